@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Why writing to a directory was denied, each the key of the message
+// telling the player what to do about it.
+const (
+	// accessControlledFolder is Windows' ransomware protection, controlled
+	// folder access, blocking apps it doesn't know from writing below the
+	// folders it guards, e.g. a .minecraft moved into Documents.
+	accessControlledFolder = "access.controlledfolder"
+	// accessElevation is a folder only administrators may change, e.g.
+	// Program Files.
+	accessElevation = "access.elevation"
+	// accessOwner is the permissions of the directory itself, e.g. one
+	// created by another account or by a run as root.
+	accessOwner = "access.owner"
+)
+
+// AccessFacts is what is known about the environment of a directory
+// writing to was denied, for ClassifyAccessError.
+type AccessFacts struct {
+	// ProtectedFolders are the folders controlled folder access guards,
+	// Documents, Pictures and the like. Empty outside Windows.
+	ProtectedFolders []string
+	// SystemFolders are the folders only administrators may change.
+	SystemFolders []string
+	// Elevated is set when the updater runs as administrator or root.
+	Elevated bool
+	// Owner is the account owning the directory, empty when unknown.
+	Owner string
+}
+
+// ClassifyAccessError returns the message key explaining why err denied
+// writing to dir, "" when err isn't about permissions.
+func ClassifyAccessError(err error, dir string, facts AccessFacts) string {
+	if !errors.Is(err, os.ErrPermission) {
+		return ""
+	}
+	switch {
+	case containingFolder(dir, facts.ProtectedFolders) != "":
+		return accessControlledFolder
+	case !facts.Elevated && containingFolder(dir, facts.SystemFolders) != "":
+		return accessElevation
+	}
+	return accessOwner
+}
+
+// containingFolder returns the one of folders dir is in, or is, "" for
+// none. Folders are compared ignoring case, as the filesystems holding them
+// do.
+func containingFolder(dir string, folders []string) string {
+	dir = strings.ToLower(filepath.Clean(dir))
+	for _, folder := range folders {
+		if folder == "" {
+			continue
+		}
+		clean := strings.ToLower(filepath.Clean(folder))
+		if dir == clean || strings.HasPrefix(dir, strings.TrimSuffix(clean, string(filepath.Separator))+string(filepath.Separator)) {
+			return folder
+		}
+	}
+	return ""
+}
+
+// deniedDir returns the directory err was denied writing to, false when
+// err isn't a permission error naming a path.
+func deniedDir(err error) (string, bool) {
+	if !errors.Is(err, os.ErrPermission) {
+		return "", false
+	}
+	var p string
+	var pathErr *os.PathError
+	var linkErr *os.LinkError
+	switch {
+	case errors.As(err, &pathErr):
+		p = pathErr.Path
+	case errors.As(err, &linkErr):
+		p = linkErr.New
+	default:
+		return "", false
+	}
+	if info, err := os.Stat(p); err == nil && info.IsDir() {
+		return p, true
+	}
+	return filepath.Dir(p), true
+}
+
+// SystemAccessFacts finds out what ClassifyAccessError needs to know about
+// dir on this system.
+func SystemAccessFacts(dir string) AccessFacts {
+	return AccessFacts{
+		ProtectedFolders: protectedFolders(),
+		SystemFolders:    systemFolders(),
+		Elevated:         isElevated(),
+		Owner:            fileOwner(dir),
+	}
+}
+
+// ExplainAccessError tells the player what to do about err when it is
+// writing to a directory being denied, rather than leaving them with
+// "access denied". It reports whether it did.
+func ExplainAccessError(err error) bool {
+	dir, ok := deniedDir(err)
+	if !ok {
+		return false
+	}
+	facts := SystemAccessFacts(dir)
+	key := ClassifyAccessError(err, dir, facts)
+	Logf("access denied to %s: %s, %+v", dir, key, facts)
+	switch key {
+	case accessControlledFolder:
+		executable, _ := os.Executable()
+		fmt.Println(T(key, dir, containingFolder(dir, facts.ProtectedFolders), executable))
+	case accessElevation:
+		fmt.Println(T(key, dir, containingFolder(dir, facts.SystemFolders)))
+	case accessOwner:
+		if facts.Owner == "" {
+			fmt.Println(T("access.denied", dir))
+		} else {
+			fmt.Println(T(key, dir, facts.Owner))
+		}
+	default:
+		return false
+	}
+	return true
+}
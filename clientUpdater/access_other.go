@@ -0,0 +1,39 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// protectedFolders returns the folders guarded against unknown apps
+// writing, which only Windows has.
+func protectedFolders() []string { return nil }
+
+// systemFolders returns the folders only root may change. Anywhere a
+// minecraft directory plausibly is belongs to its player, so none.
+func systemFolders() []string { return nil }
+
+// isElevated reports whether the updater runs as root.
+func isElevated() bool { return os.Geteuid() == 0 }
+
+// fileOwner returns the name of the account owning p, its uid when it has
+// no name, and "" when p can't be looked at.
+func fileOwner(p string) string {
+	info, err := os.Stat(p)
+	if err != nil {
+		return ""
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	uid := strconv.FormatUint(uint64(stat.Uid), 10)
+	if account, err := user.LookupId(uid); err == nil {
+		return account.Username
+	}
+	return uid
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClassifyAccessError(t *testing.T) {
+	home := filepath.FromSlash("/Users/steve")
+	facts := AccessFacts{
+		ProtectedFolders: []string{filepath.Join(home, "OneDrive", "Documents"), filepath.Join(home, "Desktop"), ""},
+		SystemFolders:    []string{filepath.FromSlash("/Program Files/"), filepath.FromSlash("/Windows")},
+	}
+	denied := func(dir string) error {
+		return &os.PathError{Op: "open", Path: filepath.Join(dir, "sodium.jar"), Err: os.ErrPermission}
+	}
+	tests := []struct {
+		name     string
+		dir      string
+		err      error
+		elevated bool
+		want     string
+	}{
+		{name: "documents", dir: filepath.Join(home, "OneDrive", "Documents", "Minecraft", "mods"), want: accessControlledFolder},
+		{name: "other case", dir: filepath.Join(home, "onedrive", "DOCUMENTS", "mods"), want: accessControlledFolder},
+		{name: "the folder itself", dir: filepath.Join(home, "Desktop"), want: accessControlledFolder},
+		// a protected folder guards elevated runs as well
+		{name: "documents elevated", dir: filepath.Join(home, "Desktop", "mods"), elevated: true, want: accessControlledFolder},
+		{name: "same prefix", dir: filepath.Join(home, "Desktop2", "mods"), want: accessOwner},
+		{name: "program files", dir: filepath.FromSlash("/Program Files/Minecraft/mods"), want: accessElevation},
+		{name: "program files elevated", dir: filepath.FromSlash("/Program Files/Minecraft/mods"), elevated: true, want: accessOwner},
+		{name: "windows", dir: filepath.FromSlash("/WINDOWS/Temp/mods"), want: accessElevation},
+		{name: "elsewhere", dir: filepath.FromSlash("/games/.minecraft/mods"), want: accessOwner},
+		{name: "wrapped", dir: filepath.FromSlash("/games/.minecraft/mods"), err: fmt.Errorf("installing: %w", denied("mods")), want: accessOwner},
+		{name: "not about permissions", dir: filepath.Join(home, "Desktop", "mods"), err: os.ErrNotExist, want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.err
+			if err == nil {
+				err = denied(test.dir)
+			}
+			facts := facts
+			facts.Elevated = test.elevated
+			if got := ClassifyAccessError(err, test.dir, facts); got != test.want {
+				t.Errorf("classified %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestContainingFolder(t *testing.T) {
+	documents := filepath.FromSlash("/Users/steve/Documents")
+	folders := []string{"", filepath.FromSlash("/Program Files"), documents}
+	if got := containingFolder(filepath.FromSlash("/users/Steve/documents/./mc/mods"), folders); got != documents {
+		t.Errorf("contained in %q", got)
+	}
+	if got := containingFolder(filepath.FromSlash("/Users/steve/Documents-old/mods"), folders); got != "" {
+		t.Errorf("contained in %q", got)
+	}
+	if got := containingFolder(filepath.FromSlash("/Users/steve"), folders); got != "" {
+		t.Errorf("contained in %q", got)
+	}
+}
+
+func TestDeniedDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "sodium.jar")
+	tests := []struct {
+		name string
+		err  error
+		want string
+		ok   bool
+	}{
+		{name: "file", err: &os.PathError{Op: "open", Path: file, Err: os.ErrPermission}, want: dir, ok: true},
+		{name: "directory", err: &os.PathError{Op: "mkdir", Path: dir, Err: os.ErrPermission}, want: dir, ok: true},
+		{name: "rename", err: &os.LinkError{Op: "rename", Old: filepath.Join(t.TempDir(), "staged.jar"), New: file, Err: os.ErrPermission}, want: dir, ok: true},
+		{name: "wrapped", err: fmt.Errorf("installing: %w", &os.PathError{Op: "open", Path: file, Err: os.ErrPermission}), want: dir, ok: true},
+		{name: "no path", err: os.ErrPermission},
+		{name: "other error", err: &os.PathError{Op: "open", Path: file, Err: os.ErrNotExist}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := deniedDir(test.err)
+			if got != test.want || ok != test.ok {
+				t.Errorf("denied %q, %t", got, ok)
+			}
+		})
+	}
+}
+
+func TestExplainAccessError(t *testing.T) {
+	dir := t.TempDir()
+	var explained bool
+	output := captureStdout(t, func() {
+		explained = ExplainAccessError(&os.PathError{Op: "open", Path: filepath.Join(dir, "sodium.jar"), Err: os.ErrPermission})
+	})
+	if !explained {
+		t.Fatalf("not explained, output:\n%s", output)
+	}
+	// the temporary directory is outside any protected or system folder,
+	// so it is down to its owner, the account running the tests
+	want := "You may not change files in " + dir + "."
+	if owner := fileOwner(dir); owner != "" {
+		want = "You may not change files in " + dir + ", it belongs to the account " + owner + "."
+		if current, err := user.Current(); err == nil && !strings.HasSuffix(current.Username, owner) {
+			t.Errorf("owner %q, running as %q", owner, current.Username)
+		}
+	}
+	if !strings.HasPrefix(output, want) {
+		t.Errorf("output %q, want %q", output, want)
+	}
+
+	output = captureStdout(t, func() {
+		explained = ExplainAccessError(errors.New("access denied"))
+	})
+	if explained || output != "" {
+		t.Errorf("explained %t: %q", explained, output)
+	}
+}
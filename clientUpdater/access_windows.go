@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// knownFolderIDs are the known folders controlled folder access guards by
+// default, as KNOWNFOLDERIDs from knownfolders.h: Documents, Pictures,
+// Videos, Music, Desktop, Favorites and the public Documents. They are
+// looked up rather than guessed, Documents is often moved to OneDrive.
+var knownFolderIDs = []syscall.GUID{
+	{Data1: 0xfdd39ad0, Data2: 0x238f, Data3: 0x46af, Data4: [8]byte{0xad, 0xb4, 0x6c, 0x85, 0x48, 0x03, 0x69, 0xc7}},
+	{Data1: 0x33e28130, Data2: 0x4e1e, Data3: 0x4676, Data4: [8]byte{0x83, 0x5a, 0x98, 0x39, 0x5c, 0x3b, 0xc3, 0xbb}},
+	{Data1: 0x18989b1d, Data2: 0x99b5, Data3: 0x455b, Data4: [8]byte{0x84, 0x1c, 0xab, 0x7c, 0x74, 0xe4, 0xdd, 0xfc}},
+	{Data1: 0x4bd8d571, Data2: 0x6d19, Data3: 0x48d3, Data4: [8]byte{0xbe, 0x97, 0x42, 0x22, 0x20, 0x08, 0x0e, 0x43}},
+	{Data1: 0xb4bfcc3a, Data2: 0xdb2c, Data3: 0x424c, Data4: [8]byte{0xb0, 0x29, 0x7f, 0xe9, 0x9a, 0x87, 0xc6, 0x41}},
+	{Data1: 0x1777f761, Data2: 0x68ad, Data3: 0x4d8a, Data4: [8]byte{0x87, 0xbd, 0x30, 0xb7, 0x59, 0xfa, 0x33, 0xdd}},
+	{Data1: 0xed4824af, Data2: 0xdce4, Data3: 0x45a8, Data4: [8]byte{0x81, 0xe2, 0xfc, 0x79, 0x65, 0x08, 0x36, 0x34}},
+}
+
+// protectedFolders returns the folders controlled folder access guards by
+// default, whether or not it is turned on: finding out needs rights the
+// updater doesn't have, and a denied write below one of them is almost
+// always it.
+func protectedFolders() []string {
+	shell32 := syscall.NewLazyDLL("shell32.dll")
+	getKnownFolderPath := shell32.NewProc("SHGetKnownFolderPath")
+	coTaskMemFree := syscall.NewLazyDLL("ole32.dll").NewProc("CoTaskMemFree")
+	if err := getKnownFolderPath.Find(); err != nil {
+		return nil
+	}
+	var folders []string
+	for i := range knownFolderIDs {
+		var p *uint16
+		if hr, _, _ := getKnownFolderPath.Call(uintptr(unsafe.Pointer(&knownFolderIDs[i])), 0, 0, uintptr(unsafe.Pointer(&p))); hr != 0 {
+			continue
+		}
+		folders = append(folders, utf16PtrToString(p))
+		coTaskMemFree.Call(uintptr(unsafe.Pointer(p)))
+	}
+	return folders
+}
+
+// utf16PtrToString reads the NUL terminated string at p.
+func utf16PtrToString(p *uint16) string {
+	var chars []uint16
+	for ptr := unsafe.Pointer(p); *(*uint16)(ptr) != 0; ptr = unsafe.Add(ptr, 2) {
+		chars = append(chars, *(*uint16)(ptr))
+	}
+	return syscall.UTF16ToString(chars)
+}
+
+// systemFolders returns the folders only administrators may change.
+func systemFolders() []string {
+	var folders []string
+	for _, name := range []string{"ProgramFiles", "ProgramFiles(x86)", "ProgramW6432", "SystemRoot"} {
+		if folder := os.Getenv(name); folder != "" {
+			folders = append(folders, folder)
+		}
+	}
+	return folders
+}
+
+// tokenElevation is TokenElevation of TOKEN_INFORMATION_CLASS.
+const tokenElevation = 20
+
+// isElevated reports whether the updater runs as administrator.
+func isElevated() bool {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return false
+	}
+	var token syscall.Token
+	if err := syscall.OpenProcessToken(process, syscall.TOKEN_QUERY, &token); err != nil {
+		return false
+	}
+	defer token.Close()
+	var elevated, size uint32
+	if err := syscall.GetTokenInformation(token, tokenElevation, (*byte)(unsafe.Pointer(&elevated)), uint32(unsafe.Sizeof(elevated)), &size); err != nil {
+		return false
+	}
+	return elevated != 0
+}
+
+// Arguments of GetNamedSecurityInfoW, from accctrl.h and winnt.h.
+const (
+	seFileObject             = 1
+	ownerSecurityInformation = 1
+)
+
+// fileOwner returns the account owning p as DOMAIN\name, "" when it can't
+// be told.
+func fileOwner(p string) string {
+	advapi32 := syscall.NewLazyDLL("advapi32.dll")
+	getNamedSecurityInfo := advapi32.NewProc("GetNamedSecurityInfoW")
+	localFree := syscall.NewLazyDLL("kernel32.dll").NewProc("LocalFree")
+	if err := getNamedSecurityInfo.Find(); err != nil {
+		return ""
+	}
+	name, err := syscall.UTF16PtrFromString(p)
+	if err != nil {
+		return ""
+	}
+	var owner *syscall.SID
+	var descriptor uintptr
+	if ret, _, _ := getNamedSecurityInfo.Call(uintptr(unsafe.Pointer(name)), seFileObject, ownerSecurityInformation,
+		uintptr(unsafe.Pointer(&owner)), 0, 0, 0, uintptr(unsafe.Pointer(&descriptor))); ret != 0 {
+		return ""
+	}
+	defer localFree.Call(descriptor)
+	account, domain, _, err := owner.LookupAccount("")
+	if err != nil {
+		if sid, err := owner.String(); err == nil {
+			return sid
+		}
+		return ""
+	}
+	if domain == "" {
+		return account
+	}
+	return domain + `\` + account
+}
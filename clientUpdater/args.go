@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// updaterModes are the subcommands taken as the first argument.
+var updaterModes = map[string]bool{
+	"history":  true,
+	"restore":  true,
+	"diff":     true,
+	"export":   true,
+	"import":   true,
+	"repair":   true,
+	"verify":   true,
+	"diagnose": true,
+	"migrate":  true,
+	// plan saves an update for review, apply carries it out
+	"plan":  true,
+	"apply": true,
+	// prelaunch runs as a launcher's pre-launch command
+	"prelaunch": true,
+	// rollback undoes the last update, settings changes the config
+	"rollback": true,
+	"settings": true,
+	// owned lists the files the updater manages, why tells where one of
+	// them came from
+	"owned": true,
+	"why":   true,
+}
+
+// isPackArchiveArg reports whether arg names an existing zip file, which is
+// what Windows passes when an archive is dropped onto the executable.
+func isPackArchiveArg(arg string) bool {
+	if !strings.EqualFold(filepath.Ext(arg), ".zip") {
+		return false
+	}
+	info, err := os.Stat(arg)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// printUsage lists the subcommands and flags.
+func printUsage() {
+	fmt.Fprintln(flag.CommandLine.Output(), T("usage", filepath.Base(os.Args[0])))
+	flag.PrintDefaults()
+}
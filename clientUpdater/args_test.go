@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIsPackArchiveArg(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"pack.zip": "PK", "PACK.ZIP": "PK", "pack.jar": "PK", "folder.zip/x": ""})
+	tests := map[string]bool{
+		filepath.Join(dir, "pack.zip"):    true,
+		filepath.Join(dir, "PACK.ZIP"):    true,
+		filepath.Join(dir, "pack.jar"):    false,
+		filepath.Join(dir, "folder.zip"):  false,
+		filepath.Join(dir, "missing.zip"): false,
+		"history":                         false,
+	}
+	for arg, want := range tests {
+		if got := isPackArchiveArg(arg); got != want {
+			t.Errorf("isPackArchiveArg(%s) = %t", arg, got)
+		}
+	}
+}
+
+// TestUpdateFromDroppedArchive runs the updater with an archive as its
+// only argument, as Windows starts it when the archive is dropped onto the
+// executable: it installs from the archive without going online.
+func TestUpdateFromDroppedArchive(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 2"})
+	var requests int32
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.NotFound(w, r)
+	}))
+	dropped := filepath.Join(t.TempDir(), "rxmc-Mods-master.zip")
+	writeZip(t, dropped, map[string]string{"rxmc-Mods-master/mods/sodium.jar": "sodium 3", "rxmc-Mods-master/mods/iris.jar": "iris"})
+
+	output := readFile(t, u.run(t, dropped))
+	if got := dirNames(t, u.mods); got != "iris.jar sodium.jar" || readFile(t, filepath.Join(u.mods, "sodium.jar")) != "sodium 3" {
+		t.Errorf("installed %s, output:\n%s", got, output)
+	}
+	if !strings.Contains(output, dropped) {
+		t.Errorf("the archive isn't told, output:\n%s", output)
+	}
+	if _, err := os.Stat(dropped); err != nil {
+		t.Errorf("the dropped archive was removed: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Errorf("%d requests installing from a dropped archive", n)
+	}
+}
+
+func TestUnknownArguments(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 2"})
+	useRunState(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"pack.zip": "PK", "notes.txt": ""})
+	for _, args := range [][]string{
+		{"upgrade"},
+		{filepath.Join(dir, "notes.txt")},
+		{filepath.Join(dir, "missing.zip")},
+		// one archive at a time
+		{filepath.Join(dir, "pack.zip"), filepath.Join(dir, "pack.zip")},
+	} {
+		var output string
+		code := exitsWith(func() { output = u.run(t, args...) })
+		if code != 2 {
+			t.Errorf("%q exited with %d", args, code)
+		}
+		if output != "" {
+			t.Errorf("%q ran", args)
+		}
+	}
+	if got := dirNames(t, u.mods); got != "" {
+		t.Errorf("installed %s", got)
+	}
+}
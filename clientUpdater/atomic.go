@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// partialSuffix marks files that are still being written.
+const partialSuffix = ".partial"
+
+// partialMaxAge is how long a leftover partial file is kept around.
+const partialMaxAge = 24 * time.Hour
+
+// rename is os.Rename, replaced by tests simulating moves between
+// filesystems.
+var rename = os.Rename
+
+// syncDir flushes a directory entry to disk so a rename inside it survives
+// a crash. Windows can't open directories for syncing, there renames are
+// durable once MoveFileEx returns.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// writeSynced writes content to p and syncs both the file and its
+// directory before returning, for markers that must survive a crash
+// right after.
+func writeSynced(p string, content []byte) error {
+	file, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, filePerm)
+	if err != nil {
+		return err
+	}
+	_, err = (countingWriter{file}).Write(content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(p)
+		return spaceError(p, int64(len(content)), err)
+	}
+	syncDir(filepath.Dir(p))
+	return nil
+}
+
+// writeReplacing writes content next to p and moves it over p once it is
+// complete, so a write failing halfway, e.g. on a full disk, leaves p as
+// it was.
+func writeReplacing(p string, content []byte) error {
+	tmp := p + partialSuffix
+	if err := writeSynced(tmp, content); err != nil {
+		var full *noSpaceError
+		if errors.As(err, &full) {
+			full.path = p
+		}
+		return err
+	}
+	if err := renameSynced(tmp, p); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// renameSynced moves src to dst atomically and syncs the directory. When
+// both live on different filesystems it copies to a temporary file next to
+// dst first, so dst still only ever appears complete.
+func renameSynced(src string, dst string) error {
+	err := rename(src, dst)
+	if err != nil && errors.Is(err, syscall.EXDEV) {
+		tmp := dst + partialSuffix
+		if err = copyFile(src, tmp); err == nil {
+			if err = os.Rename(tmp, dst); err == nil {
+				os.Remove(src)
+			}
+		}
+		os.Remove(tmp)
+	}
+	if err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(dst))
+	return nil
+}
+
+// CleanStalePartials removes partial downloads in dir older than a day,
+// left behind by runs that crashed or lost power.
+func CleanStalePartials(dir string) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), partialSuffix) || clock.Now().Sub(entry.ModTime()) < partialMaxAge {
+			continue
+		}
+		p := filepath.Join(dir, entry.Name())
+		if err := os.Remove(p); err == nil {
+			clearResumeState(p)
+			Logf("removed stale partial download %s", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestDownloadInterrupted cuts a download off halfway: the partial file
+// keeps what arrived, the final name never appears.
+func TestDownloadInterrupted(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 1000))
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content[:4000])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	dest := filepath.Join(t.TempDir(), "pack.zip")
+	if _, err := downloadFile(dest, "https://example.com/pack.zip", ""); err == nil {
+		t.Fatal("downloaded from a dropped connection")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("pack.zip appeared: %v", err)
+	}
+	if got := readFile(t, dest+partialSuffix); got != string(content[:4000]) {
+		t.Errorf("kept %d bytes to resume from", len(got))
+	}
+}
+
+func TestDownloadIncomplete(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 1000))
+	tests := map[string]struct {
+		handle func(w http.ResponseWriter, r *http.Request)
+		sha256 string
+		// err is the type of error wanted.
+		err string
+	}{
+		"checksum mismatch": {
+			handle: func(w http.ResponseWriter, r *http.Request) { w.Write(content) },
+			sha256: sha256Hex([]byte("something else")),
+			err:    "*main.checksumError",
+		},
+		"not found": {
+			handle: http.NotFound,
+			err:    "*main.statusError",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			useTestServer(t, http.HandlerFunc(test.handle))
+			dest := filepath.Join(t.TempDir(), "pack.zip")
+			_, err := downloadFile(dest, "https://example.com/pack.zip", test.sha256)
+			if got := fmt.Sprintf("%T", err); got != test.err {
+				t.Fatalf("error %v of type %s", err, got)
+			}
+			for _, p := range []string{dest, dest + partialSuffix} {
+				if _, err := os.Stat(p); !os.IsNotExist(err) {
+					t.Errorf("%s is left: %v", filepath.Base(p), err)
+				}
+			}
+		})
+	}
+}
+
+// useRename replaces the rename of renameSynced for the test.
+func useRename(t *testing.T, f func(src string, dst string) error) {
+	t.Helper()
+	saved := rename
+	rename = f
+	t.Cleanup(func() { rename = saved })
+}
+
+func TestRenameSynced(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"pack.zip.partial": "new", "pack.zip": "old"})
+	if err := renameSynced(filepath.Join(dir, "pack.zip.partial"), filepath.Join(dir, "pack.zip")); err != nil {
+		t.Fatal(err)
+	}
+	if got := dirNames(t, dir); got != "pack.zip" || readFile(t, filepath.Join(dir, "pack.zip")) != "new" {
+		t.Errorf("left %s", got)
+	}
+}
+
+// TestRenameSyncedAcrossFilesystems moves a download from a cache on
+// another filesystem: it is copied next to the destination, then renamed.
+func TestRenameSyncedAcrossFilesystems(t *testing.T) {
+	var renamed []string
+	useRename(t, func(src string, dst string) error {
+		renamed = append(renamed, filepath.Base(src))
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: syscall.EXDEV}
+	})
+	cache, dest := t.TempDir(), t.TempDir()
+	writeFiles(t, cache, map[string]string{"pack.zip.partial": "new"})
+	writeFiles(t, dest, map[string]string{"pack.zip": "old"})
+	if err := renameSynced(filepath.Join(cache, "pack.zip.partial"), filepath.Join(dest, "pack.zip")); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(renamed, " ") != "pack.zip.partial" {
+		t.Errorf("renamed %v", renamed)
+	}
+	if got := dirNames(t, dest); got != "pack.zip" || readFile(t, filepath.Join(dest, "pack.zip")) != "new" {
+		t.Errorf("destination holds %s", got)
+	}
+	if got := dirNames(t, cache); got != "" {
+		t.Errorf("cache holds %s", got)
+	}
+}
+
+func TestRenameSyncedFails(t *testing.T) {
+	refused := &os.LinkError{Op: "rename", Err: syscall.EACCES}
+	useRename(t, func(src string, dst string) error { return refused })
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"pack.zip.partial": "new"})
+	if err := renameSynced(filepath.Join(dir, "pack.zip.partial"), filepath.Join(dir, "pack.zip")); err != refused {
+		t.Errorf("error %v", err)
+	}
+	if got := dirNames(t, dir); got != "pack.zip.partial" {
+		t.Errorf("left %s", got)
+	}
+}
+
+// TestWriteReplacingFails keeps the file as it was when the new content
+// can't be written.
+func TestWriteReplacingFails(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "clientUpdate.json")
+	if err := writeReplacing(p, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(p+partialSuffix, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeReplacing(p, []byte("v2")); err == nil {
+		t.Error("wrote over a directory")
+	}
+	if got := readFile(t, p); got != "v1" {
+		t.Errorf("%s is now %q", filepath.Base(p), got)
+	}
+}
+
+func TestCleanStalePartials(t *testing.T) {
+	fake := useFakeClock(t)
+	dir := t.TempDir()
+	ages := map[string]time.Duration{
+		"old.zip.partial":    partialMaxAge + time.Minute,
+		"recent.zip.partial": partialMaxAge - time.Minute,
+		"old.zip":            2 * partialMaxAge,
+	}
+	for name, age := range ages {
+		writeFiles(t, dir, map[string]string{name: name})
+		if err := os.Chtimes(filepath.Join(dir, name), fake.Now().Add(-age), fake.Now().Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "dir.partial"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Time{}.Add(time.Hour)
+	os.Chtimes(filepath.Join(dir, "dir.partial"), old, old)
+
+	CleanStalePartials(dir)
+	if got := dirNames(t, dir); got != "dir.partial old.zip recent.zip.partial" {
+		t.Errorf("left %s", got)
+	}
+	CleanStalePartials(filepath.Join(dir, "missing"))
+}
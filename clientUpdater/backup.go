@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupsDirName is the directory every run's backups are kept in, next to
+// the updater or, for mods directories on another volume, on that volume.
+const backupsDirName = "clientUpdate-backups"
+
+// backupManifestName is the manifest of a backup, written into it once the
+// files moved there were verified.
+const backupManifestName = "clientUpdate-backup.json"
+
+// backupIncompleteName marks a backup still being made, removed once its
+// manifest is written. A backup still marked was cut short: only the
+// rollback of the run that made it uses it, and it is cleaned up after.
+const backupIncompleteName = "clientUpdate-backup.incomplete"
+
+// Outcomes of verifying a backup.
+const (
+	backupComplete = "complete"
+	backupFailed   = "failed"
+)
+
+// BackupManifest lists the files of a backup with what they hashed to
+// before they were moved there. A backup is only complete once every file
+// was read back from it with that hash; rollbacks rely on nothing else.
+type BackupManifest struct {
+	Run     string       `json:"run"`
+	Created time.Time    `json:"created"`
+	Status  string       `json:"status"`
+	Files   []BackupFile `json:"files"`
+	// Size is the total size of the files.
+	Size int64 `json:"size"`
+	// Failed are the files that didn't read back with their hash.
+	Failed []string `json:"failed,omitempty"`
+
+	// dir is the backup the manifest was read from.
+	dir string
+}
+
+// BackupFile is a file of a backup, its path relative to the backup.
+type BackupFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// has reports whether the manifest lists rel, and whether it failed.
+func (m *BackupManifest) has(rel string) (listed bool, failed bool) {
+	for _, file := range m.Failed {
+		if file == rel {
+			return true, true
+		}
+	}
+	for _, file := range m.Files {
+		if file.Path == rel {
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// WriteBackupManifest reads every file moved into dir back, compares it
+// with the hash it had, and records the outcome in the manifest of dir,
+// adding to what an earlier call recorded there.
+func WriteBackupManifest(dir string, run string, files []BackupFile) (*BackupManifest, error) {
+	manifest, err := readBackupManifest(dir)
+	if err != nil {
+		Logf("backup: replacing unreadable manifest of %s: %s", dir, err)
+	}
+	if manifest == nil {
+		manifest = &BackupManifest{Run: run, Created: clock.Now(), Status: backupComplete, dir: dir}
+	}
+	for _, file := range files {
+		sum, err := fileSHA256(filepath.Join(dir, file.Path))
+		if err != nil || !strings.EqualFold(sum, file.SHA256) {
+			Logf("backup: %s doesn't read back from %s: %v", file.Path, dir, err)
+			manifest.Failed = append(manifest.Failed, file.Path)
+			manifest.Status = backupFailed
+			continue
+		}
+		manifest.Files = append(manifest.Files, file)
+		manifest.Size += file.Size
+	}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeSynced(filepath.Join(dir, backupManifestName), content); err != nil {
+		return nil, err
+	}
+	Logf("backup: %s is %s, %d files, %d bytes", dir, manifest.Status, len(manifest.Files), manifest.Size)
+	return manifest, nil
+}
+
+// readBackupManifest reads the manifest of the backup dir, nil when it has
+// none.
+func readBackupManifest(dir string) (*BackupManifest, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, backupManifestName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifest BackupManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, err
+	}
+	manifest.dir = dir
+	return &manifest, nil
+}
+
+// manifestOf finds the manifest of the backup p is in, looking up to the
+// backups directory, and returns it with the path of p relative to it.
+func manifestOf(p string) (*BackupManifest, string) {
+	for dir := filepath.Dir(p); ; dir = filepath.Dir(dir) {
+		if manifest, err := readBackupManifest(dir); manifest != nil && err == nil {
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return nil, ""
+			}
+			return manifest, rel
+		}
+		if filepath.Base(dir) == backupsDirName || filepath.Dir(dir) == dir {
+			return nil, ""
+		}
+	}
+}
+
+// incompleteBackup is the content of the marker of a backup being made.
+type incompleteBackup struct {
+	Run     string    `json:"run"`
+	Started time.Time `json:"started"`
+}
+
+// markBackupIncomplete writes the marker to dir before anything is moved
+// there.
+func markBackupIncomplete(dir string, run string) error {
+	content, err := json.Marshal(incompleteBackup{Run: run, Started: clock.Now()})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return err
+	}
+	return writeSynced(filepath.Join(dir, backupIncompleteName), content)
+}
+
+// markBackupComplete removes the marker of dir once its manifest is
+// written.
+func markBackupComplete(dir string) error {
+	if err := os.Remove(filepath.Join(dir, backupIncompleteName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// backupIncomplete reports whether the backup dir is marked incomplete.
+func backupIncomplete(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, backupIncompleteName))
+	return err == nil
+}
+
+// inIncompleteBackup reports whether p is in a backup marked incomplete,
+// looking up to the backups directory.
+func inIncompleteBackup(p string) bool {
+	for dir := filepath.Dir(p); ; dir = filepath.Dir(dir) {
+		if backupIncomplete(dir) {
+			return true
+		}
+		if filepath.Base(dir) == backupsDirName || filepath.Dir(dir) == dir {
+			return false
+		}
+	}
+}
+
+// backupVerified reports whether dir is a complete backup whose files all
+// read back with their hash.
+func backupVerified(dir string) bool {
+	manifest, _ := readBackupManifest(dir)
+	return manifest != nil && manifest.Status == backupComplete && !backupIncomplete(dir)
+}
+
+// discardIncompleteBackup removes the backup dir when it is marked
+// incomplete, once the rollback of the run making it put its files back.
+func discardIncompleteBackup(dir string) {
+	if !backupIncomplete(dir) {
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		Logf("backup: removing the incomplete backup %s: %s", dir, err)
+		return
+	}
+	Logf("backup: removed the incomplete backup %s", dir)
+}
+
+// CleanIncompleteBackups removes the backups below root that runs stopped
+// or dead while making them left marked incomplete. A backup a recovery
+// marker in recoveryDir still names stays, the recovery puts its files
+// back. It returns how many were removed.
+func CleanIncompleteBackups(root string, recoveryDir string) (removed int) {
+	pending := map[string]bool{}
+	for _, marker := range ReadRecoveryMarkers(recoveryDir) {
+		pending[filepath.Clean(marker.BackupDir)] = true
+	}
+	var dirs []string
+	filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && info.Name() == backupIncompleteName && !pending[filepath.Dir(p)] {
+			dirs = append(dirs, filepath.Dir(p))
+		}
+		return nil
+	})
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			Logf("backup: removing the incomplete backup %s: %s", dir, err)
+			continue
+		}
+		Logf("backup: removed the incomplete backup %s", dir)
+		removed++
+	}
+	return removed
+}
+
+// backupsRootOf returns the backups directory p is in, "" if it isn't.
+func backupsRootOf(p string) string {
+	for dir := filepath.Dir(p); filepath.Dir(dir) != dir; dir = filepath.Dir(dir) {
+		if filepath.Base(dir) == backupsDirName {
+			return dir
+		}
+	}
+	return ""
+}
+
+// checkBackup tells why the backup of entry can't be trusted, nil when it
+// can. A file a backup failed to verify is never trusted. A backup without
+// a manifest is of a run that died while making it, or of an older updater;
+// its files are trusted when they still have the hash the journal recorded.
+func checkBackup(entry JournalEntry) error {
+	if manifest, rel := manifestOf(entry.Backup); manifest != nil {
+		if _, failed := manifest.has(rel); failed {
+			return fmt.Errorf("failed verification when it was made")
+		}
+	}
+	sum, err := fileSHA256(entry.Backup)
+	if err != nil {
+		return fmt.Errorf("is unavailable: %s", err)
+	}
+	if !strings.EqualFold(sum, entry.SHA256) {
+		return fmt.Errorf("has been modified")
+	}
+	return nil
+}
+
+// VerifiedBackups returns the manifests of the complete backups below
+// root, newest first.
+func VerifiedBackups(root string) []*BackupManifest {
+	var manifests []*BackupManifest
+	filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.Name() != backupManifestName {
+			return nil
+		}
+		manifest, err := readBackupManifest(filepath.Dir(p))
+		if err != nil {
+			Logf("backup: unreadable manifest %s: %s", p, err)
+			return nil
+		}
+		if manifest.Status == backupComplete && !backupIncomplete(manifest.dir) {
+			manifests = append(manifests, manifest)
+		}
+		return nil
+	})
+	sort.SliceStable(manifests, func(i, j int) bool { return manifests[i].Created.After(manifests[j].Created) })
+	return manifests
+}
+
+// backupSource returns the file to put entry back from: its own backup
+// when that can be trusted, otherwise the newest verified backup of the
+// same file from another run. fallback is set for the latter, which must
+// be copied rather than moved to stay intact.
+func backupSource(entry JournalEntry) (src string, fallback bool, err error) {
+	reason := checkBackup(entry)
+	if reason == nil {
+		return entry.Backup, false, nil
+	}
+	name := filepath.Base(entry.Path)
+	Logf("backup: %s of %s %s", entry.Backup, name, reason)
+	if root := backupsRootOf(entry.Backup); root != "" {
+		for _, manifest := range VerifiedBackups(root) {
+			for _, file := range manifest.Files {
+				if !strings.EqualFold(file.SHA256, entry.SHA256) {
+					continue
+				}
+				p := filepath.Join(manifest.dir, file.Path)
+				if sum, err := fileSHA256(p); err != nil || !strings.EqualFold(sum, entry.SHA256) {
+					continue
+				}
+				Logf("backup: using %s of run %s instead", p, manifest.Run)
+				fmt.Println(T("backup.fallback", name, entry.Run, manifest.Run))
+				return p, true, nil
+			}
+		}
+	}
+	return "", false, fmt.Errorf("backup of %s from run %s %s, and no verified backup has the same file", name, entry.Run, reason)
+}
+
+// PruneBackups removes the run backups in root beyond the keep newest.
+// The newest verified backup is kept however old it is, it is what a
+// rollback falls back on. The low-write backups are left alone, they are
+// replaced by every update anyway.
+func PruneBackups(root string, keep int) (removed int, freed int64) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return 0, 0
+	}
+	var runs []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), lowWriteBackups) {
+			runs = append(runs, entry.Name())
+		}
+	}
+	// run IDs sort by the time of the run
+	sort.Sort(sort.Reverse(sort.StringSlice(runs)))
+	verified := ""
+	for _, run := range runs {
+		if backupVerified(filepath.Join(root, run)) {
+			verified = run
+			break
+		}
+	}
+	for i, run := range runs {
+		if i < keep || run == verified {
+			continue
+		}
+		dir := filepath.Join(root, run)
+		size := dirSize(dir)
+		if err := os.RemoveAll(dir); err != nil {
+			Logf("backup: removing %s: %s", dir, err)
+			continue
+		}
+		Logf("backup: removed %s, %d bytes", dir, size)
+		removed++
+		freed += size
+	}
+	return removed, freed
+}
+
+// dirSize returns the total size of the files below dir.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// RotateLowWriteBackup sets the single backup of low-write mode in dir
+// aside before an update replaces it, keeping whichever of it and the one
+// set aside before is verified.
+func RotateLowWriteBackup(dir string) {
+	previous := dir + ".previous"
+	if !backupVerified(dir) {
+		if _, err := os.Stat(previous); err == nil {
+			os.RemoveAll(dir)
+			return
+		}
+	}
+	os.RemoveAll(previous)
+	if err := rename(dir, previous); err != nil && !os.IsNotExist(err) {
+		Logf("backup: setting %s aside: %s", dir, err)
+		os.RemoveAll(dir)
+	}
+}
+
+// DropPreviousLowWriteBackup removes the backup RotateLowWriteBackup set
+// aside once the one replacing it in dir is verified.
+func DropPreviousLowWriteBackup(dir string) {
+	if backupVerified(dir) {
+		os.RemoveAll(dir + ".previous")
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backupFileList lists files, by path, as moved into a backup.
+func backupFileList(t *testing.T, files map[string]string) []BackupFile {
+	t.Helper()
+	var list []BackupFile
+	for name, content := range files {
+		list = append(list, BackupFile{Path: name, SHA256: sha256Hex([]byte(content)), Size: int64(len(content))})
+	}
+	return list
+}
+
+func TestWriteBackupManifest(t *testing.T) {
+	useFakeClock(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"sodium.jar": "sodium", "iris.jar": "iri"})
+	// iris.jar was cut short moving it
+	manifest, err := WriteBackupManifest(dir, "run", backupFileList(t, map[string]string{"sodium.jar": "sodium", "iris.jar": "iris"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Status != backupFailed || strings.Join(manifest.Failed, " ") != "iris.jar" || len(manifest.Files) != 1 || manifest.Size != 6 {
+		t.Errorf("manifest %+v", manifest)
+	}
+	if backupVerified(dir) {
+		t.Error("a failed backup is verified")
+	}
+	if listed, failed := manifest.has("iris.jar"); !listed || !failed {
+		t.Errorf("iris.jar listed %t, failed %t", listed, failed)
+	}
+
+	// later files add to the manifest
+	other := t.TempDir()
+	writeFiles(t, other, map[string]string{"a.jar": "a", "config/b.txt": "b"})
+	if _, err := WriteBackupManifest(other, "run", backupFileList(t, map[string]string{"a.jar": "a"})); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err = WriteBackupManifest(other, "run", backupFileList(t, map[string]string{"config/b.txt": "b"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Status != backupComplete || len(manifest.Files) != 2 || manifest.Size != 2 || !backupVerified(other) {
+		t.Errorf("manifest %+v", manifest)
+	}
+	// until the backup is marked complete, it isn't verified
+	if err := markBackupIncomplete(other, "run"); err != nil {
+		t.Fatal(err)
+	}
+	if backupVerified(other) || !inIncompleteBackup(filepath.Join(other, "config", "b.txt")) {
+		t.Error("an incomplete backup is verified")
+	}
+}
+
+// backupRoot makes a backups directory with a backup per run of files,
+// verified unless the run is in failed, or without a manifest when it is
+// in noManifest.
+func backupRoot(t *testing.T, runs map[string]map[string]string, failed map[string]bool, noManifest map[string]bool) string {
+	t.Helper()
+	useFakeClock(t)
+	root := filepath.Join(t.TempDir(), backupsDirName)
+	for run, files := range runs {
+		dir := filepath.Join(root, run)
+		writeFiles(t, dir, files)
+		if noManifest[run] {
+			continue
+		}
+		list := backupFileList(t, files)
+		if failed[run] {
+			list[0].SHA256 = sha256Hex([]byte("what it was"))
+		}
+		manifest, err := WriteBackupManifest(dir, run, list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the manifests are created in the order of the runs
+		created, _ := time.Parse("20060102-150405", run)
+		manifest.Created = created
+		writeFiles(t, dir, map[string]string{backupManifestName: mustJSON(t, manifest)})
+	}
+	return root
+}
+
+func TestBackupSource(t *testing.T) {
+	root := backupRoot(t, map[string]map[string]string{
+		"20240601-120000": {"sodium.jar": "sodium 1"},
+		"20240601-130000": {"sodium.jar": "sodium 1"},
+		"20240601-140000": {"sodium.jar": "sodium 1"},
+		"20240601-150000": {"sodium.jar": "sodium 1", "iris.jar": "iris"},
+		"20240601-160000": {"sodium.jar": "sodium 1"},
+	}, map[string]bool{"20240601-140000": true}, map[string]bool{"20240601-160000": true})
+	entry := func(run string, name string, content string) JournalEntry {
+		return JournalEntry{Run: run, Action: journalDelete, Path: filepath.Join("mods", name), SHA256: sha256Hex([]byte(content)), Backup: filepath.Join(root, run, name)}
+	}
+	// the newest verified run's copy is damaged since
+	writeFiles(t, filepath.Join(root, "20240601-150000"), map[string]string{"sodium.jar": "sodium"})
+	tests := []struct {
+		name  string
+		entry JournalEntry
+		// src is the run whose backup is used, err the start of the error.
+		src      string
+		fallback bool
+		err      string
+	}{
+		{name: "verified", entry: entry("20240601-130000", "sodium.jar", "sodium 1"), src: "20240601-130000"},
+		{name: "no manifest, same hash", entry: entry("20240601-160000", "sodium.jar", "sodium 1"), src: "20240601-160000"},
+		{name: "failed verification", entry: entry("20240601-140000", "sodium.jar", "sodium 1"), src: "20240601-130000", fallback: true},
+		{name: "modified", entry: entry("20240601-150000", "sodium.jar", "sodium 1"), src: "20240601-130000", fallback: true},
+		{name: "missing", entry: entry("20240601-120000", "lithium.jar", "lithium"), err: "backup of lithium.jar from run 20240601-120000 is unavailable"},
+		{name: "no other copy", entry: entry("20240601-150000", "iris.jar", "iris 2"), err: "backup of iris.jar from run 20240601-150000 has been modified, and no verified backup"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var src string
+			var fallback bool
+			var err error
+			output := captureStdout(t, func() { src, fallback, err = backupSource(test.entry) })
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Errorf("used %s, %v", src, err)
+				}
+				return
+			}
+			if err != nil || src != filepath.Join(root, test.src, filepath.Base(test.entry.Path)) || fallback != test.fallback {
+				t.Errorf("used %s, fallback %t, %v", src, fallback, err)
+			}
+			if want := fmt.Sprintf("the verified one from run %s is used instead", test.src); test.fallback != strings.Contains(output, want) {
+				t.Errorf("output %q", output)
+			}
+		})
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	runs := map[string]map[string]string{
+		"20240601-120000": {"sodium.jar": "1"},
+		"20240601-130000": {"sodium.jar": "2"},
+		"20240601-140000": {"sodium.jar": "3"},
+		"20240601-150000": {"sodium.jar": "4"},
+	}
+	tests := []struct {
+		name       string
+		keep       int
+		failed     map[string]bool
+		noManifest map[string]bool
+		left       string
+	}{
+		{name: "all verified", keep: 2, left: "20240601-140000 20240601-150000"},
+		// the newest verified backup stays beyond the count
+		{name: "newer unverified", keep: 2, failed: map[string]bool{"20240601-150000": true}, noManifest: map[string]bool{"20240601-140000": true}, left: "20240601-130000 20240601-140000 20240601-150000"},
+		{name: "keep none", keep: 0, failed: map[string]bool{"20240601-150000": true}, left: "20240601-140000"},
+		{name: "none verified", keep: 1, failed: map[string]bool{"20240601-120000": true, "20240601-130000": true}, noManifest: map[string]bool{"20240601-140000": true, "20240601-150000": true}, left: "20240601-150000"},
+		{name: "fewer than kept", keep: 10, left: "20240601-120000 20240601-130000 20240601-140000 20240601-150000"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := backupRoot(t, runs, test.failed, test.noManifest)
+			// low-write backups are left to the updates replacing them
+			writeFiles(t, filepath.Join(root, lowWriteBackups), map[string]string{"sodium.jar": "0"})
+			writeFiles(t, filepath.Join(root, lowWriteBackups+".previous"), map[string]string{"sodium.jar": "0"})
+			PruneBackups(root, test.keep)
+			if got := dirNames(t, root); got != test.left+" "+lowWriteBackups+" "+lowWriteBackups+".previous" {
+				t.Errorf("left %s", got)
+			}
+		})
+	}
+	if removed, freed := PruneBackups(filepath.Join(t.TempDir(), "missing"), 1); removed != 0 || freed != 0 {
+		t.Errorf("pruned %d, %d bytes of a missing directory", removed, freed)
+	}
+}
+
+func TestCleanIncompleteBackups(t *testing.T) {
+	root := filepath.Join(t.TempDir(), backupsDirName)
+	recoveryDir := t.TempDir()
+	for _, run := range []string{"20240601-120000", "20240601-130000", "20240601-140000"} {
+		writeFiles(t, filepath.Join(root, run), map[string]string{"sodium.jar": run})
+	}
+	for _, run := range []string{"20240601-130000", "20240601-140000"} {
+		if err := markBackupIncomplete(filepath.Join(root, run), run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the recovery of an interrupted run still needs its backup
+	if _, err := writeRecoveryMarker(recoveryDir, recoveryMarker{Run: "20240601-140000", ModPath: "mods", BackupDir: filepath.Join(root, "20240601-140000")}); err != nil {
+		t.Fatal(err)
+	}
+	if removed := CleanIncompleteBackups(root, recoveryDir); removed != 1 {
+		t.Errorf("removed %d", removed)
+	}
+	if got := dirNames(t, root); got != "20240601-120000 20240601-140000" {
+		t.Errorf("left %s", got)
+	}
+}
+
+func TestRotateLowWriteBackup(t *testing.T) {
+	useFakeClock(t)
+	root := t.TempDir()
+	dir := filepath.Join(root, lowWriteBackups)
+	verified := func(content string) {
+		writeFiles(t, dir, map[string]string{"sodium.jar": content})
+		if _, err := WriteBackupManifest(dir, content, backupFileList(t, map[string]string{"sodium.jar": content})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verified("1")
+	RotateLowWriteBackup(dir)
+	if got := dirNames(t, root); got != lowWriteBackups+".previous" {
+		t.Fatalf("left %s", got)
+	}
+	// the new backup failed: the verified one set aside stays
+	writeFiles(t, dir, map[string]string{"sodium.jar": "2"})
+	DropPreviousLowWriteBackup(dir)
+	RotateLowWriteBackup(dir)
+	if got := readFile(t, filepath.Join(dir+".previous", "sodium.jar")); got != "1" {
+		t.Errorf("set aside %q", got)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("the unverified backup is left: %v", err)
+	}
+	verified("3")
+	DropPreviousLowWriteBackup(dir)
+	if got := dirNames(t, root); got != lowWriteBackups {
+		t.Errorf("left %s", got)
+	}
+}
+
+// TestRollbackFallsBackToVerifiedBackup rolls an update back whose backup
+// was damaged since: the same file from the verified backup of an earlier
+// run is put back instead.
+func TestRollbackFallsBackToVerifiedBackup(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 1"})
+	writeFiles(t, u.mods, map[string]string{"sodium.jar": "sodium 1"})
+	u.run(t)
+	u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 2"})
+	u.run(t)
+	backups := filepath.Join(u.state, backupsDirName)
+	if got := dirNames(t, backups); got != "20240601-120100 20240601-120200" {
+		t.Fatalf("backups %s", got)
+	}
+	writeFiles(t, filepath.Join(backups, "20240601-120200"), map[string]string{"sodium.jar": "sodium"})
+
+	output := readFile(t, u.run(t, "rollback"))
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 1" {
+		t.Errorf("rolled back to %q, output:\n%s", got, output)
+	}
+	if !strings.Contains(output, "The backup of sodium.jar from run 20240601-120200 is damaged, the verified one from run 20240601-120100 is used instead.") {
+		t.Errorf("output:\n%s", output)
+	}
+	// the verified backup was copied, it is still intact
+	if !backupVerified(filepath.Join(backups, "20240601-120100")) || readFile(t, filepath.Join(backups, "20240601-120100", "sodium.jar")) != "sodium 1" {
+		t.Error("the verified backup changed")
+	}
+}
+
+// backupTree writes n files of a few KB to dir, in a few subfolders,
+// returning them as backup jobs and their total size.
+func backupTree(t testing.TB, dir string, n int) ([]backupJob, int64) {
+	t.Helper()
+	var jobs []backupJob
+	var total int64
+	for i := 0; i < n; i++ {
+		rel := filepath.Join(fmt.Sprintf("sub-%d", i%4), fmt.Sprintf("mod-%03d.jar", i))
+		content := strings.Repeat(fmt.Sprintf("mod %d ", i), 200+i%300)
+		writeFiles(t, dir, map[string]string{filepath.ToSlash(rel): content})
+		jobs = append(jobs, backupJob{path: filepath.Join(dir, rel), rel: rel, size: int64(len(content))})
+		total += int64(len(content))
+	}
+	return jobs, total
+}
+
+// backupModsDir returns the mods directory of a new minecraft directory.
+func backupModsDir(t *testing.T) string {
+	t.Helper()
+	minecraft := t.TempDir()
+	writeFiles(t, minecraft, map[string]string{"versions/1.20.1/1.20.1.json": "{}"})
+	return filepath.Join(minecraft, "mods")
+}
+
+// TestBackupAndRemoveProgress backs up a tree, reporting the files and
+// bytes done as they are moved.
+func TestBackupAndRemoveProgress(t *testing.T) {
+	clock := useFakeClock(t)
+	dir := backupModsDir(t)
+	_, total := backupTree(t, dir, 40)
+	backupDir := filepath.Join(t.TempDir(), backupsDirName, "20240601-120000")
+	journal := &Journal{Path: filepath.Join(t.TempDir(), "journal.jsonl"), Run: "20240601-120000"}
+
+	var reported []RunProgress
+	err := BackupAndRemove(dir, backupDir, journal, nil, nil, func(progress RunProgress) {
+		reported = append(reported, progress)
+		clock.Advance(time.Millisecond)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 41 {
+		t.Fatalf("reported %d times", len(reported))
+	}
+	if first := reported[0]; first.Files != 0 || first.FilesTotal != 40 || first.Done != 0 || first.Total != total {
+		t.Errorf("first reported %+v", first)
+	}
+	for i := 1; i < len(reported); i++ {
+		if reported[i].Files != int64(i) || reported[i].Done <= reported[i-1].Done {
+			t.Errorf("reported %+v after %+v", reported[i], reported[i-1])
+		}
+	}
+	if last := reported[40]; last.Done != total || last.Files != 40 || last.BytesPerSecond <= 0 {
+		t.Errorf("last reported %+v", last)
+	}
+
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("the mods directory is left: %v", err)
+	}
+	if !backupVerified(backupDir) {
+		t.Error("the backup isn't verified")
+	}
+	entries, err := ReadJournal(journal.Path)
+	if err != nil || len(entries) != 40 {
+		t.Fatalf("journaled %d entries: %v", len(entries), err)
+	}
+	for _, entry := range entries {
+		if entry.Action != journalDelete || !strings.HasPrefix(entry.Backup, backupDir) {
+			t.Errorf("journaled %+v", entry)
+		}
+	}
+}
+
+// TestBackupAndRemoveStopped stops a run a quarter through backing up a
+// tree: the backup stays marked incomplete and is never trusted, the
+// rollback puts every file back and the backup is removed after.
+func TestBackupAndRemoveStopped(t *testing.T) {
+	useRunState(t)
+	useFakeClock(t)
+	began := clock.Now()
+	dir := backupModsDir(t)
+	jobs, _ := backupTree(t, dir, 400)
+	root := filepath.Join(t.TempDir(), backupsDirName)
+	backupDir := filepath.Join(root, "20240601-120000")
+	journal := &Journal{Path: filepath.Join(t.TempDir(), "journal.jsonl"), Run: "20240601-120000"}
+
+	err := BackupAndRemove(dir, backupDir, journal, nil, nil, func(progress RunProgress) {
+		if progress.Files >= 100 {
+			stopRunCtx()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("failed with %v", err)
+	}
+	entries, err := ReadJournal(journal.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) < 100 || len(entries) > 100+backupWorkers {
+		t.Errorf("backed up %d files", len(entries))
+	}
+	if !backupIncomplete(backupDir) || backupVerified(backupDir) || len(VerifiedBackups(root)) != 0 {
+		t.Error("the backup cut short is trusted")
+	}
+	// nothing but the rollback of its own run uses it
+	if err := RestoreFile(journal.Path, filepath.Base(entries[0].Path), ""); err == nil {
+		t.Errorf("restored %s from the incomplete backup", entries[0].Path)
+	}
+
+	if err := RollbackRun(journal, dir, began); err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range jobs {
+		if got := readFile(t, job.path); int64(len(got)) != job.size {
+			t.Fatalf("%s has %d bytes after the rollback", job.rel, len(got))
+		}
+	}
+	discardIncompleteBackup(backupDir)
+	if _, err := os.Stat(backupDir); !os.IsNotExist(err) {
+		t.Errorf("the incomplete backup is left: %v", err)
+	}
+}
+
+// BenchmarkBackupFiles backs up a tree of 500 small files one at a time
+// and with the worker pool.
+func BenchmarkBackupFiles(b *testing.B) {
+	for _, workers := range []int{1, backupWorkers} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				root := b.TempDir()
+				jobs, total := backupTree(b, filepath.Join(root, "mods"), 500)
+				journal := &Journal{Path: filepath.Join(root, "journal.jsonl"), Run: "bench"}
+				b.StartTimer()
+				if _, err := backupFiles(context.Background(), workers, jobs, total, filepath.Join(root, "backup"), journal, nil, func(RunProgress) {}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestUpdateNoBackup updates with --no-backup: confirmed with --yes the
+// replaced mods are deleted, the journal tells and rollback refuses; an
+// unattended run without --yes fails, and a player declining keeps the
+// backup.
+func TestUpdateNoBackup(t *testing.T) {
+	useRunState(t)
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 1"})
+	writeFiles(t, u.mods, map[string]string{"sodium.jar": "sodium 0"})
+	u.run(t)
+	backups := filepath.Join(u.state, backupsDirName)
+	before := dirNames(t, backups)
+
+	u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 2"})
+	output := readFile(t, u.run(t, "--no-backup"))
+	if !strings.Contains(output, T("backup.skip.warning", u.mods)) || strings.Contains(output, T("mods.backup", "")) {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 2" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+	if got := dirNames(t, backups); got != before {
+		t.Errorf("backups %s, before %s", got, before)
+	}
+	if output := readFile(t, u.run(t, "history")); !strings.Contains(output, "  ! "+T("history.nobackup")) {
+		t.Errorf("history:\n%s", output)
+	}
+	if code := exitsWith(func() { u.run(t, "rollback") }); code != exitLocal {
+		t.Errorf("rollback exited %d", code)
+	}
+	if log := readFile(t, filepath.Join(u.state, "clientUpdate.log")); !strings.Contains(log, "replaced the mods with --no-backup, so the mods it removed can't be put back") {
+		t.Errorf("log:\n%s", log)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 2" {
+		t.Errorf("rolled back to %q", got)
+	}
+
+	u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 3"})
+	u.clock.Advance(time.Minute)
+	if code := exitsWith(func() {
+		runMain(t, "--portable", u.state, "--dir", u.mods, "--no-telemetry", "--no-tui", "--mc-version", "1.20.1", "--no-backup")
+	}); code != exitUsage {
+		t.Errorf("unattended without --yes exited %d", code)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 2" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+
+	SaveConfig(ConfFile{MCDirectory: u.mods, MCVersion: "1.20.1"}, filepath.Join(u.state, "clientUpdate.json"))
+	useStdin(t, "y\nn\n"+strings.Repeat("y\n", 10))
+	u.clock.Advance(time.Minute)
+	output = readFile(t, runMain(t, "--portable", u.state, "--no-telemetry", "--no-tui", "--no-backup"))
+	if !strings.Contains(output, T("backup.skip.kept")) {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 3" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+	if got := dirNames(t, backups); got == before {
+		t.Errorf("declined: no backup, backups %s", got)
+	}
+}
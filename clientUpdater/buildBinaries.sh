@@ -1,3 +1,3 @@
 #!/usr/bin/env bash
-go build -o RXclientUpdater.bin
-GOOS=windows GOARCH=386 go build -o RXclientUpdater.exe
+go build -ldflags "-X main.Version=$(git describe --tags)" -o RXclientUpdater.bin
+GOOS=windows GOARCH=386 go build -ldflags "-X main.Version=$(git describe --tags)" -o RXclientUpdater.exe
@@ -0,0 +1,55 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// bundledFabricInstaller is the Fabric installer built into the updater,
+// for installing the loader when no newer one can be downloaded. Players
+// move the updater without the files next to it, so it isn't one of them.
+const (
+	bundledFabricInstaller = "fabric-installer-0.6.1.51.jar"
+	bundledFabricSHA256    = "4370467bde03dbfe8601e1355e7618e2d5d4addc6c9aa09282f88c352c503532"
+)
+
+//go:embed fabric-installer-0.6.1.51.jar
+var bundledFabricJar []byte
+
+// Bundled returns the path of a file built into the updater, written to
+// the cache's installers for java to run. The copy written is verified
+// against sum, the hash the file was built in with; a cached copy already
+// matching it is used as it is.
+func (c *Cache) Bundled(name string, content []byte, sum string) (string, error) {
+	defer c.lock("installers/" + name)()
+	p, err := c.path("installers", name)
+	if err != nil {
+		return "", err
+	}
+	sum = strings.ToLower(sum)
+	if actual, err := fileSHA256(p); err == nil && actual == sum {
+		return p, nil
+	}
+	Logf("cache: writing the bundled %s", name)
+	if err := writeAtomic(p, content); err != nil {
+		return "", err
+	}
+	actual, err := fileSHA256(p)
+	if err != nil {
+		return "", err
+	}
+	if actual != sum {
+		os.Remove(p)
+		return "", fmt.Errorf("the bundled %s has SHA-256 %s, expected %s", name, actual, sum)
+	}
+	if err := writeAtomic(p+".sha256", []byte(sum+"\n")); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// bundledFabricInstallerJar is the Fabric installer built into the
+// updater, to run with Cache.RunInstaller.
+var bundledFabricInstallerJar = InstallerJar{Name: bundledFabricInstaller, SHA256: bundledFabricSHA256, Bundled: bundledFabricJar}
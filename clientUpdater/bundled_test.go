@@ -0,0 +1,78 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBundledFabricJar(t *testing.T) {
+	if got := sha256Hex(bundledFabricJar); got != bundledFabricSHA256 {
+		t.Fatalf("the embedded %s has SHA-256 %s", bundledFabricInstaller, got)
+	}
+	if _, err := zip.NewReader(bytes.NewReader(bundledFabricJar), int64(len(bundledFabricJar))); err != nil {
+		t.Errorf("the embedded %s is no jar: %v", bundledFabricInstaller, err)
+	}
+}
+
+func TestCacheBundled(t *testing.T) {
+	log := captureRunLog(t)
+	cache := &Cache{Dir: t.TempDir()}
+	p, err := cache.Bundled(bundledFabricInstaller, bundledFabricJar, strings.ToUpper(bundledFabricSHA256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(cache.Dir, "installers", bundledFabricInstaller); p != want {
+		t.Errorf("written to %s, want %s", p, want)
+	}
+	if got := sha256Hex([]byte(readFile(t, p))); got != bundledFabricSHA256 {
+		t.Errorf("the written copy has SHA-256 %s", got)
+	}
+	if got := readFile(t, p+".sha256"); got != bundledFabricSHA256+"\n" {
+		t.Errorf("recorded %q", got)
+	}
+
+	// a copy already matching is used as it is
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(p, old, old); err != nil {
+		t.Fatal(err)
+	}
+	log.Reset()
+	if again, err := cache.Bundled(bundledFabricInstaller, bundledFabricJar, bundledFabricSHA256); err != nil || again != p {
+		t.Fatalf("%s, %v", again, err)
+	}
+	if info, err := os.Stat(p); err != nil || !info.ModTime().Equal(old) {
+		t.Errorf("the copy was rewritten: %v", err)
+	}
+	if strings.Contains(log.String(), "writing the bundled") {
+		t.Errorf("logged:\n%s", log)
+	}
+
+	// a tampered copy is replaced
+	if err := os.WriteFile(p, []byte("tampered"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Bundled(bundledFabricInstaller, bundledFabricJar, bundledFabricSHA256); err != nil {
+		t.Fatal(err)
+	}
+	if got := sha256Hex([]byte(readFile(t, p))); got != bundledFabricSHA256 {
+		t.Errorf("the replaced copy has SHA-256 %s", got)
+	}
+}
+
+// TestCacheBundledMismatch builds in a file that doesn't have its hash:
+// nothing is left for java to run.
+func TestCacheBundledMismatch(t *testing.T) {
+	cache := &Cache{Dir: t.TempDir()}
+	_, err := cache.Bundled("other.jar", []byte("not the installer"), bundledFabricSHA256)
+	if err == nil || !strings.Contains(err.Error(), "the bundled other.jar has SHA-256 "+sha256Hex([]byte("not the installer"))) {
+		t.Fatalf("failed with %v", err)
+	}
+	if got := dirNames(t, filepath.Join(cache.Dir, "installers")); got != "" {
+		t.Errorf("left %s", got)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheDirName is the folder inside the user cache directory holding
+// everything the updater caches.
+const cacheDirName = "rxmc-Updater"
+
+// Cache is the on-disk cache shared by every run and instance. Entries are
+// written to a temporary file and renamed into place, so concurrent updates
+// only ever see complete files.
+type Cache struct {
+	Dir string
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+	// looked holds the metadata looked up during this run, by name.
+	looked map[string][]byte
+}
+
+// DefaultCacheDir returns the cache directory for the current user.
+func DefaultCacheDir() string {
+	if baseDirs.Cache == "" {
+		return "clientUpdate-cache"
+	}
+	return filepath.Join(baseDirs.Cache, cacheDirName)
+}
+
+// lock serializes work on a single cache entry within this process.
+func (c *Cache) lock(name string) func() {
+	c.mu.Lock()
+	if c.locks == nil {
+		c.locks = map[string]*sync.Mutex{}
+	}
+	l, ok := c.locks[name]
+	if !ok {
+		l = &sync.Mutex{}
+		c.locks[name] = l
+	}
+	c.mu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// path returns where an entry lives, creating its directory.
+func (c *Cache) path(kind string, name string) (string, error) {
+	dir := filepath.Join(c.Dir, kind)
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// writeAtomic replaces the file at p with content.
+func writeAtomic(p string, content []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := (countingWriter{tmp}).Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return spaceError(p, int64(len(content)), err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return spaceError(p, int64(len(content)), err)
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// metadataValidators are what a server said identifies the version of a
+// metadata entry, sent back to ask whether it changed since.
+type metadataValidators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// errNotModified is a conditional request finding the cached copy current.
+var errNotModified = errors.New("not modified")
+
+// Metadata returns the response body of a metadata url. A copy fetched less
+// than ttl ago is used as it is; an older one is revalidated with a
+// conditional request when the server identified its version, and still
+// used when the network is unavailable. Each entry is looked up once per
+// run: concurrent callers wait for the lookup in flight, later ones get its
+// result. A nil cache fetches every time.
+func (c *Cache) Metadata(name string, url string, ttl time.Duration) ([]byte, error) {
+	if c == nil {
+		return fetch(url)
+	}
+	defer c.lock("metadata/" + name)()
+	c.mu.Lock()
+	content, ok := c.looked[name]
+	c.mu.Unlock()
+	if ok {
+		return content, nil
+	}
+
+	start := clock.Now()
+	content, outcome, err := c.lookUp(name, url, ttl)
+	took := clock.Now().Sub(start).Round(time.Millisecond)
+	if err != nil {
+		Logf("cache: looking up %s failed after %s: %s", name, took, err)
+		return nil, err
+	}
+	Logf("cache: %s %s in %s", name, outcome, took)
+	countCacheLookup(outcome != "fetched")
+	c.mu.Lock()
+	if c.looked == nil {
+		c.looked = map[string][]byte{}
+	}
+	c.looked[name] = content
+	c.mu.Unlock()
+	return content, nil
+}
+
+// lookUp does the work of Metadata, returning how the entry was found.
+func (c *Cache) lookUp(name string, url string, ttl time.Duration) ([]byte, string, error) {
+	p, err := c.path("metadata", name)
+	if err != nil {
+		return nil, "", err
+	}
+	validatorsPath := p + ".validators"
+	info, statErr := os.Stat(p)
+	if statErr == nil && clock.Now().Sub(info.ModTime()) < ttl {
+		if content, err := ioutil.ReadFile(p); err == nil {
+			return content, "fresh", nil
+		}
+	}
+
+	var validators metadataValidators
+	if statErr == nil {
+		if content, err := ioutil.ReadFile(validatorsPath); err == nil {
+			json.Unmarshal(content, &validators)
+		}
+	}
+	content, next, err := fetchConditional(url, validators)
+	if err == errNotModified {
+		if content, err := ioutil.ReadFile(p); err == nil {
+			now := clock.Now()
+			os.Chtimes(p, now, now)
+			return content, "revalidated", nil
+		}
+		content, next, err = fetchConditional(url, metadataValidators{})
+	}
+	if err != nil {
+		if statErr == nil {
+			Logf("cache: refreshing %s failed (%s), using stale copy", name, err)
+			content, err := ioutil.ReadFile(p)
+			return content, "stale", err
+		}
+		return nil, "", err
+	}
+	if err := writeAtomic(p, content); err != nil {
+		Logf("cache: storing %s: %s", name, err)
+	}
+	if next == (metadataValidators{}) {
+		os.Remove(validatorsPath)
+	} else if encoded, err := json.Marshal(next); err == nil {
+		writeAtomic(validatorsPath, encoded)
+	}
+	return content, "fetched", nil
+}
+
+// DropMetadata forgets the metadata entry name, for a copy found to be
+// wrong.
+func (c *Cache) DropMetadata(name string) {
+	if c == nil {
+		return
+	}
+	defer c.lock("metadata/" + name)()
+	c.mu.Lock()
+	delete(c.looked, name)
+	c.mu.Unlock()
+	if p, err := c.path("metadata", name); err == nil {
+		os.Remove(p)
+		os.Remove(p + ".validators")
+	}
+}
+
+// Installer returns the path of a cached installer jar, downloading it when
+// it isn't cached or no longer matches its hash. publishedSHA256 is the hash
+// published next to the download; when empty the hash of the first download
+// is recorded and verified from then on.
+func (c *Cache) Installer(name string, url string, publishedSHA256 string) (string, error) {
+	defer c.lock("installers/" + name)()
+	p, err := c.path("installers", name)
+	if err != nil {
+		return "", err
+	}
+	hashPath := p + ".sha256"
+
+	expected := strings.ToLower(publishedSHA256)
+	if recorded, err := ioutil.ReadFile(hashPath); err == nil && expected == "" {
+		expected = strings.TrimSpace(string(recorded))
+	}
+	if expected != "" {
+		if sum, err := fileSHA256(p); err == nil && sum == expected {
+			countCacheLookup(true)
+			return p, nil
+		}
+	}
+	countCacheLookup(false)
+
+	Logf("cache: downloading %s from %s", name, url)
+	if _, err := downloadPreferringMirror(p, url, publishedSHA256); err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	sum, err := fileSHA256(p)
+	if err != nil {
+		return "", err
+	}
+	if err := writeAtomic(hashPath, []byte(sum+"\n")); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// Clear removes the cache directory. It refuses to touch anything that
+// doesn't look like our own cache.
+func (c *Cache) Clear() error {
+	if filepath.Base(c.Dir) != cacheDirName && filepath.Base(c.Dir) != "clientUpdate-cache" {
+		return fmt.Errorf("refusing to clear %s, it is not an updater cache", c.Dir)
+	}
+	return os.RemoveAll(c.Dir)
+}
+
+// fetch returns the body of a url.
+func fetch(url string) ([]byte, error) {
+	content, _, err := fetchConditional(url, metadataValidators{})
+	return content, err
+}
+
+// fetchConditional returns the body of a url with the validators of its
+// version, or errNotModified when it still has the version of validators.
+func fetchConditional(url string, validators metadataValidators) ([]byte, metadataValidators, error) {
+	req, err := http.NewRequestWithContext(runCtx, "GET", url, nil)
+	if err != nil {
+		return nil, metadataValidators{}, err
+	}
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, metadataValidators{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && validators != (metadataValidators{}) {
+		return nil, validators, errNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, metadataValidators{}, &statusError{status: resp.StatusCode}
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	countDownloaded(int64(len(content)))
+	next := metadataValidators{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	return content, next, err
+}
+
+// publishedHashTTL is how long a published hash is reused, the artifacts
+// they are published for never change.
+const publishedHashTTL = 30 * 24 * time.Hour
+
+// publishedSHA256 fetches the hash maven repositories publish next to each
+// artifact.
+func (c *Cache) publishedSHA256(name string, url string) (string, error) {
+	content, err := c.Metadata(name+".sha256", url+".sha256", publishedHashTTL)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 || len(fields[0]) != 64 {
+		return "", fmt.Errorf("%s: malformed published hash", name)
+	}
+	return strings.ToLower(fields[0]), nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// metadataServer serves metadata by url path, with an ETag of its content,
+// and counts the requests.
+type metadataServer struct {
+	mu          sync.Mutex
+	content     map[string]string
+	requests    int
+	conditional int
+	// delay holds every response back, so concurrent lookups overlap.
+	delay time.Duration
+}
+
+func (s *metadataServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	match := r.Header.Get("If-None-Match")
+	if match != "" {
+		s.conditional++
+	}
+	content, ok := s.content[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	etag := fmt.Sprintf(`"%x"`, len(content))
+	if match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	fmt.Fprint(w, content)
+}
+
+// counts returns the requests made and how many of them were conditional.
+func (s *metadataServer) counts() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests, s.conditional
+}
+
+// expire dates the cached entry name back beyond ttl.
+func expire(t *testing.T, cache *Cache, name string, ttl time.Duration) {
+	t.Helper()
+	old := clock.Now().Add(-ttl - time.Minute)
+	if err := os.Chtimes(filepath.Join(cache.Dir, "metadata", name), old, old); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheMetadata(t *testing.T) {
+	useFakeClock(t)
+	log := captureRunLog(t)
+	server := &metadataServer{content: map[string]string{"/versions.json": "[1]"}}
+	useTestServer(t, server)
+	dir := t.TempDir()
+	lookUp := func(cache *Cache, want string, requests int, conditional int, outcome string) {
+		t.Helper()
+		log.Reset()
+		content, err := cache.Metadata("versions.json", "https://meta.example.com/versions.json", time.Hour)
+		if err != nil || string(content) != want {
+			t.Errorf("looked up %q, %v, want %q", content, err, want)
+		}
+		if got, gotConditional := server.counts(); got != requests || gotConditional != conditional {
+			t.Errorf("%d requests, %d conditional, want %d, %d", got, gotConditional, requests, conditional)
+		}
+		if outcome != "" && !strings.Contains(log.String(), "cache: versions.json "+outcome+" in ") {
+			t.Errorf("not %s:\n%s", outcome, log)
+		}
+	}
+
+	run := &Cache{Dir: dir}
+	lookUp(run, "[1]", 1, 0, "fetched")
+	// looked up once per run
+	lookUp(run, "[1]", 1, 0, "")
+	// the next run finds a fresh copy
+	lookUp(&Cache{Dir: dir}, "[1]", 1, 0, "fresh")
+
+	run = &Cache{Dir: dir}
+	expire(t, run, "versions.json", time.Hour)
+	lookUp(run, "[1]", 2, 1, "revalidated")
+
+	server.content["/versions.json"] = "[1, 2]"
+	run = &Cache{Dir: dir}
+	expire(t, run, "versions.json", time.Hour)
+	lookUp(run, "[1, 2]", 3, 2, "fetched")
+
+	delete(server.content, "/versions.json")
+	run = &Cache{Dir: dir}
+	expire(t, run, "versions.json", time.Hour)
+	lookUp(run, "[1, 2]", 4, 3, "stale")
+
+	// nothing to fall back on
+	run.DropMetadata("versions.json")
+	if _, err := run.Metadata("versions.json", "https://meta.example.com/versions.json", time.Hour); err == nil {
+		t.Errorf("looked up a dropped entry the server doesn't have")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "metadata", "versions.json")); !os.IsNotExist(err) {
+		t.Errorf("the dropped entry is still cached: %v", err)
+	}
+}
+
+func TestCacheMetadataSingleFlight(t *testing.T) {
+	useFakeClock(t)
+	server := &metadataServer{content: map[string]string{"/versions.json": "[1]"}, delay: 20 * time.Millisecond}
+	useTestServer(t, server)
+	cache := &Cache{Dir: t.TempDir()}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if content, err := cache.Metadata("versions.json", "https://meta.example.com/versions.json", time.Hour); err != nil || string(content) != "[1]" {
+				t.Errorf("looked up %q, %v", content, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if requests, _ := server.counts(); requests != 1 {
+		t.Errorf("%d requests", requests)
+	}
+}
+
+// TestPrefetchMetadata prefetches what installing Fabric needs, and then
+// runs again: a warm cache makes at most one conditional request.
+func TestPrefetchMetadata(t *testing.T) {
+	useFakeClock(t)
+	server := &metadataServer{content: map[string]string{
+		"/v2/versions/installer":             `[{"url": "https://maven.fabricmc.net/fabric-installer-1.0.1.jar", "version": "1.0.1", "stable": true}]`,
+		"/fabric-installer-1.0.1.jar.sha256": "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+	}}
+	useTestServer(t, server)
+	dir := t.TempDir()
+	minecraft := t.TempDir()
+	// the loader's lookups of a run, first prefetched, then as installing
+	// does them
+	run := func() {
+		t.Helper()
+		cache := &Cache{Dir: dir}
+		loader := fabricLoader{cache: cache}
+		PrefetchMetadata(cache, loader, minecraft, "1.20.1", false)
+		if name, _, sum, err := loader.newestInstaller(); err != nil || name != "fabric-installer-1.0.1.jar" || sum[:4] != "0123" {
+			t.Fatalf("installer %s %s, %v", name, sum, err)
+		}
+	}
+
+	run()
+	if requests, _ := server.counts(); requests != 2 {
+		t.Errorf("cold: %d requests", requests)
+	}
+	run()
+	if requests, _ := server.counts(); requests != 2 {
+		t.Errorf("warm: %d requests", requests-2)
+	}
+	expire(t, &Cache{Dir: dir}, "fabric-installers.json", metadataTTL)
+	run()
+	if requests, conditional := server.counts(); requests != 3 || conditional != 1 {
+		t.Errorf("expired: %d requests, %d conditional", requests-2, conditional)
+	}
+
+	// nothing is looked up for an installed loader
+	writeFiles(t, minecraft, map[string]string{"versions/fabric-loader-0.15.11-1.20.1/fabric-loader-0.15.11-1.20.1.json": "{}"})
+	expire(t, &Cache{Dir: dir}, "fabric-installers.json", metadataTTL)
+	PrefetchMetadata(&Cache{Dir: dir}, fabricLoader{cache: &Cache{Dir: dir}}, minecraft, "1.20.1", false)
+	if requests, _ := server.counts(); requests != 3 {
+		t.Errorf("installed: %d requests", requests-3)
+	}
+}
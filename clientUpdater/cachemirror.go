@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// blobsKept is how many downloads the cache keeps by content for LAN
+// mirrors, the newest ones; installers are kept anyway.
+const blobsKept = 2
+
+// blobPrefix is the path LAN mirrors serve content under, followed by its
+// SHA-256.
+const blobPrefix = "/sha256/"
+
+// blobPath matches the paths of content a LAN mirror serves.
+var blobPath = regexp.MustCompile("^" + blobPrefix + "([0-9a-f]{64})$")
+
+// StoreBlob keeps the downloaded file at p in the cache by its content sum,
+// for a LAN mirror to serve. It is linked where possible, so it costs no
+// space while p exists, and the oldest blobs beyond blobsKept are removed.
+func (c *Cache) StoreBlob(p string, sum string) error {
+	sum = strings.ToLower(sum)
+	if !sha256Pattern.MatchString(sum) {
+		return fmt.Errorf("%q is not a SHA-256", sum)
+	}
+	defer c.lock("blobs")()
+	dest, err := c.path("blobs", sum)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dest); err == nil {
+		now := clock.Now()
+		return os.Chtimes(dest, now, now)
+	}
+	if err := os.Link(p, dest); err != nil {
+		if err := copyFile(p, dest+partialSuffix); err != nil {
+			os.Remove(dest + partialSuffix)
+			return err
+		}
+		if err := rename(dest+partialSuffix, dest); err != nil {
+			return err
+		}
+	}
+	entries, err := ioutil.ReadDir(filepath.Dir(dest))
+	if err != nil {
+		return err
+	}
+	var blobs []os.FileInfo
+	for _, entry := range entries {
+		if sha256Pattern.MatchString(entry.Name()) {
+			blobs = append(blobs, entry)
+		}
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].ModTime().After(blobs[j].ModTime()) })
+	for i := blobsKept; i < len(blobs); i++ {
+		Logf("cache: dropping the blob %s", blobs[i].Name())
+		os.Remove(filepath.Join(filepath.Dir(dest), blobs[i].Name()))
+	}
+	return nil
+}
+
+// blob returns the file of the cache holding content sum: a stored blob or
+// an installer with that hash.
+func (c *Cache) blob(sum string) (string, bool) {
+	p := filepath.Join(c.Dir, "blobs", sum)
+	if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() {
+		return p, true
+	}
+	sidecars, _ := filepath.Glob(filepath.Join(c.Dir, "installers", "*.sha256"))
+	for _, sidecar := range sidecars {
+		recorded, err := ioutil.ReadFile(sidecar)
+		if err != nil || strings.TrimSpace(string(recorded)) != sum {
+			continue
+		}
+		installer := strings.TrimSuffix(sidecar, ".sha256")
+		if actual, err := fileSHA256(installer); err == nil && actual == sum {
+			return installer, true
+		}
+	}
+	return "", false
+}
+
+// CacheServer serves the cache read-only to the LAN, by content: GET or
+// HEAD of /sha256/<sum> returns the file with that SHA-256, ranges
+// included, so downloads from it resume like any other. Nothing else is
+// served, so no path of the request ever reaches the filesystem.
+type CacheServer struct {
+	Cache *Cache
+}
+
+func (s *CacheServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	m := blobPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	p, ok := s.Cache.blob(m[1])
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := os.Open(p)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	Logf("serve-cache: %s %s to %s", r.Method, m[1], r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", `"`+m[1]+`"`)
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
+
+// ServeCache serves cache on addr, e.g. ":8766" or "192.168.1.10:8766",
+// until interrupted.
+func ServeCache(cache *Cache, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: &CacheServer{Cache: cache}, ReadHeaderTimeout: 10 * time.Second}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		server.Close()
+	}()
+	fmt.Println(T("servecache.start", cache.Dir, listener.Addr()))
+	Logf("serve-cache: serving %s on %s", cache.Dir, listener.Addr())
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	fmt.Println(T("servecache.stopped"))
+	return nil
+}
+
+// cacheMirror is the LAN mirror tried first for downloads whose hash is
+// known, empty for none.
+var cacheMirror string
+
+// SetCacheMirror sets the LAN mirror, the address of another updater
+// running --serve-cache, e.g. http://192.168.1.10:8766.
+func SetCacheMirror(mirror string) error {
+	if mirror == "" {
+		cacheMirror = ""
+		return nil
+	}
+	u, err := url.Parse(mirror)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("cacheMirror %q is not an address like http://192.168.1.10:8766", mirror)
+	}
+	cacheMirror = strings.TrimRight(mirror, "/")
+	return nil
+}
+
+// fromCacheMirror downloads content sum from the LAN mirror into dest.
+// What the mirror serves is verified against sum like any download, a
+// mirror can only fail, never hand out something else. ok is false when
+// there is no mirror or it doesn't have the content; the download then
+// goes to the internet as usual.
+func fromCacheMirror(dest string, sum string) (download *Download, ok bool) {
+	if cacheMirror == "" || !sha256Pattern.MatchString(sum) {
+		return nil, false
+	}
+	mirrorURL := cacheMirror + blobPrefix + strings.ToLower(sum)
+	err := probeURL(mirrorURL)
+	if err == nil {
+		download, err = downloadFile(dest, mirrorURL, sum)
+	}
+	if err != nil {
+		Logf("download: LAN mirror %s: %s", mirrorURL, err)
+		os.Remove(dest)
+		return nil, false
+	}
+	Logf("download: %s from the LAN mirror, %d bytes", sum, download.Size)
+	return download, true
+}
+
+// downloadPreferringMirror is downloadFile trying the LAN mirror first.
+func downloadPreferringMirror(dest string, url string, sum string) (*Download, error) {
+	if download, ok := fromCacheMirror(dest, sum); ok {
+		return download, nil
+	}
+	return downloadFile(dest, url, sum)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// storeBlob stores content in the cache for the mirror, returning its sum.
+func storeBlob(t *testing.T, cache *Cache, content string, modified time.Time) string {
+	t.Helper()
+	p := writeTemp(t, content)
+	if err := os.Chtimes(p, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	sum, _ := fileSHA256(p)
+	if err := cache.StoreBlob(p, sum); err != nil {
+		t.Fatal(err)
+	}
+	return sum
+}
+
+func TestCacheServer(t *testing.T) {
+	cache := &Cache{Dir: t.TempDir()}
+	sum := storeBlob(t, cache, "0123456789", time.Now())
+	writeFiles(t, cache.Dir, map[string]string{
+		"installers/fabric-installer.jar":        "installer",
+		"installers/fabric-installer.jar.sha256": sha256Hex([]byte("installer")) + "\n",
+		"cache.json":                             "{}",
+	})
+	server := httptest.NewServer(&CacheServer{Cache: cache})
+	defer server.Close()
+
+	tests := []struct {
+		method, path, rangeHeader string
+		status                    int
+		body                      string
+	}{
+		{"GET", blobPrefix + sum, "", http.StatusOK, "0123456789"},
+		{"HEAD", blobPrefix + sum, "", http.StatusOK, ""},
+		{"GET", blobPrefix + sum, "bytes=4-", http.StatusPartialContent, "456789"},
+		{"GET", blobPrefix + sum, "bytes=2-4", http.StatusPartialContent, "234"},
+		{"GET", blobPrefix + sum, "bytes=20-", http.StatusRequestedRangeNotSatisfiable, ""},
+		{"GET", blobPrefix + sha256Hex([]byte("installer")), "", http.StatusOK, "installer"},
+		{"POST", blobPrefix + sum, "", http.StatusMethodNotAllowed, ""},
+		{"DELETE", blobPrefix + sum, "", http.StatusMethodNotAllowed, ""},
+		{"GET", blobPrefix + strings.ToUpper(sum), "", http.StatusNotFound, ""},
+		{"GET", blobPrefix + sum + "/x", "", http.StatusNotFound, ""},
+		{"GET", blobPrefix + sum[:63], "", http.StatusNotFound, ""},
+		{"GET", blobPrefix + sha256Hex([]byte("unknown")), "", http.StatusNotFound, ""},
+		{"GET", blobPrefix + "..%2fcache.json", "", http.StatusNotFound, ""},
+		{"GET", "/blobs/" + sum, "", http.StatusNotFound, ""},
+		{"GET", "/cache.json", "", http.StatusNotFound, ""},
+		{"GET", "/", "", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, server.URL+test.path, nil)
+		if test.rangeHeader != "" {
+			req.Header.Set("Range", test.rangeHeader)
+		}
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%s %s %s: %s, want %d", test.method, test.path, test.rangeHeader, resp.Status, test.status)
+			continue
+		}
+		if test.body != "" && string(body) != test.body {
+			t.Errorf("%s %s %s served %q, want %q", test.method, test.path, test.rangeHeader, body, test.body)
+		}
+		if test.status == http.StatusMethodNotAllowed && resp.Header.Get("Allow") != "GET, HEAD" {
+			t.Errorf("%s %s: Allow %q", test.method, test.path, resp.Header.Get("Allow"))
+		}
+	}
+}
+
+func TestStoreBlobKeepsTheNewest(t *testing.T) {
+	cache := &Cache{Dir: t.TempDir()}
+	start := time.Now().Add(-time.Hour)
+	var sums []string
+	for i, content := range []string{"first", "second", "third"} {
+		sums = append(sums, storeBlob(t, cache, content, start.Add(time.Duration(i)*time.Minute)))
+	}
+	for i, sum := range sums {
+		_, kept := cache.blob(sum)
+		if kept != (i > 0) {
+			t.Errorf("blob %d kept: %t", i, kept)
+		}
+	}
+	if err := cache.StoreBlob(writeTemp(t, "x"), "not a sum"); err == nil {
+		t.Error("stored a blob under a name that isn't a SHA-256")
+	}
+}
+
+// mirrorTest serves the LAN mirror at lan.example:8766 and the internet
+// source at example.com, recording the requests to each.
+type mirrorTest struct {
+	mu       sync.Mutex
+	requests []string
+	// mirrored is what the mirror serves for every sum, none when empty.
+	mirrored string
+	content  string
+}
+
+func (m *mirrorTest) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	m.requests = append(m.requests, r.Method+" "+r.Host)
+	m.mu.Unlock()
+	switch {
+	case r.Host == "lan.example:8766" && m.mirrored != "":
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(m.mirrored))
+	case r.Host == "example.com":
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(m.content))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestDownloadPreferringMirror(t *testing.T) {
+	const content = "the pack"
+	sum := sha256Hex([]byte(content))
+	tests := []struct {
+		name     string
+		mirror   string
+		mirrored string
+		sum      string
+		requests string
+	}{
+		{"from the mirror", "http://lan.example:8766", content, sum, "HEAD lan.example:8766, GET lan.example:8766"},
+		{"not on the mirror", "http://lan.example:8766/", "", sum, "HEAD lan.example:8766, GET example.com"},
+		// what the mirror serves is verified, the internet has the right one
+		{"poisoned mirror", "http://lan.example:8766", "something else", sum, "HEAD lan.example:8766, GET lan.example:8766, GET example.com"},
+		{"unknown sum", "http://lan.example:8766", content, "", "GET example.com"},
+		{"no mirror", "", content, sum, "GET example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &mirrorTest{mirrored: test.mirrored, content: content}
+			useTestServer(t, server)
+			if err := SetCacheMirror(test.mirror); err != nil {
+				t.Fatal(err)
+			}
+			defer SetCacheMirror("")
+			dest := filepath.Join(t.TempDir(), "pack.zip")
+
+			download, err := downloadPreferringMirror(dest, "https://example.com/pack.zip", test.sum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readFile(t, dest); got != content || download.SHA256 != sum {
+				t.Errorf("downloaded %q with SHA-256 %s", got, download.SHA256)
+			}
+			if got := strings.Join(server.requests, ", "); got != test.requests {
+				t.Errorf("requests %s, want %s", got, test.requests)
+			}
+		})
+	}
+}
+
+func TestSetCacheMirror(t *testing.T) {
+	defer SetCacheMirror("")
+	for _, mirror := range []string{"192.168.1.10:8766", "ftp://192.168.1.10", "http://", "http://%zz"} {
+		if err := SetCacheMirror(mirror); err == nil {
+			t.Errorf("%q accepted", mirror)
+		}
+	}
+	if err := SetCacheMirror("http://192.168.1.10:8766/"); err != nil || cacheMirror != "http://192.168.1.10:8766" {
+		t.Errorf("mirror %q, %v", cacheMirror, err)
+	}
+}
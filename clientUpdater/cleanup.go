@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// removePath is os.RemoveAll, replaced by tests simulating files another
+// program holds open.
+var removePath = os.RemoveAll
+
+// cleanupRetryDelays are the waits between the attempts to remove a file
+// another program has open, a few seconds in all.
+var cleanupRetryDelays = []time.Duration{100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond, time.Second, 2 * time.Second}
+
+// cleanupMaxAge is how long the next runs keep trying to remove a file
+// before giving up on it.
+const cleanupMaxAge = 7 * 24 * time.Hour
+
+// cleanupListPath is the cleanup list, the files a run couldn't remove for
+// the next run to remove. Empty means nothing is left for the next run.
+var cleanupListPath string
+
+// cleanupListMu serializes changes to the cleanup list.
+var cleanupListMu sync.Mutex
+
+// pendingCleanup is a file or directory on the cleanup list.
+type pendingCleanup struct {
+	Path  string    `json:"path"`
+	Since time.Time `json:"since"`
+	Error string    `json:"error"`
+}
+
+// removeRetrying removes p and everything in it. A file another program has
+// open, on Windows typically a virus scanner or the search indexer looking
+// at a file just written, fails the removal only for a moment, so that is
+// retried for a few seconds.
+func removeRetrying(p string) error {
+	err := removePath(p)
+	for _, delay := range cleanupRetryDelays {
+		if err == nil || !fileInUse(err) {
+			break
+		}
+		Logf("cleanup: %s is in use, retrying in %s", p, delay)
+		clock.Sleep(delay)
+		err = removePath(p)
+	}
+	return err
+}
+
+// RemoveTemporary removes a temporary file or directory of the updater,
+// which nothing depends on being gone right away. What can't be removed
+// even after retrying is put on the cleanup list for the next run.
+func RemoveTemporary(p string) {
+	err := removeRetrying(p)
+	if err == nil {
+		return
+	}
+	Logf("cleanup: removing %s failed: %s", p, err)
+	if cleanupListPath == "" {
+		return
+	}
+	if err := scheduleCleanup(pendingCleanup{Path: p, Since: clock.Now(), Error: err.Error()}); err != nil {
+		Logf("cleanup: scheduling %s for the next run: %s", p, err)
+		return
+	}
+	fmt.Println(T("cleanup.deferred", p))
+}
+
+// scheduleCleanup adds entry to the cleanup list.
+func scheduleCleanup(entry pendingCleanup) error {
+	cleanupListMu.Lock()
+	defer cleanupListMu.Unlock()
+	pending, err := readCleanupList(cleanupListPath)
+	if err != nil {
+		Logf("cleanup: replacing unreadable %s: %s", cleanupListPath, err)
+	}
+	for _, p := range pending {
+		if p.Path == entry.Path {
+			return nil
+		}
+	}
+	return writeCleanupList(cleanupListPath, append(pending, entry))
+}
+
+// SweepCleanupList removes what earlier runs left on the cleanup list.
+// What still can't be removed stays on it, until it is a week old.
+func SweepCleanupList() {
+	if cleanupListPath == "" {
+		return
+	}
+	cleanupListMu.Lock()
+	defer cleanupListMu.Unlock()
+	pending, err := readCleanupList(cleanupListPath)
+	if err != nil {
+		Logf("cleanup: dropping unreadable %s: %s", cleanupListPath, err)
+	}
+	var left []pendingCleanup
+	for _, entry := range pending {
+		err := removeRetrying(entry.Path)
+		switch {
+		case err == nil:
+			Logf("cleanup: removed %s, left over since %s", entry.Path, entry.Since.Format(time.RFC3339))
+		case clock.Now().Sub(entry.Since) > cleanupMaxAge:
+			Logf("cleanup: giving up on %s, it couldn't be removed since %s: %s", entry.Path, entry.Since.Format(time.RFC3339), err)
+		default:
+			Logf("cleanup: %s still can't be removed: %s", entry.Path, err)
+			entry.Error = err.Error()
+			left = append(left, entry)
+		}
+	}
+	if err := writeCleanupList(cleanupListPath, left); err != nil {
+		Logf("cleanup: writing %s: %s", cleanupListPath, err)
+	}
+}
+
+// readCleanupList reads the cleanup list at p, empty when there is none.
+func readCleanupList(p string) ([]pendingCleanup, error) {
+	var pending []pendingCleanup
+	err := ReadStateFile(p, cleanupSchema, &pending)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return pending, err
+}
+
+// writeCleanupList saves the cleanup list at p, removing it when empty.
+func writeCleanupList(p string, pending []pendingCleanup) error {
+	if len(pending) == 0 {
+		return RemoveStateFile(p)
+	}
+	return WriteStateFile(p, cleanupSchema, pending)
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// fileInUse reports whether err is a removal failing only while another
+// program uses the file. Unix removes open files, only busy mount points
+// refuse.
+func fileInUse(err error) bool {
+	return errors.Is(err, syscall.EBUSY)
+}
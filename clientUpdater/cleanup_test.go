@@ -0,0 +1,202 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// errLocked is how removing a file another program has open fails, on
+// Windows a sharing violation.
+var errLocked error = syscall.EBUSY
+
+func init() {
+	if runtime.GOOS == "windows" {
+		errLocked = syscall.Errno(32)
+	}
+}
+
+// lockedFiles simulates files other programs hold open: removing them
+// fails as long as they are locked.
+type lockedFiles struct {
+	mu       sync.Mutex
+	locked   func(p string) bool
+	attempts map[string]int
+}
+
+// useLockedFiles replaces removePath for the test, the files locked
+// reports being held open.
+func useLockedFiles(t *testing.T, locked func(p string) bool) *lockedFiles {
+	t.Helper()
+	files := &lockedFiles{locked: locked, attempts: map[string]int{}}
+	saved := removePath
+	removePath = func(p string) error {
+		files.mu.Lock()
+		files.attempts[p]++
+		locked := files.locked(p)
+		files.mu.Unlock()
+		if locked {
+			return &os.PathError{Op: "unlinkat", Path: p, Err: errLocked}
+		}
+		return os.RemoveAll(p)
+	}
+	t.Cleanup(func() { removePath = saved })
+	return files
+}
+
+// unlock has the files locked reports held open from now on.
+func (f *lockedFiles) unlock(locked func(p string) bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.locked = locked
+}
+
+// useCleanupList has the cleanup list at p for the test.
+func useCleanupList(t *testing.T, p string) {
+	t.Helper()
+	saved := cleanupListPath
+	cleanupListPath = p
+	t.Cleanup(func() { cleanupListPath = saved })
+}
+
+func lockedNone(string) bool { return false }
+
+func TestRemoveRetrying(t *testing.T) {
+	fake := useFakeClock(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"briefly.zip": "", "held.zip": ""})
+	// a file the virus scanner lets go of after two attempts, and one it
+	// holds on to
+	briefly := filepath.Join(dir, "briefly.zip")
+	tries := 0
+	files := useLockedFiles(t, func(p string) bool {
+		if p == briefly {
+			tries++
+			return tries <= 2
+		}
+		return filepath.Base(p) == "held.zip"
+	})
+	start := fake.Now()
+	if err := removeRetrying(briefly); err != nil {
+		t.Errorf("removing briefly.zip: %v", err)
+	}
+	if files.attempts[briefly] != 3 || fake.Now().Sub(start) != 350*time.Millisecond {
+		t.Errorf("removed briefly.zip in %d attempts, %s", files.attempts[briefly], fake.Now().Sub(start))
+	}
+
+	held := filepath.Join(dir, "held.zip")
+	start = fake.Now()
+	if err := removeRetrying(held); !fileInUse(err) {
+		t.Errorf("removing held.zip: %v", err)
+	}
+	if files.attempts[held] != len(cleanupRetryDelays)+1 || fake.Now().Sub(start) != 3850*time.Millisecond {
+		t.Errorf("gave up on held.zip after %d attempts, %s", files.attempts[held], fake.Now().Sub(start))
+	}
+	if _, err := os.Stat(held); err != nil {
+		t.Errorf("held.zip: %v", err)
+	}
+}
+
+func TestRemoveRetryingOtherErrors(t *testing.T) {
+	useFakeClock(t)
+	attempts := 0
+	saved := removePath
+	removePath = func(p string) error {
+		attempts++
+		return &os.PathError{Op: "unlinkat", Path: p, Err: syscall.EROFS}
+	}
+	defer func() { removePath = saved }()
+	if err := removeRetrying(filepath.Join(t.TempDir(), "pack.zip")); err == nil || attempts != 1 {
+		t.Errorf("%d attempts, %v", attempts, err)
+	}
+}
+
+func TestCleanupList(t *testing.T) {
+	fake := useFakeClock(t)
+	state := t.TempDir()
+	list := filepath.Join(state, "clientUpdate-cleanup.json")
+	useCleanupList(t, list)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"pack.zip": "", "staging/sodium.jar": "", "done.zip": ""})
+	pack, staging := filepath.Join(dir, "pack.zip"), filepath.Join(dir, "staging")
+	files := useLockedFiles(t, func(p string) bool { return p == pack || p == staging })
+
+	output := captureStdout(t, func() {
+		RemoveTemporary(pack)
+		RemoveTemporary(staging)
+		// once on the list is enough
+		RemoveTemporary(pack)
+		RemoveTemporary(filepath.Join(dir, "done.zip"))
+	})
+	if got := strings.Count(output, "couldn't be removed yet"); got != 3 {
+		t.Errorf("output:\n%s", output)
+	}
+	pending, err := readCleanupList(list)
+	if err != nil || len(pending) != 2 || pending[0].Path != pack || pending[1].Path != staging || pending[0].Since.After(fake.Now()) || pending[0].Since.IsZero() {
+		t.Fatalf("scheduled %+v, %v", pending, err)
+	}
+	if got := dirNames(t, dir); got != "pack.zip staging" {
+		t.Errorf("left %s", got)
+	}
+
+	// the next runs: still locked, the staging folder let go of, and the
+	// archive given up on after a week
+	fake.Advance(time.Hour)
+	SweepCleanupList()
+	if pending, _ := readCleanupList(list); len(pending) != 2 {
+		t.Errorf("still locked: %+v", pending)
+	}
+	files.unlock(func(p string) bool { return p == pack })
+	SweepCleanupList()
+	if pending, _ := readCleanupList(list); len(pending) != 1 || pending[0].Path != pack || !strings.Contains(pending[0].Error, pack) {
+		t.Errorf("staging unlocked: %+v", pending)
+	}
+	if got := dirNames(t, dir); got != "pack.zip" {
+		t.Errorf("left %s", got)
+	}
+	fake.Advance(cleanupMaxAge)
+	SweepCleanupList()
+	if _, err := os.Stat(list); !os.IsNotExist(err) {
+		t.Errorf("the cleanup list is left: %v", err)
+	}
+	if got := dirNames(t, dir); got != "pack.zip" {
+		t.Errorf("left %s", got)
+	}
+}
+
+// TestUpdateDefersLockedArchive updates while a virus scanner holds the
+// downloaded pack archive open: it is removed by the next run.
+func TestUpdateDefersLockedArchive(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium"})
+	var archives []string
+	files := useLockedFiles(t, func(p string) bool {
+		if strings.HasSuffix(p, ".zip") {
+			archives = append(archives, p)
+			return true
+		}
+		return false
+	})
+
+	output := readFile(t, u.run(t))
+	if len(archives) == 0 || !strings.Contains(output, archives[0]+" couldn't be removed yet") {
+		t.Fatalf("archives %q, output:\n%s", archives, output)
+	}
+	list := filepath.Join(u.state, "clientUpdate-cleanup.json")
+	if pending, err := readCleanupList(list); err != nil || len(pending) != 1 || pending[0].Path != archives[0] {
+		t.Errorf("scheduled %+v, %v", pending, err)
+	}
+
+	files.unlock(lockedNone)
+	u.run(t)
+	if _, err := os.Stat(archives[0]); !os.IsNotExist(err) {
+		t.Errorf("the archive is left: %v", err)
+	}
+	if _, err := os.Stat(list); !os.IsNotExist(err) {
+		t.Errorf("the cleanup list is left: %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Errors Windows reports for a file another program has open. Deleting a
+// file a virus scanner is still reading is refused with access denied.
+const (
+	errorAccessDenied     syscall.Errno = 5
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// fileInUse reports whether err is a removal failing only while another
+// program uses the file.
+func fileInUse(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	return errno == errorSharingViolation || errno == errorLockViolation || errno == errorAccessDenied
+}
@@ -1,266 +1,1934 @@
-package main
-
-import (
-	"archive/zip"
-	"bufio"
-	"encoding/json"
-	"fmt"
-	"io"
-	"io/ioutil"
-	"net/http"
-	"os"
-	"os/exec"
-	"path"
-	"path/filepath"
-	"regexp"
-	"strings"
-	"time"
-)
-
-type ConfFile struct {
-	MCVersion   string `json:"version"`
-	MCDirectory string `json:"directory"`
-}
-
-func isWindows() bool {
-	return os.PathSeparator == '\\' && os.PathListSeparator == ';'
-}
-
-// DownloadFile will download a url to a local file. It's efficient because it will
-// write as it downloads and not load the whole file into memory.
-func DownloadFile(filepath string, url string) error {
-
-	// Get the data
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	// Create the file
-	out, err := os.Create(filepath)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
-	return err
-
-}
-
-// Unzip will decompress a zip archive, moving all files and folders
-// within the zip file (parameter 1) to an output directory (parameter 2).
-func Unzip(src string, dest string) ([]string, error) {
-
-	var filenames []string
-
-	r, err := zip.OpenReader(src)
-	if err != nil {
-		return filenames, err
-	}
-	defer r.Close()
-
-	for _, f := range r.File {
-
-		isModFile, err := regexp.MatchString("rxmc-Mods-master/[-._a-zA-Z0-9]*mods/.*\\.jar$", f.Name)
-		if f.FileInfo().IsDir() || !isModFile || err != nil {
-			continue
-		}
-
-		// Store filename/path for returning and using later on
-		_, fileName := filepath.Split(f.Name)
-		fpath := filepath.Join(dest, fileName)
-
-		// Check for ZipSlip. More Info: http://bit.ly/2MsjAWE
-		if !strings.HasPrefix(fpath, filepath.Clean(dest)+string(os.PathSeparator)) {
-			return filenames, fmt.Errorf("%s: illegal file path", fpath)
-		}
-
-		filenames = append(filenames, fpath)
-
-		// Make File
-		if err = os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
-			return filenames, err
-		}
-
-		outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
-			return filenames, err
-		}
-
-		rc, err := f.Open()
-		if err != nil {
-			return filenames, err
-		}
-
-		_, err = io.Copy(outFile, rc)
-
-		// Close the file without defer to close before next iteration of loop
-		outFile.Close()
-		rc.Close()
-
-		if err != nil {
-			return filenames, err
-		}
-	}
-	return filenames, nil
-}
-
-func SaveConfig(config ConfFile, jsonConfPath string) {
-	newconfig, err := os.Open(jsonConfPath)
-	if err != nil {
-		newconfig, err = os.Create(jsonConfPath)
-		if err != nil {
-			panic(err)
-		}
-	}
-	defer newconfig.Close()
-
-	jsonData, err := json.Marshal(config)
-	if err == nil {
-		newconfig.Write(jsonData)
-	} else {
-		panic(err)
-	}
-}
-
-func main() {
-	bundledFabricInstaller := "fabric-installer-0.6.1.51.jar"
-	fileURL := "https://github.com/rx13/rxmc-Mods/archive/master.zip"
-	fileOut := "serverMods-master.zip"
-	jsonConfPath := "clientUpdate.json"
-
-	// set base module path for vanilla
-	modPath := ""
-	if isWindows() {
-		modPath = path.Join(os.Getenv("APPDATA"), ".minecraft", "mods")
-	} else {
-		modPath = path.Join(os.Getenv("HOME"), ".minecraft", "mods")
-	}
-
-	// load and set config file if not present
-	var config ConfFile
-	configfile, err := os.Open(jsonConfPath)
-	if err == nil {
-		defer configfile.Close()
-		filecontent, _ := ioutil.ReadAll(configfile)
-		json.Unmarshal(filecontent, &config)
-	} else {
-		fmt.Println(err)
-		// probably not present, assign new values
-		config = ConfFile{MCVersion: "1.16.2", MCDirectory: modPath}
-		SaveConfig(config, jsonConfPath)
-	}
-
-	// set common needs for module handling
-	modPath = config.MCDirectory
-	reader := bufio.NewReader(os.Stdin)
-
-	fmt.Println("Downloading lastest mods")
-	err = DownloadFile(fileOut, fileURL)
-	if err != nil {
-		panic(err)
-	}
-	fmt.Println("> Downloaded: " + fileOut + "\n")
-
-	// validate module path is intended
-	fmt.Println("< Is this the correct minecraft MODS directory? (if not sure, just type yes) ")
-	fmt.Print("  > " + modPath + " ? [y/n]: ")
-	confirm, _ := reader.ReadString('\n')
-	if strings.ToLower(confirm)[0] != byte('y') {
-		fmt.Println("< Enter the correct path below")
-		fmt.Print("  > ")
-		newpath, _ := reader.ReadString('\n')
-		newpath = strings.TrimSpace(newpath)
-		if _, err := os.Stat(newpath); err == nil {
-			modPath = newpath
-			config.MCDirectory = newpath
-			SaveConfig(config, jsonConfPath)
-		} else {
-			fmt.Printf("Location %s does not exist, exiting.\n", newpath)
-			os.Exit(1)
-		}
-	}
-	fmt.Println("")
-	// mods path should end in "mods", else exit
-	if !strings.HasSuffix(strings.ToLower(modPath), "mods") {
-		fmt.Println("FATAL: the mod path should end in 'mods', but it is currently: " + modPath)
-		fmt.Println("Exiting.")
-		os.Exit(1)
-	}
-
-	// set minecraft relative paths
-	minecraftPath := path.Dir(modPath)
-	versionsPath := path.Join(minecraftPath, "versions")
-	versions, err := ioutil.ReadDir(versionsPath)
-	foundValidFabric := false
-	if err != nil {
-		fmt.Println("> No existing minecraft versions found.")
-	} else {
-		// check if minecraft version already exists with Fabric
-		fmt.Println("Collecting existing version information.")
-		for _, versionDirectory := range versions {
-			if versionDirectory.IsDir() {
-				dirName := path.Base(versionDirectory.Name())
-				if strings.HasPrefix(dirName, "fabric-loader") && strings.HasSuffix(dirName, config.MCVersion) {
-					foundValidFabric = true
-				}
-			}
-		}
-	}
-
-	// if fabric isn't there, install it
-	if !foundValidFabric {
-		fmt.Println("> Installing designated Fabric + Minecraft version.")
-		installFabric := exec.Command("java", "-jar", bundledFabricInstaller, "client", "-dir", minecraftPath, "-mcversion", config.MCVersion)
-		err = installFabric.Run()
-		if err != nil {
-			fmt.Printf("Fabric Install Error: %s\n", err)
-		} else {
-			fmt.Println("> Install complete.")
-		}
-	} else {
-		fmt.Println("> Fabric + Minecraft version already installed.")
-	}
-
-	if _, err := os.Stat(modPath); err == nil {
-		fmt.Println("Removing old mods for Minecraft")
-		err := os.RemoveAll(modPath)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println("> Mods have been removed")
-	}
-	os.MkdirAll(modPath, os.ModePerm)
-
-	fmt.Println("Loading new mods for Minecraft")
-	_, err = Unzip(fileOut, modPath)
-	if err != nil {
-		panic(err)
-	}
-	fmt.Println("> Mods loaded") // + strings.Join(extractedFiles, "\n  "))
-
-	fmt.Println("Cleaning up")
-	os.Remove(fileOut)
-	fmt.Println("> Done")
-
-	fmt.Printf("\n\n\n===== ADDITIONAL STEPS IF USING MultiMC =====\n\n")
-	fmt.Printf("  1) Make sure the 'instance' version of minecraft is: %s\n", config.MCVersion)
-	fmt.Printf("  2) Make sure the 'instance' version of FABRIC is up to date.\n    (%s is bundled with this)", bundledFabricInstaller)
-	fmt.Printf("\n===== ===== ===== ===== ===== ===== ===== =====\n")
-
-	i := 20
-	fmt.Printf("Exiting in ")
-	for {
-		if i <= 0 {
-			fmt.Println("0")
-			break
-		} else {
-			fmt.Printf("%d.", i)
-			time.Sleep(1 * time.Second)
-			i--
-		}
-	}
-}
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Permissions applied to extracted content. The process umask still applies
+// on top of these, and on Windows only the owner-write bit has any effect.
+const (
+	dirPerm  os.FileMode = 0755
+	filePerm os.FileMode = 0644
+)
+
+type ConfFile struct {
+	MCVersion   string `json:"version"`
+	MCDirectory string `json:"directory"`
+	// Mirrors are tried in order whenever the primary download fails.
+	Mirrors []string `json:"mirrors,omitempty"`
+	// Language overrides the language detected from the system, e.g. "de".
+	Language string `json:"language,omitempty"`
+	// Loader is the mod loader the pack is built for: fabric (the
+	// default), quilt or neoforge.
+	Loader string `json:"loader,omitempty"`
+	// LoaderVersion pins the loader release installed. The pack's
+	// loaderVersions win a conflict unless --force-loader is given.
+	LoaderVersion string `json:"loaderVersion,omitempty"`
+	// TemplateValues are the values the player entered for pack templates.
+	// They are never written to the log.
+	TemplateValues map[string]string `json:"templateValues,omitempty"`
+	// ArchiveSHA256 pins the expected content of the pack archive, so that
+	// a mirror can not hand out something different from the primary.
+	ArchiveSHA256 string `json:"sha256,omitempty"`
+	// AllowNonStandardModsDir is a mods directory not named "mods" that
+	// the player confirmed is intended, e.g. a MultiMC instance with a
+	// custom mods folder. The warning stays silent for that directory
+	// only; the other safety checks still apply.
+	AllowNonStandardModsDir string `json:"allowNonStandardModsDir,omitempty"`
+	// ZipNameEncoding is the encoding of archive entry names that aren't
+	// flagged as UTF-8: cp437 (the default) or windows-1252.
+	ZipNameEncoding string `json:"zipNameEncoding,omitempty"`
+	// Network restricts connections to "ipv4" or "ipv6", e.g. behind a
+	// router whose IPv6 is broken. Both are used by default.
+	Network string `json:"network,omitempty"`
+	// Targets are more mods directories updated with the same pack after
+	// "directory", e.g. a test instance. Targets sharing a minecraft
+	// directory are updated one after another.
+	Targets []string `json:"targets,omitempty"`
+	// Telemetry is the player's answer to sending anonymous update
+	// statistics to the pack maintainer, nil while they weren't asked.
+	// InstallID is the random id sent along.
+	Telemetry *bool  `json:"telemetry,omitempty"`
+	InstallID string `json:"installID,omitempty"`
+	// WarnModFiles and WarnModsMB change when extracting a pack that
+	// doesn't declare its contents warns about its size.
+	WarnModFiles int `json:"warnModFiles,omitempty"`
+	WarnModsMB   int `json:"warnModsMB,omitempty"`
+	// Maintenance is opt-in housekeeping of the minecraft directory after
+	// each successful update.
+	Maintenance Maintenance `json:"maintenance,omitempty"`
+	// LockModsDir leaves the mods directories read-only between updates,
+	// so nobody adds or replaces jars by accident.
+	LockModsDir bool `json:"lockModsDir,omitempty"`
+	// Notifications shows desktop notifications when an update starts,
+	// completes or fails. They are on by default only when there is no
+	// terminal, e.g. when run from a scheduled task.
+	Notifications *bool `json:"notifications,omitempty"`
+	// StartJitter delays unattended runs by a random time in this range,
+	// e.g. "0-10m", so a whole community's scheduled updates don't start
+	// in the same minute. Interactive runs start right away.
+	StartJitter string `json:"startJitter,omitempty"`
+	// MaxDuration stops unattended runs that take longer, e.g. "45m",
+	// 30 minutes by default; "0" means no limit. Interactive runs are only
+	// limited by --max-duration.
+	MaxDuration string `json:"maxDuration,omitempty"`
+	// DaemonInterval is how often --daemon updates, e.g. "12h", 6 hours
+	// by default. StatusPort serves the daemon's status page on
+	// 127.0.0.1, 0 for none.
+	DaemonInterval string `json:"daemonInterval,omitempty"`
+	StatusPort     int    `json:"statusPort,omitempty"`
+	// CacheMirror is another updater on the LAN running --serve-cache,
+	// e.g. "http://192.168.1.10:8766". Downloads whose hash is known, a
+	// pack pinned by sha256 and the loader installers, are tried from it
+	// first and verified like any download.
+	CacheMirror string `json:"cacheMirror,omitempty"`
+	// Sources are more pack repositories merged into the mods directory
+	// after the pack, in priority order: a later source's file replaces an
+	// earlier one of the same name.
+	Sources []PackSource `json:"sources,omitempty"`
+	// MaxArchiveEntries is the most entries an archive may have, 20000 by
+	// default.
+	MaxArchiveEntries int `json:"maxArchiveEntries,omitempty"`
+	// Freeze keeps the player on the pack version they have: true, or the
+	// date the freeze ends, e.g. "2024-11-30". Set with --freeze and
+	// --unfreeze.
+	Freeze *Freeze `json:"freeze,omitempty"`
+	// MetricsFile is where update runs write their timings and counters
+	// in the OpenMetrics text format when they end, e.g. into the
+	// directory of node_exporter's textfile collector. A relative path is
+	// relative to the directory of this file. None are written by default.
+	MetricsFile string `json:"metricsFile,omitempty"`
+}
+
+// allowsModsDir reports whether dir may be used without confirming it
+// isn't named like a mods folder.
+func (c ConfFile) allowsModsDir(dir string) bool {
+	return IsStandardModsDir(dir) || (c.AllowNonStandardModsDir != "" && NormalizeDir(c.AllowNonStandardModsDir) == dir)
+}
+
+func isWindows() bool {
+	return os.PathSeparator == '\\' && os.PathListSeparator == ';'
+}
+
+// DownloadFile will download a url to a local file. It's efficient because it will
+// write as it downloads and not load the whole file into memory.
+func DownloadFile(filepath string, url string) error {
+	_, err := downloadFile(filepath, url, "")
+	return err
+}
+
+// downloadFile downloads url into <filepath>.partial and only renames it to
+// filepath once the whole body arrived, is synced to disk and, when
+// expectedSHA256 is set, matches it. A crash therefore never leaves a
+// truncated file under the final name. An interrupted download of the same
+// url is resumed when the server supports range requests.
+func downloadFile(filepath string, url string, expectedSHA256 string) (*Download, error) {
+	if err := DiskFull(); err != nil {
+		return nil, err
+	}
+	partial := filepath + partialSuffix
+	resume := loadResumeState(partial, url)
+
+	// Get the data
+	req, err := http.NewRequestWithContext(runCtx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resume != nil {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resume.Offset))
+		req.Header.Set("If-Range", resume.validator())
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	hash := sha256.New()
+	var out *os.File
+	var offset int64
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && resume != nil && contentRangeStart(resp) == resume.Offset:
+		// the server still has the same file, carry on where we stopped
+		out, err = os.OpenFile(partial, os.O_RDWR, filePerm)
+		if err == nil {
+			offset, err = io.Copy(hash, io.LimitReader(out, resume.Offset))
+		}
+		if err == nil && offset != resume.Offset {
+			err = fmt.Errorf("%s shrank while resuming", partial)
+		}
+		if err != nil {
+			if out != nil {
+				out.Close()
+			}
+			clearResumeState(partial)
+			return nil, err
+		}
+		fmt.Println(T("download.resume", offset/1024/1024))
+		Logf("download: resuming %s at byte %d", url, offset)
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && resume != nil:
+		// nothing past the end of the partial file: a run stopped between
+		// its last byte and the rename left it complete
+		if download := completedPartial(partial, filepath, url, resume, contentRangeTotal(resp), expectedSHA256); download != nil {
+			return download, nil
+		}
+		Logf("download: %s can't be resumed at byte %d, starting over", url, resume.Offset)
+		resp.Body.Close()
+		discardPartial(partial)
+		return downloadFile(filepath, url, expectedSHA256)
+	case resp.StatusCode == http.StatusPartialContent && resume != nil:
+		// a range starting elsewhere than the partial file ends, which
+		// would only fail the same way again
+		Logf("download: %s sent bytes from %d to resume at %d, starting over", url, contentRangeStart(resp), resume.Offset)
+		resp.Body.Close()
+		discardPartial(partial)
+		return downloadFile(filepath, url, expectedSHA256)
+	case resp.StatusCode == http.StatusOK:
+		// fresh download, either nothing to resume or the file changed
+		if resume != nil {
+			Logf("download: %s changed or ignores ranges, starting over", url)
+		}
+		clearResumeState(partial)
+		out, err = os.Create(partial)
+		if err != nil {
+			return nil, err
+		}
+		saveResumeState(partial, url, resp)
+	default:
+		return nil, &statusError{status: resp.StatusCode}
+	}
+
+	// Write the body to file, hashing along the way
+	progress := &progressWriter{done: offset}
+	if resp.ContentLength >= 0 {
+		progress.total = offset + resp.ContentLength
+	}
+	written, err := io.Copy(io.MultiWriter(countingWriter{out}, hash, progress), resp.Body)
+	countDownloaded(written)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if isNoSpace(err) {
+		// nothing to resume from a full disk, the space is given back
+		clearResumeState(partial)
+		os.Remove(partial)
+		return nil, spaceError(filepath, resp.ContentLength-written, err)
+	}
+	if err != nil {
+		// the partial file stays for the next attempt to resume from
+		return nil, err
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return nil, &truncatedError{got: offset + written, want: offset + resp.ContentLength}
+	}
+	total := offset + written
+	contentLength := resp.ContentLength
+	if contentLength >= 0 {
+		contentLength += offset
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if expectedSHA256 != "" && !strings.EqualFold(sum, expectedSHA256) {
+		clearResumeState(partial)
+		os.Remove(partial)
+		return nil, &checksumError{got: sum}
+	}
+
+	if err := renameSynced(partial, filepath); err != nil {
+		return nil, err
+	}
+	clearResumeState(partial)
+	return &Download{
+		URL:           url,
+		Size:          total,
+		ContentLength: contentLength,
+		SHA256:        sum,
+		ETag:          resp.Header.Get("ETag"),
+		LastModified:  resp.Header.Get("Last-Modified"),
+	}, nil
+
+}
+
+// entryPerm normalizes the permission bits of a zip entry. Archives built on
+// Windows frequently carry no permission bits at all, so rather than trusting
+// f.Mode() we only carry over whether the entry was executable.
+func entryPerm(f *zip.File) os.FileMode {
+	if f.Mode()&0111 != 0 {
+		return filePerm | 0111
+	}
+	return filePerm
+}
+
+// Unzip will decompress a zip archive, moving all mod files within the
+// given pack folder of the zip file (parameter 1) to an output directory
+// (parameter 2). An empty folder extracts every legacy mods folder.
+func Unzip(src string, dest string, folder string) ([]string, error) {
+	report, err := unzipMatching(src, dest, func(f *zip.File) bool {
+		return isModEntry(f.Name, folder)
+	}, true)
+	return report.Paths, err
+}
+
+// ExtractReport summarizes an extraction.
+type ExtractReport struct {
+	Files int
+	Bytes int64
+	// Paths are the extracted files, only collected when asked for so
+	// archives with many entries don't pile up names nobody reads. Sizes
+	// are their sizes as the archive declares them.
+	Paths []string
+	Sizes []int64
+	// Failed are the entries that couldn't be read, see
+	// ExtractFailedError.
+	Failed []EntryFailure
+}
+
+// unzipMatching extracts the files of the archive accepted by match,
+// flattened into dest. The paths of the extracted files are part of the
+// report when collectPaths is set.
+func unzipMatching(src string, dest string, match func(f *zip.File) bool, collectPaths bool) (*ExtractReport, error) {
+	return unzipPlaced(src, dest, func(f *zip.File) string {
+		if !match(f) {
+			return ""
+		}
+		return path.Base(strings.Replace(f.Name, "\\", "/", -1))
+	}, collectPaths)
+}
+
+// unzipPlaced extracts the files of the archive to the path below dest
+// place returns for them, skipping those it returns "" for. Entries that
+// can't be read, e.g. failing their checksum, don't stop it: the others are
+// extracted and an ExtractFailedError lists them.
+func unzipPlaced(src string, dest string, place func(f *zip.File) string, collectPaths bool) (*ExtractReport, error) {
+	report := &ExtractReport{}
+
+	r, err := OpenPackArchive(src)
+	if err != nil {
+		return report, err
+	}
+	defer r.Close()
+
+	for i, f := range r.File {
+
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		placed := place(f)
+		if placed == "" {
+			continue
+		}
+		if err := DiskFull(); err != nil {
+			return report, err
+		}
+
+		if _, err := sanitizeEntryPath(f.Name); err != nil {
+			return report, err
+		}
+		rel, err := sanitizeEntryPath(placed)
+		if err != nil {
+			return report, err
+		}
+		fpath := filepath.Join(dest, filepath.FromSlash(rel))
+
+		// Check for ZipSlip. More Info: http://bit.ly/2MsjAWE
+		if !strings.HasPrefix(fpath, filepath.Clean(dest)+string(os.PathSeparator)) {
+			return report, fmt.Errorf("%s: illegal file path", fpath)
+		}
+
+		// Make File
+		if err = os.MkdirAll(filepath.Dir(fpath), dirPerm); err != nil {
+			return report, err
+		}
+
+		outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, entryPerm(f))
+		if err != nil {
+			return report, spaceError(fpath, remainingSize(r.File[i:], place), err)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			outFile.Close()
+			os.Remove(fpath)
+			report.Failed = append(report.Failed, EntryFailure{Name: f.Name, Placed: rel, Size: int64(f.UncompressedSize64), Err: err})
+			continue
+		}
+
+		entry := &entryReader{r: rc}
+		written, err := io.Copy(countingWriter{outFile}, entry)
+
+		// Close the file without defer to close before next iteration of loop
+		if closeErr := outFile.Close(); err == nil {
+			err = closeErr
+		}
+		rc.Close()
+
+		// a damaged entry only costs its own file, the destination failing
+		// costs every one after it
+		if err != nil && err == entry.err {
+			os.Remove(fpath)
+			report.Failed = append(report.Failed, EntryFailure{Name: f.Name, Placed: rel, Size: int64(f.UncompressedSize64), Err: err})
+			continue
+		}
+
+		// the destination failing leaves no part of the file behind, and
+		// stops the extraction
+		if err != nil {
+			os.Remove(fpath)
+			return report, spaceError(fpath, remainingSize(r.File[i:], place), err)
+		}
+
+		report.Files++
+		report.Bytes += written
+		if collectPaths {
+			report.Paths = append(report.Paths, fpath)
+			report.Sizes = append(report.Sizes, int64(f.UncompressedSize64))
+		}
+	}
+	if len(report.Failed) > 0 {
+		return report, &ExtractFailedError{Failed: report.Failed}
+	}
+	return report, nil
+}
+
+// remainingSize adds up the sizes of the entries of files place extracts.
+func remainingSize(files []*zip.File, place func(f *zip.File) string) int64 {
+	var size int64
+	for _, f := range files {
+		if !f.FileInfo().IsDir() && place(f) != "" {
+			size += int64(f.UncompressedSize64)
+		}
+	}
+	return size
+}
+
+func SaveConfig(config ConfFile, jsonConfPath string) {
+	if err := writeConfig(config, jsonConfPath); err != nil {
+		Fatal(err)
+	}
+}
+
+// writeConfig writes config to jsonConfPath, keeping the config it
+// replaces as a generation.
+func writeConfig(config ConfFile, jsonConfPath string) error {
+	if err := rotateGenerations(jsonConfPath); err != nil {
+		Logf("config: keeping the previous %s: %s", jsonConfPath, err)
+	}
+	jsonData, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	// a config cut off by a full disk would lose every setting
+	return writeReplacing(jsonConfPath, jsonData)
+}
+
+// exitFetchFailed reports why the pack couldn't be fetched and exits. Nothing
+// has been changed on disk at that point.
+func exitFetchFailed(err error) {
+	Exit(fetchFailed(err))
+}
+
+// fetchFailed tells why the pack couldn't be fetched and returns err as
+// told.
+func fetchFailed(err error) error {
+	Logf("fatal: %s", err)
+	Notify(T("notify.failed", err))
+	var tooOld *updaterTooOldError
+	var skew *clockSkewError
+	if errors.As(err, &tooOld) {
+		fmt.Println(T("updater.tooold", tooOld.required, Version))
+		fmt.Println(T("updater.get", releasesURL))
+	} else if errors.As(err, &skew) {
+		fmt.Println(skew.Message())
+	} else {
+		fmt.Println(T("fatal.download", err))
+	}
+	return Told(err)
+}
+
+func main() {
+	dirFlag := flag.String("dir", "", "update this mods (or .minecraft) directory without prompting; the saved config is left untouched")
+	yesFlag := flag.Bool("yes", false, "answer yes to every prompt, except typing the name of a mods directory not named \"mods\" (set allowNonStandardModsDir in the config instead)")
+	defaultsFlag := flag.Bool("defaults", false, "answer every prompt with its default, the capital one in [Y/n] or [y/N], without reading anything; unlike --yes it declines what is declined by default")
+	mcVersionFlag := flag.String("mc-version", "", "Minecraft version to update for, instead of the configured one")
+	clearCacheFlag := flag.Bool("clear-cache", false, "remove all cached downloads and exit")
+	serialFlag := flag.Bool("serial", false, "don't prepare the update while downloading (for debugging)")
+	versionFlag := flag.Bool("version", false, "print the updater version and exit")
+	noTelemetryFlag := flag.Bool("no-telemetry", false, "never ask about or send update statistics")
+	bootstrapVanillaFlag := flag.Bool("bootstrap-vanilla", false, "download the vanilla Minecraft files from Mojang when the game was never launched, instead of asking")
+	sourceFlag := flag.String("source", "", "install from this pack archive instead of downloading the pack")
+	lowWriteFlag := flag.Bool("low-write", false, "write as little as possible: files matching the pack are left in place and only the last update's backup is kept")
+	noBackupFlag := flag.Bool("no-backup", false, "delete the mods the update replaces instead of backing them up, after confirming (unattended runs need --yes); the update can't be rolled back")
+	verboseFlag := flag.Bool("verbose", false, "print every warning instead of the first few of each kind, and how fast the connection to the pack is before downloading it")
+	stageOnlyFlag := flag.Bool("stage-only", false, "download and verify the pack and stage it for the next run, the pre-launch check or --apply-staged to apply without downloading, and exit")
+	applyStagedFlag := flag.Bool("apply-staged", false, "apply the staged update without downloading anything, failing when there is none")
+	discardStagedFlag := flag.Bool("discard-staged", false, "throw away the staged update and exit")
+	portableFlag := flag.String("portable", "", "keep the config, logs and downloads in this directory instead of the user's config directory, like a "+portableMarker+" file next to the updater does")
+	noProbeFlag := flag.Bool("no-probe", false, "don't measure the connection in diagnose or with --verbose")
+	daemonFlag := flag.Bool("daemon", false, "keep running and update every --interval, each update as if run with --yes")
+	intervalFlag := flag.Duration("interval", 0, "time between updates with --daemon (default the config's daemonInterval, or 6h)")
+	statusPortFlag := flag.Int("status-port", 0, "serve the daemon's status as JSON on this port of 127.0.0.1 (default the config's statusPort, none if unset)")
+	freezeArg := &freezeFlag{}
+	flag.Var(freezeArg, "freeze", "stay on the pack version installed now, until --unfreeze or until the date given, e.g. --freeze=2024-11-30, and exit")
+	unfreezeFlag := flag.Bool("unfreeze", false, "end a freeze and exit, the next run updates again")
+	unpinFlag := flag.Bool("unpin", false, "end the pin to the pack version \"repair --pack-version\" installed and exit, the next run updates again")
+	skipConfigsFlag := flag.Bool("skip-configs", false, "update the mods but leave the pack's files in config, resourcepacks, shaderpacks and the game settings alone for this run, the next update offers them again")
+	applyRecommendedFlag := flag.Bool("apply-recommended-settings", false, "overwrite your game settings with the values the pack recommends, after confirming them")
+	serveCacheFlag := flag.String("serve-cache", "", "serve the download cache read-only to the LAN at this address, e.g. :8766, for other players' cacheMirror setting, and exit when interrupted")
+	noTUIFlag := flag.Bool("no-tui", false, "never show the menu, update right away as when started with arguments")
+	forceLoaderFlag := flag.Bool("force-loader", false, "install the loader release of the config's loaderVersion even when the pack requires another")
+	strictPackFlag := flag.Bool("strict-pack", false, "fail when the pack has jars outside its mods folders or junk such as .DS_Store and editor backups instead of warning about them, for the pack repository's CI")
+	allowUnverifiedInstallerFlag := flag.Bool("allow-unverified-installer", false, "run a loader installer even when no SHA-256 is published or known for it to verify it against")
+	jsonFlag := flag.Bool("json", false, "leave standard output to programs: everything else goes to standard error, a failure ends with a JSON line there describing it, and up to date (10) and updated with warnings (11) get exit codes of their own")
+	maxDurationFlag := flag.Duration("max-duration", 0, "stop the update if it takes longer than this, e.g. 20m (unattended runs stop after the config's maxDuration, 30m by default)")
+	flag.Usage = printUsage
+	flag.Parse()
+	if *jsonFlag {
+		EnableJSONOutput()
+	}
+	strictPack = *strictPackFlag
+	allowUnverifiedInstaller = *allowUnverifiedInstallerFlag
+	defer ReportOutcome()
+	if *versionFlag {
+		fmt.Println("clientUpdater " + VersionString())
+		return
+	}
+	// run as a pre-launch command of MultiMC or Prism Launcher, the
+	// instance is updated without asking and with its java; without
+	// anything on the command line the run is the quick prelaunch check,
+	// the launch waits for it
+	instance := LauncherInstanceFromEnv(os.Getenv)
+	if instance != nil {
+		if *dirFlag == "" {
+			*dirFlag = instance.MCDir
+		}
+		if instance.Java != "" {
+			javaCommand = instance.Java
+		}
+		if flag.NFlag() == 0 && flag.NArg() == 0 {
+			flag.CommandLine.Parse([]string{"prelaunch"})
+		}
+	}
+	interactive := *dirFlag == "" && !*yesFlag
+	SetLanguage(SystemLocale())
+
+	// everything the updater writes goes below one directory, never next
+	// to the executable unless asked to: it may be on a read-only share
+	location, err := ResolveStateDir(SystemStateDirInputs(*portableFlag))
+	if err != nil {
+		Fatal(err)
+	}
+	statePath := func(name string) string {
+		return filepath.Join(location.Dir, name)
+	}
+	fileURL := "https://github.com/rx13/rxmc-Mods/archive/master.zip"
+	fileOut := statePath("serverMods-master.zip")
+	jsonConfPath := statePath("clientUpdate.json")
+	logPath := statePath("clientUpdate.log")
+	journalPath := statePath("clientUpdate-journal.jsonl")
+	backupsPath := statePath(backupsDirName)
+	installedPath := statePath("clientUpdate-installed.json")
+	exportPath := statePath("clientUpdate-export.json")
+	mergedPath := statePath("clientUpdate-merged.zip")
+	statusPath := statePath("clientUpdate-status.json")
+	recoveryPath := statePath("clientUpdate-recovery")
+	fetchStatePath := statePath("clientUpdate-fetch.json")
+	cleanupPath := statePath("clientUpdate-cleanup.json")
+	stagedPath := statePath("clientUpdate-staged.json")
+	stagedArchivePath := statePath("clientUpdate-staged.zip")
+	failuresRecordPath := statePath("clientUpdate-failures.json")
+	SetVerboseWarnings(*verboseFlag)
+
+	// the first argument is a subcommand, or a pack archive dropped onto
+	// the updater, which is installed like one given with --source
+	localArchive := *sourceFlag
+	switch mode := flag.Arg(0); {
+	case mode == "" || updaterModes[mode]:
+	case localArchive == "" && flag.NArg() == 1 && isPackArchiveArg(mode):
+		localArchive = mode
+	default:
+		fmt.Println(T("usage.unknown", strings.Join(flag.Args(), " ")))
+		printUsage()
+		Exit(Failure(categoryUsage, errors.New(T("usage.unknown", strings.Join(flag.Args(), " ")))))
+	}
+
+	// started from a terminal without anything on the command line, e.g.
+	// by double-clicking, the player picks what to do from a menu; each
+	// action runs its mode as if given on the command line, and without a
+	// raw terminal the update runs as always
+	if !*noTUIFlag && instance == nil && flag.NFlag() == 0 && flag.NArg() == 0 && hasTerminal() {
+		mode, quit := runMenu(func() (string, bool) { return RunMenu(os.Stdin, os.Stdout) }, logPath)
+		if quit {
+			return
+		}
+		if mode == "" {
+			ShowProgressLine(os.Stdout)
+		} else {
+			flag.CommandLine.Parse([]string{mode})
+		}
+	}
+
+	// a pre-launch command's output only gets in the launcher's way,
+	// everything goes to the log; launchers setting the instance variables
+	// show it in their log pane, plain lines are fine there
+	if flag.Arg(0) == "prelaunch" && instance == nil {
+		if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+			os.Stdout = devNull
+		}
+	}
+
+	logFile, err := OpenRunLog(logPath)
+	if err != nil {
+		fmt.Println(T("log.unavailable", logPath, err))
+	} else {
+		defer logFile.Close()
+	}
+	defer ResetFailures()
+	defer ReportCrash()
+	Logf("state directory %s, %s (%s)", location.Dir, location.Mode, location.Reason)
+	if location.Mode == statePortable {
+		fmt.Println(T("statedir.portable", location.Dir))
+	}
+	if location.Legacy != "" {
+		TakeOverLegacyState(location)
+		fmt.Println(T("statedir.legacy", location.Legacy, location.Dir))
+	}
+	if instance != nil {
+		Logf("launcher instance %q: minecraft directory %s, java %q, minecraft %q", instance.ID, instance.MCDir, instance.Java, instance.MCVersion)
+	}
+	if p := os.Getenv(statusFileEnv); p != "" {
+		PublishStatus(p)
+	}
+
+	cache := &Cache{Dir: DefaultCacheDir()}
+	if *clearCacheFlag {
+		if err := cache.Clear(); err != nil {
+			Fatal(err)
+		}
+		fmt.Println(T("cache.cleared", cache.Dir))
+		return
+	}
+	if *serveCacheFlag != "" {
+		if err := ServeCache(cache, *serveCacheFlag); err != nil {
+			Fatal(err)
+		}
+		return
+	}
+
+	switch flag.Arg(0) {
+	case "history":
+		if err := PrintHistory(journalPath); err != nil {
+			Fatal(err)
+		}
+		return
+	case "restore":
+		if flag.Arg(1) == "" {
+			FailWith(categoryUsage, T("restore.usage"))
+		}
+		if err := RestoreFile(journalPath, flag.Arg(1), flag.Arg(2)); err != nil {
+			Fatal(err)
+		}
+		return
+	case "diff":
+		// compares two pack versions for maintainers, no minecraft
+		// directory is looked at
+		diffFlags := flag.NewFlagSet("diff", flag.ExitOnError)
+		fromFlag := diffFlags.String("from", "", "pack tag, commit, archive or checkout to compare from")
+		toFlag := diffFlags.String("to", "master", "pack tag, commit, archive or checkout to compare to")
+		jsonFlag := diffFlags.Bool("json", false, "print the difference as JSON")
+		diffFlags.Parse(flag.Args()[1:])
+		if *fromFlag == "" {
+			FailWith(categoryUsage, T("diff.usage"))
+		}
+		diff, err := DiffPacks(*fromFlag, *toFlag)
+		if err != nil {
+			Fatal(err)
+		}
+		if *jsonFlag || jsonOutput {
+			out, _ := json.MarshalIndent(diff, "", "  ")
+			fmt.Fprintln(machineOut, string(out))
+		} else {
+			diff.Print()
+		}
+		return
+	case "lint-manifest":
+		// checks a manifest before it is published, for maintainers
+		lintFlags := flag.NewFlagSet("lint-manifest", flag.ExitOnError)
+		jsonFlag := lintFlags.Bool("json", false, "print the problems as JSON")
+		lintFlags.Parse(flag.Args()[1:])
+		if lintFlags.NArg() != 1 {
+			FailWith(categoryUsage, T("lint.usage"))
+		}
+		lint, err := LintManifest(lintFlags.Arg(0))
+		if err != nil {
+			Fatal(err)
+		}
+		if *jsonFlag || jsonOutput {
+			out, _ := json.MarshalIndent(lint, "", "  ")
+			fmt.Fprintln(machineOut, string(out))
+		} else {
+			lint.Print()
+		}
+		if len(lint.Problems) > 0 {
+			Exit(&ManifestError{Problems: lint.Problems})
+		}
+		return
+	}
+
+	// "plan" works the update out and saves it for review instead of
+	// carrying it out, "apply" carries out such a plan as long as nothing
+	// changed since
+	var planOut string
+	var reviewed *PlanFile
+	switch flag.Arg(0) {
+	case "plan":
+		planFlags := flag.NewFlagSet("plan", flag.ExitOnError)
+		outFlag := planFlags.String("out", statePath("clientUpdate-plan.json"), "file the plan is saved to")
+		planFlags.Parse(flag.Args()[1:])
+		planOut = *outFlag
+	case "apply":
+		if flag.Arg(1) == "" {
+			FailWith(categoryUsage, T("apply.usage"))
+		}
+		if reviewed, err = ReadPlanFile(flag.Arg(1)); err != nil {
+			Fatal(err)
+		}
+	}
+
+	// files earlier runs couldn't remove, usually because another program
+	// had them open, are removed first
+	cleanupListPath = cleanupPath
+	SweepCleanupList()
+	CleanStalePartials(location.Dir)
+	CleanStalePartials(filepath.Join(cache.Dir, "installers"))
+
+	runID := NewRunID(clock.Now())
+	Logf("starting update, run %s, updater %s", runID, VersionString())
+	// backups of a mods directory stay on its volume, and low-write mode
+	// keeps a single backup, replaced by every update, instead of one per
+	// run
+	runBackups := func(dir string) string {
+		if *lowWriteFlag {
+			return filepath.Join(BackupsRoot(backupsPath, dir), lowWriteBackups)
+		}
+		return filepath.Join(BackupsRoot(backupsPath, dir), runID)
+	}
+
+	// set base module path for vanilla
+	modPath := filepath.Join(baseDirs.Home, ".minecraft", "mods")
+	if isWindows() {
+		modPath = filepath.Join(baseDirs.Config, ".minecraft", "mods")
+	}
+
+	// load and set config file if not present
+	config, configProblems, err := ReadConfig(jsonConfPath)
+	switch {
+	case os.IsNotExist(err):
+		fmt.Println(T("config.missing", jsonConfPath))
+		config = ConfFile{MCVersion: defaultMCVersion, MCDirectory: modPath}
+		SaveConfig(config, jsonConfPath)
+	case err != nil:
+		Fatal(err)
+	case len(configProblems) > 0:
+		// the version is corrected once the pack is known, the directory
+		// by the picker; saving the corrections drops whatever else was
+		// broken, so the original is kept
+		fmt.Println(T("config.problems", jsonConfPath))
+		for _, problem := range configProblems {
+			fmt.Println("  - " + problem)
+			Logf("config: %s", problem)
+		}
+		if err := copyFile(jsonConfPath, jsonConfPath+".broken"); err == nil {
+			fmt.Println(T("config.broken.kept", jsonConfPath+".broken"))
+		}
+	}
+
+	if config.Language != "" {
+		SetLanguage(config.Language)
+	}
+	if err := SetZipNameEncoding(config.ZipNameEncoding); err != nil {
+		Fatal(err)
+	}
+	if err := SetNetworkPreference(config.Network); err != nil {
+		Fatal(err)
+	}
+	if err := SetCacheMirror(config.CacheMirror); err != nil {
+		Fatal(err)
+	}
+	SetMaxArchiveEntries(config.MaxArchiveEntries)
+	SetupNotifications(config.Notifications)
+	if config.TemplateValues == nil {
+		config.TemplateValues = map[string]string{}
+	}
+	for _, value := range config.TemplateValues {
+		AddLogSecret(value)
+	}
+	if freezeArg.freeze != nil || *unfreezeFlag {
+		if err := runFreeze(config, freezeArg.freeze, *unfreezeFlag, jsonConfPath); err != nil {
+			Fatal(err)
+		}
+		return
+	}
+	if *discardStagedFlag {
+		if err := runDiscardStaged(stagedPath); err != nil {
+			Fatal(err)
+		}
+		return
+	}
+	if *stageOnlyFlag && (len(config.Sources) > 0 || localArchive != "") {
+		FailWith(categoryUsage, T("stage.sources"))
+	}
+	if *unpinFlag {
+		if err := PinInstalledState(installedPath, ""); err != nil {
+			Fatal(err)
+		}
+		Logf("unpinned")
+		fmt.Println(T("pin.unset"))
+		return
+	}
+
+	// the daemon only schedules, every update runs in a process of its own
+	if *daemonFlag {
+		if flag.Arg(0) != "" {
+			FailWith(categoryUsage, T("daemon.mode", flag.Arg(0)))
+		}
+		interval := *intervalFlag
+		if interval == 0 {
+			interval = defaultDaemonInterval
+			if config.DaemonInterval != "" {
+				if interval, err = time.ParseDuration(config.DaemonInterval); err != nil || interval <= 0 {
+					Fatal(fmt.Errorf("daemonInterval %q is not a duration", config.DaemonInterval))
+				}
+			}
+		}
+		port := *statusPortFlag
+		if port == 0 {
+			port = config.StatusPort
+		}
+		if err := RunDaemon(interval, port, logPath, installedPath, statusPath, jsonConfPath, stagedPath); err != nil {
+			Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "settings" {
+		if err := runSettings(config, NewPrompter(os.Stdin, *defaultsFlag), jsonConfPath); err != nil {
+			Fatal(err)
+		}
+		return
+	}
+
+	// command line overrides apply to this run only, savedConfig is what
+	// gets written back to disk
+	savedConfig := config
+	if *mcVersionFlag != "" {
+		config.MCVersion = *mcVersionFlag
+	} else if instance != nil && instance.MCVersion != "" {
+		config.MCVersion = instance.MCVersion
+	}
+	if *dirFlag != "" {
+		config.MCDirectory, err = ResolveModsDir(*dirFlag)
+		if err != nil {
+			fmt.Println(T("fatal.dir", err))
+			Exit(err)
+		}
+	}
+	prompter := NewPrompter(os.Stdin, *defaultsFlag)
+
+	// update runs failing the same way again and again get noticed: the
+	// player is told, and what may fix the failure is tried once
+	if flag.Arg(0) == "" {
+		if config.MetricsFile != "" {
+			metricsFile := config.MetricsFile
+			if !filepath.IsAbs(metricsFile) {
+				metricsFile = filepath.Join(filepath.Dir(jsonConfPath), metricsFile)
+			}
+			EnableMetrics(metricsFile)
+		}
+		if failures := TrackFailures(failuresRecordPath); failures != nil {
+			Logf("failures: %s", describeFailures(failures))
+			escalation := Escalate(failures, escalationRules)
+			if escalation.Banner && interactive {
+				fmt.Println(failures.Banner())
+				fmt.Println()
+			}
+			for _, remedy := range escalation.Remedies {
+				switch {
+				case remedy == remedyClearCache:
+					fmt.Println(T("failures.remedy.cache"))
+					if err := cache.Clear(); err != nil {
+						Logf("failures: clearing the cache: %s", err)
+					}
+					DiscardFetched(fetchStatePath)
+				case remedy == remedyPickDir && interactive && prompter.Asks():
+					fmt.Println(T("failures.remedy.pickdir", config.MCDirectory))
+					dir, err := PickModsDir(prompter)
+					if err != nil {
+						Fatal(err)
+					}
+					config.MCDirectory, savedConfig.MCDirectory = dir, dir
+					SaveConfig(savedConfig, jsonConfPath)
+				default:
+					// nobody to ask, the next interactive run tries it
+					continue
+				}
+				Logf("failures: tried %s", remedy)
+				MarkRemedied(failures, remedy)
+			}
+		}
+	}
+
+	// make sure the directory is usable before doing anything with it
+	config.MCDirectory = NormalizeDir(config.MCDirectory)
+	dirStatus := CheckModsDir(config.MCDirectory)
+	fmt.Println(T("modsdir.status", config.MCDirectory, dirStatus))
+	Logf("mods directory %q: exists=%t writable=%t err=%v", config.MCDirectory, dirStatus.Exists, dirStatus.Writable, dirStatus.Err)
+	if dirStatus.Err != nil {
+		ExplainAccessError(dirStatus.Err)
+	}
+	if flag.Arg(0) == "diagnose" {
+		// meant for broken installations, so nothing about the directory
+		// is required
+		loader, _ := LoaderByName(config.Loader, cache)
+		fmt.Println(T("diagnose.network"))
+		network := DiagnoseNetwork(append([]string{fileURL}, config.Mirrors...))
+		fmt.Print(network)
+		if !*noProbeFlag {
+			var probes []*NetworkProbe
+			for _, source := range append([]string{fileURL}, config.Mirrors...) {
+				probe := ProbeNetwork(runCtx, source)
+				Logf("probe: %s", probe)
+				fmt.Println(T("diagnose.probe", source, T("probe."+probe.Class), probe.FirstByte.Round(time.Millisecond), probe.Throughput()/1024))
+				probes = append(probes, probe)
+			}
+			network += DescribeProbes(probes)
+		}
+		bundle, err := WriteDiagnoseBundle(location.Dir, DiagnoseInputs{
+			Network:       network,
+			Config:        config,
+			LogPath:       logPath,
+			InstalledPath: installedPath,
+			ModPath:       config.MCDirectory,
+			MinecraftPath: filepath.Dir(config.MCDirectory),
+			Loader:        loader,
+		})
+		if err != nil {
+			Fatal(err)
+		}
+		if abs, err := filepath.Abs(bundle); err == nil {
+			bundle = abs
+		}
+		fmt.Println(T("diagnose.done", bundle))
+		return
+	}
+	if dirStatus.Err == nil && dirStatus.Exists {
+		// repairs and pre-launch checks need a record of what is installed;
+		// without a pack commit in it the next update installs the pack again
+		if rebuilt, err := RebuildInstalledState(installedPath, config.MCDirectory, config.MCVersion, config.Loader); err != nil {
+			Logf("state: rebuilding %s: %s", installedPath, err)
+		} else if rebuilt {
+			Warn(warnState, T("state.rebuilt", config.MCDirectory))
+		}
+	}
+	// a staged update is applied right before the game starts, it takes
+	// seconds; the check is left to the next start
+	applyingStaged := false
+	if flag.Arg(0) == "prelaunch" {
+		if staged, _ := ReadStagedUpdate(stagedPath); staged != nil {
+			Logf("prelaunch: applying the staged update %s", staged.Label())
+			applyingStaged = true
+		}
+	}
+	if flag.Arg(0) == "prelaunch" && !applyingStaged {
+		// the game is about to start: nothing is asked and nothing waits
+		// longer than the network budget
+		state, err := ReadInstalledState(installedPath)
+		if err != nil || state == nil || dirStatus.Err != nil || !dirStatus.Exists {
+			Logf("prelaunch: nothing to check, installed state %v, error %v", state != nil, err)
+			return
+		}
+		// the pack's mods crash a game of another version, so the launch
+		// is stopped rather than started anyway
+		if instance != nil && instance.MCVersion != "" && state.MCVersion != "" && instance.MCVersion != state.MCVersion {
+			Logf("prelaunch: the instance runs minecraft %s, the pack was installed for %s", instance.MCVersion, state.MCVersion)
+			FailWith(categoryLocal, T("prelaunch.mcversion", instance.MCVersion, state.MCVersion))
+		}
+		runID := NewRunID(clock.Now())
+		ctx, cancel := context.WithTimeout(context.Background(), prelaunchNetworkBudget)
+		report := Prelaunch(ctx, state, config.MCDirectory, filepath.Join(BackupsRoot(backupsPath, config.MCDirectory), runID), &Journal{Path: journalPath, Run: runID, Pack: packLabel(state.PackVersion, state.PackCommit)}, packHeadURL)
+		cancel()
+		Logf("prelaunch: %d pack files damaged (%s), %d restored", len(report.Damaged), strings.Join(report.Damaged, ", "), len(report.Restored))
+		if len(report.Restored) > 0 {
+			fmt.Println(T("prelaunch.restored", len(report.Restored)))
+		}
+		switch {
+		case report.Offline:
+			Logf("prelaunch: the pack couldn't be checked, verified locally only")
+		case report.Changed && UpdateHold(config.Freeze, state, clock.Now()) != holdNone:
+			Logf("prelaunch: the pack changed since the last update, staying on it while %s", UpdateHold(config.Freeze, state, clock.Now()))
+		case report.Changed:
+			Logf("prelaunch: the pack changed since the last update, run the updater")
+			fmt.Println(T("prelaunch.changed"))
+		}
+		if report.Unrepaired() {
+			Logf("prelaunch: files are still missing, run the updater or \"repair\"")
+			FailWith(categoryLocal, T("prelaunch.unrepaired", len(report.Damaged)-len(report.Restored)))
+		}
+		fmt.Println(T("prelaunch.ok"))
+		return
+	}
+	if mode := flag.Arg(0); mode == "export" || mode == "import" || mode == "repair" || mode == "verify" || mode == "migrate" || mode == "rollback" {
+		// never pick or create another directory here, importing into the
+		// wrong one would wipe it
+		if dirStatus.Err != nil || !dirStatus.Exists || !config.allowsModsDir(config.MCDirectory) {
+			FailWith(categoryLocal, T("import.unsafe", config.MCDirectory))
+		}
+	}
+	if mode := flag.Arg(0); mode == "verify" || mode == "repair" {
+		versionFlags := flag.NewFlagSet(mode, flag.ExitOnError)
+		packVersionFlag := versionFlags.String("pack-version", "", "compare with this release of the pack, e.g. v1.4, instead of the last update; repair pins it until --unpin")
+		versionFlags.Parse(flag.Args()[1:])
+		state, err := ReadInstalledState(installedPath)
+		if err == nil && state == nil {
+			err = fmt.Errorf("no update has been recorded yet, run a normal update first")
+		}
+		if err != nil {
+			Fatal(err)
+		}
+		if *packVersionFlag != "" {
+			if err := runPackVersion(mode, *packVersionFlag, config, state, cache, fileURL, fileOut, installedPath, journalPath, BackupsRoot(backupsPath, config.MCDirectory), runID); err != nil {
+				Fatal(err)
+			}
+			return
+		}
+	}
+	if flag.Arg(0) == "verify" {
+		state, _ := ReadInstalledState(installedPath)
+		fmt.Println(T("repair.verify", config.MCDirectory))
+		damaged := VerifyInstalled(state, config.MCDirectory)
+		for _, file := range damaged {
+			fmt.Println(T("verify.differs", file.Name))
+		}
+		Logf("verify: %d files missing or changed", len(damaged))
+		if len(damaged) > 0 {
+			Exit(Failure(categoryLocal, fmt.Errorf("%d files differ from the last update, run \"repair\"", len(damaged))))
+		}
+		fmt.Println(T("verify.ok"))
+		return
+	}
+	if flag.Arg(0) == "repair" {
+		state, _ := ReadInstalledState(installedPath)
+		fmt.Println(T("repair.verify", config.MCDirectory))
+		urls := packURLs(state.PackCommit, fileURL, config.Mirrors)
+		if err := unlockForUpdate(config.MCDirectory); err != nil {
+			Fatal(err)
+		}
+		repaired, failed, err := Repair(state, config.MCDirectory, urls, fileOut, filepath.Join(BackupsRoot(backupsPath, config.MCDirectory), runID), &Journal{Path: journalPath, Run: runID, Pack: packLabel(state.PackVersion, state.PackCommit)})
+		for _, name := range repaired {
+			fmt.Println(T("repair.fixed", name))
+		}
+		PrintRepairFailed(state, failed)
+		Logf("repair: %d repaired, %d failed, error %v", len(repaired), len(failed), err)
+		relockAfterUpdate(config.MCDirectory, config.LockModsDir)
+		if err != nil {
+			Fatal(err)
+		}
+		if len(failed) > 0 {
+			Exit(Failure(categoryLocal, fmt.Errorf("%d files couldn't be repaired", len(failed))))
+		}
+		if len(repaired) == 0 {
+			fmt.Println(T("repair.ok"))
+		}
+		return
+	}
+	if flag.Arg(0) == "rollback" {
+		if err := runRollback(config, installedPath, journalPath, runID); err != nil {
+			Fatal(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "owned" {
+		ownedFlags := flag.NewFlagSet("owned", flag.ExitOnError)
+		jsonFlag := ownedFlags.Bool("json", false, "print the report as JSON")
+		ownedFlags.Parse(flag.Args()[1:])
+		state, err := ReadInstalledState(installedPath)
+		var entries []JournalEntry
+		if err == nil {
+			entries, err = ReadJournal(journalPath)
+		}
+		var report *OwnedReport
+		if err == nil {
+			report, err = OwnedFiles(state, entries, config.MCDirectory)
+		}
+		if err != nil {
+			Fatal(err)
+		}
+		if *jsonFlag || jsonOutput {
+			out, _ := json.MarshalIndent(report, "", "  ")
+			fmt.Fprintln(machineOut, string(out))
+		} else {
+			report.Print()
+		}
+		return
+	}
+	if flag.Arg(0) == "why" {
+		whyFlags := flag.NewFlagSet("why", flag.ExitOnError)
+		jsonFlag := whyFlags.Bool("json", false, "print the answer as JSON")
+		whyFlags.Parse(flag.Args()[1:])
+		if whyFlags.Arg(0) == "" {
+			FailWith(categoryUsage, T("why.usage"))
+		}
+		state, err := ReadInstalledState(installedPath)
+		var entries []JournalEntry
+		if err == nil {
+			entries, err = ReadJournal(journalPath)
+		}
+		var report *OwnedReport
+		if err == nil {
+			report, err = OwnedFiles(state, entries, config.MCDirectory)
+		}
+		var file OwnedFile
+		if err == nil {
+			file, err = FindOwned(report, strings.Join(whyFlags.Args(), " "))
+		}
+		if err != nil {
+			Fatal(err)
+		}
+		if *jsonFlag || jsonOutput {
+			out, _ := json.MarshalIndent(file, "", "  ")
+			fmt.Fprintln(machineOut, string(out))
+		} else {
+			PrintWhy(report, file)
+		}
+		return
+	}
+	if flag.Arg(0) == "export" {
+		if flag.Arg(1) != "" {
+			exportPath = flag.Arg(1)
+		}
+		if err := runExport(config, cache, installedPath, exportPath); err != nil {
+			Fatal(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "import" {
+		if flag.Arg(1) == "" {
+			FailWith(categoryUsage, T("import.usage"))
+		}
+		// with --dir the saved config is left untouched
+		var saved *ConfFile
+		if *dirFlag == "" {
+			saved = &savedConfig
+		}
+		if err := runImport(flag.Arg(1), config, saved, prompter, *yesFlag, cache, fileURL, fileOut, installedPath, journalPath, BackupsRoot(backupsPath, config.MCDirectory), runID, jsonConfPath); err != nil {
+			Fatal(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "migrate" {
+		migrateFlags := flag.NewFlagSet("migrate", flag.ExitOnError)
+		nameFlag := migrateFlags.String("name", defaultInstanceName, "name of the new instance")
+		instancesFlag := migrateFlags.String("instances", "", "instances folder of Prism Launcher or MultiMC, found automatically when empty")
+		moveFlag := migrateFlags.Bool("move", false, "move the files into the instance instead of copying them")
+		migrateFlags.Parse(flag.Args()[1:])
+		instancesDir := *instancesFlag
+		if instancesDir == "" {
+			dirs := InstancesDirs()
+			if len(dirs) == 0 {
+				FailWith(categoryLocal, T("migrate.nolauncher"))
+			}
+			instancesDir = dirs[0]
+		}
+		loader, err := LoaderByName(config.Loader, cache)
+		if err != nil {
+			Fatal(err)
+		}
+		migration, err := PlanMigration(config.MCDirectory, instancesDir, *nameFlag, config.MCVersion, loader)
+		if err != nil {
+			Fatal(err)
+		}
+		migration.Move = *moveFlag
+		migration.Print()
+
+		confirmed := false
+		if *yesFlag {
+			confirmed = prompter.Assume(T("migrate.confirm"), false, true)
+		} else {
+			confirmed = prompter.Confirm(T("migrate.confirm"), false)
+		}
+		if !confirmed {
+			fmt.Println(T("migrate.cancelled"))
+			return
+		}
+		// the old mods directory gets the note pointing to the instance
+		if err := unlockForUpdate(config.MCDirectory); err != nil {
+			Fatal(err)
+		}
+		err = migration.Execute()
+		relockAfterUpdate(config.MCDirectory, config.LockModsDir && (err != nil || !*moveFlag))
+		if err != nil {
+			Logf("migration failed: %s", err)
+			Fatal(err)
+		}
+		relockAfterUpdate(migration.ModPath(), config.LockModsDir)
+		if *dirFlag == "" {
+			savedConfig.MCDirectory = migration.ModPath()
+			SaveConfig(savedConfig, jsonConfPath)
+		}
+		Logf("migrated %s to %s", config.MCDirectory, migration.InstanceDir)
+		fmt.Println(T("migrate.done", migration.InstanceDir))
+		return
+	}
+	// a missing directory is only created when asked to: --dir counts as
+	// asking, a saved directory that vanished usually means the instance
+	// was deleted
+	if dirStatus.Err != nil || (!dirStatus.Exists && *dirFlag == "") {
+		if !interactive {
+			err := dirStatus.Err
+			if err == nil {
+				err = errors.New(T("modsdir.missing", config.MCDirectory))
+				fmt.Println(err)
+			}
+			fmt.Println(T("exiting"))
+			Exit(&modsDirError{dir: config.MCDirectory, err: err})
+		}
+		config.MCDirectory, err = ResolveMissingModsDir(prompter, dirStatus)
+		if err != nil {
+			Fatal(err)
+		}
+	}
+	if *dirFlag == "" && config.MCDirectory != savedConfig.MCDirectory {
+		savedConfig.MCDirectory = config.MCDirectory
+		SaveConfig(savedConfig, jsonConfPath)
+	}
+	Logf("minecraft %s, mods directory %s", config.MCVersion, config.MCDirectory)
+
+	// set common needs for module handling
+	modPath = config.MCDirectory
+	Recover(recoveryPath, prompter, interactive, journalPath, installedPath)
+	// a frozen player stays on the pack version of the last update, which
+	// is only verified and repaired; scheduled runs just log it
+	if config.Freeze != nil && !config.Freeze.Active(clock.Now()) {
+		Logf("the freeze ended %s", config.Freeze.Until.Format(time.RFC3339))
+		fmt.Println(T("freeze.expired"))
+		savedConfig.Freeze = nil
+		SaveConfig(savedConfig, jsonConfPath)
+	}
+	// so is a player pinned to a pack version, before and after a freeze
+	state, err := ReadInstalledState(installedPath)
+	if hold := UpdateHold(config.Freeze, state, clock.Now()); hold != holdNone {
+		if err != nil {
+			Logf("%s: reading the installed state: %s", hold, err)
+		}
+		if err := runHeld(hold, config, state, interactive, *noTelemetryFlag, fileURL, fileOut, journalPath, runBackups(modPath), runID); err != nil {
+			Fatal(err)
+		}
+		return
+	}
+	PrintLeftovers(FindLeftovers(modPath, filepath.Dir(fileOut)))
+	// a staged update is applied from disk without downloading anything,
+	// unless the pack moved on since it was staged
+	var staged *StagedUpdate
+	if localArchive == "" && !*stageOnlyFlag && planOut == "" && reviewed == nil {
+		staged = pendingStaged(stagedPath, *applyStagedFlag || applyingStaged)
+	}
+	if *applyStagedFlag && staged == nil {
+		FailWith(categoryLocal, T("stage.none"))
+	}
+	// the limit counts the jitter too, and Ctrl-C interrupts its wait
+	maxDuration := *maxDurationFlag
+	if maxDuration == 0 && !interactive {
+		maxDuration = defaultUnattendedMaxDuration
+		if config.MaxDuration != "" {
+			if maxDuration, err = time.ParseDuration(config.MaxDuration); err != nil {
+				Fatal(fmt.Errorf("maxDuration %q: %s", config.MaxDuration, err))
+			}
+		}
+	}
+	StartRunLimits(maxDuration)
+	if config.StartJitter != "" && !interactive && staged == nil {
+		if err := WaitJitter(runCtx, config.StartJitter); err != nil {
+			Fatal(err)
+		}
+	}
+
+	loader, err := LoaderByName(config.Loader, cache)
+	if err != nil {
+		Fatal(err)
+	}
+
+	// hashing the current mods and looking for the loader only reads from
+	// disk, so it can overlap with the download, and so can looking up the
+	// metadata installing the loader needs
+	// launchers with profiles keep the game's versions further up than
+	// the parent of the mods directory, the last update recorded where
+	previous, _ := ReadInstalledState(installedPath)
+	var prepared <-chan *Preparation
+	if !*serialFlag {
+		root := ResolveMinecraftRoot(modPath, previous)
+		prepared = PrepareAsync(modPath, filepath.Join(root, "versions"), loader, config.MCVersion)
+		go PrefetchMetadata(cache, loader, root, config.MCVersion, *bootstrapVanillaFlag)
+	}
+
+	SetPhase(phaseDownload)
+	// what an interrupted run with the same plan fetched is used again
+	fetcher := NewFetcher(fetchStatePath, FetchPlan(config, fileURL))
+	var archive *PackArchive
+	downloadStart := clock.Now()
+	if staged != nil {
+		// the disk may have changed since, what is applied is verified
+		// again
+		archive, err = staged.Verify(config.MCVersion)
+		if err != nil {
+			Logf("staged: %s", err)
+			if err := DiscardStaged(stagedPath); err != nil {
+				Logf("staged: %s", err)
+			}
+			if *applyStagedFlag || applyingStaged {
+				StopPreparing(prepared)
+				Fatal(err)
+			}
+			fmt.Println(T("stage.invalid", err))
+			staged = nil
+		} else {
+			fmt.Println(T("stage.apply", staged.Label(), staged.StagedAt.Format("2006-01-02 15:04")) + "\n")
+			// kept until the update succeeded, and like a local archive
+			// nothing is sent anywhere
+			localArchive = staged.Archive
+		}
+	}
+	if archive == nil && localArchive != "" {
+		fmt.Println(T("source.local", localArchive) + "\n")
+		archive, err = LocalPack(localArchive, config.MCVersion)
+		if err != nil {
+			StopPreparing(prepared)
+			Fatal(err)
+		}
+	} else if archive == nil {
+		if *verboseFlag && !*noProbeFlag {
+			probe := ProbeNetwork(runCtx, fileURL)
+			Logf("probe: %s", probe)
+			fmt.Println(T("download.probe", T("probe."+probe.Class), probe.FirstByte.Round(time.Millisecond), probe.Throughput()/1024))
+		}
+		fmt.Println(T("download.start"))
+		archive, err = fetcher.Fetch(fileOut, append([]string{fileURL}, config.Mirrors...), config.ArchiveSHA256, config.MCVersion)
+		if err != nil {
+			StopPreparing(prepared)
+			exitFetchFailed(err)
+		}
+		if archive.Download.URL != fileURL && !strings.HasPrefix(archive.Download.URL, cacheMirror+blobPrefix) {
+			fmt.Println(T("download.mirror", archive.Download.URL))
+		}
+		// kept for players updating from this cache over the LAN
+		if err := cache.StoreBlob(archive.Path, archive.Download.SHA256); err != nil {
+			Logf("cache: keeping %s: %s", archive.Path, err)
+		}
+		fmt.Println(T("download.done", fileOut) + "\n")
+	}
+	downloadTime := clock.Now().Sub(downloadStart)
+	if config.MCVersion == "" {
+		config.MCVersion = archive.MCVersion
+		if config.MCVersion == "" {
+			config.MCVersion = defaultMCVersion
+		}
+		fmt.Println(T("config.version.default", config.MCVersion))
+	}
+	if savedConfig.MCVersion == "" {
+		savedConfig.MCVersion = config.MCVersion
+		SaveConfig(savedConfig, jsonConfPath)
+	}
+	if *stageOnlyFlag {
+		StopPreparing(prepared)
+		fetcher.Done()
+		if err := runStageOnly(archive, previous, stagedPath, stagedArchivePath); err != nil {
+			Fatal(err)
+		}
+		return
+	}
+	sourceURL := archive.Download.URL
+	if archive.NewerVersion != "" {
+		fmt.Println(T("notice.newer", archive.NewerVersion, config.MCVersion))
+		// moving to another Minecraft version is never answered by --yes
+		switched := false
+		if interactive && *mcVersionFlag == "" {
+			switched = prompter.Confirm(T("notice.newer.switch", archive.NewerVersion), false)
+		}
+		if switched {
+			target := archive.NewerVersion
+			newer, err := ValidateArchive(archive.Path, target)
+			if err != nil {
+				Fatal(err)
+			}
+			newer.Download = archive.Download
+			archive = newer
+			config.MCVersion = target
+			savedConfig.MCVersion = target
+			SaveConfig(savedConfig, jsonConfPath)
+			Logf("switching to minecraft %s on request", target)
+		} else {
+			fmt.Println(T("notice.newer.how", archive.NewerVersion, jsonConfPath))
+		}
+		fmt.Println()
+	}
+
+	// further sources are merged with the pack into one archive, so the
+	// plan sees the complete mods directory; conflicts stop the update
+	// before anything is written
+	var overrides []MergeOverride
+	sourceNames := []string{primarySource}
+	if len(config.Sources) > 0 {
+		extra, err := FetchSources(fetcher, config.Sources, filepath.Dir(fileOut), config.MCVersion)
+		if err != nil {
+			StopPreparing(prepared)
+			exitFetchFailed(err)
+		}
+		sources := []SourceArchive{{Name: primarySource, Archive: archive}}
+		for _, source := range extra {
+			sources = append(sources, source)
+			sourceNames = append(sourceNames, source.Name)
+		}
+		merged, found, err := MergePacks(mergedPath, sources)
+		for _, source := range extra {
+			RemoveTemporary(source.Archive.Path)
+		}
+		if archive.Path != localArchive {
+			RemoveTemporary(archive.Path)
+		}
+		var conflicts *mergeConflictError
+		if errors.As(err, &conflicts) {
+			fmt.Println(T("sources.conflict"))
+			for _, conflict := range conflicts.conflicts {
+				fmt.Println("  " + conflict.String())
+			}
+			Exit(err)
+		} else if err != nil {
+			Fatal(err)
+		}
+		archive, overrides = merged, found
+		for _, o := range overrides {
+			Logf("sources: %s of %s replaced by %s", o.Name, o.Source, o.By)
+		}
+		fmt.Println(T("sources.merged", len(sources), archive.ModEntries) + "\n")
+	}
+
+	// values for the pack's templates are asked for now, so nothing has
+	// to be asked once the update started
+	templateValues := len(config.TemplateValues)
+	if manifest := archive.Manifest; manifest != nil && len(manifest.Transforms) > 0 {
+		missing, err := MissingTemplateValues(archive.Path, manifest.Transforms, config.TemplateValues)
+		if err != nil {
+			Fatal(err)
+		}
+		if len(missing) > 0 && !interactive {
+			Fatal(fmt.Errorf("values for %s are needed, run the updater interactively once", strings.Join(missing, ", ")))
+		}
+		if config.TemplateValues == nil {
+			config.TemplateValues = map[string]string{}
+		}
+		for _, name := range missing {
+			value, err := prompter.Line(T("prompt.template", name))
+			if err != nil {
+				Fatal(fmt.Errorf("values for %s are needed, run the updater interactively once", strings.Join(missing, ", ")))
+			}
+			config.TemplateValues[name] = value
+			AddLogSecret(value)
+		}
+	}
+
+	// everything is gathered and checked first, then summarized for a
+	// single confirmation; nothing on disk changes before it
+	SetPhase(phaseConfirm)
+	journal := &Journal{Path: journalPath, Run: runID, Pack: archive.Label()}
+	var plan UpdatePlan
+	var preflight *Preflight
+	var minecraftPath string
+	var loaderVersion string
+	hostname, _ := os.Hostname()
+	for {
+		// mods path should end in "mods", anything else has to be
+		// confirmed by typing its name, even with --yes
+		if !config.allowsModsDir(modPath) {
+			if !ConfirmNonStandardModsDir(prompter, modPath) {
+				FailWith(categoryLocal, T("exiting"))
+			}
+			Logf("non-standard mods directory %s confirmed", modPath)
+			fmt.Println(T("path.notmods.allow", modPath, jsonConfPath))
+		}
+
+		// set minecraft relative paths
+		minecraftPath = ResolveMinecraftRoot(modPath, previous)
+		versionsPath := filepath.Join(minecraftPath, "versions")
+		fmt.Println(T("versions.collect"))
+		waitStart := clock.Now()
+		var prep *Preparation
+		if prepared != nil {
+			// the download is done, what wasn't hashed meanwhile is
+			// hashed when needed
+			prep = <-prepared
+			prep.StopHashing()
+			prepared = nil
+		}
+		if prep == nil || prep.ModPath != modPath || prep.MCVersion != config.MCVersion {
+			// serial mode, or the directory or version changed at the prompts
+			prep = Prepare(modPath, versionsPath, loader, config.MCVersion)
+		} else {
+			saved := prep.Duration - clock.Now().Sub(waitStart)
+			Logf("preparation took %s, %s of it overlapped with the %s download", prep.Duration, saved, downloadTime)
+			if saved > time.Second {
+				fmt.Println(T("prepare.overlap", saved.Round(time.Second)))
+			}
+		}
+
+		// the pack tells which loader release its mods need on this
+		// Minecraft version, it is installed unless it already is
+		loaderVersion = SelectLoaderVersion(archive.LoaderVersion(config.MCVersion), config.LoaderVersion, *forceLoaderFlag)
+		plan = UpdatePlan{
+			Archive:       archive,
+			MCVersion:     config.MCVersion,
+			ModPath:       modPath,
+			MinecraftPath: minecraftPath,
+			GameDir:       filepath.Dir(modPath),
+			Loader:        loader,
+			InstallLoader: NeedsLoaderInstall(loader, versionsPath, config.MCVersion, loaderVersion, prep.LoaderInstalled),
+			LoaderVersion: loaderVersion,
+			Prepared:      prep,
+			WarnModFiles:  config.WarnModFiles,
+			WarnModsMB:    config.WarnModsMB,
+
+			TemplateValues: config.TemplateValues,
+			Journal:        journal,
+			BackupDir:      runBackups(modPath),
+			RecoveryDir:    recoveryPath,
+			LowWrite:       *lowWriteFlag,
+			SkipConfigs:    *skipConfigsFlag,
+			Cache:          cache,
+		}
+		// the loader installers need the vanilla version the official
+		// launcher sets up on first launch
+		neverLaunched := !prep.LoaderInstalled && NeverLaunched(minecraftPath)
+		if neverLaunched {
+			Logf("%s was never launched", minecraftPath)
+			fmt.Println(T("vanilla.neverlaunched", config.MCVersion))
+			plan.BootstrapVanilla = *bootstrapVanillaFlag
+			if !plan.BootstrapVanilla && interactive {
+				plan.BootstrapVanilla = prompter.Confirm(T("vanilla.prompt", config.MCVersion), false)
+			}
+		}
+		preflight, err = plan.Preflight(sourceURL)
+		if err != nil {
+			Fatal(err)
+		}
+		for _, o := range overrides {
+			preflight.Warnings = append(preflight.Warnings, T("sources.override", o.Name, o.Source, o.By))
+		}
+		if neverLaunched && !plan.BootstrapVanilla {
+			preflight.Warnings = append(preflight.Warnings, T("vanilla.warning", config.MCVersion))
+		} else if prep.LoaderErr != nil && !plan.BootstrapVanilla {
+			preflight.Warnings = append(preflight.Warnings, T("preflight.noversions", versionsPath))
+		}
+		// the installed state records the version last applied, the
+		// config only the one wanted
+		state, err := ReadInstalledState(installedPath)
+		if err != nil {
+			Logf("reading installed state: %s", err)
+		}
+		if state != nil && state.MCVersion != "" && state.MCVersion != config.MCVersion {
+			preflight.PreviousMCVersion = state.MCVersion
+			Logf("minecraft version changes from %s to %s", state.MCVersion, config.MCVersion)
+		}
+		if state != nil && len(state.SkippedConfigs) > 0 && !plan.SkipConfigs {
+			preflight.Warnings = append(preflight.Warnings, T("configs.pending", len(state.SkippedConfigs)))
+		}
+		if state != nil {
+			plan.PreviousLayout = installedLayout(state)
+			if layout := archive.Manifest.layout(); plan.PreviousLayout != layout {
+				preflight.Warnings = append(preflight.Warnings, T("layout.change", plan.PreviousLayout, layout))
+			}
+		}
+		// an update leaving the mods as they are skips their backup and
+		// swap; a release marked config-only gets the full update where
+		// the mods don't match
+		configOnly, reason := plan.DetectConfigOnly(preflight, state)
+		plan.ConfigOnly, preflight.ConfigOnly = configOnly, configOnly
+		if configOnly {
+			Logf("config-only update, the mods match the pack")
+		} else if archive.Manifest != nil && archive.Manifest.ConfigOnly {
+			Logf("config-only release, full update: %s", reason)
+			preflight.Warnings = append(preflight.Warnings, T("configonly.escalated"))
+		}
+		fmt.Println()
+		fmt.Print(preflight.Render())
+		for _, issue := range preflight.Requirements {
+			Logf("requirements: %s", issue.Message)
+		}
+		// a minecraft directory synced between machines must not be
+		// updated from two of them at once
+		if contention := DetectContention(minecraftPath, modPath, hostname); planOut == "" && contention.Found() {
+			Logf("shared: another machine's lock: %t, %d sync conflicts", contention.Lock != nil, len(contention.Conflicts))
+			fmt.Println()
+			contention.Print(modPath)
+			switch {
+			case interactive:
+				if !prompter.Confirm(T("shared.confirm"), false) {
+					fmt.Println(T("preflight.cancelled"))
+					osExit(0)
+				}
+				Logf("shared: updating anyway, confirmed")
+			case contention.Lock != nil:
+				FailWith(categoryLocal, T("shared.refused", minecraftPath, contention.Lock.Host))
+			default:
+				Warn(warnShared, T("shared.conflicts.warn", len(contention.Conflicts), modPath))
+			}
+		}
+		if planOut != "" || reviewed != nil {
+			current, err := plan.PlanFile(sourceURL)
+			if err != nil {
+				Fatal(err)
+			}
+			if planOut != "" {
+				if err := WritePlanFile(planOut, current); err != nil {
+					Fatal(err)
+				}
+				Logf("plan of %d file actions saved to %s", len(current.Files), planOut)
+				fmt.Println(T("plan.saved", planOut))
+				return
+			}
+			if drift := reviewed.Drift(current); len(drift) > 0 {
+				Logf("apply: %s no longer matches: %s", flag.Arg(1), strings.Join(drift, "; "))
+				fmt.Println(T("apply.drift", flag.Arg(1)))
+				for _, line := range drift {
+					fmt.Println("  - " + line)
+				}
+				Exit(Failure(categoryLocal, errors.New(T("apply.drift", flag.Arg(1)))))
+			}
+			Logf("apply: carrying out %s, made %s", flag.Arg(1), reviewed.Created.Format(time.RFC3339))
+			fmt.Println(T("apply.matches", flag.Arg(1)))
+			break
+		}
+		if interactive {
+			plan.AskForUserMods(prompter)
+		}
+		if BelowMinimum(preflight.Requirements) && archive.Manifest.Requirements.ConfirmBelowMinimum {
+			if !interactive {
+				prompter.Assume(T("requirements.confirm"), true, true)
+			} else if !prompter.Confirm(T("requirements.confirm"), true) {
+				fmt.Println(T("preflight.cancelled"))
+				osExit(0)
+			}
+		}
+		if *applyRecommendedFlag {
+			changes, err := RecommendedSettingsChanges(archive, plan.GameDir)
+			if err != nil {
+				Fatal(err)
+			}
+			plan.ApplyRecommended = false
+			if len(changes) == 0 {
+				fmt.Println(T("options.recommended.none"))
+			} else {
+				fmt.Println(T("options.recommended", len(changes)))
+				for _, change := range changes {
+					fmt.Println(T("options.recommended.item", change.Key, change.Current, change.Recommended))
+				}
+				if interactive {
+					plan.ApplyRecommended = prompter.Confirm(T("options.recommended.confirm"), true)
+				} else {
+					plan.ApplyRecommended = prompter.Assume(T("options.recommended.confirm"), true, true)
+				}
+				Logf("recommended settings: %d differ, applying: %t", len(changes), plan.ApplyRecommended)
+			}
+		}
+
+		answer := answerWord(true)
+		if interactive {
+			answer = prompter.Text(T("preflight.confirm")+" "+T("preflight.hint")+": ", answer)
+		} else {
+			fmt.Println(T("preflight.confirm") + " " + T("preflight.hint") + ": " + answer)
+		}
+		if isYes(answer) {
+			// the configs are confirmed on their own, players care
+			// more about them than about the mods
+			if len(preflight.Configs) > 0 && !plan.SkipConfigs && interactive {
+				plan.SkipConfigs = !prompter.Confirm(T("configs.confirm", len(preflight.Configs)), true)
+				Logf("configs: %d files outside the mods directory, applying: %t", len(preflight.Configs), !plan.SkipConfigs)
+			}
+			break
+		}
+		if strings.TrimSpace(strings.ToLower(answer)) != T("answer.dir") {
+			fmt.Println(T("preflight.cancelled"))
+			osExit(0)
+		}
+		newpath, err := PickModsDir(prompter)
+		if err != nil {
+			Fatal(err)
+		}
+		modPath = newpath
+		config.MCDirectory = newpath
+		savedConfig.MCDirectory = newpath
+		SaveConfig(savedConfig, jsonConfPath)
+	}
+	fmt.Println()
+
+	// without a backup nothing the update removes can be put back, so that
+	// is confirmed on its own; unattended runs need --yes for it
+	if *noBackupFlag && !plan.ConfigOnly {
+		fmt.Println(T("backup.skip.warning", modPath))
+		plan.NoBackup = true
+		switch {
+		case interactive:
+			if !prompter.Confirm(T("backup.skip.confirm"), false) {
+				plan.NoBackup = false
+				fmt.Println(T("backup.skip.kept"))
+			}
+		case !*yesFlag:
+			FailWith(categoryUsage, T("backup.skip.unconfirmed"))
+		}
+		Logf("backup: --no-backup, skipping the backup: %t", plan.NoBackup)
+	}
+
+	if err := unlockForUpdate(modPath); err != nil {
+		Fatal(err)
+	}
+	if *lowWriteFlag && !plan.ConfigOnly {
+		RotateLowWriteBackup(plan.BackupDir)
+	}
+	guard, err := AcquireUpdateLock(minecraftPath, hostname, runID)
+	if err != nil {
+		Logf("shared: writing the update lock: %s", err)
+	}
+	plan.Guard = guard
+	Notify(T("notify.started", modPath))
+	err = plan.Execute()
+	guard.Release()
+	if len(config.TemplateValues) != templateValues {
+		savedConfig.TemplateValues = config.TemplateValues
+		SaveConfig(savedConfig, jsonConfPath)
+	}
+	if err != nil {
+		Logf("update failed: %s", err)
+		Notify(T("notify.failed", err))
+		ExplainAccessError(err)
+		Fatal(err)
+	}
+	Logf("update complete from %s", sourceURL)
+	RecordFileChanges(preflight.Add, preflight.Remove, preflight.Unchanged)
+	Notify(T("notify.done", preflight.Add+preflight.Remove))
+	var skippedConfigs []string
+	if plan.SkipConfigs {
+		for _, change := range preflight.Configs {
+			skippedConfigs = append(skippedConfigs, change.Path)
+		}
+	}
+	if err := RecordInstalledState(installedPath, archive, loader, modPath, minecraftPath, config.MCVersion, skippedConfigs); err != nil {
+		Logf("recording installed state: %s", err)
+	}
+	if CleanUpSyncConflicts(installedPath, modPath, plan.BackupDir, journal, prompter, interactive) {
+		if err := RecordInstalledState(installedPath, archive, loader, modPath, minecraftPath, config.MCVersion, skippedConfigs); err != nil {
+			Logf("recording installed state: %s", err)
+		}
+	}
+	if config.Maintenance.Enabled() {
+		removed, freed := config.Maintenance.Run(plan.GameDir, journal)
+		fmt.Println(T("maintenance.done", removed, megabytes(freed)))
+		Logf("maintenance: removed %d files, %d bytes", removed, freed)
+	}
+	CleanIncompleteBackups(BackupsRoot(backupsPath, modPath), recoveryPath)
+	if *lowWriteFlag {
+		DropPreviousLowWriteBackup(plan.BackupDir)
+	} else if keep := config.Maintenance.KeepBackups; keep > 0 {
+		if removed, freed := PruneBackups(BackupsRoot(backupsPath, modPath), keep); removed > 0 {
+			fmt.Println(T("backup.pruned", removed, megabytes(freed)))
+		}
+	}
+	relockAfterUpdate(modPath, config.LockModsDir)
+
+	// further targets, only with the saved config: --dir updates a single
+	// directory
+	var targets []string
+	for _, target := range config.Targets {
+		dir := NormalizeDir(target)
+		if *dirFlag != "" || dir == modPath {
+			continue
+		}
+		if status := CheckModsDir(dir); status.Err != nil {
+			fmt.Println(T("targets.skip", dir, status.Err))
+			continue
+		}
+		if !config.allowsModsDir(dir) && !ConfirmNonStandardModsDir(prompter, dir) {
+			fmt.Println(T("targets.skip", dir, T("targets.notconfirmed")))
+			continue
+		}
+		targets = append(targets, dir)
+	}
+	var targetResults []TargetResult
+	if len(targets) > 0 {
+		SetPhase(phaseTargets)
+		groups := GroupTargets(targets)
+		fmt.Println()
+		PrintTargetOrder(groups)
+		// the targets' progress is shown together, their output line by
+		// line above it
+		mode := muxLines
+		switch {
+		case jsonOutput:
+			mode = muxJSON
+		case isTerminal(os.Stdout):
+			mode = muxTerminal
+		}
+		out := io.Writer(os.Stdout)
+		if jsonOutput {
+			out = machineOut
+		}
+		mux := NewProgressMux(out, mode)
+		for _, group := range groups {
+			for _, dir := range group.ModPaths {
+				mux.Add(dir, targetName(dir))
+			}
+		}
+		targetOut := mux.Output()
+		restoreWarnings := PrintWarningsTo(targetOut)
+		targetResults = RunTargetGroups(groups, func(group TargetGroup, dir string, first bool) error {
+			versionsPath := filepath.Join(group.MinecraftPath, "versions")
+			prep := Prepare(dir, versionsPath, loader, config.MCVersion)
+			target := UpdatePlan{
+				Archive:       archive,
+				MCVersion:     config.MCVersion,
+				ModPath:       dir,
+				MinecraftPath: group.MinecraftPath,
+				GameDir:       filepath.Dir(dir),
+				Loader:        loader,
+				// the loader is installed once per minecraft directory,
+				// the main target already took care of its own
+				InstallLoader: first && group.MinecraftPath != minecraftPath && NeedsLoaderInstall(loader, versionsPath, config.MCVersion, loaderVersion, prep.LoaderInstalled),
+				LoaderVersion: loaderVersion,
+				Prepared:      prep,
+				WarnModFiles:  config.WarnModFiles,
+				WarnModsMB:    config.WarnModsMB,
+
+				TemplateValues: config.TemplateValues,
+				Journal:        journal,
+				BackupDir:      filepath.Join(runBackups(dir), "targets", filepath.Base(group.MinecraftPath), filepath.Base(dir)),
+				RecoveryDir:    recoveryPath,
+				LowWrite:       *lowWriteFlag,
+				SkipConfigs:    plan.SkipConfigs,
+				Cache:          cache,
+				Target:         dir,
+				Mux:            mux,
+				Out:            targetOut,
+			}
+			err := unlockForUpdate(dir)
+			if err == nil {
+				err = target.Execute()
+			}
+			Logf("target %s: %v", dir, err)
+			if err == nil {
+				relockAfterUpdate(dir, config.LockModsDir)
+			}
+			mux.Finish(dir, err)
+			FinishTarget(dir)
+			return err
+		})
+		restoreWarnings()
+		mux.Close()
+	}
+	SetPhase(phaseFinish)
+	fmt.Println(T("cleanup"))
+	// an archive the player provided is theirs to keep
+	if archive.Path != localArchive {
+		RemoveTemporary(archive.Path)
+	}
+	fetcher.Done()
+	if staged != nil {
+		if err := DiscardStaged(stagedPath); err != nil {
+			Logf("staged: %s", err)
+		}
+	}
+
+	// anonymous statistics, only when the pack asks for them and the
+	// player agreed; installing from a local archive stays offline
+	if manifest := archive.Manifest; manifest != nil && manifest.StatsURL != "" && !*noTelemetryFlag && localArchive == "" {
+		if savedConfig.Telemetry == nil && interactive {
+			agreed := prompter.Confirm(T("telemetry.prompt", jsonConfPath), false)
+			savedConfig.Telemetry = &agreed
+			SaveConfig(savedConfig, jsonConfPath)
+		}
+		if savedConfig.Telemetry != nil && *savedConfig.Telemetry {
+			if savedConfig.InstallID == "" {
+				savedConfig.InstallID = NewInstallID()
+				SaveConfig(savedConfig, jsonConfPath)
+			}
+			SendUpdateStats(manifest.StatsURL, BuildUpdateStats(savedConfig.InstallID, manifest.Version, config.MCVersion, runtime.GOOS, Version))
+		}
+	}
+
+	fmt.Printf("\n%s\n", T("summary.source", sourceURL))
+	for _, check := range plan.CriticalChecks {
+		fmt.Println("  " + check.String())
+		Logf("check %s", check)
+	}
+	for _, mod := range plan.Policies.Kept {
+		fmt.Println("  " + T("policy.kept", mod.ID, mod.Version, mod.PackVersion))
+	}
+	for _, mod := range plan.Policies.Restored {
+		fmt.Println("  " + T("policy.restored", mod.ID, mod.PackVersion, mod.Version))
+	}
+	for _, file := range plan.Protected {
+		fmt.Println("  " + file.String())
+	}
+	if len(targetResults) > 0 {
+		fmt.Println(T("targets.summary"))
+		PrintTargetResults(targetResults)
+	}
+	if archive.Sources != nil {
+		counts := map[string]int{}
+		for _, source := range archive.Sources {
+			counts[source]++
+		}
+		for _, name := range sourceNames {
+			fmt.Println("  " + T("sources.summary", name, counts[name]))
+		}
+	}
+	PrintWarningSummary(logPath)
+	targetsFailed := false
+	for _, result := range targetResults {
+		targetsFailed = targetsFailed || result.Err != nil
+	}
+	SetOutcome(preflight.Add+preflight.Remove > 0 || plan.InstallLoader || len(targetResults) > 0, targetsFailed)
+	fmt.Println(T("summary.written", megabytes(BytesWritten())))
+	Logf("%d bytes written", BytesWritten())
+	fmt.Printf("\n\n\n%s\n\n", T("multimc.header"))
+	fmt.Println(T("multimc.mcversion", config.MCVersion))
+	fmt.Print(T("multimc.loader", bundledFabricInstaller))
+	fmt.Printf("\n%s\n", T("multimc.footer"))
+
+	if !interactive {
+		return
+	}
+	i := 20
+	fmt.Print(T("exit.countdown"))
+	for {
+		if i <= 0 {
+			fmt.Println("0")
+			break
+		} else {
+			fmt.Printf("%d.", i)
+			clock.Sleep(1 * time.Second)
+			i--
+		}
+	}
+}
@@ -0,0 +1,551 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// packArchiveURLPath is where the pack archive is downloaded from, on
+// github.com.
+const packArchiveURLPath = "/rx13/rxmc-Mods/archive/master.zip"
+
+// fakeJavaEnv names the file the test binary, run as java by useFakeJava,
+// records its arguments to.
+const fakeJavaEnv = "RXMC_TEST_FAKE_JAVA"
+
+// TestMain runs the test binary as the java of the tests when fakeJavaEnv
+// is set.
+func TestMain(m *testing.M) {
+	if record := os.Getenv(fakeJavaEnv); record != "" {
+		content, _ := json.Marshal(os.Args[1:])
+		os.WriteFile(record, content, 0644)
+		fakeFabricInstall(os.Args[1:])
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeFabricInstall creates the version directory the Fabric installer
+// run with args would, for the release of -loader or else 0.16.5.
+func fakeFabricInstall(args []string) {
+	options := map[string]string{"-loader": "0.16.5"}
+	for i := 0; i+1 < len(args); i++ {
+		if strings.HasPrefix(args[i], "-") {
+			options[args[i]] = args[i+1]
+		}
+	}
+	if options["-dir"] == "" || options["-mcversion"] == "" {
+		return
+	}
+	name := "fabric-loader-" + options["-loader"] + "-" + options["-mcversion"]
+	dir := filepath.Join(options["-dir"], "versions", name)
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, name+".json"), []byte(`{"id": "`+name+`"}`), 0644)
+	os.WriteFile(filepath.Join(dir, name+".jar"), nil, 0644)
+}
+
+// useFakeJava has the test binary run as java, returning the file the
+// arguments of its last run are recorded to. Run as an installer, it
+// installs Fabric, see fakeFabricInstall.
+func useFakeJava(t *testing.T) string {
+	t.Helper()
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := filepath.Join(t.TempDir(), "java-args.json")
+	t.Setenv(fakeJavaEnv, record)
+	saved := javaCommand
+	javaCommand = self
+	t.Cleanup(func() { javaCommand = saved })
+	return record
+}
+
+// runMain runs the updater with args as its command line, its output going
+// to a file returned.
+func runMain(t *testing.T, args ...string) string {
+	t.Helper()
+	saved := struct {
+		args    []string
+		flags   *flag.FlagSet
+		stdout  *os.File
+		outcome int
+	}{os.Args, flag.CommandLine, os.Stdout, runOutcome}
+	out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.Args, flag.CommandLine, os.Stdout, runOutcome = saved.args, saved.flags, saved.stdout, saved.outcome
+		out.Close()
+	}()
+	os.Args = append([]string{"clientUpdater"}, args...)
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	os.Stdout = out
+	main()
+	return out.Name()
+}
+
+// fakeUpdate is a minecraft directory with the loader installed and the
+// updater's state directory, and a pack served for them.
+type fakeUpdate struct {
+	clock     *fakeClock
+	server    *fakePackServer
+	minecraft string
+	mods      string
+	state     string
+}
+
+// fakePackServer serves the pack archive from github.com and nothing
+// else, counting the downloads.
+type fakePackServer struct {
+	archive   atomic.Value
+	downloads int32
+}
+
+func (s *fakePackServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Host != "github.com" || r.URL.Path != packArchiveURLPath {
+		http.NotFound(w, r)
+		return
+	}
+	atomic.AddInt32(&s.downloads, 1)
+	http.ServeFile(w, r, s.archive.Load().(string))
+}
+
+// newFakeUpdate sets up a run against a fake pack of files, by entry name
+// below the archive's top folder.
+func newFakeUpdate(t *testing.T, files map[string]string) *fakeUpdate {
+	t.Helper()
+	return newFakeUpdateIn(t, t.TempDir(), files)
+}
+
+// newFakeUpdateIn is newFakeUpdate with the minecraft and state
+// directories below root.
+func newFakeUpdateIn(t *testing.T, root string, files map[string]string) *fakeUpdate {
+	t.Helper()
+	u := &fakeUpdate{
+		clock:     useFakeClock(t),
+		server:    &fakePackServer{},
+		minecraft: filepath.Join(root, ".minecraft"),
+		state:     filepath.Join(root, "state"),
+	}
+	u.mods = filepath.Join(u.minecraft, "mods")
+	writeFiles(t, u.minecraft, map[string]string{
+		"versions/1.20.1/1.20.1.json": "{}",
+		"versions/fabric-loader-0.15.11-1.20.1/fabric-loader-0.15.11-1.20.1.json": "{}",
+		"launcher_profiles.json": "{}",
+	})
+	if err := os.MkdirAll(u.mods, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// the launcher last touched its profiles long before the runs
+	old := u.clock.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(u.minecraft, "launcher_profiles.json"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	u.setPack(t, files)
+	useTempBaseDirs(t)
+	useTestServer(t, u.server)
+	return u
+}
+
+// setPack replaces the pack served.
+func (u *fakeUpdate) setPack(t *testing.T, files map[string]string) {
+	t.Helper()
+	entries := map[string]string{}
+	for name, content := range files {
+		entries["rxmc-Mods-master/"+name] = content
+	}
+	p := filepath.Join(t.TempDir(), "pack.zip")
+	writeZip(t, p, entries)
+	u.server.archive.Store(p)
+}
+
+// run runs an unattended update of the mods directory, a minute after the
+// last one. The runs' duration limits are timers on the test's clock, a
+// test stays well within them.
+func (u *fakeUpdate) run(t *testing.T, args ...string) string {
+	t.Helper()
+	u.clock.Advance(time.Minute)
+	return runMain(t, append([]string{"--portable", u.state, "--dir", u.mods, "--yes", "--no-telemetry", "--no-tui", "--mc-version", "1.20.1"}, args...)...)
+}
+
+func TestUpdateEndToEnd(t *testing.T) {
+	start := time.Now()
+	u := newFakeUpdate(t, map[string]string{
+		"mods/fabric-api.jar": "fabric api",
+		"mods/sodium.jar":     "sodium 1",
+		"README.md":           "not installed",
+	})
+	writeFiles(t, u.mods, map[string]string{"old.jar": "removed by the update"})
+
+	output := u.run(t)
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 1" {
+		t.Fatalf("sodium.jar = %q after the update, output:\n%s", got, readFile(t, output))
+	}
+	if _, err := os.Stat(filepath.Join(u.mods, "old.jar")); !os.IsNotExist(err) {
+		t.Errorf("old.jar is still there: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(u.mods, "README.md")); !os.IsNotExist(err) {
+		t.Errorf("README.md was installed: %v", err)
+	}
+	state, err := ReadInstalledState(filepath.Join(u.state, "clientUpdate-installed.json"))
+	if err != nil || state == nil {
+		t.Fatalf("installed state: %v, %v", state, err)
+	}
+	if state.MCVersion != "1.20.1" || len(state.Files) != 2 {
+		t.Errorf("installed state for %s with %d files, want 1.20.1 with 2", state.MCVersion, len(state.Files))
+	}
+	backups, _ := filepath.Glob(filepath.Join(u.state, backupsDirName, "*", "old.jar"))
+	if len(backups) != 1 {
+		t.Errorf("old.jar backed up %d times", len(backups))
+	}
+
+	// the next release replaces a mod
+	u.setPack(t, map[string]string{
+		"mods/fabric-api.jar": "fabric api",
+		"mods/sodium.jar":     "sodium 2",
+	})
+	output = u.run(t)
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 2" {
+		t.Fatalf("sodium.jar = %q after the second update, output:\n%s", got, readFile(t, output))
+	}
+	if downloads := atomic.LoadInt32(&u.server.downloads); downloads != 2 {
+		t.Errorf("the pack was downloaded %d times, want 2", downloads)
+	}
+	if !strings.Contains(readFile(t, output), T("summary.source", "https://github.com"+packArchiveURLPath)) {
+		t.Errorf("the summary doesn't name the source:\n%s", readFile(t, output))
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("two updates took %s", took)
+	}
+}
+
+func TestInteractiveUpdateEndToEnd(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/fabric-api.jar": "fabric api"})
+	config, _ := json.Marshal(ConfFile{MCVersion: "1.20.1", MCDirectory: u.mods})
+	writeFiles(t, u.state, map[string]string{"clientUpdate.json": string(config)})
+	// every prompt answered with its default
+	stdin, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	savedStdin := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = savedStdin }()
+
+	before := u.clock.Now()
+	output := runMain(t, "--portable", u.state, "--no-telemetry", "--no-tui")
+	if _, err := os.Stat(filepath.Join(u.mods, "fabric-api.jar")); err != nil {
+		t.Fatalf("fabric-api.jar wasn't installed: %v, output:\n%s", err, readFile(t, output))
+	}
+	// the countdown before the window closes sleeps on the clock
+	if waited := u.clock.Now().Sub(before); waited < 20*time.Second {
+		t.Errorf("the countdown took %s of the clock, want 20s", waited)
+	}
+	if !strings.Contains(readFile(t, output), T("exit.countdown")) {
+		t.Errorf("no countdown in the output:\n%s", readFile(t, output))
+	}
+}
+
+// zipEntry is an entry of an archive built by writeZipEntries, with the
+// mode bits of the system that made it.
+type zipEntry struct {
+	name string
+	mode os.FileMode
+	// msdos are the attributes of an archive made on Windows, which has
+	// no mode bits, used instead of mode when set.
+	msdos uint32
+}
+
+// writeZipEntries writes an archive holding entries to p, each holding its
+// name.
+func writeZipEntries(t *testing.T, p string, entries []zipEntry) {
+	t.Helper()
+	out, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	w := zip.NewWriter(out)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		if entry.msdos != 0 {
+			header.CreatorVersion = 0 // FAT
+			header.ExternalAttrs = entry.msdos
+		} else {
+			header.SetMode(entry.mode)
+		}
+		f, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(entry.name))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createdPerm returns the permissions a file created with perm gets, the
+// umask and the system applied.
+func createdPerm(t *testing.T, perm os.FileMode, dir bool) os.FileMode {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "reference")
+	var err error
+	if dir {
+		err = os.Mkdir(p, perm)
+	} else {
+		err = os.WriteFile(p, nil, perm)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Mode().Perm()
+}
+
+func TestUnzipNormalizesModes(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "pack.zip")
+	writeZipEntries(t, archivePath, []zipEntry{
+		{name: "pack/mods/no-bits.jar", mode: 0},
+		{name: "pack/mods/private.jar", mode: 0600},
+		{name: "pack/mods/world-writable.jar", mode: 0666},
+		{name: "pack/mods/readonly.jar", mode: 0444},
+		{name: "pack/mods/windows.jar", msdos: 0x20},
+		{name: "pack/mods/windows-readonly.jar", msdos: 0x21},
+		{name: "pack/mods/everything.jar", mode: 0777},
+		{name: "pack/mods/owner-exec.jar", mode: 0700},
+		{name: "pack/mods/setuid.jar", mode: os.ModeSetuid | os.ModeSticky | 0755},
+	})
+	dest := filepath.Join(t.TempDir(), "mods")
+	if _, err := Unzip(archivePath, dest, "mods"); err != nil {
+		t.Fatal(err)
+	}
+
+	plain := createdPerm(t, filePerm, false)
+	executable := createdPerm(t, filePerm|0111, false)
+	for name, want := range map[string]os.FileMode{
+		"no-bits.jar":          plain,
+		"private.jar":          plain,
+		"world-writable.jar":   plain,
+		"readonly.jar":         plain,
+		"windows.jar":          plain,
+		"windows-readonly.jar": plain,
+		"everything.jar":       executable,
+		"owner-exec.jar":       executable,
+		"setuid.jar":           executable,
+	} {
+		info, err := os.Stat(filepath.Join(dest, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != want {
+			t.Errorf("%s extracted with mode %s, want %s", name, info.Mode(), want)
+		}
+		if got := readFile(t, filepath.Join(dest, name)); got != "pack/mods/"+name {
+			t.Errorf("%s holds %q", name, got)
+		}
+	}
+	switch runtime.GOOS {
+	case "windows":
+		// only the owner's write bit exists, every file must be writable
+		// for the next update to replace it
+		if plain != 0666 || executable != 0666 {
+			t.Errorf("files created with %s and %s", plain, executable)
+		}
+	default:
+		// the owner reads and writes every file, and only executes those
+		// that were executable
+		if plain&0600 != 0600 || plain&0111 != 0 || executable&0100 == 0 || executable&^0111 != plain {
+			t.Errorf("files created with %s and %s", plain, executable)
+		}
+	}
+}
+
+func TestUnzipCreatesDirsWithDirPerm(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "pack.zip")
+	writeZipEntries(t, archivePath, []zipEntry{
+		{name: "pack/config/", mode: os.ModeDir | 0777},
+		{name: "pack/config/deep/nested/options.json", mode: 0},
+	})
+	dest := filepath.Join(t.TempDir(), "minecraft")
+	_, err := unzipPlaced(archivePath, dest, func(f *zip.File) string {
+		return PackPath(f.Name)
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := createdPerm(t, dirPerm, true)
+	for _, dir := range []string{dest, filepath.Join(dest, "config"), filepath.Join(dest, "config", "deep", "nested")} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("%s created with %s, want %s", dir, info.Mode().Perm(), want)
+		}
+	}
+}
+
+// unusualDirNames are folder names of players' home directories that broke
+// updates: spaces, umlauts, CJK and characters shells treat specially.
+var unusualDirNames = []string{"Jürgen Müller", "李 明", "O'Brien & \"Co\" $HOME", "Ñoño; (copia)"}
+
+// TestUpdateInUnusualPaths downloads, extracts and records an update, and
+// saves a config, below each of unusualDirNames.
+func TestUpdateInUnusualPaths(t *testing.T) {
+	for _, name := range unusualDirNames {
+		t.Run(name, func(t *testing.T) {
+			root := filepath.Join(t.TempDir(), name)
+			u := newFakeUpdateIn(t, root, map[string]string{"mods/sodium.jar": "sodium"})
+			output := u.run(t)
+			if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium" {
+				t.Fatalf("sodium.jar = %q, output:\n%s", got, readFile(t, output))
+			}
+			state, err := ReadInstalledState(filepath.Join(u.state, "clientUpdate-installed.json"))
+			if err != nil || state == nil || len(state.Files) != 1 {
+				t.Fatalf("installed state %+v, %v", state, err)
+			}
+
+			configPath := filepath.Join(u.state, "clientUpdate.json")
+			SaveConfig(ConfFile{MCVersion: "1.20.1", MCDirectory: u.mods}, configPath)
+			var saved ConfFile
+			if err := json.Unmarshal([]byte(readFile(t, configPath)), &saved); err != nil || saved.MCDirectory != u.mods {
+				t.Errorf("saved the directory %q, %v", saved.MCDirectory, err)
+			}
+		})
+	}
+}
+
+// TestInstallerArgumentsInUnusualPaths runs the Fabric installer for
+// minecraft directories below each of unusualDirNames: the directory
+// arrives as a single argument, unchanged.
+func TestInstallerArgumentsInUnusualPaths(t *testing.T) {
+	useFakeClock(t)
+	useRunState(t)
+	record := useFakeJava(t)
+	// no installer from meta, the bundled one is run
+	useTestServer(t, http.NotFoundHandler())
+	for _, name := range unusualDirNames {
+		minecraft := filepath.Join(t.TempDir(), name, ".minecraft")
+		loader := fabricLoader{cache: &Cache{Dir: filepath.Join(t.TempDir(), name, "cache")}}
+		if err := loader.Install(minecraft, "1.20.1", "0.15.11"); err != nil {
+			t.Fatal(err)
+		}
+		var args []string
+		if err := json.Unmarshal([]byte(readFile(t, record)), &args); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"-jar", "", "client", "-dir", minecraft, "-mcversion", "1.20.1", "-loader", "0.15.11"}
+		if len(args) != len(want) {
+			t.Fatalf("java run with %q", args)
+		}
+		for i := range want {
+			if i != 1 && args[i] != want[i] {
+				t.Errorf("argument %d is %q, want %q", i, args[i], want[i])
+			}
+		}
+		if filepath.Base(args[1]) != bundledFabricInstaller {
+			t.Errorf("ran %s", args[1])
+		}
+	}
+}
+
+// TestMinecraftVersionChange moves a player from Minecraft 1.20.1 to 1.21
+// with --mc-version: the loader for 1.21 is installed, its mods replace
+// the old ones, and 1.20.1 stays installed to switch back to.
+func TestMinecraftVersionChange(t *testing.T) {
+	record := useFakeJava(t)
+	u := newFakeUpdate(t, map[string]string{"mods-1.20.1/sodium.jar": "sodium for 1.20.1"})
+	u.run(t)
+	u.setPack(t, map[string]string{
+		"mods-1.20.1/sodium.jar": "sodium for 1.20.1",
+		"mods-1.21/sodium.jar":   "sodium for 1.21",
+	})
+	output := readFile(t, u.run(t, "--mc-version", "1.21"))
+	for _, want := range []string{
+		"The last update was for Minecraft 1.20.1, this one is for 1.21.",
+		"Minecraft 1.20.1 stays installed",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output lacks %q:\n%s", want, output)
+		}
+	}
+
+	var args []string
+	if err := json.Unmarshal([]byte(readFile(t, record)), &args); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(args[2:], " "); got != "client -dir "+u.minecraft+" -mcversion 1.21" {
+		t.Errorf("installer run with %s", got)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium for 1.21" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+	for _, version := range []string{"1.20.1/1.20.1.json", "fabric-loader-0.15.11-1.20.1/fabric-loader-0.15.11-1.20.1.json", "fabric-loader-0.16.5-1.21/fabric-loader-0.16.5-1.21.json"} {
+		if _, err := os.Stat(filepath.Join(u.minecraft, "versions", filepath.FromSlash(version))); err != nil {
+			t.Error(err)
+		}
+	}
+	state, err := ReadInstalledState(filepath.Join(u.state, "clientUpdate-installed.json"))
+	if err != nil || state == nil || state.MCVersion != "1.21" {
+		t.Errorf("installed state %+v, %v", state, err)
+	}
+}
+
+// TestMinecraftVersionSwitch answers the offer to move to the newer
+// Minecraft version the pack supports.
+func TestMinecraftVersionSwitch(t *testing.T) {
+	for _, switched := range []bool{true, false} {
+		t.Run(fmt.Sprint(switched), func(t *testing.T) {
+			useFakeJava(t)
+			u := newFakeUpdate(t, map[string]string{
+				"mods-1.20.1/sodium.jar": "sodium for 1.20.1",
+				"mods-1.21/sodium.jar":   "sodium for 1.21",
+			})
+			configPath := filepath.Join(u.state, "clientUpdate.json")
+			if err := os.MkdirAll(u.state, 0755); err != nil {
+				t.Fatal(err)
+			}
+			SaveConfig(ConfFile{MCDirectory: u.mods, MCVersion: "1.20.1"}, configPath)
+			// the answer to the offer, then yes to everything else
+			answer := "n"
+			if switched {
+				answer = "y"
+			}
+			useStdin(t, answer+"\n"+strings.Repeat("y\n", 10))
+			u.clock.Advance(time.Minute)
+			output := readFile(t, runMain(t, "--portable", u.state, "--no-telemetry", "--no-tui"))
+
+			want := map[bool]string{true: "1.21", false: "1.20.1"}[switched]
+			if !strings.Contains(output, "< Move to Minecraft 1.21 now? [y/N]") {
+				t.Errorf("not offered:\n%s", output)
+			}
+			if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium for "+want {
+				t.Errorf("sodium.jar is %q, output:\n%s", got, output)
+			}
+			var saved ConfFile
+			if err := json.Unmarshal([]byte(readFile(t, configPath)), &saved); err != nil || saved.MCVersion != want {
+				t.Errorf("configured %q, %v", saved.MCVersion, err)
+			}
+			if !switched && !strings.Contains(output, "Run with --mc-version 1.21 to try it") {
+				t.Errorf("not told how to move:\n%s", output)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// clockSkewTolerance is how far the system clock may be off before
+// certificate errors are blamed on it.
+const clockSkewTolerance = 5 * time.Minute
+
+// clockCheckTimeout bounds the request asking a server for the time.
+const clockCheckTimeout = 5 * time.Second
+
+// clockSkewError is a request failing because the system clock is off:
+// certificates look expired, or not yet valid, to it.
+type clockSkewError struct {
+	// skew is how far the system clock is ahead of the server's, negative
+	// when it is behind.
+	skew time.Duration
+	err  error
+}
+
+func (e *clockSkewError) Error() string {
+	return fmt.Sprintf("the system clock is off by %s: %s", e.skew.Round(time.Minute), e.err)
+}
+
+func (e *clockSkewError) Unwrap() error { return e.err }
+
+// Category is local even for the network errors it wraps: the clock has to
+// be set right, trying again doesn't help.
+func (e *clockSkewError) Category() string { return categoryLocal }
+
+// Message tells the player how far their clock is off and that setting it
+// fixes the error.
+func (e *clockSkewError) Message() string {
+	if e.skew > 0 {
+		return T("clock.ahead", roughDuration(e.skew))
+	}
+	return T("clock.behind", roughDuration(-e.skew))
+}
+
+// roughDuration describes d in the largest unit it has two of, e.g. "3
+// years" or "40 minutes".
+func roughDuration(d time.Duration) string {
+	day := 24 * time.Hour
+	switch {
+	case d >= 2*365*day:
+		return T("clock.years", int(d/(365*day)))
+	case d >= 2*day:
+		return T("clock.days", int(d/day))
+	case d >= 2*time.Hour:
+		return T("clock.hours", int(d/time.Hour))
+	}
+	return T("clock.minutes", int(d/time.Minute))
+}
+
+// isCertificateTimeError reports whether err rejects a certificate as
+// expired or not yet valid, which a system clock set wrong causes for
+// every certificate.
+func isCertificateTimeError(err error) bool {
+	var invalid x509.CertificateInvalidError
+	return errors.As(err, &invalid) && invalid.Reason == x509.Expired
+}
+
+// ClassifyClockSkew returns the error to report for err, a failed request,
+// given now and date, the Date header of a server: a clockSkewError when a
+// certificate was rejected for its time and the clocks differ by more than
+// clockSkewTolerance, err itself otherwise. A date that can't be read
+// proves nothing and leaves err alone.
+func ClassifyClockSkew(err error, now time.Time, date string) error {
+	if !isCertificateTimeError(err) {
+		return err
+	}
+	server, parseErr := http.ParseTime(date)
+	if parseErr != nil {
+		return err
+	}
+	skew := now.Sub(server)
+	if skew < clockSkewTolerance && skew > -clockSkewTolerance {
+		return err
+	}
+	return &clockSkewError{skew: skew, err: err}
+}
+
+// serverDate asks the host of rawURL for the time over plain HTTP, which
+// works without certificates. It is best effort, empty when the host
+// doesn't answer.
+func serverDate(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	client := withTimeout(clockCheckTimeout)
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Head("http://" + u.Hostname() + "/")
+	if err != nil {
+		Logf("clock: asking %s for the time: %s", u.Hostname(), err)
+		return ""
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Date")
+}
+
+// checkClockSkew is ClassifyClockSkew for a request to rawURL that failed
+// with err, the host only asked for the time when a certificate was
+// rejected for it.
+func checkClockSkew(err error, rawURL string) error {
+	if !isCertificateTimeError(err) {
+		return err
+	}
+	date := serverDate(rawURL)
+	Logf("clock: certificate rejected for its time, local clock %s, server %q", clock.Now().UTC().Format(time.RFC1123), date)
+	return ClassifyClockSkew(err, clock.Now(), date)
+}
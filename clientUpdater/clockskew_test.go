@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificate is a certificate for 127.0.0.1 valid from notBefore to
+// notAfter, with the pool trusting it.
+func testCertificate(t *testing.T, notBefore time.Time, notAfter time.Time) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "github.com"},
+		DNSNames:              []string{"github.com"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool
+}
+
+// certificateTimeError is how verifying a certificate valid for the 90 days
+// from issued fails at now.
+func certificateTimeError(t *testing.T, issued time.Time, now time.Time) error {
+	t.Helper()
+	cert, pool := testCertificate(t, issued, issued.Add(90*24*time.Hour))
+	_, err := cert.Leaf.Verify(x509.VerifyOptions{Roots: pool, CurrentTime: now})
+	if err == nil {
+		t.Fatalf("the certificate is valid at %s", now)
+	}
+	return err
+}
+
+func TestClassifyClockSkew(t *testing.T) {
+	now := time.Date(2027, 6, 1, 12, 0, 0, 0, time.UTC)
+	server := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	expired := certificateTimeError(t, server, now)
+	notYetValid := certificateTimeError(t, now.Add(time.Hour), now)
+	date := func(at time.Time) string { return at.Format(http.TimeFormat) }
+	tests := []struct {
+		name string
+		err  error
+		now  time.Time
+		date string
+		// skew is the skew found, 0 for err reported as it is.
+		skew time.Duration
+	}{
+		{name: "clock ahead", err: expired, now: now, date: date(server), skew: now.Sub(server)},
+		{name: "clock behind", err: notYetValid, now: server, date: date(now), skew: server.Sub(now)},
+		{name: "wrapped", err: &url.Error{Op: "Get", URL: "https://github.com", Err: &tls.CertificateVerificationError{Err: expired}}, now: now, date: date(server), skew: now.Sub(server)},
+		{name: "within tolerance", err: expired, now: now, date: date(now.Add(-4 * time.Minute))},
+		{name: "certificate really expired", err: expired, now: now, date: date(now)},
+		{name: "no date", err: expired, now: now, date: ""},
+		{name: "unreadable date", err: expired, now: now, date: "yesterday"},
+		{name: "other certificate error", err: x509.UnknownAuthorityError{}, now: now, date: date(server)},
+		{name: "network error", err: errors.New("connection refused"), now: now, date: date(server)},
+	}
+	for _, test := range tests {
+		got := ClassifyClockSkew(test.err, test.now, test.date)
+		var skew *clockSkewError
+		switch {
+		case test.skew == 0 && got != test.err:
+			t.Errorf("%s: classified as %v", test.name, got)
+		case test.skew != 0 && (!errors.As(got, &skew) || skew.skew != test.skew || !errors.Is(got, test.err)):
+			t.Errorf("%s: classified as %v", test.name, got)
+		}
+	}
+}
+
+func TestClockSkewMessage(t *testing.T) {
+	tests := map[time.Duration]string{
+		3*365*24*time.Hour + 20*24*time.Hour: "about 3 years ahead",
+		-400 * 24 * time.Hour:                "about 400 days behind",
+		-3 * 24 * time.Hour:                  "about 3 days behind",
+		30 * time.Hour:                       "about 30 hours ahead",
+		-2 * time.Hour:                       "about 2 hours behind",
+		40 * time.Minute:                     "about 40 minutes ahead",
+	}
+	for skew, want := range tests {
+		err := &clockSkewError{skew: skew, err: errors.New("x509")}
+		if got := err.Message(); !strings.Contains(got, want) {
+			t.Errorf("%s: %q", skew, got)
+		}
+		if err.Category() != categoryLocal {
+			t.Errorf("%s: category %s", skew, err.Category())
+		}
+	}
+}
+
+// useTLSServer serves the updater's requests over TLS with a certificate
+// valid for 90 days from now, the client checking it at now plus after.
+// Plain HTTP requests are answered by a server dating them skew before
+// the fake clock.
+func useTLSServer(t *testing.T, handler http.Handler, after time.Duration, skew time.Duration) {
+	t.Helper()
+	now := time.Now()
+	cert, pool := testCertificate(t, now.Add(-time.Hour), now.Add(90*24*time.Hour))
+	secure := httptest.NewUnstartedServer(handler)
+	secure.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	secure.StartTLS()
+	t.Cleanup(secure.Close)
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", clock.Now().Add(-skew).UTC().Format(http.TimeFormat))
+	}))
+	t.Cleanup(plain.Close)
+
+	secureURL, _ := url.Parse(secure.URL)
+	plainURL, _ := url.Parse(plain.URL)
+	tlsTransport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, Time: func() time.Time { return time.Now().Add(after) }}}
+	t.Cleanup(tlsTransport.CloseIdleConnections)
+	saved := httpClient
+	httpClient = &http.Client{Transport: schemeTransport{
+		"https": redirectTransport{target: secureURL, next: tlsTransport},
+		"http":  redirectTransport{target: plainURL, next: http.DefaultTransport},
+	}}
+	t.Cleanup(func() { httpClient = saved })
+}
+
+// schemeTransport sends requests by their url scheme.
+type schemeTransport map[string]http.RoundTripper
+
+func (t schemeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next, ok := t[req.URL.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("no transport for %s", req.URL)
+	}
+	return next.RoundTrip(req)
+}
+
+func TestUpdateWithClockSkew(t *testing.T) {
+	useRunState(t)
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium"})
+	// the local clock is three years ahead
+	skew := 3 * 365 * 24 * time.Hour
+	useTLSServer(t, u.server, skew, skew)
+
+	if code := exitsWith(func() { u.run(t) }); code <= 0 {
+		t.Errorf("exited with %d", code)
+	}
+	if got := dirNames(t, u.mods); got != "" {
+		t.Errorf("installed %s", got)
+	}
+	log := readFile(t, filepath.Join(u.state, "clientUpdate.log"))
+	if !strings.Contains(log, "the system clock is off by 26280h0m0s") {
+		t.Errorf("log:\n%s", log)
+	}
+}
+
+// TestUpdateWithExpiredCertificate downloads from a server whose
+// certificate did expire: the clock is right, so the error is the
+// certificate's.
+func TestUpdateWithExpiredCertificate(t *testing.T) {
+	useRunState(t)
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium"})
+	useTLSServer(t, u.server, 200*24*time.Hour, 0)
+
+	if code := exitsWith(func() { u.run(t) }); code <= 0 {
+		t.Errorf("exited with %d", code)
+	}
+	log := readFile(t, filepath.Join(u.state, "clientUpdate.log"))
+	if strings.Contains(log, "the system clock is off") || !strings.Contains(log, "clock: certificate rejected for its time") {
+		t.Errorf("log:\n%s", log)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// defaultMCVersion is used when neither the config nor the pack tell which
+// Minecraft version to update for.
+const defaultMCVersion = "1.16.2"
+
+// mcVersionPattern matches release, pre-release and snapshot versions,
+// e.g. 1.20.1, 1.21-pre3 or 24w14a.
+var mcVersionPattern = regexp.MustCompile(`^(\d+\.\d+(\.\d+)?(-(pre|rc)\d+)?|\d{2}w\d{2}[a-z])$`)
+
+// ReadConfig reads the config at p, keeping whatever can be read when
+// parts of it are broken: invalid JSON, values of the wrong type, unknown
+// settings or content after the settings. problems describes each of
+// these, along with a missing or implausible version or directory. A
+// version that isn't usable is cleared so a default is picked. err is
+// only set when the file can't be read at all.
+func ReadConfig(p string) (config ConfFile, problems []string, err error) {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return config, nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(content))
+	if err := dec.Decode(&config); err != nil {
+		problems = append(problems, err.Error())
+		// players edit the settings by hand, so they have no checksum; a
+		// file that isn't JSON at all is replaced by the last one saved
+		if earlier, restored := readConfigGeneration(p); restored != "" {
+			problems = append(problems, fmt.Sprintf("the settings of %s are used instead", restored))
+			config, content = earlier, nil
+		}
+	} else if offset := dec.InputOffset(); len(bytes.TrimSpace(content[offset:])) > 0 {
+		problems = append(problems, "unexpected content after the settings")
+		content = content[:offset]
+	}
+	var raw map[string]json.RawMessage
+	if json.Unmarshal(content, &raw) == nil {
+		problems = append(problems, unknownSettings(raw)...)
+	}
+
+	config.MCVersion = strings.TrimSpace(config.MCVersion)
+	switch {
+	case config.MCVersion == "":
+		problems = append(problems, `"version" is missing`)
+	case !mcVersionPattern.MatchString(config.MCVersion):
+		problems = append(problems, fmt.Sprintf(`"version" %q is not a Minecraft version`, config.MCVersion))
+		config.MCVersion = ""
+	}
+	if strings.TrimSpace(config.MCDirectory) == "" {
+		problems = append(problems, `"directory" is missing`)
+	}
+	return config, problems, nil
+}
+
+// readConfigGeneration returns the newest earlier generation of the
+// settings at p that is valid JSON, and its path, empty when there is none.
+func readConfigGeneration(p string) (config ConfFile, restored string) {
+	for gen := 1; gen <= stateGenerations; gen++ {
+		content, err := ioutil.ReadFile(generationPath(p, gen))
+		if err != nil {
+			continue
+		}
+		var earlier ConfFile
+		if json.Unmarshal(content, &earlier) == nil {
+			return earlier, generationPath(p, gen)
+		}
+	}
+	return config, ""
+}
+
+// settingAliases are names players commonly give the settings instead.
+var settingAliases = map[string]string{
+	"minecraftversion": "version",
+	"dir":              "directory",
+	"modsdir":          "directory",
+	"path":             "directory",
+}
+
+// unknownSettings reports the keys of raw that aren't settings, naming the
+// setting meant when the key is the name of the field in the code or a
+// common alias. Keys
+// are matched case-insensitively, like encoding/json does.
+func unknownSettings(raw map[string]json.RawMessage) []string {
+	known := map[string]bool{}
+	similar := map[string]string{}
+	for alias, name := range settingAliases {
+		similar[alias] = name
+	}
+	t := reflect.TypeOf(ConfFile{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		known[strings.ToLower(name)] = true
+		similar[strings.ToLower(t.Field(i).Name)] = name
+	}
+	var keys []string
+	for key := range raw {
+		if !known[strings.ToLower(key)] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	problems := make([]string, len(keys))
+	for i, key := range keys {
+		if name, ok := similar[strings.ToLower(key)]; ok {
+			problems[i] = fmt.Sprintf("unknown setting %q, did you mean %q?", key, name)
+		} else {
+			problems[i] = fmt.Sprintf("unknown setting %q", key)
+		}
+	}
+	return problems
+}
+
+// EditSettings asks for the mods directory, the minecraft version and the
+// language of config in turn, an empty answer keeping the current value.
+// It reports whether anything changed.
+func EditSettings(prompter *Prompter, config *ConfFile) bool {
+	changed := false
+	ask := func(key string, current string, check func(string) error) string {
+		for {
+			value := prompter.Text(T(key, current)+": ", current)
+			if value == current {
+				return current
+			}
+			if err := check(value); err != nil {
+				fmt.Println(T("settings.invalid", err))
+				continue
+			}
+			changed = true
+			return value
+		}
+	}
+	dir := ask("settings.dir", config.MCDirectory, func(value string) error {
+		return CheckModsDir(NormalizeDir(value)).Err
+	})
+	config.MCDirectory = NormalizeDir(dir)
+	config.MCVersion = ask("settings.version", config.MCVersion, func(value string) error {
+		if !mcVersionPattern.MatchString(value) {
+			return fmt.Errorf("%q is not a minecraft version", value)
+		}
+		return nil
+	})
+	config.Language = ask("settings.language", config.Language, func(value string) error {
+		code := languageCode(value)
+		if _, err := translations.ReadFile("lang/" + code + ".json"); code != "en" && err != nil {
+			return fmt.Errorf("there is no translation for %q", value)
+		}
+		return nil
+	})
+	return changed
+}
+
+// runSettings edits the settings of config with prompter and saves them at
+// jsonConfPath when they changed.
+func runSettings(config ConfFile, prompter *Prompter, jsonConfPath string) error {
+	if !EditSettings(prompter, &config) {
+		fmt.Println(T("settings.unchanged"))
+		return nil
+	}
+	if err := writeConfig(config, jsonConfPath); err != nil {
+		return err
+	}
+	Logf("settings: directory %q, version %s, language %q", config.MCDirectory, config.MCVersion, config.Language)
+	fmt.Println(T("settings.saved", jsonConfPath))
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		// version and directory are what is read, problems what is wrong.
+		version   string
+		directory string
+		problems  []string
+	}{
+		{
+			name:      "valid",
+			content:   `{"version": "1.20.1", "directory": "/games/.minecraft/mods"}`,
+			version:   "1.20.1",
+			directory: "/games/.minecraft/mods",
+		},
+		{
+			name:     "empty",
+			content:  `{}`,
+			problems: []string{`"version" is missing`, `"directory" is missing`},
+		},
+		{
+			name:    "wrong key names",
+			content: `{"minecraftVersion": "1.20.1", "dir": "/games/.minecraft/mods", "MCDirectory": "/x", "colour": "red"}`,
+			problems: []string{
+				`unknown setting "MCDirectory", did you mean "directory"?`,
+				`unknown setting "colour"`,
+				`unknown setting "dir", did you mean "directory"?`,
+				`unknown setting "minecraftVersion", did you mean "version"?`,
+				`"version" is missing`,
+				`"directory" is missing`,
+			},
+		},
+		{
+			// encoding/json matches keys case-insensitively
+			name:      "key case",
+			content:   `{"Version": "1.20.1", "DIRECTORY": "/games/.minecraft/mods"}`,
+			version:   "1.20.1",
+			directory: "/games/.minecraft/mods",
+		},
+		{
+			// what comes before the broken value is kept
+			name:      "wrong types",
+			content:   `{"directory": "/games/.minecraft/mods", "version": 1.2}`,
+			directory: "/games/.minecraft/mods",
+			problems: []string{
+				"json: cannot unmarshal number into Go struct field ConfFile.version of type string",
+				`"version" is missing`,
+			},
+		},
+		{
+			name:      "trailing garbage",
+			content:   "{\"version\": \"1.20.1\", \"directory\": \"/games/.minecraft/mods\"}\n}, \"notifications\": true}",
+			version:   "1.20.1",
+			directory: "/games/.minecraft/mods",
+			problems:  []string{"unexpected content after the settings"},
+		},
+		{
+			name:      "implausible version",
+			content:   `{"version": "latest", "directory": "/games/.minecraft/mods"}`,
+			directory: "/games/.minecraft/mods",
+			problems:  []string{`"version" "latest" is not a Minecraft version`},
+		},
+		{
+			name:     "not JSON",
+			content:  `version = 1.20.1`,
+			problems: []string{"invalid character 'v' looking for beginning of value", `"version" is missing`, `"directory" is missing`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "clientUpdate.json")
+			writeFiles(t, filepath.Dir(p), map[string]string{"clientUpdate.json": test.content})
+			config, problems, err := ReadConfig(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.MCVersion != test.version || config.MCDirectory != test.directory {
+				t.Errorf("read version %q, directory %q", config.MCVersion, config.MCDirectory)
+			}
+			if got, want := strings.Join(problems, "\n"), strings.Join(test.problems, "\n"); got != want {
+				t.Errorf("problems:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+	if _, _, err := ReadConfig(filepath.Join(t.TempDir(), "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("a missing config: %v", err)
+	}
+}
+
+// TestReadConfigEarlierGeneration reads settings that aren't JSON at all,
+// falling back to the last ones saved.
+func TestReadConfigEarlierGeneration(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "clientUpdate.json")
+	writeFiles(t, dir, map[string]string{
+		"clientUpdate.json":   "\x00\x00\x00",
+		"clientUpdate.json.1": "also broken",
+		"clientUpdate.json.2": `{"version": "1.20.1", "directory": "/games/.minecraft/mods"}`,
+	})
+	config, problems, err := ReadConfig(p)
+	if err != nil || config.MCVersion != "1.20.1" || config.MCDirectory != "/games/.minecraft/mods" {
+		t.Fatalf("read %+v, %v", config, err)
+	}
+	if len(problems) != 2 || problems[1] != "the settings of "+p+".2 are used instead" {
+		t.Errorf("problems %q", problems)
+	}
+}
+
+// TestUpdateRecoversConfig updates with a config whose version is under a
+// wrong key: the problems are told, the original is kept aside, and the
+// pack's newest version is saved in the config.
+func TestUpdateRecoversConfig(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods-1.19.2/sodium.jar": "sodium 0.4", "mods-1.20.1/sodium.jar": "sodium 0.5"})
+	configPath := filepath.Join(u.state, "clientUpdate.json")
+	broken := `{"directory": "` + filepath.ToSlash(u.mods) + `", "minecraftVersion": "1.20.1"}`
+	writeFiles(t, u.state, map[string]string{"clientUpdate.json": broken})
+
+	output := readFile(t, runMain(t, "--portable", u.state, "--yes", "--no-telemetry", "--no-tui"))
+	for _, want := range []string{
+		"Some settings in " + configPath + " could not be used:",
+		`  - unknown setting "minecraftVersion", did you mean "version"?`,
+		`  - "version" is missing`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("no %q in the output:\n%s", want, output)
+		}
+	}
+	if got := readFile(t, configPath+".broken"); got != broken {
+		t.Errorf("kept %q", got)
+	}
+	config, problems, err := ReadConfig(configPath)
+	if err != nil || len(problems) != 0 || config.MCVersion != "1.20.1" {
+		t.Errorf("saved %+v: %q, %v", config, problems, err)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 0.5" {
+		t.Errorf("installed sodium %q, output:\n%s", got, output)
+	}
+}
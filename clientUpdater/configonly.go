@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+)
+
+// DetectConfigOnly decides from the preflight of the plan whether the
+// update can leave the mods and the loader alone and only install the
+// pack's transformed files, the configs and server list. It never goes by
+// the pack version: the mods directory, as hashed for this update, must
+// hold exactly the pack's mods, the loader must be installed, and the last
+// update must have been for the same minecraft version and loader.
+// Otherwise it returns what forces the full update.
+func (p *UpdatePlan) DetectConfigOnly(f *Preflight, state *InstalledState) (bool, error) {
+	switch {
+	case p.Prepared == nil:
+		return false, fmt.Errorf("the installed mods weren't checked")
+	case state == nil:
+		return false, fmt.Errorf("no update was recorded yet")
+	case state.MCVersion != p.MCVersion || state.Loader != p.Loader.Name():
+		return false, fmt.Errorf("the last update was for %s %s", state.Loader, state.MCVersion)
+	case p.InstallLoader || p.BootstrapVanilla:
+		return false, fmt.Errorf("%s isn't installed for %s", p.Loader.Name(), p.MCVersion)
+	case f.Add > 0:
+		return false, fmt.Errorf("%d mods are missing or differ from the pack", f.Add)
+	case f.Remove > 0:
+		return false, fmt.Errorf("%d installed files aren't part of the pack", f.Remove)
+	}
+	return true, nil
+}
+
+// executeConfigOnly installs the transformed files of the pack, the mods
+// directory already matching it.
+func (p *UpdatePlan) executeConfigOnly() error {
+	var written []string
+	if manifest := p.Archive.Manifest; p.SkipConfigs {
+		p.skipConfigs()
+	} else if manifest != nil && len(manifest.Transforms) > 0 {
+		if err := p.Guard.Check(); err != nil {
+			return err
+		}
+		p.setPhase(phaseTransforms)
+		var err error
+		written, err = ApplyTransforms(p.Archive.Path, manifest.Transforms, p.GameDir, p.BackupDir, p.Journal, p.TemplateValues, p.Prompt, p.ApplyRecommended)
+		if err != nil {
+			return err
+		}
+	}
+	Logf("config-only update, %d files written", len(written))
+	fmt.Fprintln(p.out(), T("configonly.done", len(written)))
+	return nil
+}
+
+// skipConfigs reports the transformed files left alone for SkipConfigs.
+func (p *UpdatePlan) skipConfigs() {
+	if p.Archive.Manifest == nil || len(p.Archive.Manifest.Transforms) == 0 {
+		return
+	}
+	changes := PlanConfigChanges(p.Archive.Manifest.Transforms, p.GameDir)
+	Logf("configs: skipped %d files outside the mods directory", len(changes))
+	fmt.Fprintln(p.out(), T("configs.skipped", len(changes)))
+}
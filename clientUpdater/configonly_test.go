@@ -0,0 +1,244 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDetectConfigOnly(t *testing.T) {
+	state := &InstalledState{MCVersion: "1.20.1", Loader: "fabric"}
+	plan := func(edit func(p *UpdatePlan)) *UpdatePlan {
+		p := &UpdatePlan{MCVersion: "1.20.1", Loader: fabricLoader{}, Prepared: &Preparation{}}
+		if edit != nil {
+			edit(p)
+		}
+		return p
+	}
+	tests := []struct {
+		name   string
+		plan   *UpdatePlan
+		f      *Preflight
+		state  *InstalledState
+		reason string
+	}{
+		{name: "matching", plan: plan(nil), f: &Preflight{Unchanged: 3}, state: state},
+		{name: "not checked", plan: plan(func(p *UpdatePlan) { p.Prepared = nil }), f: &Preflight{}, state: state, reason: "the installed mods weren't checked"},
+		{name: "first update", plan: plan(nil), f: &Preflight{}, reason: "no update was recorded yet"},
+		{name: "other minecraft", plan: plan(func(p *UpdatePlan) { p.MCVersion = "1.21" }), f: &Preflight{}, state: state, reason: "the last update was for fabric 1.20.1"},
+		{name: "other loader", plan: plan(func(p *UpdatePlan) { p.Loader = quiltLoader{} }), f: &Preflight{}, state: state, reason: "the last update was for fabric 1.20.1"},
+		{name: "no loader", plan: plan(func(p *UpdatePlan) { p.InstallLoader = true }), f: &Preflight{}, state: state, reason: "fabric isn't installed for 1.20.1"},
+		{name: "mod missing", plan: plan(nil), f: &Preflight{Add: 1, Unchanged: 2}, state: state, reason: "1 mods are missing or differ from the pack"},
+		{name: "extra file", plan: plan(nil), f: &Preflight{Remove: 2, Unchanged: 3}, state: state, reason: "2 installed files aren't part of the pack"},
+	}
+	for _, test := range tests {
+		configOnly, err := test.plan.DetectConfigOnly(test.f, test.state)
+		if configOnly != (test.reason == "") || (err == nil) != (test.reason == "") || err != nil && err.Error() != test.reason {
+			t.Errorf("%s: %t, %v", test.name, configOnly, err)
+		}
+	}
+}
+
+// configPack is a release of a pack whose mods don't change, with the
+// server list given.
+func configPack(configOnly bool, servers string) map[string]string {
+	manifest := `{"version": "2024.06", "transforms": {"servers.txt": "template"}}`
+	if configOnly {
+		manifest = `{"version": "2024.07", "configOnly": true, "transforms": {"servers.txt": "template"}}`
+	}
+	return map[string]string{
+		"pack.json":       manifest,
+		"servers.txt":     servers,
+		"mods/sodium.jar": "sodium",
+		"mods/iris.jar":   "iris",
+	}
+}
+
+// TestUpdateConfigOnly updates to a release changing only the server list:
+// only the replaced server list is backed up and the mods aren't moved,
+// whether the release says so or not.
+func TestUpdateConfigOnly(t *testing.T) {
+	for _, marked := range []bool{true, false} {
+		u := newFakeUpdate(t, configPack(false, "play.example.com"))
+		u.run(t)
+		u.setPack(t, configPack(marked, "play.example.com\nevent.example.com"))
+		before := snapshotFiles(t, u.mods)
+
+		output := readFile(t, u.run(t))
+		for _, want := range []string{"config files only, all 2 mods already match the pack", "Config-only update applied, 1 files changed"} {
+			if !strings.Contains(output, want) {
+				t.Errorf("marked %t: no %q in the output:\n%s", marked, want, output)
+			}
+		}
+		if got := readFile(t, filepath.Join(u.minecraft, "servers.txt")); got != "play.example.com\nevent.example.com" {
+			t.Errorf("marked %t: servers.txt is %q", marked, got)
+		}
+		if got := changedFiles(t, u.mods, before); got != "" {
+			t.Errorf("marked %t: changed %s", marked, got)
+		}
+		backup := filepath.Join(u.state, backupsDirName, "20240601-120200")
+		if got := dirNames(t, backup); got != "transforms" {
+			t.Errorf("marked %t: backed up %s", marked, got)
+		} else if got := readFile(t, filepath.Join(backup, "transforms", "servers.txt")); got != "play.example.com" {
+			t.Errorf("marked %t: backed up servers.txt %q", marked, got)
+		}
+	}
+}
+
+// TestUpdateConfigOnlyEscalates updates a player missing a mod, and one
+// with a mod changed, to a release marked config-only: they get the full
+// update.
+func TestUpdateConfigOnlyEscalates(t *testing.T) {
+	for name, damage := range map[string]func(mods string){
+		"missing": func(mods string) { os.Remove(filepath.Join(mods, "iris.jar")) },
+		"changed": func(mods string) { os.WriteFile(filepath.Join(mods, "iris.jar"), []byte("iris, edited"), 0644) },
+	} {
+		u := newFakeUpdate(t, configPack(false, "play.example.com"))
+		u.run(t)
+		u.setPack(t, configPack(true, "event.example.com"))
+		damage(u.mods)
+
+		output := readFile(t, u.run(t))
+		if !strings.Contains(output, "This release only changes config files, but your mods don't match the pack") || strings.Contains(output, "Config-only update applied") {
+			t.Errorf("%s: output:\n%s", name, output)
+		}
+		if got := readFile(t, filepath.Join(u.mods, "iris.jar")); got != "iris" {
+			t.Errorf("%s: iris.jar is %q", name, got)
+		}
+		if got := readFile(t, filepath.Join(u.minecraft, "servers.txt")); got != "event.example.com" {
+			t.Errorf("%s: servers.txt is %q", name, got)
+		}
+	}
+}
+
+// skipConfigsPack is a release of a pack writing a config, a resource
+// pack and the server list next to its mods.
+func skipConfigsPack(release string) map[string]string {
+	return map[string]string{
+		"pack.json":            `{"version": "` + release + `", "transforms": {"config/chat.json": "template", "resourcepacks/rx.zip": "skip-if-exists", "servers.txt": "template"}}`,
+		"config/chat.json":     "chat " + release,
+		"resourcepacks/rx.zip": "resources " + release,
+		"servers.txt":          "servers " + release,
+		"mods/sodium.jar":      "sodium " + release,
+	}
+}
+
+// TestUpdateSkipConfigs applies only the mods of an update with
+// --skip-configs: the configs are neither written nor recorded as
+// applied, the next update offers and writes them.
+func TestUpdateSkipConfigs(t *testing.T) {
+	u := newFakeUpdate(t, skipConfigsPack("2024.06"))
+	writeFiles(t, u.minecraft, map[string]string{"config/chat.json": "mine"})
+	installedPath := filepath.Join(u.state, "clientUpdate-installed.json")
+
+	output := readFile(t, u.run(t, "--skip-configs"))
+	for _, want := range []string{"Configs:    3 files outside the mods folder are left alone for this run", T("configs.skipped", 3)} {
+		if !strings.Contains(output, want) {
+			t.Errorf("no %q in the output:\n%s", want, output)
+		}
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 2024.06" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+	if got := readFile(t, filepath.Join(u.minecraft, "config", "chat.json")); got != "mine" {
+		t.Errorf("chat.json is %q", got)
+	}
+	for _, name := range []string{"resourcepacks", "servers.txt"} {
+		if _, err := os.Stat(filepath.Join(u.minecraft, name)); !os.IsNotExist(err) {
+			t.Errorf("wrote %s: %v", name, err)
+		}
+	}
+	state, err := ReadInstalledState(installedPath)
+	if err != nil || state == nil {
+		t.Fatalf("installed state %v, %v", state, err)
+	}
+	var files []string
+	for _, file := range state.Files {
+		files = append(files, file.Name)
+	}
+	if got := strings.Join(files, " "); got != "sodium.jar" {
+		t.Errorf("recorded %s as installed", got)
+	}
+	if got := strings.Join(state.SkippedConfigs, " "); got != "config/chat.json resourcepacks/rx.zip servers.txt" {
+		t.Errorf("recorded %s as skipped", got)
+	}
+
+	output = readFile(t, u.run(t))
+	if !strings.Contains(output, T("configs.pending", 3)) || !strings.Contains(output, "config/chat.json (replaces yours, which is kept in the backup)") {
+		t.Errorf("output:\n%s", output)
+	}
+	for name, want := range map[string]string{"config/chat.json": "chat 2024.06", "resourcepacks/rx.zip": "resources 2024.06", "servers.txt": "servers 2024.06"} {
+		if got := readFile(t, filepath.Join(u.minecraft, filepath.FromSlash(name))); got != want {
+			t.Errorf("%s is %q", name, got)
+		}
+	}
+	if state, err := ReadInstalledState(installedPath); err != nil || len(state.SkippedConfigs) != 0 {
+		t.Errorf("installed state %+v, %v", state, err)
+	}
+}
+
+// TestUpdateDeclinesConfigs answers no to writing the configs of an
+// interactive update: only the mods are updated.
+func TestUpdateDeclinesConfigs(t *testing.T) {
+	for _, declined := range []bool{true, false} {
+		u := newFakeUpdate(t, skipConfigsPack("2024.06"))
+		if err := os.MkdirAll(u.state, 0755); err != nil {
+			t.Fatal(err)
+		}
+		SaveConfig(ConfFile{MCDirectory: u.mods, MCVersion: "1.20.1"}, filepath.Join(u.state, "clientUpdate.json"))
+		// yes to the update, the answer to the configs, then yes to
+		// everything else
+		answer := map[bool]string{true: "n", false: "y"}[declined]
+		useStdin(t, "y\n"+answer+"\n"+strings.Repeat("y\n", 10))
+		u.clock.Advance(time.Minute)
+		output := readFile(t, runMain(t, "--portable", u.state, "--no-telemetry", "--no-tui"))
+
+		if !strings.Contains(output, T("configs.confirm", 3)) {
+			t.Errorf("declined %t: not asked:\n%s", declined, output)
+		}
+		if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 2024.06" {
+			t.Errorf("declined %t: sodium.jar is %q", declined, got)
+		}
+		_, err := os.Stat(filepath.Join(u.minecraft, "servers.txt"))
+		if written := err == nil; written == declined {
+			t.Errorf("declined %t: servers.txt written %t", declined, written)
+		}
+		state, err := ReadInstalledState(filepath.Join(u.state, "clientUpdate-installed.json"))
+		if err != nil || state == nil || (len(state.SkippedConfigs) == 3) != declined {
+			t.Errorf("declined %t: installed state %+v, %v", declined, state, err)
+		}
+	}
+}
+
+// TestPlanSkipConfigs saves the plan of a mods-only update: it leaves out
+// the writes of the configs.
+func TestPlanSkipConfigs(t *testing.T) {
+	u := newFakeUpdate(t, skipConfigsPack("2024.06"))
+	for _, skip := range []bool{false, true} {
+		planPath := filepath.Join(t.TempDir(), "plan.json")
+		args := []string{"plan", "--out", planPath}
+		if skip {
+			args = append([]string{"--skip-configs"}, args...)
+		}
+		u.run(t, args...)
+		plan, err := ReadPlanFile(planPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, file := range plan.Files {
+			paths = append(paths, file.Path)
+		}
+		want := "config/chat.json resourcepacks/rx.zip servers.txt sodium.jar"
+		if skip {
+			want = "sodium.jar"
+		}
+		sort.Strings(paths)
+		if got := strings.Join(paths, " "); got != want {
+			t.Errorf("skip %t: planned %s", skip, got)
+		}
+	}
+}
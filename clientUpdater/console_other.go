@@ -0,0 +1,7 @@
+//go:build !windows
+
+package main
+
+// EnableUTF8Console is a no-op, terminals outside Windows already speak
+// UTF-8 (or whatever the locale says, which Go leaves alone).
+func EnableUTF8Console() {}
@@ -0,0 +1,19 @@
+package main
+
+import "syscall"
+
+// cpUTF8 is the UTF-8 console code page.
+const cpUTF8 = 65001
+
+// EnableUTF8Console switches the console to UTF-8 so paths with umlauts or
+// CJK characters are printed and read back unmangled instead of through the
+// legacy OEM code page.
+func EnableUTF8Console() {
+	kernel32 := syscall.NewLazyDLL("kernel32.dll")
+	for _, name := range []string{"SetConsoleOutputCP", "SetConsoleCP"} {
+		proc := kernel32.NewProc(name)
+		if proc.Find() == nil {
+			proc.Call(uintptr(cpUTF8))
+		}
+	}
+}
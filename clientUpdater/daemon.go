@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultDaemonInterval is how often --daemon updates unless told
+// otherwise.
+const defaultDaemonInterval = 6 * time.Hour
+
+// daemonPoll is how often the daemon looks whether the next update is due
+// or it was asked to stop.
+const daemonPoll = time.Second
+
+// statusLogLines is how many of the last lines of the log the status page
+// shows.
+const statusLogLines = 50
+
+// statusFileEnv names the file an update started by the daemon publishes
+// its phase and progress to, see PublishStatus.
+const statusFileEnv = "CLIENTUPDATE_STATUS_FILE"
+
+// daemonFlags are the flags of the daemon itself, not handed on to the
+// updates it starts.
+var daemonFlags = map[string]bool{"daemon": true, "interval": true, "status-port": true}
+
+// DaemonRun is an update the daemon started.
+type DaemonRun struct {
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// Result is ok, failed, timeout or interrupted.
+	Result   string `json:"result,omitempty"`
+	ExitCode int    `json:"exitCode"`
+}
+
+// DaemonStatus is what the status page shows.
+type DaemonStatus struct {
+	Interval string     `json:"interval"`
+	NextRun  *time.Time `json:"nextRun,omitempty"`
+	LastRun  *DaemonRun `json:"lastRun,omitempty"`
+	// Current is the update running right now, Phase how far it got.
+	Current *DaemonRun `json:"current,omitempty"`
+	Phase   *RunStatus `json:"phase,omitempty"`
+	Pack    *PackState `json:"pack,omitempty"`
+	// Frozen is set while the player is frozen on the pack version.
+	Frozen *FreezeState `json:"frozen,omitempty"`
+	// Staged is the update waiting to be applied, if any.
+	Staged *StagedUpdate `json:"staged,omitempty"`
+	System *SystemFacts  `json:"system,omitempty"`
+	Log    []string      `json:"log"`
+}
+
+// PackState is the pack the last update installed.
+type PackState struct {
+	Version   string    `json:"version,omitempty"`
+	Commit    string    `json:"commit,omitempty"`
+	MCVersion string    `json:"mcVersion"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Daemon updates every Interval until stopped.
+type Daemon struct {
+	Interval time.Duration
+	// Update runs one update and returns its exit code.
+	Update func() int
+	// StatusPath is where the running update publishes its phase, LogPath,
+	// InstalledPath, ConfigPath and StagedPath the log, installed state,
+	// config and staged update shown on the status page.
+	StatusPath    string
+	LogPath       string
+	InstalledPath string
+	ConfigPath    string
+	StagedPath    string
+	// System is what the daemon found out about the system when started.
+	System *SystemFacts
+
+	mu      sync.Mutex
+	last    *DaemonRun
+	current *DaemonRun
+	next    time.Time
+}
+
+// Loop updates right away and then every Interval on the clock, until stop
+// is closed. A running update is finished first.
+func (d *Daemon) Loop(stop <-chan struct{}) {
+	for {
+		d.runOnce()
+		next := clock.Now().Add(d.Interval)
+		d.mu.Lock()
+		d.next = next
+		d.mu.Unlock()
+		Logf("daemon: next update at %s", next.Format(time.RFC3339))
+		for clock.Now().Before(next) {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			clock.Sleep(daemonPoll)
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// runOnce runs an update and records how it went.
+func (d *Daemon) runOnce() {
+	run := &DaemonRun{Started: clock.Now()}
+	d.mu.Lock()
+	d.current = run
+	d.mu.Unlock()
+	os.Remove(d.StatusPath)
+	Logf("daemon: update started")
+
+	code := d.Update()
+
+	finished := clock.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	run.Finished, run.ExitCode = &finished, code
+	switch code {
+	case exitOK, exitUpToDate, exitWarnings:
+		run.Result = "ok"
+	case exitTimedOut:
+		run.Result = "timeout"
+	case exitInterrupted:
+		run.Result = "interrupted"
+	default:
+		run.Result = "failed"
+	}
+	d.current, d.last = nil, run
+	Logf("daemon: update %s, exit code %d", run.Result, code)
+}
+
+// Status returns what the status page shows.
+func (d *Daemon) Status() DaemonStatus {
+	d.mu.Lock()
+	status := DaemonStatus{Interval: d.Interval.String(), System: d.System, Log: []string{}}
+	if !d.next.IsZero() {
+		next := d.next
+		status.NextRun = &next
+	}
+	if d.last != nil {
+		last := *d.last
+		status.LastRun = &last
+	}
+	if d.current != nil {
+		current := *d.current
+		status.Current = &current
+	}
+	d.mu.Unlock()
+
+	if status.Current != nil {
+		var phase RunStatus
+		if content, err := ioutil.ReadFile(d.StatusPath); err == nil && json.Unmarshal(content, &phase) == nil {
+			status.Phase = &phase
+		}
+	}
+	if state, err := ReadInstalledState(d.InstalledPath); err == nil && state != nil {
+		status.Pack = &PackState{Version: state.PackVersion, Commit: state.PackCommit, MCVersion: state.MCVersion, UpdatedAt: state.UpdatedAt}
+	}
+	if config, _, err := ReadConfig(d.ConfigPath); err == nil {
+		status.Frozen = freezeState(config, clock.Now())
+	}
+	if staged, err := ReadStagedUpdate(d.StagedPath); err == nil {
+		status.Staged = staged
+	}
+	if lines, err := tailLines(d.LogPath, statusLogLines); err == nil {
+		status.Log = lines
+	}
+	return status
+}
+
+// ServeHTTP answers GET / and /status with the status as JSON.
+func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" && r.URL.Path != "/status" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	content, err := json.MarshalIndent(d.Status(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(append(content, '\n'))
+}
+
+// tailLines returns the last n lines of the file at p, reading no more than
+// the end of it.
+func tailLines(p string, n int) ([]string, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	const window = 64 << 10
+	offset := info.Size() - window
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if offset > 0 && len(lines) > 0 {
+		// the first line is most likely cut off
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, "\r")
+	}
+	return lines, nil
+}
+
+// updateProcess runs updates as child processes of the updater with the
+// command line of the daemon, minus its own flags and answering yes. Each
+// update runs in its own process, so one that exits or crashes doesn't
+// take the daemon with it.
+type updateProcess struct {
+	args       []string
+	statusPath string
+
+	mu  sync.Mutex
+	cmd *exec.Cmd
+}
+
+// newUpdateProcess builds the command line of the updates from the flags
+// given to the daemon.
+func newUpdateProcess(statusPath string) *updateProcess {
+	args := []string{"-yes"}
+	flag.Visit(func(f *flag.Flag) {
+		if !daemonFlags[f.Name] && f.Name != "yes" {
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	return &updateProcess{args: append(args, flag.Args()...), statusPath: statusPath}
+}
+
+// Run runs one update and returns its exit code.
+func (u *updateProcess) Run() int {
+	exe, err := os.Executable()
+	if err != nil {
+		Logf("daemon: %s", err)
+		return 1
+	}
+	cmd := exec.Command(exe, u.args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), statusFileEnv+"="+u.statusPath)
+	u.mu.Lock()
+	err = cmd.Start()
+	if err == nil {
+		u.cmd = cmd
+	}
+	u.mu.Unlock()
+	if err != nil {
+		Logf("daemon: starting the update: %s", err)
+		return 1
+	}
+	err = cmd.Wait()
+	u.mu.Lock()
+	u.cmd = nil
+	u.mu.Unlock()
+	if exit, ok := err.(*exec.ExitError); ok {
+		return exit.ExitCode()
+	} else if err != nil {
+		Logf("daemon: update: %s", err)
+		return 1
+	}
+	return 0
+}
+
+// Interrupt asks a running update to stop, which rolls back a swap in
+// progress. Where the update can't be signalled, e.g. on Windows, it
+// shares the console and gets Ctrl-C there.
+func (u *updateProcess) Interrupt() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.cmd != nil && u.cmd.Process != nil {
+		if err := u.cmd.Process.Signal(os.Interrupt); err != nil {
+			Logf("daemon: interrupting the update: %s", err)
+		}
+	}
+}
+
+// RunDaemon updates every interval until interrupted, serving the status
+// page on port of 127.0.0.1 unless port is 0. The page needs no
+// authentication because nothing but the machine itself can reach it.
+func RunDaemon(interval time.Duration, port int, logPath string, installedPath string, statusPath string, configPath string, stagedPath string) error {
+	process := newUpdateProcess(statusPath)
+	system := ProbeSystem()
+	daemon := &Daemon{
+		Interval:      interval,
+		Update:        process.Run,
+		StatusPath:    statusPath,
+		LogPath:       logPath,
+		InstalledPath: installedPath,
+		ConfigPath:    configPath,
+		StagedPath:    stagedPath,
+		System:        &system,
+	}
+
+	var server *http.Server
+	if port != 0 {
+		listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			return err
+		}
+		server = &http.Server{Handler: daemon, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+				Logf("daemon: status page: %s", err)
+			}
+		}()
+		fmt.Println(T("daemon.status", "http://"+listener.Addr().String()+"/status"))
+	}
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		Logf("daemon: received %s, stopping", sig)
+		fmt.Println(T("daemon.stopping"))
+		close(stop)
+		process.Interrupt()
+	}()
+
+	fmt.Println(T("daemon.start", interval))
+	Logf("daemon: updating every %s", interval)
+	daemon.Loop(stop)
+
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}
+	os.Remove(statusPath)
+	Logf("daemon: stopped")
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestDaemon is a daemon with its files in a temporary directory,
+// running update.
+func newTestDaemon(t *testing.T, update func() int) *Daemon {
+	dir := t.TempDir()
+	return &Daemon{
+		Interval:      6 * time.Hour,
+		Update:        update,
+		StatusPath:    filepath.Join(dir, "clientUpdate-status.json"),
+		LogPath:       filepath.Join(dir, "clientUpdate.log"),
+		InstalledPath: filepath.Join(dir, "clientUpdate-installed.json"),
+		ConfigPath:    filepath.Join(dir, "clientUpdate.json"),
+		StagedPath:    filepath.Join(dir, "clientUpdate-staged.json"),
+	}
+}
+
+// getStatus fetches the status page of server at path.
+func getStatus(t *testing.T, server *httptest.Server, path string) DaemonStatus {
+	t.Helper()
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("%s: %s, %s", path, resp.Status, resp.Header.Get("Content-Type"))
+	}
+	var status DaemonStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+// TestDaemonLoop runs the loop on the fake clock, each update taking a
+// minute: updates start every interval after the last one finished, and
+// the loop stops once asked to without starting another.
+func TestDaemonLoop(t *testing.T) {
+	fake := useFakeClock(t)
+	start := fake.Now()
+	stop := make(chan struct{})
+	var started []time.Duration
+	codes := []int{exitOK, 1, exitTimedOut}
+	var d *Daemon
+	d = newTestDaemon(t, func() int {
+		started = append(started, clock.Now().Sub(start))
+		fake.Advance(time.Minute)
+		if len(started) == len(codes) {
+			close(stop)
+		}
+		return codes[len(started)-1]
+	})
+
+	done := make(chan struct{})
+	go func() {
+		d.Loop(stop)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the loop didn't stop")
+	}
+	if got := fmt.Sprint(started); got != "[0s 6h1m0s 12h2m0s]" {
+		t.Errorf("updates started at %s", got)
+	}
+	status := d.Status()
+	if status.LastRun == nil || status.LastRun.Result != "timeout" || status.LastRun.ExitCode != exitTimedOut || status.Current != nil {
+		t.Errorf("status %+v", status)
+	}
+	if want := start.Add(18*time.Hour + 3*time.Minute); status.NextRun == nil || !status.NextRun.Equal(want) {
+		t.Errorf("next run %v, want %v", status.NextRun, want)
+	}
+}
+
+func TestDaemonResults(t *testing.T) {
+	useFakeClock(t)
+	for code, want := range map[int]string{exitOK: "ok", exitUpToDate: "ok", exitWarnings: "ok", 1: "failed", exitTimedOut: "timeout", exitInterrupted: "interrupted", 2: "failed"} {
+		d := newTestDaemon(t, func() int { return code })
+		d.runOnce()
+		if last := d.Status().LastRun; last == nil || last.Result != want || last.Finished == nil {
+			t.Errorf("exit code %d: %+v, want %s", code, last, want)
+		}
+	}
+}
+
+// TestDaemonStatusPage queries the status page while an update runs,
+// publishing its phase, and after it finished.
+func TestDaemonStatusPage(t *testing.T) {
+	fake := useFakeClock(t)
+	useRunState(t)
+	var d *Daemon
+	var server *httptest.Server
+	var running DaemonStatus
+	d = newTestDaemon(t, func() int {
+		PublishStatus(d.StatusPath)
+		SetPhase(phaseDownload)
+		// progress is published at most every statusInterval
+		fake.Advance(time.Minute)
+		SetProgress(250, 1000)
+		running = getStatus(t, server, "/")
+		return exitOK
+	})
+	server = httptest.NewServer(d)
+	defer server.Close()
+
+	if err := WriteInstalledState(d.InstalledPath, &InstalledState{PackVersion: "2.1.0", PackCommit: "0123abc", MCVersion: "1.20.1", Loader: "fabric", UpdatedAt: fake.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	var log strings.Builder
+	for i := 1; i <= statusLogLines+10; i++ {
+		fmt.Fprintf(&log, "line %d\r\n", i)
+	}
+	writeFiles(t, filepath.Dir(d.LogPath), map[string]string{filepath.Base(d.LogPath): log.String()})
+
+	idle := getStatus(t, server, "/status")
+	if idle.Interval != "6h0m0s" || idle.LastRun != nil || idle.Current != nil || idle.Phase != nil || idle.NextRun != nil {
+		t.Errorf("before the first update: %+v", idle)
+	}
+	if idle.Pack == nil || idle.Pack.Version != "2.1.0" || idle.Pack.Commit != "0123abc" || idle.Pack.MCVersion != "1.20.1" {
+		t.Errorf("pack %+v", idle.Pack)
+	}
+	if len(idle.Log) != statusLogLines || idle.Log[0] != "line 11" || idle.Log[statusLogLines-1] != "line 60" {
+		t.Errorf("log %q", idle.Log)
+	}
+
+	d.runOnce()
+	if running.Current == nil || running.Phase == nil || running.Phase.Phase != phaseDownload || running.Phase.Progress == nil || running.Phase.Progress.Percent != 25 {
+		t.Errorf("while updating: %+v, phase %+v", running, running.Phase)
+	}
+	finished := getStatus(t, server, "/status")
+	if finished.Current != nil || finished.Phase != nil || finished.LastRun == nil || finished.LastRun.Result != "ok" {
+		t.Errorf("after the update: %+v", finished)
+	}
+}
+
+func TestDaemonStatusPageRequests(t *testing.T) {
+	useFakeClock(t)
+	server := httptest.NewServer(newTestDaemon(t, nil))
+	defer server.Close()
+	// no log yet shows as an empty list
+	if status := getStatus(t, server, "/"); status.Log == nil || len(status.Log) != 0 {
+		t.Errorf("log %q", status.Log)
+	}
+	resp, err := http.Get(server.URL + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("/other: %s", resp.Status)
+	}
+	resp, err = http.Post(server.URL+"/status", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET, HEAD" {
+		t.Errorf("POST: %s, allowing %s", resp.Status, resp.Header.Get("Allow"))
+	}
+}
+
+func TestTailLines(t *testing.T) {
+	dir := t.TempDir()
+	long := strings.Repeat("x", 70<<10)
+	writeFiles(t, dir, map[string]string{
+		"short.log": "one\ntwo\r\nthree\n",
+		"long.log":  long + "\ncut\nlast\n",
+	})
+	if lines, err := tailLines(filepath.Join(dir, "short.log"), 2); err != nil || strings.Join(lines, "|") != "two|three" {
+		t.Errorf("short: %q, %v", lines, err)
+	}
+	// only the end of a long file is read, its first line dropped
+	if lines, err := tailLines(filepath.Join(dir, "long.log"), 10); err != nil || strings.Join(lines, "|") != "cut|last" {
+		t.Errorf("long: %q, %v", lines, err)
+	}
+	if _, err := tailLines(filepath.Join(dir, "missing.log"), 10); err == nil {
+		t.Error("tailed a missing file")
+	}
+}
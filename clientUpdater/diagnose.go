@@ -0,0 +1,273 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Limits of what a diagnostic bundle takes from growing files; the end is
+// what matters for a report.
+const (
+	diagnoseLogTail     = 1 << 20
+	diagnoseCrashTail   = 512 << 10
+	diagnoseJavaWait    = 10 * time.Second
+	diagnoseNetworkWait = 15 * time.Second
+)
+
+// Credentials in key=value pairs and URLs, e.g. a mirror with an access
+// token or a user and password.
+var (
+	credentialPattern = regexp.MustCompile(`(?i)((?:token|key|secret|password|passwd|auth|sig)[a-z_]*=)[^&\s"']+`)
+	userInfoPattern   = regexp.MustCompile(`://[^/@\s"']+@`)
+)
+
+// DiagnoseInputs are the files a diagnostic bundle is gathered from. Only
+// these are ever read, so saves, screenshots and anything else of the
+// player's stay out of it.
+type DiagnoseInputs struct {
+	Config        ConfFile
+	LogPath       string
+	InstalledPath string
+	ModPath       string
+	MinecraftPath string
+	Loader        Loader
+	// Network is what DiagnoseNetwork found.
+	Network string
+}
+
+// diagnoseFile is one file of the bundle.
+type diagnoseFile struct {
+	name    string
+	content string
+}
+
+// WriteDiagnoseBundle gathers everything needed to support a player into
+// clientUpdate-diagnose-<time>.zip in dir and returns its path. Paths
+// below the home directory and credentials are redacted from every file.
+func WriteDiagnoseBundle(dir string, in DiagnoseInputs) (string, error) {
+	files := []diagnoseFile{
+		{"config.json", diagnoseConfig(in.Config)},
+		{"system.txt", diagnoseSystem()},
+		{"mods.txt", diagnoseMods(in.ModPath)},
+		{"verify.txt", diagnoseVerify(in)},
+		{"network.txt", in.Network},
+	}
+	if content, err := ioutil.ReadFile(in.InstalledPath); err == nil {
+		files = append(files, diagnoseFile{"installed.json", string(content)})
+	}
+	if content, err := readTail(in.LogPath, diagnoseLogTail); err == nil {
+		files = append(files, diagnoseFile{"clientUpdate.log", content})
+	}
+	if crash := newestFile(filepath.Join(in.MinecraftPath, "crash-reports")); crash != "" {
+		if content, err := readTail(crash, diagnoseCrashTail); err == nil {
+			files = append(files, diagnoseFile{"crash-report.txt", content})
+		}
+	}
+
+	p := filepath.Join(dir, "clientUpdate-diagnose-"+clock.Now().Format("20060102-150405")+".zip")
+	out, err := os.Create(p)
+	if err != nil {
+		return "", err
+	}
+	w := zip.NewWriter(countingWriter{out})
+	for _, file := range files {
+		var f io.Writer
+		if f, err = w.Create(file.name); err != nil {
+			break
+		}
+		if _, err = io.WriteString(f, redactDiagnostics(file.content)); err != nil {
+			break
+		}
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(p)
+		return "", err
+	}
+	return p, nil
+}
+
+// redactDiagnostics removes secrets, credentials and the home directory
+// from s.
+func redactDiagnostics(s string) string {
+	s = Redact(s)
+	s = credentialPattern.ReplaceAllString(s, "$1[redacted]")
+	s = userInfoPattern.ReplaceAllString(s, "://[redacted]@")
+	if home := baseDirs.Home; home != "" {
+		s = strings.Replace(s, home, "~", -1)
+		// config.json escapes the backslashes of Windows paths
+		s = strings.Replace(s, strings.Replace(home, `\`, `\\`, -1), "~", -1)
+	}
+	return s
+}
+
+// diagnoseConfig is the configuration without the values entered for the
+// pack's templates or the install id.
+func diagnoseConfig(config ConfFile) string {
+	if len(config.TemplateValues) > 0 {
+		redacted := map[string]string{}
+		for name := range config.TemplateValues {
+			redacted[name] = "[redacted]"
+		}
+		config.TemplateValues = redacted
+	}
+	config.InstallID = ""
+	content, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(content) + "\n"
+}
+
+// diagnoseSystem describes the updater, the operating system and java.
+func diagnoseSystem() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "updater: %s\n", VersionString())
+	fmt.Fprintf(&b, "os: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	facts := ProbeSystem()
+	fmt.Fprintf(&b, "memory: %d MB\njava major version: %d\n", facts.TotalRAMMB, facts.Java)
+	ctx, cancel := context.WithTimeout(context.Background(), diagnoseJavaWait)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, javaCommand, "-version").CombinedOutput()
+	fmt.Fprintf(&b, "java -version:\n%s", output)
+	if err != nil {
+		fmt.Fprintf(&b, "(%s)\n", err)
+	}
+	return b.String()
+}
+
+// DiagnoseNetwork tells, for the host of each of urls, whether it can be
+// resolved and reached over IPv4 and over IPv6.
+func DiagnoseNetwork(urls []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "network setting: %q\n", dialer.preference)
+	seen := map[string]bool{}
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() == "" || seen[u.Host] {
+			continue
+		}
+		seen[u.Host] = true
+		port := u.Port()
+		if port == "" {
+			port = "443"
+			if u.Scheme == "http" {
+				port = "80"
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), diagnoseNetworkWait)
+		fmt.Fprintf(&b, "%s:\n", u.Host)
+		for _, check := range CheckHost(ctx, u.Hostname(), port) {
+			fmt.Fprintf(&b, "  %s\n", check)
+		}
+		cancel()
+	}
+	return b.String()
+}
+
+// diagnoseMods lists the files of the mods directory with their hashes.
+func diagnoseMods(modPath string) string {
+	files, err := ScanInstalledFiles(modPath)
+	if err != nil {
+		return fmt.Sprintf("%s: %s\n", modPath, err)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s, %d files\n", modPath, len(files))
+	for _, file := range files {
+		fmt.Fprintf(&b, "%s  %10d  %s\n", file.SHA256, file.Size, file.Name)
+	}
+	return b.String()
+}
+
+// diagnoseVerify runs the checks an update would: the mods directory, the
+// loader, the installed files against the last update and the jars'
+// metadata.
+func diagnoseVerify(in DiagnoseInputs) string {
+	var b strings.Builder
+	status := CheckModsDir(in.ModPath)
+	fmt.Fprintf(&b, "mods directory: exists=%t writable=%t locked=%t err=%v\n", status.Exists, status.Writable, status.Locked, status.Err)
+	if in.Loader != nil {
+		root, _ := FindMinecraftRoot(in.ModPath)
+		installed, err := LoaderInstalled(in.Loader, filepath.Join(root, "versions"), in.Config.MCVersion)
+		fmt.Fprintf(&b, "%s for %s installed: %t", in.Loader.Name(), in.Config.MCVersion, installed)
+		if err != nil {
+			fmt.Fprintf(&b, " (%s)", err)
+		}
+		b.WriteString("\n")
+		if foreign, err := CheckModMetadata(in.Loader, in.ModPath); err == nil && len(foreign) > 0 {
+			fmt.Fprintf(&b, "without %s: %s\n", in.Loader.MetadataFile(), strings.Join(foreign, ", "))
+		}
+	}
+	state, err := ReadInstalledState(in.InstalledPath)
+	switch {
+	case err != nil:
+		fmt.Fprintf(&b, "installed state: %s\n", err)
+	case state == nil:
+		b.WriteString("installed state: none recorded\n")
+	default:
+		damaged := VerifyInstalled(state, in.ModPath)
+		fmt.Fprintf(&b, "installed state: pack %s, %d files, %d missing or changed\n", state.PackVersion, len(state.Files), len(damaged))
+		for _, file := range damaged {
+			fmt.Fprintf(&b, "  %s\n", file.Name)
+		}
+	}
+	return b.String()
+}
+
+// readTail returns the last max bytes of the file at p.
+func readTail(p string, max int64) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > max {
+		if _, err := f.Seek(-max, io.SeekEnd); err != nil {
+			return "", err
+		}
+	}
+	content, err := ioutil.ReadAll(f)
+	return string(content), err
+}
+
+// newestFile returns the most recently modified regular file directly in
+// dir, "" when there is none.
+func newestFile(dir string) string {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	var files []os.FileInfo
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() {
+			files = append(files, entry)
+		}
+	}
+	if len(files) == 0 {
+		return ""
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+	return filepath.Join(dir, files[0].Name())
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// noSpaceError is a write that ran out of space on its disk. Whatever it
+// was writing is removed again, a cut off jar would otherwise look
+// installed to the next run and crash the game.
+type noSpaceError struct {
+	path string
+	// free is what the disk has left, -1 when it can't be told; needed
+	// is what the write and those after it still wanted, 0 when unknown.
+	free   int64
+	needed int64
+	err    error
+}
+
+func (e *noSpaceError) Error() string {
+	var space []string
+	if e.free >= 0 {
+		space = append(space, megabytes(e.free)+" free")
+	}
+	if e.needed > 0 {
+		space = append(space, megabytes(e.needed)+" needed")
+	}
+	if len(space) == 0 {
+		return fmt.Sprintf("the disk ran out of space writing %s; free up space and run the update again", e.path)
+	}
+	return fmt.Sprintf("the disk ran out of space writing %s (%s); free up space and run the update again", e.path, strings.Join(space, ", "))
+}
+
+func (e *noSpaceError) Unwrap() error { return e.err }
+
+func (e *noSpaceError) Category() string { return categoryLocal }
+
+// diskFull is the first write of the run that ran out of space. Once it is
+// set, nothing new is started to write.
+var diskFull struct {
+	sync.Mutex
+	err *noSpaceError
+}
+
+// DiskFull returns the write that ran out of space, nil while none did.
+func DiskFull() error {
+	diskFull.Lock()
+	defer diskFull.Unlock()
+	if diskFull.err == nil {
+		return nil
+	}
+	return diskFull.err
+}
+
+// isNoSpace reports whether err is a write that ran out of space, the disk
+// or the player's quota being full, or one that wrote less than it was
+// given.
+func isNoSpace(err error) bool {
+	if errors.Is(err, io.ErrShortWrite) || errors.As(err, new(*noSpaceError)) {
+		return true
+	}
+	for _, errno := range noSpaceErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// spaceError turns err, from writing p, into a *noSpaceError when the disk
+// ran out of space, needed being what was still to be written; other
+// errors are returned as they are. The first one stops new writes, see
+// DiskFull.
+func spaceError(p string, needed int64, err error) error {
+	if !isNoSpace(err) {
+		return err
+	}
+	var full *noSpaceError
+	if !errors.As(err, &full) {
+		full = &noSpaceError{path: p, free: -1, needed: needed, err: err}
+		if free, err := freeSpace(existingAncestor(filepath.Dir(p))); err == nil {
+			full.free = free
+		} else {
+			Logf("diskspace: free space below %s: %s", p, err)
+		}
+	}
+	diskFull.Lock()
+	if diskFull.err == nil {
+		diskFull.err = full
+		Logf("diskspace: %s", full)
+	}
+	diskFull.Unlock()
+	return full
+}
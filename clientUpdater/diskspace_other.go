@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// noSpaceErrnos are the errors of writes to a full disk.
+var noSpaceErrnos = []syscall.Errno{syscall.ENOSPC, syscall.EDQUOT}
+
+// freeSpace returns the bytes the disk of dir has left for the user.
+func freeSpace(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
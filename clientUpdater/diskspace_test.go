@@ -0,0 +1,345 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fullDisk is a disk with room for left more bytes, after which every
+// write fails the way a write to a full disk does, having written what
+// still fit.
+type fullDisk struct {
+	mu   sync.Mutex
+	left int64
+}
+
+func (d *fullDisk) write(w io.Writer, p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.left < 0 {
+		return w.Write(p)
+	}
+	fits := p
+	if int64(len(p)) > d.left {
+		fits = p[:d.left]
+	}
+	n, err := w.Write(fits)
+	d.left -= int64(n)
+	if err == nil && n < len(p) {
+		err = &os.PathError{Op: "write", Err: noSpaceErrnos[0]}
+	}
+	return n, err
+}
+
+// Free gives the disk all the room it needs again, and forgets it ran
+// full, as a new run would.
+func (d *fullDisk) Free() {
+	d.mu.Lock()
+	d.left = -1
+	d.mu.Unlock()
+	resetDiskFull()
+}
+
+func resetDiskFull() {
+	diskFull.Lock()
+	diskFull.err = nil
+	diskFull.Unlock()
+}
+
+// useFullDisk lets the updater's writes fill a disk with room for left
+// bytes.
+func useFullDisk(t *testing.T, left int64) *fullDisk {
+	d := &fullDisk{left: left}
+	saved := diskWrite
+	diskWrite = d.write
+	t.Cleanup(func() {
+		diskWrite = saved
+		resetDiskFull()
+	})
+	return d
+}
+
+// walkNames lists every file below dir, relative to it.
+func walkNames(t *testing.T, dir string) string {
+	t.Helper()
+	var names []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestIsNoSpace(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		err  error
+		full bool
+	}{
+		{"disk full", &os.PathError{Op: "write", Path: "sodium.jar", Err: noSpaceErrnos[0]}, true},
+		{"quota", &os.PathError{Op: "write", Path: "sodium.jar", Err: noSpaceErrnos[len(noSpaceErrnos)-1]}, true},
+		{"short write", io.ErrShortWrite, true},
+		{"already turned", &noSpaceError{path: "sodium.jar", err: io.ErrShortWrite}, true},
+		{"permission denied", &os.PathError{Op: "open", Path: "sodium.jar", Err: os.ErrPermission}, false},
+		{"nil", nil, false},
+	} {
+		if got := isNoSpace(test.err); got != test.full {
+			t.Errorf("%s: %v", test.name, got)
+		}
+	}
+}
+
+func TestSpaceError(t *testing.T) {
+	useFullDisk(t, -1)
+	dir := t.TempDir()
+	other := errors.New("permission denied")
+	if err := spaceError(filepath.Join(dir, "sodium.jar"), 1<<20, other); err != other || DiskFull() != nil {
+		t.Errorf("turned %v, latched %v", err, DiskFull())
+	}
+
+	p := filepath.Join(dir, "mods", "sodium.jar")
+	err := spaceError(p, 3<<20, &os.PathError{Op: "write", Path: p, Err: noSpaceErrnos[0]})
+	var full *noSpaceError
+	if !errors.As(err, &full) || ErrorCategory(err) != categoryLocal {
+		t.Fatalf("turned into %v", err)
+	}
+	if full.free < 0 || !strings.Contains(err.Error(), p) || !strings.Contains(err.Error(), "3.0 MB needed") || !strings.Contains(err.Error(), " free, ") {
+		t.Errorf("error %q", err)
+	}
+	if !errors.Is(err, noSpaceErrnos[0]) {
+		t.Error("lost the write's error")
+	}
+	// the first write running out of space is the one kept
+	spaceError(filepath.Join(dir, "lithium.jar"), 1, io.ErrShortWrite)
+	if DiskFull() != err {
+		t.Errorf("latched %v", DiskFull())
+	}
+
+	if got := (&noSpaceError{path: p, free: -1}).Error(); got != "the disk ran out of space writing "+p+"; free up space and run the update again" {
+		t.Errorf("without sizes %q", got)
+	}
+}
+
+func TestWriteReplacingDiskFull(t *testing.T) {
+	disk := useFullDisk(t, 10)
+	dir := t.TempDir()
+	p := filepath.Join(dir, "clientUpdate.json")
+	writeFiles(t, dir, map[string]string{"clientUpdate.json": `{"mcVersion": "1.20.1"}`})
+
+	err := writeReplacing(p, []byte(`{"mcVersion": "1.21.1", "loader": "quilt"}`))
+	var full *noSpaceError
+	if !errors.As(err, &full) || full.path != p || full.needed != 42 {
+		t.Fatalf("failed with %v", err)
+	}
+	// the config stays as it was, no part of the new one is left
+	if got := readFile(t, p); got != `{"mcVersion": "1.20.1"}` {
+		t.Errorf("config %q", got)
+	}
+	if got := dirNames(t, dir); got != "clientUpdate.json" {
+		t.Errorf("left %s", got)
+	}
+
+	disk.Free()
+	if err := writeReplacing(p, []byte(`{"mcVersion": "1.21.1"}`)); err != nil || readFile(t, p) != `{"mcVersion": "1.21.1"}` {
+		t.Errorf("with space: %v", err)
+	}
+}
+
+func TestCopyFileDiskFull(t *testing.T) {
+	useFullDisk(t, 1000)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"sodium.jar": strings.Repeat("sodium ", 1000)})
+	err := copyFile(filepath.Join(dir, "sodium.jar"), filepath.Join(dir, "backup.jar"))
+	var full *noSpaceError
+	if !errors.As(err, &full) || full.needed != 7000 {
+		t.Fatalf("failed with %v", err)
+	}
+	if got := dirNames(t, dir); got != "sodium.jar" {
+		t.Errorf("left %s", got)
+	}
+}
+
+// TestUnzipDiskFull runs out of space halfway through an archive: the file
+// being written is removed, those before it stay for the caller to clean
+// up, and nothing new is written after.
+func TestUnzipDiskFull(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "pack.zip")
+	writeZip(t, archive, map[string]string{
+		"mods/iris.jar":    strings.Repeat("i", 1000),
+		"mods/lithium.jar": strings.Repeat("l", 3000),
+		"mods/sodium.jar":  strings.Repeat("s", 2000),
+	})
+	useFullDisk(t, 2000)
+	dest := t.TempDir()
+	report, err := unzipMatching(archive, dest, func(f *zip.File) bool { return true }, true)
+	var full *noSpaceError
+	if !errors.As(err, &full) || full.path != filepath.Join(dest, "lithium.jar") || full.needed != 5000 {
+		t.Fatalf("failed with %v", err)
+	}
+	if got := dirNames(t, dest); got != "iris.jar" {
+		t.Errorf("extracted %s", got)
+	}
+	if report.Files != 1 || len(report.Paths) != 1 || len(report.Sizes) != 1 {
+		t.Errorf("reported %d files, %d paths", report.Files, len(report.Paths))
+	}
+	if DiskFull() != err {
+		t.Errorf("latched %v", DiskFull())
+	}
+
+	// with the disk full, another extraction and a download don't start
+	dest = t.TempDir()
+	if _, err := unzipMatching(archive, dest, func(f *zip.File) bool { return true }, true); err != full || dirNames(t, dest) != "" {
+		t.Errorf("extracted %s: %v", dirNames(t, dest), err)
+	}
+	requested := false
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { requested = true }))
+	if _, err := downloadFile(filepath.Join(dest, "pack.zip"), "https://example.com/pack.zip", ""); err != full || requested {
+		t.Errorf("downloaded: %v", err)
+	}
+}
+
+// TestDownloadDiskFull removes a download cut off by the disk filling up,
+// there is nothing to resume it from.
+func TestDownloadDiskFull(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 1000))
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write(content) }))
+	useFullDisk(t, 4000)
+	dir := t.TempDir()
+	_, err := downloadFile(filepath.Join(dir, "pack.zip"), "https://example.com/pack.zip", "")
+	var full *noSpaceError
+	if !errors.As(err, &full) || full.path != filepath.Join(dir, "pack.zip") {
+		t.Fatalf("failed with %v", err)
+	}
+	if got := dirNames(t, dir); got != "" {
+		t.Errorf("left %s", got)
+	}
+}
+
+func TestVerifyExtractedSizes(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"iris.jar": "iris", "sodium.jar": "sodi"})
+	report := &ExtractReport{
+		Paths: []string{filepath.Join(dir, "iris.jar"), filepath.Join(dir, "sodium.jar")},
+		Sizes: []int64{4, 6},
+	}
+	err := VerifyExtractedSizes(report)
+	truncated, ok := err.(*truncatedFileError)
+	if !ok || truncated.path != report.Paths[1] || ErrorCategory(err) != categoryLocal {
+		t.Fatalf("failed with %v", err)
+	}
+	if !strings.Contains(err.Error(), "sodium.jar was extracted with 4 bytes, the pack has 6") {
+		t.Errorf("error %q", err)
+	}
+	report.Sizes[1] = 4
+	if err := VerifyExtractedSizes(report); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestUpdateDiskFull runs out of space extracting an update: the update
+// fails telling how much space is missing, the mods stay as they were and
+// no part of the new ones is left anywhere. Once there is space, the next
+// run updates.
+func TestUpdateDiskFull(t *testing.T) {
+	useRunState(t)
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 1", "mods/iris.jar": "iris 1"})
+	u.run(t)
+
+	u.setPack(t, map[string]string{
+		"mods/sodium.jar":  "sodium 2",
+		"mods/iris.jar":    strings.Repeat("iris 2 ", 20000),
+		"mods/lithium.jar": strings.Repeat("lithium 2 ", 20000),
+	})
+	// room for the download, not for the mods
+	disk := useFullDisk(t, 100<<10)
+	var output string
+	if code := exitsWith(func() { output = u.run(t) }); code != exitLocal {
+		t.Fatalf("exited %d", code)
+	}
+	if log := readFile(t, filepath.Join(u.state, "clientUpdate.log")); !strings.Contains(log, "diskspace: the disk ran out of space writing ") || !strings.Contains(log, " needed); free up space") {
+		t.Errorf("output:\n%s\nlog:\n%s", output, log)
+	}
+	if got := walkNames(t, u.mods); got != "iris.jar sodium.jar" {
+		t.Errorf("mods %s", got)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "iris.jar")); got != "iris 1" {
+		t.Errorf("iris.jar is %q", got)
+	}
+	if got := dirNames(t, u.minecraft); strings.Contains(got, "staging") || strings.Contains(got, partialSuffix) {
+		t.Errorf("left %s", got)
+	}
+
+	disk.Free()
+	u.run(t)
+	if got := readFile(t, filepath.Join(u.mods, "lithium.jar")); got != strings.Repeat("lithium 2 ", 20000) {
+		t.Errorf("lithium.jar has %d bytes", len(got))
+	}
+}
+
+// TestSwapDiskFull runs out of space moving the second staged mod in: the
+// mods the swap already replaced are put back from the backup, and the
+// error tells the disk is full.
+func TestSwapDiskFull(t *testing.T) {
+	installed := map[string]string{"a.jar": "old a", "b.jar": "old b", "c.jar": "old c"}
+	archive := packZip(t, map[string]string{
+		"mods/a.jar": "new a",
+		"mods/b.jar": "new b",
+		"mods/c.jar": "new c",
+	})
+	disk := useFullDisk(t, -1)
+	var moved []string
+	useRename(t, func(src string, dst string) error {
+		if strings.Contains(src, stagingSuffix) {
+			moved = append(moved, filepath.Base(dst))
+			if len(moved) == 2 {
+				// the copy moveFile falls back to finds the disk full too
+				disk.mu.Lock()
+				disk.left = 0
+				disk.mu.Unlock()
+				return &os.LinkError{Op: "rename", Old: src, New: dst, Err: noSpaceErrnos[0]}
+			}
+		}
+		return os.Rename(src, dst)
+	})
+	copyFallback = sync.Once{}
+	t.Cleanup(func() { copyFallback = sync.Once{} })
+
+	var mods, output string
+	var err error
+	captureStdout(t, func() { mods, output, err = executeArchive(t, archive, installed, 0) })
+	if !isNoSpace(err) || !strings.Contains(err.Error(), "the disk ran out of space writing ") {
+		t.Fatalf("%v, output:\n%s", err, output)
+	}
+	if len(moved) != 2 {
+		t.Errorf("moved in %q", moved)
+	}
+	if !strings.Contains(output, T("diskfull.rolledback")) {
+		t.Errorf("output:\n%s", output)
+	}
+	// the mod moved in before is replaced by the installed one again
+	for name, content := range installed {
+		if got := readFile(t, filepath.Join(mods, name)); got != content {
+			t.Errorf("%s is %q", name, got)
+		}
+	}
+	if got := walkNames(t, mods); got != "a.jar b.jar c.jar" {
+		t.Errorf("mods %s", got)
+	}
+	if _, err := os.Stat(mods + stagingSuffix); !os.IsNotExist(err) {
+		t.Errorf("the staging directory is left: %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// noSpaceErrnos are the errors of writes to a full disk: ERROR_DISK_FULL,
+// ERROR_HANDLE_DISK_FULL and a full quota, ERROR_DISK_QUOTA_EXCEEDED.
+var noSpaceErrnos = []syscall.Errno{112, 39, 1295}
+
+// freeSpace returns the bytes the disk of dir has left for the user.
+func freeSpace(dir string) (int64, error) {
+	kernel32 := syscall.NewLazyDLL("kernel32.dll")
+	getDiskFreeSpaceEx := kernel32.NewProc("GetDiskFreeSpaceExW")
+	if err := getDiskFreeSpaceEx.Find(); err != nil {
+		return 0, err
+	}
+	name, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var available uint64
+	if ok, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(&available)), 0, 0); ok == 0 {
+		return 0, err
+	}
+	return int64(available), nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// probeTimeout bounds the HEAD request sent to a mirror before downloading.
+const probeTimeout = 5 * time.Second
+
+// Download describes a completed download.
+type Download struct {
+	URL string
+	// Size is the number of bytes received, ContentLength what the server
+	// announced (-1 when unknown).
+	Size          int64
+	ContentLength int64
+	// SHA256 is the hash of what was received. ETag and LastModified are
+	// what the server said about the file, to tell later whether it
+	// changed.
+	SHA256       string
+	ETag         string
+	LastModified string
+}
+
+// DownloadFromSources downloads the first of urls that works into filepath.
+// The first url is the primary source, the rest are mirrors tried in order;
+// the LAN mirror comes before all of them when the content is known.
+// When expectedSHA256 is set, every source must produce exactly that content.
+// On failure the error describes what went wrong with each source.
+func DownloadFromSources(filepath string, urls []string, expectedSHA256 string) (*Download, error) {
+	if download, ok := fromCacheMirror(filepath, expectedSHA256); ok {
+		fmt.Println(T("download.lan", cacheMirror))
+		return download, nil
+	}
+	var outcomes []string
+	for i, url := range urls {
+		name := sourceName(i)
+		download, err := downloadVerified(filepath, url, expectedSHA256, i > 0)
+		if err == nil {
+			Logf("download: %s (%s) succeeded, %d bytes", name, url, download.Size)
+			if i > 0 {
+				Logf("download: %s", strings.Join(append(outcomes, name+" succeeded"), ", "))
+			}
+			return download, nil
+		}
+		Logf("download: %s (%s) failed: %s", name, url, err)
+		// a clock set wrong fails every source the same way
+		var skew *clockSkewError
+		if errors.As(checkClockSkew(err, url), &skew) {
+			os.Remove(filepath)
+			return nil, skew
+		}
+		outcomes = append(outcomes, name+" "+describeDownloadError(err))
+		os.Remove(filepath)
+	}
+	return nil, &sourcesError{outcomes: outcomes}
+}
+
+// sourcesError is a download none of whose sources worked, with what went
+// wrong with each.
+type sourcesError struct {
+	outcomes []string
+}
+
+func (e *sourcesError) Error() string    { return strings.Join(e.outcomes, ", ") }
+func (e *sourcesError) Category() string { return categoryNetwork }
+
+func sourceName(i int) string {
+	if i == 0 {
+		return "primary"
+	}
+	return fmt.Sprintf("mirror %d", i)
+}
+
+func downloadVerified(filepath string, url string, expectedSHA256 string, probe bool) (*Download, error) {
+	if probe {
+		if err := probeURL(url); err != nil {
+			return nil, err
+		}
+	}
+	return downloadFile(filepath, url, expectedSHA256)
+}
+
+// probeURL checks a mirror is alive before committing to a large download
+// from it.
+func probeURL(url string) error {
+	resp, err := withTimeout(probeTimeout).Head(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	// some hosts refuse HEAD but serve GET just fine
+	if resp.StatusCode >= 400 && resp.StatusCode != http.StatusMethodNotAllowed {
+		return &statusError{status: resp.StatusCode}
+	}
+	return nil
+}
+
+func fileSHA256(filepath string) (string, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("returned %d %s", e.status, http.StatusText(e.status))
+}
+
+func (e *statusError) Category() string { return categoryNetwork }
+
+type truncatedError struct {
+	got, want int64
+}
+
+func (e *truncatedError) Error() string {
+	return fmt.Sprintf("download ended early, received %d of %d bytes", e.got, e.want)
+}
+
+func (e *truncatedError) Category() string { return categoryNetwork }
+
+type checksumError struct {
+	got string
+}
+
+func (e *checksumError) Error() string {
+	return "checksum mismatch (got " + e.got + ")"
+}
+
+func (e *checksumError) Category() string { return categoryNetwork }
+
+// describeDownloadError turns a download error into a short phrase such as
+// "timed out" or "returned 404".
+func describeDownloadError(err error) string {
+	var status *statusError
+	var checksum *checksumError
+	var dial *dialError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dial):
+		return "failed (" + dial.Error() + ")"
+	case errors.As(err, &status):
+		return fmt.Sprintf("returned %d", status.status)
+	case errors.As(err, &checksum):
+		return "served a file with the wrong checksum"
+	case errors.As(err, new(*truncatedError)):
+		return "ended the download early"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timed out"
+	default:
+		return "failed (" + err.Error() + ")"
+	}
+}
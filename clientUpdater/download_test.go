@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mirrorsServer serves each host its own status and body, answering HEAD
+// with headStatus when set, and records the requests by method and host.
+type mirrorsServer struct {
+	mu       sync.Mutex
+	requests []string
+	hosts    map[string]mirrorReply
+}
+
+type mirrorReply struct {
+	status     int
+	headStatus int
+	body       string
+}
+
+func (s *mirrorsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.Host)
+	s.mu.Unlock()
+	reply, ok := s.hosts[r.Host]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	status := reply.status
+	if r.Method == http.MethodHead && reply.headStatus != 0 {
+		status = reply.headStatus
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write([]byte(reply.body))
+	}
+}
+
+// received returns the requests received so far and forgets them.
+func (s *mirrorsServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+var testMirrors = []string{"https://primary.example/pack.zip", "https://mirror1.example/pack.zip", "https://mirror2.example/pack.zip"}
+
+func TestProbeURL(t *testing.T) {
+	server := &mirrorsServer{hosts: map[string]mirrorReply{
+		"alive.example":   {},
+		"no-head.example": {headStatus: http.StatusMethodNotAllowed},
+		"down.example":    {status: http.StatusBadGateway},
+	}}
+	useTestServer(t, server)
+	for host, want := range map[string]error{
+		"alive.example":   nil,
+		"no-head.example": nil,
+		"down.example":    &statusError{status: http.StatusBadGateway},
+		"gone.example":    &statusError{status: http.StatusNotFound},
+	} {
+		if err := probeURL("https://" + host + "/pack.zip"); !reflect.DeepEqual(err, want) {
+			t.Errorf("%s: %v, want %v", host, err, want)
+		}
+	}
+	if got := server.received(); len(got) != 4 || strings.Count(strings.Join(got, " "), "HEAD ") != 4 {
+		t.Errorf("requests %q", got)
+	}
+}
+
+// TestDownloadFromSources tries the primary source, then the mirrors in
+// their order: a mirror whose probe fails isn't downloaded from, one
+// serving other content than expected is dropped for the next.
+func TestDownloadFromSources(t *testing.T) {
+	content := "pack"
+	sum := sha256Hex([]byte(content))
+	tests := []struct {
+		name     string
+		hosts    map[string]mirrorReply
+		requests []string
+		log      string
+	}{
+		{
+			name:     "primary",
+			hosts:    map[string]mirrorReply{"primary.example": {body: content}},
+			requests: []string{"GET primary.example"},
+		},
+		{
+			name:     "first mirror",
+			hosts:    map[string]mirrorReply{"primary.example": {status: http.StatusServiceUnavailable}, "mirror1.example": {body: content}, "mirror2.example": {body: content}},
+			requests: []string{"GET primary.example", "HEAD mirror1.example", "GET mirror1.example"},
+			log:      "download: primary returned 503, mirror 1 succeeded",
+		},
+		{
+			name:     "dead mirror",
+			hosts:    map[string]mirrorReply{"primary.example": {status: http.StatusServiceUnavailable}, "mirror1.example": {headStatus: http.StatusNotFound, body: content}, "mirror2.example": {body: content}},
+			requests: []string{"GET primary.example", "HEAD mirror1.example", "HEAD mirror2.example", "GET mirror2.example"},
+			log:      "download: primary returned 503, mirror 1 returned 404, mirror 2 succeeded",
+		},
+		{
+			name:     "mirror refusing HEAD",
+			hosts:    map[string]mirrorReply{"primary.example": {status: http.StatusServiceUnavailable}, "mirror1.example": {headStatus: http.StatusMethodNotAllowed, body: content}},
+			requests: []string{"GET primary.example", "HEAD mirror1.example", "GET mirror1.example"},
+			log:      "download: primary returned 503, mirror 1 succeeded",
+		},
+		{
+			name:     "wrong checksum",
+			hosts:    map[string]mirrorReply{"primary.example": {body: "stale pack"}, "mirror1.example": {body: "tampered pack"}, "mirror2.example": {body: content}},
+			requests: []string{"GET primary.example", "HEAD mirror1.example", "GET mirror1.example", "HEAD mirror2.example", "GET mirror2.example"},
+			log:      "download: primary served a file with the wrong checksum, mirror 1 served a file with the wrong checksum, mirror 2 succeeded",
+		},
+	}
+	for _, test := range tests {
+		server := &mirrorsServer{hosts: test.hosts}
+		useTestServer(t, server)
+		log := captureRunLog(t)
+		p := filepath.Join(t.TempDir(), "pack.zip")
+		var download *Download
+		var err error
+		captureStdout(t, func() { download, err = DownloadFromSources(p, testMirrors, sum) })
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if download.SHA256 != sum || readFile(t, p) != content {
+			t.Errorf("%s: downloaded %+v", test.name, download)
+		}
+		if got := server.received(); !reflect.DeepEqual(got, test.requests) {
+			t.Errorf("%s: requests %q, want %q", test.name, got, test.requests)
+		}
+		if test.log != "" && !strings.Contains(log.String(), test.log) {
+			t.Errorf("%s: log:\n%s", test.name, log)
+		}
+	}
+}
+
+// TestDownloadFromSourcesFails tells what went wrong with every source,
+// leaving nothing of the rejected downloads behind.
+func TestDownloadFromSourcesFails(t *testing.T) {
+	server := &mirrorsServer{hosts: map[string]mirrorReply{
+		"primary.example": {status: http.StatusServiceUnavailable},
+		"mirror1.example": {body: "tampered pack"},
+		"mirror2.example": {headStatus: http.StatusNotFound},
+	}}
+	useTestServer(t, server)
+	dir := t.TempDir()
+	var err error
+	captureStdout(t, func() {
+		_, err = DownloadFromSources(filepath.Join(dir, "pack.zip"), testMirrors, sha256Hex([]byte("pack")))
+	})
+	var sources *sourcesError
+	if !errors.As(err, &sources) || ErrorCategory(err) != categoryNetwork {
+		t.Fatalf("failed with %v", err)
+	}
+	if want := "primary returned 503, mirror 1 served a file with the wrong checksum, mirror 2 returned 404"; err.Error() != want {
+		t.Errorf("error %q", err)
+	}
+	if got := server.received(); !reflect.DeepEqual(got, []string{"GET primary.example", "HEAD mirror1.example", "GET mirror1.example", "HEAD mirror2.example"}) {
+		t.Errorf("requests %q", got)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("left %s", dirNames(t, dir))
+	}
+}
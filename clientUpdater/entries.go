@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// defaultMaxArchiveEntries bounds the entries of the archives the updater
+// opens. The whole central directory is read into memory first, for an
+// archive with far more entries that alone takes minutes and most of the
+// memory, and a mod pack never needs that many.
+const defaultMaxArchiveEntries = 20000
+
+// maxArchiveEntries is the bound in use.
+var maxArchiveEntries = defaultMaxArchiveEntries
+
+// SetMaxArchiveEntries changes the bound, 0 keeps the default.
+func SetMaxArchiveEntries(n int) {
+	if n > 0 {
+		maxArchiveEntries = n
+	}
+}
+
+type tooManyEntriesError struct {
+	entries uint64
+	max     int
+}
+
+func (e *tooManyEntriesError) Error() string {
+	return fmt.Sprintf("the archive has %d entries, more than the %d allowed (raise maxArchiveEntries in the config if that is intended)", e.entries, e.max)
+}
+
+// checkEntryCount refuses archives with more than maxArchiveEntries
+// entries before their central directory is read.
+func checkEntryCount(src string) error {
+	entries, err := archiveEntryCount(src)
+	if err != nil {
+		return err
+	}
+	if entries > uint64(maxArchiveEntries) {
+		return &tooManyEntriesError{entries: entries, max: maxArchiveEntries}
+	}
+	return nil
+}
+
+// Signatures and sizes of the zip records archiveEntryCount reads.
+var (
+	eocdSignature         = []byte("PK\x05\x06")
+	zip64LocatorSignature = []byte("PK\x06\x07")
+	zip64EOCDSignature    = []byte("PK\x06\x06")
+)
+
+const (
+	eocdLen         = 22
+	zip64LocatorLen = 20
+	zip64EOCDLen    = 56
+	maxZipComment   = 0xFFFF
+)
+
+// archiveEntryCount reads the number of entries from the end of central
+// directory record, and its zip64 version when there are more than 65535,
+// without reading the directory itself. It is 0 when the record isn't
+// found; opening the archive reports that properly.
+func archiveEntryCount(src string) (uint64, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	n := int64(zip64LocatorLen + eocdLen + maxZipComment)
+	if n > info.Size() {
+		n = info.Size()
+	}
+	tail := make([]byte, n)
+	if _, err := f.ReadAt(tail, info.Size()-n); err != nil {
+		return 0, err
+	}
+	i := bytes.LastIndex(tail, eocdSignature)
+	if i < 0 || len(tail)-i < eocdLen {
+		return 0, nil
+	}
+	entries := uint64(binary.LittleEndian.Uint16(tail[i+10:]))
+	if entries != 0xFFFF || i < zip64LocatorLen {
+		return entries, nil
+	}
+	locator := tail[i-zip64LocatorLen : i]
+	if !bytes.Equal(locator[:4], zip64LocatorSignature) {
+		return entries, nil
+	}
+	record := make([]byte, zip64EOCDLen)
+	if _, err := f.ReadAt(record, int64(binary.LittleEndian.Uint64(locator[8:]))); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(record[:4], zip64EOCDSignature) {
+		return entries, nil
+	}
+	return binary.LittleEndian.Uint64(record[32:]), nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// manyEntriesZip builds a zip of n entries in memory: a few mods and,
+// like a pack bundling its resource packs file by file, empty files in
+// folders nothing extracts.
+func manyEntriesZip(t testing.TB, n int, comment string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("pack/resourcepacks/textures/block/%d.png", i)
+		if i%1000 == 0 {
+			name = fmt.Sprintf("pack/mods/mod-%d.jar", i)
+		}
+		if _, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.SetComment(comment); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeArchive writes content to a file in a temporary directory.
+func writeArchive(t testing.TB, content []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "pack.zip")
+	if err := os.WriteFile(p, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestArchiveEntryCount(t *testing.T) {
+	small := manyEntriesZip(t, 3, "")
+	// past 65535 entries the count is only in the zip64 record the
+	// locator points to
+	zip64 := manyEntriesZip(t, 70000, "")
+	damagedZip64 := append([]byte{}, zip64...)
+	i := bytes.LastIndex(damagedZip64, zip64EOCDSignature)
+	copy(damagedZip64[i:], "XXXX")
+
+	tests := []struct {
+		name    string
+		content []byte
+		entries uint64
+	}{
+		{"few entries", small, 3},
+		{"no entries", manyEntriesZip(t, 0, ""), 0},
+		{"comment", manyEntriesZip(t, 5, strings.Repeat("c", 1000)), 5},
+		{"longest comment", manyEntriesZip(t, 5, strings.Repeat("c", maxZipComment)), 5},
+		{"most entries without zip64", manyEntriesZip(t, 65534, ""), 65534},
+		{"zip64", zip64, 70000},
+		{"zip64 record missing", damagedZip64, 0xFFFF},
+		// the record isn't found, opening the archive tells why
+		{"not a zip", []byte("not a zip"), 0},
+		{"empty", nil, 0},
+		{"record cut off", small[:len(small)-10], 0},
+		{"record missing", small[:len(small)-eocdLen], 0},
+	}
+	for _, test := range tests {
+		entries, err := archiveEntryCount(writeArchive(t, test.content))
+		if err != nil || entries != test.entries {
+			t.Errorf("%s: %d entries, %v; want %d", test.name, entries, err, test.entries)
+		}
+	}
+
+	if _, err := archiveEntryCount(filepath.Join(t.TempDir(), "missing.zip")); !os.IsNotExist(err) {
+		t.Errorf("missing archive: %v", err)
+	}
+
+	// a locator pointing past the end of the archive
+	broken := append([]byte{}, zip64...)
+	locator := bytes.LastIndex(broken, zip64LocatorSignature)
+	copy(broken[locator+8:], []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0, 0})
+	if _, err := archiveEntryCount(writeArchive(t, broken)); err == nil {
+		t.Error("read a zip64 record past the end")
+	}
+}
+
+// useMaxArchiveEntries bounds the entries of archives to n for the test.
+func useMaxArchiveEntries(t testing.TB, n int) {
+	saved := maxArchiveEntries
+	SetMaxArchiveEntries(n)
+	t.Cleanup(func() { maxArchiveEntries = saved })
+}
+
+func TestCheckEntryCount(t *testing.T) {
+	useMaxArchiveEntries(t, 0)
+	if maxArchiveEntries != defaultMaxArchiveEntries {
+		t.Errorf("0 set the bound to %d", maxArchiveEntries)
+	}
+	if err := checkEntryCount(writeArchive(t, manyEntriesZip(t, defaultMaxArchiveEntries, ""))); err != nil {
+		t.Errorf("as many entries as allowed: %v", err)
+	}
+
+	useMaxArchiveEntries(t, 10)
+	archive := writeArchive(t, manyEntriesZip(t, 11, ""))
+	err := checkEntryCount(archive)
+	var tooMany *tooManyEntriesError
+	if !errors.As(err, &tooMany) || ErrorCategory(err) != categoryLocal {
+		t.Fatalf("failed with %v", err)
+	}
+	if want := "the archive has 11 entries, more than the 10 allowed (raise maxArchiveEntries in the config if that is intended)"; err.Error() != want {
+		t.Errorf("error %q", err)
+	}
+	// refused before the central directory is read
+	if _, err := ValidateArchive(archive, "1.20.1"); !errors.As(err, &tooMany) {
+		t.Errorf("validated: %v", err)
+	}
+	if _, err := OpenPackArchive(archive); !errors.As(err, &tooMany) {
+		t.Errorf("opened: %v", err)
+	}
+
+	useMaxArchiveEntries(t, 70000)
+	if err := checkEntryCount(writeArchive(t, manyEntriesZip(t, 70001, ""))); !errors.As(err, &tooMany) || tooMany.entries != 70001 {
+		t.Errorf("zip64: %v", err)
+	}
+}
+
+// BenchmarkManyEntries counts and goes through the entries of a pack with
+// 40000 of them, extracting the 40 mods.
+func BenchmarkManyEntries(b *testing.B) {
+	useMaxArchiveEntries(b, 50000)
+	archive := writeArchive(b, manyEntriesZip(b, 40000, ""))
+	mods := func(f *zip.File) bool { return strings.HasPrefix(f.Name, "pack/mods/") }
+	b.Run("count", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if entries, err := archiveEntryCount(archive); err != nil || entries != 40000 {
+				b.Fatalf("%d entries, %v", entries, err)
+			}
+		}
+	})
+	b.Run("extract", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			dest := b.TempDir()
+			b.StartTimer()
+			report, err := unzipMatching(archive, dest, mods, true)
+			if err != nil || report.Files != 40 {
+				b.Fatalf("extracted %d files: %v", report.Files, err)
+			}
+		}
+	})
+}
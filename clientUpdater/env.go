@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// Clock is the time source of everything waiting or timestamping, so a
+// whole run can be driven without real sleeps.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	// After sends the time on the channel once d passed.
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc calls f in its own goroutine once d passed.
+	AfterFunc(d time.Duration, f func())
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (systemClock) AfterFunc(d time.Duration, f func())    { time.AfterFunc(d, f) }
+
+// clock is the Clock in use, replaced when the updater is driven by a
+// test.
+var clock Clock = systemClock{}
+
+// httpClient makes every request of the updater. Requests needing a
+// shorter timeout use withTimeout instead of building their own client,
+// so they go through the same transport and share its connections.
+var httpClient = &http.Client{Transport: newTransport()}
+
+// withTimeout returns httpClient with timeout set.
+func withTimeout(timeout time.Duration) *http.Client {
+	client := *httpClient
+	client.Timeout = timeout
+	return &client
+}
+
+// javaCommand is the java the updater runs, the one on the PATH unless a
+// launcher told which java its instance uses.
+var javaCommand = "java"
+
+// BaseDirs are the per-user directories everything the updater reads or
+// writes outside the working directory is found below. Empty when the
+// system doesn't know them.
+type BaseDirs struct {
+	Home string
+	// Config is %APPDATA% on Windows, ~/Library/Application Support on
+	// macOS and ~/.config elsewhere.
+	Config string
+	Cache  string
+}
+
+// SystemBaseDirs returns the directories of the current user.
+func SystemBaseDirs() BaseDirs {
+	var dirs BaseDirs
+	dirs.Home, _ = os.UserHomeDir()
+	dirs.Config, _ = os.UserConfigDir()
+	dirs.Cache, _ = os.UserCacheDir()
+	return dirs
+}
+
+// baseDirs are the BaseDirs in use, pointed elsewhere when the updater is
+// driven by a test.
+var baseDirs = SystemBaseDirs()
+
+// DefaultMinecraftDir is where the official launcher keeps the game.
+func DefaultMinecraftDir() string {
+	switch {
+	case isWindows():
+		return filepath.Join(baseDirs.Config, ".minecraft")
+	case runtime.GOOS == "darwin":
+		return filepath.Join(baseDirs.Config, "minecraft")
+	}
+	return filepath.Join(baseDirs.Home, ".minecraft")
+}
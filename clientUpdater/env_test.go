@@ -0,0 +1,311 @@
+package main
+
+import (
+	"archive/zip"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves when advanced or slept on.
+// Functions given to AfterFunc run in the goroutine advancing the clock,
+// so a test sees what they did once Advance returns.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	// changed is signalled whenever a timer is added.
+	changed chan struct{}
+}
+
+type fakeTimer struct {
+	at   time.Time
+	fire func(now time.Time)
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, changed: make(chan struct{}, 1)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.schedule(d, func(now time.Time) { ch <- now })
+	return ch
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) {
+	c.schedule(d, func(time.Time) { f() })
+}
+
+func (c *fakeClock) schedule(d time.Duration, fire func(now time.Time)) {
+	c.mu.Lock()
+	c.timers = append(c.timers, &fakeTimer{at: c.now.Add(d), fire: fire})
+	c.mu.Unlock()
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Advance moves the time on by d, firing the timers due in their order.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	var due, pending []*fakeTimer
+	for _, timer := range c.timers {
+		if timer.at.After(now) {
+			pending = append(pending, timer)
+		} else {
+			due = append(due, timer)
+		}
+	}
+	c.timers = pending
+	c.mu.Unlock()
+	sort.SliceStable(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+	for _, timer := range due {
+		timer.fire(now)
+	}
+}
+
+// WaitForTimers waits until n timers are pending, for a goroutine to get
+// to the point where it waits on the clock.
+func (c *fakeClock) WaitForTimers(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		c.mu.Lock()
+		pending := len(c.timers)
+		c.mu.Unlock()
+		if pending >= n {
+			return
+		}
+		select {
+		case <-c.changed:
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("%d timers pending, waited for %d", pending, n)
+		}
+	}
+}
+
+// useFakeClock has the updater run on a fake clock for the test.
+func useFakeClock(t *testing.T) *fakeClock {
+	t.Helper()
+	fake := newFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	saved := clock
+	clock = fake
+	t.Cleanup(func() { clock = saved })
+	return fake
+}
+
+// useTempBaseDirs points the user's directories at empty temporary ones
+// for the test.
+func useTempBaseDirs(t *testing.T) BaseDirs {
+	t.Helper()
+	root := t.TempDir()
+	dirs := BaseDirs{
+		Home:   filepath.Join(root, "home"),
+		Config: filepath.Join(root, "config"),
+		Cache:  filepath.Join(root, "cache"),
+	}
+	for _, dir := range []string{dirs.Home, dirs.Config, dirs.Cache} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := baseDirs
+	baseDirs = dirs
+	t.Cleanup(func() { baseDirs = saved })
+	return dirs
+}
+
+// redirectTransport sends every request to target, whatever its host. The
+// server still sees the original host in r.Host.
+type redirectTransport struct {
+	target *url.URL
+	next   http.RoundTripper
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.target.Scheme, t.target.Host
+	return t.next.RoundTrip(req)
+}
+
+// useTestServer serves every request of the updater, to any host, with
+// handler for the test.
+func useTestServer(t *testing.T, handler http.Handler) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	target, _ := url.Parse(server.URL)
+	saved := httpClient
+	httpClient = &http.Client{Transport: redirectTransport{target: target, next: server.Client().Transport}}
+	t.Cleanup(func() { httpClient = saved })
+	return server
+}
+
+// writeZip writes an archive holding files, by entry name, to p. Names
+// ending in / are directories.
+func writeZip(t testing.TB, p string, files map[string]string) {
+	t.Helper()
+	out, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := zip.NewWriter(out)
+	for _, name := range names {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeFiles creates files, by path below dir, with their content.
+func writeFiles(t testing.TB, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// captureStdout returns what f prints to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	saved := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = saved }()
+	f()
+	return readFile(t, out.Name())
+}
+
+// useStdin has the updater read input, the player's answers, from stdin
+// for the test.
+func useStdin(t *testing.T, input string) {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(p, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	in, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdin
+	os.Stdin = in
+	t.Cleanup(func() {
+		os.Stdin = saved
+		in.Close()
+	})
+}
+
+// readFile returns the content of p, failing the test when it can't be
+// read.
+func readFile(t testing.TB, p string) string {
+	t.Helper()
+	content, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// dirNames lists the names in dir, sorted and separated by spaces.
+func dirNames(t testing.TB, dir string) string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return strings.Join(names, " ")
+}
+
+func TestFakeClockFiresTimersInOrder(t *testing.T) {
+	fake := newFakeClock(time.Unix(0, 0))
+	var fired []string
+	fake.AfterFunc(2*time.Second, func() { fired = append(fired, "second") })
+	fake.AfterFunc(time.Second, func() { fired = append(fired, "first") })
+	after := fake.After(3 * time.Second)
+
+	fake.Advance(1500 * time.Millisecond)
+	if len(fired) != 1 || fired[0] != "first" {
+		t.Fatalf("after 1.5s fired %v", fired)
+	}
+	fake.Sleep(time.Second)
+	if len(fired) != 2 || fired[1] != "second" {
+		t.Fatalf("after 2.5s fired %v", fired)
+	}
+	select {
+	case <-after:
+		t.Fatal("After fired early")
+	default:
+	}
+	fake.Advance(time.Second)
+	if got := <-after; !got.Equal(time.Unix(3, 500e6)) {
+		t.Fatalf("After sent %s", got)
+	}
+}
+
+func TestDefaultDirsFollowBaseDirs(t *testing.T) {
+	dirs := useTempBaseDirs(t)
+	want := filepath.Join(dirs.Home, ".minecraft")
+	switch {
+	case isWindows():
+		want = filepath.Join(dirs.Config, ".minecraft")
+	case runtime.GOOS == "darwin":
+		want = filepath.Join(dirs.Config, "minecraft")
+	}
+	if got := DefaultMinecraftDir(); got != want {
+		t.Errorf("DefaultMinecraftDir() = %s, want %s", got, want)
+	}
+	if got, want := DefaultCacheDir(), filepath.Join(dirs.Cache, cacheDirName); got != want {
+		t.Errorf("DefaultCacheDir() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// exportFormat is bumped whenever the bundle layout changes incompatibly.
+const exportFormat = 1
+
+// packCommitURL is where the archive of a pack commit, tag or branch is
+// fetched.
+const packCommitURL = "https://github.com/rx13/rxmc-Mods/archive/%s.zip"
+
+// ExportBundle is a player's working setup, written by "export" and applied
+// elsewhere by "import".
+type ExportBundle struct {
+	Format int `json:"format"`
+	// Config is the configuration without anything private or specific
+	// to the exporting machine (template values, the mods directory).
+	Config     ConfFile       `json:"config"`
+	Installed  InstalledState `json:"installed"`
+	ExportedAt time.Time      `json:"exportedAt"`
+}
+
+// ExportInstance writes the bundle describing the instance at modPath to
+// dest. The files are hashed as they are now rather than as the last
+// update left them, so manual changes are part of the export.
+func ExportInstance(dest string, config ConfFile, installedPath string, loader Loader, modPath string) (*ExportBundle, error) {
+	state, err := ReadInstalledState(installedPath)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		state = &InstalledState{}
+	}
+	state.MCVersion = config.MCVersion
+	state.Loader = loader.Name()
+	state.LoaderVersion = InstalledLoaderVersion(loader, filepath.Join(ResolveMinecraftRoot(modPath, state), "versions"), config.MCVersion)
+	if state.Files, err = ScanInstalledFiles(modPath); err != nil {
+		return nil, err
+	}
+
+	config.MCDirectory = ""
+	config.TemplateValues = nil
+	bundle := &ExportBundle{Format: exportFormat, Config: config, Installed: *state, ExportedAt: clock.Now()}
+	content, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return bundle, writeAtomic(dest, append(content, '\n'))
+}
+
+// runExport writes the bundle of the instance config points at to
+// exportPath, see ExportInstance.
+func runExport(config ConfFile, cache *Cache, installedPath string, exportPath string) error {
+	loader, err := LoaderByName(config.Loader, cache)
+	if err != nil {
+		return err
+	}
+	if _, err := ExportInstance(exportPath, config, installedPath, loader, config.MCDirectory); err != nil {
+		return err
+	}
+	fmt.Println(T("export.done", exportPath))
+	Logf("exported %s to %s", config.MCDirectory, exportPath)
+	return nil
+}
+
+// runImport makes the instance config points at the one of the bundle at
+// bundlePath, after confirming the plan. savedConfig, unless nil, is the
+// config saved at jsonConfPath and takes the bundle's settings.
+func runImport(bundlePath string, config ConfFile, savedConfig *ConfFile, prompter *Prompter, assumeYes bool, cache *Cache, fileURL string, fileOut string, installedPath string, journalPath string, backupsRoot string, runID string, jsonConfPath string) error {
+	bundle, err := ReadExportBundle(bundlePath)
+	if err != nil {
+		return err
+	}
+	loader, err := LoaderByName(bundle.Installed.Loader, cache)
+	if err != nil {
+		return err
+	}
+	fmt.Println(T("download.start"))
+	archive, err := FetchPack(fileOut, bundle.PackURLs(fileURL), "", bundle.Installed.MCVersion)
+	if err != nil {
+		return fetchFailed(err)
+	}
+	defer RemoveTemporary(archive.Path)
+	plan, err := PlanImport(bundle, archive, config.MCDirectory, loader)
+	if err != nil {
+		return err
+	}
+	plan.Journal = &Journal{Path: journalPath, Run: runID, Pack: archive.Label()}
+	plan.BackupDir = filepath.Join(backupsRoot, runID)
+	plan.Print()
+
+	confirmed := false
+	if assumeYes {
+		confirmed = prompter.Assume(T("import.confirm"), false, true)
+	} else {
+		confirmed = prompter.Confirm(T("import.confirm"), false)
+	}
+	if !confirmed {
+		fmt.Println(T("import.cancelled"))
+		return nil
+	}
+	if err := unlockForUpdate(config.MCDirectory); err != nil {
+		return err
+	}
+	if err := plan.Execute(); err != nil {
+		Logf("import failed: %s", err)
+		return err
+	}
+	if err := RecordInstalledState(installedPath, archive, loader, config.MCDirectory, plan.MinecraftPath, bundle.Installed.MCVersion, nil); err != nil {
+		Logf("recording installed state: %s", err)
+	}
+	relockAfterUpdate(config.MCDirectory, config.LockModsDir)
+	if savedConfig != nil {
+		savedConfig.MCDirectory = config.MCDirectory
+		savedConfig.MCVersion = bundle.Installed.MCVersion
+		savedConfig.Loader = bundle.Config.Loader
+		savedConfig.Mirrors = bundle.Config.Mirrors
+		savedConfig.ArchiveSHA256 = bundle.Config.ArchiveSHA256
+		SaveConfig(*savedConfig, jsonConfPath)
+	}
+	fmt.Println(T("import.done", bundlePath))
+	return nil
+}
+
+// ReadExportBundle reads and checks a bundle written by ExportInstance.
+func ReadExportBundle(p string) (*ExportBundle, error) {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var bundle ExportBundle
+	if err := json.Unmarshal(content, &bundle); err != nil {
+		return nil, fmt.Errorf("%s: %s", p, err)
+	}
+	if bundle.Format != exportFormat {
+		return nil, fmt.Errorf("%s: unsupported export format %d", p, bundle.Format)
+	}
+	if bundle.Installed.MCVersion == "" {
+		return nil, fmt.Errorf("%s: no Minecraft version recorded", p)
+	}
+	if commit := bundle.Installed.PackCommit; commit != "" && !isPackRef(commit) {
+		return nil, fmt.Errorf("%s: %q is neither a commit nor a tag or branch of the pack", p, commit)
+	}
+	return &bundle, nil
+}
+
+// PackURLs returns where the pack the bundle was exported from can be
+// downloaded: its exact commit when known, the usual sources otherwise.
+func (b *ExportBundle) PackURLs(defaultURL string) []string {
+	return packURLs(b.Installed.PackCommit, defaultURL, b.Config.Mirrors)
+}
+
+// packURLs returns the archive of the pack commit when it is known, the
+// default sources otherwise. Mirrors only serve the latest pack. A commit
+// that isn't one, nor a ref name, never makes it into the URL.
+func packURLs(commit string, defaultURL string, mirrors []string) []string {
+	if commit != "" && isPackRef(commit) {
+		return []string{fmt.Sprintf(packCommitURL, commit)}
+	}
+	if commit != "" {
+		Logf("pack commit %q is invalid, using the latest pack", commit)
+	}
+	return append([]string{defaultURL}, mirrors...)
+}
+
+// isPackRef reports whether ref can name a commit of the pack: a hex commit
+// SHA, or a tag or branch name as git allows them, made of the characters
+// that stand for themselves in the archive URL.
+func isPackRef(ref string) bool {
+	if ref == "" || strings.HasPrefix(ref, "-") || strings.HasSuffix(ref, ".") || strings.Contains(ref, "..") {
+		return false
+	}
+	for _, r := range ref {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("._-/", r)) {
+			return false
+		}
+	}
+	for _, component := range strings.Split(ref, "/") {
+		if component == "" || strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+	return true
+}
+
+// ImportPlan is everything an import is going to change to make the local
+// mods directory match a bundle.
+type ImportPlan struct {
+	Bundle        *ExportBundle
+	Archive       *PackArchive
+	ModPath       string
+	MinecraftPath string
+	Loader        Loader
+	// Keep are files already matching the bundle, Remove are local files
+	// the bundle doesn't have, Add are bundle files extracted from the
+	// pack. Unavailable files are in the bundle but not in the pack, e.g.
+	// mods the exporting player added by hand.
+	Keep        []InstalledFile
+	Remove      []InstalledFile
+	Add         []InstalledFile
+	Unavailable []InstalledFile
+	// InstallLoader is set when the bundle's loader version is missing.
+	InstallLoader bool
+
+	Journal   *Journal
+	BackupDir string
+
+	// entries maps the hash of every usable archive entry to its name.
+	entries map[string]string
+}
+
+// PlanImport compares the bundle with the mods directory and the pack
+// archive. Nothing is changed on disk.
+func PlanImport(bundle *ExportBundle, archive *PackArchive, modPath string, loader Loader) (*ImportPlan, error) {
+	p := &ImportPlan{
+		Bundle:        bundle,
+		Archive:       archive,
+		ModPath:       modPath,
+		MinecraftPath: ResolveMinecraftRoot(modPath, nil),
+		Loader:        loader,
+	}
+
+	local, err := ScanInstalledFiles(modPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	wanted := map[string]InstalledFile{}
+	sizes := map[uint64]bool{}
+	for _, file := range bundle.Installed.Files {
+		wanted[file.Name] = file
+		sizes[uint64(file.Size)] = true
+	}
+	protected, err := ScanProtected(modPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	paths := protectedPaths(modPath, protected)
+	// protected files are neither removed nor replaced by the bundle's
+	have := map[string]bool{}
+	for _, file := range protected {
+		have[file.Name] = true
+	}
+	for _, file := range local {
+		if paths.Has(filepath.Join(modPath, file.Name)) {
+			continue
+		}
+		if w, ok := wanted[file.Name]; ok && w.SHA256 == file.SHA256 {
+			p.Keep = append(p.Keep, file)
+			have[file.Name] = true
+		} else {
+			p.Remove = append(p.Remove, file)
+		}
+	}
+
+	if p.entries, err = hashArchiveEntries(archive.Path, sizes); err != nil {
+		return nil, err
+	}
+	for _, file := range bundle.Installed.Files {
+		if have[file.Name] {
+			continue
+		}
+		if _, ok := p.entries[file.SHA256]; ok {
+			p.Add = append(p.Add, file)
+		} else {
+			p.Unavailable = append(p.Unavailable, file)
+		}
+	}
+
+	if v := bundle.Installed.LoaderVersion; v != "" {
+		p.InstallLoader = InstalledLoaderVersion(loader, filepath.Join(p.MinecraftPath, "versions"), bundle.Installed.MCVersion) != v
+	}
+	return p, nil
+}
+
+// hashArchiveEntries hashes the archive entries whose size is in sizes, the
+// others can't match any file of the bundle.
+func hashArchiveEntries(src string, sizes map[uint64]bool) (map[string]string, error) {
+	r, err := OpenPackArchive(src)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	entries := map[string]string{}
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || !sizes[f.UncompressedSize64] {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.New()
+		_, err = io.Copy(hash, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		entries[hex.EncodeToString(hash.Sum(nil))] = f.Name
+	}
+	return entries, nil
+}
+
+// Print shows the plan.
+func (p *ImportPlan) Print() {
+	installed := p.Bundle.Installed
+	pack := installed.PackCommit
+	if pack == "" {
+		pack = T("import.pack.latest")
+	}
+	if installed.PackVersion != "" {
+		pack = installed.PackVersion + " (" + pack + ")"
+	}
+	fmt.Println(T("import.plan", p.ModPath))
+	fmt.Println(T("import.plan.pack", pack))
+	fmt.Println(T("import.plan.minecraft", installed.MCVersion, installed.Loader, installed.LoaderVersion))
+	for _, file := range p.Remove {
+		fmt.Println("  - " + file.Name)
+	}
+	for _, file := range p.Add {
+		fmt.Println("  + " + file.Name)
+	}
+	for _, file := range p.Unavailable {
+		fmt.Println("  ! " + file.Name)
+	}
+	fmt.Println(T("import.plan.summary", len(p.Keep), len(p.Remove), len(p.Add)))
+	if len(p.Unavailable) > 0 {
+		fmt.Println(T("import.plan.unavailable", len(p.Unavailable)))
+	}
+	if p.InstallLoader {
+		fmt.Println(T("import.plan.loader", installed.Loader, installed.LoaderVersion))
+	}
+}
+
+// Execute carries out the plan. Removed files are kept in BackupDir and
+// every change is journaled, like during an update.
+func (p *ImportPlan) Execute() error {
+	if p.InstallLoader {
+		InstallLoader(os.Stdout, p.Loader, p.MinecraftPath, p.Bundle.Installed.MCVersion, p.Bundle.Installed.LoaderVersion)
+	}
+
+	if err := os.MkdirAll(p.ModPath, dirPerm); err != nil {
+		return err
+	}
+	for _, file := range p.Remove {
+		path := filepath.Join(p.ModPath, file.Name)
+		backup := filepath.Join(p.BackupDir, file.Name)
+		if err := moveFile(path, backup); err != nil {
+			return err
+		}
+		if err := p.Journal.Record(JournalEntry{Action: journalDelete, Path: path, SHA256: file.SHA256, Backup: backup}); err != nil {
+			return err
+		}
+	}
+	r, err := OpenPackArchive(p.Archive.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	files := map[string]*zip.File{}
+	for _, f := range r.File {
+		files[f.Name] = f
+	}
+	for _, file := range p.Add {
+		name, err := sanitizeEntryPath(file.Name)
+		if err != nil {
+			return err
+		}
+		// exports only list the files of the mods directory itself
+		if name != filepath.Base(name) {
+			return fmt.Errorf("%s: the export names a file in a subfolder", file.Name)
+		}
+		path := filepath.Join(p.ModPath, name)
+		if err := extractEntry(files[p.entries[file.SHA256]], path); err != nil {
+			return err
+		}
+		if sum, err := fileSHA256(path); err != nil {
+			return err
+		} else if sum != file.SHA256 {
+			return fmt.Errorf("%s: extracted file does not match the export", file.Name)
+		}
+		if err := p.Journal.Record(JournalEntry{Action: journalAdd, Path: path, SHA256: file.SHA256}); err != nil {
+			return err
+		}
+	}
+	Logf("import: kept %d, removed %d, added %d, unavailable %d", len(p.Keep), len(p.Remove), len(p.Add), len(p.Unavailable))
+	if len(p.Unavailable) > 0 {
+		var names []string
+		for _, file := range p.Unavailable {
+			names = append(names, file.Name)
+		}
+		Logf("import: not in the pack: %s", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// extractEntry writes a single archive entry to dest.
+func extractEntry(f *zip.File, dest string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, entryPerm(f))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(countingWriter{out}, rc)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return spaceError(dest, int64(f.UncompressedSize64), err)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestExportImportRoundTrip exports an instance and imports it into
+// another mods directory, which then holds the same files.
+func TestExportImportRoundTrip(t *testing.T) {
+	useFakeClock(t)
+	root := t.TempDir()
+	exported := filepath.Join(root, "a", ".minecraft", "mods")
+	writeFiles(t, exported, map[string]string{
+		"fabric-api.jar": "fabric api",
+		"sodium.jar":     "sodium 2",
+	})
+	installedPath := filepath.Join(root, "installed.json")
+	if err := WriteInstalledState(installedPath, &InstalledState{PackCommit: "0123456789abcdef0123456789abcdef01234567", PackVersion: "1.4.0"}); err != nil {
+		t.Fatal(err)
+	}
+	bundlePath := filepath.Join(root, "export.json")
+	config := ConfFile{MCVersion: "1.20.1", MCDirectory: exported, TemplateValues: map[string]string{"name": "private"}}
+	if _, err := ExportInstance(bundlePath, config, installedPath, fabricLoader{}, exported); err != nil {
+		t.Fatal(err)
+	}
+	if content := readFile(t, bundlePath); strings.Contains(content, "private") || strings.Contains(content, exported) {
+		t.Errorf("the export has what is private to the machine:\n%s", content)
+	}
+
+	bundle, err := ReadExportBundle(bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if urls := bundle.PackURLs("https://example.com/latest.zip"); len(urls) != 1 || !strings.HasSuffix(urls[0], "/0123456789abcdef0123456789abcdef01234567.zip") {
+		t.Errorf("the pack is downloaded from %q", urls)
+	}
+
+	imported := filepath.Join(root, "b", ".minecraft", "mods")
+	writeFiles(t, imported, map[string]string{
+		"sodium.jar": "sodium 1",
+		"stale.jar":  "removed",
+	})
+	archivePath := filepath.Join(root, "pack.zip")
+	writeZip(t, archivePath, map[string]string{
+		"rxmc-Mods-0123456/mods/fabric-api.jar": "fabric api",
+		"rxmc-Mods-0123456/mods/sodium.jar":     "sodium 2",
+	})
+	plan, err := PlanImport(bundle, &PackArchive{Path: archivePath}, imported, fabricLoader{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := fileNames(plan.Add); names != "fabric-api.jar sodium.jar" {
+		t.Errorf("adds %s", names)
+	}
+	if names := fileNames(plan.Remove); names != "sodium.jar stale.jar" {
+		t.Errorf("removes %s", names)
+	}
+	plan.Journal = &Journal{Path: filepath.Join(root, "journal.jsonl"), Run: "import"}
+	plan.BackupDir = filepath.Join(root, "backup")
+	if err := plan.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := ScanInstalledFiles(exported)
+	got, err := ScanInstalledFiles(imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wantJSON, gotJSON := mustJSON(t, want), mustJSON(t, got); wantJSON != gotJSON {
+		t.Errorf("imported %s, want %s", gotJSON, wantJSON)
+	}
+	if got := readFile(t, filepath.Join(plan.BackupDir, "stale.jar")); got != "removed" {
+		t.Errorf("stale.jar backed up as %q", got)
+	}
+}
+
+// TestExportImportModes exports an instance and imports it into another
+// without going through main: declined, then confirmed with and without
+// the saved config taking the bundle's settings.
+func TestExportImportModes(t *testing.T) {
+	useRunState(t)
+	commit := strings.Repeat("e", 40)
+	archivePath := filepath.Join(t.TempDir(), "pack.zip")
+	writeCommitZip(t, archivePath, commit, map[string]string{"mods/sodium.jar": "sodium 2", "mods/iris.jar": "iris"})
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rx13/rxmc-Mods/archive/"+commit+".zip" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, archivePath)
+	}))
+	root := t.TempDir()
+	exported := filepath.Join(root, "a", "mods")
+	writeFiles(t, exported, map[string]string{"sodium.jar": "sodium 2", "iris.jar": "iris"})
+	installedPath := filepath.Join(root, "installed.json")
+	if err := WriteInstalledState(installedPath, &InstalledState{PackCommit: commit}); err != nil {
+		t.Fatal(err)
+	}
+	bundlePath := filepath.Join(root, "export.json")
+	cache := &Cache{Dir: filepath.Join(root, "cache")}
+	output := captureStdout(t, func() {
+		if err := runExport(ConfFile{MCDirectory: exported, MCVersion: "1.20.1", Mirrors: []string{"https://mirror.example/pack.zip"}}, cache, installedPath, bundlePath); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.HasSuffix(output, T("export.done", bundlePath)+"\n") {
+		t.Errorf("export printed %q", output)
+	}
+
+	for _, test := range []struct {
+		name    string
+		input   string
+		yes     bool
+		saved   bool
+		summary string
+	}{
+		{name: "declined", input: "n\n", summary: T("import.cancelled")},
+		{name: "--dir", yes: true, summary: T("import.done", bundlePath)},
+		{name: "saved", input: "y\n", saved: true, summary: T("import.done", bundlePath)},
+	} {
+		dir := filepath.Join(t.TempDir(), "mods")
+		writeFiles(t, dir, map[string]string{"sodium.jar": "sodium 1"})
+		jsonConfPath := filepath.Join(t.TempDir(), "clientUpdate.json")
+		var saved *ConfFile
+		if test.saved {
+			saved = &ConfFile{Language: "de"}
+		}
+		var err error
+		output := captureStdout(t, func() {
+			err = runImport(bundlePath, ConfFile{MCDirectory: dir}, saved, NewPrompter(strings.NewReader(test.input), false), test.yes, cache, "https://github.com/rx13/rxmc-Mods/archive/master.zip", filepath.Join(t.TempDir(), "pack.zip"), filepath.Join(t.TempDir(), "installed.json"), filepath.Join(t.TempDir(), "journal.jsonl"), t.TempDir(), "run", jsonConfPath)
+		})
+		if err != nil || !strings.HasSuffix(output, test.summary+"\n") {
+			t.Fatalf("%s: %v, output:\n%s", test.name, err, output)
+		}
+		want := "iris.jar sodium.jar"
+		if test.summary == T("import.cancelled") {
+			want = "sodium.jar"
+		}
+		if got := dirNames(t, dir); got != want || test.summary != T("import.cancelled") && readFile(t, filepath.Join(dir, "sodium.jar")) != "sodium 2" {
+			t.Errorf("%s: imported %s", test.name, got)
+		}
+		config, _, err := ReadConfig(jsonConfPath)
+		if !test.saved {
+			if _, statErr := os.Stat(jsonConfPath); !os.IsNotExist(statErr) {
+				t.Errorf("%s: saved the config", test.name)
+			}
+			continue
+		}
+		if err != nil || config.MCDirectory != dir || config.MCVersion != "1.20.1" || config.Language != "de" || len(config.Mirrors) != 1 {
+			t.Errorf("%s: saved %+v, %v", test.name, config, err)
+		}
+	}
+
+	// the bundle's pack can't be downloaded
+	bundle, _ := ReadExportBundle(bundlePath)
+	bundle.Installed.PackCommit = strings.Repeat("f", 40)
+	content, _ := json.Marshal(bundle)
+	writeFiles(t, root, map[string]string{"missing.json": string(content)})
+	var err error
+	captureStdout(t, func() {
+		err = runImport(filepath.Join(root, "missing.json"), ConfFile{MCDirectory: exported}, nil, NewPrompter(strings.NewReader(""), true), true, cache, "", filepath.Join(root, "pack.zip"), installedPath, filepath.Join(root, "journal.jsonl"), root, "run", "")
+	})
+	if !errors.As(err, new(*toldError)) {
+		t.Errorf("importing a pack that can't be downloaded: %v", err)
+	}
+}
+
+func TestImportRejectsFilesInSubfolders(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "pack.zip")
+	writeZip(t, archivePath, map[string]string{"pack/mods/evil.jar": "payload"})
+	sum, _ := fileSHA256(writeTemp(t, "payload"))
+	for i, name := range []string{"../evil.jar", "config/evil.jar", `config\evil.jar`} {
+		bundle := &ExportBundle{Format: exportFormat, Installed: InstalledState{
+			MCVersion: "1.20.1",
+			Files:     []InstalledFile{{Name: name, SHA256: sum, Size: int64(len("payload"))}},
+		}}
+		mods := filepath.Join(root, fmt.Sprint(i), "mods")
+		plan, err := PlanImport(bundle, &PackArchive{Path: archivePath}, mods, fabricLoader{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan.Journal = &Journal{Path: filepath.Join(root, "journal.jsonl")}
+		if err := plan.Execute(); err == nil {
+			t.Errorf("%s was imported", name)
+		}
+		for _, pattern := range []string{"*/evil.jar", "*/*/*/evil.jar"} {
+			if matches, _ := filepath.Glob(filepath.Join(root, pattern)); len(matches) > 0 {
+				t.Errorf("%s was written to %v", name, matches)
+			}
+		}
+	}
+}
+
+func TestIsPackRef(t *testing.T) {
+	for _, ref := range []string{"0123abc", "0123456789abcdef0123456789abcdef01234567", "master", "v1.4.0", "release/1.20", "feature_x-2"} {
+		if !isPackRef(ref) {
+			t.Errorf("%q refused", ref)
+		}
+	}
+	for _, ref := range []string{"", "../../other/repo/archive/x", "a/../b", "a//b", "/master", "master/", ".hidden", "x.lock", "v1.", "-rf", "a b", "a?b", "a#b", "a%2e", "a:b", "a~1", "a^", "@{1}", "a\\b"} {
+		if isPackRef(ref) {
+			t.Errorf("%q accepted", ref)
+		}
+	}
+	if urls := packURLs("../evil", "https://example.com/latest.zip", []string{"https://mirror"}); len(urls) != 2 || urls[0] != "https://example.com/latest.zip" {
+		t.Errorf("an invalid commit downloads from %q", urls)
+	}
+}
+
+func TestReadExportBundleRejectsInvalidCommit(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "export.json")
+	content, _ := json.Marshal(ExportBundle{Format: exportFormat, Installed: InstalledState{MCVersion: "1.20.1", PackCommit: "master/../../../x"}})
+	if err := os.WriteFile(p, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadExportBundle(p); err == nil {
+		t.Error("a bundle with an invalid commit was read")
+	}
+}
+
+// fileNames lists the names of files, sorted.
+func fileNames(files []InstalledFile) string {
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	content, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// writeTemp writes content to a temporary file, returning its path.
+func writeTemp(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ExternalMod is a mod the pack needs but can't ship, its license
+// forbidding anyone else to host it. With a URL the updater downloads it
+// from its author; without one the player downloads it from Page and puts
+// it into the mods directory.
+type ExternalMod struct {
+	// File is where the mod is installed, relative to the mods directory.
+	File string `json:"file"`
+	// SHA256 is the release of the mod the pack needs.
+	SHA256 string `json:"sha256"`
+	// URL is the author's download of exactly that release.
+	URL string `json:"url,omitempty"`
+	// Page is where the player finds the download, for mods without URL.
+	Page string `json:"page,omitempty"`
+	// Name is the mod's name shown to players, File when empty.
+	Name string `json:"name,omitempty"`
+}
+
+// name returns what players know the mod as.
+func (m ExternalMod) name() string {
+	if m.Name != "" {
+		return m.Name
+	}
+	return m.File
+}
+
+// UserProvided reports whether the player has to provide the mod.
+func (m ExternalMod) UserProvided() bool {
+	return m.URL == ""
+}
+
+// Kinds of external mods recorded in InstalledFile.External.
+const (
+	externalDownload = "download"
+	externalUser     = "user"
+)
+
+// kind returns the kind of the mod for the installed state.
+func (m ExternalMod) kind() string {
+	if m.UserProvided() {
+		return externalUser
+	}
+	return externalDownload
+}
+
+// sha256Pattern matches a hex encoded SHA-256.
+var sha256Pattern = regexp.MustCompile("^[0-9a-fA-F]{64}$")
+
+// validateExternalMods reports external mods the updater couldn't install
+// or check.
+func validateExternalMods(mods []ExternalMod, problems *manifestProblems) {
+	seen := map[string]bool{}
+	for i, mod := range mods {
+		at := indexPath("external", i)
+		if _, err := sanitizeEntryPath(mod.File); err != nil || mod.File == "" {
+			problems.add(fieldPath(at, "file"), "%q is not a path in the mods directory", mod.File)
+		} else if seen[strings.ToLower(mod.File)] {
+			problems.add(fieldPath(at, "file"), "%q is listed twice", mod.File)
+		}
+		seen[strings.ToLower(mod.File)] = true
+		if !sha256Pattern.MatchString(mod.SHA256) {
+			problems.add(fieldPath(at, "sha256"), "%q is not a SHA-256", mod.SHA256)
+		}
+		switch {
+		case mod.URL != "" && !strings.HasPrefix(mod.URL, "https://") && !strings.HasPrefix(mod.URL, "http://"):
+			problems.add(fieldPath(at, "url"), "%q is not a web address", mod.URL)
+		case mod.URL == "" && mod.Page == "":
+			problems.add(at, "needs a url to download it from, or a page players download it from")
+		}
+	}
+}
+
+// What was found for an external mod.
+const (
+	// externalPresent is a file with the mod's hash.
+	externalPresent = "present"
+	// externalMissing is no file of the mod's name or hash.
+	externalMissing = "missing"
+	// externalMismatch is a file of the mod's name with another hash.
+	externalMismatch = "mismatch"
+)
+
+// ExternalPlan is what was found in the mods directory for an external
+// mod.
+type ExternalPlan struct {
+	Mod    ExternalMod
+	Status string
+	// Path is the file found, unless the mod is missing.
+	Path string
+}
+
+// PlanExternalMods looks for each external mod among the files of modPath,
+// hashes holding their SHA-256 by path. A file of the mod's name is only
+// the mod with its hash; players saving the download under another name
+// are fine too, any file with the hash is the mod.
+func PlanExternalMods(mods []ExternalMod, modPath string, hashes map[string]string) []ExternalPlan {
+	paths := make([]string, 0, len(hashes))
+	for p := range hashes {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	// the first file of each hash, looked up once per mod instead of going
+	// through every file again
+	byHash := map[string]string{}
+	for _, p := range paths {
+		sum := strings.ToLower(hashes[p])
+		if _, ok := byHash[sum]; !ok {
+			byHash[sum] = p
+		}
+	}
+	var plans []ExternalPlan
+	for _, mod := range mods {
+		plan := ExternalPlan{Mod: mod, Status: externalMissing}
+		want := filepath.Join(modPath, filepath.FromSlash(mod.File))
+		if sum, ok := hashes[want]; ok {
+			plan.Path = want
+			plan.Status = externalMismatch
+			if strings.EqualFold(sum, mod.SHA256) {
+				plan.Status = externalPresent
+			}
+		}
+		if p, ok := byHash[strings.ToLower(mod.SHA256)]; ok && plan.Status != externalPresent {
+			plan.Path, plan.Status = p, externalPresent
+		}
+		plans = append(plans, plan)
+	}
+	return plans
+}
+
+// ExternalMods looks for the pack's external mods in the mods directory as
+// it is now. Usually every mod is where the pack puts it and only those
+// files are hashed; the whole directory only when one is elsewhere or
+// missing.
+func (p *UpdatePlan) ExternalMods() []ExternalPlan {
+	if p.Archive.Manifest == nil || len(p.Archive.Manifest.External) == 0 {
+		return nil
+	}
+	var known map[string]string
+	if p.Prepared != nil {
+		known = p.Prepared.Known()
+	}
+	mods := p.Archive.Manifest.External
+	hashes := map[string]string{}
+	for _, mod := range mods {
+		want := filepath.Join(p.ModPath, filepath.FromSlash(mod.File))
+		sum, ok := known[want]
+		if !ok {
+			var err error
+			if sum, err = fileSHA256(want); err != nil {
+				hashes = nil
+				break
+			}
+		}
+		hashes[want] = sum
+		if !strings.EqualFold(sum, mod.SHA256) {
+			hashes = nil
+			break
+		}
+	}
+	if hashes == nil {
+		hashes = currentModHashes(p.ModPath, known)
+	}
+	return PlanExternalMods(mods, p.ModPath, hashes)
+}
+
+// currentModHashes hashes the files below modPath, reusing the hashes in
+// known for the files hashed before.
+func currentModHashes(modPath string, known map[string]string) map[string]string {
+	hashes := map[string]string{}
+	filepath.WalkDir(modPath, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if sum, ok := known[p]; ok {
+			hashes[p] = sum
+		} else if sum, err := fileSHA256(p); err == nil {
+			hashes[p] = sum
+		}
+		return nil
+	})
+	return hashes
+}
+
+// externalProtected returns the files of external mods the update leaves
+// alone: those with the mod's hash, and the player's own copy of a mod
+// they provide even where it is another release.
+func externalProtected(modPath string, plans []ExternalPlan) []ProtectedFile {
+	var files []ProtectedFile
+	for _, plan := range plans {
+		if plan.Status == externalMissing || plan.Status == externalMismatch && !plan.Mod.UserProvided() {
+			continue
+		}
+		if rel, err := filepath.Rel(modPath, plan.Path); err == nil {
+			files = append(files, ProtectedFile{Name: rel, Reason: protectedExternal})
+		}
+	}
+	return files
+}
+
+// fetchExternal downloads the external mods that are missing or another
+// release into staging and returns the files downloaded. A mod that can't
+// be downloaded with its hash fails on its own: it is warned about and
+// the update goes on without it.
+func (p *UpdatePlan) fetchExternal(staging string, plans []ExternalPlan) []string {
+	var fetched []string
+	for _, plan := range plans {
+		if plan.Status == externalPresent {
+			continue
+		}
+		if plan.Mod.UserProvided() {
+			Warn(warnExternal, T("external.user.absent", plan.Mod.name(), plan.Mod.Page))
+			continue
+		}
+		dest := filepath.Join(staging, filepath.FromSlash(plan.Mod.File))
+		fmt.Fprintln(p.out(), T("external.download", plan.Mod.name(), plan.Mod.URL))
+		err := os.MkdirAll(filepath.Dir(dest), dirPerm)
+		if err == nil {
+			_, err = downloadFile(dest, plan.Mod.URL, plan.Mod.SHA256)
+		}
+		if err != nil {
+			Logf("external: %s from %s: %s", plan.Mod.File, plan.Mod.URL, err)
+			Warn(warnExternal, T("external.failed", plan.Mod.name(), err))
+			p.ExternalFailed = append(p.ExternalFailed, plan.Mod.File)
+			continue
+		}
+		fetched = append(fetched, dest)
+	}
+	return fetched
+}
+
+// AskForUserMods tells the player how to get the mods they provide that
+// are missing or another release, and waits for them to put them in
+// place. It returns once all are there or the player goes on without, as
+// they do by default.
+func (p *UpdatePlan) AskForUserMods(prompter *Prompter) {
+	for {
+		var wanted []ExternalPlan
+		for _, plan := range p.ExternalMods() {
+			if plan.Mod.UserProvided() && plan.Status != externalPresent {
+				wanted = append(wanted, plan)
+			}
+		}
+		if len(wanted) == 0 {
+			return
+		}
+		fmt.Fprintln(p.out(), T("external.user", len(wanted)))
+		for _, plan := range wanted {
+			if plan.Status == externalMismatch {
+				fmt.Fprintln(p.out(), T("external.user.mismatch", plan.Mod.name(), plan.Mod.Page, filepath.Base(plan.Path)))
+			} else {
+				fmt.Fprintln(p.out(), T("external.user.missing", plan.Mod.name(), plan.Mod.Page))
+			}
+		}
+		fmt.Fprintln(p.out(), T("external.user.where", p.ModPath))
+		if !prompter.Confirm(T("external.user.check"), false) {
+			Logf("external: updating without %d mods the player provides", len(wanted))
+			return
+		}
+	}
+}
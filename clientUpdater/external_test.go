@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlanExternalMods(t *testing.T) {
+	mods := t.TempDir()
+	at := func(name string) string { return filepath.Join(mods, name) }
+	voicechat := sha256Hex([]byte("voicechat"))
+	optifine := sha256Hex([]byte("optifine"))
+	tests := []struct {
+		name   string
+		hashes map[string]string
+		status string
+		path   string
+	}{
+		{name: "present", hashes: map[string]string{at("optifine.jar"): optifine, at("sodium.jar"): "other"}, status: externalPresent, path: at("optifine.jar")},
+		{name: "hash in capitals", hashes: map[string]string{at("optifine.jar"): strings.ToUpper(optifine)}, status: externalPresent, path: at("optifine.jar")},
+		{name: "renamed", hashes: map[string]string{at("OptiFine_1.20.1_HD_U_I6.jar"): optifine}, status: externalPresent, path: at("OptiFine_1.20.1_HD_U_I6.jar")},
+		{name: "missing", hashes: map[string]string{at("sodium.jar"): "other"}, status: externalMissing},
+		{name: "wrong hash", hashes: map[string]string{at("optifine.jar"): voicechat}, status: externalMismatch, path: at("optifine.jar")},
+		{name: "wrong hash, right one renamed", hashes: map[string]string{at("optifine.jar"): voicechat, at("optifine-new.jar"): optifine}, status: externalPresent, path: at("optifine-new.jar")},
+	}
+	mod := ExternalMod{File: "optifine.jar", SHA256: optifine, Page: "https://optifine.net/downloads"}
+	for _, test := range tests {
+		plans := PlanExternalMods([]ExternalMod{mod}, mods, test.hashes)
+		if len(plans) != 1 || plans[0].Status != test.status || plans[0].Path != test.path {
+			t.Errorf("%s: planned %+v, want %s at %s", test.name, plans, test.status, test.path)
+		}
+	}
+}
+
+func TestExternalProtected(t *testing.T) {
+	mods := t.TempDir()
+	user := ExternalMod{File: "optifine.jar", SHA256: sha256Hex([]byte("optifine")), Page: "https://optifine.net/downloads"}
+	download := ExternalMod{File: "voicechat.jar", SHA256: sha256Hex([]byte("voicechat")), URL: "https://cdn.example.com/voicechat.jar"}
+	plans := []ExternalPlan{
+		{Mod: user, Status: externalMismatch, Path: filepath.Join(mods, "optifine.jar")},
+		{Mod: download, Status: externalMismatch, Path: filepath.Join(mods, "voicechat.jar")},
+		{Mod: download, Status: externalPresent, Path: filepath.Join(mods, "sub", "voicechat-2.jar")},
+		{Mod: user, Status: externalMissing},
+	}
+	var names []string
+	for _, file := range externalProtected(mods, plans) {
+		if file.Reason != protectedExternal {
+			t.Errorf("%s protected as %s", file.Name, file.Reason)
+		}
+		names = append(names, filepath.ToSlash(file.Name))
+	}
+	// the wrong release of a downloaded mod is replaced
+	if got := strings.Join(names, " "); got != "optifine.jar sub/voicechat-2.jar" {
+		t.Errorf("protected %s", got)
+	}
+}
+
+// externalServer serves the author's downloads of external mods, and the
+// pack for everything else.
+type externalServer struct {
+	pack  http.Handler
+	files map[string]string
+}
+
+func (s *externalServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Host != "cdn.example.com" {
+		s.pack.ServeHTTP(w, r)
+		return
+	}
+	content, ok := s.files[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprint(w, content)
+}
+
+// externalPack is a pack with a mod downloaded from its author and one the
+// player provides.
+func externalPack(t *testing.T) map[string]string {
+	return map[string]string{
+		"pack.json": `{"version": "2024.06", "external": [
+			{"file": "voicechat.jar", "name": "Simple Voice Chat", "sha256": "` + sha256Hex([]byte("voicechat")) + `", "url": "https://cdn.example.com/voicechat.jar"},
+			{"file": "optifine.jar", "name": "OptiFine", "sha256": "` + sha256Hex([]byte("optifine")) + `", "page": "https://optifine.net/downloads"}
+		]}`,
+		"mods/sodium.jar": "sodium",
+	}
+}
+
+func TestUpdateExternalMods(t *testing.T) {
+	useWarnings(t)
+	u := newFakeUpdate(t, externalPack(t))
+	useTestServer(t, &externalServer{pack: u.server, files: map[string]string{"/voicechat.jar": "voicechat"}})
+
+	output := readFile(t, u.run(t))
+	for _, want := range []string{
+		"External:   Simple Voice Chat is downloaded from https://cdn.example.com/voicechat.jar",
+		"External:   OptiFine is missing, download it yourself from https://optifine.net/downloads",
+		"OptiFine, which you download yourself, is missing or not the release the pack needs. Get it from https://optifine.net/downloads",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("no %q in the output:\n%s", want, output)
+		}
+	}
+	if got := dirNames(t, u.mods); got != "sodium.jar voicechat.jar" {
+		t.Errorf("installed %s", got)
+	}
+	state, err := ReadInstalledState(filepath.Join(u.state, "clientUpdate-installed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range state.Files {
+		if want := map[string]string{"voicechat.jar": externalDownload}[file.Name]; file.External != want {
+			t.Errorf("%s recorded as %q external", file.Name, file.External)
+		}
+	}
+
+	// the player's download, saved under its own name, is found and left
+	// alone; the next update doesn't download again
+	writeFiles(t, u.mods, map[string]string{"OptiFine_HD_U_I6.jar": "optifine"})
+	u.setPack(t, map[string]string{"pack.json": externalPack(t)["pack.json"], "mods/sodium.jar": "sodium 2"})
+	output = readFile(t, u.run(t))
+	if strings.Contains(output, "External:") || strings.Contains(output, "Downloading Simple Voice Chat") {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := dirNames(t, u.mods); got != "OptiFine_HD_U_I6.jar sodium.jar voicechat.jar" {
+		t.Errorf("installed %s", got)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 2" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+}
+
+// TestUpdateExternalMismatch downloads an external mod of the wrong
+// release: that mod fails, the rest of the update goes on.
+func TestUpdateExternalMismatch(t *testing.T) {
+	useWarnings(t)
+	u := newFakeUpdate(t, externalPack(t))
+	useTestServer(t, &externalServer{pack: u.server, files: map[string]string{"/voicechat.jar": "voicechat, another release"}})
+	writeFiles(t, u.mods, map[string]string{"optifine.jar": "optifine, another release"})
+
+	output := readFile(t, u.run(t))
+	for _, want := range []string{
+		"Simple Voice Chat couldn't be downloaded from its author and is missing",
+		"External:   OptiFine is another release than the pack needs, download it yourself from https://optifine.net/downloads",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("no %q in the output:\n%s", want, output)
+		}
+	}
+	if got := dirNames(t, u.mods); got != "optifine.jar sodium.jar" {
+		t.Errorf("installed %s", got)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "optifine.jar")); got != "optifine, another release" {
+		t.Errorf("the player's optifine.jar is %q", got)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// stagingSuffix names the directory next to the mods directory the pack is
+// extracted to before it replaces the installed mods.
+const stagingSuffix = ".staging"
+
+// Extracted files may differ this much from what the manifest declares,
+// so the declaration doesn't need updating for every small change.
+const (
+	contentsFileTolerance = 0.05
+	contentsSizeTolerance = 0.10
+)
+
+// Without a manifest declaring the contents, more than this many files or
+// megabytes only warn. Both can be changed in the config.
+const (
+	defaultWarnModFiles  = 400
+	defaultWarnModsMB    = 2048
+	maxListedExtractions = 20
+)
+
+// PackContents is what a mods folder of the pack declares to hold.
+type PackContents struct {
+	Files int   `json:"files"`
+	Size  int64 `json:"size"`
+}
+
+// ExtractionCheck compares the files extracted from the pack with what the
+// pack declares.
+type ExtractionCheck struct {
+	Files int
+	Size  int64
+	// Expected is nil when the pack declares nothing for the folder.
+	Expected *PackContents
+	// Extras are the archive entries most likely extracted by mistake: mod
+	// files nested below the mods folder, e.g. from a committed source
+	// tree.
+	Extras []string
+}
+
+// CheckExtraction measures the files extracted from folder of the archive
+// and looks up what the manifest declares for it.
+func CheckExtraction(archive *PackArchive, extracted []string) (*ExtractionCheck, error) {
+	c := &ExtractionCheck{Files: len(extracted)}
+	for _, p := range extracted {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		c.Size += info.Size()
+	}
+
+	folder := archive.ModFolder
+	if folder == "" {
+		folder = "mods"
+	}
+	if archive.Manifest != nil {
+		if contents, ok := archive.Manifest.Contents[folder]; ok {
+			c.Expected = &contents
+		}
+	}
+
+	r, err := OpenPackArchive(archive.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || !isModEntry(f.Name, archive.ModFolder) {
+			continue
+		}
+		// the declared contents count every platform's files
+		if !archive.IsModEntry(f.Name) {
+			if c.Expected != nil {
+				c.Expected.Files--
+				c.Expected.Size -= int64(f.UncompressedSize64)
+			}
+			continue
+		}
+		name := PackPath(f.Name)
+		if i := strings.Index(name, "mods/"); archive.ModFolder == "" && i >= 0 {
+			name = name[i+len("mods/"):]
+		} else {
+			name = strings.TrimPrefix(name, archive.ModFolder+"/")
+		}
+		if strings.Contains(name, "/") {
+			c.Extras = append(c.Extras, PackPath(f.Name))
+		}
+	}
+	return c, nil
+}
+
+// Mismatch reports whether the extracted files differ from the declared
+// contents beyond the tolerance. It is false when nothing is declared.
+func (c *ExtractionCheck) Mismatch() bool {
+	if c.Expected == nil {
+		return false
+	}
+	return !within(int64(c.Files), int64(c.Expected.Files), contentsFileTolerance) ||
+		!within(c.Size, c.Expected.Size, contentsSizeTolerance)
+}
+
+// ExceedsLimits reports whether the extracted files are more than a pack
+// plausibly holds, for packs not declaring their contents.
+func (c *ExtractionCheck) ExceedsLimits(maxFiles int, maxMB int) bool {
+	if maxFiles <= 0 {
+		maxFiles = defaultWarnModFiles
+	}
+	if maxMB <= 0 {
+		maxMB = defaultWarnModsMB
+	}
+	return c.Files > maxFiles || c.Size > int64(maxMB)<<20
+}
+
+// PrintExtras lists the extras to w, the first few of them when there are
+// many.
+func (c *ExtractionCheck) PrintExtras(w io.Writer) {
+	if len(c.Extras) == 0 {
+		return
+	}
+	fmt.Fprintln(w, T("extract.extras", len(c.Extras)))
+	for i, name := range c.Extras {
+		if i == maxListedExtractions {
+			fmt.Fprintln(w, T("extract.extras.more", len(c.Extras)-i))
+			break
+		}
+		fmt.Fprintln(w, "  ? "+name)
+	}
+}
+
+// within reports whether got is at most tolerance away from want, relative
+// to want. At least one unit of difference is always tolerated.
+func within(got int64, want int64, tolerance float64) bool {
+	diff := got - want
+	if diff < 0 {
+		diff = -diff
+	}
+	allowed := int64(float64(want) * tolerance)
+	if allowed < 1 {
+		allowed = 1
+	}
+	return diff <= allowed
+}
+
+// EntryFailure is an entry of the archive that couldn't be read while
+// extracting, e.g. one failing its checksum. Nothing of it is left in the
+// destination.
+type EntryFailure struct {
+	// Name is the entry in the archive, Placed where below the destination
+	// it was to be extracted to.
+	Name   string
+	Placed string
+	Size   int64
+	Err    error
+}
+
+// ExtractFailedError is the error of an extraction some entries of failed,
+// returned after all the others were extracted.
+type ExtractFailedError struct {
+	Failed []EntryFailure
+}
+
+func (e *ExtractFailedError) Error() string {
+	names := make([]string, len(e.Failed))
+	for i, failure := range e.Failed {
+		names[i] = fmt.Sprintf("%s (%s)", failure.Name, failure.Err)
+	}
+	return fmt.Sprintf("%d archive entries couldn't be read: %s", len(e.Failed), strings.Join(names, ", "))
+}
+
+// entryReader remembers the error reading an entry failed with, telling it
+// from the destination failing.
+type entryReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *entryReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF {
+		e.err = err
+	}
+	return n, err
+}
+
+// CriticalFailures returns the failures of critical mods among failed. The
+// jar of a failed entry can't be read for its mod ID, so entries are
+// matched by name like ShippedMods does: a file name starting with the ID,
+// which errs on the side of a critical mod.
+func CriticalFailures(failed []EntryFailure, ids []string) []EntryFailure {
+	var critical []EntryFailure
+	for _, failure := range failed {
+		name := strings.ToLower(path.Base(failure.Name))
+		for _, id := range ids {
+			if strings.HasPrefix(name, strings.ToLower(id)) {
+				critical = append(critical, failure)
+				break
+			}
+		}
+	}
+	return critical
+}
+
+// PrintFailures lists the failed entries to w.
+func PrintFailures(w io.Writer, failed []EntryFailure) {
+	for i, failure := range failed {
+		if i == maxListedExtractions {
+			fmt.Fprintln(w, T("extract.extras.more", len(failed)-i))
+			break
+		}
+		fmt.Fprintf(w, "  ! %s: %s\n", failure.Name, failure.Err)
+	}
+}
+
+// truncatedFileError is an extracted file shorter or longer than its
+// archive entry, cut off by a write that failed without telling.
+type truncatedFileError struct {
+	path     string
+	size     int64
+	expected int64
+}
+
+func (e *truncatedFileError) Error() string {
+	return fmt.Sprintf("%s was extracted with %d bytes, the pack has %d; the disk may be full or failing", e.path, e.size, e.expected)
+}
+
+func (e *truncatedFileError) Category() string { return categoryLocal }
+
+// VerifyExtractedSizes checks that every file of report has the size of its
+// archive entry, independently of the errors the writes reported.
+func VerifyExtractedSizes(report *ExtractReport) error {
+	for i, p := range report.Paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if info.Size() != report.Sizes[i] {
+			return &truncatedFileError{path: p, size: info.Size(), expected: report.Sizes[i]}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWithin(t *testing.T) {
+	tests := []struct {
+		got, want int64
+		tolerance float64
+		within    bool
+	}{
+		{100, 100, 0.05, true},
+		{105, 100, 0.05, true},
+		{95, 100, 0.05, true},
+		{106, 100, 0.05, false},
+		{94, 100, 0.05, false},
+		// at least one off is always fine
+		{3, 2, 0.05, true},
+		{1, 0, 0.05, true},
+		{4, 2, 0.05, false},
+	}
+	for _, test := range tests {
+		if got := within(test.got, test.want, test.tolerance); got != test.within {
+			t.Errorf("within(%d, %d, %g) = %t", test.got, test.want, test.tolerance, got)
+		}
+	}
+}
+
+func TestExtractionCheckMismatch(t *testing.T) {
+	declared := &PackContents{Files: 100, Size: 100 << 20}
+	tests := []struct {
+		name     string
+		check    ExtractionCheck
+		mismatch bool
+	}{
+		{"exact", ExtractionCheck{Files: 100, Size: 100 << 20, Expected: declared}, false},
+		{"within the tolerance", ExtractionCheck{Files: 104, Size: 92 << 20, Expected: declared}, false},
+		{"over", ExtractionCheck{Files: 600, Size: 180 << 20, Expected: declared}, true},
+		{"under", ExtractionCheck{Files: 40, Size: 30 << 20, Expected: declared}, true},
+		{"too many files of the size", ExtractionCheck{Files: 110, Size: 100 << 20, Expected: declared}, true},
+		{"files of the wrong size", ExtractionCheck{Files: 100, Size: 120 << 20, Expected: declared}, true},
+		{"nothing declared", ExtractionCheck{Files: 600, Size: 180 << 20}, false},
+	}
+	for _, test := range tests {
+		if got := test.check.Mismatch(); got != test.mismatch {
+			t.Errorf("%s: mismatch %t", test.name, got)
+		}
+	}
+}
+
+func TestExceedsLimits(t *testing.T) {
+	tests := []struct {
+		check           ExtractionCheck
+		maxFiles, maxMB int
+		exceeds         bool
+	}{
+		{ExtractionCheck{Files: defaultWarnModFiles, Size: defaultWarnModsMB << 20}, 0, 0, false},
+		{ExtractionCheck{Files: defaultWarnModFiles + 1}, 0, 0, true},
+		{ExtractionCheck{Size: defaultWarnModsMB<<20 + 1}, 0, 0, true},
+		{ExtractionCheck{Files: 11}, 10, 0, true},
+		{ExtractionCheck{Files: 500, Size: 5 << 20}, 1000, 4, true},
+		{ExtractionCheck{Files: 500, Size: 3 << 20}, 1000, 4, false},
+	}
+	for _, test := range tests {
+		if got := test.check.ExceedsLimits(test.maxFiles, test.maxMB); got != test.exceeds {
+			t.Errorf("%+v within %d files and %d MB: exceeds %t", test.check, test.maxFiles, test.maxMB, got)
+		}
+	}
+}
+
+// TestCheckExtraction measures the files extracted from a pack with a
+// source tree committed below its mods folder.
+func TestCheckExtraction(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "pack.zip")
+	writeZip(t, archivePath, map[string]string{
+		"rxmc-Mods-master/pack.json":                              `{"versions": {"1.20.1": "mods-1.20.1"}, "contents": {"mods-1.20.1": {"files": 2, "size": 13}}}`,
+		"rxmc-Mods-master/mods-1.20.1/sodium.jar":                 "sodium",
+		"rxmc-Mods-master/mods-1.20.1/lithium.jar":                "lithium",
+		"rxmc-Mods-master/mods-1.20.1/src/build/libs/mymod.jar":   "built",
+		"rxmc-Mods-master/mods-1.20.1/src/build/libs/mymod-2.jar": "built",
+	})
+	archive, err := ValidateArchive(archivePath, "1.20.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	extracted := filepath.Join(t.TempDir(), "mods")
+	writeFiles(t, extracted, map[string]string{
+		"sodium.jar":                 "sodium",
+		"lithium.jar":                "lithium",
+		"src/build/libs/mymod.jar":   "built",
+		"src/build/libs/mymod-2.jar": "built",
+	})
+	check, err := CheckExtraction(archive, []string{
+		filepath.Join(extracted, "sodium.jar"),
+		filepath.Join(extracted, "lithium.jar"),
+		filepath.Join(extracted, "src", "build", "libs", "mymod.jar"),
+		filepath.Join(extracted, "src", "build", "libs", "mymod-2.jar"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Files != 4 || check.Size != 23 || check.Expected == nil || *check.Expected != (PackContents{Files: 2, Size: 13}) || !check.Mismatch() {
+		t.Errorf("check %+v, expected %+v", check, check.Expected)
+	}
+	if got := strings.Join(check.Extras, " "); got != "mods-1.20.1/src/build/libs/mymod-2.jar mods-1.20.1/src/build/libs/mymod.jar" {
+		t.Errorf("extras %s", got)
+	}
+}
+
+func TestPrintExtras(t *testing.T) {
+	var b bytes.Buffer
+	(&ExtractionCheck{}).PrintExtras(&b)
+	if b.Len() != 0 {
+		t.Errorf("printed %q without extras", b.String())
+	}
+	check := &ExtractionCheck{}
+	for i := 0; i < maxListedExtractions+3; i++ {
+		check.Extras = append(check.Extras, fmt.Sprintf("mods/src/%02d.jar", i))
+	}
+	check.PrintExtras(&b)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != maxListedExtractions+2 || lines[1] != "  ? mods/src/00.jar" || lines[len(lines)-1] != "    ... and 3 more" {
+		t.Errorf("printed:\n%s", b.String())
+	}
+}
+
+// executeUpdate updates a mods directory holding installed from the pack
+// files, by path in the pack, returning what the plan printed.
+func executeUpdate(t *testing.T, files map[string]string, installed map[string]string, warnModFiles int) (string, string, error) {
+	t.Helper()
+	return executeArchive(t, packZip(t, files), installed, warnModFiles)
+}
+
+// packZip writes an archive of the pack files, by path in the pack,
+// returning its path.
+func packZip(t *testing.T, files map[string]string) string {
+	t.Helper()
+	entries := map[string]string{}
+	for name, content := range files {
+		entries["rxmc-Mods-master/"+name] = content
+	}
+	archivePath := filepath.Join(t.TempDir(), "pack.zip")
+	writeZip(t, archivePath, entries)
+	return archivePath
+}
+
+// executeArchive is executeUpdate for a pack archive already written.
+func executeArchive(t *testing.T, archivePath string, installed map[string]string, warnModFiles int) (string, string, error) {
+	t.Helper()
+	useRunState(t)
+	root := t.TempDir()
+	archive, err := ValidateArchive(archivePath, "1.20.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	minecraft := filepath.Join(root, ".minecraft")
+	mods := filepath.Join(minecraft, "mods")
+	writeFiles(t, mods, installed)
+	versions := filepath.Join(minecraft, "versions")
+	var out bytes.Buffer
+	plan := UpdatePlan{
+		Archive:       archive,
+		MCVersion:     "1.20.1",
+		ModPath:       mods,
+		MinecraftPath: minecraft,
+		GameDir:       minecraft,
+		Loader:        fabricLoader{},
+		Prepared:      Prepare(mods, versions, fabricLoader{}, "1.20.1"),
+		Journal:       &Journal{Path: filepath.Join(root, "journal.jsonl"), Run: "run"},
+		BackupDir:     filepath.Join(root, "backup"),
+		RecoveryDir:   filepath.Join(root, "recovery"),
+		WarnModFiles:  warnModFiles,
+		Out:           &out,
+	}
+	err = plan.Execute()
+	return mods, out.String(), err
+}
+
+func TestUpdateChecksDeclaredContents(t *testing.T) {
+	installed := map[string]string{"sodium.jar": "old sodium"}
+	tests := []struct {
+		name     string
+		contents string
+		// refused is set when the update has to stop.
+		refused bool
+	}{
+		{name: "exact", contents: `{"files": 2, "size": 13}`},
+		{name: "over", contents: `{"files": 1, "size": 6}`, refused: true},
+		{name: "under", contents: `{"files": 40, "size": 4000}`, refused: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mods, output, err := executeUpdate(t, map[string]string{
+				"pack.json":               `{"versions": {"1.20.1": "mods-1.20.1"}, "contents": {"mods-1.20.1": ` + test.contents + `}}`,
+				"mods-1.20.1/sodium.jar":  "sodium",
+				"mods-1.20.1/lithium.jar": "lithium",
+			}, installed, 0)
+			if !test.refused {
+				if err != nil {
+					t.Fatalf("%v, output:\n%s", err, output)
+				}
+				if got := dirNames(t, mods); got != "lithium.jar sodium.jar" {
+					t.Errorf("installed %s", got)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(output, "STOPPED: the pack declares") || !strings.Contains(output, "were kept in "+mods+stagingSuffix) {
+				t.Fatalf("%v, output:\n%s", err, output)
+			}
+			// nothing installed changed, the extracted files are kept
+			if got := dirNames(t, mods); got != "sodium.jar" || readFile(t, filepath.Join(mods, "sodium.jar")) != "old sodium" {
+				t.Errorf("installed %s", got)
+			}
+			if got := dirNames(t, mods+stagingSuffix); !strings.Contains(got, "lithium.jar sodium.jar") {
+				t.Errorf("staged %s", got)
+			}
+		})
+	}
+}
+
+// TestUpdateWarnsAboutLargeExtractions updates from a pack declaring
+// nothing that extracts more files than the limit: it only warns.
+func TestUpdateWarnsAboutLargeExtractions(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 3; i++ {
+		files[fmt.Sprintf("mods-1.20.1/src/mod%d.jar", i)] = "built"
+	}
+	var warned bytes.Buffer
+	defer PrintWarningsTo(&warned)()
+	mods, output, err := executeUpdate(t, files, nil, 2)
+	if err != nil {
+		t.Fatalf("%v, output:\n%s", err, output)
+	}
+	if got := dirNames(t, mods); got != "mod0.jar mod1.jar mod2.jar" {
+		t.Errorf("installed %s", got)
+	}
+	if !strings.Contains(output, "3 files come from folders below the mods folder") {
+		t.Errorf("output:\n%s", output)
+	}
+	if !strings.Contains(warned.String(), "the pack put 3 files") {
+		t.Errorf("warned %q among %q", warned.String(), warningMessages(warnExtract))
+	}
+}
+
+// damageZipEntry flips a byte in the middle of the stored data of the
+// entry name of the archive at p, so reading it fails.
+func damageZipEntry(t *testing.T, p string, name string) {
+	t.Helper()
+	r, err := zip.OpenReader(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offset int64 = -1
+	for _, f := range r.File {
+		if f.Name == name {
+			start, err := f.DataOffset()
+			if err != nil {
+				t.Fatal(err)
+			}
+			offset = start + int64(f.CompressedSize64)/2
+		}
+	}
+	r.Close()
+	if offset < 0 {
+		t.Fatalf("no entry %s in %s", name, p)
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[offset] ^= 0xff
+	if err := os.WriteFile(p, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnzipDamagedEntries(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "pack.zip")
+	writeZip(t, archive, map[string]string{
+		"mods/iris.jar":    strings.Repeat("iris ", 50),
+		"mods/lithium.jar": strings.Repeat("lithium ", 50),
+		"mods/sodium.jar":  strings.Repeat("sodium ", 50),
+	})
+	damageZipEntry(t, archive, "mods/lithium.jar")
+	dest := t.TempDir()
+	report, err := unzipMatching(archive, dest, func(f *zip.File) bool { return true }, true)
+	var failed *ExtractFailedError
+	if !errors.As(err, &failed) {
+		t.Fatalf("extracting failed with %v", err)
+	}
+	// the entries after the damaged one are extracted as well
+	if got := dirNames(t, dest); got != "iris.jar sodium.jar" {
+		t.Errorf("extracted %s", got)
+	}
+	if report.Files != 2 || len(report.Paths) != 2 {
+		t.Errorf("reported %d files, %d paths", report.Files, len(report.Paths))
+	}
+	if len(failed.Failed) != 1 || failed.Failed[0].Name != "mods/lithium.jar" || failed.Failed[0].Placed != "lithium.jar" || failed.Failed[0].Size != 400 {
+		t.Fatalf("failed %+v", failed.Failed)
+	}
+	if !strings.HasPrefix(err.Error(), "1 archive entries couldn't be read: mods/lithium.jar (") {
+		t.Errorf("error %q", err)
+	}
+}
+
+func TestUnzipDestinationFailing(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "pack.zip")
+	writeZip(t, archive, map[string]string{
+		"mods/iris.jar":   "iris",
+		"mods/sodium.jar": "sodium",
+	})
+	// a directory in the way of the first file stops the extraction, the
+	// archive isn't what failed
+	dest := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dest, "iris.jar", "in the way"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	_, err := unzipMatching(archive, dest, func(f *zip.File) bool { return true }, true)
+	var failed *ExtractFailedError
+	if err == nil || errors.As(err, &failed) {
+		t.Fatalf("extracting failed with %v", err)
+	}
+	if got := dirNames(t, dest); got != "iris.jar" {
+		t.Errorf("extracted %s", got)
+	}
+}
+
+func TestCriticalFailures(t *testing.T) {
+	failed := []EntryFailure{
+		{Name: "mods/Sodium-0.5.8.jar"},
+		{Name: "mods/lithium.jar"},
+		{Name: "mods/sub/fabric-api-0.92.jar"},
+	}
+	var names []string
+	for _, failure := range CriticalFailures(failed, []string{"fabric-api", "sodium", "iris"}) {
+		names = append(names, failure.Name)
+	}
+	if got := strings.Join(names, " "); got != "mods/Sodium-0.5.8.jar mods/sub/fabric-api-0.92.jar" {
+		t.Errorf("critical %s", got)
+	}
+	if got := CriticalFailures(failed, nil); got != nil {
+		t.Errorf("critical without ids %+v", got)
+	}
+}
+
+// TestUpdateWithDamagedEntries updates from pack archives with a damaged
+// mod: the update goes ahead without it only when the manifest tells the
+// pack can do without it.
+func TestUpdateWithDamagedEntries(t *testing.T) {
+	installed := map[string]string{"sodium.jar": "old sodium", "lithium.jar": "old lithium"}
+	tests := []struct {
+		name     string
+		manifest string
+		damaged  string
+		// stopped is the reason printed when the update has to stop.
+		stopped string
+	}{
+		{name: "optional", manifest: `{"versions": {"1.20.1": "mods-1.20.1"}, "criticalMods": ["sodium"]}`, damaged: "lithium.jar"},
+		{name: "critical", manifest: `{"versions": {"1.20.1": "mods-1.20.1"}, "criticalMods": ["sodium"]}`, damaged: "sodium.jar", stopped: "1 of them are mods the pack can't run without"},
+		{name: "no manifest", damaged: "lithium.jar", stopped: "The pack has no manifest"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useWarnings(t)
+			files := map[string]string{
+				"mods-1.20.1/sodium.jar":  modJar(t, "sodium", "0.5.8"),
+				"mods-1.20.1/lithium.jar": strings.Repeat("lithium ", 50),
+			}
+			if test.manifest != "" {
+				files["pack.json"] = test.manifest
+			}
+			archive := packZip(t, files)
+			damageZipEntry(t, archive, "rxmc-Mods-master/mods-1.20.1/"+test.damaged)
+			mods, output, err := executeArchive(t, archive, installed, 0)
+			if test.stopped == "" {
+				if err != nil {
+					t.Fatalf("%v, output:\n%s", err, output)
+				}
+				// the damaged mod is left out, the installed one removed
+				if got := dirNames(t, mods); got != "sodium.jar" {
+					t.Errorf("installed %s", got)
+				}
+				if got := strings.Join(warningMessages(warnExtract), "\n"); !strings.HasPrefix(got, "lithium.jar couldn't be read from the damaged pack archive") {
+					t.Errorf("warned %q", got)
+				}
+				return
+			}
+			var failed *ExtractFailedError
+			if !errors.As(err, &failed) || len(failed.Failed) != 1 {
+				t.Fatalf("%v, output:\n%s", err, output)
+			}
+			for _, want := range []string{"STOPPED: 1 files of the pack archive couldn't be read", "  ! rxmc-Mods-master/mods-1.20.1/" + test.damaged + ": ", test.stopped} {
+				if !strings.Contains(output, want) {
+					t.Errorf("no %q in the output:\n%s", want, output)
+				}
+			}
+			// nothing installed changed, and nothing is left staged
+			if got := dirNames(t, mods); got != "lithium.jar sodium.jar" || readFile(t, filepath.Join(mods, "sodium.jar")) != "old sodium" {
+				t.Errorf("installed %s", got)
+			}
+			if _, err := os.Stat(mods + stagingSuffix); !os.IsNotExist(err) {
+				t.Errorf("the staging directory is left: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// defaultExtraPatterns are the files the pack's transforms may install in
+// each folder of the minecraft directory, as patterns matched against the
+// file name. Folders without patterns take any file that isn't junk. The
+// manifest's extraFiles add to them.
+var defaultExtraPatterns = map[string][]string{
+	"config":        {"*.json", "*.json5", "*.toml", "*.properties", "*.txt", "*.cfg", "*.yml", "*.yaml", "*.snbt"},
+	"resourcepacks": {"*.zip"},
+	"shaderpacks":   {"*.zip", "*.txt"},
+}
+
+// junkPatterns are files and folders never installed wherever they are in
+// the pack: what editors, file managers and git leave behind.
+var junkPatterns = []string{".DS_Store", "Thumbs.db", "desktop.ini", "*~", "*.swp", ".git*"}
+
+// Verdicts on a file of the pack's transforms, see ClassifyExtra.
+const (
+	extraAllowed = "allowed"
+	// extraJunk is a file or below a folder matching junkPatterns.
+	extraJunk = "junk"
+	// extraUnlisted is a file of a folder whose patterns it doesn't match.
+	extraUnlisted = "unlisted"
+)
+
+// ClassifyExtra tells whether the file at slash separated path p, relative
+// to the minecraft directory, may be installed given the patterns of each
+// folder.
+func ClassifyExtra(p string, patterns map[string][]string) string {
+	if isJunk(p) {
+		return extraJunk
+	}
+	i := strings.Index(p, "/")
+	if i < 0 {
+		return extraAllowed
+	}
+	allowed, ok := patterns[p[:i]]
+	if !ok {
+		return extraAllowed
+	}
+	if matchesAny(path.Base(p), allowed) {
+		return extraAllowed
+	}
+	return extraUnlisted
+}
+
+// isJunk reports whether any part of the slash separated path p matches
+// junkPatterns.
+func isJunk(p string) bool {
+	for _, part := range strings.Split(p, "/") {
+		if part != "" && matchesAny(part, junkPatterns) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny reports whether name matches one of patterns, ignoring case.
+func matchesAny(name string, patterns []string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// extraPatterns returns the patterns of each folder: the defaults and the
+// manifest's own.
+func (m *PackManifest) extraPatterns() map[string][]string {
+	patterns := map[string][]string{}
+	for folder, list := range defaultExtraPatterns {
+		patterns[folder] = list
+	}
+	for folder, list := range m.ExtraFiles {
+		folder = strings.Trim(folder, "/")
+		patterns[folder] = append(append([]string{}, patterns[folder]...), list...)
+	}
+	return patterns
+}
+
+// IgnoredExtra is a file of the manifest's transforms that isn't
+// installed, Reason being extraJunk or extraUnlisted.
+type IgnoredExtra struct {
+	Path   string
+	Reason string
+}
+
+// filterTransforms takes the files ClassifyExtra doesn't allow out of the
+// transforms, listing them in Ignored instead.
+func (m *PackManifest) filterTransforms() {
+	patterns := m.extraPatterns()
+	for dest := range m.Transforms {
+		if verdict := ClassifyExtra(dest, patterns); verdict != extraAllowed {
+			m.Ignored = append(m.Ignored, IgnoredExtra{Path: dest, Reason: verdict})
+			delete(m.Transforms, dest)
+		}
+	}
+	sort.Slice(m.Ignored, func(i, j int) bool { return m.Ignored[i].Path < m.Ignored[j].Path })
+}
+
+// validateExtraFiles reports patterns of the manifest that aren't file
+// name patterns.
+func validateExtraFiles(extra map[string][]string, problems *manifestProblems) {
+	for _, folder := range sortedKeys(extra) {
+		for i, pattern := range extra[folder] {
+			if _, err := path.Match(pattern, ""); err != nil || strings.Contains(pattern, "/") {
+				problems.add(indexPath(keyPath("extraFiles", folder), i), "%q is not a file name pattern", pattern)
+			}
+		}
+	}
+}
+
+// junkEntriesError is a pack with junk in strict mode.
+type junkEntriesError struct {
+	paths []string
+}
+
+func (e *junkEntriesError) Error() string {
+	return fmt.Sprintf("the pack has junk files: %s", strings.Join(e.paths, ", "))
+}
+
+// checkJunk fails on the entries of the archive r matching junkPatterns in
+// strict mode. Otherwise they are only logged, nothing installs them.
+func checkJunk(r *zip.Reader) error {
+	var junk []string
+	for _, f := range r.File {
+		if p := strings.TrimSuffix(PackPath(f.Name), "/"); p != "" && isJunk(p) {
+			junk = append(junk, p)
+		}
+	}
+	if len(junk) == 0 {
+		return nil
+	}
+	if strictPack {
+		return &junkEntriesError{paths: junk}
+	}
+	Logf("pack: %d junk entries, e.g. %s", len(junk), junk[0])
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClassifyExtra(t *testing.T) {
+	patterns := map[string][]string{
+		"config":        defaultExtraPatterns["config"],
+		"resourcepacks": defaultExtraPatterns["resourcepacks"],
+		"shaderpacks":   defaultExtraPatterns["shaderpacks"],
+		"emptylist":     {},
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"config/sodium-options.json", extraAllowed},
+		{"config/iris.properties", extraAllowed},
+		{"config/fabric/indigo-renderer.properties", extraAllowed},
+		{"config/voicechat/voicechat-client.toml", extraAllowed},
+		{"config/Sodium.JSON", extraAllowed},
+		{"config/xaero.txt", extraAllowed},
+		{"config/ftbquests/quests/chapters/intro.snbt", extraAllowed},
+		{"config/journeymap.dat", extraUnlisted},
+		{"config/readme", extraUnlisted},
+		{"config/backup.json.bak", extraUnlisted},
+		{"resourcepacks/rx.zip", extraAllowed},
+		{"resourcepacks/rx.ZIP", extraAllowed},
+		{"resourcepacks/rx/pack.mcmeta", extraUnlisted},
+		{"shaderpacks/complementary.zip", extraAllowed},
+		{"shaderpacks/complementary.zip.txt", extraAllowed},
+		{"shaderpacks/complementary.rar", extraUnlisted},
+		{"emptylist/anything.json", extraUnlisted},
+		// folders without patterns and the minecraft directory itself
+		// take anything that isn't junk
+		{"servers.dat", extraAllowed},
+		{"options.txt", extraAllowed},
+		{"schematics/house.litematic", extraAllowed},
+		{"saves/world/level.dat", extraAllowed},
+		// junk anywhere, in any folder and case
+		{".DS_Store", extraJunk},
+		{"config/.DS_Store", extraJunk},
+		{"config/.ds_store", extraJunk},
+		{"resourcepacks/Thumbs.db", extraJunk},
+		{"schematics/thumbs.db", extraJunk},
+		{"shaderpacks/desktop.ini", extraJunk},
+		{"config/sodium-options.json~", extraJunk},
+		{"config/.sodium-options.json.swp", extraJunk},
+		{"options.txt~", extraJunk},
+		{".gitignore", extraJunk},
+		{".gitattributes", extraJunk},
+		{".git/config", extraJunk},
+		{"config/.git/HEAD", extraJunk},
+		{"config/.github/workflows/ci.json", extraJunk},
+		{"config/sub/.git/objects/ab/cdef.json", extraJunk},
+		// junk names only in part aren't junk
+		{"config/git.json", extraAllowed},
+		{"config/digit.toml", extraAllowed},
+		{"config/notes.swp.json", extraAllowed},
+	}
+	for _, test := range tests {
+		if got := ClassifyExtra(test.path, patterns); got != test.want {
+			t.Errorf("%s is %s, want %s", test.path, got, test.want)
+		}
+	}
+}
+
+func TestExtraPatterns(t *testing.T) {
+	defaults := append([]string{}, defaultExtraPatterns["config"]...)
+	m := &PackManifest{ExtraFiles: map[string][]string{
+		"/config/":   {"*.dat"},
+		"schematics": {"*.litematic"},
+	}}
+	patterns := m.extraPatterns()
+	if got := patterns["config"]; !reflect.DeepEqual(got, append(append([]string{}, defaults...), "*.dat")) {
+		t.Errorf("config takes %q", got)
+	}
+	if got := patterns["schematics"]; !reflect.DeepEqual(got, []string{"*.litematic"}) {
+		t.Errorf("schematics takes %q", got)
+	}
+	if got := patterns["resourcepacks"]; !reflect.DeepEqual(got, []string{"*.zip"}) {
+		t.Errorf("resourcepacks takes %q", got)
+	}
+	// extending a folder leaves the defaults alone
+	if !reflect.DeepEqual(defaultExtraPatterns["config"], defaults) {
+		t.Errorf("defaults changed to %q", defaultExtraPatterns["config"])
+	}
+
+	if ClassifyExtra("config/journeymap.dat", patterns) != extraAllowed || ClassifyExtra("schematics/house.nbt", patterns) != extraUnlisted {
+		t.Error("the manifest's patterns aren't applied")
+	}
+	// junk stays junk whatever the manifest allows
+	m.ExtraFiles["config"] = []string{"*", ".*"}
+	if got := ClassifyExtra("config/.DS_Store", m.extraPatterns()); got != extraJunk {
+		t.Errorf("allowed junk: %s", got)
+	}
+}
+
+func TestParsePackManifestFiltersTransforms(t *testing.T) {
+	manifest, err := parsePackManifest([]byte(`{
+		"transforms": {
+			"config/chat.json": "template",
+			"config/chat.json~": "template",
+			"config/journeymap.dat": "skip-if-exists",
+			"config/minimap.dat": "skip-if-exists",
+			"resourcepacks/rx.zip": "skip-if-exists",
+			"resourcepacks/.DS_Store": "skip-if-exists",
+			"options.txt": "options-defaults"
+		},
+		"extraFiles": {"config": ["journeymap.dat"]}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(sortedKeys(manifest.Transforms), " "); got != "config/chat.json config/journeymap.dat options.txt resourcepacks/rx.zip" {
+		t.Errorf("transforms %s", got)
+	}
+	want := []IgnoredExtra{
+		{Path: "config/chat.json~", Reason: extraJunk},
+		{Path: "config/minimap.dat", Reason: extraUnlisted},
+		{Path: "resourcepacks/.DS_Store", Reason: extraJunk},
+	}
+	if !reflect.DeepEqual(manifest.Ignored, want) {
+		t.Errorf("ignored %+v", manifest.Ignored)
+	}
+}
+
+func TestCheckJunk(t *testing.T) {
+	archive := packZip(t, map[string]string{
+		"mods/sodium.jar":       "sodium",
+		"mods/.DS_Store":        "finder",
+		".gitignore":            "*.log",
+		"config/chat.json":      "chat",
+		"config/.git/HEAD":      "ref: refs/heads/master",
+		"config/chat.json.orig": "not junk, only not installed",
+	})
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	useStrictPack(t, false)
+	log := captureRunLog(t)
+	if err := checkJunk(&r.Reader); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(log.String(), "pack: 3 junk entries, e.g. .gitignore") {
+		t.Errorf("log:\n%s", log)
+	}
+
+	useStrictPack(t, true)
+	err = checkJunk(&r.Reader)
+	var junk *junkEntriesError
+	if !errors.As(err, &junk) || err.Error() != "the pack has junk files: .gitignore, config/.git/HEAD, mods/.DS_Store" {
+		t.Errorf("strict: failed with %v", err)
+	}
+
+	clean := packZip(t, map[string]string{"mods/sodium.jar": "sodium", "config/chat.json": "chat"})
+	cleanReader, err := zip.OpenReader(clean)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanReader.Close()
+	if err := checkJunk(&cleanReader.Reader); err != nil {
+		t.Errorf("clean pack: %v", err)
+	}
+}
+
+// junkPack is a pack whose transforms list a config, an editor backup of
+// it and a file config doesn't take.
+func junkPack() map[string]string {
+	return map[string]string{
+		"pack.json":          `{"transforms": {"config/chat.json": "template", "config/chat.json~": "template", "config/minimap.dat": "template"}}`,
+		"config/chat.json":   "chat",
+		"config/chat.json~":  "chat backup",
+		"config/minimap.dat": "minimap",
+		"mods/sodium.jar":    "sodium",
+	}
+}
+
+// TestUpdateIgnoresJunk updates from a pack with junk in its transforms:
+// only the allowed config is installed, the rest is counted in the
+// preflight and logged. With --strict-pack the run fails before anything
+// changes.
+func TestUpdateIgnoresJunk(t *testing.T) {
+	useRunState(t)
+	u := newFakeUpdate(t, junkPack())
+
+	if code := exitsWith(func() { u.run(t, "--strict-pack") }); code != exitLocal {
+		t.Errorf("exited with %d", code)
+	}
+	if got := dirNames(t, u.mods); got != "" {
+		t.Errorf("installed %s", got)
+	}
+	logPath := filepath.Join(u.state, "clientUpdate.log")
+	if log := readFile(t, logPath); !strings.Contains(log, "the pack has junk files: config/chat.json~") {
+		t.Errorf("log:\n%s", log)
+	}
+
+	output := readFile(t, u.run(t))
+	if !strings.Contains(output, T("preflight.junk", 2)) {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := dirNames(t, filepath.Join(u.minecraft, "config")); got != "chat.json" {
+		t.Errorf("installed configs %s", got)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+	log := readFile(t, logPath)
+	for _, want := range []string{"pack: not installing config/chat.json~, it is junk", "pack: not installing config/minimap.dat, it is unlisted"} {
+		if !strings.Contains(log, want) {
+			t.Errorf("no %q in the log:\n%s", want, log)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(u.minecraft, "config", "minimap.dat")); !os.IsNotExist(err) {
+		t.Errorf("minimap.dat was installed: %v", err)
+	}
+}
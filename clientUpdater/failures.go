@@ -0,0 +1,208 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// failuresSchema is the schema of the record of failed runs.
+const failuresSchema = 1
+
+// Kinds of failure telling apart what the categories don't, for the
+// failures some remedy can fix. Other failures are of their category.
+const (
+	failureCorruptArchive = "corrupt-archive"
+	failurePath           = "path"
+)
+
+// Remedies tried once when the same failure keeps coming back.
+const (
+	// remedyClearCache empties the download cache and throws away the
+	// archives an interrupted run fetched.
+	remedyClearCache = "clear-cache"
+	// remedyPickDir has the player pick the mods directory again, in the
+	// next run with someone to ask.
+	remedyPickDir = "pick-dir"
+)
+
+// escalationRule is what changes once update runs failed the same way
+// Failures times in a row. An empty Kind matches every kind.
+type escalationRule struct {
+	Kind     string
+	Failures int
+	// Notify shows a notification of the failures even while they are
+	// switched off, Banner tells them to interactive runs.
+	Notify bool
+	Banner bool
+	// Remedy is tried once per run of failures.
+	Remedy string
+}
+
+// escalationRules are the rules in force.
+var escalationRules = []escalationRule{
+	{Failures: 3, Notify: true, Banner: true},
+	{Kind: failureCorruptArchive, Failures: 2, Remedy: remedyClearCache},
+	{Kind: failurePath, Failures: 2, Remedy: remedyPickDir},
+}
+
+// FailureRecord is the update runs that failed the same way in a row, the
+// latest one's phase and message.
+type FailureRecord struct {
+	Kind    string    `json:"kind"`
+	Count   int       `json:"count"`
+	Since   time.Time `json:"since"`
+	Last    time.Time `json:"last"`
+	Phase   string    `json:"phase"`
+	Message string    `json:"message"`
+	// Remedied are the remedies already tried against these failures.
+	Remedied []string `json:"remedied,omitempty"`
+}
+
+// remedied reports whether remedy was tried already.
+func (r *FailureRecord) remedied(remedy string) bool {
+	for _, tried := range r.Remedied {
+		if tried == remedy {
+			return true
+		}
+	}
+	return false
+}
+
+// Escalation is what a run of failures calls for.
+type Escalation struct {
+	Notify bool
+	Banner bool
+	// Remedies are the ones due and not tried yet.
+	Remedies []string
+}
+
+// Escalate applies rules to record, nil meaning no failures.
+func Escalate(record *FailureRecord, rules []escalationRule) Escalation {
+	var escalation Escalation
+	if record == nil {
+		return escalation
+	}
+	for _, rule := range rules {
+		if rule.Kind != "" && rule.Kind != record.Kind || record.Count < rule.Failures {
+			continue
+		}
+		escalation.Notify = escalation.Notify || rule.Notify
+		escalation.Banner = escalation.Banner || rule.Banner
+		if rule.Remedy != "" && !record.remedied(rule.Remedy) {
+			escalation.Remedies = append(escalation.Remedies, rule.Remedy)
+		}
+	}
+	return escalation
+}
+
+// Banner tells an interactive run about the failures.
+func (r *FailureRecord) Banner() string {
+	return T("failures.banner", r.Count, r.Since.Local().Format("2006-01-02"), T("phase."+r.Phase), r.Message)
+}
+
+// FailureKind returns the kind of failure err is: one a remedy is known
+// for, or else its category.
+func FailureKind(err error) string {
+	var corrupt *corruptArchiveError
+	var checksum *checksumError
+	var truncated *truncatedError
+	var truncatedFile *truncatedFileError
+	var staged *stagedArchiveError
+	var installer *installerHashError
+	var modsDir *modsDirError
+	var gameRoot *gameRootError
+	switch {
+	case errors.As(err, &corrupt) || errors.As(err, &checksum) || errors.As(err, &truncated) ||
+		errors.As(err, &truncatedFile) || errors.As(err, &staged) || errors.As(err, &installer) ||
+		errors.Is(err, zip.ErrFormat) || errors.Is(err, zip.ErrChecksum):
+		return failureCorruptArchive
+	case errors.As(err, &modsDir) || errors.As(err, &gameRoot):
+		return failurePath
+	}
+	return ErrorCategory(err)
+}
+
+// failuresPath is where update runs keep their FailureRecord, set by
+// TrackFailures. Other modes leave it empty, their failures don't count.
+var failuresPath string
+
+// TrackFailures has the failures of this run recorded at p, and reads the
+// failures recorded before.
+func TrackFailures(p string) *FailureRecord {
+	failuresPath = p
+	record, err := ReadFailureRecord(p)
+	if err != nil {
+		Logf("failures: %s", err)
+	}
+	return record
+}
+
+// ReadFailureRecord reads the record at p, nil when the last run didn't
+// fail.
+func ReadFailureRecord(p string) (*FailureRecord, error) {
+	var record FailureRecord
+	if err := ReadStateFile(p, failuresSchema, &record); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// RecordFailure counts a failed update run of kind, which failed while in
+// phase with message. A notification is shown when the rules call for one.
+func RecordFailure(kind string, phase string, message string) {
+	if failuresPath == "" {
+		return
+	}
+	record, err := ReadFailureRecord(failuresPath)
+	if err != nil {
+		Logf("failures: %s", err)
+	}
+	now := clock.Now()
+	if record == nil || record.Kind != kind {
+		record = &FailureRecord{Kind: kind, Since: now}
+	}
+	record.Count++
+	record.Last, record.Phase, record.Message = now, phase, message
+	if err := WriteStateFile(failuresPath, failuresSchema, record); err != nil {
+		Logf("failures: writing %s: %s", failuresPath, err)
+	}
+	Logf("failures: %d in a row of %s since %s", record.Count, kind, record.Since.Format(time.RFC3339))
+	if Escalate(record, escalationRules).Notify {
+		NotifyAlways(T("failures.notify", record.Count, record.Since.Local().Format("2006-01-02")))
+	}
+}
+
+// MarkRemedied records that remedy was tried against the failures.
+func MarkRemedied(record *FailureRecord, remedy string) {
+	record.Remedied = append(record.Remedied, remedy)
+	if err := WriteStateFile(failuresPath, failuresSchema, record); err != nil {
+		Logf("failures: writing %s: %s", failuresPath, err)
+	}
+}
+
+// ResetFailures forgets the failures once an update run got through. It is
+// deferred by main, runs that fail exit before.
+func ResetFailures() {
+	if code := recover(); code != nil {
+		panic(code)
+	}
+	if failuresPath == "" {
+		return
+	}
+	if record, _ := ReadFailureRecord(failuresPath); record != nil {
+		Logf("failures: the run succeeded after %d failures of %s", record.Count, record.Kind)
+	}
+	if err := RemoveStateFile(failuresPath); err != nil {
+		Logf("failures: removing %s: %s", failuresPath, err)
+	}
+}
+
+// describeFailures is the log line of a run of failures.
+func describeFailures(record *FailureRecord) string {
+	return fmt.Sprintf("%d failures of %s since %s, the last while %s: %s", record.Count, record.Kind, record.Since.Format(time.RFC3339), record.Phase, record.Message)
+}
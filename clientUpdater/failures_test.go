@@ -0,0 +1,207 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEscalate(t *testing.T) {
+	tests := []struct {
+		name   string
+		record *FailureRecord
+		want   Escalation
+	}{
+		{"no failures", nil, Escalation{}},
+		{"first network failure", &FailureRecord{Kind: categoryNetwork, Count: 1}, Escalation{}},
+		{"second network failure", &FailureRecord{Kind: categoryNetwork, Count: 2}, Escalation{}},
+		{"third network failure", &FailureRecord{Kind: categoryNetwork, Count: 3}, Escalation{Notify: true, Banner: true}},
+		{"tenth network failure", &FailureRecord{Kind: categoryNetwork, Count: 10}, Escalation{Notify: true, Banner: true}},
+		{"first damaged download", &FailureRecord{Kind: failureCorruptArchive, Count: 1}, Escalation{}},
+		{"second damaged download", &FailureRecord{Kind: failureCorruptArchive, Count: 2}, Escalation{Remedies: []string{remedyClearCache}}},
+		{"third damaged download", &FailureRecord{Kind: failureCorruptArchive, Count: 3}, Escalation{Notify: true, Banner: true, Remedies: []string{remedyClearCache}}},
+		{"cache cleared already", &FailureRecord{Kind: failureCorruptArchive, Count: 3, Remedied: []string{remedyClearCache}}, Escalation{Notify: true, Banner: true}},
+		{"second unusable directory", &FailureRecord{Kind: failurePath, Count: 2}, Escalation{Remedies: []string{remedyPickDir}}},
+		{"directory picked already", &FailureRecord{Kind: failurePath, Count: 2, Remedied: []string{remedyPickDir}}, Escalation{}},
+		{"another remedy tried", &FailureRecord{Kind: failurePath, Count: 4, Remedied: []string{remedyClearCache}}, Escalation{Notify: true, Banner: true, Remedies: []string{remedyPickDir}}},
+	}
+	for _, test := range tests {
+		if got := Escalate(test.record, escalationRules); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: %+v, want %+v", test.name, got, test.want)
+		}
+	}
+
+	// the rules are data, any table is applied the same way
+	rules := []escalationRule{
+		{Kind: categoryNetwork, Failures: 1, Banner: true},
+		{Kind: categoryNetwork, Failures: 5, Notify: true, Remedy: remedyClearCache},
+		{Failures: 5, Remedy: remedyPickDir},
+	}
+	for _, test := range []struct {
+		record *FailureRecord
+		want   Escalation
+	}{
+		{&FailureRecord{Kind: categoryNetwork, Count: 1}, Escalation{Banner: true}},
+		{&FailureRecord{Kind: categoryLocal, Count: 4}, Escalation{}},
+		{&FailureRecord{Kind: categoryNetwork, Count: 5}, Escalation{Notify: true, Banner: true, Remedies: []string{remedyClearCache, remedyPickDir}}},
+		{&FailureRecord{Kind: categoryLocal, Count: 5}, Escalation{Remedies: []string{remedyPickDir}}},
+	} {
+		if got := Escalate(test.record, rules); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d of %s: %+v, want %+v", test.record.Count, test.record.Kind, got, test.want)
+		}
+	}
+	if got := Escalate(&FailureRecord{Kind: categoryNetwork, Count: 100}, nil); !reflect.DeepEqual(got, Escalation{}) {
+		t.Errorf("without rules: %+v", got)
+	}
+}
+
+func TestFailureKind(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind string
+	}{
+		{&corruptArchiveError{reason: "not a zip"}, failureCorruptArchive},
+		{fmt.Errorf("downloading: %w", &checksumError{got: "0123"}), failureCorruptArchive},
+		{&truncatedError{got: 10, want: 20}, failureCorruptArchive},
+		{&truncatedFileError{path: "sodium.jar", size: 4, expected: 6}, failureCorruptArchive},
+		{&stagedArchiveError{path: "staged.zip", err: errors.New("damaged")}, failureCorruptArchive},
+		{&installerHashError{name: "installer.jar"}, failureCorruptArchive},
+		{fmt.Errorf("reading the pack: %w", zip.ErrFormat), failureCorruptArchive},
+		{zip.ErrChecksum, failureCorruptArchive},
+		{&modsDirError{dir: "mods", err: errors.New("missing")}, failurePath},
+		{&gameRootError{dir: "/", marker: "versions"}, failurePath},
+		{&statusError{status: 502}, categoryNetwork},
+		{&unknownNameError{query: "sodium"}, categoryUsage},
+		{errors.New("something else"), ErrorCategory(errors.New("something else"))},
+	}
+	for _, test := range tests {
+		if got := FailureKind(test.err); got != test.kind {
+			t.Errorf("%v is %s, want %s", test.err, got, test.kind)
+		}
+	}
+}
+
+// useFailureRecord has the failures of the test's runs recorded at p.
+func useFailureRecord(t *testing.T) string {
+	t.Helper()
+	saved := failuresPath
+	t.Cleanup(func() { failuresPath = saved })
+	p := filepath.Join(t.TempDir(), "clientUpdate-failures.json")
+	TrackFailures(p)
+	return p
+}
+
+// TestRecordFailure counts failures of a kind in a row, starting over for
+// another kind, notifies the third even with notifications off and
+// forgets them after a run gets through.
+func TestRecordFailure(t *testing.T) {
+	clock := useFakeClock(t)
+	recording := useDesktopNotifier(t)
+	notifier = noNotifier{}
+	p := useFailureRecord(t)
+	start := clock.Now()
+
+	RecordFailure(categoryNetwork, phaseDownload, "returned 502 Bad Gateway")
+	clock.Advance(24 * time.Hour)
+	RecordFailure(failureCorruptArchive, phaseDownload, "the downloaded archive is damaged")
+	for i := 0; i < 2; i++ {
+		clock.Advance(24 * time.Hour)
+		RecordFailure(failureCorruptArchive, phaseSwap, "checksum mismatch")
+	}
+	record, err := ReadFailureRecord(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &FailureRecord{Kind: failureCorruptArchive, Count: 3, Since: start.Add(24 * time.Hour), Last: clock.Now(), Phase: phaseSwap, Message: "checksum mismatch"}
+	if !reflect.DeepEqual(record, want) {
+		t.Errorf("recorded %+v, want %+v", record, want)
+	}
+	if got := recording.Messages(); len(got) != 1 || got[0] != T("failures.notify", 3, want.Since.Local().Format("2006-01-02")) {
+		t.Errorf("notified %q", got)
+	}
+
+	MarkRemedied(record, remedyClearCache)
+	RecordFailure(failureCorruptArchive, phaseSwap, "checksum mismatch")
+	if record, _ := ReadFailureRecord(p); record.Count != 4 || !record.remedied(remedyClearCache) {
+		t.Errorf("recorded %+v", record)
+	}
+
+	ResetFailures()
+	if record, err := ReadFailureRecord(p); record != nil || err != nil {
+		t.Errorf("kept %+v, %v", record, err)
+	}
+
+	// runs of other modes don't count
+	failuresPath = ""
+	RecordFailure(categoryNetwork, phaseDownload, "returned 502 Bad Gateway")
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Errorf("recorded a failure of another mode: %v", err)
+	}
+}
+
+// TestUpdateRepeatedFailures downloads a damaged pack run after run: the
+// third run clears the cache first and its failure is notified, an
+// interactive run tells of them, and the run getting through forgets them.
+func TestUpdateRepeatedFailures(t *testing.T) {
+	useRunState(t)
+	recording := useDesktopNotifier(t)
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium"})
+	damaged := filepath.Join(t.TempDir(), "pack.zip")
+	writeFiles(t, filepath.Dir(damaged), map[string]string{"pack.zip": "not a zip"})
+	good := u.server.archive.Load().(string)
+	u.server.archive.Store(damaged)
+	recordPath := filepath.Join(u.state, "clientUpdate-failures.json")
+	logPath := filepath.Join(u.state, "clientUpdate.log")
+
+	for i := 1; i <= 3; i++ {
+		if code := exitsWith(func() { u.run(t) }); code != exitLocal {
+			t.Fatalf("run %d exited %d", i, code)
+		}
+		log := readFile(t, logPath)
+		record, err := ReadFailureRecord(recordPath)
+		if err != nil || record == nil || record.Count != i || record.Kind != failureCorruptArchive {
+			t.Fatalf("run %d: recorded %+v, %v\nlog:\n%s", i, record, err, log)
+		}
+		cleared := strings.Count(log, "failures: tried "+remedyClearCache)
+		if cleared != map[bool]int{true: 1}[i == 3] {
+			t.Errorf("run %d cleared the cache %d times", i, cleared)
+		}
+		if notified := len(recording.Messages()); notified != map[bool]int{true: 1}[i == 3] {
+			t.Errorf("run %d: notified %q", i, recording.Messages())
+		}
+	}
+	record, _ := ReadFailureRecord(recordPath)
+	if !record.remedied(remedyClearCache) {
+		t.Errorf("recorded %+v", record)
+	}
+
+	// the pack is fixed, the player starts the updater
+	u.server.archive.Store(good)
+	if err := os.MkdirAll(u.state, 0755); err != nil {
+		t.Fatal(err)
+	}
+	SaveConfig(ConfFile{MCDirectory: u.mods, MCVersion: "1.20.1"}, filepath.Join(u.state, "clientUpdate.json"))
+	useStdin(t, strings.Repeat("y\n", 10))
+	output := readFile(t, runMain(t, "--portable", u.state, "--no-telemetry", "--no-tui"))
+	if !strings.Contains(output, record.Banner()) {
+		t.Errorf("no banner:\n%s", output)
+	}
+	if strings.Contains(output, T("failures.remedy.cache")) {
+		t.Errorf("cleared the cache again:\n%s", output)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+	if _, err := os.Stat(recordPath); !os.IsNotExist(err) {
+		t.Errorf("the failures are still recorded: %v", err)
+	}
+	if log := readFile(t, logPath); !strings.Contains(log, "failures: the run succeeded after 3 failures of "+failureCorruptArchive) {
+		t.Errorf("log:\n%s", log)
+	}
+}
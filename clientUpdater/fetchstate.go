@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// FetchState records the archives of a run downloaded so far, so a run
+// interrupted after fetching some of them picks up where it left off.
+type FetchState struct {
+	// Plan identifies what was being fetched, see FetchPlan.
+	Plan  string        `json:"plan"`
+	Files []FetchedFile `json:"files"`
+}
+
+// FetchedFile is an archive that was downloaded and validated.
+type FetchedFile struct {
+	Path   string `json:"path"`
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	// Pinned is set when the config gave the hash, so the file can't have
+	// changed on the server. Otherwise ETag and LastModified tell.
+	Pinned       bool   `json:"pinned,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// FetchPlan identifies the archives a run with config downloads: the pack
+// and the extra sources, where they come from and what they must hash to.
+func FetchPlan(config ConfFile, packURL string) string {
+	content, _ := json.Marshal(struct {
+		URLs      []string
+		SHA256    string
+		MCVersion string
+		Sources   []PackSource
+	}{append([]string{packURL}, config.Mirrors...), config.ArchiveSHA256, config.MCVersion, config.Sources})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Fetcher downloads the archives of a run, reusing what an interrupted run
+// with the same plan already fetched.
+type Fetcher struct {
+	statePath string
+	state     FetchState
+}
+
+// NewFetcher loads the state at statePath. The state of another plan is
+// stale, it is discarded along with the archives it lists.
+func NewFetcher(statePath string, plan string) *Fetcher {
+	f := &Fetcher{statePath: statePath, state: FetchState{Plan: plan}}
+	var state FetchState
+	if err := ReadStateFile(statePath, fetchSchema, &state); os.IsNotExist(err) {
+		return f
+	} else if err != nil {
+		Logf("fetch: unreadable state %s: %s", statePath, err)
+		RemoveStateFile(statePath)
+		return f
+	}
+	if state.Plan != plan {
+		Logf("fetch: discarding %d archives of another plan", len(state.Files))
+		for _, file := range state.Files {
+			os.Remove(file.Path)
+		}
+		RemoveStateFile(statePath)
+		return f
+	}
+	f.state = state
+	return f
+}
+
+// Fetch is FetchPack, except that the archive an interrupted run already
+// fetched to dest is used as long as it is intact and the server still has
+// the same file.
+func (f *Fetcher) Fetch(dest string, urls []string, expectedSHA256 string, mcVersion string) (*PackArchive, error) {
+	for i, file := range f.state.Files {
+		if file.Path != dest {
+			continue
+		}
+		if archive := f.reuse(file, urls, mcVersion); archive != nil {
+			return archive, nil
+		}
+		f.state.Files = append(f.state.Files[:i], f.state.Files[i+1:]...)
+		break
+	}
+	archive, err := FetchPack(dest, urls, expectedSHA256, mcVersion)
+	if err != nil {
+		return nil, err
+	}
+	download := archive.Download
+	f.state.Files = append(f.state.Files, FetchedFile{
+		Path:         dest,
+		URL:          download.URL,
+		SHA256:       download.SHA256,
+		Pinned:       expectedSHA256 != "",
+		ETag:         download.ETag,
+		LastModified: download.LastModified,
+	})
+	f.save()
+	return archive, nil
+}
+
+// reuse validates an archive fetched before, nil when it has to be
+// fetched again.
+func (f *Fetcher) reuse(file FetchedFile, urls []string, mcVersion string) *PackArchive {
+	known := false
+	for _, url := range urls {
+		known = known || url == file.URL
+	}
+	if !known {
+		Logf("fetch: %s came from %s, which is no longer a source", file.Path, file.URL)
+		return nil
+	}
+	sum, err := fileSHA256(file.Path)
+	if err != nil || !strings.EqualFold(sum, file.SHA256) {
+		Logf("fetch: %s is missing or changed since it was fetched", file.Path)
+		return nil
+	}
+	if !file.Pinned && !stillCurrent(file) {
+		Logf("fetch: %s changed on the server since it was fetched", file.URL)
+		return nil
+	}
+	archive, err := ValidateArchive(file.Path, mcVersion)
+	if err != nil {
+		Logf("fetch: %s: %s", file.Path, err)
+		return nil
+	}
+	info, err := os.Stat(file.Path)
+	if err != nil {
+		return nil
+	}
+	archive.Download = &Download{
+		URL:           file.URL,
+		Size:          info.Size(),
+		ContentLength: info.Size(),
+		SHA256:        file.SHA256,
+		ETag:          file.ETag,
+		LastModified:  file.LastModified,
+	}
+	Logf("fetch: reusing %s from %s", file.Path, file.URL)
+	fmt.Println(T("download.reused", file.Path))
+	return archive
+}
+
+// stillCurrent asks the server whether it still has the file as it was
+// downloaded. Without a validator to compare that can't be told.
+func stillCurrent(file FetchedFile) bool {
+	if file.ETag == "" && file.LastModified == "" {
+		return false
+	}
+	req, err := http.NewRequestWithContext(runCtx, "HEAD", file.URL, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := withTimeout(probeTimeout).Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	if file.ETag != "" {
+		return resp.Header.Get("ETag") == file.ETag
+	}
+	return resp.Header.Get("Last-Modified") == file.LastModified
+}
+
+// save records the archives fetched so far.
+func (f *Fetcher) save() {
+	if err := WriteStateFile(f.statePath, fetchSchema, f.state); err != nil {
+		Logf("fetch: unable to save %s: %s", f.statePath, err)
+	}
+}
+
+// DiscardFetched removes the archives the state at statePath lists along
+// with the state, whatever plan they were fetched for.
+func DiscardFetched(statePath string) {
+	var state FetchState
+	if err := ReadStateFile(statePath, fetchSchema, &state); err == nil {
+		for _, file := range state.Files {
+			os.Remove(file.Path)
+		}
+	}
+	if err := RemoveStateFile(statePath); err != nil {
+		Logf("fetch: %s", err)
+	}
+}
+
+// Done discards the state once the archives were applied.
+func (f *Fetcher) Done() {
+	if err := RemoveStateFile(f.statePath); err != nil {
+		Logf("fetch: %s", err)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFetchPlan(t *testing.T) {
+	config := ConfFile{MCVersion: "1.20.1", Sources: []PackSource{{Name: "server", URL: "https://addon.example/addon.zip"}}}
+	plan := FetchPlan(config, "https://github.com/pack.zip")
+	if FetchPlan(config, "https://github.com/pack.zip") != plan {
+		t.Error("the same plan hashes differently")
+	}
+	changed := []ConfFile{
+		{MCVersion: "1.21", Sources: config.Sources},
+		{MCVersion: "1.20.1"},
+		{MCVersion: "1.20.1", Sources: config.Sources, Mirrors: []string{"https://mirror.example/pack.zip"}},
+		{MCVersion: "1.20.1", Sources: config.Sources, ArchiveSHA256: "0123"},
+	}
+	for _, other := range changed {
+		if FetchPlan(other, "https://github.com/pack.zip") == plan {
+			t.Errorf("%+v is the same plan", other)
+		}
+	}
+	if FetchPlan(config, "https://github.com/other.zip") == plan {
+		t.Error("another pack is the same plan")
+	}
+}
+
+func TestNewFetcherDiscardsStale(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "clientUpdate-fetch.json")
+	archive := filepath.Join(dir, "pack.zip")
+	writeFiles(t, dir, map[string]string{"pack.zip": "PK"})
+	state := FetchState{Plan: "plan", Files: []FetchedFile{{Path: archive, URL: "https://github.com/pack.zip", SHA256: "0123"}}}
+	if err := WriteStateFile(statePath, fetchSchema, state); err != nil {
+		t.Fatal(err)
+	}
+	if f := NewFetcher(statePath, "plan"); len(f.state.Files) != 1 || dirNames(t, dir) != "clientUpdate-fetch.json pack.zip" {
+		t.Errorf("the same plan: %+v", f.state)
+	}
+	if f := NewFetcher(statePath, "another plan"); len(f.state.Files) != 0 || f.state.Plan != "another plan" {
+		t.Errorf("another plan: %+v", f.state)
+	}
+	if got := dirNames(t, dir); got != "" {
+		t.Errorf("left %s", got)
+	}
+
+	writeFiles(t, dir, map[string]string{"clientUpdate-fetch.json": "{"})
+	if f := NewFetcher(statePath, "plan"); len(f.state.Files) != 0 {
+		t.Errorf("unreadable: %+v", f.state)
+	}
+	if got := dirNames(t, dir); got != "" {
+		t.Errorf("left %s", got)
+	}
+}
+
+// sourcesServer serves the pack of u and the addons of a dir at
+// addon.example, counting the GET requests by path. Paths in failing are
+// not found.
+type sourcesServer struct {
+	u   *fakeUpdate
+	dir string
+
+	mu      sync.Mutex
+	gets    map[string]int
+	failing map[string]bool
+}
+
+func (s *sourcesServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	if r.Method == http.MethodGet {
+		s.gets[r.URL.Path]++
+	}
+	failing := s.failing[r.URL.Path]
+	s.mu.Unlock()
+	if r.Host != "addon.example" {
+		s.u.server.ServeHTTP(w, r)
+		return
+	}
+	if failing {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(s.dir, filepath.Base(r.URL.Path)))
+}
+
+// fetches returns the GETs of each path since the last call.
+func (s *sourcesServer) fetches() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gets := s.gets
+	s.gets = map[string]int{}
+	return gets
+}
+
+// interruptFetch runs an update of u with two sources, one.zip and
+// two.zip, which stops as two.zip isn't found: the pack and one.zip were
+// fetched by then.
+func interruptFetch(t *testing.T) (*fakeUpdate, *sourcesServer) {
+	t.Helper()
+	useRunState(t)
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": modJar(t, "sodium", "0.5")})
+	s := &sourcesServer{u: u, dir: t.TempDir(), gets: map[string]int{}, failing: map[string]bool{"/two.zip": true}}
+	useTestServer(t, s)
+	writeZip(t, filepath.Join(s.dir, "one.zip"), map[string]string{"rxmc-one-master/mods/voicechat.jar": modJar(t, "voicechat", "1")})
+	writeZip(t, filepath.Join(s.dir, "two.zip"), map[string]string{"rxmc-two-master/mods/rxmc-sync.jar": modJar(t, "rxmc-sync", "1")})
+	writeConfigSources(t, u, "one.zip", "two.zip")
+
+	if code := exitsWith(func() { u.run(t) }); code <= 0 {
+		t.Fatalf("exited with %d", code)
+	}
+	if got := s.fetches(); got[packArchiveURLPath] != 1 || got["/one.zip"] != 1 || got["/two.zip"] == 0 {
+		t.Fatalf("fetched %v", got)
+	}
+	var state FetchState
+	if err := ReadStateFile(filepath.Join(u.state, "clientUpdate-fetch.json"), fetchSchema, &state); err != nil || len(state.Files) != 2 {
+		t.Fatalf("fetch state %+v, %v", state, err)
+	}
+	s.mu.Lock()
+	s.failing = nil
+	s.mu.Unlock()
+	return u, s
+}
+
+// writeConfigSources has u's config name the sources of a sourcesServer.
+func writeConfigSources(t *testing.T, u *fakeUpdate, names ...string) {
+	t.Helper()
+	var sources []PackSource
+	for _, name := range names {
+		sources = append(sources, PackSource{Name: strings.TrimSuffix(name, ".zip"), URL: "https://addon.example/" + name})
+	}
+	if err := os.MkdirAll(u.state, 0755); err != nil {
+		t.Fatal(err)
+	}
+	SaveConfig(ConfFile{MCDirectory: u.mods, Sources: sources}, filepath.Join(u.state, "clientUpdate.json"))
+}
+
+// TestUpdateResumesFetch updates again after a run stopped fetching the
+// sources: only the source missing is downloaded.
+func TestUpdateResumesFetch(t *testing.T) {
+	u, s := interruptFetch(t)
+	output := readFile(t, u.run(t))
+	if got := s.fetches(); len(got) != 1 || got["/two.zip"] != 1 {
+		t.Errorf("fetched %v, output:\n%s", got, output)
+	}
+	if strings.Count(output, "which the interrupted update already downloaded") != 2 {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := dirNames(t, u.mods); got != "rxmc-sync.jar sodium.jar voicechat.jar" {
+		t.Errorf("installed %s", got)
+	}
+	if _, err := os.Stat(filepath.Join(u.state, "clientUpdate-fetch.json")); !os.IsNotExist(err) {
+		t.Errorf("fetch state left: %v", err)
+	}
+}
+
+// TestUpdateResumesFetchChanged resumes after a source changed on the
+// server and after the plan changed: what changed is fetched again.
+func TestUpdateResumesFetchChanged(t *testing.T) {
+	t.Run("source", func(t *testing.T) {
+		u, s := interruptFetch(t)
+		later := time.Now().Add(time.Hour)
+		if err := os.Chtimes(filepath.Join(s.dir, "one.zip"), later, later); err != nil {
+			t.Fatal(err)
+		}
+		output := readFile(t, u.run(t))
+		if got := s.fetches(); len(got) != 2 || got["/one.zip"] != 1 || got["/two.zip"] != 1 {
+			t.Errorf("fetched %v, output:\n%s", got, output)
+		}
+	})
+	t.Run("plan", func(t *testing.T) {
+		u, s := interruptFetch(t)
+		writeConfigSources(t, u, "one.zip")
+		output := readFile(t, u.run(t))
+		if got := s.fetches(); len(got) != 2 || got[packArchiveURLPath] != 1 || got["/one.zip"] != 1 {
+			t.Errorf("fetched %v, output:\n%s", got, output)
+		}
+		if strings.Contains(output, "already downloaded") {
+			t.Errorf("reused a file of another plan:\n%s", output)
+		}
+		if got := dirNames(t, u.mods); got != "sodium.jar voicechat.jar" {
+			t.Errorf("installed %s", got)
+		}
+	})
+}
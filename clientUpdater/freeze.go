@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// freezeDateLayout is how a freeze ending on a date is written, e.g.
+// "2024-11-30". It ends when that day starts.
+const freezeDateLayout = "2006-01-02"
+
+// Freeze keeps a player on the pack version they have, e.g. for testing
+// during an event while everyone else updates. Frozen runs only verify and
+// repair the mods of the last update. In the config it is true, or the
+// date or time the freeze ends by itself.
+type Freeze struct {
+	On bool
+	// Until is when the freeze ends, zero while only --unfreeze ends it.
+	Until time.Time
+}
+
+// ParseFreeze reads a freeze as given to --freeze: "true", "false", a date
+// or an RFC 3339 time.
+func ParseFreeze(s string) (Freeze, error) {
+	if on, err := strconv.ParseBool(s); err == nil {
+		return Freeze{On: on}, nil
+	}
+	if until, err := time.ParseInLocation(freezeDateLayout, s, time.Local); err == nil {
+		return Freeze{On: true, Until: until}, nil
+	}
+	until, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return Freeze{}, fmt.Errorf("freeze %q is neither true, false, a date like %s nor a time like %s", s, freezeDateLayout, time.RFC3339)
+	}
+	return Freeze{On: true, Until: until}, nil
+}
+
+// Active reports whether the freeze holds at now.
+func (f *Freeze) Active(now time.Time) bool {
+	return f != nil && f.On && (f.Until.IsZero() || now.Before(f.Until))
+}
+
+// String is the freeze as written to the config.
+func (f Freeze) String() string {
+	switch {
+	case !f.On:
+		return "false"
+	case f.Until.IsZero():
+		return "true"
+	case f.Until.Equal(time.Date(f.Until.Year(), f.Until.Month(), f.Until.Day(), 0, 0, 0, 0, time.Local)):
+		return f.Until.Format(freezeDateLayout)
+	}
+	return f.Until.Format(time.RFC3339)
+}
+
+func (f Freeze) MarshalJSON() ([]byte, error) {
+	if !f.On || f.Until.IsZero() {
+		return json.Marshal(f.On)
+	}
+	return json.Marshal(f.String())
+}
+
+func (f *Freeze) UnmarshalJSON(content []byte) error {
+	var on bool
+	if err := json.Unmarshal(content, &on); err == nil {
+		*f = Freeze{On: on}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(content, &s); err != nil {
+		return fmt.Errorf("freeze must be true, false or a date")
+	}
+	freeze, err := ParseFreeze(s)
+	if err != nil {
+		return err
+	}
+	*f = freeze
+	return nil
+}
+
+// freezeFlag is --freeze, given alone or with the date the freeze ends.
+type freezeFlag struct {
+	freeze *Freeze
+}
+
+func (f *freezeFlag) String() string {
+	if f.freeze == nil {
+		return ""
+	}
+	return f.freeze.String()
+}
+
+func (f *freezeFlag) Set(s string) error {
+	freeze, err := ParseFreeze(s)
+	if err != nil {
+		return err
+	}
+	f.freeze = &freeze
+	return nil
+}
+
+func (f *freezeFlag) IsBoolFlag() bool { return true }
+
+// FreezeState is a freeze as shown on the daemon's status page.
+type FreezeState struct {
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// freezeState returns the freeze of config if it holds at now, nil
+// otherwise.
+func freezeState(config ConfFile, now time.Time) *FreezeState {
+	if !config.Freeze.Active(now) {
+		return nil
+	}
+	state := &FreezeState{}
+	if until := config.Freeze.Until; !until.IsZero() {
+		state.Until = &until
+	}
+	return state
+}
+
+// describeFreeze tells players how long they are frozen.
+func describeFreeze(f *Freeze) string {
+	if f.Until.IsZero() {
+		return T("freeze.indefinite")
+	}
+	return T("freeze.until", f.Until.Local().Format("2006-01-02 15:04"))
+}
+
+// runFreeze freezes the player with the freeze of --freeze, saved with
+// config at jsonConfPath, or ends the freeze with unfreeze.
+func runFreeze(config ConfFile, freeze *Freeze, unfreeze bool, jsonConfPath string) error {
+	config.Freeze = freeze
+	if unfreeze || !config.Freeze.Active(clock.Now()) {
+		config.Freeze = nil
+	}
+	if err := writeConfig(config, jsonConfPath); err != nil {
+		return err
+	}
+	if config.Freeze != nil {
+		Logf("frozen: %s", config.Freeze)
+		fmt.Println(T("freeze.set", describeFreeze(config.Freeze)))
+	} else {
+		Logf("unfrozen")
+		fmt.Println(T("freeze.unset"))
+	}
+	return nil
+}
+
+// runHeld verifies and repairs the pack version installed instead of
+// updating, while hold keeps the player on it; scheduled runs just log it.
+// The pack's statistics count the run as frozen, unless noTelemetry.
+func runHeld(hold string, config ConfFile, state *InstalledState, interactive bool, noTelemetry bool, fileURL string, fileOut string, journalPath string, backupDir string, runID string) error {
+	if hold == holdPinned {
+		Logf("pinned to %s, verifying instead of updating", state.PinnedVersion)
+		if interactive {
+			fmt.Println(T("pin.active", state.PinnedVersion))
+		}
+	} else {
+		Logf("frozen (%s), verifying instead of updating", config.Freeze)
+		if interactive {
+			fmt.Println(T("freeze.active", describeFreeze(config.Freeze)))
+		}
+	}
+	if state == nil {
+		Logf("%s: nothing to verify, no update recorded", hold)
+		return nil
+	}
+	if err := unlockForUpdate(config.MCDirectory); err != nil {
+		return err
+	}
+	repaired, failed, err := Repair(state, config.MCDirectory, packURLs(state.PackCommit, fileURL, config.Mirrors), fileOut, backupDir, &Journal{Path: journalPath, Run: runID, Pack: packLabel(state.PackVersion, state.PackCommit)})
+	relockAfterUpdate(config.MCDirectory, config.LockModsDir)
+	for _, name := range repaired {
+		fmt.Println(T("repair.fixed", name))
+	}
+	PrintRepairFailed(state, failed)
+	Logf("%s: %d repaired, %d failed, error %v", hold, len(repaired), len(failed), err)
+	if state.StatsURL != "" && !noTelemetry && config.Telemetry != nil && *config.Telemetry {
+		stats := BuildUpdateStats(config.InstallID, state.PackVersion, state.MCVersion, runtime.GOOS, Version)
+		stats.Frozen = true
+		SendUpdateStats(state.StatsURL, stats)
+	}
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return Told(Failure(categoryLocal, fmt.Errorf("%d files couldn't be repaired", len(failed))))
+	}
+	SetOutcome(len(repaired) > 0, false)
+	return nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFreeze(t *testing.T) {
+	tests := map[string]Freeze{
+		"true":                      {On: true},
+		"false":                     {},
+		"2024-11-30":                {On: true, Until: time.Date(2024, 11, 30, 0, 0, 0, 0, time.Local)},
+		"2024-11-30T18:00:00+01:00": {On: true, Until: time.Date(2024, 11, 30, 17, 0, 0, 0, time.UTC)},
+	}
+	for s, want := range tests {
+		freeze, err := ParseFreeze(s)
+		if err != nil || freeze.On != want.On || !freeze.Until.Equal(want.Until) {
+			t.Errorf("ParseFreeze(%s) = %+v, %v", s, freeze, err)
+		}
+	}
+	for _, s := range []string{"", "yes please", "30.11.2024", "2024-11-31"} {
+		if freeze, err := ParseFreeze(s); err == nil {
+			t.Errorf("ParseFreeze(%q) = %+v", s, freeze)
+		}
+	}
+}
+
+// TestFreezeExpires checks a freeze ending on a date holds until that day
+// starts, and one without a date until it is ended.
+func TestFreezeExpires(t *testing.T) {
+	until, _ := ParseFreeze("2024-06-03")
+	tests := []struct {
+		freeze *Freeze
+		now    time.Time
+		active bool
+	}{
+		{&until, time.Date(2024, 6, 2, 23, 59, 0, 0, time.Local), true},
+		{&until, time.Date(2024, 6, 3, 0, 0, 0, 0, time.Local), false},
+		{&until, time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local), false},
+		{&Freeze{On: true}, time.Date(2030, 1, 1, 0, 0, 0, 0, time.Local), true},
+		{&Freeze{}, time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local), false},
+		{nil, time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local), false},
+	}
+	for _, test := range tests {
+		if got := test.freeze.Active(test.now); got != test.active {
+			t.Errorf("%v active at %s: %t", test.freeze, test.now, got)
+		}
+		var want *FreezeState
+		if test.active {
+			want = &FreezeState{}
+			if test.freeze.Until != (time.Time{}) {
+				want.Until = &test.freeze.Until
+			}
+		}
+		if got := freezeState(ConfFile{Freeze: test.freeze}, test.now); mustJSON(t, got) != mustJSON(t, want) {
+			t.Errorf("%v at %s shows as %s", test.freeze, test.now, mustJSON(t, got))
+		}
+	}
+}
+
+func TestFreezeJSON(t *testing.T) {
+	for _, s := range []string{"true", "false", `"2024-11-30"`, `"2024-11-30T17:00:00Z"`} {
+		var freeze Freeze
+		if err := json.Unmarshal([]byte(s), &freeze); err != nil {
+			t.Fatal(err)
+		}
+		if got := mustJSON(t, freeze); got != s {
+			t.Errorf("%s written back as %s", s, got)
+		}
+	}
+	for _, s := range []string{`"soon"`, `1`, `{}`} {
+		var freeze Freeze
+		if err := json.Unmarshal([]byte(s), &freeze); err == nil {
+			t.Errorf("read %s as %+v", s, freeze)
+		}
+	}
+}
+
+// TestUpdateFrozen freezes a player until a date: their runs repair the
+// mods of the last update and tell the statistics so, until the date
+// passes and the next run updates again.
+func TestUpdateFrozen(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{
+		"pack.json":       `{"version": "2024.06", "statsURL": "https://stats.example.com/report"}`,
+		"mods/sodium.jar": "sodium 1",
+	})
+	stats := &statsServer{next: u.server}
+	useTestServer(t, stats)
+	configPath := filepath.Join(u.state, "clientUpdate.json")
+	if err := os.MkdirAll(u.state, 0755); err != nil {
+		t.Fatal(err)
+	}
+	agreed := true
+	SaveConfig(ConfFile{MCDirectory: u.mods, Telemetry: &agreed}, configPath)
+	// the run's time limit is a timer on the clock moved on by days below
+	u.run(t, "--max-duration=96h")
+	stats.Posts()
+
+	output := readFile(t, u.run(t, "--freeze=2024-06-03"))
+	if !strings.Contains(output, "Frozen until 2024-06-03 00:00") {
+		t.Errorf("output:\n%s", output)
+	}
+	if config := readFile(t, configPath); !strings.Contains(config, `"freeze":"2024-06-03"`) {
+		t.Errorf("config:\n%s", config)
+	}
+
+	// the pack moved on, still shipping the sodium.jar the repair needs
+	u.setPack(t, map[string]string{
+		"pack.json":        `{"version": "2024.07", "statsURL": "https://stats.example.com/report"}`,
+		"mods/sodium.jar":  "sodium 1",
+		"mods/lithium.jar": "lithium",
+	})
+	writeFiles(t, u.mods, map[string]string{"sodium.jar": "damaged"})
+	u.clock.Advance(time.Minute)
+	output = readFile(t, runMain(t, "--portable", u.state, "--dir", u.mods, "--yes", "--no-tui", "--mc-version", "1.20.1"))
+	if got := dirNames(t, u.mods); got != "sodium.jar" || readFile(t, filepath.Join(u.mods, "sodium.jar")) != "sodium 1" {
+		t.Errorf("frozen, installed %s, output:\n%s", got, output)
+	}
+	if posts := stats.Posts(); len(posts) != 1 || !strings.Contains(posts[0], `"packVersion":"2024.06"`) || !strings.Contains(posts[0], `"frozen":true`) {
+		t.Errorf("sent %q", posts)
+	}
+
+	// the freeze ends when its day starts
+	u.clock.Advance(48 * time.Hour)
+	output = readFile(t, u.run(t))
+	if !strings.Contains(output, "The freeze ended, updating again") {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := dirNames(t, u.mods); got != "lithium.jar sodium.jar" {
+		t.Errorf("after the freeze, installed %s", got)
+	}
+	if config := readFile(t, configPath); strings.Contains(config, "freeze") {
+		t.Errorf("config:\n%s", config)
+	}
+}
+
+func TestUnfreeze(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 1"})
+	u.run(t)
+	u.run(t, "--freeze")
+	u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 2"})
+	u.run(t)
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 1" {
+		t.Fatalf("frozen, sodium.jar is %q", got)
+	}
+	if output := readFile(t, u.run(t, "--unfreeze")); !strings.Contains(output, "Not frozen anymore") {
+		t.Errorf("output:\n%s", output)
+	}
+	u.run(t)
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 2" {
+		t.Errorf("unfrozen, sodium.jar is %q", got)
+	}
+}
+
+// TestFreezeModes saves a freeze and its end to the config, a freeze whose
+// day passed already saving none.
+func TestFreezeModes(t *testing.T) {
+	clock := useFakeClock(t)
+	configPath := filepath.Join(t.TempDir(), "clientUpdate.json")
+	config := ConfFile{MCDirectory: t.TempDir()}
+	tests := []struct {
+		freeze   *Freeze
+		unfreeze bool
+		saved    string
+		output   string
+	}{
+		{&Freeze{On: true}, false, `"freeze":true`, "Frozen until you unfreeze"},
+		{&Freeze{On: true, Until: clock.Now().Add(48 * time.Hour)}, false, `"freeze":"`, "Frozen until "},
+		{&Freeze{On: true, Until: clock.Now().Add(-48 * time.Hour)}, false, "", "Not frozen anymore"},
+		{&Freeze{}, false, "", "Not frozen anymore"},
+		{nil, true, "", "Not frozen anymore"},
+	}
+	for _, test := range tests {
+		var err error
+		output := captureStdout(t, func() { err = runFreeze(config, test.freeze, test.unfreeze, configPath) })
+		if err != nil {
+			t.Fatalf("%+v: %v", test.freeze, err)
+		}
+		if !strings.Contains(output, test.output) {
+			t.Errorf("%+v: output %q", test.freeze, output)
+		}
+		saved := readFile(t, configPath)
+		if test.saved != "" && !strings.Contains(saved, test.saved) || test.saved == "" && strings.Contains(saved, `"freeze"`) {
+			t.Errorf("%+v: saved %s", test.freeze, saved)
+		}
+	}
+}
+
+// TestHeldMode repairs the mods of the last update while frozen, telling
+// the files it couldn't repair, and has nothing to verify before any update.
+func TestHeldMode(t *testing.T) {
+	useRunState(t)
+	saved := runOutcome
+	defer func() { runOutcome = saved }()
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 1"})
+	u.run(t)
+	// the update warned sodium.jar isn't a fabric mod, the repair doesn't
+	useWarnings(t)
+	state, err := ReadInstalledState(filepath.Join(u.state, "clientUpdate-installed.json"))
+	if err != nil || state == nil {
+		t.Fatalf("installed state %+v, %v", state, err)
+	}
+	root := t.TempDir()
+	config := ConfFile{MCDirectory: u.mods, Freeze: &Freeze{On: true}}
+	held := func(state *InstalledState) (output string, err error) {
+		output = captureStdout(t, func() {
+			err = runHeld(holdFrozen, config, state, true, true, "https://github.com/rx13/rxmc-Mods/archive/master.zip", filepath.Join(root, "pack.zip"), filepath.Join(root, "journal.jsonl"), filepath.Join(root, "backup"), "run")
+		})
+		return output, err
+	}
+
+	writeFiles(t, u.mods, map[string]string{"sodium.jar": "damaged"})
+	output, err := held(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 1" || runOutcome != exitOK {
+		t.Errorf("sodium.jar is %q, outcome %d", got, runOutcome)
+	}
+	if !strings.Contains(output, T("freeze.active", describeFreeze(config.Freeze))) || !strings.Contains(output, T("repair.fixed", "sodium.jar")) {
+		t.Errorf("output:\n%s", output)
+	}
+
+	// the pack doesn't ship the damaged file anymore
+	u.setPack(t, map[string]string{"mods/lithium.jar": "lithium"})
+	writeFiles(t, u.mods, map[string]string{"sodium.jar": "damaged"})
+	_, err = held(state)
+	if !errors.As(err, new(*toldError)) || ErrorCategory(err) != categoryLocal || err.Error() != "1 files couldn't be repaired" {
+		t.Errorf("not repaired: %v", err)
+	}
+
+	log := captureRunLog(t)
+	if _, err := held(nil); err != nil {
+		t.Errorf("no update recorded: %v", err)
+	}
+	if !strings.Contains(log.String(), "frozen: nothing to verify, no update recorded") {
+		t.Errorf("log:\n%s", log)
+	}
+}
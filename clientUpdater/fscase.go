@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// caseProbe reports whether file names in dir are case-sensitive, replaced
+// by tests simulating the other kind of filesystem.
+var caseProbe = probeCaseSensitivity
+
+// caseSensitivity caches what caseProbe found for each directory.
+var caseSensitivity = struct {
+	mu   sync.Mutex
+	dirs map[string]bool
+}{dirs: map[string]bool{}}
+
+// probeCaseSensitivity creates a file in a temporary folder below dir and
+// then the same name in other case. Only a case-sensitive filesystem lets
+// the second one be created.
+func probeCaseSensitivity(dir string) (bool, error) {
+	tmp, err := ioutil.TempDir(dir, ".case-probe")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(tmp)
+	for _, name := range []string{"probe", "PROBE"} {
+		f, err := os.OpenFile(filepath.Join(tmp, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, filePerm)
+		if os.IsExist(err) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		f.Close()
+	}
+	return true, nil
+}
+
+// CaseSensitive reports whether the filesystem holding dir tells file
+// names apart by case. A dir that doesn't exist yet is probed at its
+// closest existing parent. When nothing can be probed, e.g. on a read-only
+// drive, the usual filesystem of the system is assumed: case-insensitive
+// on Windows and macOS, case-sensitive elsewhere.
+func CaseSensitive(dir string) bool {
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	caseSensitivity.mu.Lock()
+	defer caseSensitivity.mu.Unlock()
+	if sensitive, ok := caseSensitivity.dirs[dir]; ok {
+		return sensitive
+	}
+	sensitive, err := caseProbe(dir)
+	if err != nil {
+		sensitive = !isWindows() && runtime.GOOS != "darwin"
+		Logf("case probe of %s failed (%s), assuming case-sensitive: %v", dir, err, sensitive)
+	}
+	caseSensitivity.dirs[dir] = sensitive
+	return sensitive
+}
+
+// FileNames compares file names the way the filesystem holding them does.
+type FileNames struct {
+	CaseSensitive bool
+}
+
+// NamesIn returns how names in dir compare.
+func NamesIn(dir string) FileNames {
+	return FileNames{CaseSensitive: CaseSensitive(dir)}
+}
+
+// Key returns what name is compared by, equal for names that are the same
+// file.
+func (n FileNames) Key(name string) string {
+	if n.CaseSensitive {
+		return name
+	}
+	return strings.ToLower(name)
+}
+
+// Same reports whether a and b are the same file.
+func (n FileNames) Same(a string, b string) bool {
+	return n.Key(a) == n.Key(b)
+}
+
+// Collisions returns the names that are different in the list but the
+// same file here, in pairs.
+func (n FileNames) Collisions(names []string) [][2]string {
+	seen := map[string]string{}
+	var collisions [][2]string
+	for _, name := range names {
+		key := n.Key(name)
+		if first, ok := seen[key]; ok && first != name {
+			collisions = append(collisions, [2]string{first, name})
+			continue
+		}
+		seen[key] = name
+	}
+	return collisions
+}
+
+// pathSet is a set of paths in one directory tree, looked up the way its
+// filesystem compares names.
+type pathSet struct {
+	names FileNames
+	paths map[string]bool
+}
+
+func newPathSet(dir string) pathSet {
+	return pathSet{names: NamesIn(dir), paths: map[string]bool{}}
+}
+
+func (s pathSet) Add(p string) {
+	s.paths[s.names.Key(p)] = true
+}
+
+func (s pathSet) Has(p string) bool {
+	return s.paths[s.names.Key(p)]
+}
+
+// checkNameCollisions refuses a pack with mod files whose names only
+// differ in case when they'd be extracted to a case-insensitive modPath,
+// one would silently replace the other.
+func checkNameCollisions(archive *PackArchive, modPath string) error {
+	names := NamesIn(modPath)
+	if names.CaseSensitive {
+		return nil
+	}
+	r, err := OpenPackArchive(archive.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	var entries []string
+	for _, f := range r.File {
+		if !f.FileInfo().IsDir() && archive.IsModEntry(f.Name) {
+			entries = append(entries, archive.ModRelPath(f.Name))
+		}
+	}
+	sort.Strings(entries)
+	if collisions := names.Collisions(entries); len(collisions) > 0 {
+		var pairs []string
+		for _, pair := range collisions {
+			pairs = append(pairs, pair[0]+" and "+pair[1])
+		}
+		return fmt.Errorf("the pack has mods whose names only differ in case, which are the same file in %s: %s", modPath, strings.Join(pairs, ", "))
+	}
+	return nil
+}
+
+// fixNameCase renames the files in modPath that are the same file as one
+// of names but in other case, so a pack renaming e.g. OptiFabric.jar to
+// optifabric.jar gets the new name even where the file is left in place.
+// A case-insensitive filesystem may ignore a rename to the same name in
+// other case, so it goes through a temporary name.
+func fixNameCase(modPath string, names []string, journal *Journal) error {
+	if len(names) == 0 {
+		return nil
+	}
+	fileNames := NamesIn(modPath)
+	if fileNames.CaseSensitive {
+		return nil
+	}
+	entries, err := os.ReadDir(modPath)
+	if err != nil {
+		return err
+	}
+	installed := map[string]string{}
+	for _, entry := range entries {
+		installed[fileNames.Key(entry.Name())] = entry.Name()
+	}
+	for _, name := range names {
+		current, ok := installed[fileNames.Key(name)]
+		if !ok || current == name {
+			continue
+		}
+		from, to := filepath.Join(modPath, current), filepath.Join(modPath, name)
+		sum, err := fileSHA256(from)
+		if err != nil {
+			return err
+		}
+		tmp := filepath.Join(modPath, name+".case"+partialSuffix)
+		if err := rename(from, tmp); err != nil {
+			return err
+		}
+		if err := rename(tmp, to); err != nil {
+			return err
+		}
+		Logf("renamed %s to %s", current, name)
+		if err := journal.Record(JournalEntry{Action: journalDelete, Path: from, SHA256: sum}); err != nil {
+			return err
+		}
+		if err := journal.Record(JournalEntry{Action: journalAdd, Path: to, SHA256: sum}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useCaseProbe has every directory probe as sensitive says, the way a
+// filesystem of that kind would, counting the probes.
+func useCaseProbe(t *testing.T, sensitive bool) *int {
+	t.Helper()
+	probes := 0
+	saved := caseProbe
+	caseProbe = func(dir string) (bool, error) {
+		probes++
+		return sensitive, nil
+	}
+	caseSensitivity.dirs = map[string]bool{}
+	t.Cleanup(func() {
+		caseProbe = saved
+		caseSensitivity.dirs = map[string]bool{}
+	})
+	return &probes
+}
+
+// sensitivities runs f for a case-sensitive filesystem and a
+// case-insensitive one.
+func sensitivities(t *testing.T, f func(t *testing.T, sensitive bool)) {
+	for _, sensitive := range []bool{true, false} {
+		name := "insensitive"
+		if sensitive {
+			name = "sensitive"
+		}
+		t.Run(name, func(t *testing.T) {
+			useCaseProbe(t, sensitive)
+			f(t, sensitive)
+		})
+	}
+}
+
+func TestProbeCaseSensitivity(t *testing.T) {
+	dir := t.TempDir()
+	// the test systems' temporary directories are case-sensitive
+	if sensitive, err := probeCaseSensitivity(dir); err != nil || !sensitive {
+		t.Errorf("probed %t, %v", sensitive, err)
+	}
+	if got := dirNames(t, dir); got != "" {
+		t.Errorf("the probe left %s", got)
+	}
+	if _, err := probeCaseSensitivity(filepath.Join(dir, "missing")); err == nil {
+		t.Error("probed a missing directory")
+	}
+}
+
+func TestCaseSensitive(t *testing.T) {
+	probes := useCaseProbe(t, false)
+	dir := t.TempDir()
+	// a directory not created yet is probed at its parent, once
+	if CaseSensitive(filepath.Join(dir, "mods", "local")) || CaseSensitive(dir) || *probes != 1 {
+		t.Errorf("probed %d times", *probes)
+	}
+
+	caseProbe = func(dir string) (bool, error) { return false, errors.New("read-only") }
+	caseSensitivity.dirs = map[string]bool{}
+	log := captureRunLog(t)
+	// on the test systems, the usual filesystem is case-sensitive
+	if !CaseSensitive(dir) {
+		t.Error("assumed case-insensitive after a failed probe")
+	}
+	if !strings.Contains(log.String(), "failed (read-only), assuming case-sensitive: true") {
+		t.Errorf("log:\n%s", log)
+	}
+}
+
+func TestFileNames(t *testing.T) {
+	sensitivities(t, func(t *testing.T, sensitive bool) {
+		names := NamesIn(t.TempDir())
+		if names.CaseSensitive != sensitive {
+			t.Fatalf("names %+v", names)
+		}
+		if names.Same("OptiFabric.jar", "optifabric.jar") == sensitive || !names.Same("sodium.jar", "sodium.jar") {
+			t.Error("compared names wrongly")
+		}
+		var got []string
+		for _, pair := range names.Collisions([]string{"OptiFabric.jar", "iris.jar", "optifabric.jar", "IRIS.JAR", "iris.jar"}) {
+			got = append(got, pair[0]+"="+pair[1])
+		}
+		want := "OptiFabric.jar=optifabric.jar iris.jar=IRIS.JAR"
+		if sensitive {
+			want = ""
+		}
+		if strings.Join(got, " ") != want {
+			t.Errorf("collisions %q, want %q", got, want)
+		}
+
+		paths := newPathSet(t.TempDir())
+		paths.Add(filepath.Join("mods", "Sodium.jar"))
+		if !paths.Has(filepath.Join("mods", "Sodium.jar")) || paths.Has(filepath.Join("mods", "sodium.jar")) != !sensitive {
+			t.Errorf("path set %v", paths.paths)
+		}
+	})
+}
+
+func TestCheckNameCollisions(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "pack.zip")
+	writeZip(t, archivePath, map[string]string{
+		"pack/mods/OptiFabric.jar": "1",
+		"pack/mods/optifabric.jar": "2",
+		"pack/mods/sodium.jar":     "sodium",
+		"pack/config/Sodium.jar":   "not a mod",
+	})
+	sensitivities(t, func(t *testing.T, sensitive bool) {
+		modPath := filepath.Join(t.TempDir(), "mods")
+		err := checkNameCollisions(&PackArchive{Path: archivePath}, modPath)
+		if sensitive && err != nil {
+			t.Error(err)
+		}
+		if !sensitive && (err == nil || !strings.HasSuffix(err.Error(), "which are the same file in "+modPath+": OptiFabric.jar and optifabric.jar")) {
+			t.Errorf("collisions: %v", err)
+		}
+	})
+}
+
+func TestFixNameCase(t *testing.T) {
+	sensitivities(t, func(t *testing.T, sensitive bool) {
+		modPath := t.TempDir()
+		writeFiles(t, modPath, map[string]string{"OptiFabric.jar": "optifabric", "sodium.jar": "sodium"})
+		journal := &Journal{Path: filepath.Join(t.TempDir(), "journal.jsonl")}
+		if err := fixNameCase(modPath, []string{"optifabric.jar", "sodium.jar", "iris.jar"}, journal); err != nil {
+			t.Fatal(err)
+		}
+		entries, _ := ReadJournal(journal.Path)
+		want, wantEntries := "optifabric.jar sodium.jar", 2
+		if sensitive {
+			want, wantEntries = "OptiFabric.jar sodium.jar", 0
+		}
+		if got := dirNames(t, modPath); got != want || len(entries) != wantEntries {
+			t.Errorf("renamed to %s, journal %+v", got, entries)
+		}
+	})
+}
+
+// TestUpdateRenamesCase updates to a pack renaming OptiFabric.jar to
+// optifabric.jar, content unchanged, in low-write mode, which leaves
+// matching files in place: on the case-sensitive filesystem of the tests
+// the old name is removed rather than kept next to the new one.
+func TestUpdateRenamesCase(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/OptiFabric.jar": "optifabric", "mods/sodium.jar": "sodium"})
+	u.run(t, "--low-write")
+	u.setPack(t, map[string]string{"mods/optifabric.jar": "optifabric", "mods/sodium.jar": "sodium"})
+	output := readFile(t, u.run(t, "--low-write"))
+	if got := dirNames(t, u.mods); got != "optifabric.jar sodium.jar" {
+		t.Errorf("installed %s, output:\n%s", got, output)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "optifabric.jar")); got != "optifabric" {
+		t.Errorf("optifabric.jar is %q", got)
+	}
+}
+
+// TestUpdateRefusesCaseCollisions checks a pack shipping two files that
+// would be one on a case-insensitive filesystem isn't installed there.
+func TestUpdateRefusesCaseCollisions(t *testing.T) {
+	useRunState(t)
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium"})
+	u.run(t)
+	useCaseProbe(t, false)
+	u.setPack(t, map[string]string{"mods/OptiFabric.jar": "1", "mods/optifabric.jar": "2", "mods/sodium.jar": "sodium 2"})
+	var output string
+	if code := exitsWith(func() { output = readFile(t, u.run(t)) }); code <= 0 {
+		t.Errorf("exited with %d", code)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium" {
+		t.Errorf("sodium.jar is %q, output:\n%s", got, output)
+	}
+	if _, err := os.Stat(filepath.Join(u.mods, "OptiFabric.jar")); !os.IsNotExist(err) {
+		t.Errorf("OptiFabric.jar installed: %v", err)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// InstalledState is what the last update left in the mods directory. It is
+// written after every update that changed it and is the basis for
+// exporting an instance.
+type InstalledState struct {
+	// PackVersion is the version from the pack manifest, PackCommit the
+	// commit of the pack repository the archive was built from.
+	PackVersion   string `json:"packVersion,omitempty"`
+	PackCommit    string `json:"packCommit,omitempty"`
+	MCVersion     string `json:"mcVersion"`
+	Loader        string `json:"loader"`
+	LoaderVersion string `json:"loaderVersion,omitempty"`
+	// MinecraftPath is the minecraft directory the update resolved for the
+	// mods directory, see ResolveMinecraftRoot.
+	MinecraftPath string          `json:"minecraftPath,omitempty"`
+	Files         []InstalledFile `json:"files"`
+	// UpdatedAt is when the state last changed.
+	UpdatedAt time.Time `json:"updatedAt"`
+	// StatsURL is where the pack wants its statistics, so runs that don't
+	// read the pack, e.g. frozen ones, can send them too.
+	StatsURL string `json:"statsURL,omitempty"`
+	// Layout is the mods layout of the pack, empty for flat. With the
+	// preserve layout Files also lists the files in subfolders, by their
+	// slash separated path.
+	Layout string `json:"layout,omitempty"`
+	// PinnedVersion is the pack version "repair --pack-version" put in
+	// place, which normal runs keep until --unpin, see UpdateHold.
+	PinnedVersion string `json:"pinnedVersion,omitempty"`
+	// SkippedConfigs are the pack's files outside the mods directory the
+	// update left alone, declined or skipped with --skip-configs, by their
+	// slash separated path below the minecraft directory. They were never
+	// applied and are offered again by the next update.
+	SkippedConfigs []string `json:"skippedConfigs,omitempty"`
+}
+
+// InstalledFile is one file of the mods directory.
+type InstalledFile struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	// PackPath is where the file is found in the pack repository, empty
+	// for files that didn't come from the pack.
+	PackPath string `json:"packPath,omitempty"`
+	// Source is the pack source the file came from when several are
+	// merged.
+	Source string `json:"source,omitempty"`
+	// External is set for a mod the pack needs but can't ship: "download"
+	// when the updater downloads it from URL, "user" when the player
+	// provides it from the page at URL.
+	External string `json:"external,omitempty"`
+	URL      string `json:"url,omitempty"`
+	// Assumed is set for files of a state rebuilt from the mods directory,
+	// where nothing told where they came from.
+	Assumed bool `json:"assumed,omitempty"`
+	// Provenance is where the updater got the file from, nil for the
+	// player's own files.
+	Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// ScanInstalledFiles lists the files directly inside modPath with their
+// hashes, sorted by name.
+func ScanInstalledFiles(modPath string) ([]InstalledFile, error) {
+	entries, err := os.ReadDir(modPath)
+	if err != nil {
+		return nil, err
+	}
+	var files []InstalledFile
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		sum, err := fileSHA256(filepath.Join(modPath, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, InstalledFile{Name: entry.Name(), SHA256: sum, Size: info.Size()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// ScanInstalledTree is ScanInstalledFiles including the files in
+// subfolders, named by their slash separated path below modPath. The
+// player's own folder is left out.
+func ScanInstalledTree(modPath string) ([]InstalledFile, error) {
+	var files []InstalledFile
+	err := filepath.WalkDir(modPath, func(p string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.IsDir() && p == filepath.Join(modPath, localModsDir) {
+			return filepath.SkipDir
+		}
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(modPath, p)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		sum, err := fileSHA256(p)
+		if err != nil {
+			return err
+		}
+		files = append(files, InstalledFile{Name: filepath.ToSlash(rel), SHA256: sum, Size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// ReadInstalledState returns the state recorded at p, or nil when nothing
+// was recorded yet. A damaged state is restored from an earlier
+// generation, see ReadStateFile.
+func ReadInstalledState(p string) (*InstalledState, error) {
+	var state InstalledState
+	err := ReadStateFile(p, installedSchema, &state)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// WriteInstalledState records state at p.
+func WriteInstalledState(p string, state *InstalledState) error {
+	return WriteStateFile(p, installedSchema, state)
+}
+
+// RebuildInstalledState replaces the state at p, when neither it nor an
+// earlier generation can be read, with one made from modPath as it is.
+// Nothing tells which files came from the pack, so every one is recorded
+// as assumed; the next update records the state properly again. It
+// reports whether it rebuilt the state.
+func RebuildInstalledState(p string, modPath string, mcVersion string, loader string) (bool, error) {
+	_, err := ReadInstalledState(p)
+	if _, damaged := err.(*StateFileError); !damaged {
+		return false, nil
+	}
+	files, err := ScanInstalledFiles(modPath)
+	if err != nil {
+		return false, err
+	}
+	for i := range files {
+		files[i].Assumed = true
+	}
+	state := &InstalledState{MCVersion: mcVersion, Loader: loader, Files: files, UpdatedAt: clock.Now()}
+	if err := WriteInstalledState(p, state); err != nil {
+		return false, err
+	}
+	Logf("state: rebuilt %s from %d files of %s", p, len(files), modPath)
+	return true, nil
+}
+
+// packLabel names a pack release for people: its version, or its commit
+// for packs without one.
+func packLabel(version string, commit string) string {
+	if version != "" {
+		return version
+	}
+	return shortHash(commit)
+}
+
+// ReconcileInstalledState drops the files of the state recorded at p that
+// no longer match modPath, after a rollback put other ones there, so
+// repairs and pre-launch checks don't undo it. The pack commit is forgotten
+// too: the next update installs the pack again unless frozen.
+func ReconcileInstalledState(p string, modPath string) error {
+	state, err := ReadInstalledState(p)
+	if err != nil || state == nil {
+		return err
+	}
+	changed := map[string]bool{}
+	for _, file := range VerifyInstalled(state, modPath) {
+		changed[file.Name] = true
+	}
+	var files []InstalledFile
+	for _, file := range state.Files {
+		if !changed[file.Name] {
+			files = append(files, file)
+		}
+	}
+	state.Files = files
+	state.PackCommit = ""
+	state.UpdatedAt = clock.Now()
+	return WriteInstalledState(p, state)
+}
+
+// RecordInstalledState scans the mods directory after an update and records
+// it together with the pack and loader it came from, and the configs the
+// update skipped.
+func RecordInstalledState(p string, archive *PackArchive, loader Loader, modPath string, minecraftPath string, mcVersion string, skippedConfigs []string) error {
+	scan := ScanInstalledFiles
+	if archive.Manifest.layout() == layoutPreserve {
+		scan = ScanInstalledTree
+	}
+	files, err := scan(modPath)
+	if err != nil {
+		return err
+	}
+	if err := setPackPaths(files, archive); err != nil {
+		return err
+	}
+	previous, err := ReadInstalledState(p)
+	if err != nil {
+		previous = nil
+	}
+	setProvenance(files, archive, previous, clock.Now())
+	state := &InstalledState{
+		PackCommit:    archive.Commit,
+		MCVersion:     mcVersion,
+		Loader:        loader.Name(),
+		LoaderVersion: InstalledLoaderVersion(loader, filepath.Join(minecraftPath, "versions"), mcVersion),
+		MinecraftPath: minecraftPath,
+		Files:         files,
+		UpdatedAt:     clock.Now(),
+
+		SkippedConfigs: skippedConfigs,
+	}
+	if archive.Manifest != nil {
+		state.PackVersion = archive.Manifest.Version
+		state.StatsURL = archive.Manifest.StatsURL
+	}
+	if layout := archive.Manifest.layout(); layout != layoutFlat {
+		state.Layout = layout
+	}
+	// nothing is rewritten when only the time would change
+	if previous != nil {
+		previous.UpdatedAt = state.UpdatedAt
+		if reflect.DeepEqual(previous, state) {
+			return nil
+		}
+	}
+	return WriteInstalledState(p, state)
+}
+
+// setPackPaths fills in where each file came from in the pack.
+func setPackPaths(files []InstalledFile, archive *PackArchive) error {
+	r, err := OpenPackArchive(archive.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	paths := map[string]string{}
+	for _, f := range r.File {
+		if !f.FileInfo().IsDir() && archive.IsModEntry(f.Name) {
+			paths[archive.ModRelPath(f.Name)] = PackPath(f.Name)
+		}
+	}
+	for i := range files {
+		files[i].PackPath = paths[files[i].Name]
+		files[i].Source = archive.Sources[path.Base(files[i].Name)]
+		// files of other sources can't be fetched from the pack
+		// repository
+		if files[i].Source != "" && files[i].Source != primarySource {
+			files[i].PackPath = ""
+		}
+	}
+	if archive.Manifest == nil {
+		return nil
+	}
+	for _, mod := range archive.Manifest.External {
+		for i := range files {
+			if strings.EqualFold(files[i].SHA256, mod.SHA256) || files[i].Name == mod.File && mod.UserProvided() {
+				files[i].External = mod.kind()
+				files[i].URL = mod.URL
+				if mod.UserProvided() {
+					files[i].URL = mod.Page
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// VerifyInstalled returns the recorded files that are missing from modPath
+// or no longer match their recorded hash. Protected files are the player's
+// to change and never count. A file no longer of its recorded size is
+// damaged without hashing it.
+func VerifyInstalled(state *InstalledState, modPath string) []InstalledFile {
+	protected, err := ScanProtected(modPath)
+	if err != nil {
+		Logf("verify: looking for protected files: %s", err)
+	}
+	paths := protectedPaths(modPath, protected)
+	var damaged []InstalledFile
+	for _, file := range state.Files {
+		if paths.Has(filepath.Join(modPath, file.Name)) {
+			continue
+		}
+		if !sameContent(filepath.Join(modPath, file.Name), file) {
+			damaged = append(damaged, file)
+		}
+	}
+	return damaged
+}
+
+// sameContent reports whether the file at p is file as recorded, checking
+// its size before hashing it. Files recorded without a size, e.g. external
+// mods the manifest only gives the hash of, are always hashed.
+func sameContent(p string, file InstalledFile) bool {
+	info, err := os.Stat(p)
+	if err != nil || info.IsDir() || file.Size != 0 && info.Size() != file.Size {
+		return false
+	}
+	sum, err := fileSHA256(p)
+	return err == nil && strings.EqualFold(sum, file.SHA256)
+}
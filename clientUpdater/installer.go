@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// knownInstallerSHA256 are the hashes of installers known without asking
+// their publisher, by jar name. Installers published with a hash next to
+// them need no entry, new versions are verified against that.
+var knownInstallerSHA256 = map[string]string{
+	bundledFabricInstaller: bundledFabricSHA256,
+}
+
+// allowUnverifiedInstaller lets installers run whose hash isn't published
+// nor known, set by --allow-unverified-installer.
+var allowUnverifiedInstaller bool
+
+// InstallerJar is an installer to run: downloaded from URL, or built into
+// the updater as Bundled.
+type InstallerJar struct {
+	Name string
+	URL  string
+	// SHA256 is the hash published for the jar, empty when there is none.
+	SHA256  string
+	Bundled []byte
+}
+
+// unverifiedInstallerError is an installer whose hash can't be known, run
+// only with --allow-unverified-installer.
+type unverifiedInstallerError struct {
+	name string
+}
+
+func (e *unverifiedInstallerError) Error() string {
+	return fmt.Sprintf("no SHA-256 is published or known for %s, so it can't be verified and wasn't run; run the update again with --allow-unverified-installer to run it anyway", e.name)
+}
+
+func (e *unverifiedInstallerError) Category() string { return categoryLocal }
+
+// installerHashError is an installer that still doesn't match its hash
+// after downloading it again.
+type installerHashError struct {
+	name     string
+	expected string
+	actual   string
+}
+
+func (e *installerHashError) Error() string {
+	return fmt.Sprintf("%s has SHA-256 %s, expected %s, even after downloading it again; it wasn't run", e.name, e.actual, e.expected)
+}
+
+func (e *installerHashError) Category() string { return categoryNetwork }
+
+// RunInstaller runs jar with java once it matches its expected hash: the
+// published one, else the one in knownInstallerSHA256. The hash is checked
+// right before every run, a cached copy is never trusted as it is. A copy
+// that doesn't match is thrown away and fetched once more.
+func (c *Cache) RunInstaller(jar InstallerJar, args ...string) error {
+	expected := strings.ToLower(jar.SHA256)
+	if expected == "" {
+		expected = knownInstallerSHA256[jar.Name]
+	}
+	if expected == "" {
+		if !allowUnverifiedInstaller {
+			return &unverifiedInstallerError{name: jar.Name}
+		}
+		Logf("installer: %s can't be verified, running it as --allow-unverified-installer says", jar.Name)
+	}
+	for attempt := 1; ; attempt++ {
+		p, err := c.fetchInstaller(jar, expected)
+		var checksum *checksumError
+		if err != nil && !errors.As(err, &checksum) {
+			return err
+		}
+		var actual string
+		if err == nil {
+			if actual, err = fileSHA256(p); err != nil {
+				return err
+			}
+			if expected == "" || actual == expected {
+				Logf("installer: running %s, SHA-256 %s", jar.Name, actual)
+				return runInstaller(p, args...)
+			}
+		} else {
+			actual = checksum.got
+		}
+		Logf("installer: %s has SHA-256 %s, expected %s", jar.Name, actual, expected)
+		c.dropInstaller(jar.Name)
+		if attempt == 2 || jar.Bundled != nil {
+			return &installerHashError{name: jar.Name, expected: expected, actual: actual}
+		}
+	}
+}
+
+// fetchInstaller returns the path of jar in the cache, expected being its
+// hash or empty when unknown.
+func (c *Cache) fetchInstaller(jar InstallerJar, expected string) (string, error) {
+	if jar.Bundled != nil {
+		return c.Bundled(jar.Name, jar.Bundled, expected)
+	}
+	return c.Installer(jar.Name, jar.URL, expected)
+}
+
+// dropInstaller removes the cached installer name and its recorded hash.
+func (c *Cache) dropInstaller(name string) {
+	defer c.lock("installers/" + name)()
+	if p, err := c.path("installers", name); err == nil {
+		os.Remove(p)
+		os.Remove(p + ".sha256")
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// installerServer serves installer jars and what is published about them,
+// by path on any host, counting the requests.
+type installerServer struct {
+	mu    sync.Mutex
+	files map[string]string
+	hits  map[string]int
+	next  http.Handler
+}
+
+func newInstallerServer(files map[string]string) *installerServer {
+	return &installerServer{files: files, hits: map[string]int{}, next: http.NotFoundHandler()}
+}
+
+func (s *installerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	content, ok := s.files[r.URL.Path]
+	if ok {
+		s.hits[r.URL.Path]++
+	}
+	s.mu.Unlock()
+	if !ok {
+		s.next.ServeHTTP(w, r)
+		return
+	}
+	w.Write([]byte(content))
+}
+
+func (s *installerServer) Hits(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[path]
+}
+
+// installerRun returns the arguments java was last run with, nil when it
+// wasn't run.
+func installerRun(t *testing.T, record string) []string {
+	t.Helper()
+	content, err := os.ReadFile(record)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	var args []string
+	if err := json.Unmarshal(content, &args); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(record)
+	return args
+}
+
+func useAllowUnverifiedInstaller(t *testing.T, allow bool) {
+	saved := allowUnverifiedInstaller
+	allowUnverifiedInstaller = allow
+	t.Cleanup(func() { allowUnverifiedInstaller = saved })
+}
+
+// TestRunInstaller runs installers published with their hash: one matching
+// it, a cached copy tampered with since and a server sending another jar.
+func TestRunInstaller(t *testing.T) {
+	useFakeClock(t)
+	record := useFakeJava(t)
+	s := newInstallerServer(map[string]string{"/installer.jar": "installer", "/other.jar": "other"})
+	useTestServer(t, s)
+	cache := &Cache{Dir: t.TempDir()}
+	jar := InstallerJar{Name: "installer.jar", URL: "https://maven.example.com/installer.jar", SHA256: strings.ToUpper(sha256Hex([]byte("installer")))}
+	p := filepath.Join(cache.Dir, "installers", "installer.jar")
+
+	if err := cache.RunInstaller(jar, "client"); err != nil {
+		t.Fatal(err)
+	}
+	if args := installerRun(t, record); strings.Join(args, " ") != "-jar "+p+" client" {
+		t.Errorf("ran java with %q", args)
+	}
+
+	// the cached copy is checked before every run
+	if err := cache.RunInstaller(jar, "client"); err != nil || installerRun(t, record) == nil || s.Hits("/installer.jar") != 1 {
+		t.Errorf("ran the cached copy: %v, downloaded %d times", err, s.Hits("/installer.jar"))
+	}
+	if err := os.WriteFile(p, []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.RunInstaller(jar, "client"); err != nil || installerRun(t, record) == nil {
+		t.Errorf("tampered copy: %v", err)
+	}
+	if s.Hits("/installer.jar") != 2 || readFile(t, p) != "installer" {
+		t.Errorf("downloaded %d times, cached %q", s.Hits("/installer.jar"), readFile(t, p))
+	}
+
+	// a server sending something else is asked twice, then given up on
+	wrong := InstallerJar{Name: "wrong.jar", URL: "https://maven.example.com/other.jar", SHA256: jar.SHA256}
+	err := cache.RunInstaller(wrong, "client")
+	if _, ok := err.(*installerHashError); !ok || ErrorCategory(err) != categoryNetwork {
+		t.Fatalf("failed with %v", err)
+	}
+	if !strings.Contains(err.Error(), "wrong.jar has SHA-256 "+sha256Hex([]byte("other"))+", expected "+sha256Hex([]byte("installer"))) {
+		t.Errorf("failed with %v", err)
+	}
+	if args := installerRun(t, record); args != nil {
+		t.Errorf("ran java with %q", args)
+	}
+	if s.Hits("/other.jar") != 2 {
+		t.Errorf("downloaded %d times", s.Hits("/other.jar"))
+	}
+	if got := dirNames(t, filepath.Join(cache.Dir, "installers")); got != "installer.jar installer.jar.sha256" {
+		t.Errorf("cached %s", got)
+	}
+}
+
+// TestRunInstallerUnverified runs an installer nothing is published or
+// known about, refused without --allow-unverified-installer.
+func TestRunInstallerUnverified(t *testing.T) {
+	useFakeClock(t)
+	record := useFakeJava(t)
+	s := newInstallerServer(map[string]string{"/installer.jar": "installer"})
+	useTestServer(t, s)
+	cache := &Cache{Dir: t.TempDir()}
+	jar := InstallerJar{Name: "installer.jar", URL: "https://maven.example.com/installer.jar"}
+
+	useAllowUnverifiedInstaller(t, false)
+	err := cache.RunInstaller(jar, "client")
+	if _, ok := err.(*unverifiedInstallerError); !ok || ErrorCategory(err) != categoryLocal || !strings.Contains(err.Error(), "--allow-unverified-installer") {
+		t.Fatalf("failed with %v", err)
+	}
+	if installerRun(t, record) != nil || s.Hits("/installer.jar") != 0 {
+		t.Errorf("ran it, downloaded %d times", s.Hits("/installer.jar"))
+	}
+
+	useAllowUnverifiedInstaller(t, true)
+	log := captureRunLog(t)
+	if err := cache.RunInstaller(jar, "client"); err != nil || installerRun(t, record) == nil {
+		t.Errorf("allowed: %v", err)
+	}
+	if !strings.Contains(log.String(), "installer: installer.jar can't be verified, running it as --allow-unverified-installer says") {
+		t.Errorf("log:\n%s", log)
+	}
+}
+
+// TestRunInstallerKnown runs installers without a published hash that are
+// known: the bundled one and one added to the table.
+func TestRunInstallerKnown(t *testing.T) {
+	useFakeClock(t)
+	record := useFakeJava(t)
+	s := newInstallerServer(map[string]string{"/known.jar": "known"})
+	useTestServer(t, s)
+	cache := &Cache{Dir: t.TempDir()}
+
+	if err := cache.RunInstaller(bundledFabricInstallerJar, "client"); err != nil || installerRun(t, record) == nil {
+		t.Errorf("bundled: %v", err)
+	}
+
+	useAllowUnverifiedInstaller(t, false)
+	knownInstallerSHA256["known.jar"] = sha256Hex([]byte("known"))
+	defer delete(knownInstallerSHA256, "known.jar")
+	if err := cache.RunInstaller(InstallerJar{Name: "known.jar", URL: "https://maven.example.com/known.jar"}, "client"); err != nil || installerRun(t, record) == nil {
+		t.Errorf("known: %v", err)
+	}
+	knownInstallerSHA256["known.jar"] = sha256Hex([]byte("another release"))
+	if _, ok := cache.RunInstaller(InstallerJar{Name: "known.jar", URL: "https://maven.example.com/known.jar"}, "client").(*installerHashError); !ok || installerRun(t, record) != nil {
+		t.Error("ran a jar other than the known one")
+	}
+}
+
+// fabricInstallerFiles are the Fabric meta API listing a new installer
+// release and that release, with its hash published next to it unless
+// published is false.
+func fabricInstallerFiles(published bool) map[string]string {
+	files := map[string]string{
+		"/v2/versions/installer": `[{"url": "https://maven.fabricmc.net/net/fabricmc/fabric-installer/9.9.9/fabric-installer-9.9.9.jar", "version": "9.9.9", "stable": true}, {"url": "https://maven.fabricmc.net/old.jar", "version": "1.0.0", "stable": true}]`,
+		"/net/fabricmc/fabric-installer/9.9.9/fabric-installer-9.9.9.jar": "installer 9.9.9",
+	}
+	if published {
+		files["/net/fabricmc/fabric-installer/9.9.9/fabric-installer-9.9.9.jar.sha256"] = sha256Hex([]byte("installer 9.9.9")) + "  fabric-installer-9.9.9.jar\n"
+	}
+	return files
+}
+
+// TestFabricInstallerFromMeta installs Fabric with the newest installer
+// the meta API lists, verified against the hash published next to it
+// without knowing the release, and with the bundled one while the meta API
+// is down.
+func TestFabricInstallerFromMeta(t *testing.T) {
+	useFakeClock(t)
+	record := useFakeJava(t)
+	useAllowUnverifiedInstaller(t, false)
+	minecraft := t.TempDir()
+
+	s := newInstallerServer(fabricInstallerFiles(true))
+	useTestServer(t, s)
+	cache := &Cache{Dir: t.TempDir()}
+	if err := (fabricLoader{cache: cache}).Install(minecraft, "1.20.1", "0.16.5"); err != nil {
+		t.Fatal(err)
+	}
+	if args := installerRun(t, record); len(args) < 2 || filepath.Base(args[1]) != "fabric-installer-9.9.9.jar" {
+		t.Errorf("ran java with %q", args)
+	}
+
+	useTestServer(t, http.NotFoundHandler())
+	cache = &Cache{Dir: t.TempDir()}
+	if err := (fabricLoader{cache: cache}).Install(minecraft, "1.20.1", "0.16.5"); err != nil {
+		t.Fatal(err)
+	}
+	if args := installerRun(t, record); len(args) < 2 || filepath.Base(args[1]) != bundledFabricInstaller {
+		t.Errorf("ran java with %q", args)
+	}
+}
+
+// TestUpdateUnverifiedInstaller updates a player needing Quilt, whose
+// installer is published without a hash: the mods are updated without
+// running it, unless --allow-unverified-installer is given.
+func TestUpdateUnverifiedInstaller(t *testing.T) {
+	record := useFakeJava(t)
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium"})
+	if err := os.MkdirAll(u.state, 0755); err != nil {
+		t.Fatal(err)
+	}
+	SaveConfig(ConfFile{MCDirectory: u.mods, MCVersion: "1.20.1", Loader: "quilt"}, filepath.Join(u.state, "clientUpdate.json"))
+	s := newInstallerServer(map[string]string{"/repository/release/org/quiltmc/quilt-installer/latest/quilt-installer-latest.jar": "quilt installer"})
+	s.next = u.server
+	useTestServer(t, s)
+	useAllowUnverifiedInstaller(t, false)
+	logPath := filepath.Join(u.state, "clientUpdate.log")
+
+	u.run(t)
+	if args := installerRun(t, record); args != nil {
+		t.Errorf("ran java with %q", args)
+	}
+	if log := readFile(t, logPath); !strings.Contains(log, "no SHA-256 is published or known for quilt-installer-latest.jar") {
+		t.Errorf("log:\n%s", log)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+
+	u.run(t, "--allow-unverified-installer")
+	if args := installerRun(t, record); len(args) < 2 || filepath.Base(args[1]) != "quilt-installer-latest.jar" {
+		t.Errorf("ran java with %q", args)
+	}
+	if log := readFile(t, logPath); !strings.Contains(log, "installer: quilt-installer-latest.jar can't be verified, running it as --allow-unverified-installer says") {
+		t.Errorf("log:\n%s", log)
+	}
+}
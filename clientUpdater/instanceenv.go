@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// LauncherInstance is the MultiMC or Prism Launcher instance the updater
+// runs for as its pre-launch command, told by the variables the launcher
+// sets for the command.
+type LauncherInstance struct {
+	// ID is $INST_ID, the name of the instance's folder.
+	ID string
+	// MCDir is $INST_MC_DIR, the instance's minecraft directory.
+	MCDir string
+	// Java is $INST_JAVA, the java the instance runs, empty when unset.
+	Java string
+	// MCVersion is the Minecraft version of the instance, empty when it
+	// can't be told.
+	MCVersion string
+}
+
+// LauncherInstanceFromEnv returns the instance the launcher's variables
+// describe, nil when the updater doesn't run as a pre-launch command. The
+// Minecraft version is $INST_MC_VERSION when set, else read from the
+// instance's mmc-pack.json.
+func LauncherInstanceFromEnv(getenv func(string) string) *LauncherInstance {
+	dir := getenv("INST_MC_DIR")
+	if dir == "" {
+		return nil
+	}
+	instance := &LauncherInstance{
+		ID:        getenv("INST_ID"),
+		MCDir:     filepath.Clean(dir),
+		Java:      getenv("INST_JAVA"),
+		MCVersion: getenv("INST_MC_VERSION"),
+	}
+	if instance.MCVersion == "" {
+		instanceDir := getenv("INST_DIR")
+		if instanceDir == "" {
+			instanceDir = filepath.Dir(instance.MCDir)
+		}
+		instance.MCVersion = instanceMCVersion(instanceDir)
+	}
+	return instance
+}
+
+// instanceMCVersion reads the Minecraft version from the mmc-pack.json of
+// the instance in dir, "" when it can't.
+func instanceMCVersion(dir string) string {
+	content, err := ioutil.ReadFile(filepath.Join(dir, "mmc-pack.json"))
+	if err != nil {
+		return ""
+	}
+	var pack mmcPack
+	if err := json.Unmarshal(content, &pack); err != nil {
+		return ""
+	}
+	for _, component := range pack.Components {
+		if component.UID != "net.minecraft" {
+			continue
+		}
+		if component.Version != "" {
+			return component.Version
+		}
+		return component.CachedVersion
+	}
+	return ""
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLauncherInstanceFromEnv(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"MultiMC/mmc-pack.json":     `{"components": [{"uid": "org.lwjgl3", "version": "3.3.1"}, {"uid": "net.minecraft", "version": "1.20.1", "important": true}]}`,
+		"Prism/mmc-pack.json":       `{"components": [{"uid": "net.minecraft", "cachedVersion": "1.20.4"}, {"uid": "net.fabricmc.fabric-loader", "version": "0.15.11"}]}`,
+		"Broken/mmc-pack.json":      `{"components": `,
+		"Elsewhere/mmc-pack.json":   `{"components": [{"uid": "net.minecraft", "version": "1.19.2"}]}`,
+		"NoMinecraft/mmc-pack.json": `{"components": [{"uid": "org.lwjgl3", "version": "3.3.1"}]}`,
+	})
+	tests := []struct {
+		name string
+		env  map[string]string
+		want *LauncherInstance
+	}{
+		{name: "not a launcher", env: map[string]string{"INST_ID": "MultiMC", "INST_JAVA": "java"}},
+		{
+			name: "multimc",
+			env:  map[string]string{"INST_MC_DIR": filepath.Join(root, "MultiMC", ".minecraft") + string(filepath.Separator), "INST_ID": "MultiMC", "INST_JAVA": "/usr/lib/jvm/java-17/bin/java"},
+			want: &LauncherInstance{ID: "MultiMC", MCDir: filepath.Join(root, "MultiMC", ".minecraft"), Java: "/usr/lib/jvm/java-17/bin/java", MCVersion: "1.20.1"},
+		},
+		{
+			name: "prism",
+			env:  map[string]string{"INST_MC_DIR": filepath.Join(root, "Prism", "minecraft"), "INST_ID": "Prism"},
+			want: &LauncherInstance{ID: "Prism", MCDir: filepath.Join(root, "Prism", "minecraft"), MCVersion: "1.20.4"},
+		},
+		{
+			name: "version set",
+			env:  map[string]string{"INST_MC_DIR": filepath.Join(root, "Prism", "minecraft"), "INST_MC_VERSION": "1.21"},
+			want: &LauncherInstance{MCDir: filepath.Join(root, "Prism", "minecraft"), MCVersion: "1.21"},
+		},
+		{
+			name: "instance directory set",
+			env:  map[string]string{"INST_MC_DIR": filepath.Join(root, "Prism", "minecraft"), "INST_DIR": filepath.Join(root, "Elsewhere")},
+			want: &LauncherInstance{MCDir: filepath.Join(root, "Prism", "minecraft"), MCVersion: "1.19.2"},
+		},
+		{
+			name: "broken pack",
+			env:  map[string]string{"INST_MC_DIR": filepath.Join(root, "Broken", ".minecraft")},
+			want: &LauncherInstance{MCDir: filepath.Join(root, "Broken", ".minecraft")},
+		},
+		{
+			name: "no minecraft",
+			env:  map[string]string{"INST_MC_DIR": filepath.Join(root, "NoMinecraft", ".minecraft")},
+			want: &LauncherInstance{MCDir: filepath.Join(root, "NoMinecraft", ".minecraft")},
+		},
+		{
+			name: "no pack",
+			env:  map[string]string{"INST_MC_DIR": filepath.Join(root, "Missing", ".minecraft")},
+			want: &LauncherInstance{MCDir: filepath.Join(root, "Missing", ".minecraft")},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := LauncherInstanceFromEnv(func(name string) string { return test.env[name] })
+			if got == nil || test.want == nil {
+				if got != test.want {
+					t.Errorf("instance %+v, want %+v", got, test.want)
+				}
+				return
+			}
+			if *got != *test.want {
+				t.Errorf("instance %+v, want %+v", *got, *test.want)
+			}
+		})
+	}
+}
+
+// useLauncherInstance runs the updater as the pre-launch command of the
+// instance holding the minecraft directory of u, with java as the
+// instance's java.
+func useLauncherInstance(t *testing.T, u *fakeUpdate, java string) {
+	t.Helper()
+	t.Setenv("INST_MC_DIR", u.minecraft)
+	t.Setenv("INST_ID", filepath.Base(filepath.Dir(u.minecraft)))
+	t.Setenv("INST_JAVA", java)
+	t.Setenv("INST_DIR", "")
+	t.Setenv("INST_MC_VERSION", "")
+	saved := javaCommand
+	t.Cleanup(func() { javaCommand = saved })
+}
+
+// TestLauncherInstanceEndToEnd runs the updater as the pre-launch command
+// of a Prism Launcher instance: an update needs nothing on the command
+// line to find the mods directory and Minecraft version, and without
+// anything at all the prelaunch check runs and tells the log pane its
+// outcome in plain lines.
+func TestLauncherInstanceEndToEnd(t *testing.T) {
+	u := newFakeUpdateIn(t, filepath.Join(t.TempDir(), "instances", "RXMC"), map[string]string{"mods/sodium.jar": "sodium", "mods/iris.jar": "iris"})
+	writeFiles(t, filepath.Dir(u.minecraft), map[string]string{
+		"mmc-pack.json": `{"components": [{"uid": "net.minecraft", "cachedVersion": "1.20.1"}, {"uid": "net.fabricmc.fabric-loader", "version": "0.15.11"}]}`,
+	})
+	java := filepath.Join(t.TempDir(), "jre", "bin", "java")
+	useLauncherInstance(t, u, java)
+	// the pack's sodium.jar is already there, so the update backs it up
+	writeFiles(t, u.mods, map[string]string{"sodium.jar": "sodium"})
+
+	u.clock.Advance(time.Minute)
+	output := readFile(t, runMain(t, "--no-telemetry"))
+	if got := dirNames(t, u.mods); got != "iris.jar sodium.jar" {
+		t.Fatalf("installed %s, output:\n%s", got, output)
+	}
+	if javaCommand != java {
+		t.Errorf("runs java %q", javaCommand)
+	}
+	if strings.Contains(output, "\x1b") || strings.Contains(output, "\r") {
+		t.Errorf("escape sequences in the output:\n%q", output)
+	}
+	log := filepath.Join(baseDirs.Config, "rxmc-Updater", "clientUpdate.log")
+	if want := `launcher instance "RXMC": minecraft directory ` + u.minecraft + `, java "` + java + `", minecraft "1.20.1"`; !strings.Contains(readFile(t, log), want) {
+		t.Errorf("no %q in the log:\n%s", want, readFile(t, log))
+	}
+
+	// the prelaunch check restores the deleted sodium.jar
+	if err := os.Remove(filepath.Join(u.mods, "sodium.jar")); err != nil {
+		t.Fatal(err)
+	}
+	u.clock.Advance(time.Minute)
+	output = readFile(t, runMain(t))
+	for _, want := range []string{"Restored 1 damaged pack files from earlier backups.\n", "Pack checked, starting the game.\n"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("no %q in the output:\n%s", want, output)
+		}
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+
+	// an instance switched to another Minecraft version can't start
+	t.Setenv("INST_MC_VERSION", "1.21")
+	useRunState(t)
+	u.clock.Advance(time.Minute)
+	if code := exitsWith(func() { runMain(t) }); code != 1 {
+		t.Errorf("exited with %d on another version", code)
+	}
+	if want := "prelaunch: the instance runs minecraft 1.21, the pack was installed for 1.20.1"; !strings.Contains(readFile(t, log), want) {
+		t.Errorf("no %q in the log:\n%s", want, readFile(t, log))
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// swapMarkerName is written into the staging directory once the extracted
+// pack passed its checks, right before the installed mods are replaced. A
+// staging directory holding it belongs to a swap that was interrupted.
+const swapMarkerName = ".swap.json"
+
+// swapMarker lists what an interrupted swap was moving into place.
+type swapMarker struct {
+	Run string `json:"run"`
+	// Archive is the SHA-256 of the pack archive the files came from.
+	Archive string `json:"archive"`
+	// Files maps every staged file, relative to the staging directory, to
+	// its SHA-256. Each ends up in the mods directory under its base name.
+	Files map[string]string `json:"files"`
+}
+
+// writeSwapMarker records the staged files, synced to disk before any
+// installed mod is touched.
+func writeSwapMarker(staging string, marker swapMarker) error {
+	content, err := json.Marshal(marker)
+	if err != nil {
+		return err
+	}
+	return writeSynced(filepath.Join(staging, swapMarkerName), content)
+}
+
+// readSwapMarker returns the marker of the staging directory, nil when
+// there is none or it can't be read.
+func readSwapMarker(staging string) *swapMarker {
+	content, err := ioutil.ReadFile(filepath.Join(staging, swapMarkerName))
+	if err != nil {
+		return nil
+	}
+	var marker swapMarker
+	if json.Unmarshal(content, &marker) != nil || marker.Archive == "" {
+		return nil
+	}
+	return &marker
+}
+
+// resumeSwap checks whether an interrupted swap of the same pack archive
+// can be completed. Every file of the marker must still be in staging or
+// already in modPath, with the hash it was staged with. staged are the
+// files left to move, swapped the names of those already moved. ok is false
+// when there is nothing to resume, the caller then discards the staging
+// directory.
+func resumeSwap(staging string, modPath string, archiveSum string) (staged []string, swapped []string, ok bool) {
+	marker := readSwapMarker(staging)
+	if marker == nil {
+		if _, err := os.Stat(staging); err == nil {
+			Logf("resume: %s has no swap marker, discarding it", staging)
+		}
+		return nil, nil, false
+	}
+	if marker.Archive != archiveSum {
+		Logf("resume: %s was staged from another pack archive (run %s), discarding it", staging, marker.Run)
+		return nil, nil, false
+	}
+	for name, sum := range marker.Files {
+		p := filepath.Join(staging, filepath.FromSlash(name))
+		if got, err := fileSHA256(p); err == nil && got == sum {
+			staged = append(staged, p)
+			continue
+		}
+		base := filepath.Base(p)
+		if got, err := fileSHA256(filepath.Join(modPath, base)); err == nil && got == sum {
+			swapped = append(swapped, base)
+			continue
+		}
+		Logf("resume: %s of run %s can't be verified, discarding %s", name, marker.Run, staging)
+		return nil, nil, false
+	}
+	fmt.Println(T("resume.swap", marker.Run, len(swapped), len(marker.Files)))
+	Logf("resume: completing the swap of run %s, %d of %d files already moved", marker.Run, len(swapped), len(marker.Files))
+	return staged, swapped, true
+}
+
+// Leftover is something an interrupted run left behind.
+type Leftover struct {
+	Path string
+	// Resumable is set when the next update carries on with it instead of
+	// starting over.
+	Resumable bool
+}
+
+// FindLeftovers looks for partial downloads in downloadDir and a staging
+// directory next to modPath.
+func FindLeftovers(modPath string, downloadDir string) []Leftover {
+	var found []Leftover
+	if entries, err := ioutil.ReadDir(downloadDir); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), partialSuffix) {
+				continue
+			}
+			p := filepath.Join(downloadDir, entry.Name())
+			_, err := os.Stat(resumeStatePath(p))
+			found = append(found, Leftover{Path: p, Resumable: err == nil && entry.Size() > 0})
+		}
+	}
+	staging := modPath + stagingSuffix
+	if _, err := os.Stat(staging); err == nil {
+		found = append(found, Leftover{Path: staging, Resumable: readSwapMarker(staging) != nil})
+	}
+	return found
+}
+
+// PrintLeftovers tells what an interrupted run left behind and what
+// happens to it.
+func PrintLeftovers(leftovers []Leftover) {
+	if len(leftovers) == 0 {
+		return
+	}
+	fmt.Println(T("leftover.found"))
+	for _, leftover := range leftovers {
+		Logf("leftover %s, resumable %t", leftover.Path, leftover.Resumable)
+		if leftover.Resumable {
+			fmt.Println("  " + T("leftover.resume", leftover.Path))
+		} else {
+			fmt.Println("  " + T("leftover.discard", leftover.Path))
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stageUpdate extracts the pack the fake update serves next to its mods
+// directory and marks it ready to be swapped in, as a run killed right
+// before the swap leaves it. It returns the staged files.
+func stageUpdate(t *testing.T, u *fakeUpdate) []string {
+	t.Helper()
+	useRunState(t)
+	archive, err := ValidateArchive(u.server.archive.Load().(string), "1.20.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := fileSHA256(archive.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := UpdatePlan{Archive: archive, ModPath: u.mods, Journal: &Journal{Path: filepath.Join(t.TempDir(), "journal.jsonl"), Run: "killed"}, Out: io.Discard}
+	staged, err := plan.stage(u.mods+stagingSuffix, map[string]bool{}, sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return staged
+}
+
+func TestResumeSwap(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium", "mods/iris.jar": "iris"})
+	staging := u.mods + stagingSuffix
+	stageUpdate(t, u)
+	sum, _ := fileSHA256(u.server.archive.Load().(string))
+
+	if _, _, ok := resumeSwap(staging, u.mods, "another archive"); ok {
+		t.Error("resumed the swap of another archive")
+	}
+	var staged, swapped []string
+	captureStdout(t, func() { staged, swapped, _ = resumeSwap(staging, u.mods, sum) })
+	if len(staged) != 2 || len(swapped) != 0 {
+		t.Errorf("staged %q, swapped %q", staged, swapped)
+	}
+
+	// one of them was moved already
+	if err := os.Rename(filepath.Join(staging, "iris.jar"), filepath.Join(u.mods, "iris.jar")); err != nil {
+		t.Fatal(err)
+	}
+	var ok bool
+	output := captureStdout(t, func() { staged, swapped, ok = resumeSwap(staging, u.mods, sum) })
+	if !ok || len(staged) != 1 || filepath.Base(staged[0]) != "sodium.jar" || strings.Join(swapped, " ") != "iris.jar" {
+		t.Errorf("staged %q, swapped %q", staged, swapped)
+	}
+	if !strings.Contains(output, "interrupted in run killed (1 of 2 files were already in place)") {
+		t.Errorf("output %q", output)
+	}
+
+	// a file that isn't what was staged can't be resumed
+	writeFiles(t, u.mods, map[string]string{"iris.jar": "changed"})
+	if _, _, ok := resumeSwap(staging, u.mods, sum); ok {
+		t.Error("resumed with a changed file")
+	}
+	if err := os.Remove(filepath.Join(staging, swapMarkerName)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := resumeSwap(staging, u.mods, sum); ok {
+		t.Error("resumed without a marker")
+	}
+}
+
+func TestFindLeftovers(t *testing.T) {
+	root := t.TempDir()
+	mods := filepath.Join(root, "mods")
+	downloads := filepath.Join(root, "state")
+	writeFiles(t, downloads, map[string]string{
+		"pack.zip.partial":       "PK",
+		"pack.zip.partial.json":  `{"url": "https://github.com/pack.zip"}`,
+		"other.zip.partial":      "PK",
+		"empty.zip.partial":      "",
+		"empty.zip.partial.json": `{"url": "https://github.com/empty.zip"}`,
+		"pack.zip":               "done",
+	})
+	writeFiles(t, mods+stagingSuffix, map[string]string{"sodium.jar": "sodium"})
+	got := FindLeftovers(mods, downloads)
+	want := []Leftover{
+		{Path: filepath.Join(downloads, "empty.zip.partial")},
+		{Path: filepath.Join(downloads, "other.zip.partial")},
+		{Path: filepath.Join(downloads, "pack.zip.partial"), Resumable: true},
+		{Path: mods + stagingSuffix},
+	}
+	if mustJSON(t, got) != mustJSON(t, want) {
+		t.Errorf("found %+v", got)
+	}
+	writeFiles(t, mods+stagingSuffix, map[string]string{swapMarkerName: `{"run": "killed", "archive": "0123", "files": {}}`})
+	if got := FindLeftovers(mods, downloads); !got[len(got)-1].Resumable {
+		t.Errorf("staging with a marker: %+v", got[len(got)-1])
+	}
+	if got := FindLeftovers(filepath.Join(root, "missing"), filepath.Join(root, "missing")); len(got) != 0 {
+		t.Errorf("found %+v in a missing directory", got)
+	}
+}
+
+// TestUpdateAfterInterruption leaves what a run killed at each phase
+// boundary leaves, then updates again: the update ends the same as one
+// never interrupted, carrying on where it can.
+func TestUpdateAfterInterruption(t *testing.T) {
+	pack := map[string]string{"mods/sodium.jar": "sodium 2", "mods/iris.jar": "iris"}
+	tests := []struct {
+		name string
+		// kill leaves behind what the run killed left.
+		kill func(t *testing.T, u *fakeUpdate)
+		// output is what the run tells about the leftovers.
+		output string
+		// ranges are the Range headers of the pack downloads.
+		ranges string
+	}{
+		{
+			name: "during the download",
+			kill: func(t *testing.T, u *fakeUpdate) {
+				content := []byte(readFile(t, u.server.archive.Load().(string)))
+				interruptedDownload(t, filepath.Join(u.state, "serverMods-master.zip"), "https://github.com"+packArchiveURLPath, `"v1"`, content[:len(content)/2])
+			},
+			output: "serverMods-master.zip.partial, the update carries on with it",
+			ranges: "bytes=",
+		},
+		{
+			name: "during the extraction",
+			kill: func(t *testing.T, u *fakeUpdate) {
+				writeFiles(t, u.mods+stagingSuffix, map[string]string{"sodium.jar": "sod"})
+			},
+			output: "mods.staging, it can't be verified and is removed",
+		},
+		{
+			name: "before the swap",
+			kill: func(t *testing.T, u *fakeUpdate) {
+				stageUpdate(t, u)
+			},
+			output: "Completing the update interrupted in run killed (0 of 2 files were already in place)",
+		},
+		{
+			name: "during the swap",
+			kill: func(t *testing.T, u *fakeUpdate) {
+				stageUpdate(t, u)
+				os.Remove(filepath.Join(u.mods, "old.jar"))
+				os.Remove(filepath.Join(u.mods, "sodium.jar"))
+				if err := os.Rename(filepath.Join(u.mods+stagingSuffix, "sodium.jar"), filepath.Join(u.mods, "sodium.jar")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			output: "Completing the update interrupted in run killed (1 of 2 files were already in place)",
+		},
+		{
+			name: "before the swap of another pack",
+			kill: func(t *testing.T, u *fakeUpdate) {
+				u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 1.5"})
+				stageUpdate(t, u)
+				u.setPack(t, pack)
+			},
+			output: "mods.staging, the update carries on with it",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			u := newFakeUpdate(t, pack)
+			server := &rangeServer{content: []byte(readFile(t, u.server.archive.Load().(string))), etag: `"v1"`}
+			useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Host != "github.com" || r.URL.Path != packArchiveURLPath {
+					http.NotFound(w, r)
+					return
+				}
+				server.ServeHTTP(w, r)
+			}))
+			writeFiles(t, u.mods, map[string]string{"sodium.jar": "sodium 1", "old.jar": "old"})
+			if err := os.MkdirAll(u.state, 0755); err != nil {
+				t.Fatal(err)
+			}
+			test.kill(t, u)
+
+			output := readFile(t, u.run(t))
+			if !strings.Contains(output, test.output) {
+				t.Errorf("no %q in the output:\n%s", test.output, output)
+			}
+			if got := dirNames(t, u.mods); got != "iris.jar sodium.jar" || readFile(t, filepath.Join(u.mods, "sodium.jar")) != "sodium 2" {
+				t.Errorf("installed %s, output:\n%s", got, output)
+			}
+			if _, err := os.Stat(u.mods + stagingSuffix); !os.IsNotExist(err) {
+				t.Errorf("the staging directory is left: %v", err)
+			}
+			if got := strings.Join(server.ranges, " "); !strings.HasPrefix(got, test.ranges) || test.ranges == "" && got != "" {
+				t.Errorf("requested ranges %q", got)
+			}
+		})
+	}
+}
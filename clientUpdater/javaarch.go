@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// temurinURL is where players get a JDK built for their Mac.
+const temurinURL = "https://adoptium.net/temurin/releases/?os=mac&arch=%s"
+
+// javaArchPattern finds the architecture java reports among its settings.
+var javaArchPattern = regexp.MustCompile(`(?m)^\s*os\.arch\s*=\s*(\S+)`)
+
+// JavaArchFacts is what the diagnostic collects on a Mac after an installer
+// failed, as captured from the commands.
+type JavaArchFacts struct {
+	// AppleSilicon is set when sysctl hw.optional.arm64 says 1.
+	AppleSilicon bool
+	// JavaOutput is what java -XshowSettings:properties -version printed,
+	// including any error running it.
+	JavaOutput string
+	// Rosetta is set when Rosetta 2 is installed.
+	Rosetta bool
+}
+
+// JavaArch returns the architecture of the java found, "" when it didn't
+// say.
+func (f JavaArchFacts) JavaArch() string {
+	if m := javaArchPattern.FindStringSubmatch(f.JavaOutput); m != nil {
+		switch m[1] {
+		case "aarch64", "arm64":
+			return "arm64"
+		case "x86_64", "amd64":
+			return "x64"
+		}
+		return m[1]
+	}
+	return ""
+}
+
+// DiagnoseJavaArch explains a java that can't run on this Mac: an Intel
+// build on Apple Silicon without Rosetta, or an Apple Silicon build on an
+// Intel Mac. It returns the message to show, "" when the architecture is
+// not the problem.
+func DiagnoseJavaArch(f JavaArchFacts) string {
+	badCPU := strings.Contains(strings.ToLower(f.JavaOutput), "bad cpu type")
+	arch := f.JavaArch()
+	switch {
+	case f.AppleSilicon && !f.Rosetta && (arch == "x64" || badCPU):
+		return T("java.arch.rosetta", fmt.Sprintf(temurinURL, "aarch64"))
+	case !f.AppleSilicon && (arch == "arm64" || badCPU):
+		return T("java.arch.intel", fmt.Sprintf(temurinURL, "x64"))
+	}
+	return ""
+}
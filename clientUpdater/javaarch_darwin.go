@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// rosettaRuntime exists once Rosetta 2 is installed.
+const rosettaRuntime = "/Library/Apple/usr/share/rosetta/rosetta"
+
+// DiagnoseJavaFailure looks for the reason java failed to run an
+// installer that is specific to this platform.
+func DiagnoseJavaFailure() string {
+	var facts JavaArchFacts
+	if output, err := exec.Command("sysctl", "-n", "hw.optional.arm64").Output(); err == nil {
+		facts.AppleSilicon = strings.TrimSpace(string(output)) == "1"
+	}
+	output, err := exec.Command(javaCommand, "-XshowSettings:properties", "-version").CombinedOutput()
+	facts.JavaOutput = string(output)
+	if err != nil {
+		facts.JavaOutput += "\n" + err.Error()
+	}
+	_, err = os.Stat(rosettaRuntime)
+	facts.Rosetta = err == nil
+	Logf("java diagnostic: apple silicon %v, rosetta %v, java %q", facts.AppleSilicon, facts.Rosetta, facts.JavaArch())
+	return DiagnoseJavaArch(facts)
+}
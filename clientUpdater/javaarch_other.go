@@ -0,0 +1,6 @@
+//go:build !darwin
+
+package main
+
+// DiagnoseJavaFailure finds nothing specific outside macOS.
+func DiagnoseJavaFailure() string { return "" }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// javaOutput is what java printed, captured in testdata/java.
+func javaOutput(t *testing.T, name string) string {
+	t.Helper()
+	return readFile(t, filepath.Join("testdata", "java", name+".txt"))
+}
+
+func TestJavaArch(t *testing.T) {
+	tests := map[string]string{
+		"temurin-21-aarch64": "arm64",
+		"temurin-21-x64":     "x64",
+		"bad-cpu-type":       "",
+	}
+	for name, want := range tests {
+		if got := (JavaArchFacts{JavaOutput: javaOutput(t, name)}).JavaArch(); got != want {
+			t.Errorf("%s: arch %q, want %q", name, got, want)
+		}
+	}
+	if got := (JavaArchFacts{JavaOutput: "    os.arch = ppc64le\n"}).JavaArch(); got != "ppc64le" {
+		t.Errorf("unknown arch %q", got)
+	}
+}
+
+func TestDiagnoseJavaArch(t *testing.T) {
+	tests := []struct {
+		name         string
+		appleSilicon bool
+		rosetta      bool
+		java         string
+		// advice is the Temurin build recommended, none when the
+		// architecture is fine.
+		advice string
+	}{
+		{"native on Apple Silicon", true, false, "temurin-21-aarch64", ""},
+		{"Intel build with Rosetta", true, true, "temurin-21-x64", ""},
+		{"Intel build without Rosetta", true, false, "temurin-21-x64", "arch=aarch64"},
+		{"bad CPU type on Apple Silicon", true, false, "bad-cpu-type", "arch=aarch64"},
+		{"native on Intel", false, false, "temurin-21-x64", ""},
+		{"Apple Silicon build on Intel", false, false, "temurin-21-aarch64", "arch=x64"},
+		{"bad CPU type on Intel", false, false, "bad-cpu-type", "arch=x64"},
+	}
+	for _, test := range tests {
+		facts := JavaArchFacts{AppleSilicon: test.appleSilicon, Rosetta: test.rosetta, JavaOutput: javaOutput(t, test.java)}
+		got := DiagnoseJavaArch(facts)
+		if test.advice == "" && got != "" || test.advice != "" && !strings.Contains(got, "adoptium.net/temurin/releases/?os=mac&"+test.advice) {
+			t.Errorf("%s: %q", test.name, got)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// ParseJitter reads a StartJitter setting: a range such as "0-10m" or
+// "30s-2m", or a single duration meaning from zero. A bound without a unit
+// takes the unit of the other one, so "5-10m" is five to ten minutes.
+func ParseJitter(setting string) (min time.Duration, max time.Duration, err error) {
+	from, to := "0", strings.TrimSpace(setting)
+	if i := strings.Index(to, "-"); i >= 0 {
+		from, to = strings.TrimSpace(to[:i]), strings.TrimSpace(to[i+1:])
+	}
+	if max, err = time.ParseDuration(to); err != nil {
+		return 0, 0, fmt.Errorf("startJitter %q: %s", setting, err)
+	}
+	if min, err = time.ParseDuration(from); err != nil {
+		unit := strings.TrimLeft(to, "0123456789.")
+		if min, err = time.ParseDuration(from + unit); err != nil {
+			return 0, 0, fmt.Errorf("startJitter %q: %s", setting, err)
+		}
+	}
+	if min < 0 || max < min {
+		return 0, 0, fmt.Errorf("startJitter %q: the range must be from low to high", setting)
+	}
+	return min, max, nil
+}
+
+// WaitJitter sleeps a random duration in the StartJitter range on the
+// clock, so a scheduled update of many players doesn't hit the mirror in
+// the same minute. Nothing was changed yet, so the wait ends early when ctx
+// is done, with its error.
+func WaitJitter(ctx context.Context, setting string) error {
+	min, max, err := ParseJitter(setting)
+	if err != nil {
+		return err
+	}
+	random := rand.New(rand.NewSource(clock.Now().UnixNano()))
+	wait := min + time.Duration(random.Int63n(int64(max-min)+1))
+	Logf("start jitter %q: waiting %s", setting, wait)
+	if wait <= 0 {
+		return nil
+	}
+	fmt.Println(T("jitter.wait", wait.Round(time.Second), clock.Now().Add(wait).Format("15:04:05")))
+	select {
+	case <-clock.After(wait):
+		return nil
+	case <-ctx.Done():
+		Logf("start jitter: %s", ctx.Err())
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseJitter(t *testing.T) {
+	tests := []struct {
+		setting  string
+		min, max time.Duration
+	}{
+		{"0-10m", 0, 10 * time.Minute},
+		{"30s-2m", 30 * time.Second, 2 * time.Minute},
+		{"5-10m", 5 * time.Minute, 10 * time.Minute},
+		{" 1m - 90s ", time.Minute, 90 * time.Second},
+		{"10m", 0, 10 * time.Minute},
+		{"0", 0, 0},
+	}
+	for _, test := range tests {
+		min, max, err := ParseJitter(test.setting)
+		if err != nil || min != test.min || max != test.max {
+			t.Errorf("ParseJitter(%q) = %s, %s, %v, want %s and %s", test.setting, min, max, err, test.min, test.max)
+		}
+	}
+	for _, setting := range []string{"", "soon", "10m-5m", "-5m", "1x-2m"} {
+		if _, _, err := ParseJitter(setting); err == nil {
+			t.Errorf("ParseJitter(%q) accepted", setting)
+		}
+	}
+}
+
+// waitJitter runs WaitJitter until it waits on the clock, returning the
+// channel its result comes on.
+func waitJitter(t *testing.T, fake *fakeClock, ctx context.Context, setting string) <-chan error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- WaitJitter(ctx, setting) }()
+	fake.WaitForTimers(t, 1)
+	return done
+}
+
+func TestWaitJitterWaitsOnTheClock(t *testing.T) {
+	fake := useFakeClock(t)
+	for i := 0; i < 20; i++ {
+		done := waitJitter(t, fake, context.Background(), "1m-3m")
+		fake.Advance(time.Minute - time.Nanosecond)
+		select {
+		case err := <-done:
+			t.Fatalf("done before the range, %v", err)
+		case <-time.After(time.Millisecond):
+		}
+		fake.Advance(2 * time.Minute)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("still waiting after the range")
+		}
+	}
+}
+
+func TestWaitJitterWithoutRange(t *testing.T) {
+	fake := useFakeClock(t)
+	start := fake.Now()
+	if err := WaitJitter(context.Background(), "0"); err != nil {
+		t.Fatal(err)
+	}
+	if waited := fake.Now().Sub(start); waited != 0 {
+		t.Errorf("waited %s", waited)
+	}
+	if err := WaitJitter(context.Background(), "later"); err == nil {
+		t.Error("an invalid setting was waited")
+	}
+}
+
+func TestWaitJitterInterrupted(t *testing.T) {
+	fake := useFakeClock(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := waitJitter(t, fake, ctx, "10m-20m")
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("interrupted wait returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the wait wasn't interrupted")
+	}
+}
@@ -0,0 +1,637 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// journalMaxSize caps the journal, once it grows past this it is rotated to
+// <journal>.1, replacing the previous rotation.
+const journalMaxSize = 1 << 20
+
+// Journal actions.
+const (
+	journalDelete    = "delete"
+	journalAdd       = "add"
+	journalOverwrite = "overwrite"
+	// journalNoBackup records a run that replaced the mods without a
+	// backup, with --no-backup; what it deleted can't be put back.
+	journalNoBackup = "no-backup"
+)
+
+// JournalEntry records one file the updater changed.
+type JournalEntry struct {
+	Run    string    `json:"run"`
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Path   string    `json:"path"`
+	SHA256 string    `json:"sha256,omitempty"`
+	// Backup is where a copy of a deleted file was kept.
+	Backup string `json:"backup,omitempty"`
+	// Undoes is the run whose change the entry rolled back.
+	Undoes string `json:"undoes,omitempty"`
+	// Pack is the pack version the run installed.
+	Pack string `json:"pack,omitempty"`
+	// Sum is the start of the SHA-256 of the entry's line without it, so a
+	// damaged line is told apart from a good one. It must stay the last
+	// field. Entries from before it have none.
+	Sum string `json:"sum,omitempty"`
+}
+
+// journalSumLength is how many hex digits of the SHA-256 a line keeps.
+const journalSumLength = 16
+
+// journalLineSum returns the sum of a journal line written without one.
+func journalLineSum(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])[:journalSumLength]
+}
+
+// journalLineIntact reports whether line, parsed into entry, is the line
+// written for it: its sum matches the rest of the line.
+func journalLineIntact(line []byte, entry JournalEntry) bool {
+	if entry.Sum == "" {
+		return true
+	}
+	suffix := `,"sum":"` + entry.Sum + `"}`
+	if !bytes.HasSuffix(line, []byte(suffix)) {
+		return false
+	}
+	unsummed := append(line[:len(line)-len(suffix):len(line)-len(suffix)], '}')
+	return journalLineSum(unsummed) == entry.Sum
+}
+
+// Journal is an append-only JSONL record of every file the updater deleted
+// or added, used to answer "what happened to my file" and to restore files.
+type Journal struct {
+	Path string
+	Run  string
+	// Pack is the pack version recorded with every entry, see packLabel.
+	Pack string
+
+	// mu serializes targets updated concurrently.
+	mu sync.Mutex
+}
+
+// NewRunID names a run after the time it started.
+func NewRunID(now time.Time) string {
+	return now.Format("20060102-150405")
+}
+
+// Record appends an entry and syncs it to disk before returning, so a crash
+// never loses a record of something that already happened.
+func (j *Journal) Record(entry JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry.Run = j.Run
+	if entry.Pack == "" {
+		entry.Pack = j.Pack
+	}
+	if entry.Time.IsZero() {
+		entry.Time = clock.Now()
+	}
+	if info, err := os.Stat(j.Path); err == nil && info.Size() > journalMaxSize {
+		if err := os.Rename(j.Path, j.Path+".1"); err != nil {
+			return err
+		}
+	}
+
+	entry.Sum = ""
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	entry.Sum = journalLineSum(line)
+	if line, err = json.Marshal(entry); err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	// a line cut off by a crash would swallow this one
+	if lineCutOff(j.Path) {
+		line = append([]byte{'\n'}, line...)
+	}
+	file, err := os.OpenFile(j.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, filePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// lineCutOff reports whether the file at p doesn't end in a newline.
+func lineCutOff(p string) bool {
+	file, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return false
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return false
+	}
+	return last[0] != '\n'
+}
+
+// ReadJournal returns every entry of the journal, oldest first, including
+// the rotated generation. Lines that can't be parsed (e.g. cut off by a
+// crash) or whose sum doesn't match are skipped and reported by line.
+func ReadJournal(journalPath string) ([]JournalEntry, error) {
+	var entries []JournalEntry
+	for _, p := range []string{journalPath + ".1", journalPath} {
+		file, err := os.Open(p)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		for number := 1; scanner.Scan(); number++ {
+			line := bytes.TrimRight(scanner.Bytes(), "\r")
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			var entry JournalEntry
+			switch err := json.Unmarshal(line, &entry); {
+			case err != nil:
+				Warn(warnState, T("state.journal.skipped", p, number, err))
+			case !journalLineIntact(line, entry):
+				Warn(warnState, T("state.journal.skipped", p, number, "checksum mismatch"))
+			default:
+				entry.Sum = ""
+				entries = append(entries, entry)
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// backupWorkers is how many files are backed up at once. Hashing takes
+// most of the time for many small files, a few workers keep the disk busy
+// without thrashing it.
+const backupWorkers = 4
+
+// backupJob is a file to back up, rel being its path below the directory.
+type backupJob struct {
+	path string
+	rel  string
+	size int64
+}
+
+// BackupAndRemove moves every file below dir except those in keep into
+// backupDir, journaling each one, and then removes dir unless something was
+// kept. Hashes already computed for the files may be passed in known.
+// The safety checks of mods directories are repeated first, nothing that
+// could be a whole game directory is ever emptied. Protected files are
+// always kept. The backup is verified and its manifest written before dir
+// is removed; a backup that fails verification is reported, not fatal,
+// since the files are already moved and a rollback won't rely on it.
+//
+// The files are backed up by backupWorkers at once, reporting the files
+// and bytes done as progress. Until the manifest is written the backup is
+// marked incomplete, a run stopped meanwhile leaves it marked. With an
+// empty backupDir the files are deleted instead, journaled without a
+// backup. The progress is told to report.
+func BackupAndRemove(dir string, backupDir string, journal *Journal, known map[string]string, keep map[string]bool, report func(RunProgress)) error {
+	if err := checkSafeModsDir(dir); err != nil {
+		return err
+	}
+	protected, err := ScanProtected(dir)
+	if err != nil {
+		return err
+	}
+	kept := protectedPaths(dir, protected)
+	for p := range keep {
+		kept.Add(p)
+	}
+	var jobs []backupJob
+	var total int64
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || kept.Has(p) {
+			return err
+		}
+		if runCtx.Err() != nil {
+			return RunStopped()
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, backupJob{path: p, rel: rel, size: info.Size()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(jobs) > 0 && backupDir != "" {
+		if err := markBackupIncomplete(backupDir, journal.Run); err != nil {
+			return err
+		}
+	}
+	files, err := backupFiles(runCtx, backupWorkers, jobs, total, backupDir, journal, known, report)
+	if err != nil {
+		if stopped := RunStopped(); stopped != nil {
+			return stopped
+		}
+		return err
+	}
+	if len(files) > 0 && backupDir != "" {
+		manifest, err := WriteBackupManifest(backupDir, journal.Run, files)
+		if err != nil {
+			return err
+		}
+		if err := markBackupComplete(backupDir); err != nil {
+			return err
+		}
+		if manifest.Status != backupComplete {
+			fmt.Println(T("backup.unverified", backupDir, len(manifest.Failed)))
+		}
+	}
+	if len(kept.paths) > 0 {
+		return nil
+	}
+	return os.RemoveAll(dir)
+}
+
+// backupFiles backs up jobs, total bytes, with workers at once until done
+// or ctx is, stopping at the first file failing. It returns the files
+// moved to backupDir, sorted by path, even when it stopped early.
+func backupFiles(ctx context.Context, workers int, jobs []backupJob, total int64, backupDir string, journal *Journal, known map[string]string, report func(RunProgress)) ([]BackupFile, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	progress := newCopyProgress(int64(len(jobs)), total, report)
+	queue := make(chan backupJob)
+	var mu sync.Mutex
+	var files []BackupFile
+	var firstErr error
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(jobs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				file, err := backupFile(ctx, job, backupDir, journal, known)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				if backupDir != "" {
+					mu.Lock()
+					files = append(files, file)
+					mu.Unlock()
+				}
+				progress.Add(job.size)
+			}
+		}()
+	}
+feed:
+	for _, job := range jobs {
+		select {
+		case queue <- job:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return files, firstErr
+}
+
+// backupFile hashes job's file and moves it to backupDir, or deletes it
+// when backupDir is empty, journaling either.
+func backupFile(ctx context.Context, job backupJob, backupDir string, journal *Journal, known map[string]string) (BackupFile, error) {
+	file := BackupFile{Path: job.rel, Size: job.size}
+	if err := ctx.Err(); err != nil {
+		return file, err
+	}
+	sum, ok := known[job.path]
+	if !ok {
+		var err error
+		if sum, err = fileSHA256(job.path); err != nil {
+			return file, err
+		}
+	}
+	file.SHA256 = sum
+	if backupDir == "" {
+		if err := os.Remove(job.path); err != nil {
+			return file, err
+		}
+		return file, journal.Record(JournalEntry{Action: journalDelete, Path: job.path, SHA256: sum})
+	}
+	backup := filepath.Join(backupDir, job.rel)
+	if err := moveFile(job.path, backup); err != nil {
+		return file, err
+	}
+	return file, journal.Record(JournalEntry{Action: journalDelete, Path: job.path, SHA256: sum, Backup: backup})
+}
+
+// RollbackRun undoes what the run of journal changed below dir since
+// began, newest first: added files are removed again and deleted ones put
+// back from their backups. The rollback is journaled like any other change.
+func RollbackRun(journal *Journal, dir string, began time.Time) error {
+	entries, err := ReadJournal(journal.Path)
+	if err != nil {
+		return err
+	}
+	if err := rollbackEntries(journal, dir, entries, journal.Run, func(entry JournalEntry) bool {
+		return entry.Run == journal.Run && !entry.Time.Before(began)
+	}); err != nil {
+		return err
+	}
+	Logf("rolled back the changes of run %s to %s", journal.Run, dir)
+	return nil
+}
+
+// runRollback undoes the last update of the mods directory config points
+// at, see RollbackLatest. The installed state forgets the files that no
+// longer match, so the pre-launch check leaves them alone.
+func runRollback(config ConfFile, installedPath string, journalPath string, runID string) error {
+	if err := unlockForUpdate(config.MCDirectory); err != nil {
+		return err
+	}
+	run, err := RollbackLatest(&Journal{Path: journalPath, Run: runID}, config.MCDirectory)
+	relockAfterUpdate(config.MCDirectory, config.LockModsDir)
+	if err == nil && run != "" {
+		err = ReconcileInstalledState(installedPath, config.MCDirectory)
+	}
+	if err != nil {
+		return err
+	}
+	if run == "" {
+		fmt.Println(T("rollback.none"))
+		return nil
+	}
+	fmt.Println(T("rollback.done", run))
+	fmt.Println(T("rollback.freeze"))
+	return nil
+}
+
+// RollbackLatest undoes the last run that changed files below dir, the
+// rollback journaled as the run of journal. Rollbacks and the runs they
+// undid are passed over, so each call goes back one more update. It
+// returns the run rolled back, empty when the journal has none left.
+func RollbackLatest(journal *Journal, dir string) (string, error) {
+	entries, err := ReadJournal(journal.Path)
+	if err != nil {
+		return "", err
+	}
+	passed := map[string]bool{}
+	for _, entry := range entries {
+		if entry.Undoes != "" {
+			passed[entry.Run], passed[entry.Undoes] = true, true
+		}
+	}
+	prefix := filepath.Clean(dir) + string(filepath.Separator)
+	run := ""
+	for i := len(entries) - 1; i >= 0 && run == ""; i-- {
+		if !passed[entries[i].Run] && strings.HasPrefix(entries[i].Path, prefix) {
+			run = entries[i].Run
+		}
+	}
+	if run == "" {
+		return "", nil
+	}
+	for _, entry := range entries {
+		if entry.Run == run && entry.Action == journalNoBackup {
+			return run, &noBackupError{run: run}
+		}
+	}
+	if err := rollbackEntries(journal, dir, entries, run, func(entry JournalEntry) bool {
+		return entry.Run == run
+	}); err != nil {
+		return run, err
+	}
+	Logf("rolled back the changes of run %s to %s as run %s", run, dir, journal.Run)
+	return run, nil
+}
+
+// noBackupError is a run to roll back that replaced the mods without a
+// backup.
+type noBackupError struct {
+	run string
+}
+
+func (e *noBackupError) Error() string {
+	return fmt.Sprintf("run %s replaced the mods with --no-backup, so the mods it removed can't be put back; update again, or use repair to reinstall the pack", e.run)
+}
+
+func (e *noBackupError) Category() string { return categoryLocal }
+
+// rollbackEntries undoes the entries below dir that match, changes of the
+// run undoes, newest first: added files are removed again and deleted ones
+// put back from their backups.
+func rollbackEntries(journal *Journal, dir string, entries []JournalEntry, undoes string, match func(JournalEntry) bool) error {
+	prefix := filepath.Clean(dir) + string(filepath.Separator)
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if !match(entry) || !strings.HasPrefix(entry.Path, prefix) {
+			continue
+		}
+		switch {
+		case entry.Action == journalAdd:
+			if err := os.Remove(entry.Path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := journal.Record(JournalEntry{Action: journalDelete, Path: entry.Path, SHA256: entry.SHA256, Undoes: undoes}); err != nil {
+				return err
+			}
+		case entry.Action == journalDelete && entry.Backup != "":
+			src, fallback, err := backupSource(entry)
+			if err != nil {
+				return err
+			}
+			if fallback {
+				err = copyFile(src, entry.Path)
+			} else {
+				err = moveFile(src, entry.Path)
+			}
+			if err != nil {
+				return err
+			}
+			if err := journal.Record(JournalEntry{Action: journalAdd, Path: entry.Path, SHA256: entry.SHA256, Undoes: undoes}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyFallback tells once per run that files are copied instead of
+// renamed.
+var copyFallback sync.Once
+
+// moveFile moves src to dst, copying when a rename isn't possible, e.g.
+// between volumes.
+func moveFile(src string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), dirPerm); err != nil {
+		return err
+	}
+	err := rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	copyFallback.Do(func() {
+		Logf("move: renaming %s failed (%s), copying instead", src, err)
+		fmt.Println(T("move.copying", filepath.Dir(dst)))
+	})
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies src to dst and syncs it to disk.
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, filePerm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(countingWriter{out}, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// a partial copy is never left behind
+		os.Remove(dst)
+		if info, statErr := in.Stat(); statErr == nil {
+			return spaceError(dst, info.Size(), err)
+		}
+		return err
+	}
+	return nil
+}
+
+// PrintHistory prints every run in the journal with the files it changed.
+func PrintHistory(journalPath string) error {
+	entries, err := ReadJournal(journalPath)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println(T("history.empty"))
+		return nil
+	}
+
+	runs := map[string][]JournalEntry{}
+	var order []string
+	for _, entry := range entries {
+		if _, ok := runs[entry.Run]; !ok {
+			order = append(order, entry.Run)
+		}
+		runs[entry.Run] = append(runs[entry.Run], entry)
+	}
+	for _, run := range order {
+		changes := runs[run]
+		fmt.Println(T("history.run", run, changes[0].Time.Local().Format("2006-01-02 15:04")))
+		sort.SliceStable(changes, func(i, j int) bool { return changes[i].Action < changes[j].Action })
+		for _, entry := range changes {
+			switch entry.Action {
+			case journalAdd:
+				fmt.Println("  + " + filepath.Base(entry.Path))
+			case journalDelete:
+				fmt.Println("  - " + filepath.Base(entry.Path))
+			case journalOverwrite:
+				fmt.Println("  ~ " + filepath.Base(entry.Path))
+			case journalNoBackup:
+				fmt.Println("  ! " + T("history.nobackup"))
+			}
+		}
+	}
+	return nil
+}
+
+// RestoreFile puts a deleted file back from its backup. The most recent
+// backup of the file is used unless run names an older one. A backup that
+// can't be trusted is replaced by a verified one of the same file.
+func RestoreFile(journalPath string, name string, run string) error {
+	entries, err := ReadJournal(journalPath)
+	if err != nil {
+		return err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Backup == "" || !strings.EqualFold(filepath.Base(entry.Path), name) {
+			continue
+		}
+		if run != "" && entry.Run != run {
+			continue
+		}
+		// a backup cut short is only for the rollback of its own run
+		if inIncompleteBackup(entry.Backup) {
+			Logf("restore: passing over %s, its backup was never completed", entry.Backup)
+			continue
+		}
+		src, _, err := backupSource(entry)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(entry.Path), dirPerm); err != nil {
+			return err
+		}
+		// a locked mods directory is locked again once the file is back
+		locked := ModsDirLocked(filepath.Dir(entry.Path))
+		if err := unlockForUpdate(filepath.Dir(entry.Path)); err != nil {
+			return err
+		}
+		err = copyFile(src, entry.Path)
+		relockAfterUpdate(filepath.Dir(entry.Path), locked)
+		if err != nil {
+			return err
+		}
+		fmt.Println(T("restore.done", entry.Path, entry.Run))
+		Logf("restored %s from %s (run %s)", entry.Path, src, entry.Run)
+		return nil
+	}
+	if run != "" {
+		return fmt.Errorf("no backup of %s from run %s", name, run)
+	}
+	return fmt.Errorf("no backup of %s found", name)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// launcherChurnWindow is how recently launcher files must have changed
+	// to count as the launcher still working on them.
+	launcherChurnWindow = 30 * time.Second
+	// launcherWaitInterval and launcherWaitAttempts bound how long we wait
+	// for the launcher to settle before giving up on the loader install.
+	launcherWaitInterval = 15 * time.Second
+	launcherWaitAttempts = 8
+)
+
+// launcherProcesses are the executables of the official launcher. ps on
+// Linux cuts names off at 15 characters, hence "minecraft-launc".
+var launcherProcesses = []string{
+	"minecraftlauncher.exe",
+	"minecraft.exe",
+	"minecraft-launcher",
+	"minecraft-launc",
+	"minecraft launcher",
+}
+
+// LauncherActivity returns the reasons to believe the official launcher is
+// currently writing into minecraftPath, or nothing when it seems idle.
+// processes is the list of running executable names.
+func LauncherActivity(minecraftPath string, processes []string, now time.Time) []string {
+	var reasons []string
+	for _, process := range processes {
+		name := strings.ToLower(filepath.Base(process))
+		for _, launcher := range launcherProcesses {
+			if name == launcher {
+				reasons = append(reasons, "the Minecraft launcher is running ("+process+")")
+			}
+		}
+	}
+
+	if info, err := os.Stat(filepath.Join(minecraftPath, "launcher_profiles.json")); err == nil && now.Sub(info.ModTime()) < launcherChurnWindow {
+		reasons = append(reasons, "launcher_profiles.json was just modified")
+	}
+
+	// the launcher's downloads change from one poll to the next, so this
+	// doesn't use the cached ScanVersions
+	versions, _ := os.ReadDir(filepath.Join(minecraftPath, "versions"))
+	for _, version := range versions {
+		if !version.IsDir() {
+			continue
+		}
+		files, _ := os.ReadDir(filepath.Join(minecraftPath, "versions", version.Name()))
+		for _, file := range files {
+			if !strings.HasSuffix(file.Name(), ".tmp") {
+				continue
+			}
+			if info, err := file.Info(); err == nil && now.Sub(info.ModTime()) < 2*launcherChurnWindow {
+				reasons = append(reasons, "versions/"+version.Name()+"/"+file.Name()+" is being downloaded")
+			}
+		}
+	}
+	return reasons
+}
+
+// WaitForLauncher waits until the launcher looks idle. It returns the last
+// reasons found when it is still busy after the bounded wait.
+func WaitForLauncher(minecraftPath string) []string {
+	for attempt := 0; ; attempt++ {
+		processes, err := RunningProcesses()
+		if err != nil {
+			Logf("launcher check: listing processes: %s", err)
+		}
+		reasons := LauncherActivity(minecraftPath, processes, clock.Now())
+		if len(reasons) == 0 {
+			return nil
+		}
+		Logf("launcher check: %s", strings.Join(reasons, "; "))
+		if attempt == launcherWaitAttempts {
+			return reasons
+		}
+		fmt.Println(T("launcher.busy", reasons[0]))
+		clock.Sleep(launcherWaitInterval)
+	}
+}
+
+// VerifyLoaderInstall checks the loader's version directory for mcVersion
+// contains a readable version JSON, and the version jar when the loader
+// creates one.
+func VerifyLoaderInstall(loader Loader, minecraftPath string, mcVersion string) error {
+	versionsPath := filepath.Join(minecraftPath, "versions")
+	ForgetVersions(versionsPath)
+	versions, err := ScanVersions(versionsPath)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if !loader.IsVersionDir(version.Name, mcVersion) {
+			continue
+		}
+		dir := filepath.Join(versionsPath, version.Name)
+		content, err := ioutil.ReadFile(filepath.Join(dir, version.Name+".json"))
+		if err != nil {
+			return err
+		}
+		var versionJSON struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(content, &versionJSON); err != nil {
+			return fmt.Errorf("%s.json is damaged: %s", version.Name, err)
+		}
+		if loader.CreatesVersionJar() {
+			if _, err := os.Stat(filepath.Join(dir, version.Name+".jar")); err != nil {
+				return fmt.Errorf("%s.jar is missing", version.Name)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("no %s version for Minecraft %s was installed", loader.Name(), mcVersion)
+}
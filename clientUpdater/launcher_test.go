@@ -0,0 +1,174 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeAged writes files, by path below dir, last modified age before now.
+func writeAged(t *testing.T, dir string, now time.Time, files map[string]time.Duration) {
+	t.Helper()
+	for name, age := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		writeFiles(t, dir, map[string]string{name: "{}"})
+		if err := os.Chtimes(p, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLauncherActivity(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		files     map[string]time.Duration
+		processes []string
+		reasons   []string
+	}{
+		{
+			name:      "idle",
+			files:     map[string]time.Duration{"launcher_profiles.json": time.Hour, "versions/1.21/1.21.json": time.Hour},
+			processes: []string{"bash", "java", "/usr/bin/minecraft-launcher-helper"},
+		},
+		{
+			name:      "launcher running",
+			processes: []string{"explorer.exe", "MinecraftLauncher.exe"},
+			reasons:   []string{"the Minecraft launcher is running (MinecraftLauncher.exe)"},
+		},
+		{
+			// ps cuts the name off
+			name:      "launcher running on Linux",
+			processes: []string{"/opt/minecraft-launcher/minecraft-launc"},
+			reasons:   []string{"the Minecraft launcher is running (/opt/minecraft-launcher/minecraft-launc)"},
+		},
+		{
+			name:    "profiles churning",
+			files:   map[string]time.Duration{"launcher_profiles.json": 10 * time.Second},
+			reasons: []string{"launcher_profiles.json was just modified"},
+		},
+		{
+			name: "version downloading",
+			files: map[string]time.Duration{
+				"versions/1.21/1.21.json.tmp":   45 * time.Second,
+				"versions/1.21/1.21.jar.tmp":    10 * time.Minute,
+				"versions/1.20.1/1.20.1.json":   time.Second,
+				"versions/stray.tmp":            time.Second,
+				"launcher_profiles.json":        time.Minute,
+				"libraries/org/lwjgl/x.jar.tmp": time.Second,
+			},
+			reasons: []string{"versions/1.21/1.21.json.tmp is being downloaded"},
+		},
+		{
+			name:      "all at once",
+			files:     map[string]time.Duration{"launcher_profiles.json": 0, "versions/1.21/1.21.jar.tmp": 0},
+			processes: []string{"minecraft-launcher"},
+			reasons: []string{
+				"the Minecraft launcher is running (minecraft-launcher)",
+				"launcher_profiles.json was just modified",
+				"versions/1.21/1.21.jar.tmp is being downloaded",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			minecraft := t.TempDir()
+			writeAged(t, minecraft, now, test.files)
+			got := LauncherActivity(minecraft, test.processes, now)
+			if strings.Join(got, "\n") != strings.Join(test.reasons, "\n") {
+				t.Errorf("reasons %q, want %q", got, test.reasons)
+			}
+		})
+	}
+	if got := LauncherActivity(filepath.Join(t.TempDir(), "missing"), nil, now); len(got) != 0 {
+		t.Errorf("a missing minecraft directory is busy: %v", got)
+	}
+}
+
+// TestWaitForLauncher waits for a download that finishes and one that
+// never does. The processes checked are the real ones, no launcher is
+// expected to be running along with the tests.
+func TestWaitForLauncher(t *testing.T) {
+	fake := useFakeClock(t)
+	minecraft := t.TempDir()
+	writeAged(t, minecraft, fake.Now(), map[string]time.Duration{"versions/1.21/1.21.json.tmp": 0})
+	start := fake.Now()
+	if reasons := WaitForLauncher(minecraft); reasons != nil {
+		t.Fatalf("still busy: %v", reasons)
+	}
+	// the download counts for 2*launcherChurnWindow, polled every
+	// launcherWaitInterval
+	if waited := fake.Now().Sub(start); waited != 4*launcherWaitInterval {
+		t.Errorf("waited %s", waited)
+	}
+
+	// a download going on for longer than the wait is given up on
+	writeAged(t, minecraft, fake.Now(), map[string]time.Duration{"versions/1.21/1.21.json.tmp": -time.Hour})
+	start = fake.Now()
+	reasons := WaitForLauncher(minecraft)
+	if strings.Join(reasons, "") != "versions/1.21/1.21.json.tmp is being downloaded" {
+		t.Errorf("gave up for %v", reasons)
+	}
+	if waited := fake.Now().Sub(start); waited != launcherWaitAttempts*launcherWaitInterval {
+		t.Errorf("waited %s", waited)
+	}
+}
+
+func TestVerifyLoaderInstall(t *testing.T) {
+	const fabric = "versions/fabric-loader-0.15.11-1.20.1/fabric-loader-0.15.11-1.20.1"
+	const neoForge = "versions/neoforge-21.1.77/neoforge-21.1.77"
+	tests := []struct {
+		name      string
+		loader    Loader
+		mcVersion string
+		files     map[string]string
+		err       string
+	}{
+		{
+			name:      "installed",
+			loader:    fabricLoader{},
+			mcVersion: "1.20.1",
+			files:     map[string]string{fabric + ".json": `{"id": "fabric-loader-0.15.11-1.20.1"}`, fabric + ".jar": ""},
+		},
+		{
+			name:      "damaged JSON",
+			loader:    fabricLoader{},
+			mcVersion: "1.20.1",
+			files:     map[string]string{fabric + ".json": `{"id": "fabric-lo`, fabric + ".jar": ""},
+			err:       "fabric-loader-0.15.11-1.20.1.json is damaged: unexpected end of JSON input",
+		},
+		{
+			name:      "jar missing",
+			loader:    fabricLoader{},
+			mcVersion: "1.20.1",
+			files:     map[string]string{fabric + ".json": `{}`},
+			err:       "fabric-loader-0.15.11-1.20.1.jar is missing",
+		},
+		{
+			name:      "other Minecraft version",
+			loader:    fabricLoader{},
+			mcVersion: "1.21",
+			files:     map[string]string{fabric + ".json": `{}`, fabric + ".jar": ""},
+			err:       "no fabric version for Minecraft 1.21 was installed",
+		},
+		{
+			// NeoForge's installer doesn't create a version jar
+			name:      "NeoForge",
+			loader:    neoForgeLoader{},
+			mcVersion: "1.21.1",
+			files:     map[string]string{neoForge + ".json": `{}`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			minecraft := t.TempDir()
+			writeFiles(t, minecraft, test.files)
+			err := VerifyLoaderInstall(test.loader, minecraft, test.mcVersion)
+			if (err == nil) != (test.err == "") || err != nil && err.Error() != test.err {
+				t.Errorf("error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
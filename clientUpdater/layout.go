@@ -0,0 +1,167 @@
+package main
+
+import (
+	"archive/zip"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Mods layouts of a pack, see PackManifest.Layout.
+const (
+	// layoutFlat puts every mod file directly into the mods directory,
+	// whatever folder of the pack it is in.
+	layoutFlat = "flat"
+	// layoutPreserve keeps the folders below the pack's mods folder, for
+	// loaders set up to load mods from subfolders.
+	layoutPreserve = "preserve"
+)
+
+// validateLayout reports a layout the updater doesn't know.
+func validateLayout(layout string, problems *manifestProblems) {
+	switch layout {
+	case "", layoutFlat, layoutPreserve:
+		return
+	}
+	problems.add("layout", "%q is neither %s nor %s", layout, layoutFlat, layoutPreserve)
+}
+
+// layout returns the mods layout of the manifest, flat unless it says
+// otherwise.
+func (m *PackManifest) layout() string {
+	if m == nil || m.Layout == "" {
+		return layoutFlat
+	}
+	return m.Layout
+}
+
+// ModRelPath returns where the mod entry name of the archive is installed,
+// relative to the mods directory and slash separated: its file name in the
+// flat layout, its path below the pack's mods folder in the preserve
+// layout.
+func (a *PackArchive) ModRelPath(name string) string {
+	name = PackPath(strings.Replace(name, "\\", "/", -1))
+	if a.Manifest.layout() != layoutPreserve {
+		return path.Base(name)
+	}
+	if a.ModFolder != "" {
+		return strings.TrimPrefix(name, a.ModFolder+"/")
+	}
+	// the legacy layout's mods folder is the first one named like it
+	return name[strings.Index(name, "mods/")+len("mods/"):]
+}
+
+// ExtractMods extracts the mod entries of the archive whose path relative
+// to the mods directory match accepts into dest, placed as the layout
+// wants them.
+func (a *PackArchive) ExtractMods(dest string, match func(rel string) bool, collectPaths bool) (*ExtractReport, error) {
+	return unzipPlaced(a.Path, dest, func(f *zip.File) string {
+		if !a.IsModEntry(f.Name) {
+			return ""
+		}
+		rel := a.ModRelPath(f.Name)
+		if !match(rel) {
+			return ""
+		}
+		return rel
+	}, collectPaths)
+}
+
+// installedLayout returns the layout the installed state was recorded
+// with, flat for states from before layouts.
+func installedLayout(state *InstalledState) string {
+	if state == nil || state.Layout == "" {
+		return layoutFlat
+	}
+	return state.Layout
+}
+
+// finishLayoutChange cleans up after an update that changed the layout:
+// the folders the old layout left empty are removed, and mods installed
+// twice, e.g. a protected flat copy next to the pack's nested one, are
+// warned about.
+func (p *UpdatePlan) finishLayoutChange() error {
+	if err := removeEmptyDirs(p.ModPath); err != nil {
+		return err
+	}
+	duplicates, err := DuplicateModIDs(p.ModPath)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(duplicates))
+	for id := range duplicates {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		Logf("layout: mod %s is installed more than once: %s", id, strings.Join(duplicates[id], ", "))
+		Warn(warnMetadata, T("layout.duplicate", id, strings.Join(duplicates[id], ", ")))
+	}
+	return nil
+}
+
+// removeEmptyDirs removes the empty folders below dir, deepest first.
+func removeEmptyDirs(dir string) error {
+	var dirs []string
+	err := filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && p != dir {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if entries, err := os.ReadDir(dirs[i]); err == nil && len(entries) == 0 {
+			if err := os.Remove(dirs[i]); err != nil {
+				return err
+			}
+			Logf("layout: removed the empty folder %s", dirs[i])
+		}
+	}
+	return nil
+}
+
+// DuplicateModIDs returns the mod ids found in more than one jar anywhere
+// below modPath, with the paths of the jars relative to it.
+func DuplicateModIDs(modPath string) (map[string][]string, error) {
+	found := map[string][]string{}
+	err := filepath.WalkDir(modPath, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(entry.Name(), ".jar") {
+			return err
+		}
+		mod, err := ReadModInfo(p)
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(modPath, p)
+		if err != nil {
+			return err
+		}
+		found[mod.ID] = append(found[mod.ID], filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	duplicates := map[string][]string{}
+	for id, files := range found {
+		if len(files) > 1 {
+			duplicates[id] = files
+		}
+	}
+	return duplicates, nil
+}
+
+// layoutChanged reports whether the plan changes the layout of the last
+// update.
+func (p *UpdatePlan) layoutChanged() bool {
+	return p.PreviousLayout != "" && p.PreviousLayout != p.Archive.Manifest.layout()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestModRelPath(t *testing.T) {
+	tests := []struct {
+		layout string
+		folder string
+		name   string
+		want   string
+	}{
+		{layout: "", name: "pack-main/mods/perf/sodium.jar", want: "sodium.jar"},
+		{layout: layoutFlat, folder: "1.20.1/mods", name: "pack-main/1.20.1/mods/perf/sodium.jar", want: "sodium.jar"},
+		{layout: layoutPreserve, folder: "1.20.1/mods", name: "pack-main/1.20.1/mods/perf/sodium.jar", want: "perf/sodium.jar"},
+		{layout: layoutPreserve, folder: "1.20.1/mods", name: "pack-main\\1.20.1\\mods\\perf\\sodium.jar", want: "perf/sodium.jar"},
+		{layout: layoutPreserve, name: "pack-main/mods/iris.jar", want: "iris.jar"},
+		{layout: layoutPreserve, name: "pack-main/client-mods/perf/sodium.jar", want: "perf/sodium.jar"},
+	}
+	for _, test := range tests {
+		a := &PackArchive{ModFolder: test.folder, Manifest: &PackManifest{Layout: test.layout}}
+		if got := a.ModRelPath(test.name); got != test.want {
+			t.Errorf("%s layout of %q: %s is placed at %s, want %s", test.layout, test.folder, test.name, got, test.want)
+		}
+	}
+	// packs without a manifest are flat
+	if got := (&PackArchive{}).ModRelPath("pack-main/mods/perf/sodium.jar"); got != "sodium.jar" {
+		t.Errorf("without a manifest placed at %s", got)
+	}
+}
+
+func TestValidateLayout(t *testing.T) {
+	for layout, valid := range map[string]bool{"": true, layoutFlat: true, layoutPreserve: true, "nested": false} {
+		var problems manifestProblems
+		validateLayout(layout, &problems)
+		if got := len(problems) == 0; got != valid {
+			t.Errorf("%q: problems %v", layout, problems)
+		}
+	}
+}
+
+func TestRemoveEmptyDirs(t *testing.T) {
+	mods := t.TempDir()
+	writeFiles(t, mods, map[string]string{"perf/render/sodium.jar": "sodium", "iris.jar": "iris"})
+	for _, dir := range []string{"perf/empty/deeper", "old/flat", "local"} {
+		if err := os.MkdirAll(filepath.Join(mods, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := removeEmptyDirs(mods); err != nil {
+		t.Fatal(err)
+	}
+	if got := dirNames(t, mods); got != "iris.jar perf" {
+		t.Errorf("left %s", got)
+	}
+	if got := dirNames(t, filepath.Join(mods, "perf")); got != "render" {
+		t.Errorf("left perf/%s", got)
+	}
+}
+
+func TestDuplicateModIDs(t *testing.T) {
+	mods := t.TempDir()
+	writeFiles(t, mods, map[string]string{
+		"sodium.jar":          modJar(t, "sodium", "0.5.8"),
+		"perf/sodium-2.jar":   modJar(t, "sodium", "0.5.9"),
+		"local/sodium.jar":    modJar(t, "sodium", "0.5.3"),
+		"iris.jar":            modJar(t, "iris", "1.7.0"),
+		"perf/lithium.jar":    modJar(t, "lithium", "0.11.2"),
+		"not-a-mod.jar":       "not a zip",
+		"sodium.jar.disabled": modJar(t, "sodium", "0.5.0"),
+	})
+	duplicates, err := DuplicateModIDs(mods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"sodium": {"local/sodium.jar", "perf/sodium-2.jar", "sodium.jar"}}
+	if !reflect.DeepEqual(duplicates, want) {
+		t.Errorf("duplicates %v, want %v", duplicates, want)
+	}
+}
+
+// layoutPack is a release of a pack with the mods layout given, some of
+// its mods in a subfolder.
+func layoutPack(t *testing.T, layout string) map[string]string {
+	return map[string]string{
+		"pack.json":             `{"version": "` + layout + `", "layout": "` + layout + `"}`,
+		"mods/perf/sodium.jar":  modJar(t, "sodium", "0.5.8"),
+		"mods/perf/lithium.jar": modJar(t, "lithium", "0.11.2"),
+		"mods/iris.jar":         modJar(t, "iris", "1.7.0"),
+	}
+}
+
+// TestUpdateChangesLayout updates flat, to the preserve layout and back:
+// the copies of the old layout are backed up and removed, while the
+// player's files and those on keep-lists stay, the latter keeping the
+// pack's file of their name out wherever the layout puts it.
+func TestUpdateChangesLayout(t *testing.T) {
+	useWarnings(t)
+	u := newFakeUpdate(t, layoutPack(t, layoutFlat))
+	u.run(t)
+	if got := dirNames(t, u.mods); got != "iris.jar lithium.jar sodium.jar" {
+		t.Fatalf("installed %s flat", got)
+	}
+	writeFiles(t, u.mods, map[string]string{"lithium.jar.keep": "", "local/old-sodium.jar": modJar(t, "sodium", "0.5.3")})
+
+	u.setPack(t, layoutPack(t, layoutPreserve))
+	output := readFile(t, u.run(t))
+	for _, want := range []string{
+		"The pack changes how mods are placed, from flat to preserve",
+		"lithium.jar: protected (marker)",
+		"Mod sodium is installed more than once after the layout change: local/old-sodium.jar, perf/sodium.jar",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("no %q in the output:\n%s", want, output)
+		}
+	}
+	if got := dirNames(t, u.mods); got != "iris.jar lithium.jar lithium.jar.keep local perf" {
+		t.Errorf("installed %s with preserve", got)
+	}
+	if got := dirNames(t, filepath.Join(u.mods, "perf")); got != "sodium.jar" {
+		t.Errorf("installed perf/%s with preserve", got)
+	}
+	backup := filepath.Join(u.state, backupsDirName, "20240601-120200")
+	if _, err := os.Stat(filepath.Join(backup, "sodium.jar")); err != nil {
+		t.Errorf("the flat sodium.jar wasn't backed up: %v", err)
+	}
+	state, err := ReadInstalledState(filepath.Join(u.state, "clientUpdate-installed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range state.Files {
+		names = append(names, file.Name)
+	}
+	if state.Layout != layoutPreserve || strings.Join(names, " ") != "iris.jar lithium.jar lithium.jar.keep perf/sodium.jar" {
+		t.Errorf("recorded %s layout with %q", state.Layout, names)
+	}
+
+	u.setPack(t, layoutPack(t, layoutFlat))
+	output = readFile(t, u.run(t))
+	for _, want := range []string{
+		"from preserve to flat",
+		"Mod sodium is installed more than once after the layout change: local/old-sodium.jar, sodium.jar",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("no %q in the output:\n%s", want, output)
+		}
+	}
+	if got := dirNames(t, u.mods); got != "iris.jar lithium.jar lithium.jar.keep local sodium.jar" {
+		t.Errorf("installed %s flat again", got)
+	}
+	if got := dirNames(t, filepath.Join(u.mods, "local")); got != "old-sodium.jar" {
+		t.Errorf("left local/%s", got)
+	}
+}
+
+// TestUpdateChangesLayoutProtected moves to the preserve layout while the
+// player marked a file the new layout places: the pack's copy stays out.
+func TestUpdateChangesLayoutProtected(t *testing.T) {
+	u := newFakeUpdate(t, layoutPack(t, layoutFlat))
+	u.run(t)
+	writeFiles(t, u.mods, map[string]string{"perf/sodium.jar": "my own sodium", "perf/sodium.jar.keep": ""})
+
+	u.setPack(t, layoutPack(t, layoutPreserve))
+	output := readFile(t, u.run(t))
+	if got := readFile(t, filepath.Join(u.mods, "perf", "sodium.jar")); got != "my own sodium" {
+		t.Errorf("perf/sodium.jar is %q, output:\n%s", got, output)
+	}
+	if got := dirNames(t, u.mods); got != "iris.jar perf" {
+		t.Errorf("installed %s", got)
+	}
+	if got := dirNames(t, filepath.Join(u.mods, "perf")); got != "lithium.jar sodium.jar sodium.jar.keep" {
+		t.Errorf("installed perf/%s", got)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ManifestLint is what lint-manifest found in a manifest.
+type ManifestLint struct {
+	Source   string            `json:"source"`
+	Problems []ManifestProblem `json:"problems"`
+	// FilesChecked is false for a manifest without the archive or checkout
+	// it belongs to, whose files couldn't be checked against it.
+	FilesChecked bool `json:"filesChecked"`
+}
+
+// packFile is a file of the pack, named as in its archive.
+type packFile struct {
+	name string
+	size int64
+}
+
+// LintManifest checks the manifest of src for maintainers before they
+// publish it: a pack.json, a checkout of the pack repository or a pack
+// archive, on disk or at a web address. Besides what players' updaters
+// refuse, the files the manifest refers to are checked against the
+// archive or the directory the manifest is in.
+func LintManifest(src string) (*ManifestLint, error) {
+	tmpDir, err := ioutil.TempDir("", "rxmc-lint")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	local := src
+	remote := strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "http://")
+	if remote {
+		local = filepath.Join(tmpDir, "download")
+		if err := DownloadFile(local, src); err != nil {
+			return nil, fmt.Errorf("%s: %s", src, err)
+		}
+	}
+	info, err := os.Stat(local)
+	if err != nil {
+		return nil, err
+	}
+	var content []byte
+	var files []packFile
+	switch {
+	case info.IsDir():
+		content, files, err = readPackDir(local)
+	case isZipFile(local):
+		content, files, err = readPackArchive(local)
+	default:
+		content, err = ioutil.ReadFile(local)
+		// a pack.json downloaded alone has nothing to check against
+		if err == nil && !remote {
+			_, files, err = readPackDir(filepath.Dir(local))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lint := &ManifestLint{Source: src, Problems: []ManifestProblem{}}
+	manifest, err := DecodePackManifest(content)
+	if err != nil {
+		lint.Problems = append(lint.Problems, err.(*ManifestError).Problems...)
+	}
+	if manifest != nil && files != nil {
+		lint.Problems = append(lint.Problems, crossCheckManifest(manifest, files)...)
+		lint.FilesChecked = true
+	}
+	return lint, nil
+}
+
+// isZipFile reports whether the file p starts like a zip archive.
+func isZipFile(p string) bool {
+	file, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	magic := make([]byte, 4)
+	n, _ := file.Read(magic)
+	return bytes.Equal(magic[:n], []byte("PK\x03\x04"))
+}
+
+// readPackArchive returns the manifest and the files of the pack archive
+// src.
+func readPackArchive(src string) ([]byte, []packFile, error) {
+	r, err := OpenPackArchive(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", src, err)
+	}
+	defer r.Close()
+	var content []byte
+	var files []packFile
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		files = append(files, packFile{name: f.Name, size: int64(f.UncompressedSize64)})
+		if PackPath(f.Name) != packManifestName {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		content, err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if content == nil {
+		return nil, nil, fmt.Errorf("%s has no %s", src, packManifestName)
+	}
+	return content, files, nil
+}
+
+// readPackDir returns the manifest and the files of a checkout of the pack
+// repository, named as they would be in the archive, below the directory's
+// name. Git's own files are left out.
+func readPackDir(dir string) ([]byte, []packFile, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, packManifestName))
+	if err != nil {
+		return nil, nil, err
+	}
+	var files []packFile
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, packFile{name: filepath.Base(dir) + "/" + filepath.ToSlash(rel), size: info.Size()})
+		return nil
+	})
+	return content, files, err
+}
+
+// crossCheckManifest reports what the manifest refers to that isn't so in
+// the pack's files: mods folders without mods, transformed files missing
+// or never installed, declared contents players' updates would stop at,
+// platform constraints of mods the pack doesn't have and external mods it
+// ships after all.
+func crossCheckManifest(m *PackManifest, files []packFile) []ManifestProblem {
+	var problems manifestProblems
+	present := map[string]bool{}
+	mods := map[string]bool{}
+	for _, f := range files {
+		present[PackPath(f.name)] = true
+		if strings.HasSuffix(f.name, ".jar") {
+			mods[path.Base(f.name)] = true
+		}
+	}
+
+	folders := map[string]string{}
+	for _, mc := range sortedKeys(m.Versions) {
+		folder := strings.Trim(m.Versions[mc], "/")
+		folders[folder] = folder
+		if count, _ := countModEntries(files, folder); count == 0 {
+			problems.add(keyPath("versions", mc), "the pack has no mod files in %s", folder)
+		}
+	}
+	if len(folders) == 0 {
+		folders["mods"] = ""
+	}
+	for _, folder := range sortedKeys(m.Contents) {
+		entryFolder, ok := folders[folder]
+		if !ok {
+			continue
+		}
+		declared := m.Contents[folder]
+		count, size := countModEntries(files, entryFolder)
+		if !within(int64(count), int64(declared.Files), contentsFileTolerance) || !within(size, declared.Size, contentsSizeTolerance) {
+			problems.add(keyPath("contents", folder), "declares %d files of %d bytes, the pack has %d files of %d bytes; players' updates would stop", declared.Files, declared.Size, count, size)
+		}
+	}
+
+	for _, dest := range sortedKeys(m.Transforms) {
+		if !present[dest] {
+			problems.add(keyPath("transforms", dest), "is not in the pack")
+		}
+	}
+	filtered := *m
+	filtered.Transforms = map[string]string{}
+	for dest, transform := range m.Transforms {
+		filtered.Transforms[dest] = transform
+	}
+	filtered.filterTransforms()
+	for _, ignored := range filtered.Ignored {
+		problems.add(keyPath("transforms", ignored.Path), "is never installed, it is %s", ignored.Reason)
+	}
+
+	for _, name := range sortedKeys(m.Platforms) {
+		if !mods[name] {
+			problems.add(keyPath("platforms", name), "the pack has no mod file of that name")
+		}
+	}
+	for i, mod := range m.External {
+		if mods[path.Base(mod.File)] {
+			problems.add(fieldPath(indexPath("external", i), "file"), "%s is shipped in the pack as well", path.Base(mod.File))
+		}
+	}
+	return problems
+}
+
+// countModEntries counts the mod files of folder among files, "" being the
+// legacy layout's, and their size, as an update extracting them does.
+func countModEntries(files []packFile, folder string) (count int, size int64) {
+	for _, f := range files {
+		if isModEntry(f.name, folder) {
+			count++
+			size += f.size
+		}
+	}
+	return count, size
+}
+
+// Print shows what was found for people.
+func (l *ManifestLint) Print() {
+	if len(l.Problems) == 0 {
+		fmt.Println(T("lint.ok", l.Source))
+	} else {
+		fmt.Println(T("lint.problems", l.Source, len(l.Problems)))
+		for _, problem := range l.Problems {
+			fmt.Println("  " + problem.String())
+		}
+	}
+	if !l.FilesChecked {
+		fmt.Println(T("lint.nofiles"))
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// packArchive zips the pack checkout dir as GitHub does, below a folder
+// named after the repository and commit, returning the archive's path.
+func packArchive(t *testing.T, dir string) string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		files["rxmc-Mods-0123abc/"+filepath.ToSlash(rel)] = readFile(t, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archivePath := filepath.Join(t.TempDir(), "pack.zip")
+	writeZip(t, archivePath, files)
+	return archivePath
+}
+
+// TestLintManifestFixtures lints the pack checkouts of testdata/lint, as
+// a directory, their pack.json, an archive and an archive on the web, all
+// of them finding the problems in name.golden.
+func TestLintManifestFixtures(t *testing.T) {
+	for _, name := range []string{"good", "broken"} {
+		dir := filepath.Join("testdata", "lint", name)
+		archivePath := packArchive(t, dir)
+		server := useTestServer(t, http.FileServer(http.Dir(filepath.Dir(archivePath))))
+		for _, src := range []string{dir, filepath.Join(dir, packManifestName), archivePath, server.URL + "/pack.zip"} {
+			t.Run(name+"/"+filepath.Base(src), func(t *testing.T) {
+				lint, err := LintManifest(src)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !lint.FilesChecked || lint.Source != src {
+					t.Errorf("checked the files of %s: %t", lint.Source, lint.FilesChecked)
+				}
+				checkGolden(t, filepath.Join("lint", name+".golden"), problemLines(lint.Problems))
+			})
+		}
+	}
+}
+
+// TestLintManifestAlone checks a pack.json on the web is linted without
+// the files it refers to.
+func TestLintManifestAlone(t *testing.T) {
+	server := useTestServer(t, http.FileServer(http.Dir(filepath.Join("testdata", "manifests"))))
+	lint, err := LintManifest(server.URL + "/valid.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lint.FilesChecked || len(lint.Problems) != 0 {
+		t.Errorf("linted %+v", lint)
+	}
+
+	lint, err = LintManifest(server.URL + "/values.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, filepath.Join("manifests", "values.golden"), problemLines(lint.Problems))
+}
+
+func TestLintManifestUnreadable(t *testing.T) {
+	useTestServer(t, http.NotFoundHandler())
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "pack.zip")
+	writeZip(t, archivePath, map[string]string{"rxmc-Mods-master/mods/sodium.jar": "sodium"})
+	for _, src := range []string{
+		filepath.Join(dir, "missing"),
+		// a checkout without a manifest
+		filepath.Join("testdata", "lint", "good", "config"),
+		archivePath,
+		"https://example.com/pack.zip",
+	} {
+		if lint, err := LintManifest(src); err == nil {
+			t.Errorf("%s linted: %+v", src, lint)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Loader is a mod loader the updater knows how to detect and install. Adding
+// support for another loader only requires another implementation.
+type Loader interface {
+	// Name is the value of the "loader" config setting selecting it.
+	Name() string
+	// IsVersionDir reports whether a folder in versions/ holds this loader
+	// set up for mcVersion.
+	IsVersionDir(dirName string, mcVersion string) bool
+	// Install sets the loader up for mcVersion in the minecraft directory.
+	// loaderVersion picks a specific loader release, empty meaning the
+	// latest one.
+	Install(minecraftPath string, mcVersion string, loaderVersion string) error
+	// VersionOf returns the loader release a folder accepted by
+	// IsVersionDir holds.
+	VersionOf(dirName string, mcVersion string) string
+	// MetadataFile is the file inside a mod jar describing a mod for this
+	// loader.
+	MetadataFile() string
+	// CreatesVersionJar reports whether the installer places a jar next to
+	// the version JSON.
+	CreatesVersionJar() bool
+	// CriticalMods are the mod ids nearly every pack for this loader needs.
+	CriticalMods() []string
+	// InstanceComponent is the uid of the loader's component in a MultiMC
+	// or Prism Launcher instance.
+	InstanceComponent() string
+}
+
+// metadataPrefetcher is a Loader looking metadata up when it installs, which
+// can be fetched ahead while the run does other work.
+type metadataPrefetcher interface {
+	// prefetchMetadata looks up what installing for mcVersion needs.
+	prefetchMetadata(mcVersion string) error
+}
+
+// LoaderByName returns the loader for a "loader" config value, an empty
+// name meaning Fabric. Installers are kept in cache; the Fabric installer
+// built into the updater is used when no newer one can be fetched.
+func LoaderByName(name string, cache *Cache) (Loader, error) {
+	switch strings.ToLower(name) {
+	case "", "fabric":
+		return fabricLoader{cache: cache}, nil
+	case "quilt":
+		return quiltLoader{cache: cache}, nil
+	case "neoforge":
+		return neoForgeLoader{cache: cache}, nil
+	}
+	return nil, fmt.Errorf("unknown loader %q (expected fabric, quilt or neoforge)", name)
+}
+
+// LoaderInstalled reports whether the versions directory already contains
+// the loader set up for mcVersion. Folders without their version JSON, left
+// over from a failed install, don't count.
+func LoaderInstalled(loader Loader, versionsPath string, mcVersion string) (bool, error) {
+	versions, err := ScanVersions(versionsPath)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range versions {
+		if v.HasJSON && loader.IsVersionDir(v.Name, mcVersion) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// InstalledLoaderVersion returns the newest release of the loader set up
+// for mcVersion in the versions directory, or "" when there is none.
+func InstalledLoaderVersion(loader Loader, versionsPath string, mcVersion string) string {
+	versions, err := ScanVersions(versionsPath)
+	if err != nil {
+		return ""
+	}
+	installed := ""
+	for _, v := range versions {
+		if !v.HasJSON || !loader.IsVersionDir(v.Name, mcVersion) {
+			continue
+		}
+		version := loader.VersionOf(v.Name, mcVersion)
+		if installed == "" || CompareMCVersions(version, installed) > 0 {
+			installed = version
+		}
+	}
+	return installed
+}
+
+// LoaderVersionInstalled reports whether the versions directory holds
+// release loaderVersion of the loader set up for mcVersion.
+func LoaderVersionInstalled(loader Loader, versionsPath string, mcVersion string, loaderVersion string) bool {
+	versions, err := ScanVersions(versionsPath)
+	if err != nil {
+		return false
+	}
+	for _, v := range versions {
+		if v.HasJSON && loader.IsVersionDir(v.Name, mcVersion) && loader.VersionOf(v.Name, mcVersion) == loaderVersion {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckModMetadata returns the jars in modPath that do not carry the
+// loader's metadata file, which usually means a mod built for another loader.
+func CheckModMetadata(loader Loader, modPath string) ([]string, error) {
+	entries, err := os.ReadDir(modPath)
+	if err != nil {
+		return nil, err
+	}
+	var foreign []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".jar") {
+			continue
+		}
+		r, err := zip.OpenReader(filepath.Join(modPath, entry.Name()))
+		if err != nil {
+			foreign = append(foreign, entry.Name())
+			continue
+		}
+		found := false
+		for _, f := range r.File {
+			if f.Name == loader.MetadataFile() {
+				found = true
+				break
+			}
+		}
+		r.Close()
+		if !found {
+			foreign = append(foreign, entry.Name())
+		}
+	}
+	return foreign, nil
+}
+
+// runInstaller runs an installer jar with java. Arguments are handed over
+// one by one, never through a shell, so directories containing spaces or
+// non-ASCII characters arrive intact (on Windows Go quotes each argument).
+// The installer is killed when the run is stopped.
+func runInstaller(installer string, args ...string) error {
+	cmd := exec.CommandContext(runCtx, javaCommand, append([]string{"-jar", installer}, args...)...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		Logf("%s output:\n%s", filepath.Base(installer), output)
+		if hint := DiagnoseJavaFailure(); hint != "" {
+			fmt.Println(hint)
+		}
+	}
+	return err
+}
+
+// fabricInstallerMeta lists the published Fabric installers, newest first.
+const fabricInstallerMeta = "https://meta.fabricmc.net/v2/versions/installer"
+
+// metadataTTL is how long loader metadata is reused before asking again.
+const metadataTTL = time.Hour
+
+type fabricLoader struct {
+	cache *Cache
+}
+
+func (fabricLoader) Name() string { return "fabric" }
+
+func (fabricLoader) IsVersionDir(dirName string, mcVersion string) bool {
+	v := ParseVersionDir(dirName)
+	return v.Loader == "fabric" && v.MCVersion == mcVersion
+}
+
+func (fabricLoader) VersionOf(dirName string, mcVersion string) string {
+	return ParseVersionDir(dirName).LoaderVersion
+}
+
+func (l fabricLoader) Install(minecraftPath string, mcVersion string, loaderVersion string) error {
+	args := []string{"client", "-dir", minecraftPath, "-mcversion", mcVersion}
+	if loaderVersion != "" {
+		args = append(args, "-loader", loaderVersion)
+	}
+	name, url, sum, err := l.newestInstaller()
+	if err != nil {
+		Logf("fabric: no installer from meta (%s), using the bundled %s", err, bundledFabricInstaller)
+		return l.cache.RunInstaller(bundledFabricInstallerJar, args...)
+	}
+	return l.cache.RunInstaller(InstallerJar{Name: name, URL: url, SHA256: sum}, args...)
+}
+
+func (l fabricLoader) prefetchMetadata(mcVersion string) error {
+	_, _, _, err := l.newestInstaller()
+	return err
+}
+
+// newestInstaller looks up the jar name, url and published hash of the
+// newest stable Fabric installer.
+func (l fabricLoader) newestInstaller() (name string, url string, sum string, err error) {
+	content, err := l.cache.Metadata("fabric-installers.json", fabricInstallerMeta, metadataTTL)
+	if err != nil {
+		return "", "", "", err
+	}
+	var installers []struct {
+		URL     string `json:"url"`
+		Version string `json:"version"`
+		Stable  bool   `json:"stable"`
+	}
+	if err := json.Unmarshal(content, &installers); err != nil {
+		return "", "", "", err
+	}
+	for _, installer := range installers {
+		if !installer.Stable {
+			continue
+		}
+		name := "fabric-installer-" + installer.Version + ".jar"
+		sum, err := l.cache.publishedSHA256(name, installer.URL)
+		return name, installer.URL, sum, err
+	}
+	return "", "", "", fmt.Errorf("no stable Fabric installer published")
+}
+
+func (fabricLoader) MetadataFile() string { return "fabric.mod.json" }
+
+func (fabricLoader) CreatesVersionJar() bool { return true }
+
+func (fabricLoader) CriticalMods() []string { return []string{"fabric-api"} }
+
+func (fabricLoader) InstanceComponent() string { return "net.fabricmc.fabric-loader" }
+
+// quiltInstallerURL always points at the newest Quilt installer.
+const quiltInstallerURL = "https://maven.quiltmc.org/repository/release/org/quiltmc/quilt-installer/latest/quilt-installer-latest.jar"
+
+type quiltLoader struct {
+	cache *Cache
+}
+
+func (quiltLoader) Name() string { return "quilt" }
+
+func (quiltLoader) IsVersionDir(dirName string, mcVersion string) bool {
+	v := ParseVersionDir(dirName)
+	return v.Loader == "quilt" && v.MCVersion == mcVersion
+}
+
+func (quiltLoader) VersionOf(dirName string, mcVersion string) string {
+	return ParseVersionDir(dirName).LoaderVersion
+}
+
+func (l quiltLoader) Install(minecraftPath string, mcVersion string, loaderVersion string) error {
+	name := "quilt-installer-latest.jar"
+	sum, err := l.cache.publishedSHA256(name, quiltInstallerURL)
+	if err != nil {
+		Logf("quilt: no published installer hash: %s", err)
+	}
+	args := []string{"install", "client", mcVersion}
+	if loaderVersion != "" {
+		args = append(args, loaderVersion)
+	}
+	return l.cache.RunInstaller(InstallerJar{Name: name, URL: quiltInstallerURL, SHA256: sum}, append(args, "--install-dir="+minecraftPath)...)
+}
+
+func (quiltLoader) MetadataFile() string { return "quilt.mod.json" }
+
+func (quiltLoader) CreatesVersionJar() bool { return true }
+
+func (quiltLoader) CriticalMods() []string { return []string{"quilted_fabric_api"} }
+
+func (quiltLoader) InstanceComponent() string { return "org.quiltmc.quilt-loader" }
+
+// neoForgeMaven is where NeoForge publishes its installers.
+const neoForgeMaven = "https://maven.neoforged.net/releases/net/neoforged/neoforge/"
+
+type neoForgeLoader struct {
+	cache *Cache
+}
+
+func (neoForgeLoader) Name() string { return "neoforge" }
+
+// IsVersionDir matches the neoforge-<version> folders the installer creates.
+// NeoForge versions drop the leading "1." of the Minecraft version, so
+// 21.1.x is built for Minecraft 1.21.1.
+func (neoForgeLoader) IsVersionDir(dirName string, mcVersion string) bool {
+	v := ParseVersionDir(dirName)
+	return v.Loader == "neoforge" && v.MCVersion == mcVersion
+}
+
+func (neoForgeLoader) VersionOf(dirName string, mcVersion string) string {
+	return ParseVersionDir(dirName).LoaderVersion
+}
+
+func (l neoForgeLoader) Install(minecraftPath string, mcVersion string, loaderVersion string) error {
+	version := loaderVersion
+	if version == "" {
+		var err error
+		if version, err = l.latest(mcVersion); err != nil {
+			return err
+		}
+	}
+	name, url := neoForgeInstaller(version)
+	sum, err := l.cache.publishedSHA256(name, url)
+	if err != nil {
+		Logf("neoforge: no published installer hash: %s", err)
+	}
+	return l.cache.RunInstaller(InstallerJar{Name: name, URL: url, SHA256: sum}, "--installClient", minecraftPath)
+}
+
+func (l neoForgeLoader) prefetchMetadata(mcVersion string) error {
+	version, err := l.latest(mcVersion)
+	if err != nil {
+		return err
+	}
+	_, err = l.cache.publishedSHA256(neoForgeInstaller(version))
+	return err
+}
+
+// neoForgeInstaller returns the jar name and url of the installer of a
+// NeoForge release.
+func neoForgeInstaller(version string) (name string, url string) {
+	name = "neoforge-" + version + "-installer.jar"
+	return name, neoForgeMaven + version + "/" + name
+}
+
+func (neoForgeLoader) MetadataFile() string { return "META-INF/neoforge.mods.toml" }
+
+func (neoForgeLoader) CreatesVersionJar() bool { return false }
+
+func (neoForgeLoader) CriticalMods() []string { return nil }
+
+func (neoForgeLoader) InstanceComponent() string { return "net.neoforged" }
+
+// neoForgePrefix turns a Minecraft version into the prefix of matching
+// NeoForge versions, e.g. 1.21.1 into "21.1." and 1.21 into "21.0.".
+func neoForgePrefix(mcVersion string) string {
+	parts := strings.Split(strings.TrimPrefix(mcVersion, "1."), ".")
+	if len(parts) == 1 {
+		parts = append(parts, "0")
+	}
+	return parts[0] + "." + parts[1] + "."
+}
+
+// latest looks up the newest stable NeoForge release for mcVersion.
+func (l neoForgeLoader) latest(mcVersion string) (string, error) {
+	content, err := l.cache.Metadata("neoforge-maven-metadata.xml", neoForgeMaven+"maven-metadata.xml", metadataTTL)
+	if err != nil {
+		return "", err
+	}
+
+	var metadata struct {
+		Versions []string `xml:"versioning>versions>version"`
+	}
+	if err := xml.Unmarshal(content, &metadata); err != nil {
+		return "", err
+	}
+	latest := ""
+	for _, v := range metadata.Versions {
+		if !strings.HasPrefix(v, neoForgePrefix(mcVersion)) || strings.Contains(v, "beta") {
+			continue
+		}
+		if latest == "" || CompareMCVersions(v, latest) > 0 {
+			latest = v
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no NeoForge release found for Minecraft %s", mcVersion)
+	}
+	return latest, nil
+}
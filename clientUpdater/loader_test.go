@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fixtureVersions is a versions directory holding vanilla, Fabric, Quilt
+// and NeoForge installs, a Fabric install for 1.21 that failed before
+// writing its JSON, and a stray file.
+var fixtureVersions = filepath.Join("testdata", "versions")
+
+func TestLoaderByName(t *testing.T) {
+	tests := map[string]string{
+		"":         "fabric",
+		"fabric":   "fabric",
+		"Quilt":    "quilt",
+		"NEOFORGE": "neoforge",
+	}
+	for setting, want := range tests {
+		loader, err := LoaderByName(setting, nil)
+		if err != nil || loader.Name() != want {
+			t.Errorf("LoaderByName(%q) = %v, %v, want %s", setting, loader, err, want)
+		}
+	}
+	if _, err := LoaderByName("forge", nil); err == nil || !strings.Contains(err.Error(), "fabric, quilt or neoforge") {
+		t.Errorf("forge: %v", err)
+	}
+}
+
+func TestLoaderDetection(t *testing.T) {
+	tests := []struct {
+		loader    Loader
+		mcVersion string
+		installed string
+	}{
+		{fabricLoader{}, "1.20.1", "0.16.5"},
+		// the folder without its JSON doesn't count
+		{fabricLoader{}, "1.21", ""},
+		{fabricLoader{}, "1.20.4", ""},
+		{quiltLoader{}, "1.20.1", "0.26.0-beta.1"},
+		{quiltLoader{}, "1.21", ""},
+		{neoForgeLoader{}, "1.21.1", "21.1.77"},
+		{neoForgeLoader{}, "1.21", "21.0.10"},
+		{neoForgeLoader{}, "1.20.4", "20.4.237"},
+		{neoForgeLoader{}, "1.20.1", ""},
+	}
+	for _, test := range tests {
+		installed, err := LoaderInstalled(test.loader, fixtureVersions, test.mcVersion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if installed != (test.installed != "") {
+			t.Errorf("%s for %s installed: %t", test.loader.Name(), test.mcVersion, installed)
+		}
+		if got := InstalledLoaderVersion(test.loader, fixtureVersions, test.mcVersion); got != test.installed {
+			t.Errorf("%s for %s is release %q, want %q", test.loader.Name(), test.mcVersion, got, test.installed)
+		}
+	}
+	if !LoaderVersionInstalled(fabricLoader{}, fixtureVersions, "1.20.1", "0.15.11") || LoaderVersionInstalled(fabricLoader{}, fixtureVersions, "1.20.1", "0.16.9") {
+		t.Error("the releases of Fabric installed for 1.20.1 are not 0.15.11 and 0.16.5")
+	}
+	if _, err := LoaderInstalled(fabricLoader{}, filepath.Join(t.TempDir(), "versions"), "1.20.1"); err == nil {
+		t.Error("a missing versions directory was scanned")
+	}
+}
+
+func TestCheckModMetadata(t *testing.T) {
+	mods := t.TempDir()
+	writeZip(t, filepath.Join(mods, "fabric-mod.jar"), map[string]string{"fabric.mod.json": "{}", "a/A.class": ""})
+	writeZip(t, filepath.Join(mods, "quilt-mod.jar"), map[string]string{"quilt.mod.json": "{}"})
+	writeZip(t, filepath.Join(mods, "neoforge-mod.jar"), map[string]string{"META-INF/neoforge.mods.toml": ""})
+	writeZip(t, filepath.Join(mods, "both.jar"), map[string]string{"fabric.mod.json": "{}", "META-INF/neoforge.mods.toml": ""})
+	writeFiles(t, mods, map[string]string{
+		"broken.jar":       "not a zip",
+		"readme.txt":       "not a mod",
+		"sub/inner.jar":    "not looked at",
+		"fabric.mod.json":  "not a jar",
+		"not-a-jar.jar.gz": "",
+	})
+	tests := map[Loader]string{
+		fabricLoader{}:   "broken.jar neoforge-mod.jar quilt-mod.jar",
+		quiltLoader{}:    "both.jar broken.jar fabric-mod.jar neoforge-mod.jar",
+		neoForgeLoader{}: "broken.jar fabric-mod.jar quilt-mod.jar",
+	}
+	for loader, want := range tests {
+		foreign, err := CheckModMetadata(loader, mods)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(foreign, " "); got != want {
+			t.Errorf("%s: foreign %s, want %s", loader.Name(), got, want)
+		}
+	}
+}
+
+func TestNeoForgeVersions(t *testing.T) {
+	for mc, want := range map[string]string{"1.21.1": "21.1.", "1.21": "21.0.", "1.20.4": "20.4."} {
+		if got := neoForgePrefix(mc); got != want {
+			t.Errorf("neoForgePrefix(%s) = %s, want %s", mc, got, want)
+		}
+		if got := neoForgeMCVersion(want + "57"); got != mc {
+			t.Errorf("neoForgeMCVersion(%s57) = %s, want %s", want, got, mc)
+		}
+	}
+	name, url := neoForgeInstaller("21.1.77")
+	if name != "neoforge-21.1.77-installer.jar" || url != neoForgeMaven+"21.1.77/neoforge-21.1.77-installer.jar" {
+		t.Errorf("installer %s at %s", name, url)
+	}
+}
+
+func TestNeoForgeLatest(t *testing.T) {
+	useFakeClock(t)
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/releases/net/neoforged/neoforge/maven-metadata.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`<metadata><versioning><versions>
+			<version>21.0.10</version>
+			<version>21.1.8</version>
+			<version>21.1.77</version>
+			<version>21.1.9</version>
+			<version>21.1.80-beta</version>
+			<version>21.10.1</version>
+		</versions></versioning></metadata>`))
+	}))
+	loader := neoForgeLoader{cache: &Cache{Dir: t.TempDir()}}
+	for mc, want := range map[string]string{"1.21.1": "21.1.77", "1.21": "21.0.10", "1.21.10": "21.10.1"} {
+		if got, err := loader.latest(mc); err != nil || got != want {
+			t.Errorf("latest for %s: %s, %v, want %s", mc, got, err, want)
+		}
+	}
+	if got, err := loader.latest("1.20.4"); err == nil {
+		t.Errorf("latest for 1.20.4: %s", got)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import "strings"
+
+// mcSeriesSuffix ends the Minecraft versions of loaderVersions matching a
+// whole series: "1.21.x" is 1.21 and every 1.21.n.
+const mcSeriesSuffix = ".x"
+
+// validateLoaderVersions reports loaderVersions entries that can't be
+// meant: keys must be Minecraft versions or series, values exact loader
+// releases, as the installers take nothing else.
+func validateLoaderVersions(mapping map[string]string, problems *manifestProblems) {
+	for _, mc := range sortedKeys(mapping) {
+		loader := mapping[mc]
+		version := strings.TrimSuffix(mc, mcSeriesSuffix)
+		if !mcVersionPattern.MatchString(version) && !mcVersionPattern.MatchString(version+".0") {
+			problems.add(keyPath("loaderVersions", mc), "%q is not a Minecraft version or series like 1.21.x", mc)
+		}
+		if loader == "" || strings.ContainsAny(loader, "x*") {
+			problems.add(keyPath("loaderVersions", mc), "must be a loader release like 0.16.5, not %q", loader)
+		}
+	}
+}
+
+// ResolveLoaderVersion returns the loader release mapping requires on
+// mcVersion, "" when it requires none. An exact entry wins over a series
+// and a longer series over a shorter one, so with "1.21.x" and "1.x"
+// 1.21.4 gets the release of "1.21.x".
+func ResolveLoaderVersion(mapping map[string]string, mcVersion string) string {
+	if loader, ok := mapping[mcVersion]; ok {
+		return loader
+	}
+	best, bestLen := "", -1
+	for mc, loader := range mapping {
+		if !strings.HasSuffix(mc, mcSeriesSuffix) {
+			continue
+		}
+		series := strings.TrimSuffix(mc, mcSeriesSuffix)
+		if mcVersion != series && !strings.HasPrefix(mcVersion, series+".") {
+			continue
+		}
+		if len(series) > bestLen {
+			best, bestLen = loader, len(series)
+		}
+	}
+	return best
+}
+
+// SelectLoaderVersion picks the loader release to install from the one
+// the pack requires and the one the config pins, either empty for none.
+// The pack's wins a conflict, it knows what its mods need, unless force
+// is set; either way the conflict is warned about.
+func SelectLoaderVersion(required string, pinned string, force bool) string {
+	switch {
+	case required == "":
+		return pinned
+	case pinned == "" || pinned == required:
+		return required
+	case force:
+		Warn(warnLoader, T("loader.pin.forced", pinned, required))
+		return pinned
+	}
+	Warn(warnLoader, T("loader.pin.conflict", pinned, required, required))
+	return required
+}
+
+// LoaderVersion returns the loader release the pack requires on
+// mcVersion, "" when it requires none.
+func (a *PackArchive) LoaderVersion(mcVersion string) string {
+	if a.Manifest == nil {
+		return ""
+	}
+	return ResolveLoaderVersion(a.Manifest.LoaderVersions, mcVersion)
+}
+
+// NeedsLoaderInstall reports whether the loader has to be installed into
+// versionsPath: installed tells whether it is set up for mcVersion at all,
+// and when loaderVersion is required that release must be among them.
+func NeedsLoaderInstall(loader Loader, versionsPath string, mcVersion string, loaderVersion string, installed bool) bool {
+	if !installed {
+		return true
+	}
+	if loaderVersion != "" && !LoaderVersionInstalled(loader, versionsPath, mcVersion, loaderVersion) {
+		Logf("%s %s is not installed for minecraft %s", loader.Name(), loaderVersion, mcVersion)
+		return true
+	}
+	return false
+}
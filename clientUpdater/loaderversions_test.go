@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveLoaderVersion(t *testing.T) {
+	mapping := map[string]string{
+		"1.20.4": "0.15.11",
+		"1.20.x": "0.15.7",
+		"1.21.x": "0.16.5",
+		"1.21.1": "0.16.2",
+		"1.x":    "0.14.0",
+	}
+	tests := []struct {
+		mapping   map[string]string
+		mcVersion string
+		want      string
+	}{
+		// an exact entry wins over the series holding it
+		{mapping, "1.20.4", "0.15.11"},
+		{mapping, "1.21.1", "0.16.2"},
+		{mapping, "1.20.1", "0.15.7"},
+		// a series holds its first release, without a patch number
+		{mapping, "1.21", "0.16.5"},
+		{mapping, "1.21.4", "0.16.5"},
+		// a longer series wins over a shorter one
+		{mapping, "1.19.2", "0.14.0"},
+		{mapping, "1.19", "0.14.0"},
+		// a series holds whole versions only, 1.2.x isn't 1.21
+		{map[string]string{"1.2.x": "0.9.0"}, "1.21", ""},
+		{mapping, "2.0", ""},
+		{nil, "1.20.1", ""},
+	}
+	for _, test := range tests {
+		if got := ResolveLoaderVersion(test.mapping, test.mcVersion); got != test.want {
+			t.Errorf("%s resolved to %q, want %q", test.mcVersion, got, test.want)
+		}
+	}
+}
+
+func TestSelectLoaderVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		required string
+		pinned   string
+		force    bool
+		want     string
+		warning  string
+	}{
+		{name: "nothing", want: ""},
+		{name: "pinned", pinned: "0.15.11", want: "0.15.11"},
+		{name: "required", required: "0.16.5", want: "0.16.5"},
+		{name: "agreeing", required: "0.16.5", pinned: "0.16.5", want: "0.16.5"},
+		{name: "conflict", required: "0.16.5", pinned: "0.15.11", want: "0.16.5", warning: "The config pins loader release 0.15.11, but the pack needs 0.16.5 on this Minecraft version; installing 0.16.5."},
+		{name: "forced", required: "0.16.5", pinned: "0.15.11", force: true, want: "0.15.11", warning: "Installing loader release 0.15.11 as the config pins it, although the pack needs 0.16.5"},
+		{name: "forced agreeing", required: "0.16.5", pinned: "0.16.5", force: true, want: "0.16.5"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useWarnings(t)
+			if got := SelectLoaderVersion(test.required, test.pinned, test.force); got != test.want {
+				t.Errorf("selected %q, want %q", got, test.want)
+			}
+			warned := warningMessages(warnLoader)
+			if test.warning == "" {
+				if len(warned) > 0 {
+					t.Errorf("warned %q", warned)
+				}
+				return
+			}
+			if len(warned) != 1 || !strings.HasPrefix(warned[0], test.warning) {
+				t.Errorf("warned %q, want %q", warned, test.warning)
+			}
+		})
+	}
+}
+
+func TestNeedsLoaderInstall(t *testing.T) {
+	versions := filepath.Join(t.TempDir(), "versions")
+	writeFiles(t, versions, map[string]string{
+		"1.20.1/1.20.1.json": "{}",
+		"fabric-loader-0.15.11-1.20.1/fabric-loader-0.15.11-1.20.1.json": "{}",
+		// a version directory without its json is an install that failed
+		"fabric-loader-0.16.5-1.20.1/fabric-loader-0.16.5-1.20.1.jar": "",
+		"fabric-loader-0.16.5-1.21/fabric-loader-0.16.5-1.21.json":    "{}",
+	})
+	tests := []struct {
+		loaderVersion string
+		installed     bool
+		want          bool
+	}{
+		{"", false, true},
+		{"", true, false},
+		{"0.15.11", true, false},
+		{"0.16.5", true, true},
+		{"0.14.0", true, true},
+	}
+	for _, test := range tests {
+		if got := NeedsLoaderInstall(fabricLoader{}, versions, "1.20.1", test.loaderVersion, test.installed); got != test.want {
+			t.Errorf("%q, installed %t: needs an install %t", test.loaderVersion, test.installed, got)
+		}
+	}
+}
+
+// TestUpdateInstallsRequiredLoader updates with a pack requiring another
+// loader release than the one installed, once with the config pinning the
+// installed one and once forcing it.
+func TestUpdateInstallsRequiredLoader(t *testing.T) {
+	manifest := `{"versions": {"1.20.1": "mods"}, "loaderVersions": {"1.20.x": "0.16.5", "1.21.x": "0.16.9"}}`
+	tests := []struct {
+		name    string
+		pinned  string
+		args    []string
+		install string
+		warning string
+	}{
+		{name: "required", install: "0.16.5"},
+		{name: "pinned", pinned: "0.15.11", install: "0.16.5", warning: "The config pins loader release 0.15.11"},
+		{name: "forced", pinned: "0.15.11", args: []string{"--force-loader"}, warning: "Installing loader release 0.15.11 as the config pins it"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useWarnings(t)
+			record := useFakeJava(t)
+			u := newFakeUpdate(t, map[string]string{"pack.json": manifest, "mods/sodium.jar": "sodium"})
+			if test.pinned != "" {
+				if err := os.MkdirAll(u.state, 0755); err != nil {
+					t.Fatal(err)
+				}
+				SaveConfig(ConfFile{MCDirectory: u.mods, MCVersion: "1.20.1", LoaderVersion: test.pinned}, filepath.Join(u.state, "clientUpdate.json"))
+			}
+			output := readFile(t, u.run(t, test.args...))
+			if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium" {
+				t.Fatalf("sodium.jar is %q, output:\n%s", got, output)
+			}
+			content, err := os.ReadFile(record)
+			if test.install == "" {
+				if !os.IsNotExist(err) {
+					t.Errorf("the installer was run: %s, %v", content, err)
+				}
+			} else {
+				var args []string
+				if err := json.Unmarshal(content, &args); err != nil {
+					t.Fatalf("%s, output:\n%s", err, output)
+				}
+				if got := strings.Join(args[2:], " "); got != "client -dir "+u.minecraft+" -mcversion 1.20.1 -loader "+test.install {
+					t.Errorf("installer run with %s", got)
+				}
+			}
+			warned := warningMessages(warnLoader)
+			if test.warning == "" && len(warned) > 0 || test.warning != "" && (len(warned) != 1 || !strings.HasPrefix(warned[0], test.warning)) {
+				t.Errorf("warned %q", warned)
+			}
+
+			// once installed, the required release isn't installed again
+			if test.install == "" {
+				return
+			}
+			if err := os.Remove(record); err != nil {
+				t.Fatal(err)
+			}
+			u.run(t, test.args...)
+			if _, err := os.Stat(record); !os.IsNotExist(err) {
+				t.Errorf("the installer was run again: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// SystemLocale returns the locale from the environment, e.g. "de_DE.UTF-8".
+func SystemLocale() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if locale := os.Getenv(name); locale != "" {
+			return locale
+		}
+	}
+	return ""
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package main
+
+import "testing"
+
+func TestSystemLocale(t *testing.T) {
+	t.Setenv("LC_ALL", "")
+	t.Setenv("LC_MESSAGES", "")
+	t.Setenv("LANG", "")
+	if got := SystemLocale(); got != "" {
+		t.Errorf("without a locale: %q", got)
+	}
+	t.Setenv("LANG", "es_ES.UTF-8")
+	if got := SystemLocale(); got != "es_ES.UTF-8" {
+		t.Errorf("LANG: %q", got)
+	}
+	// LC_ALL and LC_MESSAGES override LANG, as for every program
+	t.Setenv("LC_MESSAGES", "de_AT.UTF-8")
+	if got := SystemLocale(); got != "de_AT.UTF-8" {
+		t.Errorf("LC_MESSAGES: %q", got)
+	}
+	t.Setenv("LC_ALL", "de_DE.UTF-8")
+	if got := SystemLocale(); got != "de_DE.UTF-8" {
+		t.Errorf("LC_ALL: %q", got)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// localeNameMaxLength is LOCALE_NAME_MAX_LENGTH from winnls.h.
+const localeNameMaxLength = 85
+
+// SystemLocale returns the user's default locale, e.g. "de-DE".
+func SystemLocale() string {
+	kernel32 := syscall.NewLazyDLL("kernel32.dll")
+	getUserDefaultLocaleName := kernel32.NewProc("GetUserDefaultLocaleName")
+	if getUserDefaultLocaleName.Find() != nil {
+		return ""
+	}
+	buf := make([]uint16, localeNameMaxLength)
+	n, _, _ := getUserDefaultLocaleName.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	if n == 0 {
+		return ""
+	}
+	return syscall.UTF16ToString(buf)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// LockModsDir makes the mods directory and every file in it read-only, so
+// jars can't be added or replaced by accident between updates. On Windows
+// this sets the read-only attribute, elsewhere it removes the write
+// permission.
+func LockModsDir(dir string) error {
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		return os.Chmod(p, info.Mode().Perm()&^0222)
+	})
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(dir, info.Mode().Perm()&^0222)
+}
+
+// UnlockModsDir undoes LockModsDir. The directory is made writable first,
+// so its files can then be changed.
+func UnlockModsDir(dir string) error {
+	if err := os.Chmod(dir, dirPerm); err != nil {
+		return err
+	}
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.Mode().Perm()&0200 != 0 {
+			return err
+		}
+		return os.Chmod(p, info.Mode().Perm()|0200)
+	})
+}
+
+// ModsDirLocked reports whether the mods directory was left read-only by
+// LockModsDir.
+func ModsDirLocked(dir string) bool {
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir() && info.Mode().Perm()&0200 == 0
+}
+
+// unlockForUpdate unlocks a locked mods directory before it is changed.
+// It returns an error only when the directory stays locked.
+func unlockForUpdate(dir string) error {
+	if !ModsDirLocked(dir) {
+		return nil
+	}
+	Logf("unlocking %s", dir)
+	return UnlockModsDir(dir)
+}
+
+// relockAfterUpdate locks the mods directory again when the config asks
+// for it. Failing to is reported but never fails the update.
+func relockAfterUpdate(dir string, lock bool) {
+	if !lock {
+		return
+	}
+	if err := LockModsDir(dir); err != nil {
+		Logf("locking %s: %s", dir, err)
+		Warn(warnLock, T("lock.failed", dir, err))
+		return
+	}
+	Logf("locked %s", dir)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// checkWritable fails unless every file below dir, and dir itself, is
+// writable as wanted. The folders below it are never locked.
+func checkWritable(t *testing.T, dir string, writable bool) {
+	t.Helper()
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && p != dir {
+			return nil
+		}
+		if got := info.Mode().Perm()&0200 != 0; got != writable {
+			t.Errorf("%s is %v", p, info.Mode())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestLockModsDirRoundTrip locks a mods directory and unlocks it again:
+// the permissions on Unix, the read-only attribute on Windows.
+func TestLockModsDirRoundTrip(t *testing.T) {
+	mods := filepath.Join(t.TempDir(), "mods")
+	writeFiles(t, mods, map[string]string{
+		"sodium.jar":        "sodium",
+		"local/minimap.jar": "minimap",
+	})
+	if ModsDirLocked(mods) {
+		t.Fatal("locked before LockModsDir")
+	}
+	t.Cleanup(func() { UnlockModsDir(mods) })
+	if err := LockModsDir(mods); err != nil {
+		t.Fatal(err)
+	}
+	if !ModsDirLocked(mods) {
+		t.Error("not locked after LockModsDir")
+	}
+	checkWritable(t, mods, false)
+	if runtime.GOOS == "windows" || os.Geteuid() != 0 {
+		if err := os.WriteFile(filepath.Join(mods, "sodium.jar"), []byte("replaced"), 0644); err == nil {
+			t.Error("replaced a jar of the locked directory")
+		}
+	}
+	// locking twice keeps it locked
+	if err := LockModsDir(mods); err != nil || !ModsDirLocked(mods) {
+		t.Errorf("locking again: %v", err)
+	}
+
+	if err := UnlockModsDir(mods); err != nil {
+		t.Fatal(err)
+	}
+	if ModsDirLocked(mods) {
+		t.Error("locked after UnlockModsDir")
+	}
+	checkWritable(t, mods, true)
+	if err := os.WriteFile(filepath.Join(mods, "sodium.jar"), []byte("replaced"), 0644); err != nil {
+		t.Error(err)
+	}
+	if err := os.RemoveAll(mods); err != nil {
+		t.Errorf("removing the unlocked directory: %v", err)
+	}
+}
+
+func TestUnlockForUpdate(t *testing.T) {
+	mods := filepath.Join(t.TempDir(), "mods")
+	writeFiles(t, mods, map[string]string{"sodium.jar": "sodium"})
+	if err := unlockForUpdate(mods); err != nil || ModsDirLocked(mods) {
+		t.Errorf("unlocked directory: %v", err)
+	}
+	if err := unlockForUpdate(filepath.Join(t.TempDir(), "missing")); err != nil {
+		t.Errorf("missing directory: %v", err)
+	}
+	LockModsDir(mods)
+	if err := unlockForUpdate(mods); err != nil || ModsDirLocked(mods) {
+		t.Errorf("locked directory: %v", err)
+	}
+	checkWritable(t, mods, true)
+}
+
+// TestRelockAfterUpdateFails checks failing to lock only warns.
+func TestRelockAfterUpdateFails(t *testing.T) {
+	var warned bytes.Buffer
+	defer PrintWarningsTo(&warned)()
+	missing := filepath.Join(t.TempDir(), "missing")
+	relockAfterUpdate(missing, false)
+	if warned.Len() != 0 {
+		t.Errorf("warned without locking: %s", warned.String())
+	}
+	relockAfterUpdate(missing, true)
+	if !strings.Contains(warned.String(), "could not make "+missing+" read-only again") {
+		t.Errorf("warned %q", warned.String())
+	}
+}
+
+// TestUpdateLockedModsDir updates a mods directory the config keeps
+// locked twice, then rolls the last update back: the directory is locked
+// after each of them.
+func TestUpdateLockedModsDir(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 1"})
+	if err := os.MkdirAll(u.state, 0755); err != nil {
+		t.Fatal(err)
+	}
+	SaveConfig(ConfFile{MCDirectory: u.mods, LockModsDir: true}, filepath.Join(u.state, "clientUpdate.json"))
+	// registered after the temporary directory, so it runs before that is
+	// removed
+	t.Cleanup(func() { UnlockModsDir(u.mods) })
+
+	output := u.run(t)
+	if !ModsDirLocked(u.mods) || readFile(t, filepath.Join(u.mods, "sodium.jar")) != "sodium 1" {
+		t.Fatalf("first update, output:\n%s", readFile(t, output))
+	}
+	checkWritable(t, u.mods, false)
+
+	u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 2", "mods/iris.jar": "iris"})
+	output = u.run(t)
+	if !strings.Contains(readFile(t, output), "locked, it is unlocked for the update") {
+		t.Errorf("the summary doesn't tell about the lock:\n%s", readFile(t, output))
+	}
+	if got := dirNames(t, u.mods); got != "iris.jar sodium.jar" || readFile(t, filepath.Join(u.mods, "sodium.jar")) != "sodium 2" {
+		t.Fatalf("second update installed %s, output:\n%s", got, readFile(t, output))
+	}
+	checkWritable(t, u.mods, false)
+
+	output = u.run(t, "rollback")
+	if got := dirNames(t, u.mods); got != "sodium.jar" || readFile(t, filepath.Join(u.mods, "sodium.jar")) != "sodium 1" {
+		t.Fatalf("rolled back to %s, output:\n%s", got, readFile(t, output))
+	}
+	if !ModsDirLocked(u.mods) {
+		t.Error("unlocked by the rollback")
+	}
+	checkWritable(t, u.mods, false)
+}
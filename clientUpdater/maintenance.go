@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultMaintenanceKeep is how many of the newest files of each folder
+// are kept however old they are, so the last crash can still be reported.
+const defaultMaintenanceKeep = 5
+
+// Maintenance is the housekeeping done after a successful update. Every
+// task is off unless its age is set.
+type Maintenance struct {
+	// CleanLogsOlderThanDays removes files of logs/ older than this.
+	CleanLogsOlderThanDays int `json:"cleanLogsOlderThanDays,omitempty"`
+	// CleanCrashReportsOlderThanDays removes files of crash-reports/ older
+	// than this.
+	CleanCrashReportsOlderThanDays int `json:"cleanCrashReportsOlderThanDays,omitempty"`
+	// Keep is how many of the newest files of each folder are never
+	// removed, 5 by default.
+	Keep int `json:"keep,omitempty"`
+	// KeepBackups is how many of the newest update backups are kept, all
+	// of them when 0. The newest verified one is always kept.
+	KeepBackups int `json:"keepBackups,omitempty"`
+}
+
+// maintenanceFolders are the only folders maintenance ever touches.
+var maintenanceFolders = map[string]bool{"logs": true, "crash-reports": true}
+
+// Enabled reports whether any task is switched on.
+func (m Maintenance) Enabled() bool {
+	return m.CleanLogsOlderThanDays > 0 || m.CleanCrashReportsOlderThanDays > 0
+}
+
+// Run carries out the enabled tasks in the minecraft directory, returning
+// the number of files removed and the bytes freed. Removals are journaled,
+// without a backup since freeing the space is the point.
+func (m Maintenance) Run(minecraftPath string, journal *Journal) (removed int, freed int64) {
+	keep := m.Keep
+	if keep <= 0 {
+		keep = defaultMaintenanceKeep
+	}
+	tasks := []struct {
+		folder string
+		days   int
+	}{
+		{"logs", m.CleanLogsOlderThanDays},
+		{"crash-reports", m.CleanCrashReportsOlderThanDays},
+	}
+	for _, task := range tasks {
+		if task.days <= 0 {
+			continue
+		}
+		n, size, err := CleanOldFiles(minecraftPath, task.folder, time.Duration(task.days)*24*time.Hour, keep, journal)
+		if err != nil {
+			Logf("maintenance: %s: %s", task.folder, err)
+		}
+		removed += n
+		freed += size
+	}
+	return removed, freed
+}
+
+// CleanOldFiles removes the files directly in folder of the minecraft
+// directory that are older than maxAge, except the keep newest ones.
+// folder must be one of the maintenance folders, and neither it nor the
+// files may be symlinks, so nothing outside it is ever removed.
+func CleanOldFiles(minecraftPath string, folder string, maxAge time.Duration, keep int, journal *Journal) (removed int, freed int64, err error) {
+	if !maintenanceFolders[folder] {
+		return 0, 0, fmt.Errorf("%s is not a maintenance folder", folder)
+	}
+	if !looksLikeGameDir(minecraftPath) {
+		return 0, 0, fmt.Errorf("%s is not a minecraft directory", minecraftPath)
+	}
+	dir := filepath.Join(minecraftPath, folder)
+	info, err := os.Lstat(dir)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+	if !info.IsDir() {
+		return 0, 0, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var files []os.FileInfo
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files = append(files, info)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+
+	now := clock.Now()
+	for i, file := range files {
+		if i < keep || now.Sub(file.ModTime()) < maxAge {
+			continue
+		}
+		p := filepath.Join(dir, file.Name())
+		if rel, err := filepath.Rel(dir, p); err != nil || strings.ContainsAny(rel, `/\`) {
+			continue
+		}
+		if err := os.Remove(p); err != nil {
+			Logf("maintenance: removing %s: %s", p, err)
+			continue
+		}
+		if err := journal.Record(JournalEntry{Action: journalDelete, Path: p}); err != nil {
+			return removed, freed, err
+		}
+		removed++
+		freed += file.Size()
+	}
+	return removed, freed, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+func TestCleanOldFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]time.Duration
+		maxAge  time.Duration
+		keep    int
+		left    string
+		removed int
+	}{
+		{
+			name:    "old ones removed",
+			files:   map[string]time.Duration{"latest.log": 0, "2024-05-30-1.log.gz": 2 * day, "2024-05-01-1.log.gz": 31 * day, "2024-04-01-1.log.gz": 61 * day},
+			maxAge:  30 * day,
+			left:    "2024-05-30-1.log.gz latest.log",
+			removed: 2,
+		},
+		{
+			name:    "newest kept however old",
+			files:   map[string]time.Duration{"a.log.gz": 40 * day, "b.log.gz": 50 * day, "c.log.gz": 60 * day},
+			maxAge:  30 * day,
+			keep:    2,
+			left:    "a.log.gz b.log.gz",
+			removed: 1,
+		},
+		{
+			name:   "all within the age",
+			files:  map[string]time.Duration{"a.log.gz": day, "b.log.gz": 29 * day},
+			maxAge: 30 * day,
+			left:   "a.log.gz b.log.gz",
+		},
+		{
+			name:    "folders left alone",
+			files:   map[string]time.Duration{"old/a.log.gz": 90 * day, "b.log.gz": 90 * day},
+			maxAge:  30 * day,
+			left:    "old",
+			removed: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := useFakeClock(t)
+			minecraft := filepath.Join(t.TempDir(), ".minecraft")
+			logs := filepath.Join(minecraft, "logs")
+			writeAged(t, logs, fake.Now(), test.files)
+			journal := &Journal{Path: filepath.Join(t.TempDir(), "journal.jsonl"), Run: "run"}
+
+			removed, freed, err := CleanOldFiles(minecraft, "logs", test.maxAge, test.keep, journal)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := dirNames(t, logs); got != test.left {
+				t.Errorf("left %s, want %s", got, test.left)
+			}
+			if removed != test.removed || freed != int64(test.removed*len("{}")) {
+				t.Errorf("removed %d files, %d bytes", removed, freed)
+			}
+			entries, _ := ReadJournal(journal.Path)
+			if len(entries) != removed {
+				t.Fatalf("journaled %+v", entries)
+			}
+			for _, entry := range entries {
+				if entry.Action != journalDelete || filepath.Dir(entry.Path) != logs {
+					t.Errorf("journaled %+v", entry)
+				}
+			}
+		})
+	}
+}
+
+// TestCleanOldFilesContained checks nothing but the two maintenance
+// folders of a minecraft directory is ever cleaned.
+func TestCleanOldFilesContained(t *testing.T) {
+	fake := useFakeClock(t)
+	root := t.TempDir()
+	journal := &Journal{Path: filepath.Join(root, "journal.jsonl")}
+	minecraft := filepath.Join(root, ".minecraft")
+	writeAged(t, minecraft, fake.Now(), map[string]time.Duration{"config/old.txt": 90 * day, "logs/old.log.gz": 90 * day})
+	if _, _, err := CleanOldFiles(minecraft, "config", day, 0, journal); err == nil {
+		t.Error("config cleaned")
+	}
+	if _, _, err := CleanOldFiles(minecraft, "../logs", day, 0, journal); err == nil {
+		t.Error("../logs cleaned")
+	}
+	documents := filepath.Join(root, "Documents")
+	writeAged(t, documents, fake.Now(), map[string]time.Duration{"crash-reports/old.txt": 90 * day})
+	if _, _, err := CleanOldFiles(documents, "crash-reports", day, 0, journal); err == nil || dirNames(t, filepath.Join(documents, "crash-reports")) != "old.txt" {
+		t.Errorf("cleaned a folder outside a minecraft directory: %v", err)
+	}
+	// a missing folder has nothing to clean
+	if removed, _, err := CleanOldFiles(minecraft, "crash-reports", day, 0, journal); removed != 0 || err != nil {
+		t.Errorf("missing folder: %d, %v", removed, err)
+	}
+	if got := dirNames(t, filepath.Join(minecraft, "config")); got != "old.txt" {
+		t.Errorf("config holds %s", got)
+	}
+}
+
+func TestCleanOldFilesSkipsSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	fake := useFakeClock(t)
+	root := t.TempDir()
+	journal := &Journal{Path: filepath.Join(root, "journal.jsonl")}
+	elsewhere := filepath.Join(root, "elsewhere")
+	writeAged(t, elsewhere, fake.Now(), map[string]time.Duration{"precious.txt": 90 * day})
+
+	linkedFolder := filepath.Join(root, "a", ".minecraft")
+	if err := os.MkdirAll(linkedFolder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(elsewhere, filepath.Join(linkedFolder, "logs")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := CleanOldFiles(linkedFolder, "logs", day, 0, journal); err == nil {
+		t.Error("cleaned a symlinked folder")
+	}
+
+	linkedFile := filepath.Join(root, "b", ".minecraft")
+	writeAged(t, linkedFile, fake.Now(), map[string]time.Duration{"logs/old.log.gz": 90 * day})
+	if err := os.Symlink(filepath.Join(elsewhere, "precious.txt"), filepath.Join(linkedFile, "logs", "link.log.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if removed, _, err := CleanOldFiles(linkedFile, "logs", day, 0, journal); removed != 1 || err != nil {
+		t.Errorf("removed %d, %v", removed, err)
+	}
+	if got := dirNames(t, filepath.Join(linkedFile, "logs")); got != "link.log.gz" {
+		t.Errorf("left %s", got)
+	}
+	if got := dirNames(t, elsewhere); got != "precious.txt" {
+		t.Errorf("elsewhere holds %s", got)
+	}
+}
+
+func TestMaintenanceRun(t *testing.T) {
+	fake := useFakeClock(t)
+	minecraft := filepath.Join(t.TempDir(), ".minecraft")
+	writeAged(t, minecraft, fake.Now(), map[string]time.Duration{
+		"logs/a.log.gz":               10 * day,
+		"logs/b.log.gz":               20 * day,
+		"crash-reports/crash-1.txt":   10 * day,
+		"crash-reports/crash-2.txt":   20 * day,
+		"crash-reports/crash-3.txt":   30 * day,
+		"screenshots/2024-01-01.png":  200 * day,
+		"logs/telemetry/events.jsonl": 200 * day,
+	})
+	journal := &Journal{Path: filepath.Join(t.TempDir(), "journal.jsonl")}
+
+	if (Maintenance{Keep: 1}).Enabled() {
+		t.Error("maintenance enabled by default")
+	}
+	if removed, freed := (Maintenance{}).Run(minecraft, journal); removed != 0 || freed != 0 {
+		t.Errorf("disabled maintenance removed %d files", removed)
+	}
+	m := Maintenance{CleanCrashReportsOlderThanDays: 15, Keep: 1}
+	removed, freed := m.Run(minecraft, journal)
+	if removed != 2 || freed != 4 {
+		t.Errorf("removed %d files, %d bytes", removed, freed)
+	}
+	if got := dirNames(t, filepath.Join(minecraft, "crash-reports")); got != "crash-1.txt" {
+		t.Errorf("crash reports left %s", got)
+	}
+	if got := dirNames(t, filepath.Join(minecraft, "logs")); got != "a.log.gz b.log.gz telemetry" {
+		t.Errorf("logs left %s", got)
+	}
+	if got := dirNames(t, filepath.Join(minecraft, "screenshots")); got != "2024-01-01.png" {
+		t.Errorf("screenshots left %s", got)
+	}
+}
+
+// TestUpdateRunsMaintenance updates with the logs cleanup enabled in the
+// config.
+func TestUpdateRunsMaintenance(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium"})
+	writeAged(t, u.minecraft, u.clock.Now(), map[string]time.Duration{
+		"logs/latest.log":     0,
+		"logs/old-1.log.gz":   60 * day,
+		"logs/old-2.log.gz":   70 * day,
+		"crash-reports/c.txt": 90 * day,
+	})
+	if err := os.MkdirAll(u.state, 0755); err != nil {
+		t.Fatal(err)
+	}
+	SaveConfig(ConfFile{MCDirectory: u.mods, Maintenance: Maintenance{CleanLogsOlderThanDays: 30, Keep: 1}}, filepath.Join(u.state, "clientUpdate.json"))
+	output := readFile(t, u.run(t))
+	if !strings.Contains(output, "Maintenance: removed 2 old log and crash report files") {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := dirNames(t, filepath.Join(u.minecraft, "logs")); got != "latest.log" {
+		t.Errorf("logs left %s", got)
+	}
+	if got := dirNames(t, filepath.Join(u.minecraft, "crash-reports")); got != "c.txt" {
+		t.Errorf("crash reports left %s", got)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ManifestProblem is a mistake in the pack manifest at Path, the place in
+// its JSON, e.g. external[3].sha256.
+type ManifestProblem struct {
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p ManifestProblem) String() string {
+	if p.Path == "" {
+		return p.Message
+	}
+	return p.Path + ": " + p.Message
+}
+
+// manifestProblems collects the problems of a manifest.
+type manifestProblems []ManifestProblem
+
+func (p *manifestProblems) add(at string, format string, args ...interface{}) {
+	*p = append(*p, ManifestProblem{Path: at, Message: fmt.Sprintf(format, args...)})
+}
+
+// fieldPath, keyPath and indexPath name a field, a key of an object and
+// an element of an array below the place at.
+func fieldPath(at string, name string) string {
+	if at == "" {
+		return name
+	}
+	return at + "." + name
+}
+
+func keyPath(at string, key string) string {
+	return at + "[" + strconv.Quote(key) + "]"
+}
+
+func indexPath(at string, i int) string {
+	return at + "[" + strconv.Itoa(i) + "]"
+}
+
+// ManifestError is a manifest the updater refuses, with every problem
+// found in it.
+type ManifestError struct {
+	Problems []ManifestProblem
+}
+
+func (e *ManifestError) Error() string {
+	if len(e.Problems) == 1 {
+		return packManifestName + ": " + e.Problems[0].String()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s has %d problems:", packManifestName, len(e.Problems))
+	for _, problem := range e.Problems {
+		b.WriteString("\n  " + problem.String())
+	}
+	return b.String()
+}
+
+// DecodePackManifest decodes a manifest strictly: besides JSON that isn't
+// valid, every unknown field, value of the wrong type and problem Validate
+// finds is reported, as a *ManifestError. Unknown fields matching a known
+// one but for case, which encoding/json would take, are reported too.
+func DecodePackManifest(content []byte) (*PackManifest, error) {
+	var raw interface{}
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, &ManifestError{Problems: []ManifestProblem{{Message: describeJSONError(content, err)}}}
+	}
+	var problems manifestProblems
+	checkJSONShape(raw, reflect.TypeOf(PackManifest{}), "", &problems)
+	var manifest PackManifest
+	// values of the wrong type were reported, the rest is decoded
+	json.Unmarshal(content, &manifest)
+	problems = append(problems, manifest.Validate()...)
+	if len(problems) > 0 {
+		return &manifest, &ManifestError{Problems: problems}
+	}
+	return &manifest, nil
+}
+
+// describeJSONError tells where in content err, from decoding it, is.
+func describeJSONError(content []byte, err error) string {
+	syntax, ok := err.(*json.SyntaxError)
+	if !ok {
+		return err.Error()
+	}
+	// Offset is just past the offending byte
+	before := content[:syntax.Offset]
+	if len(before) > 0 {
+		before = before[:len(before)-1]
+	}
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Sprintf("line %d, column %d: %s", line, column, err)
+}
+
+// checkJSONShape reports where the decoded JSON value doesn't fit t, the
+// type it is decoded into: unknown fields and values of the wrong type.
+// null fits everything, it leaves the zero value.
+func checkJSONShape(value interface{}, t reflect.Type, at string, problems *manifestProblems) {
+	if value == nil {
+		return
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			problems.add(at, "must be an object, not %s", jsonKind(value))
+			return
+		}
+		fields := map[string]reflect.StructField{}
+		similar := map[string]string{}
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if name == "-" || t.Field(i).PkgPath != "" {
+				continue
+			}
+			fields[name] = t.Field(i)
+			similar[strings.ToLower(name)] = name
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field, ok := fields[key]
+			switch {
+			case ok:
+				checkJSONShape(object[key], field.Type, fieldPath(at, key), problems)
+			case similar[strings.ToLower(key)] != "":
+				problems.add(fieldPath(at, key), "unknown field, did you mean %q?", similar[strings.ToLower(key)])
+			default:
+				problems.add(fieldPath(at, key), "unknown field")
+			}
+		}
+	case reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			problems.add(at, "must be an object, not %s", jsonKind(value))
+			return
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			checkJSONShape(object[key], t.Elem(), keyPath(at, key), problems)
+		}
+	case reflect.Slice:
+		array, ok := value.([]interface{})
+		if !ok {
+			problems.add(at, "must be an array, not %s", jsonKind(value))
+			return
+		}
+		for i, element := range array {
+			checkJSONShape(element, t.Elem(), indexPath(at, i), problems)
+		}
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			problems.add(at, "must be a string, not %s", jsonKind(value))
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			problems.add(at, "must be true or false, not %s", jsonKind(value))
+		}
+	case reflect.Int, reflect.Int64:
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			problems.add(at, "must be a whole number, not %s", jsonKind(value))
+		}
+	}
+}
+
+// jsonKind describes a decoded JSON value for problems.
+func jsonKind(value interface{}) string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return fmt.Sprintf("the string %q", v)
+	case float64:
+		return "the number " + strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return "null"
+}
+
+// knownOS and knownArch are the GOOS and GOARCH names platform
+// constraints may use.
+var (
+	knownOS   = []string{"android", "darwin", "freebsd", "linux", "netbsd", "openbsd", "windows"}
+	knownArch = []string{"386", "amd64", "arm", "arm64", "loong64", "ppc64le", "riscv64", "s390x"}
+)
+
+// Validate checks the values of the manifest, every problem with its place
+// in the JSON.
+func (m *PackManifest) Validate() []ManifestProblem {
+	var problems manifestProblems
+	if m.MinUpdaterVersion != "" {
+		if _, err := parseSemver(m.MinUpdaterVersion); err != nil {
+			problems.add("minUpdaterVersion", "%q is not a version like 1.4.0", m.MinUpdaterVersion)
+		}
+	}
+	if m.StatsURL != "" {
+		if u, err := url.Parse(m.StatsURL); err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+			problems.add("statsURL", "%q is not a web address", m.StatsURL)
+		}
+	}
+	folders := map[string]bool{}
+	for _, mc := range sortedKeys(m.Versions) {
+		folder := m.Versions[mc]
+		if !mcVersionPattern.MatchString(mc) {
+			problems.add(keyPath("versions", mc), "%q is not a Minecraft version", mc)
+		}
+		if clean, err := sanitizeEntryPath(folder); err != nil || clean == "" {
+			problems.add(keyPath("versions", mc), "%q is not a folder of the pack", folder)
+		}
+		folders[strings.Trim(folder, "/")] = true
+	}
+	for _, dest := range sortedKeys(m.Transforms) {
+		if clean, err := sanitizeEntryPath(dest); err != nil || clean == "" {
+			problems.add(keyPath("transforms", dest), "must be a path in the minecraft directory")
+		}
+		if _, ok := Transforms[m.Transforms[dest]]; !ok {
+			problems.add(keyPath("transforms", dest), "unknown transform %q, must be one of %s", m.Transforms[dest], strings.Join(sortedKeys(Transforms), ", "))
+		}
+	}
+	for i, id := range m.CriticalMods {
+		if strings.TrimSpace(id) == "" {
+			problems.add(indexPath("criticalMods", i), "must be a mod id")
+		}
+	}
+	for _, folder := range sortedKeys(m.Contents) {
+		contents := m.Contents[folder]
+		if len(folders) == 0 && folder != "mods" || len(folders) > 0 && !folders[folder] {
+			problems.add(keyPath("contents", folder), "is not a mods folder of versions")
+		}
+		if contents.Files < 0 {
+			problems.add(fieldPath(keyPath("contents", folder), "files"), "must not be negative")
+		}
+		if contents.Size < 0 {
+			problems.add(fieldPath(keyPath("contents", folder), "size"), "must not be negative")
+		}
+	}
+	for _, id := range sortedKeys(m.ModPolicies) {
+		if policy := m.ModPolicies[id]; policy != modPinned && policy != modFloating {
+			problems.add(keyPath("modPolicies", id), "must be %s or %s, not %q", modPinned, modFloating, policy)
+		}
+	}
+	for _, name := range sortedKeys(m.Platforms) {
+		at := keyPath("platforms", name)
+		if name == "" || path.Base(name) != name {
+			problems.add(at, "must be the file name of a mod, without folders")
+		}
+		checkPlatformValues(m.Platforms[name].OS, knownOS, fieldPath(at, "os"), &problems)
+		checkPlatformValues(m.Platforms[name].Arch, knownArch, fieldPath(at, "arch"), &problems)
+	}
+	if m.Requirements != nil {
+		m.Requirements.validate("requirements", &problems)
+	}
+	validateLayout(m.Layout, &problems)
+	validateExternalMods(m.External, &problems)
+	validateLoaderVersions(m.LoaderVersions, &problems)
+	validateExtraFiles(m.ExtraFiles, &problems)
+	return problems
+}
+
+// checkPlatformValues reports the values of a platform constraint that
+// aren't in known, with or without their "!".
+func checkPlatformValues(values []string, known []string, at string, problems *manifestProblems) {
+	for i, value := range values {
+		name := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(value)), "!")
+		found := false
+		for _, k := range known {
+			found = found || k == name
+		}
+		if !found {
+			problems.add(indexPath(at, i), "unknown %q, must be one of %s, optionally with a leading !", value, strings.Join(known, ", "))
+		}
+	}
+}
+
+// sortedKeys returns the keys of a map with string keys, sorted, so
+// problems are reported in the same order every time.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, key := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// problemLines lists problems one per line, as the golden files have them.
+func problemLines(problems []ManifestProblem) string {
+	var b strings.Builder
+	for _, problem := range problems {
+		b.WriteString(problem.String() + "\n")
+	}
+	return b.String()
+}
+
+// TestDecodePackManifestFixtures decodes every manifest of
+// testdata/manifests, comparing the problems found in name.json with
+// name.golden, empty for valid ones.
+func TestDecodePackManifestFixtures(t *testing.T) {
+	fixtures, _ := filepath.Glob(filepath.Join("testdata", "manifests", "*.json"))
+	if len(fixtures) == 0 {
+		t.Fatal("no manifest fixtures")
+	}
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".json")
+		t.Run(name, func(t *testing.T) {
+			_, err := DecodePackManifest([]byte(readFile(t, fixture)))
+			var problems []ManifestProblem
+			if err != nil {
+				var manifestErr *ManifestError
+				if !errors.As(err, &manifestErr) {
+					t.Fatalf("not a *ManifestError: %v", err)
+				}
+				problems = manifestErr.Problems
+			}
+			checkGolden(t, filepath.Join("manifests", name+".golden"), problemLines(problems))
+		})
+	}
+}
+
+func TestDecodePackManifestValid(t *testing.T) {
+	manifest, err := DecodePackManifest([]byte(readFile(t, filepath.Join("testdata", "manifests", "valid.json"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Layout != layoutPreserve || len(manifest.External) != 2 || manifest.Requirements.MinJava != 17 || manifest.Contents["mods-1.20.1"].Size != 30 {
+		t.Errorf("decoded %+v", manifest)
+	}
+}
+
+func TestManifestErrorMessage(t *testing.T) {
+	one := &ManifestError{Problems: []ManifestProblem{{Path: "layout", Message: "is wrong"}}}
+	if got := one.Error(); got != "pack.json: layout: is wrong" {
+		t.Errorf("one problem: %s", got)
+	}
+	two := &ManifestError{Problems: []ManifestProblem{{Message: "line 1, column 2: bad"}, {Path: "x", Message: "y"}}}
+	if got := two.Error(); got != "pack.json has 2 problems:\n  line 1, column 2: bad\n  x: y" {
+		t.Errorf("two problems: %s", got)
+	}
+}
+
+// TestReadPackManifestRefusesInvalid checks players' updates stop at a
+// broken manifest, reporting what is wrong with it.
+func TestReadPackManifestRefusesInvalid(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "pack.zip")
+	writeZip(t, archivePath, map[string]string{
+		"rxmc-Mods-master/pack.json":              readFile(t, filepath.Join("testdata", "manifests", "unknown-fields.json")),
+		"rxmc-Mods-master/mods-1.20.1/sodium.jar": "sodium",
+	})
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	manifest, err := ReadPackManifest(&r.Reader)
+	var manifestErr *ManifestError
+	if manifest != nil || !errors.As(err, &manifestErr) || !strings.Contains(err.Error(), `Versions: unknown field, did you mean "versions"?`) {
+		t.Errorf("read %+v, %v", manifest, err)
+	}
+}
+
+// TestReadPackManifestTooOld checks a manifest needing a newer updater is
+// reported as such rather than for the fields that one added.
+func TestReadPackManifestTooOld(t *testing.T) {
+	savedVersion := Version
+	Version = "1.0.0"
+	defer func() { Version = savedVersion }()
+	_, err := parsePackManifest([]byte(`{"minUpdaterVersion": "9.0.0", "newField": true}`))
+	if err == nil || strings.Contains(err.Error(), "newField") {
+		t.Errorf("reported %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// minecraftRootMarkers are found in the minecraft directory the game's
+// versions are installed in, the one the loader installers and the
+// launcher profile need.
+var minecraftRootMarkers = []string{"versions", "launcher_profiles.json"}
+
+// minecraftRootDepth is how many directories above a mods directory its
+// minecraft directory is looked for. Launchers with profiles keep the mods
+// in e.g. .minecraft/profiles/main/mods, two directories below it.
+const minecraftRootDepth = 3
+
+// FindMinecraftRoot returns the minecraft directory of modPath: the
+// nearest of its first minecraftRootDepth ancestors holding one of the
+// minecraftRootMarkers. found is false when none does, root is then the
+// parent of modPath, which is where a never launched game puts them.
+func FindMinecraftRoot(modPath string) (root string, found bool) {
+	dir := filepath.Dir(filepath.Clean(modPath))
+	for depth := 0; depth < minecraftRootDepth; depth++ {
+		if isMinecraftRoot(dir) {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return filepath.Dir(filepath.Clean(modPath)), false
+}
+
+// isMinecraftRoot reports whether dir holds one of the minecraftRootMarkers.
+func isMinecraftRoot(dir string) bool {
+	for _, marker := range minecraftRootMarkers {
+		if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveMinecraftRoot returns the minecraft directory of modPath for this
+// run: the one recorded in state while it still is an ancestor of modPath
+// holding a marker, so every run agrees on it, else the one found above
+// modPath. Falling back to the parent of modPath is warned about.
+func ResolveMinecraftRoot(modPath string, state *InstalledState) string {
+	if state != nil && state.MinecraftPath != "" && isMinecraftRoot(state.MinecraftPath) {
+		rel, err := filepath.Rel(state.MinecraftPath, modPath)
+		if err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			return state.MinecraftPath
+		}
+	}
+	root, found := FindMinecraftRoot(modPath)
+	if !found {
+		Warn(warnLauncher, T("mcroot.notfound", modPath, root))
+	} else if root != filepath.Dir(modPath) {
+		Logf("minecraft directory of %s is %s", modPath, root)
+	}
+	return root
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFindMinecraftRoot finds the minecraft directory of the mods
+// directories of realistic launcher layouts.
+func TestFindMinecraftRoot(t *testing.T) {
+	tests := []struct {
+		name string
+		// files are the layout's files, mods its mods directory and root
+		// the minecraft directory expected, by slash separated path.
+		files map[string]string
+		mods  string
+		root  string
+		found bool
+	}{
+		{
+			name:  "vanilla",
+			files: map[string]string{".minecraft/versions/1.20.1/1.20.1.json": "{}", ".minecraft/launcher_profiles.json": "{}"},
+			mods:  ".minecraft/mods",
+			root:  ".minecraft",
+			found: true,
+		},
+		{
+			name:  "launched once",
+			files: map[string]string{".minecraft/launcher_profiles.json": "{}"},
+			mods:  ".minecraft/mods",
+			root:  ".minecraft",
+			found: true,
+		},
+		{
+			name:  "launcher profile",
+			files: map[string]string{".minecraft/versions/1.20.1/1.20.1.json": "{}", ".minecraft/profiles/main/options.txt": ""},
+			mods:  ".minecraft/profiles/main/mods",
+			root:  ".minecraft",
+			found: true,
+		},
+		{
+			name:  "nearest",
+			files: map[string]string{".minecraft/versions/1.20.1/1.20.1.json": "{}", ".minecraft/profiles/main/versions/1.21/1.21.json": "{}"},
+			mods:  ".minecraft/profiles/main/mods",
+			root:  ".minecraft/profiles/main",
+			found: true,
+		},
+		{
+			name:  "multimc",
+			files: map[string]string{"MultiMC/instances/rxmc/instance.cfg": "", "MultiMC/instances/rxmc/.minecraft/options.txt": "", "MultiMC/libraries/lwjgl.jar": ""},
+			mods:  "MultiMC/instances/rxmc/.minecraft/mods",
+			root:  "MultiMC/instances/rxmc/.minecraft",
+		},
+		{
+			name:  "prism",
+			files: map[string]string{"PrismLauncher/instances/rxmc/mmc-pack.json": "{}", "PrismLauncher/instances/rxmc/minecraft/options.txt": ""},
+			mods:  "PrismLauncher/instances/rxmc/minecraft/mods",
+			root:  "PrismLauncher/instances/rxmc/minecraft",
+		},
+		{
+			name:  "curseforge",
+			files: map[string]string{"curseforge/minecraft/Install/versions/1.20.1/1.20.1.json": "{}", "curseforge/minecraft/Instances/rxmc/minecraftinstance.json": "{}"},
+			mods:  "curseforge/minecraft/Instances/rxmc/mods",
+			root:  "curseforge/minecraft/Instances/rxmc",
+		},
+		{
+			name:  "never launched",
+			files: map[string]string{".minecraft/mods/sodium.jar": ""},
+			mods:  ".minecraft/mods",
+			root:  ".minecraft",
+		},
+		{
+			name:  "too deep",
+			files: map[string]string{".minecraft/versions/1.20.1/1.20.1.json": "{}"},
+			mods:  ".minecraft/a/b/c/mods",
+			root:  ".minecraft/a/b/c",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, test.files)
+			root, found := FindMinecraftRoot(filepath.Join(dir, filepath.FromSlash(test.mods)) + string(filepath.Separator))
+			if want := filepath.Join(dir, filepath.FromSlash(test.root)); root != want || found != test.found {
+				t.Errorf("found %t, %s, want %t, %s", found, root, test.found, want)
+			}
+		})
+	}
+}
+
+func TestResolveMinecraftRoot(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".minecraft/versions/1.20.1/1.20.1.json":           "{}",
+		".minecraft/profiles/main/launcher_profiles.json":  "{}",
+		"elsewhere/.minecraft/versions/1.20.1/1.20.1.json": "{}",
+	})
+	minecraft := filepath.Join(dir, ".minecraft")
+	mods := filepath.Join(minecraft, "profiles", "main", "mods")
+	tests := []struct {
+		name     string
+		recorded string
+		want     string
+	}{
+		{name: "nothing recorded", want: filepath.Join(minecraft, "profiles", "main")},
+		// later runs keep to the directory the first one resolved
+		{name: "recorded", recorded: minecraft, want: minecraft},
+		{name: "recorded elsewhere", recorded: filepath.Join(dir, "elsewhere", ".minecraft"), want: filepath.Join(minecraft, "profiles", "main")},
+		{name: "recorded without markers", recorded: filepath.Join(minecraft, "profiles"), want: filepath.Join(minecraft, "profiles", "main")},
+		{name: "recorded mods", recorded: mods, want: filepath.Join(minecraft, "profiles", "main")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useWarnings(t)
+			state := &InstalledState{MinecraftPath: test.recorded}
+			if got := ResolveMinecraftRoot(mods, state); got != test.want {
+				t.Errorf("resolved %s, want %s", got, test.want)
+			}
+			if warned := warningMessages(warnLauncher); len(warned) > 0 {
+				t.Errorf("warned %q", warned)
+			}
+		})
+	}
+
+	useWarnings(t)
+	defer PrintWarningsTo(&strings.Builder{})()
+	bare := filepath.Join(dir, "bare", "mods")
+	if got := ResolveMinecraftRoot(bare, nil); got != filepath.Dir(bare) {
+		t.Errorf("resolved %s", got)
+	}
+	warned := warningMessages(warnLauncher)
+	if want := "No versions folder or launcher_profiles.json was found above " + bare + "; " + filepath.Dir(bare) + " is used"; len(warned) != 1 || !strings.HasPrefix(warned[0], want) {
+		t.Errorf("warned %q", warned)
+	}
+}
+
+// TestUpdateLauncherProfile updates the mods directory of a launcher
+// profile below the minecraft directory: the loader installed there is
+// found, and the directory is recorded for later runs.
+func TestUpdateLauncherProfile(t *testing.T) {
+	useWarnings(t)
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": modJar(t, "sodium", "0.5.8")})
+	// the profile's game directory has been played in
+	writeFiles(t, u.minecraft, map[string]string{"profiles/main/options.txt": "fov:0.0\n"})
+	mods := filepath.Join(u.minecraft, "profiles", "main", "mods")
+	if err := os.MkdirAll(mods, 0755); err != nil {
+		t.Fatal(err)
+	}
+	useRunState(t)
+	u.clock.Advance(time.Minute)
+	output := readFile(t, runMain(t, "--portable", u.state, "--dir", mods, "--yes", "--no-telemetry", "--no-tui", "--mc-version", "1.20.1"))
+	if got := dirNames(t, mods); got != "sodium.jar" {
+		t.Fatalf("installed %s, output:\n%s", got, output)
+	}
+	if !strings.Contains(output, "fabric + Minecraft version already installed.") {
+		t.Errorf("the loader wasn't found, output:\n%s", output)
+	}
+	if warned := warningMessages(warnLauncher); len(warned) > 0 {
+		t.Errorf("warned %q", warned)
+	}
+	state, err := ReadInstalledState(filepath.Join(u.state, "clientUpdate-installed.json"))
+	if err != nil || state == nil || state.MinecraftPath != u.minecraft {
+		t.Errorf("installed state %+v, %v", state, err)
+	}
+}
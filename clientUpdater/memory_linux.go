@@ -0,0 +1,12 @@
+package main
+
+import "syscall"
+
+// totalMemory returns the physical memory in bytes.
+func totalMemory() (uint64, error) {
+	var info syscall.Sysinfo_t
+	if err := syscall.Sysinfo(&info); err != nil {
+		return 0, err
+	}
+	return uint64(info.Totalram) * uint64(info.Unit), nil
+}
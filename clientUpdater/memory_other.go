@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "errors"
+
+// totalMemory can't tell the memory on this system.
+func totalMemory() (uint64, error) {
+	return 0, errors.New("not supported on this system")
+}
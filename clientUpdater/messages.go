@@ -0,0 +1,489 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// translations holds one <language>.json catalog per supported language,
+// each mapping message keys to translated text. Keys missing from a
+// translation fall back to English.
+//
+//go:embed lang/*.json
+var translations embed.FS
+
+// english is the message catalog every translation is based on. Log output
+// always stays in English regardless of the chosen language.
+var english = map[string]string{
+	"answer.yes":                   "y,yes",
+	"answer.no":                    "n",
+	"answer.hint.yes":              "[Y/n]",
+	"answer.hint.no":               "[y/N]",
+	"config.missing":               "No configuration found (%s), creating a new one.",
+	"log.unavailable":              "Unable to open log file %s: %s",
+	"fatal":                        "FATAL: %s",
+	"fatal.dir":                    "FATAL: invalid --dir: %s",
+	"fatal.download":               "FATAL: unable to get the mods: %s",
+	"download.start":               "Downloading lastest mods",
+	"download.mirror":              "> Primary download unavailable, used mirror: %s",
+	"download.done":                "> Downloaded: %s",
+	"download.retry":               "> The download looks damaged, trying once more",
+	"notice.newer":                 "NOTICE: the mod pack also supports Minecraft %s, you are set up for %s.",
+	"notice.newer.how":             "  > Run with --mc-version %s to try it, or change \"version\" in %s to move for good.",
+	"prompt.path":                  "< Enter the correct path below",
+	"exiting":                      "Exiting.",
+	"versions.collect":             "Collecting existing version information.",
+	"loader.install":               "> Installing designated %s + Minecraft version.",
+	"loader.failed":                "%s Install Error: %s",
+	"loader.done":                  "> Install complete.",
+	"loader.present":               "> %s + Minecraft version already installed.",
+	"mods.removing":                "Removing old mods for Minecraft",
+	"mods.removed":                 "> Mods have been removed",
+	"mods.loading":                 "Loading new mods for Minecraft",
+	"mods.loaded":                  "> Mods loaded",
+	"mods.foreign":                 "WARNING: %s doesn't look like a %s mod",
+	"cleanup":                      "Cleaning up",
+	"done":                         "> Done",
+	"summary.source":               "  Mods downloaded from: %s",
+	"multimc.header":               "===== ADDITIONAL STEPS IF USING MultiMC =====",
+	"multimc.mcversion":            "  1) Make sure the 'instance' version of minecraft is: %s",
+	"multimc.loader":               "  2) Make sure the 'instance' version of FABRIC is up to date.\n    (%s is bundled with this)",
+	"multimc.footer":               "===== ===== ===== ===== ===== ===== ===== =====",
+	"exit.countdown":               "Exiting in ",
+	"history.empty":                "No updates have been recorded yet.",
+	"history.run":                  "Run %s (%s)",
+	"restore.usage":                "Usage: restore <filename> [run]",
+	"restore.done":                 "> Restored %s from the backup of run %s",
+	"mods.backup":                  "> The old mods were kept in %s",
+	"modsdir.status":               "Mods directory: %s (%s)",
+	"modsdir.ok":                   "OK",
+	"modsdir.create":               "does not exist",
+	"modsdir.invalid":              "unusable: %s",
+	"path.unusable":                "That directory can't be used: %s",
+	"prepare.overlap":              "> Preparing while downloading saved %s",
+	"cache.cleared":                "> Cleared the cache in %s",
+	"transforms.done":              "> Installed %d pack files",
+	"prompt.template":              "< The mod pack needs a value for %s: ",
+	"launcher.busy":                "> Waiting for the Minecraft launcher to finish (%s)",
+	"launcher.gaveup":              "WARNING: the Minecraft launcher is still busy, %s was not installed. Close the launcher and run the updater again.",
+	"download.resume":              "> Resuming the previous download at %d MB",
+	"import.unsafe":                "FATAL: %s is not a usable mods directory, refusing to export, import or migrate. Fix the \"directory\" setting or use --dir.",
+	"import.usage":                 "Usage: import <export file>",
+	"import.pack.latest":           "latest",
+	"import.plan":                  "Importing into %s:",
+	"import.plan.pack":             "  Pack: %s",
+	"import.plan.minecraft":        "  Minecraft %s, %s %s",
+	"import.plan.summary":          "  %d files already match, %d will be removed, %d will be added.",
+	"import.plan.unavailable":      "  %d files (marked !) are not part of the pack and can't be imported, copy them over by hand.",
+	"import.plan.loader":           "  %s %s will be installed.",
+	"import.confirm":               "< Apply these changes?",
+	"import.cancelled":             "Import cancelled, nothing was changed.",
+	"import.done":                  "> Imported %s",
+	"export.done":                  "> Exported this setup to %s",
+	"path.notmods":                 "WARNING: %s doesn't look like a mods folder, everything in it will be replaced.",
+	"path.notmods.confirm":         "< Type the folder name (%s) to proceed, anything else stops: ",
+	"path.notmods.allow":           "  > To stop asking for this folder, set \"allowNonStandardModsDir\" to %s in %s.",
+	"updater.tooold":               "FATAL: the mod pack needs updater %s or newer, you are running %s. Nothing was changed.",
+	"updater.get":                  "  > Download the new version from %s",
+	"updater.unchecked":            "This updater's version (%s) isn't a release version, so it can't tell whether it meets the pack's minimum of %s. If the update goes wrong, get the latest release from %s.",
+	"diff.usage":                   "Usage: diff --from <tag, commit, archive or directory> [--to <...>] [--json]",
+	"diff.header":                  "Pack %s -> %s",
+	"diff.packversion":             "  Pack version: %s -> %s",
+	"diff.minupdater":              "  Minimum updater version: %s -> %s",
+	"diff.mc.added":                "  Minecraft versions added: %s",
+	"diff.mc.removed":              "  Minecraft versions removed: %s",
+	"diff.legacy":                  "Mods:",
+	"diff.version":                 "Minecraft %s:",
+	"diff.summary":                 "  %d added, %d removed, %d updated, %d unchanged",
+	"diff.size":                    "  New or changed mods: %s (full pack download: %s)",
+	"diff.loader":                  "  Loader: %s -> %s",
+	"diff.loader.any":              "any",
+	"diff.conflicts":               "Mods with more than one jar, players get all of them:",
+	"diff.conflict":                "  ! %s, Minecraft %s: %s in %s",
+	"repair.verify":                "Checking the mods in %s against the last update.",
+	"repair.fixed":                 "  > Repaired %s",
+	"repair.failed":                "  > Could not repair %s, the pack no longer has this version of it. Run a normal update.",
+	"repair.ok":                    "> Every mod is intact, nothing to repair.",
+	"targets.skip":                 "> Skipping target %s: %s",
+	"targets.notconfirmed":         "not confirmed",
+	"targets.order":                "Updating %d more group(s) of targets. Groups run at the same time, targets sharing a minecraft directory wait for each other:",
+	"targets.group":                "  Group %d (%s):",
+	"targets.summary":              "Targets:",
+	"targets.ok":                   "OK",
+	"targets.failed":               "FAILED: %s",
+	"targets.result":               "  [%d] %s: %s (started after %s, took %s)",
+	"telemetry.prompt":             "< The pack maintainer would like to count how many players are on each pack version. Send an anonymous note (random id, pack, Minecraft and updater version, operating system) after each update? You can turn this off any time with \"telemetry\": false in %s or --no-telemetry.",
+	"modsdir.missing":              "The configured mods directory %s no longer exists, was the instance deleted?",
+	"modsdir.fresh":                "There is no minecraft directory at %s yet. Start Minecraft (or create the instance in your launcher) once, or pick an existing install below.",
+	"modsdir.choose":               "< Which mods directory should be updated?",
+	"modsdir.choose.create":        "  c) create %s",
+	"modsdir.choose.other":         "  o) enter another directory",
+	"notice.newer.switch":          "< Move to Minecraft %s now?",
+	"mcversion.change":             "The last update was for Minecraft %s, this one is for %s.",
+	"mcversion.change.loader":      "  > %s for Minecraft %s is installed along with the matching mods, and the launcher gets a profile for it.",
+	"mcversion.change.keep":        "  > Minecraft %s stays installed, switch back to it in the launcher whenever you like.",
+	"extract.mismatch":             "STOPPED: the pack declares %d mod files (%s) but %d files (%s) were extracted. Your installed mods were not touched.",
+	"extract.kept":                 "  > The extracted files were kept in %s, please tell the pack maintainer.",
+	"extract.large":                "WARNING: the pack put %d files (%s) into the mods directory, far more than a pack usually holds. Starting the game may take very long.",
+	"extract.extras":               "  > %d files come from folders below the mods folder and were probably not meant to be extracted:",
+	"extract.extras.more":          "    ... and %d more",
+	"maintenance.done":             "Maintenance: removed %d old log and crash report files, %s freed.",
+	"java.arch.rosetta":            "Your Java is built for Intel Macs, and Rosetta 2 is not installed to run it on Apple Silicon. Install the Apple Silicon (aarch64) Java from %s, or install Rosetta with: softwareupdate --install-rosetta",
+	"java.arch.intel":              "Your Java is built for Apple Silicon and can't run on this Intel Mac. Install the Intel (x64) Java from %s",
+	"policy.kept":                  "kept newer user version of %s (%s > %s)",
+	"policy.restored":              "%s must match the pack, restored %s over your %s",
+	"preflight.header":             "=== Ready to update ===",
+	"preflight.pack":               "  Pack:       %s",
+	"preflight.target":             "  Mods:       %s",
+	"preflight.minecraft":          "  Minecraft:  %s with %s (already installed)",
+	"preflight.minecraft.install":  "  Minecraft:  %s with %s (will be installed)",
+	"preflight.mods":               "  Changes:    %d mods added or updated, %d removed, %d unchanged",
+	"preflight.backup":             "  Backup:     %d current files are kept in %s",
+	"preflight.noversions":         "No Minecraft versions found in %s, start the game once with the launcher if the loader install fails.",
+	"preflight.confirm":            "< Go ahead?",
+	"preflight.hint":               "[Y = update / n = cancel / d = choose another mods directory]",
+	"preflight.cancelled":          "Cancelled, nothing was changed.",
+	"answer.dir":                   "d",
+	"modsdir.locked":               "OK, locked",
+	"lock.failed":                  "WARNING: could not make %s read-only again: %s",
+	"preflight.locked":             "              locked, it is unlocked for the update and locked again afterwards",
+	"notify.started":               "Updating the mods in %s",
+	"notify.done":                  "Update complete: %d mods changed",
+	"notify.failed":                "Update failed: %s",
+	"summary.written":              "%s written to disk",
+	"vanilla.neverlaunched":        "Minecraft was never started in this directory yet, so the files of Minecraft %s the loader needs are missing.",
+	"vanilla.prompt":               "< Download the vanilla Minecraft %s files from Mojang now? (otherwise start Minecraft once with the launcher and run the updater again)",
+	"vanilla.warning":              "Start vanilla Minecraft %s once with the launcher before playing, or run the updater with --bootstrap-vanilla; the loader install is likely to fail until then.",
+	"vanilla.bootstrap":            "Downloading the vanilla Minecraft %s files...",
+	"vanilla.failed":               "Could not download the vanilla Minecraft files: %s",
+	"preflight.vanilla":            "              vanilla files are downloaded from Mojang first",
+	"resume.swap":                  "Completing the update interrupted in run %s (%d of %d files were already in place).",
+	"leftover.found":               "An earlier run was interrupted and left behind:",
+	"leftover.resume":              "%s, the update carries on with it",
+	"leftover.discard":             "%s, it can't be verified and is removed",
+	"jitter.wait":                  "Waiting %s before starting, so not everyone updates at once (starting at %s).",
+	"usage":                        "Usage: %s [flags] [pack.zip | history | restore <file> [run] | diff --from <...> | lint-manifest <pack.json> | export [file] | import <file> | verify [--pack-version <v>] | repair [--pack-version <v>] | diagnose | prelaunch | rollback | settings | owned [--json] | why [--json] <file> | migrate [--move] [--name <name>] | plan [--out <file>] | apply <file>]",
+	"usage.unknown":                "Unknown arguments: %s",
+	"source.local":                 "Installing from %s, nothing is downloaded.",
+	"move.copying":                 "%s is on another drive, files are copied there instead of moved, which takes longer.",
+	"sources.fetch":                "Downloading the %s pack source...",
+	"sources.merged":               "Merged %d pack sources, %d mods in total.",
+	"sources.conflict":             "These mods come from more than one pack source under different names and would be installed twice, nothing was changed:",
+	"sources.override":             "%s of %s is replaced by the one from %s",
+	"sources.summary":              "%s: %d mods",
+	"diagnose.done":                "Diagnostic bundle written to %s, attach it to your report. It holds the config, log, mods list and system details, with personal paths and secrets removed.",
+	"preflight.platform":           "              %d files left out, meant for other platforms than %s:",
+	"preflight.platform.file":      "                %s (%s)",
+	"migrate.nolauncher":           "No Prism Launcher or MultiMC instances folder found, start the launcher once or pass it with --instances.",
+	"migrate.plan":                 "Migrating %s into the new instance %s:",
+	"migrate.plan.minecraft":       "  Minecraft %s, %s %s",
+	"migrate.plan.copy":            "  Copying %s; the originals stay where they are, but are no longer updated.",
+	"migrate.plan.move":            "  Moving %s; nothing of it stays behind.",
+	"migrate.confirm":              "< Create the instance?",
+	"migrate.cancelled":            "Migration cancelled, nothing was changed.",
+	"migrate.done":                 "> Migrated to %s, add it in your launcher if it isn't listed yet. The updater keeps this instance up to date from now on.",
+	"migrate.readme":               "The mods of this pack moved to %s, where the updater keeps them up to date. This directory is no longer updated.",
+	"warnings.held":                "  ... further %s warnings only go to the log",
+	"warnings.summary":             "%s: %d warnings",
+	"warnings.more":                "(%d not shown, see %s)",
+	"warning.metadata":             "Mod metadata",
+	"warning.extract":              "Pack contents",
+	"warning.launcher":             "Launcher",
+	"warning.lock":                 "Mods directory lock",
+	"modsdir.gameroot":             "%s is not a mods directory but a whole Minecraft directory (it holds %s). Everything in the directory given is replaced by the pack, so your saves and settings would be lost; renaming the folder doesn't change that.",
+	"modsdir.gameroot.use":         "< Update its mods directory %s instead?",
+	"config.problems":              "Some settings in %s could not be used:",
+	"config.broken.kept":           "Corrections are saved to the file, a copy of it as it was is kept as %s.",
+	"config.version.default":       "No usable Minecraft version configured, updating for Minecraft %s (saved to the config).",
+	"preflight.protected":          "              %d files of your own are left alone:",
+	"preflight.protected.file":     "                %s",
+	"protected.file":               "%s: protected (%s)",
+	"protected.marker":             "marker",
+	"protected.local":              "mods/local",
+	"phase.startup":                "starting up",
+	"phase.download":               "downloading the pack",
+	"phase.confirm":                "preparing the update",
+	"phase.loader":                 "installing the loader",
+	"phase.swap":                   "replacing the mods",
+	"phase.transforms":             "updating config files",
+	"phase.targets":                "updating the other targets",
+	"phase.targets.detail":         "updating the other targets (%s)",
+	"phase.finish":                 "finishing up",
+	"run.timeout":                  "The update took longer than %s and was stopped while %s. Run it again, or allow more time with --max-duration or \"maxDuration\" in the config.",
+	"run.interrupted":              "Stopped while %s.",
+	"run.rollback":                 "Stopping, putting back the mods changed so far...",
+	"daemon.start":                 "Updating now and then every %s, stop with Ctrl-C.",
+	"daemon.status":                "Status page: %s",
+	"daemon.stopping":              "Stopping, a running update is stopped and rolled back first...",
+	"daemon.mode":                  "--daemon only runs updates, it can't be combined with %s.",
+	"recovery.found":               "An update of %s was interrupted while %s (%s, run %s). The mods directory may be half updated.",
+	"recovery.options":             "[r] restore the mods from before that update (default), [c] let this update complete it, [v] compare the mods directory with the last completed update first",
+	"recovery.unattended":          "Restoring the mods from before that update, which is what unattended runs always do.",
+	"recovery.complete":            "This update replaces the mods directory completely.",
+	"recovery.restored":            "Restored %s to before the interrupted update.",
+	"recovery.failed":              "Restoring failed: %s. The files that update removed are kept in %s.",
+	"recovery.kept":                "Nothing was changed, you'll be asked again next time.",
+	"recovery.verify.none":         "No completed update was recorded to compare with.",
+	"recovery.verify.ok":           "The mods directory matches the last completed update.",
+	"recovery.verify.damaged":      "%d files differ from the last completed update: %s",
+	"preflight.requirements":       "  System:     this computer may not run the pack well:",
+	"preflight.requirements.issue": "              ! %s",
+	"requirements.ram.min":         "%s of memory, the pack needs at least %s; expect very low frame rates or crashes",
+	"requirements.ram.recommended": "%s of memory, the pack recommends %s; lower the render distance if the game stutters",
+	"requirements.java":            "java %s found, the pack needs java %d or newer; make sure the launcher uses a newer one",
+	"requirements.confirm":         "< This computer is below the minimum requirements of the pack. Update anyway?",
+	"download.reused":              "> Using %s, which the interrupted update already downloaded",
+	"freeze.indefinite":            "until you unfreeze",
+	"freeze.until":                 "until %s",
+	"freeze.set":                   "> Frozen %s: updates only check and repair the mods installed now. Run the updater with --unfreeze to update again.",
+	"freeze.unset":                 "> Not frozen anymore, the next run updates the mods.",
+	"freeze.expired":               "> The freeze ended, updating again.",
+	"freeze.active":                "> Frozen %s: the mods are only checked and repaired, not updated. Run the updater with --unfreeze to update again.",
+	"diagnose.network":             "Checking whether the pack can be reached over IPv4 and IPv6...",
+	"backup.unverified":            "! The backup in %s could not be verified, %d files did not read back intact. Rollbacks will use older backups for them.",
+	"backup.fallback":              "> The backup of %s from run %s is damaged, the verified one from run %s is used instead.",
+	"backup.pruned":                "Removed %d old backups, %s freed.",
+	"preflight.configonly":         "  Changes:    config files only, all %d mods already match the pack",
+	"configonly.escalated":         "This release only changes config files, but your mods don't match the pack, so they are updated too.",
+	"configonly.done":              "> Config-only update applied, %d files changed",
+	"plan.saved":                   "The update was planned without changing anything and saved to %s. Review it, then carry it out with \"apply\".",
+	"apply.usage":                  "Usage: apply <plan.json>",
+	"apply.drift":                  "%s can't be applied, things changed since it was planned:",
+	"apply.drift.field":            "%s was %v, is now %v",
+	"apply.drift.gone":             "planned: %s, no longer needed",
+	"apply.drift.changed":          "planned: %s, now: %s",
+	"apply.drift.new":              "not planned: %s",
+	"apply.matches":                "Nothing changed since %s was planned, carrying it out.",
+	"layout.change":                "The pack changes how mods are placed, from %s to %s: the installed mods are moved over, the old copies go to the backup.",
+	"layout.duplicate":             "Mod %s is installed more than once after the layout change: %s",
+	"options.recommended":          "The pack recommends other values for %d of your settings:",
+	"options.recommended.item":     "  - %s: %s -> %s",
+	"options.recommended.confirm":  "< Overwrite them with the recommended values?",
+	"options.recommended.none":     "Your settings already have the values the pack recommends.",
+	"cleanup.deferred":             "  %s couldn't be removed yet, probably another program still has it open. The next run removes it.",
+	"warning.external":             "Mods from other sites",
+	"protected.external":           "needed by the pack, not shipped with it",
+	"external.download":            "Downloading %s from its author, %s",
+	"external.failed":              "%s couldn't be downloaded from its author and is missing: %s",
+	"external.user.absent":         "%s, which you download yourself, is missing or not the release the pack needs. Get it from %s",
+	"external.user":                "The pack needs %d mods it isn't allowed to ship, you have to download them yourself:",
+	"external.user.missing":        "  - %s, from %s",
+	"external.user.mismatch":       "  - %s, from %s (%s is another release of it)",
+	"external.user.where":          "Save them into %s, the file names don't matter.",
+	"external.user.check":          "< Check again once they are there? Answering no updates without them.",
+	"preflight.external.download":  "  External:   %s is downloaded from %s",
+	"preflight.external.missing":   "  External:   %s is missing, download it yourself from %s",
+	"preflight.external.mismatch":  "  External:   %s is another release than the pack needs, download it yourself from %s",
+	"repair.external.user":         "  > %s is missing or changed. You download this mod yourself, from %s",
+	"menu.title":                   "What do you want to do? (arrows or numbers, Enter to choose, q to quit)",
+	"menu.update":                  "Update now",
+	"menu.verify":                  "Verify installation",
+	"menu.rollback":                "Roll back the last update",
+	"menu.settings":                "Change settings",
+	"menu.log":                     "View last log",
+	"menu.quit":                    "Quit",
+	"menu.log.none":                "Nothing has been logged yet.",
+	"menu.log.more":                "(%d earlier lines not shown, see %s)",
+	"settings.dir":                 "Mods directory [%s]",
+	"settings.version":             "Minecraft version [%s]",
+	"settings.language":            "Language, empty for the system's [%s]",
+	"settings.invalid":             "  %s, try again or press Enter to keep it.",
+	"settings.saved":               "> Settings saved to %s.",
+	"settings.unchanged":           "Nothing changed.",
+	"rollback.none":                "No update to roll back was found.",
+	"rollback.done":                "> Rolled back the update of run %s, the mods are as they were before it.",
+	"rollback.freeze":              "The next update installs the pack again; run the updater with --freeze to stay on these mods.",
+	"clock.ahead":                  "Your system clock is about %s ahead, which makes the download server's certificate look expired. Set your clock to the correct date and time, then run the updater again.",
+	"clock.behind":                 "Your system clock is about %s behind, which makes the download server's certificate look not yet valid. Set your clock to the correct date and time, then run the updater again.",
+	"clock.years":                  "%d years",
+	"clock.days":                   "%d days",
+	"clock.hours":                  "%d hours",
+	"clock.minutes":                "%d minutes",
+	"owned.header":                 "Files the updater manages for %s (%d):",
+	"owned.status":                 "STATUS",
+	"owned.pack":                   "PACK",
+	"owned.path":                   "PATH",
+	"owned.unmanaged":              "Unmanaged, left alone by the updater (%d):",
+	"owned.unmanaged.none":         "No unmanaged files.",
+	"servecache.start":             "Serving the download cache %s to the LAN on %s; players set \"cacheMirror\" in their clientUpdate.json to http://<this machine's IP>:<port>. Press Ctrl+C to stop.",
+	"servecache.stopped":           "Stopped serving the download cache.",
+	"download.lan":                 "Downloaded from the LAN mirror %s.",
+	"extract.failed":               "STOPPED: %d files of the pack archive couldn't be read, the archive is damaged:",
+	"extract.failed.critical":      "  > %d of them are mods the pack can't run without. Your installed mods were not touched.",
+	"extract.failed.nomanifest":    "  > The pack has no manifest telling which mods it can do without. Your installed mods were not touched.",
+	"extract.failed.skipped":       "%s couldn't be read from the damaged pack archive and was left out of the update: %s",
+	"access.controlledfolder":      "Windows blocked the updater from changing %s: it is inside %s, which Windows Security's ransomware protection (controlled folder access) guards. Allow the updater under Windows Security > Virus & threat protection > Ransomware protection > Allow an app through Controlled folder access, adding %s, or move the Minecraft instance out of that folder.",
+	"access.elevation":             "%s is inside %s, where only administrators may change files. Move the Minecraft instance to a folder of your own, or run the updater as administrator.",
+	"access.owner":                 "You may not change files in %s, it belongs to the account %s. Give your account write permission to the folder, or run the updater as that account.",
+	"access.denied":                "You may not change files in %s. Give your account write permission to the folder.",
+	"prelaunch.mcversion":          "STOPPED: this instance runs Minecraft %s, but the pack was installed for Minecraft %s and its mods would crash the game. Change the instance's Minecraft version, or run the updater for this version.",
+	"prelaunch.restored":           "Restored %d damaged pack files from earlier backups.",
+	"prelaunch.changed":            "The pack has changed since your last update, run the updater to get the new version.",
+	"prelaunch.unrepaired":         "STOPPED: %d pack files are missing or damaged and couldn't be restored. Run the updater or \"repair\".",
+	"prelaunch.ok":                 "Pack checked, starting the game.",
+	"warning.loader":               "Mod loader",
+	"loader.pin.conflict":          "The config pins loader release %s, but the pack needs %s on this Minecraft version; installing %s. Pass --force-loader to install your release anyway.",
+	"loader.pin.forced":            "Installing loader release %s as the config pins it, although the pack needs %s on this Minecraft version (--force-loader).",
+	"warning.state":                "Updater state",
+	"state.damaged":                "%s is damaged: %s.",
+	"state.restored":               "%s was restored from %s; changes made after that copy was written may be missing.",
+	"state.rebuilt":                "The record of installed mods couldn't be recovered and was rebuilt from %s. Every file there is assumed to belong to the pack until the next update.",
+	"state.journal.skipped":        "Line %[2]d of %[1]s is damaged and was skipped: %[3]v.",
+	"warning.pack":                 "Pack contents",
+	"pack.stray":                   "%s is a jar outside the pack's mods folders and is not installed. Move it into a mods folder or remove it from the pack.",
+	"mcroot.notfound":              "No versions folder or launcher_profiles.json was found above %s; %s is used as the minecraft directory.",
+	"verify.version":               "Comparing the mods in %s with pack version %s.",
+	"verify.differs":               "  > %s is missing or differs",
+	"verify.extra":                 "  > %s is not part of this pack version",
+	"verify.ok":                    "> Every mod matches, nothing differs.",
+	"pin.set":                      "> Pinned to pack version %s: updates only check and repair it. Run the updater with --unpin to update again.",
+	"pin.unset":                    "> Not pinned anymore, the next run updates the mods.",
+	"pin.active":                   "> Pinned to pack version %s: the mods are only checked and repaired, not updated. Run the updater with --unpin to update again.",
+	"diagnose.probe":               "  %s: the connection is %s, %s until the first byte, %.0f KB/s",
+	"download.probe":               "> The connection to the pack is %s: %s until the first byte, %.0f KB/s.",
+	"probe.fine":                   "fine",
+	"probe.slow":                   "slow",
+	"probe.very-slow":              "very slow",
+	"probe.broken":                 "broken",
+	"statedir.portable":            "> Portable mode: the updater keeps its files in %s.",
+	"statedir.legacy":              "> Took over the settings and history in %s, the updater keeps its files in %s now.",
+	"stage.done":                   "> Pack %s is downloaded, verified and staged. The next run or game start applies it in seconds, --discard-staged throws it away.",
+	"stage.replaced":               "> Pack %s was staged before, the newer pack replaces it.",
+	"stage.uptodate":               "> Pack %s is installed already, nothing to stage.",
+	"stage.apply":                  "> Applying the update to pack %s staged %s, nothing is downloaded.",
+	"stage.superseded":             "> The staged update to pack %s is out of date, the pack has a newer release. Downloading that instead.",
+	"stage.invalid":                "> The staged update can't be used, downloading the pack instead: %s",
+	"stage.none":                   "> No update is staged.",
+	"stage.discarded":              "> The staged update to pack %s was thrown away.",
+	"stage.sources":                "--stage-only stages the pack download, it can't be used with further sources or a local pack archive.",
+	"warning.shared":               "Shared minecraft directory",
+	"shared.warning":               "WARNING: this minecraft directory looks shared with another machine through a sync tool, and another updater may be changing it. Updating it from two machines at once leaves duplicate mods and sync conflicts behind.",
+	"shared.lock":                  "  An updater on %[1]s has been updating it since %[2]s.",
+	"shared.conflicts":             "%[1]d sync conflict copies are in %[2]s, made by a sync tool when two machines changed the same file:",
+	"shared.item":                  "  - %s",
+	"shared.confirm":               "Update anyway? Only do so if the other machine isn't updating right now.",
+	"shared.refused":               "Not updating %[1]s, %[2]s is updating it right now. Run the update again once it is done, or interactively to update anyway.",
+	"shared.cleanup":               "%[1]d sync conflict copies of the pack's files are in %[2]s; the pack's files themselves are the right ones:",
+	"shared.cleanup.item":          "  - %[1]s (a copy of %[2]s)",
+	"shared.cleanup.confirm":       "Move the conflict copies to the backup?",
+	"shared.cleanup.failed":        "The sync conflict copies could not all be moved: %s",
+	"shared.cleaned":               "Moved %[1]d sync conflict copies to %[2]s.",
+	"shared.conflicts.warn":        "%[1]d sync conflict copies in %[2]s, another machine may be updating it too",
+	"shared.cleanup.warn":          "%[1]d sync conflict copies of the pack's files are left in %[2]s, run the updater interactively to clean them up",
+	"preflight.configs":            "  Configs:    %d files outside the mods folder are written:",
+	"preflight.configs.add":        "                %s (new)",
+	"preflight.configs.overwrite":  "                %s (replaces yours, which is kept in the backup)",
+	"preflight.configs.skipped":    "  Configs:    %d files outside the mods folder are left alone for this run",
+	"configs.confirm":              "Also write the %d files outside the mods folder? With n only the mods are updated, the next update offers them again.",
+	"configs.skipped":              "Left %d files outside the mods folder alone, the next update offers them again.",
+	"configs.pending":              "The last update left %d files outside the mods folder alone, they are offered again.",
+	"diskfull.rolledback":          "The disk is full: everything this update changed was put back.",
+	"diskfull.rollback.failed":     "The disk is full and putting back what this update changed failed: %[1]s",
+	"preflight.junk":               "  Ignored junk: %[1]d files of the pack's configs aren't installed, see the log",
+	"why.usage":                    "Name the file to tell about, e.g. \"why sodium\".",
+	"why.file":                     "%[1]s",
+	"why.unknown":                  "  Came from: unknown (pre-existing), installed before the updater recorded where files come from",
+	"why.source":                   "  Source:    %[1]s",
+	"why.pack":                     "  Pack:      %[1]s",
+	"why.url":                      "  URL:       %[1]s",
+	"why.installed":                "  Installed: %[1]s",
+	"why.sha256":                   "  SHA-256:   %[1]s",
+	"why.status.ok":                "  Unchanged since it was installed.",
+	"why.status.modified":          "  Modified locally since it was installed.",
+	"why.status.missing":           "  Missing, it was removed since it was installed.",
+	"phase.backup":                 "backing up the mods",
+	"progress.files":               "%[1]d/%[2]d files, %[3]s/s",
+	"history.nobackup":             "no backup, --no-backup",
+	"backup.skip.warning":          "--no-backup: the mods replaced in %[1]s are deleted instead of backed up. This update can't be rolled back, and if it is stopped halfway the removed mods are gone.",
+	"backup.skip.confirm":          "Update without a backup?",
+	"backup.skip.kept":             "The mods are backed up as usual.",
+	"backup.skip.unconfirmed":      "--no-backup deletes the replaced mods for good, so an unattended run needs --yes with it to confirm that.",
+	"failures.banner":              "This update has failed %[1]d times in a row since %[2]s, the last time while %[3]s: %[4]s",
+	"failures.notify":              "The update has failed %[1]d times in a row since %[2]s. Open the updater to see why.",
+	"failures.remedy.cache":        "The downloads were damaged several times in a row, clearing the download cache to fetch everything again.",
+	"failures.remedy.pickdir":      "The mods directory %[1]s couldn't be used several times in a row, please choose it again.",
+	"progress.waiting":             "waiting",
+	"progress.done":                "done",
+	"progress.failed":              "failed: %[1]s",
+	"lint.usage":                   "Usage: lint-manifest [--json] <pack.json, checkout, archive or URL>",
+	"lint.ok":                      "%s: no problems found",
+	"lint.problems":                "%[1]s has %[2]d problems:",
+	"lint.nofiles":                 "The files the manifest refers to were not checked, no archive or checkout came with it.",
+}
+
+// catalog is the message catalog of the active language.
+var catalog = english
+
+// SetLanguage switches the message catalog to the given language, e.g.
+// "de" or "es_ES.UTF-8". Unknown languages leave English in place.
+func SetLanguage(language string) {
+	catalog = english
+	code := languageCode(language)
+	if code == "" || code == "en" {
+		return
+	}
+	content, err := translations.ReadFile("lang/" + code + ".json")
+	if err != nil {
+		return
+	}
+	translated := map[string]string{}
+	if err := json.Unmarshal(content, &translated); err != nil {
+		Logf("language %s: %s", code, err)
+		return
+	}
+	catalog = map[string]string{}
+	for key, text := range english {
+		catalog[key] = text
+	}
+	for key, text := range translated {
+		catalog[key] = text
+	}
+}
+
+// languageCode reduces a locale such as "de_DE.UTF-8" or "es-ES" to its
+// language code.
+func languageCode(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(locale, "_-.@"); i >= 0 {
+		locale = locale[:i]
+	}
+	if locale == "c" || locale == "posix" {
+		return ""
+	}
+	return locale
+}
+
+// T returns the message for key in the active language, formatted with args.
+func T(key string, args ...interface{}) string {
+	text, ok := catalog[key]
+	if !ok {
+		text = key
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// isYes reports whether a prompt answer is affirmative in the active
+// language, accepting both the full word and its first letter.
+func isYes(answer string) bool {
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if answer == "" {
+		return false
+	}
+	first, _ := utf8.DecodeRuneInString(answer)
+	for _, word := range strings.Split(T("answer.yes"), ",") {
+		word = strings.TrimSpace(word)
+		if word == answer {
+			return true
+		}
+		if r, _ := utf8.DecodeRuneInString(word); utf8.RuneLen(first) == len(answer) && r == first {
+			return true
+		}
+	}
+	return false
+}
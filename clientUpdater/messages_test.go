@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// useLanguage switches the messages to language for the test.
+func useLanguage(t *testing.T, language string) {
+	t.Helper()
+	SetLanguage(language)
+	t.Cleanup(func() { SetLanguage("") })
+}
+
+func TestSetLanguage(t *testing.T) {
+	tests := map[string]string{
+		"de_DE.UTF-8":    "[J/n]",
+		"de-AT":          "[J/n]",
+		"es_ES.UTF-8":    "[S/n]",
+		"es":             "[S/n]",
+		"en_US.UTF-8":    "[Y/n]",
+		"fr_FR.UTF-8":    "[Y/n]",
+		"C":              "[Y/n]",
+		"POSIX":          "[Y/n]",
+		"":               "[Y/n]",
+		"de_DE@euro":     "[J/n]",
+		"../../etc/de":   "[Y/n]",
+		"  DE_de.utf8  ": "[J/n]",
+	}
+	for locale, want := range tests {
+		useLanguage(t, locale)
+		if got := T("answer.hint.yes"); got != want {
+			t.Errorf("%q: %s, want %s", locale, got, want)
+		}
+	}
+}
+
+// TestTranslatedPrompt switches to German: the question and its hint are
+// German and "ja" is yes.
+func TestTranslatedPrompt(t *testing.T) {
+	useLanguage(t, "de_DE.UTF-8")
+	var confirmed bool
+	printed := captureStdout(t, func() {
+		confirmed = NewPrompter(strings.NewReader("ja\n"), false).Confirm(T("shared.cleanup.confirm"), false)
+	})
+	if !confirmed {
+		t.Error("ja wasn't taken for yes")
+	}
+	if printed != "Die Konfliktkopien ins Backup verschieben? [j/N]: " {
+		t.Errorf("prompted %q", printed)
+	}
+
+	SetLanguage("en")
+	printed = captureStdout(t, func() {
+		confirmed = NewPrompter(strings.NewReader("ja\n"), false).Confirm(T("shared.cleanup.confirm"), false)
+	})
+	if confirmed || printed != "Move the conflict copies to the backup? [y/N]: " {
+		t.Errorf("prompted %q in English, ja taken for yes: %t", printed, confirmed)
+	}
+}
+
+func TestIsYes(t *testing.T) {
+	tests := []struct {
+		language string
+		yes      []string
+		no       []string
+	}{
+		{"en", []string{"y", "Y", "yes", " YES "}, []string{"", "n", "no", "ja", "yep", "s"}},
+		{"de", []string{"j", "J", "ja", "Ja", "y", "yes"}, []string{"", "n", "nein", "jein", "jj"}},
+		{"es", []string{"s", "si", "sí", "SÍ", "y"}, []string{"", "n", "no", "sip", "j"}},
+	}
+	for _, test := range tests {
+		useLanguage(t, test.language)
+		for _, answer := range test.yes {
+			if !isYes(answer) {
+				t.Errorf("%s: %q isn't yes", test.language, answer)
+			}
+		}
+		for _, answer := range test.no {
+			if isYes(answer) {
+				t.Errorf("%s: %q is yes", test.language, answer)
+			}
+		}
+	}
+}
+
+// formatVerb matches the verbs of a message, with their argument index.
+var formatVerb = regexp.MustCompile(`%(\[\d+\])?[-+# 0]*\d*(\.\d+)?[a-zA-Z%]`)
+
+// TestTranslationsMatchEnglish checks every translated message has an
+// English one, and the same format verbs as it, in any order.
+func TestTranslationsMatchEnglish(t *testing.T) {
+	entries, err := translations.ReadDir("lang")
+	if err != nil || len(entries) == 0 {
+		t.Fatalf("translations: %v", err)
+	}
+	for _, entry := range entries {
+		content, _ := translations.ReadFile("lang/" + entry.Name())
+		translated := map[string]string{}
+		if err := json.Unmarshal(content, &translated); err != nil {
+			t.Fatalf("%s: %s", entry.Name(), err)
+		}
+		for key, text := range translated {
+			english, ok := english[key]
+			if !ok {
+				t.Errorf("%s: %s isn't an English message", entry.Name(), key)
+				continue
+			}
+			if got, want := verbs(text), verbs(english); got != want {
+				t.Errorf("%s: %s has the verbs %s, English %s", entry.Name(), key, got, want)
+			}
+		}
+	}
+}
+
+// verbs lists the format verbs of a message, sorted, those without an
+// index numbered as fmt does.
+func verbs(text string) string {
+	var found []string
+	next := 1
+	for _, verb := range formatVerb.FindAllStringSubmatch(text, -1) {
+		if verb[0] == "%%" {
+			continue
+		}
+		if verb[1] != "" {
+			next, _ = strconv.Atoi(strings.Trim(verb[1], "[]"))
+		}
+		found = append(found, fmt.Sprintf("%d%s", next, verb[0][len(verb[0])-1:]))
+		next++
+	}
+	sort.Strings(found)
+	return strings.Join(found, " ")
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The metrics an update run writes to metricsFile, for fleet monitoring,
+// e.g. by node_exporter's textfile collector. Dashboards and alerts are
+// built on these names and labels: they are never renamed or given other
+// meanings, only new ones are added.
+const (
+	// metricInfo is 1, labelled with the version of the updater.
+	metricInfo = "rxmc_updater_info"
+	// metricLastRunTimestamp is when the last run ended, in seconds since
+	// the Unix epoch.
+	metricLastRunTimestamp = "rxmc_updater_last_run_timestamp_seconds"
+	// metricLastRunExitCode is the exit code of the last run, see
+	// categoryExitCodes and the exit* constants.
+	metricLastRunExitCode = "rxmc_updater_last_run_exit_code"
+	// metricLastRunSuccess is 1 when the last run got through: updated,
+	// up to date or updated with warnings.
+	metricLastRunSuccess = "rxmc_updater_last_run_success"
+	// metricLastRunDuration is how long the last run took.
+	metricLastRunDuration = "rxmc_updater_last_run_duration_seconds"
+	// metricLastRunPhaseDuration is how long the last run spent in each
+	// phase, labelled phase: every phase of phaseOrder, 0 for the ones it
+	// didn't get to.
+	metricLastRunPhaseDuration = "rxmc_updater_last_run_phase_duration_seconds"
+	// metricLastRunTargetPhaseDuration is how long each further target of
+	// the last run spent in each phase, labelled target, its mods
+	// directory, and phase: the phases it went through, in phaseOrder.
+	// Left out when the run updated no further targets.
+	metricLastRunTargetPhaseDuration = "rxmc_updater_last_run_target_phase_duration_seconds"
+	// metricLastRunDownloaded is the bytes the last run downloaded: pack
+	// archives, installers and metadata, mirrors included.
+	metricLastRunDownloaded = "rxmc_updater_last_run_downloaded_bytes"
+	// metricLastRunFiles is the mod files the last run changed in the mods
+	// directory, labelled change: added, removed or unchanged. Further
+	// targets aren't counted, nor are runs that failed before swapping.
+	metricLastRunFiles = "rxmc_updater_last_run_files"
+	// metricLastRunCacheLookups is the last run's lookups in the download
+	// cache, labelled result: hit, a copy in the cache was used, or miss.
+	metricLastRunCacheLookups = "rxmc_updater_last_run_cache_lookups"
+	// metricLastRunCacheHitRatio is the share of hits among them, left out
+	// when the run looked nothing up.
+	metricLastRunCacheHitRatio = "rxmc_updater_last_run_cache_hit_ratio"
+	// metricConsecutiveFailures is the update runs in a row, the last one
+	// included, that failed the same way, 0 after one that got through.
+	metricConsecutiveFailures = "rxmc_updater_consecutive_failures"
+)
+
+// phaseOrder is the phases of a run in the order they come.
+var phaseOrder = []string{phaseStartup, phaseDownload, phaseConfirm, phaseLoader, phaseBackup, phaseSwap, phaseTransforms, phaseTargets, phaseFinish}
+
+// MetricSample is a value of a metric, with its labels.
+type MetricSample struct {
+	Labels [][2]string
+	Value  float64
+}
+
+// MetricFamily is a gauge and its samples. Unit, when set, is the suffix
+// of its name OpenMetrics requires for it.
+type MetricFamily struct {
+	Name    string
+	Help    string
+	Unit    string
+	Samples []MetricSample
+}
+
+// FormatOpenMetrics writes families in the OpenMetrics text format, in the
+// order given, ending with the # EOF line.
+func FormatOpenMetrics(w io.Writer, families []MetricFamily) error {
+	var b strings.Builder
+	for _, family := range families {
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", family.Name)
+		if family.Unit != "" {
+			fmt.Fprintf(&b, "# UNIT %s %s\n", family.Name, family.Unit)
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n", family.Name, escapeMetricText(family.Help, false))
+		for _, sample := range family.Samples {
+			b.WriteString(family.Name)
+			if len(sample.Labels) > 0 {
+				b.WriteString("{")
+				for i, label := range sample.Labels {
+					if i > 0 {
+						b.WriteString(",")
+					}
+					fmt.Fprintf(&b, "%s=\"%s\"", label[0], escapeMetricText(label[1], true))
+				}
+				b.WriteString("}")
+			}
+			b.WriteString(" " + formatMetricValue(sample.Value) + "\n")
+		}
+	}
+	b.WriteString("# EOF\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// escapeMetricText escapes the text of a HELP line, or of a label value
+// when quoted, whose double quotes are escaped too.
+func escapeMetricText(s string, quoted bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quoted {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
+
+// formatMetricValue writes whole numbers without a fraction or exponent.
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// TargetPhases is the time a further target spent in each phase.
+type TargetPhases struct {
+	Target string
+	Phases map[string]time.Duration
+}
+
+// RunMetrics is what an update run measured.
+type RunMetrics struct {
+	Version       string
+	Ended         time.Time
+	ExitCode      int
+	Duration      time.Duration
+	Phases        map[string]time.Duration
+	TargetPhases  []TargetPhases
+	Downloaded    int64
+	Added         int
+	Removed       int
+	Unchanged     int
+	CacheHits     int64
+	CacheMisses   int64
+	FailuresInRow int
+}
+
+// Families returns the metrics of the run, see the metric* constants.
+func (m RunMetrics) Families() []MetricFamily {
+	gauge := func(name string, unit string, help string, value float64) MetricFamily {
+		return MetricFamily{Name: name, Unit: unit, Help: help, Samples: []MetricSample{{Value: value}}}
+	}
+	success := 0.0
+	if m.ExitCode == exitOK || m.ExitCode == exitUpToDate || m.ExitCode == exitWarnings {
+		success = 1
+	}
+	phases := MetricFamily{Name: metricLastRunPhaseDuration, Unit: "seconds", Help: "Time the last run spent in each phase."}
+	for _, phase := range phaseOrder {
+		phases.Samples = append(phases.Samples, MetricSample{Labels: [][2]string{{"phase", phase}}, Value: m.Phases[phase].Seconds()})
+	}
+
+	targetPhases := MetricFamily{Name: metricLastRunTargetPhaseDuration, Unit: "seconds", Help: "Time each further target of the last run spent in each phase."}
+	for _, target := range m.TargetPhases {
+		for _, phase := range phaseOrder {
+			if d, ok := target.Phases[phase]; ok {
+				targetPhases.Samples = append(targetPhases.Samples, MetricSample{Labels: [][2]string{{"target", target.Target}, {"phase", phase}}, Value: d.Seconds()})
+			}
+		}
+	}
+
+	families := []MetricFamily{
+		{Name: metricInfo, Help: "Version of the updater.", Samples: []MetricSample{{Labels: [][2]string{{"version", m.Version}}, Value: 1}}},
+		gauge(metricLastRunTimestamp, "seconds", "When the last run ended, in seconds since the Unix epoch.", float64(m.Ended.UnixNano()/int64(time.Millisecond))/1000),
+		gauge(metricLastRunExitCode, "", "Exit code of the last run.", float64(m.ExitCode)),
+		gauge(metricLastRunSuccess, "", "Whether the last run got through: updated, up to date or updated with warnings.", success),
+		gauge(metricLastRunDuration, "seconds", "How long the last run took.", m.Duration.Seconds()),
+		phases,
+		gauge(metricLastRunDownloaded, "bytes", "Bytes the last run downloaded.", float64(m.Downloaded)),
+		{Name: metricLastRunFiles, Help: "Mod files the last run changed in the mods directory.", Samples: []MetricSample{
+			{Labels: [][2]string{{"change", "added"}}, Value: float64(m.Added)},
+			{Labels: [][2]string{{"change", "removed"}}, Value: float64(m.Removed)},
+			{Labels: [][2]string{{"change", "unchanged"}}, Value: float64(m.Unchanged)},
+		}},
+		{Name: metricLastRunCacheLookups, Help: "Lookups of the last run in the download cache.", Samples: []MetricSample{
+			{Labels: [][2]string{{"result", "hit"}}, Value: float64(m.CacheHits)},
+			{Labels: [][2]string{{"result", "miss"}}, Value: float64(m.CacheMisses)},
+		}},
+	}
+	if lookups := m.CacheHits + m.CacheMisses; lookups > 0 {
+		families = append(families, gauge(metricLastRunCacheHitRatio, "ratio", "Share of the last run's cache lookups that were hits.", float64(m.CacheHits)/float64(lookups)))
+	}
+	if len(targetPhases.Samples) > 0 {
+		families = append(families, targetPhases)
+	}
+	return append(families, gauge(metricConsecutiveFailures, "", "Update runs in a row that failed the same way.", float64(m.FailuresInRow)))
+}
+
+// runStarted is when the run started, as near as the updater can tell.
+var runStarted = clock.Now()
+
+// bytesDownloaded, cacheHits and cacheMisses count what this run
+// downloaded and looked up in the cache.
+var bytesDownloaded, cacheHits, cacheMisses int64
+
+// countDownloaded counts n bytes downloaded.
+func countDownloaded(n int64) {
+	atomic.AddInt64(&bytesDownloaded, n)
+}
+
+// countCacheLookup counts a lookup in the cache, hit when a copy in the
+// cache was used.
+func countCacheLookup(hit bool) {
+	if hit {
+		atomic.AddInt64(&cacheHits, 1)
+	} else {
+		atomic.AddInt64(&cacheMisses, 1)
+	}
+}
+
+// runFiles are the mod files the run changed, set by RecordFileChanges.
+var runFiles struct {
+	added, removed, unchanged int
+}
+
+// RecordFileChanges records the mod files the run changed.
+func RecordFileChanges(added int, removed int, unchanged int) {
+	runFiles.added, runFiles.removed, runFiles.unchanged = added, removed, unchanged
+}
+
+// metricsPath is where update runs write their metrics, set by
+// EnableMetrics. Other modes leave it empty and write none.
+var metricsPath string
+
+// EnableMetrics has the metrics of this run written to p when it ends,
+// none when p is empty.
+func EnableMetrics(p string) {
+	metricsPath = p
+}
+
+// WriteRunMetrics writes the metrics of the run ending with code. Failing
+// to never fails the run, the metrics are left out.
+func WriteRunMetrics(code int) {
+	if metricsPath == "" {
+		return
+	}
+	writeRunMetrics(code, PhaseDurations(), TargetPhaseDurations())
+}
+
+// writeRunMetrics is WriteRunMetrics for callers holding runState's lock,
+// with the phase durations they took.
+func writeRunMetrics(code int, phases map[string]time.Duration, targetPhases []TargetPhases) {
+	if metricsPath == "" {
+		return
+	}
+	failuresInRow := 0
+	if failuresPath != "" && code != exitOK && code != exitUpToDate && code != exitWarnings {
+		if record, err := ReadFailureRecord(failuresPath); err == nil && record != nil {
+			failuresInRow = record.Count
+		}
+	}
+	metrics := RunMetrics{
+		Version:       Version,
+		Ended:         clock.Now(),
+		ExitCode:      code,
+		Duration:      clock.Now().Sub(runStarted),
+		Phases:        phases,
+		TargetPhases:  targetPhases,
+		Downloaded:    atomic.LoadInt64(&bytesDownloaded),
+		Added:         runFiles.added,
+		Removed:       runFiles.removed,
+		Unchanged:     runFiles.unchanged,
+		CacheHits:     atomic.LoadInt64(&cacheHits),
+		CacheMisses:   atomic.LoadInt64(&cacheMisses),
+		FailuresInRow: failuresInRow,
+	}
+	var b strings.Builder
+	FormatOpenMetrics(&b, metrics.Families())
+	// written whole or not at all, a collector never reads half a file
+	if err := writeAtomic(metricsPath, []byte(b.String())); err != nil {
+		Logf("metrics: writing %s: %s", metricsPath, err)
+		return
+	}
+	Logf("metrics: written to %s", metricsPath)
+}
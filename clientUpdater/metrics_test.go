@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares got with the golden file testdata/name, rewriting
+// it instead with -update.
+func checkGolden(t *testing.T, name string, got string) {
+	t.Helper()
+	p := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(p, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want := strings.ReplaceAll(readFile(t, p), "\r\n", "\n")
+	if got != want {
+		t.Errorf("%s differs, got:\n%s", name, got)
+	}
+}
+
+func TestFormatOpenMetrics(t *testing.T) {
+	tests := []struct {
+		golden  string
+		metrics RunMetrics
+	}{
+		{
+			golden: "metrics-updated.golden",
+			metrics: RunMetrics{
+				Version:  "1.4.0",
+				Ended:    time.Date(2024, 6, 1, 12, 3, 30, 250e6, time.UTC),
+				ExitCode: exitOK,
+				Duration: 210250 * time.Millisecond,
+				Phases: map[string]time.Duration{
+					phaseStartup:  500 * time.Millisecond,
+					phaseDownload: 95 * time.Second,
+					phaseConfirm:  4 * time.Second,
+					phaseSwap:     12500 * time.Millisecond,
+					phaseTargets:  90 * time.Second,
+					phaseFinish:   8250 * time.Millisecond,
+				},
+				TargetPhases: []TargetPhases{
+					{Target: `C:\Games\Survival\mods`, Phases: map[string]time.Duration{phaseLoader: 30 * time.Second, phaseSwap: 60 * time.Second}},
+				},
+				Downloaded:  104857600,
+				Added:       3,
+				Removed:     1,
+				Unchanged:   140,
+				CacheHits:   3,
+				CacheMisses: 1,
+			},
+		},
+		{
+			golden: "metrics-failed.golden",
+			metrics: RunMetrics{
+				Version:       "dev \"local\"",
+				Ended:         time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC),
+				ExitCode:      exitTimedOut,
+				Duration:      30 * time.Minute,
+				Phases:        map[string]time.Duration{phaseStartup: time.Second, phaseDownload: 1799 * time.Second},
+				FailuresInRow: 4,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.golden, func(t *testing.T) {
+			var b strings.Builder
+			if err := FormatOpenMetrics(&b, test.metrics.Families()); err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, test.golden, b.String())
+		})
+	}
+}
+
+func TestEscapeMetricText(t *testing.T) {
+	if got := escapeMetricText("a\\b\n\"c\"", false); got != `a\\b\n"c"` {
+		t.Errorf("help text escaped as %s", got)
+	}
+	if got := escapeMetricText("a\\b\n\"c\"", true); got != `a\\b\n\"c\"` {
+		t.Errorf("label value escaped as %s", got)
+	}
+}
+
+// useMetrics has the test's run write its metrics to p, none when empty,
+// its duration counting from now on the clock.
+func useMetrics(t *testing.T, p string) {
+	t.Helper()
+	savedStarted := runStarted
+	runStarted = clock.Now()
+	EnableMetrics(p)
+	t.Cleanup(func() {
+		EnableMetrics("")
+		runStarted = savedStarted
+	})
+}
+
+func TestWriteRunMetrics(t *testing.T) {
+	fake := useFakeClock(t)
+	useRunState(t)
+	p := filepath.Join(t.TempDir(), "updater.prom")
+	useMetrics(t, p)
+	fake.Advance(90 * time.Second)
+	WriteRunMetrics(exitUpToDate)
+
+	content := readFile(t, p)
+	for _, line := range []string{
+		metricLastRunExitCode + " 10\n",
+		metricLastRunSuccess + " 1\n",
+		metricLastRunDuration + " 90\n",
+		metricLastRunTimestamp + " 1717243290\n",
+		"# EOF\n",
+	} {
+		if !strings.Contains(content, line) {
+			t.Errorf("no %q in\n%s", line, content)
+		}
+	}
+}
+
+func TestWriteRunMetricsNeverFailsTheRun(t *testing.T) {
+	useFakeClock(t)
+	useRunState(t)
+	// the parent is a file, the metrics can't be written
+	parent := writeTemp(t, "not a directory")
+	useMetrics(t, filepath.Join(parent, "updater.prom"))
+	WriteRunMetrics(exitOK)
+	if _, err := os.Stat(filepath.Join(parent, "updater.prom")); err == nil {
+		t.Error("the metrics were written")
+	}
+}
+
+func TestWriteRunMetricsDisabled(t *testing.T) {
+	useFakeClock(t)
+	dir := t.TempDir()
+	useMetrics(t, "")
+	WriteRunMetrics(exitOK)
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("wrote %v", entries)
+	}
+}
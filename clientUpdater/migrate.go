@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// migratedItems are what a migration takes along from the minecraft
+// directory: the managed mods and what the player set up around them.
+// Worlds, screenshots and logs stay where they are.
+var migratedItems = []string{"mods", "config", "resourcepacks", "servers.dat", "options.txt"}
+
+// defaultInstanceName names the instance a migration creates.
+const defaultInstanceName = "rxmc"
+
+// migratedReadme is left in the old mods directory, so a player looking
+// there finds out where the updater moved to.
+const migratedReadme = "README-clientUpdate.txt"
+
+// mmcPack is the mmc-pack.json of an instance, listing the components
+// the launcher sets up. Dependencies, e.g. Fabric's intermediary mappings,
+// are added by the launcher itself.
+type mmcPack struct {
+	Components    []mmcComponent `json:"components"`
+	FormatVersion int            `json:"formatVersion"`
+}
+
+type mmcComponent struct {
+	UID     string `json:"uid"`
+	Version string `json:"version"`
+	// CachedVersion is the version Prism Launcher resolved a component
+	// to, set when Version isn't.
+	CachedVersion string `json:"cachedVersion,omitempty"`
+	Important     bool   `json:"important,omitempty"`
+}
+
+// WriteInstanceFiles writes the instance.cfg and mmc-pack.json of an
+// instance running loaderVersion of the loader on Minecraft mcVersion.
+func WriteInstanceFiles(dir string, name string, mcVersion string, loader Loader, loaderVersion string) error {
+	cfg := fmt.Sprintf("InstanceType=OneSix\nname=%s\n", name)
+	if err := writeAtomic(filepath.Join(dir, "instance.cfg"), []byte(cfg)); err != nil {
+		return err
+	}
+	pack := mmcPack{
+		Components: []mmcComponent{
+			{UID: "net.minecraft", Version: mcVersion, Important: true},
+			{UID: loader.InstanceComponent(), Version: loaderVersion},
+		},
+		FormatVersion: 1,
+	}
+	content, err := json.MarshalIndent(pack, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeAtomic(filepath.Join(dir, "mmc-pack.json"), append(content, '\n'))
+}
+
+// Migration moves the setup of a minecraft directory the official
+// launcher uses into a new Prism Launcher or MultiMC instance.
+type Migration struct {
+	// From is the minecraft directory migrated, InstanceDir the instance
+	// created, which must not exist yet.
+	From          string
+	InstanceDir   string
+	Name          string
+	MCVersion     string
+	Loader        Loader
+	LoaderVersion string
+	// Move removes the migrated files from From once the instance is
+	// complete, instead of leaving a copy.
+	Move bool
+}
+
+// PlanMigration works out the migration of the minecraft directory
+// holding modPath into a new instance called name below instancesDir.
+// The loader must already be installed there, its version is what the
+// instance runs.
+func PlanMigration(modPath string, instancesDir string, name string, mcVersion string, loader Loader) (*Migration, error) {
+	from := filepath.Dir(modPath)
+	m := &Migration{From: from, InstanceDir: filepath.Join(instancesDir, name), Name: name, MCVersion: mcVersion, Loader: loader}
+	if _, err := os.Stat(m.InstanceDir); err == nil {
+		return nil, fmt.Errorf("%s already exists, choose another name with --name", m.InstanceDir)
+	}
+	m.LoaderVersion = InstalledLoaderVersion(loader, filepath.Join(from, "versions"), mcVersion)
+	if m.LoaderVersion == "" {
+		return nil, fmt.Errorf("%s is not installed for Minecraft %s in %s, run a normal update first", loader.Name(), mcVersion, from)
+	}
+	return m, nil
+}
+
+// ModPath is the mods directory of the new instance.
+func (m *Migration) ModPath() string {
+	return filepath.Join(m.InstanceDir, ".minecraft", "mods")
+}
+
+// Print shows what the migration is going to do.
+func (m *Migration) Print() {
+	fmt.Println(T("migrate.plan", m.From, m.InstanceDir))
+	fmt.Println(T("migrate.plan.minecraft", m.MCVersion, m.Loader.Name(), m.LoaderVersion))
+	var items []string
+	for _, item := range migratedItems {
+		if _, err := os.Stat(filepath.Join(m.From, item)); err == nil {
+			items = append(items, item)
+		}
+	}
+	if m.Move {
+		fmt.Println(T("migrate.plan.move", strings.Join(items, ", ")))
+	} else {
+		fmt.Println(T("migrate.plan.copy", strings.Join(items, ", ")))
+	}
+}
+
+// Execute creates the instance and copies everything into it. The mods
+// are compared with the originals by hash, so the installed state recorded
+// by the last update still describes them and the next update stays
+// incremental. Only then are the originals removed when moving, and the
+// old mods directory gets a note pointing to the instance. A failed
+// migration removes the half-created instance and leaves the originals
+// alone.
+func (m *Migration) Execute() (err error) {
+	minecraftPath := filepath.Join(m.InstanceDir, ".minecraft")
+	if err := os.MkdirAll(minecraftPath, dirPerm); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(m.InstanceDir)
+		}
+	}()
+	if err := WriteInstanceFiles(m.InstanceDir, m.Name, m.MCVersion, m.Loader, m.LoaderVersion); err != nil {
+		return err
+	}
+	var copied []string
+	for _, item := range migratedItems {
+		src := filepath.Join(m.From, item)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue
+		}
+		if err := copyTree(src, filepath.Join(minecraftPath, item)); err != nil {
+			return fmt.Errorf("copying %s: %s", item, err)
+		}
+		copied = append(copied, src)
+	}
+	if err := compareMods(filepath.Join(m.From, "mods"), m.ModPath()); err != nil {
+		return err
+	}
+	Logf("migrate: copied %s into %s", strings.Join(copied, ", "), m.InstanceDir)
+
+	if m.Move {
+		for _, src := range copied {
+			if err := os.RemoveAll(src); err != nil {
+				// the instance is complete, what's left is only a
+				// leftover copy
+				Logf("migrate: removing %s: %s", src, err)
+			}
+		}
+	}
+	oldMods := filepath.Join(m.From, "mods")
+	if err := os.MkdirAll(oldMods, dirPerm); err == nil {
+		err = writeAtomic(filepath.Join(oldMods, migratedReadme), []byte(T("migrate.readme", m.ModPath())+"\n"))
+		if err != nil {
+			Logf("migrate: writing %s: %s", migratedReadme, err)
+		}
+	}
+	return nil
+}
+
+// copyTree copies the file or directory src to dst.
+func copyTree(src string, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, dirPerm)
+		}
+		if !info.Mode().IsRegular() {
+			Logf("migrate: skipping %s, not a regular file", p)
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), dirPerm); err != nil {
+			return err
+		}
+		return copyFile(p, target)
+	})
+}
+
+// compareMods checks the copied mods directory holds exactly the files of
+// the original.
+func compareMods(original string, copied string) error {
+	want, err := ScanInstalledFiles(original)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	got, err := ScanInstalledFiles(copied)
+	if err != nil {
+		return err
+	}
+	sums := map[string]string{}
+	for _, file := range got {
+		sums[file.Name] = file.SHA256
+	}
+	for _, file := range want {
+		if sums[file.Name] != file.SHA256 {
+			return fmt.Errorf("%s differs from the original after copying", filepath.Join(copied, file.Name))
+		}
+	}
+	return nil
+}
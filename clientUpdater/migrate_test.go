@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWriteInstanceFiles compares the instance files written for each
+// loader with those of testdata/instance, as Prism Launcher reads them.
+func TestWriteInstanceFiles(t *testing.T) {
+	tests := []struct {
+		loader        Loader
+		mcVersion     string
+		loaderVersion string
+	}{
+		{fabricLoader{}, "1.20.1", "0.15.11"},
+		{quiltLoader{}, "1.20.1", "0.26.0-beta.1"},
+		{neoForgeLoader{}, "1.21.1", "21.1.77"},
+	}
+	for _, test := range tests {
+		t.Run(test.loader.Name(), func(t *testing.T) {
+			dir := t.TempDir()
+			if err := WriteInstanceFiles(dir, "rxmc", test.mcVersion, test.loader, test.loaderVersion); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"instance.cfg", "mmc-pack.json"} {
+				checkGolden(t, filepath.Join("instance", test.loader.Name(), name), readFile(t, filepath.Join(dir, name)))
+			}
+		})
+	}
+}
+
+func TestPlanMigration(t *testing.T) {
+	instances := t.TempDir()
+	writeFiles(t, instances, map[string]string{"taken/instance.cfg": ""})
+	mods := filepath.Join(fixtureVersions, "..", "mods")
+	m, err := PlanMigration(mods, instances, "rxmc", "1.20.1", fabricLoader{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.LoaderVersion != "0.16.5" || m.ModPath() != filepath.Join(instances, "rxmc", ".minecraft", "mods") {
+		t.Errorf("planned %+v", m)
+	}
+	if _, err := PlanMigration(mods, instances, "taken", "1.20.1", fabricLoader{}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("into an existing instance: %v", err)
+	}
+	if _, err := PlanMigration(mods, instances, "rxmc", "1.20.1", neoForgeLoader{}); err == nil || !strings.Contains(err.Error(), "run a normal update first") {
+		t.Errorf("without the loader: %v", err)
+	}
+}
+
+// TestMigrateEndToEnd migrates an updated minecraft directory into a new
+// instance: the player's setup goes along, their worlds stay, the config
+// points at the instance and the next update has nothing to change.
+func TestMigrateEndToEnd(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium", "mods/iris.jar": "iris"})
+	writeFiles(t, u.minecraft, map[string]string{
+		"config/sodium.json":    "{}",
+		"resourcepacks/hd.zip":  "PK",
+		"options.txt":           "fov:1.0",
+		"servers.dat":           "servers",
+		"saves/World/level.dat": "world",
+	})
+	configPath := filepath.Join(u.state, "clientUpdate.json")
+	if err := os.MkdirAll(u.state, 0755); err != nil {
+		t.Fatal(err)
+	}
+	SaveConfig(ConfFile{MCDirectory: u.mods}, configPath)
+	u.run(t)
+	instances := filepath.Join(t.TempDir(), "instances")
+	run := func(args ...string) string {
+		u.clock.Advance(time.Minute)
+		return readFile(t, runMain(t, append([]string{"--portable", u.state, "--yes", "--no-telemetry", "--no-tui", "--mc-version", "1.20.1"}, args...)...))
+	}
+
+	output := run("migrate", "--instances", instances, "--move")
+	instance := filepath.Join(instances, defaultInstanceName)
+	if got := dirNames(t, filepath.Join(instance, ".minecraft")); got != "config mods options.txt resourcepacks servers.dat" {
+		t.Fatalf("migrated %s, output:\n%s", got, output)
+	}
+	if got := readFile(t, filepath.Join(instance, "mmc-pack.json")); !strings.Contains(got, `"version": "0.15.11"`) {
+		t.Errorf("mmc-pack.json:\n%s", got)
+	}
+	if got := dirNames(t, u.minecraft); got != "launcher_profiles.json mods saves versions" {
+		t.Errorf("left %s", got)
+	}
+	if got := dirNames(t, u.mods); got != migratedReadme || !strings.Contains(readFile(t, filepath.Join(u.mods, migratedReadme)), filepath.Join(instance, ".minecraft", "mods")) {
+		t.Errorf("left %s in the old mods directory", got)
+	}
+	config, _, err := ReadConfig(configPath)
+	if err != nil || config.MCDirectory != filepath.Join(instance, ".minecraft", "mods") {
+		t.Fatalf("config points at %s: %v", config.MCDirectory, err)
+	}
+
+	// the installed state still matches the copied mods
+	state, err := ReadInstalledState(filepath.Join(u.state, "clientUpdate-installed.json"))
+	if err != nil || state == nil {
+		t.Fatalf("installed state: %v", err)
+	}
+	if damaged := VerifyInstalled(state, config.MCDirectory); len(damaged) != 0 {
+		t.Errorf("changed by the migration: %v", damaged)
+	}
+	useFakeJava(t)
+	output = run()
+	if !strings.Contains(output, "0 mods added or updated, 0 removed, 2 unchanged") {
+		t.Errorf("the update after migrating isn't incremental, output:\n%s", output)
+	}
+	if got := dirNames(t, filepath.Join(instance, ".minecraft", "mods")); got != "iris.jar sodium.jar" {
+		t.Errorf("installed %s", got)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ModInfo identifies a mod jar by the metadata it carries.
+type ModInfo struct {
+	ID      string
+	Version string
+	// File is the jar's name in the mods directory or the archive.
+	File string
+}
+
+var (
+	tomlModID   = regexp.MustCompile(`(?m)^\s*modId\s*=\s*"([^"]+)"`)
+	tomlVersion = regexp.MustCompile(`(?m)^\s*version\s*=\s*"([^"]+)"`)
+)
+
+// readModInfo reads the mod id and version from a jar, understanding
+// Fabric, Quilt and NeoForge metadata.
+func readModInfo(r *zip.Reader, file string) (*ModInfo, error) {
+	for _, f := range r.File {
+		switch f.Name {
+		case "fabric.mod.json", "quilt.mod.json", "META-INF/neoforge.mods.toml", "META-INF/mods.toml":
+		default:
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		info := &ModInfo{File: file}
+		switch f.Name {
+		case "fabric.mod.json":
+			var meta struct {
+				ID      string `json:"id"`
+				Version string `json:"version"`
+			}
+			// some mods ship raw newlines inside strings, which is still
+			// good enough to find the id and version
+			if err := json.Unmarshal(bytes.Replace(content, []byte("\n"), []byte(" "), -1), &meta); err != nil {
+				return nil, fmt.Errorf("%s: fabric.mod.json: %s", file, err)
+			}
+			info.ID, info.Version = meta.ID, meta.Version
+		case "quilt.mod.json":
+			var meta struct {
+				QuiltLoader struct {
+					ID      string `json:"id"`
+					Version string `json:"version"`
+				} `json:"quilt_loader"`
+			}
+			if err := json.Unmarshal(content, &meta); err != nil {
+				return nil, fmt.Errorf("%s: quilt.mod.json: %s", file, err)
+			}
+			info.ID, info.Version = meta.QuiltLoader.ID, meta.QuiltLoader.Version
+		default:
+			if m := tomlModID.FindSubmatch(content); m != nil {
+				info.ID = string(m[1])
+			}
+			if m := tomlVersion.FindSubmatch(content); m != nil {
+				info.Version = string(m[1])
+			}
+		}
+		if info.ID == "" {
+			return nil, fmt.Errorf("%s: no mod id in %s", file, f.Name)
+		}
+		return info, nil
+	}
+	return nil, fmt.Errorf("%s: no mod metadata", file)
+}
+
+// ReadModInfo reads the metadata of a jar on disk.
+func ReadModInfo(jarPath string) (*ModInfo, error) {
+	r, err := zip.OpenReader(jarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readModInfo(&r.Reader, filepath.Base(jarPath))
+}
+
+// readArchivedModInfo reads the metadata of a jar inside the pack archive.
+func readArchivedModInfo(f *zip.File) (*ModInfo, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(rc, 256<<20))
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+	return readModInfo(r, filepath.Base(f.Name))
+}
+
+// ScanMods groups the jars in modPath by mod id. Jars without readable
+// metadata are left out.
+func ScanMods(modPath string) (map[string][]ModInfo, error) {
+	entries, err := os.ReadDir(modPath)
+	if err != nil {
+		return nil, err
+	}
+	mods := map[string][]ModInfo{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".jar") {
+			continue
+		}
+		info, err := ReadModInfo(filepath.Join(modPath, entry.Name()))
+		if err != nil {
+			continue
+		}
+		mods[info.ID] = append(mods[info.ID], *info)
+	}
+	return mods, nil
+}
+
+// Critical mod check states.
+const (
+	criticalOK        = "OK"
+	criticalMissing   = "MISSING"
+	criticalDuplicate = "DUPLICATE"
+	criticalMismatch  = "WRONG VERSION"
+)
+
+// CriticalCheck is the outcome of checking one critical mod.
+type CriticalCheck struct {
+	ID       string
+	State    string
+	Version  string
+	Expected string
+	Files    []string
+}
+
+func (c CriticalCheck) String() string {
+	switch c.State {
+	case criticalOK:
+		return fmt.Sprintf("%s: %s %s", c.ID, c.State, c.Version)
+	case criticalMissing:
+		return fmt.Sprintf("%s: %s (expected %s)", c.ID, c.State, c.Expected)
+	case criticalMismatch:
+		return fmt.Sprintf("%s: %s %s (expected %s)", c.ID, c.State, c.Version, c.Expected)
+	default:
+		return fmt.Sprintf("%s: %s %s", c.ID, c.State, strings.Join(c.Files, ", "))
+	}
+}
+
+// ShippedMods reads the metadata of the critical mods in the pack's mod
+// folder installed on this platform. Jars are matched by file name first to
+// avoid opening every jar.
+func ShippedMods(archive *PackArchive, ids []string) (map[string]ModInfo, error) {
+	r, err := OpenPackArchive(archive.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	shipped := map[string]ModInfo{}
+	for _, id := range ids {
+		for _, f := range r.File {
+			name := strings.ToLower(filepath.Base(f.Name))
+			if f.FileInfo().IsDir() || !archive.IsModEntry(f.Name) || !strings.HasPrefix(name, strings.ToLower(id)) {
+				continue
+			}
+			info, err := readArchivedModInfo(f)
+			if err == nil && info.ID == id {
+				shipped[id] = *info
+				break
+			}
+		}
+	}
+	return shipped, nil
+}
+
+// CheckCriticalMods verifies exactly one jar of each critical mod is in
+// modPath, in the version the pack shipped.
+func CheckCriticalMods(modPath string, ids []string, shipped map[string]ModInfo) ([]CriticalCheck, error) {
+	installed, err := ScanMods(modPath)
+	if err != nil {
+		return nil, err
+	}
+	checks := make([]CriticalCheck, 0, len(ids))
+	for _, id := range ids {
+		check := CriticalCheck{ID: id, Expected: shipped[id].Version}
+		jars := installed[id]
+		for _, jar := range jars {
+			check.Files = append(check.Files, jar.File)
+		}
+		sort.Strings(check.Files)
+		switch {
+		case len(jars) == 0:
+			check.State = criticalMissing
+		case len(jars) > 1:
+			check.State = criticalDuplicate
+		case check.Expected != "" && jars[0].Version != check.Expected:
+			check.State = criticalMismatch
+			check.Version = jars[0].Version
+		default:
+			check.State = criticalOK
+			check.Version = jars[0].Version
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// RemoveDuplicateMods keeps the jar of mod id that the pack shipped and moves
+// every other jar with the same id into backupDir, the pack's file too when
+// it holds another version. Protected jars stay. It reports whether the
+// pack's jar is still in modPath.
+func RemoveDuplicateMods(modPath string, id string, keep ModInfo, backupDir string, journal *Journal) (bool, error) {
+	installed, err := ScanMods(modPath)
+	if err != nil {
+		return false, err
+	}
+	protected, err := ScanProtected(modPath)
+	if err != nil {
+		return false, err
+	}
+	paths := protectedPaths(modPath, protected)
+	kept := false
+	for _, jar := range installed[id] {
+		p := filepath.Join(modPath, jar.File)
+		if jar.File == keep.File && (keep.Version == "" || jar.Version == keep.Version) || paths.Has(p) {
+			kept = kept || jar.File == keep.File
+			continue
+		}
+		sum, err := fileSHA256(p)
+		if err != nil {
+			return false, err
+		}
+		backup := filepath.Join(backupDir, "duplicates", jar.File)
+		if err := moveFile(p, backup); err != nil {
+			return false, err
+		}
+		Logf("removed duplicate %s jar %s %s", id, jar.File, jar.Version)
+		if err := journal.Record(JournalEntry{Action: journalDelete, Path: p, SHA256: sum, Backup: backup}); err != nil {
+			return false, err
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRemoveDuplicateMods(t *testing.T) {
+	keep := ModInfo{ID: "sodium", Version: "0.5.8", File: "sodium-0.5.8.jar"}
+	tests := []struct {
+		name      string
+		installed map[string]string
+		kept      bool
+		left      string
+		moved     string
+	}{
+		{
+			name:      "two versions",
+			installed: map[string]string{"sodium-0.5.8.jar": modJar(t, "sodium", "0.5.8"), "sodium-0.5.0.jar": modJar(t, "sodium", "0.5.0"), "iris.jar": modJar(t, "iris", "1.7.0")},
+			kept:      true,
+			left:      "iris.jar sodium-0.5.8.jar",
+			moved:     "sodium-0.5.0.jar",
+		},
+		{
+			name:      "the pack's version under another name",
+			installed: map[string]string{"sodium-0.5.8.jar": modJar(t, "sodium", "0.5.8"), "sodium-copy.jar": modJar(t, "sodium", "0.5.8")},
+			kept:      true,
+			left:      "sodium-0.5.8.jar",
+			moved:     "sodium-copy.jar",
+		},
+		{
+			name:      "the pack's file in another version",
+			installed: map[string]string{"sodium-0.5.8.jar": modJar(t, "sodium", "0.5.0")},
+			moved:     "sodium-0.5.8.jar",
+		},
+		{
+			name:      "only another version",
+			installed: map[string]string{"sodium-0.5.0.jar": modJar(t, "sodium", "0.5.0")},
+			moved:     "sodium-0.5.0.jar",
+		},
+	}
+	for _, test := range tests {
+		root := t.TempDir()
+		mods := filepath.Join(root, "mods")
+		writeFiles(t, mods, test.installed)
+		journal := &Journal{Path: filepath.Join(root, "journal.jsonl"), Run: "run"}
+		backup := filepath.Join(root, "backup")
+
+		kept, err := RemoveDuplicateMods(mods, "sodium", keep, backup, journal)
+		if err != nil || kept != test.kept {
+			t.Errorf("%s: kept %t, %v", test.name, kept, err)
+		}
+		if got := dirNames(t, mods); got != test.left {
+			t.Errorf("%s: left %s", test.name, got)
+		}
+		// the moved jar is in the backup as it was, journaled
+		moved := filepath.Join(backup, "duplicates", test.moved)
+		if got := readFile(t, moved); got != test.installed[test.moved] {
+			t.Errorf("%s: backed up %d bytes", test.name, len(got))
+		}
+		entries, err := ReadJournal(journal.Path)
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("%s: journaled %+v, %v", test.name, entries, err)
+		}
+		if entry := entries[0]; entry.Action != journalDelete || entry.Path != filepath.Join(mods, test.moved) || entry.Backup != moved || entry.SHA256 != sha256Hex([]byte(test.installed[test.moved])) {
+			t.Errorf("%s: journaled %+v", test.name, entry)
+		}
+	}
+
+	// a protected jar of the mod is the player's to keep
+	root := t.TempDir()
+	mods := filepath.Join(root, "mods")
+	writeFiles(t, mods, map[string]string{"sodium-0.5.8.jar": modJar(t, "sodium", "0.5.8"), "sodium-dev.jar": modJar(t, "sodium", "0.6.0-dev"), "sodium-dev.jar" + keepSuffix: ""})
+	kept, err := RemoveDuplicateMods(mods, "sodium", keep, filepath.Join(root, "backup"), &Journal{Path: filepath.Join(root, "journal.jsonl")})
+	if err != nil || !kept {
+		t.Errorf("protected: kept %t, %v", kept, err)
+	}
+	if got := dirNames(t, mods); got != "sodium-0.5.8.jar sodium-dev.jar sodium-dev.jar.keep" {
+		t.Errorf("protected: left %s", got)
+	}
+}
+
+// TestCheckCriticalModsRepairs leaves the pack's critical mod in the mods
+// directory in the ways an update can: each is repaired to the one jar of
+// the pack's version, the rest in the backup.
+func TestCheckCriticalModsRepairs(t *testing.T) {
+	shipped := modJar(t, "sodium", "0.5.8")
+	archivePath := packZip(t, map[string]string{
+		"pack.json":                    `{"versions": {"1.20.1": "mods-1.20.1"}, "criticalMods": ["sodium"]}`,
+		"mods-1.20.1/sodium-0.5.8.jar": shipped,
+		"mods-1.20.1/lithium-0.11.jar": modJar(t, "lithium", "0.11.2"),
+	})
+	archive, err := ValidateArchive(archivePath, "1.20.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		installed map[string]string
+		state     string
+		moved     string
+	}{
+		{name: "in place", installed: map[string]string{"sodium-0.5.8.jar": shipped}},
+		{name: "missing", installed: map[string]string{}, state: criticalMissing},
+		{name: "two versions", installed: map[string]string{"sodium-0.5.8.jar": shipped, "sodium-0.5.0.jar": modJar(t, "sodium", "0.5.0")}, state: criticalDuplicate, moved: "sodium-0.5.0.jar"},
+		{name: "only another version", installed: map[string]string{"sodium-0.5.0.jar": modJar(t, "sodium", "0.5.0")}, state: criticalMismatch, moved: "sodium-0.5.0.jar"},
+		{name: "the pack's file in another version", installed: map[string]string{"sodium-0.5.8.jar": modJar(t, "sodium", "0.5.0")}, state: criticalMismatch, moved: "sodium-0.5.8.jar"},
+	}
+	for _, test := range tests {
+		root := t.TempDir()
+		mods := filepath.Join(root, "mods")
+		writeFiles(t, mods, test.installed)
+		if err := os.MkdirAll(mods, 0755); err != nil {
+			t.Fatal(err)
+		}
+		plan := UpdatePlan{
+			Archive:   archive,
+			MCVersion: "1.20.1",
+			ModPath:   mods,
+			Loader:    fabricLoader{},
+			Journal:   &Journal{Path: filepath.Join(root, "journal.jsonl"), Run: "run"},
+			BackupDir: filepath.Join(root, "backup"),
+		}
+		log := captureRunLog(t)
+		checks, err := plan.checkCriticalMods()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		want := []CriticalCheck{{ID: "sodium", State: criticalOK, Expected: "0.5.8", Version: "0.5.8", Files: []string{"sodium-0.5.8.jar"}}}
+		if !reflect.DeepEqual(checks, want) {
+			t.Errorf("%s: checked %+v", test.name, checks)
+		}
+		if got := readFile(t, filepath.Join(mods, "sodium-0.5.8.jar")); got != shipped || dirNames(t, mods) != "sodium-0.5.8.jar" {
+			t.Errorf("%s: installed %s", test.name, dirNames(t, mods))
+		}
+		if test.moved != "" && readFile(t, filepath.Join(plan.BackupDir, "duplicates", test.moved)) != test.installed[test.moved] {
+			t.Errorf("%s: %s isn't in the backup", test.name, test.moved)
+		}
+		// the pack's jar is extracted again unless it was kept
+		extracted := strings.Contains(log.String(), "critical mod sodium: extracting sodium-0.5.8.jar again")
+		if extracted != (test.state != "" && test.state != criticalDuplicate) {
+			t.Errorf("%s: log:\n%s", test.name, log)
+		}
+	}
+}
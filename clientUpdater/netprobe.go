@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Bounds of the network probe: it never takes longer than probeWait and
+// never reads more than probeBytes, whatever the connection is like.
+const (
+	probeWait  = 8 * time.Second
+	probeBytes = 256 << 10
+)
+
+// Classes of connections the probe tells apart, the names of their
+// "probe.<class>" messages.
+const (
+	connectionFine     = "fine"
+	connectionSlow     = "slow"
+	connectionVerySlow = "very-slow"
+	connectionBroken   = "broken"
+)
+
+// Limits of the classes: below slowThroughput or above slowLatency a
+// connection is slow, below verySlowThroughput or above verySlowLatency
+// very slow. Latency is the time until the first byte of the answer.
+const (
+	slowThroughput     = 1 << 20
+	verySlowThroughput = 128 << 10
+	slowLatency        = 1500 * time.Millisecond
+	verySlowLatency    = 4 * time.Second
+)
+
+// NetworkProbe is what probing a download source found.
+type NetworkProbe struct {
+	URL string
+	// DNS is the time resolving the host took, Connect the TCP handshake
+	// and TLS the TLS handshake, zero when there was none. FirstByte is
+	// the time from connecting until the first byte of the answer.
+	DNS       time.Duration
+	Connect   time.Duration
+	TLS       time.Duration
+	FirstByte time.Duration
+	// Bytes were read, all but the first one in Transfer.
+	Bytes    int64
+	Transfer time.Duration
+	Class    string
+	Err      error
+	// stalled is set when the server was reached but the time ran out
+	// before it answered.
+	stalled bool
+}
+
+// Throughput is the speed of the transfer in bytes per second, 0 when too
+// little was read to tell.
+func (p *NetworkProbe) Throughput() float64 {
+	if p.Bytes == 0 || p.Transfer <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / p.Transfer.Seconds()
+}
+
+func (p *NetworkProbe) String() string {
+	if p.Err != nil {
+		return fmt.Sprintf("%s: %s (%s)", p.URL, p.Class, p.Err)
+	}
+	return fmt.Sprintf("%s: %s, dns %s, connect %s, tls %s, first byte %s, %d bytes in %s (%.0f KB/s)",
+		p.URL, p.Class, p.DNS.Round(time.Millisecond), p.Connect.Round(time.Millisecond), p.TLS.Round(time.Millisecond),
+		p.FirstByte.Round(time.Millisecond), p.Bytes, p.Transfer.Round(time.Millisecond), p.Throughput()/1024)
+}
+
+// classify sorts the probe into a class of connections. A transfer cut
+// short by the time limit still counts with what it read.
+func (p *NetworkProbe) classify() {
+	throughput := p.Throughput()
+	switch {
+	case p.Err != nil && p.Bytes == 0 && !p.stalled:
+		p.Class = connectionBroken
+	case p.stalled || p.FirstByte > verySlowLatency || throughput > 0 && throughput < verySlowThroughput:
+		p.Class = connectionVerySlow
+	case p.FirstByte > slowLatency || throughput > 0 && throughput < slowThroughput:
+		p.Class = connectionSlow
+	default:
+		p.Class = connectionFine
+	}
+}
+
+// ProbeNetwork measures the connection to source: how long resolving its
+// host and the handshakes take and how fast the first probeBytes of it
+// download, asked for as a range so servers supporting it send no more.
+// The probe connects on a transport of its own, a connection kept from an
+// earlier request would hide the handshakes, but through the same dialer
+// as every download.
+func ProbeNetwork(ctx context.Context, source string) *NetworkProbe {
+	probe := &NetworkProbe{URL: source}
+	defer probe.classify()
+	ctx, cancel := context.WithTimeout(ctx, probeWait)
+	defer cancel()
+	u, err := url.Parse(source)
+	if err != nil {
+		probe.Err = err
+		return probe
+	}
+
+	start := clock.Now()
+	if net.ParseIP(u.Hostname()) == nil {
+		if _, err := dialer.resolver.LookupIPAddr(ctx, u.Hostname()); err != nil {
+			probe.Err = err
+			return probe
+		}
+		probe.DNS = clock.Now().Sub(start)
+	}
+
+	var connectStart, tlsStart time.Time
+	// connected is set once the server was reached, a handshake quicker
+	// than the clock's resolution takes no time
+	var connected bool
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(string, string) { connectStart = clock.Now() },
+		ConnectDone: func(_ string, _ string, err error) {
+			if !connectStart.IsZero() && !connected && err == nil {
+				probe.Connect = clock.Now().Sub(connectStart)
+				connected = true
+			}
+		},
+		TLSHandshakeStart: func() { tlsStart = clock.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			if !tlsStart.IsZero() {
+				probe.TLS = clock.Now().Sub(tlsStart)
+			}
+		},
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), "GET", source, nil)
+	if err != nil {
+		probe.Err = err
+		return probe
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", probeBytes-1))
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		ForceAttemptHTTP2:   true,
+		TLSHandshakeTimeout: probeWait,
+	}
+	defer transport.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		probe.Err = err
+		probe.stalled = ctx.Err() != nil && connected
+		return probe
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4<<10))
+		probe.Err = &statusError{status: resp.StatusCode}
+		return probe
+	}
+
+	// the first byte may take a while, the clock of the transfer starts
+	// once it arrived
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(resp.Body, first); err != nil {
+		probe.Err = err
+		probe.stalled = ctx.Err() != nil
+		return probe
+	}
+	probe.FirstByte = clock.Now().Sub(start) - probe.DNS
+	transferStart := clock.Now()
+	n, err := io.Copy(ioutil.Discard, io.LimitReader(resp.Body, probeBytes-1))
+	probe.Bytes = n + 1
+	probe.Transfer = clock.Now().Sub(transferStart)
+	if err != nil && ctx.Err() == nil {
+		probe.Err = err
+	}
+	return probe
+}
+
+// DescribeProbes lists the probes for the diagnostic bundle.
+func DescribeProbes(probes []*NetworkProbe) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "probe (at most %s and %d bytes per source):\n", probeWait, probeBytes)
+	for _, probe := range probes {
+		fmt.Fprintf(&b, "  %s\n", probe)
+	}
+	return b.String()
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassifyProbe(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		probe NetworkProbe
+		class string
+	}{
+		{"fast", NetworkProbe{FirstByte: 100 * time.Millisecond, Bytes: probeBytes, Transfer: 100 * time.Millisecond}, connectionFine},
+		{"too quick to tell", NetworkProbe{FirstByte: 100 * time.Millisecond, Bytes: probeBytes}, connectionFine},
+		{"late first byte", NetworkProbe{FirstByte: 2 * time.Second, Bytes: probeBytes, Transfer: 100 * time.Millisecond}, connectionSlow},
+		{"slow transfer", NetworkProbe{Bytes: probeBytes, Transfer: time.Second}, connectionSlow},
+		{"very late first byte", NetworkProbe{FirstByte: 5 * time.Second, Bytes: probeBytes, Transfer: 100 * time.Millisecond}, connectionVerySlow},
+		{"crawling transfer", NetworkProbe{Bytes: probeBytes, Transfer: 4 * time.Second}, connectionVerySlow},
+		{"cut short", NetworkProbe{Bytes: 10 << 10, Transfer: 7 * time.Second, Err: context.DeadlineExceeded}, connectionVerySlow},
+		{"no answer", NetworkProbe{Connect: time.Millisecond, Err: context.DeadlineExceeded, stalled: true}, connectionVerySlow},
+		{"refused", NetworkProbe{Err: &net.OpError{Op: "dial"}}, connectionBroken},
+		{"error status", NetworkProbe{FirstByte: time.Millisecond, Err: &statusError{status: http.StatusNotFound}}, connectionBroken},
+	} {
+		probe := test.probe
+		probe.classify()
+		if probe.Class != test.class {
+			t.Errorf("%s: %s, want %s", test.name, probe.Class, test.class)
+		}
+	}
+}
+
+// probeServer serves a source of size bytes for a probe, its clock moved
+// on by latency before answering and by transfer while sending all but
+// the first 64 KB.
+func probeServer(t *testing.T, c *fakeClock, size int, latency time.Duration, transfer time.Duration, ranges *[]string) string {
+	t.Helper()
+	content := bytes.Repeat([]byte("x"), size)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ranges != nil {
+			*ranges = append(*ranges, r.Header.Get("Range"))
+		}
+		c.Advance(latency)
+		if transfer == 0 {
+			http.ServeContent(w, r, "pack.zip", time.Time{}, bytes.NewReader(content))
+			return
+		}
+		// ignores the range, sending everything
+		w.Write(content[:64<<10])
+		w.(http.Flusher).Flush()
+		// the probe reads the first byte before the transfer goes on
+		time.Sleep(100 * time.Millisecond)
+		c.Advance(transfer)
+		w.Write(content[64<<10:])
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/pack.zip"
+}
+
+// TestProbeNetwork probes fast, high-latency, throttled, hanging and
+// failing servers, on a clock the servers move on.
+func TestProbeNetwork(t *testing.T) {
+	c := useFakeClock(t)
+	var ranges []string
+	fast := ProbeNetwork(context.Background(), probeServer(t, c, 1<<20, 0, 0, &ranges))
+	if fast.Err != nil || fast.Class != connectionFine || fast.Bytes != probeBytes {
+		t.Errorf("fast: %s", fast)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=0-262143" {
+		t.Errorf("asked for ranges %q", ranges)
+	}
+	if fast.DNS != 0 {
+		t.Errorf("resolved an address in %s", fast.DNS)
+	}
+
+	for _, test := range []struct {
+		name     string
+		latency  time.Duration
+		transfer time.Duration
+		class    string
+	}{
+		{"latency", 2 * time.Second, 0, connectionSlow},
+		{"high latency", 5 * time.Second, 0, connectionVerySlow},
+		{"throttled", 0, time.Second, connectionSlow},
+		{"crawling", 0, 4 * time.Second, connectionVerySlow},
+	} {
+		probe := ProbeNetwork(context.Background(), probeServer(t, c, 1<<20, test.latency, test.transfer, nil))
+		if probe.Class != test.class || probe.Bytes != probeBytes {
+			t.Errorf("%s: %s", test.name, probe)
+		}
+		if probe.FirstByte != test.latency {
+			t.Errorf("%s: first byte after %s", test.name, probe.FirstByte)
+		}
+	}
+
+	// a server that never answers is given up on
+	hang := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-hang }))
+	defer hanging.Close()
+	defer close(hang)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	stalled := ProbeNetwork(ctx, hanging.URL)
+	if stalled.Class != connectionVerySlow || stalled.Err == nil {
+		t.Errorf("hanging: %s", stalled)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("a hanging server was probed for %s", took)
+	}
+
+	failing := httptest.NewServer(http.NotFoundHandler())
+	defer failing.Close()
+	if probe := ProbeNetwork(context.Background(), failing.URL); probe.Class != connectionBroken || !strings.Contains(probe.String(), "404") {
+		t.Errorf("failing: %s", probe)
+	}
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	if probe := ProbeNetwork(context.Background(), closed.URL); probe.Class != connectionBroken {
+		t.Errorf("closed: %s", probe)
+	}
+	if probe := ProbeNetwork(context.Background(), "http://bad host/"); probe.Class != connectionBroken {
+		t.Errorf("invalid URL: %s", probe)
+	}
+
+	useFakeNetwork(t, networkAuto, fakeResolver{}, &fakeNetwork{})
+	if probe := ProbeNetwork(context.Background(), "https://pack.example/pack.zip"); probe.Class != connectionBroken || !strings.Contains(probe.String(), "no such host") {
+		t.Errorf("unknown host: %s", probe)
+	}
+}
+
+func TestDescribeProbes(t *testing.T) {
+	probes := []*NetworkProbe{
+		{URL: "https://github.com/pack.zip", DNS: 20 * time.Millisecond, Connect: 30 * time.Millisecond, TLS: 40 * time.Millisecond, FirstByte: 150 * time.Millisecond, Bytes: 256 << 10, Transfer: 500 * time.Millisecond, Class: connectionSlow},
+		{URL: "https://mirror.example/pack.zip", Class: connectionBroken, Err: &statusError{status: http.StatusBadGateway}},
+	}
+	want := "probe (at most 8s and 262144 bytes per source):\n" +
+		"  https://github.com/pack.zip: slow, dns 20ms, connect 30ms, tls 40ms, first byte 150ms, 262144 bytes in 500ms (512 KB/s)\n" +
+		"  https://mirror.example/pack.zip: broken (" + (&statusError{status: http.StatusBadGateway}).Error() + ")\n"
+	if got := DescribeProbes(probes); got != want {
+		t.Errorf("described\n%s\nwant\n%s", got, want)
+	}
+}
+
+// TestUpdateVerboseProbes probes the pack before a --verbose update, not
+// without --verbose or with --no-probe. The pack's host doesn't resolve
+// for the probe, the download goes to the test server all the same.
+func TestUpdateVerboseProbes(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 1"})
+	useFakeNetwork(t, networkAuto, fakeResolver{}, &fakeNetwork{})
+	logPath := filepath.Join(u.state, "clientUpdate.log")
+
+	output := readFile(t, u.run(t, "--verbose"))
+	if !strings.Contains(output, T("download.probe", T("probe.broken"), time.Duration(0), 0.0)) {
+		t.Errorf("output:\n%s", output)
+	}
+	if log := readFile(t, logPath); !strings.Contains(log, "probe: https://github.com/rx13/rxmc-Mods/archive/master.zip: broken") {
+		t.Errorf("log:\n%s", log)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 1" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+
+	for _, args := range [][]string{nil, {"--verbose", "--no-probe"}} {
+		u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 2"})
+		before := strings.Count(readFile(t, logPath), "probe: ")
+		u.run(t, args...)
+		if after := strings.Count(readFile(t, logPath), "probe: "); after != before {
+			t.Errorf("%q probed the pack", args)
+		}
+	}
+}
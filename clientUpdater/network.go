@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Network preferences of the config: both address families, the one
+// answering first winning, or only one of them, e.g. to find out whether
+// a router's IPv6 is broken.
+const (
+	networkAuto = ""
+	networkIPv4 = "ipv4"
+	networkIPv6 = "ipv6"
+)
+
+// Timeouts of connecting. The other family is tried fallbackDelay after
+// the first one didn't connect, long before a hanging connection attempt
+// times out, which on some systems only happens after minutes.
+const (
+	dialTimeout    = 30 * time.Second
+	attemptTimeout = 10 * time.Second
+	fallbackDelay  = 300 * time.Millisecond
+)
+
+// resolver looks up the addresses of a host, replaced by tests.
+type resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	// LookupIP looks up the addresses of one family, network being "ip4"
+	// or "ip6".
+	LookupIP(ctx context.Context, network string, host string) ([]net.IP, error)
+}
+
+// familyDialer connects to the addresses of a host over the families its
+// preference allows, racing them like happy eyeballs (RFC 8305) instead of
+// leaving that to the system, which some Windows stacks get wrong. When
+// every attempt fails it says which addresses were tried.
+type familyDialer struct {
+	preference string
+	resolver   resolver
+	// dial connects to a single address, replaced by tests.
+	dial func(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+// dialer is what httpClient connects with.
+var dialer = &familyDialer{
+	resolver: net.DefaultResolver,
+	dial:     (&net.Dialer{Timeout: attemptTimeout, KeepAlive: 30 * time.Second}).DialContext,
+}
+
+// SetNetworkPreference restricts connections to the address family the
+// config names. An empty name uses both.
+func SetNetworkPreference(name string) error {
+	name = strings.ToLower(name)
+	switch name {
+	case networkAuto, networkIPv4, networkIPv6:
+		dialer.preference = name
+		return nil
+	}
+	return fmt.Errorf("unsupported network %q (supported: ipv4, ipv6)", name)
+}
+
+// dialAttempt is a connection attempt to one address.
+type dialAttempt struct {
+	addr net.IP
+	err  error
+}
+
+// dialError is a host none of whose addresses could be connected to.
+type dialError struct {
+	host     string
+	attempts []dialAttempt
+	// excluded are the addresses the preference ruled out.
+	excluded []net.IP
+}
+
+func (e *dialError) Error() string {
+	var parts []string
+	for _, attempt := range e.attempts {
+		parts = append(parts, fmt.Sprintf("%s (%s): %s", attempt.addr, ipFamily(attempt.addr), attempt.err))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "no address of an allowed family")
+	}
+	s := fmt.Sprintf("couldn't connect to %s: %s", e.host, strings.Join(parts, ", "))
+	if len(e.excluded) > 0 {
+		var excluded []string
+		for _, ip := range e.excluded {
+			excluded = append(excluded, ip.String())
+		}
+		s += fmt.Sprintf(" (not tried, ruled out by the network setting: %s)", strings.Join(excluded, ", "))
+	}
+	return s
+}
+
+func (e *dialError) Category() string { return categoryNetwork }
+
+// Timeout reports whether every attempt timed out.
+func (e *dialError) Timeout() bool {
+	for _, attempt := range e.attempts {
+		if netErr, ok := attempt.err.(net.Error); !ok || !netErr.Timeout() {
+			return false
+		}
+	}
+	return len(e.attempts) > 0
+}
+
+func (e *dialError) Temporary() bool { return false }
+
+// ipFamily names the address family of ip.
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+// DialContext connects to address, a host and port.
+func (d *familyDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+
+	var addrs []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		addrs = []net.IP{ip}
+	} else {
+		resolved, err := d.resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range resolved {
+			addrs = append(addrs, addr.IP)
+		}
+	}
+	primaries, fallbacks, excluded := d.partition(addrs)
+	if len(primaries) == 0 {
+		return nil, &dialError{host: host, excluded: excluded}
+	}
+
+	type result struct {
+		conn     net.Conn
+		attempts []dialAttempt
+	}
+	results := make(chan result, 2)
+	primaryFailed := make(chan struct{})
+	racing, stop := context.WithCancel(ctx)
+	defer stop()
+	serial := func(ips []net.IP, failed chan struct{}) {
+		var attempts []dialAttempt
+		for _, ip := range ips {
+			conn, err := d.dial(racing, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				results <- result{conn: conn, attempts: attempts}
+				return
+			}
+			attempts = append(attempts, dialAttempt{addr: ip, err: err})
+			if racing.Err() != nil {
+				break
+			}
+		}
+		// sent first, so the attempts are reported in the order made
+		results <- result{attempts: attempts}
+		if failed != nil {
+			close(failed)
+		}
+	}
+	go serial(primaries, primaryFailed)
+	pending := 1
+	if len(fallbacks) > 0 {
+		pending++
+		go func() {
+			select {
+			case <-clock.After(fallbackDelay):
+			case <-primaryFailed:
+			case <-racing.Done():
+				results <- result{}
+				return
+			}
+			serial(fallbacks, nil)
+		}()
+	}
+
+	var attempts []dialAttempt
+	var winner net.Conn
+	for ; pending > 0; pending-- {
+		r := <-results
+		attempts = append(attempts, r.attempts...)
+		switch {
+		case r.conn != nil && winner == nil:
+			winner = r.conn
+			stop()
+		case r.conn != nil:
+			r.conn.Close()
+		}
+	}
+	if winner != nil {
+		return winner, nil
+	}
+	if err := ctx.Err(); err != nil && len(attempts) == 0 {
+		return nil, err
+	}
+	err = &dialError{host: host, attempts: attempts, excluded: excluded}
+	Logf("network: %s", err)
+	return nil, err
+}
+
+// partition splits addrs into the family tried first, the family of the
+// first address, and the other one, leaving out the family the preference
+// doesn't allow.
+func (d *familyDialer) partition(addrs []net.IP) (primaries []net.IP, fallbacks []net.IP, excluded []net.IP) {
+	var v4, v6 []net.IP
+	for _, ip := range addrs {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	switch d.preference {
+	case networkIPv4:
+		return v4, nil, v6
+	case networkIPv6:
+		return v6, nil, v4
+	}
+	if len(addrs) > 0 && addrs[0].To4() != nil {
+		return v4, v6, nil
+	}
+	return v6, v4, nil
+}
+
+// HostCheck is what CheckHost found out about reaching a host over one
+// address family.
+type HostCheck struct {
+	Family string
+	// Addrs are the addresses the host resolved to, Err why it couldn't
+	// be resolved.
+	Addrs []net.IP
+	Err   error
+	// Connected is the address a connection could be made to, Attempts
+	// why the others couldn't.
+	Connected net.IP
+	Attempts  []dialAttempt
+}
+
+// CheckHost resolves host over each address family and connects to port
+// on its addresses, ignoring the network preference, to tell whether one
+// family is broken.
+func CheckHost(ctx context.Context, host string, port string) []HostCheck {
+	checks := []HostCheck{{Family: "IPv4"}, {Family: "IPv6"}}
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(check *HostCheck) {
+			defer wg.Done()
+			network := "ip4"
+			if check.Family == "IPv6" {
+				network = "ip6"
+			}
+			check.Addrs, check.Err = dialer.resolver.LookupIP(ctx, network, host)
+			for _, ip := range check.Addrs {
+				conn, err := dialer.dial(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+				if err == nil {
+					conn.Close()
+					check.Connected = ip
+					return
+				}
+				check.Attempts = append(check.Attempts, dialAttempt{addr: ip, err: err})
+			}
+		}(&checks[i])
+	}
+	wg.Wait()
+	return checks
+}
+
+// String describes the check for people.
+func (c HostCheck) String() string {
+	switch {
+	case c.Err != nil:
+		return fmt.Sprintf("%s: not resolved (%s)", c.Family, c.Err)
+	case c.Connected != nil:
+		return fmt.Sprintf("%s: %d addresses, connected to %s", c.Family, len(c.Addrs), c.Connected)
+	}
+	var failures []string
+	for _, attempt := range c.Attempts {
+		failures = append(failures, fmt.Sprintf("%s: %s", attempt.addr, attempt.err))
+	}
+	return fmt.Sprintf("%s: %d addresses, none connected (%s)", c.Family, len(c.Addrs), strings.Join(failures, ", "))
+}
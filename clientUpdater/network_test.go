@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResolver resolves the hosts it knows to their addresses, in order.
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	var resolved []net.IPAddr
+	for _, addr := range addrs {
+		resolved = append(resolved, net.IPAddr{IP: net.ParseIP(addr)})
+	}
+	return resolved, nil
+}
+
+func (r fakeResolver) LookupIP(ctx context.Context, network string, host string) ([]net.IP, error) {
+	resolved, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, addr := range resolved {
+		if (addr.IP.To4() != nil) == (network == "ip4") {
+			ips = append(ips, addr.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+// What an address of a fakeNetwork does when dialed: connect, refuse the
+// connection, or hang until the attempt is given up.
+const (
+	addrConnects = "connects"
+	addrRefuses  = "refuses"
+	addrHangs    = "hangs"
+)
+
+// fakeNetwork dials addresses as its map says, recording the attempts.
+type fakeNetwork struct {
+	addrs    map[string]string
+	mu       sync.Mutex
+	attempts []string
+}
+
+func (n *fakeNetwork) dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(address)
+	n.mu.Lock()
+	n.attempts = append(n.attempts, host)
+	n.mu.Unlock()
+	switch n.addrs[host] {
+	case addrConnects:
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	case addrHangs:
+		<-ctx.Done()
+		return nil, &net.OpError{Op: "dial", Net: network, Err: ctx.Err()}
+	}
+	return nil, &net.OpError{Op: "dial", Net: network, Err: errors.New("connection refused")}
+}
+
+func (n *fakeNetwork) Attempts() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return strings.Join(n.attempts, " ")
+}
+
+// useFakeNetwork has the dialer resolve with r and connect over n,
+// preferring the family named.
+func useFakeNetwork(t *testing.T, preference string, r fakeResolver, n *fakeNetwork) {
+	t.Helper()
+	saved := *dialer
+	t.Cleanup(func() { *dialer = saved })
+	dialer.resolver = r
+	dialer.dial = n.dial
+	if err := SetNetworkPreference(preference); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDialFallsBackToIPv4(t *testing.T) {
+	fake := useFakeClock(t)
+	n := &fakeNetwork{addrs: map[string]string{"2001:db8::1": addrHangs, "192.0.2.1": addrConnects}}
+	useFakeNetwork(t, networkAuto, fakeResolver{"github.com": {"2001:db8::1", "192.0.2.1"}}, n)
+
+	dialed := make(chan error, 1)
+	go func() {
+		conn, err := dialer.DialContext(context.Background(), "tcp", "github.com:443")
+		if err == nil {
+			conn.Close()
+		}
+		dialed <- err
+	}()
+	// IPv4 is only tried once the hanging IPv6 had fallbackDelay
+	fake.WaitForTimers(t, 1)
+	for n.Attempts() == "" {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-dialed:
+		t.Fatalf("dialed before the fallback delay: %v", err)
+	default:
+	}
+	fake.Advance(fallbackDelay)
+	select {
+	case err := <-dialed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the IPv4 fallback never connected")
+	}
+	if got := n.Attempts(); got != "2001:db8::1 192.0.2.1" {
+		t.Errorf("attempts %s", got)
+	}
+}
+
+func TestDial(t *testing.T) {
+	hosts := fakeResolver{
+		"github.com":  {"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2"},
+		"v4.example":  {"192.0.2.1"},
+		"v6.example":  {"2001:db8::1"},
+		"v4first.com": {"192.0.2.1", "2001:db8::1"},
+	}
+	tests := []struct {
+		name       string
+		preference string
+		address    string
+		addrs      map[string]string
+		attempts   string
+		// err is what the error says, none when the dial connects.
+		err string
+	}{
+		{
+			name:     "IPv6 connects",
+			address:  "github.com:443",
+			addrs:    map[string]string{"2001:db8::1": addrConnects},
+			attempts: "2001:db8::1",
+		},
+		{
+			// refused IPv6 falls back at once, without the delay
+			name:     "IPv6 refused",
+			address:  "github.com:443",
+			addrs:    map[string]string{"2001:db8::1": addrRefuses, "192.0.2.1": addrConnects},
+			attempts: "2001:db8::1 2001:db8::2 192.0.2.1",
+		},
+		{
+			name:     "IPv4 first",
+			address:  "v4first.com:443",
+			addrs:    map[string]string{"192.0.2.1": addrConnects, "2001:db8::1": addrConnects},
+			attempts: "192.0.2.1",
+		},
+		{
+			name:     "all refused",
+			address:  "github.com:443",
+			attempts: "2001:db8::1 2001:db8::2 192.0.2.1 192.0.2.2",
+			err:      "couldn't connect to github.com: 2001:db8::1 (IPv6): dial tcp: connection refused, 2001:db8::2 (IPv6): dial tcp: connection refused, 192.0.2.1 (IPv4): dial tcp: connection refused, 192.0.2.2 (IPv4): dial tcp: connection refused",
+		},
+		{
+			name:       "IPv4 only",
+			preference: networkIPv4,
+			address:    "github.com:443",
+			addrs:      map[string]string{"2001:db8::1": addrConnects, "192.0.2.2": addrConnects},
+			attempts:   "192.0.2.1 192.0.2.2",
+		},
+		{
+			name:       "IPv6 only",
+			preference: "IPv6",
+			address:    "github.com:443",
+			attempts:   "2001:db8::1 2001:db8::2",
+			err:        "couldn't connect to github.com: 2001:db8::1 (IPv6): dial tcp: connection refused, 2001:db8::2 (IPv6): dial tcp: connection refused (not tried, ruled out by the network setting: 192.0.2.1, 192.0.2.2)",
+		},
+		{
+			name:       "no address of the family",
+			preference: networkIPv4,
+			address:    "v6.example:443",
+			err:        "couldn't connect to v6.example: no address of an allowed family (not tried, ruled out by the network setting: 2001:db8::1)",
+		},
+		{
+			name:     "address literal",
+			address:  "[2001:db8::9]:443",
+			addrs:    map[string]string{"2001:db8::9": addrConnects},
+			attempts: "2001:db8::9",
+		},
+		{
+			name:    "unknown host",
+			address: "nowhere.example:443",
+			err:     "lookup nowhere.example: no such host",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useFakeClock(t)
+			n := &fakeNetwork{addrs: test.addrs}
+			useFakeNetwork(t, test.preference, hosts, n)
+
+			conn, err := dialer.DialContext(context.Background(), "tcp", test.address)
+			if conn != nil {
+				conn.Close()
+			}
+			switch {
+			case test.err == "" && err != nil:
+				t.Fatal(err)
+			case test.err != "" && (err == nil || err.Error() != test.err):
+				t.Fatalf("error %v, want %s", err, test.err)
+			}
+			if got := n.Attempts(); got != test.attempts {
+				t.Errorf("attempts %q, want %q", got, test.attempts)
+			}
+		})
+	}
+}
+
+func TestDialErrorTimeout(t *testing.T) {
+	useFakeClock(t)
+	n := &fakeNetwork{addrs: map[string]string{"192.0.2.1": addrHangs}}
+	useFakeNetwork(t, networkAuto, fakeResolver{"v4.example": {"192.0.2.1"}}, n)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := dialer.DialContext(ctx, "tcp", "v4.example:443")
+	var dialErr *dialError
+	if !errors.As(err, &dialErr) || !dialErr.Timeout() || ErrorCategory(err) != categoryNetwork {
+		t.Errorf("hanging: %v", err)
+	}
+	if refused := (&dialError{attempts: []dialAttempt{{addr: net.ParseIP("192.0.2.1"), err: errors.New("refused")}}}); refused.Timeout() {
+		t.Error("refused connections time out")
+	}
+}
+
+func TestSetNetworkPreference(t *testing.T) {
+	saved := *dialer
+	defer func() { *dialer = saved }()
+	for _, name := range []string{"", "ipv4", "IPv6"} {
+		if err := SetNetworkPreference(name); err != nil || dialer.preference != strings.ToLower(name) {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+	if err := SetNetworkPreference("ipv5"); err == nil {
+		t.Error("ipv5 accepted")
+	}
+}
+
+// TestTransportDialsWithFamilyDialer fetches a page from a host whose
+// IPv6 address refuses connections through the updater's transport.
+func TestTransportDialsWithFamilyDialer(t *testing.T) {
+	useFakeClock(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "reached "+r.Host)
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	n := &fakeNetwork{}
+	useFakeNetwork(t, networkAuto, fakeResolver{"pack.example": {"2001:db8::1", "127.0.0.1"}}, n)
+	dialer.dial = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		if host, _, _ := net.SplitHostPort(address); host == "127.0.0.1" {
+			n.dial(ctx, network, address)
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		}
+		return n.dial(ctx, network, address)
+	}
+
+	client := &http.Client{Transport: newTransport()}
+	resp, err := client.Get("http://pack.example:" + port + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "reached pack.example:"+port || n.Attempts() != "2001:db8::1 127.0.0.1" {
+		t.Errorf("got %q, attempts %s", body, n.Attempts())
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	n := &fakeNetwork{addrs: map[string]string{"192.0.2.2": addrConnects}}
+	useFakeNetwork(t, networkIPv6, fakeResolver{"github.com": {"192.0.2.1", "192.0.2.2"}}, n)
+	// the preference is ignored, both families are checked
+	checks := CheckHost(context.Background(), "github.com", "443")
+	if len(checks) != 2 {
+		t.Fatalf("checks %v", checks)
+	}
+	want := []string{
+		"IPv4: 2 addresses, connected to 192.0.2.2",
+		"IPv6: not resolved (lookup github.com: no such host)",
+	}
+	for i, check := range checks {
+		if check.String() != want[i] {
+			t.Errorf("check %d is %q, want %q", i, check, want[i])
+		}
+	}
+
+	failed := HostCheck{Family: "IPv4", Addrs: []net.IP{net.ParseIP("192.0.2.1")}, Attempts: []dialAttempt{{addr: net.ParseIP("192.0.2.1"), err: errors.New("refused")}}}
+	if got := failed.String(); got != "IPv4: 1 addresses, none connected (192.0.2.1: refused)" {
+		t.Errorf("failed check %q", got)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// notifyTimeout bounds how long a notification may take to show, a hung
+// notifier must never hold up the update.
+const notifyTimeout = 5 * time.Second
+
+// notifyTitle is the title of every notification.
+const notifyTitle = "rxmc Updater"
+
+// Notifier shows desktop notifications.
+type Notifier interface {
+	Notify(message string)
+}
+
+// noNotifier is used while notifications are off.
+type noNotifier struct{}
+
+func (noNotifier) Notify(string) {}
+
+// commandNotifier shows notifications with the platform's own tooling, see
+// notifyCommand. It does nothing when the tooling isn't installed.
+type commandNotifier struct{}
+
+func (commandNotifier) Notify(message string) {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	cmd := notifyCommand(ctx, notifyTitle, message)
+	if cmd == nil {
+		return
+	}
+	if _, err := exec.LookPath(cmd.Path); err != nil {
+		return
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		Logf("notification: %s %s", err, output)
+	}
+}
+
+// desktopNotifier shows the notifications once they are switched on,
+// replaced when the updater is driven by a test.
+var desktopNotifier Notifier = commandNotifier{}
+
+// notifier is the Notifier in use.
+var notifier Notifier = noNotifier{}
+
+// SetupNotifications switches notifications on when the config asks for
+// them, or when it doesn't say and there is no terminal to print to, e.g.
+// when run from a scheduled task or a launcher hook.
+func SetupNotifications(enabled *bool) {
+	if (enabled == nil && !hasTerminal()) || (enabled != nil && *enabled) {
+		notifier = desktopNotifier
+		return
+	}
+	notifier = noNotifier{}
+}
+
+// Notify shows a notification if they are switched on.
+func Notify(message string) {
+	Logf("notification: %s", message)
+	notifier.Notify(message)
+}
+
+// NotifyAlways shows a notification even while they are switched off, for
+// what mustn't go unnoticed.
+func NotifyAlways(message string) {
+	Logf("notification: %s", message)
+	if _, off := notifier.(noNotifier); off {
+		desktopNotifier.Notify(message)
+		return
+	}
+	notifier.Notify(message)
+}
+
+// hasTerminal reports whether standard input is a terminal.
+func hasTerminal() bool {
+	return isTerminal(os.Stdin)
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
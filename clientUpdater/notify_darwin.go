@@ -0,0 +1,16 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+)
+
+// notifyCommand shows a notification with osascript. Title and message
+// are handed to the script as arguments, never as part of it.
+func notifyCommand(ctx context.Context, title string, message string) *exec.Cmd {
+	return exec.CommandContext(ctx, "osascript",
+		"-e", "on run argv",
+		"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+		"-e", "end run",
+		title, message)
+}
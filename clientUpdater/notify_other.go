@@ -0,0 +1,13 @@
+//go:build !windows && !darwin
+
+package main
+
+import (
+	"context"
+	"os/exec"
+)
+
+// notifyCommand shows a notification with notify-send.
+func notifyCommand(ctx context.Context, title string, message string) *exec.Cmd {
+	return exec.CommandContext(ctx, "notify-send", "--app-name="+title, title, message)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useDesktopNotifier records the notifications the test would have shown
+// once they are switched on.
+func useDesktopNotifier(t *testing.T) *recordingNotifier {
+	t.Helper()
+	recording := &recordingNotifier{}
+	savedDesktop, saved := desktopNotifier, notifier
+	desktopNotifier = recording
+	t.Cleanup(func() { desktopNotifier, notifier = savedDesktop, saved })
+	return recording
+}
+
+func TestSetupNotifications(t *testing.T) {
+	on, off := true, false
+	recording := useDesktopNotifier(t)
+	// standard input is a file, as when run from a scheduled task
+	useStdin(t, "")
+	tests := []struct {
+		name    string
+		enabled *bool
+		shown   bool
+	}{
+		{"switched on", &on, true},
+		{"switched off", &off, false},
+		{"without a terminal", nil, true},
+	}
+	for _, test := range tests {
+		SetupNotifications(test.enabled)
+		before := len(recording.Messages())
+		Notify(test.name)
+		if shown := len(recording.Messages()) > before; shown != test.shown {
+			t.Errorf("%s: shown %t", test.name, shown)
+		}
+	}
+
+	SetupNotifications(&off)
+	NotifyAlways("failing for days")
+	if got := recording.Messages(); got[len(got)-1] != "failing for days" {
+		t.Errorf("notified %q", got)
+	}
+}
+
+// TestCommandNotifierWithoutTooling checks a notification without the
+// platform's tooling installed is dropped.
+func TestCommandNotifierWithoutTooling(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	done := make(chan struct{})
+	go func() {
+		commandNotifier{}.Notify("nobody sees this")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(notifyTimeout):
+		t.Fatal("notifying without the tooling took too long")
+	}
+}
+
+// TestCommandNotifierHung has a notify-send that never returns: the
+// notification is given up after notifyTimeout.
+func TestCommandNotifierHung(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("notify-send is the notifier of other platforms")
+	}
+	bin := t.TempDir()
+	writeFiles(t, bin, map[string]string{"notify-send": "#!/bin/sh\nexec sleep 60\n"})
+	if err := os.Chmod(filepath.Join(bin, "notify-send"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	start := time.Now()
+	commandNotifier{}.Notify("hangs")
+	if took := time.Since(start); took < notifyTimeout || took > 2*notifyTimeout {
+		t.Errorf("gave up after %s", took)
+	}
+}
+
+// TestUpdateNotifications checks which notifications an update shows, and
+// that it shows none while they are switched off.
+func TestUpdateNotifications(t *testing.T) {
+	recording := useDesktopNotifier(t)
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium", "mods/iris.jar": "iris"})
+	writeFiles(t, u.mods, map[string]string{"old.jar": "old"})
+	if err := os.MkdirAll(u.state, 0755); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(u.state, "clientUpdate.json")
+	on, off := true, false
+	SaveConfig(ConfFile{MCDirectory: u.mods, Notifications: &on}, configPath)
+
+	u.run(t)
+	want := "Updating the mods in " + u.mods + "|Update complete: 3 mods changed"
+	if got := strings.Join(recording.Messages(), "|"); got != want {
+		t.Errorf("notified %q, want %q", got, want)
+	}
+
+	SaveConfig(ConfFile{MCDirectory: u.mods, Notifications: &off}, configPath)
+	u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 2"})
+	u.run(t)
+	if got := strings.Join(recording.Messages(), "|"); got != want {
+		t.Errorf("notified %q while switched off", got)
+	}
+}
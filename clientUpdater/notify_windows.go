@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// toastScript shows a toast through WinRT. Title and message are passed
+// in the environment, never as part of the script.
+const toastScript = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$toast = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $toast.GetElementsByTagName('text')
+$text.Item(0).AppendChild($toast.CreateTextNode($env:RXMC_NOTIFY_TITLE)) > $null
+$text.Item(1).AppendChild($toast.CreateTextNode($env:RXMC_NOTIFY_MESSAGE)) > $null
+$app = '{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe'
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($app).Show([Windows.UI.Notifications.ToastNotification]::new($toast))`
+
+// notifyCommand shows a toast with PowerShell.
+func notifyCommand(ctx context.Context, title string, message string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", toastScript)
+	cmd.Env = append(os.Environ(), "RXMC_NOTIFY_TITLE="+title, "RXMC_NOTIFY_MESSAGE="+message)
+	return cmd
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// optionsDefaultsTransform merges the settings of a pack's options.txt, a
+// few key:value lines, into the player's options.txt. Keys the player
+// already has a value for are left alone unless the player asked for the
+// pack's recommended settings; everything else in the file stays exactly as
+// it was. A player without options.txt gets the pack's file.
+func optionsDefaultsTransform(ctx *TransformContext, src io.Reader) (bool, error) {
+	content, err := ioutil.ReadAll(src)
+	if err != nil {
+		return false, err
+	}
+	current, err := ioutil.ReadFile(ctx.Dest)
+	if os.IsNotExist(err) {
+		return true, writeFile(ctx.Dest, content)
+	}
+	if err != nil {
+		return false, err
+	}
+	options := ParseOptions(current)
+	changed := options.Merge(ParseOptions(content), ctx.ApplyRecommended)
+	if len(changed) == 0 {
+		return false, nil
+	}
+	Logf("options: setting %s in %s", strings.Join(changed, ", "), ctx.Dest)
+	return true, writeFile(ctx.Dest, options.Bytes())
+}
+
+// Options is an options.txt as minecraft writes it: a setting per line,
+// its key and value split at the first colon. Values are kept as written,
+// quoted lists like resourcePacks:["vanilla","file/pack.zip"] included, and
+// lines that aren't settings are kept too, so writing the file back gives
+// exactly what was read.
+type Options struct {
+	lines []optionsLine
+	// newline ends the lines added to the file, the one its first line
+	// ends with.
+	newline string
+}
+
+// optionsLine is a line of options.txt, without its line ending.
+type optionsLine struct {
+	text   string
+	ending string
+	// key is empty for lines that aren't settings.
+	key string
+}
+
+// value returns what follows the key of the line.
+func (l optionsLine) value() string {
+	return l.text[len(l.key)+1:]
+}
+
+// ParseOptions reads the content of an options.txt.
+func ParseOptions(content []byte) *Options {
+	options := &Options{newline: "\n"}
+	rest := string(content)
+	for rest != "" {
+		line := rest
+		if i := strings.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		rest = rest[len(line):]
+		text := strings.TrimRight(line, "\r\n")
+		l := optionsLine{text: text, ending: line[len(text):]}
+		if i := strings.IndexByte(text, ':'); i > 0 {
+			l.key = text[:i]
+		}
+		options.lines = append(options.lines, l)
+	}
+	if len(options.lines) > 0 && options.lines[0].ending == "\r\n" {
+		options.newline = "\r\n"
+	}
+	return options
+}
+
+// Get returns the value of key, the last one if it is set more than once,
+// as minecraft reads it.
+func (o *Options) Get(key string) (string, bool) {
+	for i := len(o.lines) - 1; i >= 0; i-- {
+		if o.lines[i].key == key {
+			return o.lines[i].value(), true
+		}
+	}
+	return "", false
+}
+
+// Set sets key to value in every line setting it, or adds it at the end.
+func (o *Options) Set(key string, value string) {
+	found := false
+	for i, l := range o.lines {
+		if l.key == key {
+			o.lines[i].text = key + ":" + value
+			found = true
+		}
+	}
+	if found {
+		return
+	}
+	if n := len(o.lines); n > 0 && o.lines[n-1].ending == "" {
+		o.lines[n-1].ending = o.newline
+	}
+	o.lines = append(o.lines, optionsLine{text: key + ":" + value, ending: o.newline, key: key})
+}
+
+// Keys returns the keys set, in the order of the file.
+func (o *Options) Keys() []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, l := range o.lines {
+		if l.key != "" && !seen[l.key] {
+			seen[l.key] = true
+			keys = append(keys, l.key)
+		}
+	}
+	return keys
+}
+
+// Merge sets the keys of defaults missing from o, and with overwrite those
+// set to something else as well. It returns the keys it set.
+func (o *Options) Merge(defaults *Options, overwrite bool) []string {
+	var changed []string
+	for _, key := range defaults.Keys() {
+		value, _ := defaults.Get(key)
+		current, ok := o.Get(key)
+		if ok && (!overwrite || current == value) {
+			continue
+		}
+		o.Set(key, value)
+		changed = append(changed, key)
+	}
+	return changed
+}
+
+// Bytes returns the content of the options file.
+func (o *Options) Bytes() []byte {
+	var b bytes.Buffer
+	for _, l := range o.lines {
+		b.WriteString(l.text)
+		b.WriteString(l.ending)
+	}
+	return b.Bytes()
+}
+
+// OptionChange is a setting of the player the pack recommends another value
+// for.
+type OptionChange struct {
+	File        string
+	Key         string
+	Current     string
+	Recommended string
+}
+
+// RecommendedSettingsChanges lists the settings of the player that applying
+// the recommended settings of the pack, its options-defaults transforms,
+// would overwrite.
+func RecommendedSettingsChanges(archive *PackArchive, minecraftPath string) ([]OptionChange, error) {
+	if archive.Manifest == nil {
+		return nil, nil
+	}
+	var dests []string
+	for dest, transform := range archive.Manifest.Transforms {
+		if transform == "options-defaults" {
+			dests = append(dests, dest)
+		}
+	}
+	if len(dests) == 0 {
+		return nil, nil
+	}
+	sort.Strings(dests)
+	r, err := OpenPackArchive(archive.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	entries := map[string]*zip.File{}
+	for _, f := range r.File {
+		entries[PackPath(f.Name)] = f
+	}
+
+	var changes []OptionChange
+	for _, dest := range dests {
+		f, ok := entries[dest]
+		if !ok {
+			continue
+		}
+		target, err := transformDest(minecraftPath, dest)
+		if err != nil {
+			return nil, err
+		}
+		current, err := ioutil.ReadFile(target)
+		if err != nil {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		player, defaults := ParseOptions(current), ParseOptions(content)
+		for _, key := range defaults.Keys() {
+			value, _ := defaults.Get(key)
+			if now, ok := player.Get(key); ok && now != value {
+				changes = append(changes, OptionChange{File: dest, Key: key, Current: now, Recommended: value})
+			}
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readOptionsFixture returns the options.txt in testdata/options named name.
+func readOptionsFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("testdata", "options", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestParseOptions(t *testing.T) {
+	tests := map[string]map[string]string{
+		"vanilla-1.20.1.txt": {
+			"renderDistance":            "32",
+			"renderClouds":              `"true"`,
+			"resourcePacks":             `["vanilla","fabric","file/Fresh Animations v1.9.zip","file/Stay True.zip"]`,
+			"incompatibleResourcePacks": `["file/Old Pack.zip"]`,
+			"lastServer":                "play.example.com:25565",
+			"soundDevice":               `""`,
+			"key_key.attack":            "key.mouse.left",
+			"modelPart_left_sleeve":     "true",
+		},
+		"windows-crlf.txt": {
+			"renderDistance":       "12",
+			"resourcePacks":        `["vanilla","file/Faithful 32x - 1.21.zip"]`,
+			"lastServer":           "[2001:db8::1]:25565",
+			"soundCategory_master": "0.45",
+		},
+		"odd.txt": {
+			// the last of the repeated keys is the one minecraft reads
+			"renderDistance": "16",
+			"soundDevice":    "",
+			"resourcePacks":  "[]",
+			"maxFps":         " 260 ",
+		},
+	}
+	for name, values := range tests {
+		content := readOptionsFixture(t, name)
+		options := ParseOptions(content)
+		if got := options.Bytes(); string(got) != string(content) {
+			t.Errorf("%s doesn't round-trip:\n%q\nwas\n%q", name, got, content)
+		}
+		for key, want := range values {
+			if got, ok := options.Get(key); !ok || got != want {
+				t.Errorf("%s: %s is %q, %t, want %q", name, key, got, ok, want)
+			}
+		}
+	}
+	odd := ParseOptions(readOptionsFixture(t, "odd.txt"))
+	if got := strings.Join(odd.Keys(), " "); got != "\ufeffversion renderDistance soundDevice resourcePacks maxFps" {
+		t.Errorf("odd.txt keys %q", got)
+	}
+	for _, key := range []string{"fancyLeaves", "# written by a config tool", "  "} {
+		if _, ok := odd.Get(key); ok {
+			t.Errorf("odd.txt sets %q", key)
+		}
+	}
+}
+
+func TestOptionsMerge(t *testing.T) {
+	defaults := ParseOptions([]byte("renderDistance:12\ngraphicsMode:1\nresourcePacks:[\"vanilla\",\"file/Pack Defaults.zip\"]\n"))
+	tests := []struct {
+		name      string
+		fixture   string
+		overwrite bool
+		changed   string
+		// edit turns the fixture into what merging makes of it.
+		edit func(content string) string
+	}{
+		{
+			name:    "keeps the player's values",
+			fixture: "vanilla-1.20.1.txt",
+			edit:    func(content string) string { return content },
+		},
+		{
+			name:      "overwrites with the recommended values",
+			fixture:   "vanilla-1.20.1.txt",
+			overwrite: true,
+			changed:   "renderDistance resourcePacks",
+			edit: func(content string) string {
+				content = strings.Replace(content, "renderDistance:32\n", "renderDistance:12\n", 1)
+				return strings.Replace(content, `resourcePacks:["vanilla","fabric","file/Fresh Animations v1.9.zip","file/Stay True.zip"]`, `resourcePacks:["vanilla","file/Pack Defaults.zip"]`, 1)
+			},
+		},
+		{
+			name:    "keeps a CRLF file as it is",
+			fixture: "windows-crlf.txt",
+			edit:    func(content string) string { return content },
+		},
+		{
+			name:      "overwrites every line of a repeated key",
+			fixture:   "odd.txt",
+			overwrite: true,
+			changed:   "renderDistance graphicsMode resourcePacks",
+			edit: func(content string) string {
+				content = strings.Replace(content, "renderDistance:8\nrenderDistance:16\n", "renderDistance:12\nrenderDistance:12\n", 1)
+				content = strings.Replace(content, "resourcePacks:[]", `resourcePacks:["vanilla","file/Pack Defaults.zip"]`, 1)
+				return content + "graphicsMode:1\n"
+			},
+		},
+	}
+	for _, test := range tests {
+		content := readOptionsFixture(t, test.fixture)
+		options := ParseOptions(content)
+		changed := options.Merge(defaults, test.overwrite)
+		if got := strings.Join(changed, " "); got != test.changed {
+			t.Errorf("%s: changed %q, want %q", test.name, changed, test.changed)
+		}
+		if got, want := string(options.Bytes()), test.edit(string(content)); got != want {
+			t.Errorf("%s: merged into\n%q\nwant\n%q", test.name, got, want)
+		}
+	}
+
+	// keys missing are added with the file's line ending, after ending its
+	// last line
+	options := ParseOptions(readOptionsFixture(t, "windows-crlf.txt"))
+	options.Merge(ParseOptions([]byte("narrator:0\n")), false)
+	if got := string(options.Bytes()); !strings.HasSuffix(got, "soundCategory_master:0.45\r\nnarrator:0\r\n") {
+		t.Errorf("appended to windows-crlf.txt:\n%q", got)
+	}
+}
+
+func TestOptionsDefaultsTransform(t *testing.T) {
+	dir := t.TempDir()
+	defaults := "renderDistance:12\ngraphicsMode:1\n"
+	transform := func(name string, overwrite bool) (bool, string) {
+		t.Helper()
+		dest := filepath.Join(dir, name)
+		written, err := optionsDefaultsTransform(&TransformContext{Dest: dest, ApplyRecommended: overwrite}, strings.NewReader(defaults))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return written, readFile(t, dest)
+	}
+
+	// a new install gets the pack's file
+	if written, got := transform("new.txt", false); !written || got != defaults {
+		t.Errorf("new install: %t, %q", written, got)
+	}
+	writeFiles(t, dir, map[string]string{"player.txt": "version:3465\nrenderDistance:32\n"})
+	if written, got := transform("player.txt", false); !written || got != "version:3465\nrenderDistance:32\ngraphicsMode:1\n" {
+		t.Errorf("merged: %t, %q", written, got)
+	}
+	if written, _ := transform("player.txt", false); written {
+		t.Errorf("rewrote options with nothing missing")
+	}
+	if written, got := transform("player.txt", true); !written || got != "version:3465\nrenderDistance:12\ngraphicsMode:1\n" {
+		t.Errorf("applied the recommended settings: %t, %q", written, got)
+	}
+}
+
+// optionsPack is a release of a pack recommending settings.
+func optionsPack(version string) map[string]string {
+	return map[string]string{
+		"pack.json":       `{"version": "` + version + `", "transforms": {"options.txt": "options-defaults"}}`,
+		"options.txt":     "renderDistance:12\ngraphicsMode:1\n",
+		"mods/sodium.jar": "sodium",
+	}
+}
+
+func TestUpdateMergesOptions(t *testing.T) {
+	u := newFakeUpdate(t, optionsPack("2024.06"))
+	player := string(readOptionsFixture(t, "vanilla-1.20.1.txt"))
+	writeFiles(t, u.minecraft, map[string]string{"options.txt": player})
+
+	u.run(t)
+	if got := readFile(t, filepath.Join(u.minecraft, "options.txt")); got != player {
+		t.Errorf("the player's options.txt changed:\n%s", got)
+	}
+
+	u.setPack(t, optionsPack("2024.07"))
+	output := readFile(t, u.run(t, "--apply-recommended-settings"))
+	if !strings.Contains(output, "The pack recommends other values for 1 of your settings:\n  - renderDistance: 32 -> 12\n") {
+		t.Errorf("output:\n%s", output)
+	}
+	want := strings.Replace(player, "renderDistance:32\n", "renderDistance:12\n", 1)
+	if got := readFile(t, filepath.Join(u.minecraft, "options.txt")); got != want {
+		t.Errorf("the recommended settings weren't applied:\n%s", got)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"runtime/debug"
+)
+
+// Exit codes, the contract with scripts and launchers running the updater.
+// Failures always exit with the code of their category. The successes
+// other than 0 are only told apart with --json, launchers and shells
+// treating anything but 0 as a failure keep working without it.
+//
+//	0    updated, or the mode asked for did its work
+//	1    local: a problem on this computer the player has to fix, e.g. a
+//	     mods directory that can't be written or damaged state
+//	2    usage: the command line is wrong
+//	3    network: the pack couldn't be fetched, try again later
+//	4    bug: the updater crashed
+//	10   up to date, nothing needed to change (--json only)
+//	11   updated, with warnings (--json only)
+//	124  timeout: the run took longer than allowed
+//	130  interrupted
+const (
+	exitOK       = 0
+	exitLocal    = 1
+	exitUsage    = 2
+	exitNetwork  = 3
+	exitBug      = 4
+	exitUpToDate = 10
+	exitWarnings = 11
+)
+
+// Categories of failure, each with its exit code.
+const (
+	categoryLocal       = "local"
+	categoryUsage       = "usage"
+	categoryNetwork     = "network"
+	categoryBug         = "bug"
+	categoryTimeout     = "timeout"
+	categoryInterrupted = "interrupted"
+)
+
+var categoryExitCodes = map[string]int{
+	categoryLocal:       exitLocal,
+	categoryUsage:       exitUsage,
+	categoryNetwork:     exitNetwork,
+	categoryBug:         exitBug,
+	categoryTimeout:     exitTimedOut,
+	categoryInterrupted: exitInterrupted,
+}
+
+// retryableCategories are the failures running the updater again later
+// may get past without anyone doing anything.
+var retryableCategories = map[string]bool{
+	categoryNetwork:     true,
+	categoryTimeout:     true,
+	categoryInterrupted: true,
+}
+
+// categorized is an error that knows its category of failure.
+type categorized interface {
+	error
+	Category() string
+}
+
+// RunError is an error of a category its type doesn't tell, e.g. one made
+// from a message.
+type RunError struct {
+	category string
+	Err      error
+}
+
+// Failure returns err as a failure of category.
+func Failure(category string, err error) error {
+	return &RunError{category: category, Err: err}
+}
+
+func (e *RunError) Error() string    { return e.Err.Error() }
+func (e *RunError) Unwrap() error    { return e.Err }
+func (e *RunError) Category() string { return e.category }
+
+// toldError is a failure the player was already told about in words of
+// its own, e.g. with the list of files that differ.
+type toldError struct {
+	err error
+}
+
+// Told returns err as told already: Fatal exits with it without telling it
+// again.
+func Told(err error) error {
+	return &toldError{err: err}
+}
+
+func (e *toldError) Error() string { return e.err.Error() }
+func (e *toldError) Unwrap() error { return e.err }
+
+// ErrorCategory returns the category of failure err is: the one its type
+// tells, network for errors of requests and connections and local for
+// everything else, e.g. files that can't be read or written.
+func ErrorCategory(err error) string {
+	var c categorized
+	var urlErr *url.Error
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &c):
+		return c.Category()
+	case errors.Is(err, errRunTimedOut):
+		return categoryTimeout
+	case errors.Is(err, errRunInterrupted):
+		return categoryInterrupted
+	case errors.As(err, &urlErr) || errors.As(err, &opErr) || errors.As(err, &dnsErr):
+		return categoryNetwork
+	}
+	return categoryLocal
+}
+
+// ErrorEnvelope describes why a run failed, for programs: the last line on
+// standard error in JSON mode.
+type ErrorEnvelope struct {
+	Code      int    `json:"code"`
+	Category  string `json:"category"`
+	Message   string `json:"message"`
+	Phase     string `json:"phase"`
+	Retryable bool   `json:"retryable"`
+}
+
+// jsonOutput is set by --json. Standard output is then left to programs:
+// everything printed for people goes to standard error, and failures end
+// with an ErrorEnvelope.
+var jsonOutput bool
+
+// machineOut is where output for programs goes, standard output.
+var machineOut io.Writer = os.Stdout
+
+// EnableJSONOutput switches to JSON mode, see jsonOutput.
+func EnableJSONOutput() {
+	jsonOutput = true
+	machineOut = os.Stdout
+	os.Stdout = os.Stderr
+}
+
+// writeEnvelope writes the envelope of a failure in JSON mode.
+func writeEnvelope(code int, category string, message string, phase string) {
+	if !jsonOutput {
+		return
+	}
+	line, _ := json.Marshal(ErrorEnvelope{
+		Code:      code,
+		Category:  category,
+		Message:   message,
+		Phase:     phase,
+		Retryable: retryableCategories[category],
+	})
+	fmt.Fprintln(os.Stderr, string(line))
+}
+
+// Exit ends a failed run with the exit code of err's category, what went
+// wrong already told.
+func Exit(err error) {
+	category := ErrorCategory(err)
+	code := categoryExitCodes[category]
+	Logf("fatal (%s, exit code %d): %s", category, code, err)
+	if category != categoryInterrupted {
+		RecordFailure(FailureKind(err), CurrentPhase(), err.Error())
+	}
+	WriteRunMetrics(code)
+	writeEnvelope(code, category, err.Error(), CurrentPhase())
+	osExit(code)
+}
+
+// Fatal tells err as the reason the run failed, unless it was told
+// already, and exits, see Exit.
+func Fatal(err error) {
+	if !errors.As(err, new(*toldError)) {
+		fmt.Println(T("fatal", err))
+	}
+	Exit(err)
+}
+
+// FailWith tells message as the reason the run failed and exits with the
+// exit code of category.
+func FailWith(category string, message string) {
+	fmt.Println(message)
+	Exit(Failure(category, errors.New(message)))
+}
+
+// runOutcome is the exit code of a run that succeeded, see ReportOutcome.
+var runOutcome = exitOK
+
+// SetOutcome records how an update that didn't fail went: changed tells
+// whether it changed anything, degraded whether a part of it, e.g. a
+// further target, failed.
+func SetOutcome(changed bool, degraded bool) {
+	switch {
+	case degraded || changed && len(WarningGroups()) > 0:
+		runOutcome = exitWarnings
+	case !changed:
+		runOutcome = exitUpToDate
+	default:
+		runOutcome = exitOK
+	}
+}
+
+// ReportOutcome exits with the exit code of the run's outcome in JSON
+// mode. It is deferred by main.
+func ReportOutcome() {
+	WriteRunMetrics(runOutcome)
+	if jsonOutput && runOutcome != exitOK {
+		Logf("exit code %d", runOutcome)
+		osExit(runOutcome)
+	}
+}
+
+// ReportCrash tells the phase a panicking run was in and exits as a bug.
+// It is deferred by main.
+func ReportCrash() {
+	if err := recover(); err != nil {
+		if code, ok := err.(exitCode); ok {
+			panic(code)
+		}
+		stack := debug.Stack()
+		Logf("fatal: crashed while %s: %v\n%s", CurrentPhase(), err, stack)
+		fmt.Fprintf(os.Stderr, "panic: %v\n\n%s\n", err, stack)
+		RecordFailure(categoryBug, CurrentPhase(), fmt.Sprint(err))
+		WriteRunMetrics(exitBug)
+		writeEnvelope(exitBug, categoryBug, fmt.Sprint(err), CurrentPhase())
+		osExit(exitBug)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorCategory(t *testing.T) {
+	urlErr := &url.Error{Op: "Get", URL: "https://github.com/pack.zip", Err: errors.New("connection reset")}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "typed", err: &statusError{status: http.StatusBadGateway}, want: categoryNetwork},
+		{name: "typed wrapped", err: fmt.Errorf("downloading: %w", &statusError{status: http.StatusNotFound}), want: categoryNetwork},
+		{name: "failure", err: Failure(categoryUsage, errors.New("Usage: diff --from <...>")), want: categoryUsage},
+		// the clock has to be set right, the request it surfaces in is
+		// no network problem
+		{name: "clock skew", err: &clockSkewError{skew: time.Hour, err: urlErr}, want: categoryLocal},
+		{name: "request", err: urlErr, want: categoryNetwork},
+		{name: "connection", err: fmt.Errorf("fetching: %w", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("refused")}), want: categoryNetwork},
+		{name: "name lookup", err: &net.DNSError{Name: "github.com", Err: "no such host"}, want: categoryNetwork},
+		{name: "timed out", err: fmt.Errorf("extracting: %w", errRunTimedOut), want: categoryTimeout},
+		{name: "interrupted", err: errRunInterrupted, want: categoryInterrupted},
+		{name: "file", err: &os.PathError{Op: "open", Path: "mods/sodium.jar", Err: os.ErrPermission}, want: categoryLocal},
+		{name: "anything else", err: errors.New("something"), want: categoryLocal},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ErrorCategory(test.err); got != test.want {
+				t.Errorf("category %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// TestExitCodes pins the exit codes of the categories, scripts rely on
+// them.
+func TestExitCodes(t *testing.T) {
+	want := map[string]int{
+		categoryLocal:       1,
+		categoryUsage:       2,
+		categoryNetwork:     3,
+		categoryBug:         4,
+		categoryTimeout:     124,
+		categoryInterrupted: 130,
+	}
+	if len(categoryExitCodes) != len(want) {
+		t.Errorf("exit codes %v", categoryExitCodes)
+	}
+	for category, code := range want {
+		if got := categoryExitCodes[category]; got != code {
+			t.Errorf("%s exits with %d, want %d", category, got, code)
+		}
+	}
+}
+
+// TestFatalTold tells a failure once: the one told already exits with its
+// category without being printed again.
+func TestFatalTold(t *testing.T) {
+	useRunState(t)
+	err := Failure(categoryUsage, errors.New("no such version"))
+	var code int
+	output := captureStdout(t, func() { code = exitsWith(func() { Fatal(err) }) })
+	if code != exitUsage || output != T("fatal", err)+"\n" {
+		t.Errorf("exited %d, printed %q", code, output)
+	}
+	output = captureStdout(t, func() { code = exitsWith(func() { Fatal(Told(err)) }) })
+	if code != exitUsage || output != "" {
+		t.Errorf("told: exited %d, printed %q", code, output)
+	}
+}
+
+func TestSetOutcome(t *testing.T) {
+	tests := []struct {
+		changed, degraded, warned bool
+		want                      int
+	}{
+		{changed: true, want: exitOK},
+		{changed: false, want: exitUpToDate},
+		{changed: true, warned: true, want: exitWarnings},
+		// warnings about an update that changed nothing don't count
+		{changed: false, warned: true, want: exitUpToDate},
+		{changed: false, degraded: true, want: exitWarnings},
+		{changed: true, degraded: true, want: exitWarnings},
+	}
+	saved := runOutcome
+	defer func() { runOutcome = saved }()
+	for _, test := range tests {
+		useWarnings(t)
+		if test.warned {
+			defer PrintWarningsTo(&strings.Builder{})()
+			Warn(warnLock, "the mods directory couldn't be locked")
+		}
+		SetOutcome(test.changed, test.degraded)
+		if runOutcome != test.want {
+			t.Errorf("changed %t, degraded %t, warned %t: outcome %d, want %d", test.changed, test.degraded, test.warned, runOutcome, test.want)
+		}
+	}
+}
+
+// useJSONOutput restores what --json switches, returning the file standard
+// error goes to meanwhile.
+func useJSONOutput(t *testing.T) string {
+	t.Helper()
+	stderr, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedJSON, savedMachine, savedStderr := jsonOutput, machineOut, os.Stderr
+	os.Stderr = stderr
+	t.Cleanup(func() {
+		jsonOutput, machineOut, os.Stderr = savedJSON, savedMachine, savedStderr
+		stderr.Close()
+	})
+	return stderr.Name()
+}
+
+// lastEnvelope returns the envelope on the last line of stderr, nil when
+// the last line is none.
+func lastEnvelope(t *testing.T, stderr string) *ErrorEnvelope {
+	t.Helper()
+	lines := strings.Split(strings.TrimRight(readFile(t, stderr), "\n"), "\n")
+	var envelope ErrorEnvelope
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &envelope); err != nil {
+		return nil
+	}
+	return &envelope
+}
+
+// TestReportCrash panics in a run: it exits as a bug, with the stack on
+// standard error followed by the envelope.
+func TestReportCrash(t *testing.T) {
+	useRunState(t)
+	stderr := useJSONOutput(t)
+	jsonOutput = true
+	SetPhase(phaseSwap)
+	code := exitsWith(func() {
+		defer ReportCrash()
+		var files map[string]string
+		files["sodium.jar"] = "sodium"
+	})
+	if code != exitBug {
+		t.Errorf("exited with %d", code)
+	}
+	if !strings.Contains(readFile(t, stderr), "panic: assignment to entry in nil map\n") {
+		t.Errorf("standard error:\n%s", readFile(t, stderr))
+	}
+	want := ErrorEnvelope{Code: exitBug, Category: categoryBug, Message: "assignment to entry in nil map", Phase: phaseSwap}
+	if envelope := lastEnvelope(t, stderr); envelope == nil || *envelope != want {
+		t.Errorf("envelope %+v, want %+v", envelope, want)
+	}
+}
+
+// TestUpdateFailuresJSON walks representative failures and outcomes of
+// runs with --json: each exits with the code of its category and ends
+// with its envelope, and nothing but output for programs is printed on
+// standard output.
+func TestUpdateFailuresJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup breaks the update, args are the run's after --json.
+		setup func(t *testing.T, u *fakeUpdate)
+		args  []string
+		code  int
+		// envelope is the one expected, its message only the start of it;
+		// nil when the run succeeded.
+		envelope *ErrorEnvelope
+	}{
+		{
+			name:     "usage",
+			args:     []string{"diff"},
+			code:     exitUsage,
+			envelope: &ErrorEnvelope{Code: exitUsage, Category: categoryUsage, Message: "Usage: diff --from", Phase: phaseStartup},
+		},
+		{
+			name: "network",
+			setup: func(t *testing.T, u *fakeUpdate) {
+				useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				}))
+			},
+			code:     exitNetwork,
+			envelope: &ErrorEnvelope{Code: exitNetwork, Category: categoryNetwork, Message: "", Phase: phaseDownload, Retryable: true},
+		},
+		{
+			name: "local",
+			setup: func(t *testing.T, u *fakeUpdate) {
+				if err := os.MkdirAll(u.state, 0755); err != nil {
+					t.Fatal(err)
+				}
+				SaveConfig(ConfFile{MCDirectory: u.mods, MCVersion: "1.20.1", MaxDuration: "soon"}, filepath.Join(u.state, "clientUpdate.json"))
+			},
+			code:     exitLocal,
+			envelope: &ErrorEnvelope{Code: exitLocal, Category: categoryLocal, Message: `maxDuration "soon": `, Phase: phaseStartup},
+		},
+		{name: "updated", code: -1},
+		{
+			name: "updated with warnings",
+			setup: func(t *testing.T, u *fakeUpdate) {
+				u.setPack(t, map[string]string{"mods/sodium.jar": "no fabric mod"})
+			},
+			code: exitWarnings,
+		},
+		{
+			name:  "up to date",
+			setup: func(t *testing.T, u *fakeUpdate) { u.run(t) },
+			code:  exitUpToDate,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useRunState(t)
+			useWarnings(t)
+			u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": modJar(t, "sodium", "0.5.8")})
+			if test.setup != nil {
+				test.setup(t, u)
+			}
+			stderr := useJSONOutput(t)
+			var stdout string
+			code := exitsWith(func() { stdout = u.run(t, append([]string{"--json"}, test.args...)...) })
+			if code != test.code {
+				t.Errorf("exited with %d, want %d, standard error:\n%s", code, test.code, readFile(t, stderr))
+			}
+			// failing runs unwind before the output is returned, their
+			// prose is checked on standard error below
+			if stdout != "" && readFile(t, stdout) != "" {
+				t.Errorf("printed on standard output:\n%s", readFile(t, stdout))
+			}
+			envelope := lastEnvelope(t, stderr)
+			if test.envelope == nil {
+				if envelope != nil {
+					t.Errorf("envelope %+v after a success", envelope)
+				}
+				return
+			}
+			if envelope == nil {
+				t.Fatalf("no envelope, standard error:\n%s", readFile(t, stderr))
+			}
+			want := *test.envelope
+			if !strings.HasPrefix(envelope.Message, want.Message) {
+				t.Errorf("message %q, want %q", envelope.Message, want.Message)
+			}
+			want.Message = envelope.Message
+			if *envelope != want {
+				t.Errorf("envelope %+v, want %+v", *envelope, want)
+			}
+			// the failure is told to people on standard error as well
+			if prose := readFile(t, stderr); !strings.Contains(prose[:strings.LastIndex(strings.TrimRight(prose, "\n"), "\n")+1], envelope.Message+"\n") {
+				t.Errorf("not told on standard error:\n%s", prose)
+			}
+		})
+	}
+}
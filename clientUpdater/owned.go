@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ownedAreas are the folders of the minecraft directory the "owned" report
+// looks for unmanaged files in, besides the mods directory.
+var ownedAreas = []string{"config", "resourcepacks", "shaderpacks"}
+
+// Whether an owned file is on disk as the updater left it.
+const (
+	ownedMatches  = "ok"
+	ownedModified = "modified"
+	ownedMissing  = "missing"
+)
+
+// OwnedFile is a file the updater considers its own: a mod of the pack in
+// the mods directory, or a file a transform wrote below the minecraft
+// directory.
+type OwnedFile struct {
+	Path string `json:"path"`
+	// Pack is the pack version that installed the file as it should be,
+	// empty when the journal doesn't tell.
+	Pack   string `json:"pack,omitempty"`
+	SHA256 string `json:"sha256"`
+	Status string `json:"status"`
+	// Provenance is where the file came from, see "why".
+	Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// OwnedReport is every file the updater owns in a minecraft directory, and
+// the files found next to them that it doesn't.
+type OwnedReport struct {
+	ModPath       string      `json:"modPath"`
+	MinecraftPath string      `json:"minecraftPath"`
+	Owned         []OwnedFile `json:"owned"`
+	Unmanaged     []string    `json:"unmanaged"`
+}
+
+// pathKeys canonicalizes paths below a directory, so the same file spelled
+// differently, e.g. in other case on a case-insensitive filesystem, with
+// other separators or with dots in it, has one key.
+type pathKeys struct {
+	names FileNames
+}
+
+func newPathKeys(dir string) pathKeys {
+	return pathKeys{names: NamesIn(dir)}
+}
+
+// Key returns the key of p, which must be absolute.
+func (k pathKeys) Key(p string) string {
+	return k.names.Key(filepath.Clean(filepath.FromSlash(p)))
+}
+
+// OwnedFiles reports the files the updater owns for modPath: the pack's
+// and external mods of the installed state, and the files transforms wrote
+// below its minecraft directory that no later run removed again. Each is
+// listed once whatever spellings the state and the journal use, with the
+// pack version of the last run installing its content.
+func OwnedFiles(state *InstalledState, journal []JournalEntry, modPath string) (*OwnedReport, error) {
+	minecraftPath := filepath.Dir(modPath)
+	report := &OwnedReport{ModPath: modPath, MinecraftPath: minecraftPath}
+	keys := newPathKeys(modPath)
+	inMods := func(p string) bool {
+		return strings.HasPrefix(keys.Key(p), keys.Key(modPath)+string(filepath.Separator))
+	}
+
+	// the last add of each content, and the last action on each path
+	added := map[string]JournalEntry{}
+	last := map[string]JournalEntry{}
+	var order []string
+	for _, entry := range journal {
+		key := keys.Key(entry.Path)
+		if entry.Action == journalAdd {
+			added[key+"\x00"+strings.ToLower(entry.SHA256)] = entry
+		}
+		if _, ok := last[key]; !ok {
+			order = append(order, key)
+		}
+		last[key] = entry
+	}
+	pack := func(key string, sum string) string {
+		return added[key+"\x00"+strings.ToLower(sum)].Pack
+	}
+
+	owned := map[string]bool{}
+	own := func(p string, sum string, provenance *Provenance) {
+		key := keys.Key(p)
+		if owned[key] {
+			return
+		}
+		owned[key] = true
+		file := OwnedFile{Path: p, Pack: pack(key, sum), SHA256: sum, Status: ownedMatches, Provenance: provenance}
+		current, err := fileSHA256(p)
+		switch {
+		case os.IsNotExist(err):
+			file.Status = ownedMissing
+		case err != nil:
+			file.Status = ownedModified
+		case !strings.EqualFold(current, sum):
+			file.Status = ownedModified
+		}
+		report.Owned = append(report.Owned, file)
+	}
+	if state != nil {
+		for _, file := range state.Files {
+			if installedByUpdater(file) || file.External != "" {
+				own(filepath.Join(modPath, filepath.FromSlash(file.Name)), file.SHA256, file.Provenance)
+			}
+		}
+	}
+	prefix := keys.Key(minecraftPath) + string(filepath.Separator)
+	for _, key := range order {
+		entry := last[key]
+		if entry.Action != journalAdd || inMods(entry.Path) || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		// files transforms wrote come from the pack, the journal tells
+		// which version and when
+		own(filepath.Clean(entry.Path), entry.SHA256, &Provenance{Source: primarySource, PackVersion: entry.Pack, InstalledAt: entry.Time})
+	}
+	sort.Slice(report.Owned, func(i, j int) bool { return report.Owned[i].Path < report.Owned[j].Path })
+
+	dirs := []string{modPath}
+	for _, area := range ownedAreas {
+		dirs = append(dirs, filepath.Join(minecraftPath, area))
+	}
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && dir != modPath && keys.Key(p) == keys.Key(modPath) {
+				return filepath.SkipDir
+			}
+			if !info.IsDir() && !owned[keys.Key(p)] {
+				report.Unmanaged = append(report.Unmanaged, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(report.Unmanaged)
+	return report, nil
+}
+
+// Print prints the report as a table, paths relative to the minecraft
+// directory.
+func (r *OwnedReport) Print() {
+	rel := func(p string) string {
+		if relative, err := filepath.Rel(r.MinecraftPath, p); err == nil {
+			return filepath.ToSlash(relative)
+		}
+		return p
+	}
+	fmt.Println(T("owned.header", r.ModPath, len(r.Owned)))
+	width := len(T("owned.pack"))
+	for _, file := range r.Owned {
+		if len(file.Pack) > width {
+			width = len(file.Pack)
+		}
+	}
+	fmt.Printf("  %-8s  %-*s  %-12s  %s\n", T("owned.status"), width, T("owned.pack"), "SHA-256", T("owned.path"))
+	for _, file := range r.Owned {
+		pack := file.Pack
+		if pack == "" {
+			pack = "-"
+		}
+		fmt.Printf("  %-8s  %-*s  %-12s  %s\n", file.Status, width, pack, shortHash(file.SHA256), rel(file.Path))
+	}
+	fmt.Println()
+	if len(r.Unmanaged) == 0 {
+		fmt.Println(T("owned.unmanaged.none"))
+		return
+	}
+	fmt.Println(T("owned.unmanaged", len(r.Unmanaged)))
+	for _, p := range r.Unmanaged {
+		fmt.Println("  " + rel(p))
+	}
+}
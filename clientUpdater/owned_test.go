@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPathKeys(t *testing.T) {
+	sensitivities(t, func(t *testing.T, sensitive bool) {
+		dir := t.TempDir()
+		keys := newPathKeys(dir)
+		mods := filepath.Join(dir, "mods")
+		same := func(a string, b string) bool { return keys.Key(a) == keys.Key(b) }
+		if !same(filepath.Join(mods, "sodium.jar"), mods+"/./sodium.jar") || !same(filepath.Join(mods, "sodium.jar"), mods+"/old/../sodium.jar") {
+			t.Errorf("dots spell other files")
+		}
+		if got := same(filepath.Join(mods, "Sodium.jar"), filepath.Join(dir, "MODS", "sodium.jar")); got == sensitive {
+			t.Errorf("other case is the same file: %t", got)
+		}
+		if same(filepath.Join(mods, "sodium.jar"), filepath.Join(mods, "sodium.jar.disabled")) {
+			t.Errorf("different files have one key")
+		}
+	})
+}
+
+// ownedFixture is a minecraft directory updated twice: the pack's mods,
+// a mod downloaded from its author, the player's, and configs written by
+// transforms, with the journal spelling some paths in other case.
+type ownedFixture struct {
+	minecraft string
+	mods      string
+	state     *InstalledState
+	journal   []JournalEntry
+}
+
+func newOwnedFixture(t *testing.T) *ownedFixture {
+	t.Helper()
+	f := &ownedFixture{minecraft: filepath.Join(t.TempDir(), ".minecraft")}
+	f.mods = filepath.Join(f.minecraft, "mods")
+	writeFiles(t, f.minecraft, map[string]string{
+		"mods/sodium.jar":             "sodium 2",
+		"mods/iris.jar":               "iris, edited",
+		"mods/voicechat.jar":          "voicechat",
+		"mods/mymod.jar":              "the player's",
+		"config/chat.json":            "chat",
+		"config/sodium-options.json":  "the player's",
+		"resourcepacks/Faithful.zip":  "faithful",
+		"shaderpacks/BSL.zip":         "bsl",
+		"options.txt":                 "not in an area",
+		"config/removed-later.toml":   "left by the player",
+		"saves/World/level.dat":       "not in an area",
+		"shaderpacks/BSL.zip.txt":     "bsl settings",
+		"resourcepacks/mods/deep.jar": "no mods directory",
+	})
+	sum := func(content string) string { return sha256Hex([]byte(content)) }
+	f.state = &InstalledState{Files: []InstalledFile{
+		{Name: "iris.jar", SHA256: sum("iris"), PackPath: "mods/iris.jar"},
+		{Name: "lithium.jar", SHA256: sum("lithium"), PackPath: "mods/lithium.jar"},
+		{Name: "mymod.jar", SHA256: sum("the player's")},
+		{Name: "sodium.jar", SHA256: sum("sodium 2"), PackPath: "mods/sodium.jar"},
+		{Name: "voicechat.jar", SHA256: sum("voicechat"), External: externalDownload},
+	}}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	entry := func(run string, pack string, action string, rel string, content string) JournalEntry {
+		return JournalEntry{Run: run, Time: at, Action: action, Path: filepath.Join(f.minecraft, filepath.FromSlash(rel)), SHA256: sum(content), Pack: pack}
+	}
+	f.journal = []JournalEntry{
+		entry("1", "2024.05", journalAdd, "mods/sodium.jar", "sodium 1"),
+		entry("1", "2024.05", journalAdd, "mods/iris.jar", "iris"),
+		entry("1", "2024.05", journalAdd, "config/chat.json", "chat"),
+		entry("1", "2024.05", journalAdd, "config/removed-later.toml", "removed"),
+		entry("2", "2024.06", journalDelete, "MODS/Sodium.jar", "sodium 1"),
+		entry("2", "2024.06", journalAdd, "MODS/Sodium.jar", "sodium 2"),
+		entry("2", "2024.06", journalDelete, "config/removed-later.toml", "removed"),
+		entry("2", "2024.06", journalAdd, "config/./chat.json", "chat"),
+	}
+	return f
+}
+
+func TestOwnedFiles(t *testing.T) {
+	sensitivities(t, func(t *testing.T, sensitive bool) {
+		f := newOwnedFixture(t)
+		report, err := OwnedFiles(f.state, f.journal, f.mods)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var owned []string
+		for _, file := range report.Owned {
+			rel, _ := filepath.Rel(f.minecraft, file.Path)
+			owned = append(owned, filepath.ToSlash(rel)+" "+file.Status+" "+file.Pack)
+		}
+		want := []string{
+			// the journal's other spelling of sodium.jar is another file,
+			// one that is missing, on case-sensitive filesystems
+			"MODS/Sodium.jar missing 2024.06",
+			"config/chat.json ok 2024.06",
+			"mods/iris.jar modified 2024.05",
+			"mods/lithium.jar missing ",
+			"mods/sodium.jar ok ",
+			"mods/voicechat.jar ok ",
+		}
+		if !sensitive {
+			want = []string{
+				"config/chat.json ok 2024.06",
+				"mods/iris.jar modified 2024.05",
+				"mods/lithium.jar missing ",
+				"mods/sodium.jar ok 2024.06",
+				"mods/voicechat.jar ok ",
+			}
+		}
+		if got, wantList := strings.Join(owned, "\n"), strings.Join(want, "\n"); got != wantList {
+			t.Errorf("owned\n%s\nwant\n%s", got, wantList)
+		}
+		var unmanaged []string
+		for _, p := range report.Unmanaged {
+			rel, _ := filepath.Rel(f.minecraft, p)
+			unmanaged = append(unmanaged, filepath.ToSlash(rel))
+		}
+		if got := strings.Join(unmanaged, " "); got != "config/removed-later.toml config/sodium-options.json mods/mymod.jar resourcepacks/Faithful.zip resourcepacks/mods/deep.jar shaderpacks/BSL.zip shaderpacks/BSL.zip.txt" {
+			t.Errorf("unmanaged %s", got)
+		}
+	})
+}
+
+func TestOwnedFilesWithoutState(t *testing.T) {
+	mods := filepath.Join(t.TempDir(), "mods")
+	writeFiles(t, mods, map[string]string{"sodium.jar": "sodium"})
+	report, err := OwnedFiles(nil, nil, mods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Owned) != 0 || len(report.Unmanaged) != 1 || report.Unmanaged[0] != filepath.Join(mods, "sodium.jar") {
+		t.Errorf("report %s", mustJSON(t, report))
+	}
+}
+
+// useMachineOut collects the output for programs of the test.
+func useMachineOut(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var b bytes.Buffer
+	saved := machineOut
+	machineOut = &b
+	t.Cleanup(func() { machineOut = saved })
+	return &b
+}
+
+func TestUpdateOwned(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{
+		"pack.json":        `{"version": "2024.06", "transforms": {"config/chat.json": "template"}}`,
+		"config/chat.json": "chat",
+		"mods/sodium.jar":  "sodium",
+	})
+	writeFiles(t, u.minecraft, map[string]string{"mods/mymod.jar": "mine", "mods/mymod.jar.keep": "", "shaderpacks/BSL.zip": "bsl"})
+	u.run(t)
+	writeFiles(t, u.mods, map[string]string{"sodium.jar": "sodium, edited"})
+
+	output := readFile(t, u.run(t, "owned"))
+	for _, want := range []string{
+		"Files the updater manages for " + u.mods + " (2):",
+		"  STATUS    PACK     SHA-256       PATH\n",
+		"  ok        2024.06  " + shortSum("chat") + "  config/chat.json\n",
+		"  modified  2024.06  " + shortSum("sodium") + "  mods/sodium.jar\n",
+		"Unmanaged, left alone by the updater (3):\n  mods/mymod.jar\n  mods/mymod.jar.keep\n  shaderpacks/BSL.zip\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("no %q in the output:\n%s", want, output)
+		}
+	}
+
+	machine := useMachineOut(t)
+	u.run(t, "owned", "--json")
+	var report OwnedReport
+	if err := json.Unmarshal(machine.Bytes(), &report); err != nil {
+		t.Fatalf("%s in the output for programs:\n%s", err, machine)
+	}
+	if len(report.Owned) != 2 || report.Owned[1].Path != filepath.Join(u.mods, "sodium.jar") || report.Owned[1].Status != ownedModified || len(report.Unmanaged) != 3 {
+		t.Errorf("report %s", mustJSON(t, report))
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PackPath returns the path of an archive entry inside the pack. GitHub
+// places every entry of a repository zipball under a top level folder named
+// after the repository and ref (rxmc-Mods-master/, rxmc-Mods-<commit>/),
+// which is stripped here.
+func PackPath(name string) string {
+	if i := strings.Index(name, "/"); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// packManifestName is the optional manifest at the root of the pack repo.
+const packManifestName = "pack.json"
+
+// PackManifest describes the layout of the mod pack repository. Packs without
+// one fall back to parsing the directory names in the archive.
+type PackManifest struct {
+	// Version is the pack's own release name, shown to players and
+	// recorded with every install.
+	Version string `json:"version,omitempty"`
+	// MinUpdaterVersion is the oldest updater able to install the pack;
+	// older ones stop before changing anything.
+	MinUpdaterVersion string `json:"minUpdaterVersion,omitempty"`
+	// StatsURL receives anonymous statistics after each update from
+	// players who agreed to send them. Without it nothing is ever asked
+	// or sent.
+	StatsURL string `json:"statsURL,omitempty"`
+	// Versions maps a Minecraft version to the folder holding its mods,
+	// e.g. "1.21": "mods-1.21".
+	Versions map[string]string `json:"versions"`
+	// Transforms maps files of the pack, by their path relative to the
+	// minecraft directory, to the transform installing them (see
+	// Transforms).
+	Transforms map[string]string `json:"transforms,omitempty"`
+	// CriticalMods are mod ids checked after every update, since a game
+	// missing one of them crashes in confusing ways. Defaults to the
+	// loader's own list.
+	CriticalMods []string `json:"criticalMods,omitempty"`
+	// Contents declares the number and total size of the mod files in
+	// each mods folder ("mods" for the legacy layout). An update
+	// extracting something else is stopped before the installed mods are
+	// replaced.
+	Contents map[string]PackContents `json:"contents,omitempty"`
+	// ModPolicies maps mod ids to "pinned", always forced back to the
+	// pack's version, or "floating", left alone while the player's own
+	// version is newer than the pack's.
+	ModPolicies map[string]string `json:"modPolicies,omitempty"`
+	// Platforms maps mod file names to the platforms they are installed
+	// on, for natives and other platform-specific jars. Files without an
+	// entry are installed everywhere.
+	Platforms map[string]PlatformConstraint `json:"platforms,omitempty"`
+	// Requirements are what a system needs to run the pack well, warned
+	// about before updating a system below them.
+	Requirements *SystemRequirements `json:"requirements,omitempty"`
+	// ConfigOnly marks a release that only changes the transformed files.
+	// Players whose mods don't verifiably match the pack still get the
+	// full update.
+	ConfigOnly bool `json:"configOnly,omitempty"`
+	// Layout is how mod files are placed in the mods directory: "flat",
+	// the default, puts all of them directly into it, "preserve" keeps
+	// the folders below the pack's mods folder. Updates changing it move
+	// the installed mods over.
+	Layout string `json:"layout,omitempty"`
+	// External are the mods the pack needs but isn't allowed to ship,
+	// downloaded from their authors or provided by the player.
+	External []ExternalMod `json:"external,omitempty"`
+	// LoaderVersions maps Minecraft versions, or series like "1.21.x", to
+	// the loader release the pack's mods need on them, e.g. "1.20.4":
+	// "0.15.11", "1.21.x": "0.16.5". The loader installed is checked
+	// against it on every update.
+	LoaderVersions map[string]string `json:"loaderVersions,omitempty"`
+	// ExtraFiles adds file name patterns, e.g. "*.dat", to those a folder
+	// of the minecraft directory may get from the transforms, for the
+	// unusual files some mods keep there. Junk is never installed.
+	ExtraFiles map[string][]string `json:"extraFiles,omitempty"`
+
+	// Ignored are the files of Transforms taken out because they aren't
+	// allowed in their folder or are junk, see ClassifyExtra.
+	Ignored []IgnoredExtra `json:"-"`
+}
+
+var (
+	legacyModPattern  = regexp.MustCompile("^[-._a-zA-Z0-9]*mods/.*\\.jar$")
+	versionDirPattern = regexp.MustCompile("^mods-([0-9][-.a-zA-Z0-9]*)/")
+)
+
+// ReadPackManifest returns the pack manifest contained in the archive, or nil
+// when the pack does not ship one.
+func ReadPackManifest(r *zip.Reader) (*PackManifest, error) {
+	for _, f := range r.File {
+		if PackPath(f.Name) != packManifestName {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		content, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return nil, err
+		}
+		return parsePackManifest(content)
+	}
+	return nil, nil
+}
+
+// parsePackManifest decodes and checks a manifest, see DecodePackManifest.
+// A manifest with problems requiring a newer updater is most likely using
+// what that one added, which is what is reported then.
+func parsePackManifest(content []byte) (*PackManifest, error) {
+	manifest, err := DecodePackManifest(content)
+	if err != nil {
+		if manifest != nil {
+			if tooOld := CheckUpdaterVersion(manifest.MinUpdaterVersion); tooOld != nil {
+				return nil, tooOld
+			}
+		}
+		return nil, err
+	}
+	manifest.filterTransforms()
+	return manifest, nil
+}
+
+// PackVersions maps every Minecraft version the pack supports to its mod
+// folder. The manifest is authoritative when present; otherwise folders named
+// mods-<version> are picked up from the archive. An empty result means the
+// pack uses the legacy single-folder layout.
+func PackVersions(r *zip.Reader, manifest *PackManifest) map[string]string {
+	names := make([]string, len(r.File))
+	for i, f := range r.File {
+		names[i] = f.Name
+	}
+	return packVersions(names, manifest)
+}
+
+// packVersions is PackVersions for a list of entry names.
+func packVersions(names []string, manifest *PackManifest) map[string]string {
+	versions := map[string]string{}
+	if manifest != nil && len(manifest.Versions) > 0 {
+		for mcVersion, folder := range manifest.Versions {
+			versions[mcVersion] = strings.Trim(folder, "/")
+		}
+		return versions
+	}
+	for _, name := range names {
+		match := versionDirPattern.FindStringSubmatch(PackPath(name))
+		if match != nil {
+			versions[match[1]] = "mods-" + match[1]
+		}
+	}
+	return versions
+}
+
+// SelectModFolder picks the folder for mcVersion out of the supported
+// versions. It also returns the newest supported version when that is newer
+// than the requested one, so the caller can let the user know.
+func SelectModFolder(versions map[string]string, mcVersion string) (folder string, newer string, err error) {
+	if len(versions) == 0 {
+		return "", "", nil
+	}
+	available := make([]string, 0, len(versions))
+	for v := range versions {
+		available = append(available, v)
+	}
+	sort.Slice(available, func(i, j int) bool {
+		return CompareMCVersions(available[i], available[j]) < 0
+	})
+
+	folder, ok := versions[mcVersion]
+	if !ok {
+		return "", "", fmt.Errorf("the mod pack has no mods for Minecraft %s (available: %s)", mcVersion, strings.Join(available, ", "))
+	}
+	if latest := available[len(available)-1]; CompareMCVersions(latest, mcVersion) > 0 {
+		newer = latest
+	}
+	return folder, newer, nil
+}
+
+// newestMCVersion returns the newest of the supported versions, "" when
+// there are none.
+func newestMCVersion(versions map[string]string) string {
+	newest := ""
+	for v := range versions {
+		if newest == "" || CompareMCVersions(v, newest) > 0 {
+			newest = v
+		}
+	}
+	return newest
+}
+
+// CompareMCVersions compares two dotted Minecraft versions numerically,
+// returning -1, 0 or 1. Non-numeric parts are compared as strings.
+func CompareMCVersions(a, b string) int {
+	as := strings.FieldsFunc(a, isVersionSeparator)
+	bs := strings.FieldsFunc(b, isVersionSeparator)
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y string
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		xn, xerr := strconv.Atoi(x)
+		yn, yerr := strconv.Atoi(y)
+		switch {
+		case x == y:
+			continue
+		case x == "" && yerr != nil:
+			// "1.21" is newer than "1.21-pre1"
+			return 1
+		case y == "" && xerr != nil:
+			return -1
+		case xerr == nil && yerr == nil:
+			if xn < yn {
+				return -1
+			}
+			return 1
+		case yerr == nil:
+			// "1.20-pre1" is older than "1.20.1"
+			return -1
+		case xerr == nil:
+			return 1
+		case x < y:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return 0
+}
+
+func isVersionSeparator(r rune) bool {
+	return r == '.' || r == '-'
+}
+
+// isModEntry reports whether an archive entry is a mod jar inside folder, or
+// inside any legacy mods folder when folder is empty.
+func isModEntry(name string, folder string) bool {
+	name = PackPath(name)
+	if folder == "" {
+		return legacyModPattern.MatchString(name)
+	}
+	return strings.HasPrefix(name, folder+"/") && strings.HasSuffix(name, ".jar")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"archive/zip"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openZip builds an archive of files, by entry name, and opens it.
+func openZip(t *testing.T, files map[string]string) *zip.Reader {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "pack.zip")
+	writeZip(t, p, files)
+	r, err := zip.OpenReader(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return &r.Reader
+}
+
+func TestPackVersionsFromFolders(t *testing.T) {
+	r := openZip(t, map[string]string{
+		"rxmc-Mods-master/mods-1.20.4/sodium.jar":     "",
+		"rxmc-Mods-master/mods-1.21/sodium.jar":       "",
+		"rxmc-Mods-master/mods-1.21/lithium.jar":      "",
+		"rxmc-Mods-master/mods-24w14a/sodium.jar":     "",
+		"rxmc-Mods-master/mods-notes/readme.txt":      "",
+		"rxmc-Mods-master/config/mods-1.19/ignored":   "",
+		"rxmc-Mods-master/mods-1.20.4-old.txt":        "",
+		"rxmc-Mods-master/archive/mods-1.18/x.jar":    "",
+		"rxmc-Mods-master/mods-1.20.1-pre1/alpha.jar": "",
+	})
+	got := PackVersions(r, nil)
+	want := map[string]string{"1.20.4": "mods-1.20.4", "1.21": "mods-1.21", "24w14a": "mods-24w14a", "1.20.1-pre1": "mods-1.20.1-pre1"}
+	if mustJSON(t, got) != mustJSON(t, want) {
+		t.Errorf("versions %v, want %v", got, want)
+	}
+}
+
+func TestPackVersionsFromManifest(t *testing.T) {
+	r := openZip(t, map[string]string{
+		"rxmc-Mods-master/mods-1.20.4/sodium.jar": "",
+		"rxmc-Mods-master/current/sodium.jar":     "",
+	})
+	// the manifest is authoritative, the folders' names don't count
+	manifest := &PackManifest{Versions: map[string]string{"1.21": "current/", "1.20.1": "/legacy"}}
+	got := PackVersions(r, manifest)
+	want := map[string]string{"1.21": "current", "1.20.1": "legacy"}
+	if mustJSON(t, got) != mustJSON(t, want) {
+		t.Errorf("versions %v, want %v", got, want)
+	}
+	// a manifest without versions leaves them to the folders
+	if got := PackVersions(r, &PackManifest{}); len(got) != 1 || got["1.20.4"] != "mods-1.20.4" {
+		t.Errorf("versions %v without any in the manifest", got)
+	}
+}
+
+func TestPackVersionsLegacy(t *testing.T) {
+	r := openZip(t, map[string]string{"rxmc-Mods-master/mods/sodium.jar": ""})
+	if got := PackVersions(r, nil); len(got) != 0 {
+		t.Errorf("legacy layout has versions %v", got)
+	}
+}
+
+func TestSelectModFolder(t *testing.T) {
+	versions := map[string]string{"1.20.4": "mods-1.20.4", "1.21": "mods-1.21", "1.20.1": "mods-1.20.1"}
+	tests := []struct {
+		mcVersion string
+		folder    string
+		newer     string
+		err       string
+	}{
+		{mcVersion: "1.21", folder: "mods-1.21"},
+		{mcVersion: "1.20.4", folder: "mods-1.20.4", newer: "1.21"},
+		{mcVersion: "1.20.1", folder: "mods-1.20.1", newer: "1.21"},
+		{mcVersion: "1.19.2", err: "the mod pack has no mods for Minecraft 1.19.2 (available: 1.20.1, 1.20.4, 1.21)"},
+	}
+	for _, test := range tests {
+		folder, newer, err := SelectModFolder(versions, test.mcVersion)
+		if folder != test.folder || newer != test.newer || (err == nil) != (test.err == "") || err != nil && err.Error() != test.err {
+			t.Errorf("SelectModFolder(%s) = %q, %q, %v", test.mcVersion, folder, newer, err)
+		}
+	}
+	if folder, newer, err := SelectModFolder(nil, "1.21"); folder != "" || newer != "" || err != nil {
+		t.Errorf("legacy layout: %q, %q, %v", folder, newer, err)
+	}
+}
+
+func TestCompareMCVersions(t *testing.T) {
+	ordered := []string{"1.9", "1.19", "1.19.4", "1.20-pre1", "1.20-rc1", "1.20", "1.20.1", "1.20.10", "1.21"}
+	for i, a := range ordered {
+		for j, b := range ordered {
+			want := 0
+			switch {
+			case i < j:
+				want = -1
+			case i > j:
+				want = 1
+			}
+			if got := CompareMCVersions(a, b); got != want {
+				t.Errorf("CompareMCVersions(%s, %s) = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+	if newest := newestMCVersion(map[string]string{"1.20.4": "", "1.21": "", "1.9": ""}); newest != "1.21" {
+		t.Errorf("newest %s", newest)
+	}
+}
+
+func TestIsModEntry(t *testing.T) {
+	tests := []struct {
+		name   string
+		folder string
+		want   bool
+	}{
+		{"rxmc-Mods-master/mods/sodium.jar", "", true},
+		{"rxmc-Mods-master/client-mods/sodium.jar", "", true},
+		{"rxmc-Mods-master/mods/readme.txt", "", false},
+		{"rxmc-Mods-master/sodium.jar", "", false},
+		{"rxmc-Mods-master/mods-1.21/sodium.jar", "mods-1.21", true},
+		{"rxmc-Mods-master/mods-1.21/performance/lithium.jar", "mods-1.21", true},
+		{"rxmc-Mods-master/mods-1.21.1/sodium.jar", "mods-1.21", false},
+		{"rxmc-Mods-master/mods-1.20.4/sodium.jar", "mods-1.21", false},
+	}
+	for _, test := range tests {
+		if got := isModEntry(test.name, test.folder); got != test.want {
+			t.Errorf("isModEntry(%s, %q) = %t", test.name, test.folder, got)
+		}
+	}
+	if got := PackPath("rxmc-Mods-0123abc/mods/sodium.jar"); got != "mods/sodium.jar" || !strings.HasPrefix(PackPath("pack.json"), "pack") {
+		t.Errorf("PackPath %s", got)
+	}
+}
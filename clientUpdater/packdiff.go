@@ -0,0 +1,424 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PackMod is one mod jar of a pack version.
+type PackMod struct {
+	// ID is the mod id from the jar's metadata, or the file name when the
+	// jar has none.
+	ID      string `json:"id"`
+	Version string `json:"version,omitempty"`
+	File    string `json:"file"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+}
+
+// PackSnapshot is the content of one version of the pack.
+type PackSnapshot struct {
+	Ref      string
+	Manifest *PackManifest
+	// ArchiveSize is what players download for this version, 0 when the
+	// snapshot was read from a directory.
+	ArchiveSize int64
+	// Mods maps every supported Minecraft version ("" for the legacy
+	// layout) to its mods by id.
+	Mods map[string]map[string]PackMod
+	// Conflicts are the mods with more than one jar in a version's
+	// folder. Mods keeps the first of them by file name.
+	Conflicts []ModConflict
+}
+
+// ModConflict is a mod id more than one jar of a pack version has, which
+// players would all get.
+type ModConflict struct {
+	Ref       string   `json:"ref"`
+	MCVersion string   `json:"mcVersion"`
+	ID        string   `json:"id"`
+	Files     []string `json:"files"`
+}
+
+// snapshotFile is a file of a pack version, from an archive or a directory.
+type snapshotFile struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+// LoadPackSnapshot reads the pack version ref: a local archive, a local
+// checkout of the pack repository, or otherwise a tag, branch or commit of
+// the pack repository, downloaded into tmpDir.
+func LoadPackSnapshot(ref string, tmpDir string) (*PackSnapshot, error) {
+	if info, err := os.Stat(ref); err == nil && info.IsDir() {
+		return snapshotFromDir(ref)
+	} else if err == nil {
+		return snapshotFromArchive(ref, ref)
+	}
+
+	dest := filepath.Join(tmpDir, strings.NewReplacer("/", "_", "\\", "_").Replace(ref)+".zip")
+	if err := DownloadFile(dest, fmt.Sprintf(packCommitURL, ref)); err != nil {
+		return nil, fmt.Errorf("%s: %s", ref, err)
+	}
+	return snapshotFromArchive(ref, dest)
+}
+
+func snapshotFromArchive(ref string, src string) (*PackSnapshot, error) {
+	r, err := OpenPackArchive(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", ref, err)
+	}
+	defer r.Close()
+	manifest, err := ReadPackManifest(&r.Reader)
+	if err != nil {
+		return nil, err
+	}
+	var files []snapshotFile
+	for _, f := range r.File {
+		if !f.FileInfo().IsDir() {
+			files = append(files, snapshotFile{name: f.Name, open: f.Open})
+		}
+	}
+	snapshot, err := buildSnapshot(ref, files, manifest)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(src); err == nil {
+		snapshot.ArchiveSize = info.Size()
+	}
+	return snapshot, nil
+}
+
+// snapshotFromDir reads a checkout of the pack repository. Its files are
+// named as they would be in the archive, below the directory's name.
+func snapshotFromDir(dir string) (*PackSnapshot, error) {
+	var manifest *PackManifest
+	if content, err := ioutil.ReadFile(filepath.Join(dir, packManifestName)); err == nil {
+		if manifest, err = parsePackManifest(content); err != nil {
+			return nil, err
+		}
+	}
+	var files []snapshotFile
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, snapshotFile{
+			name: filepath.Base(dir) + "/" + filepath.ToSlash(rel),
+			open: func() (io.ReadCloser, error) { return os.Open(p) },
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buildSnapshot(dir, files, manifest)
+}
+
+func buildSnapshot(ref string, files []snapshotFile, manifest *PackManifest) (*PackSnapshot, error) {
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.name
+	}
+	versions := packVersions(names, manifest)
+	if len(versions) == 0 {
+		versions[""] = ""
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	snapshot := &PackSnapshot{Ref: ref, Manifest: manifest, Mods: map[string]map[string]PackMod{}}
+	for mcVersion, folder := range versions {
+		mods := map[string]PackMod{}
+		conflicts := map[string]*ModConflict{}
+		for _, f := range files {
+			if !isModEntry(f.name, folder) {
+				continue
+			}
+			mod, err := describeMod(f)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", ref, err)
+			}
+			first, ok := mods[mod.ID]
+			if !ok {
+				mods[mod.ID] = mod
+				continue
+			}
+			if conflicts[mod.ID] == nil {
+				conflicts[mod.ID] = &ModConflict{Ref: ref, MCVersion: mcVersion, ID: mod.ID, Files: []string{first.File}}
+			}
+			conflicts[mod.ID].Files = append(conflicts[mod.ID].Files, mod.File)
+		}
+		snapshot.Mods[mcVersion] = mods
+		for _, conflict := range conflicts {
+			snapshot.Conflicts = append(snapshot.Conflicts, *conflict)
+		}
+	}
+	return snapshot, nil
+}
+
+// modMetadataLimit bounds the jars whose metadata describeMod reads, it
+// needs the whole jar in memory for that. Larger jars are still hashed
+// whole and go by their file name.
+var modMetadataLimit int64 = 256 << 20
+
+// cappedBuffer keeps what is written to it up to left bytes, and drops the
+// rest.
+type cappedBuffer struct {
+	bytes.Buffer
+	left int64
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if int64(len(p)) <= b.left {
+		b.Buffer.Write(p)
+	}
+	b.left -= int64(len(p))
+	return len(p), nil
+}
+
+// describeMod hashes a mod jar and reads its metadata.
+func describeMod(f snapshotFile) (PackMod, error) {
+	mod := PackMod{File: filepath.Base(f.name)}
+	rc, err := f.open()
+	if err != nil {
+		return mod, err
+	}
+	h := sha256.New()
+	content := &cappedBuffer{left: modMetadataLimit}
+	mod.Size, err = io.Copy(io.MultiWriter(h, content), rc)
+	rc.Close()
+	if err != nil {
+		return mod, err
+	}
+	mod.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	mod.ID = mod.File
+	if content.left < 0 {
+		return mod, nil
+	}
+	if r, err := zip.NewReader(bytes.NewReader(content.Bytes()), mod.Size); err == nil {
+		if info, err := readModInfo(r, mod.File); err == nil {
+			mod.ID, mod.Version = info.ID, info.Version
+		}
+	}
+	return mod, nil
+}
+
+// ModChange is a mod added, removed or updated between two pack versions.
+type ModChange struct {
+	ID   string   `json:"id"`
+	From *PackMod `json:"from,omitempty"`
+	To   *PackMod `json:"to,omitempty"`
+}
+
+// VersionDiff is what changes for players of one Minecraft version.
+type VersionDiff struct {
+	MCVersion string `json:"mcVersion"`
+	// FromLoader and ToLoader are the loader releases the manifests'
+	// loaderVersions require on MCVersion, empty for none.
+	FromLoader string      `json:"fromLoader,omitempty"`
+	ToLoader   string      `json:"toLoader,omitempty"`
+	Added      []ModChange `json:"added"`
+	Removed    []ModChange `json:"removed"`
+	Updated    []ModChange `json:"updated"`
+	Unchanged  int         `json:"unchanged"`
+	// ChangedSize is the size of the added and updated jars, the part of
+	// the download that is actually new to these players.
+	ChangedSize int64 `json:"changedSize"`
+}
+
+// PackDiff compares two versions of the pack.
+type PackDiff struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	FromVersion string `json:"fromVersion,omitempty"`
+	ToVersion   string `json:"toVersion,omitempty"`
+	// FromMinUpdater and ToMinUpdater are the manifests' minUpdaterVersion.
+	FromMinUpdater string `json:"fromMinUpdater,omitempty"`
+	ToMinUpdater   string `json:"toMinUpdater,omitempty"`
+	// AddedMCVersions and RemovedMCVersions are Minecraft versions the pack
+	// starts or stops supporting.
+	AddedMCVersions   []string      `json:"addedMcVersions"`
+	RemovedMCVersions []string      `json:"removedMcVersions"`
+	Versions          []VersionDiff `json:"versions"`
+	// Conflicts are the mods with more than one jar, in either version.
+	Conflicts []ModConflict `json:"conflicts,omitempty"`
+	// ArchiveSize is what every player downloads for the new version.
+	ArchiveSize int64 `json:"archiveSize"`
+}
+
+// DiffPacks compares two pack versions, see LoadPackSnapshot for what from
+// and to may be. Nothing but a temporary directory is written.
+func DiffPacks(from string, to string) (*PackDiff, error) {
+	tmpDir, err := ioutil.TempDir("", "rxmc-diff")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	oldPack, err := LoadPackSnapshot(from, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	newPack, err := LoadPackSnapshot(to, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	return diffSnapshots(oldPack, newPack), nil
+}
+
+func diffSnapshots(oldPack *PackSnapshot, newPack *PackSnapshot) *PackDiff {
+	diff := &PackDiff{From: oldPack.Ref, To: newPack.Ref, ArchiveSize: newPack.ArchiveSize}
+	if oldPack.Manifest != nil {
+		diff.FromVersion, diff.FromMinUpdater = oldPack.Manifest.Version, oldPack.Manifest.MinUpdaterVersion
+	}
+	if newPack.Manifest != nil {
+		diff.ToVersion, diff.ToMinUpdater = newPack.Manifest.Version, newPack.Manifest.MinUpdaterVersion
+	}
+
+	for mcVersion := range newPack.Mods {
+		if _, ok := oldPack.Mods[mcVersion]; !ok {
+			diff.AddedMCVersions = append(diff.AddedMCVersions, mcVersion)
+		}
+	}
+	for mcVersion, oldMods := range oldPack.Mods {
+		newMods, ok := newPack.Mods[mcVersion]
+		if !ok {
+			diff.RemovedMCVersions = append(diff.RemovedMCVersions, mcVersion)
+			continue
+		}
+		versionDiff := diffMods(mcVersion, oldMods, newMods)
+		versionDiff.FromLoader = oldPack.loaderVersion(mcVersion)
+		versionDiff.ToLoader = newPack.loaderVersion(mcVersion)
+		diff.Versions = append(diff.Versions, versionDiff)
+	}
+	diff.Conflicts = append(append(diff.Conflicts, oldPack.Conflicts...), newPack.Conflicts...)
+	byVersion := func(list []string) func(i, j int) bool {
+		return func(i, j int) bool { return CompareMCVersions(list[i], list[j]) < 0 }
+	}
+	sort.Slice(diff.AddedMCVersions, byVersion(diff.AddedMCVersions))
+	sort.Slice(diff.RemovedMCVersions, byVersion(diff.RemovedMCVersions))
+	sort.Slice(diff.Versions, func(i, j int) bool {
+		return CompareMCVersions(diff.Versions[i].MCVersion, diff.Versions[j].MCVersion) < 0
+	})
+	sort.SliceStable(diff.Conflicts, func(i, j int) bool {
+		a, b := diff.Conflicts[i], diff.Conflicts[j]
+		if a.Ref != b.Ref {
+			return a.Ref == oldPack.Ref
+		}
+		if a.MCVersion != b.MCVersion {
+			return CompareMCVersions(a.MCVersion, b.MCVersion) < 0
+		}
+		return a.ID < b.ID
+	})
+	return diff
+}
+
+// loaderVersion is the loader release the snapshot's manifest requires on
+// mcVersion, empty for none.
+func (s *PackSnapshot) loaderVersion(mcVersion string) string {
+	if s.Manifest == nil {
+		return ""
+	}
+	return ResolveLoaderVersion(s.Manifest.LoaderVersions, mcVersion)
+}
+
+func diffMods(mcVersion string, oldMods map[string]PackMod, newMods map[string]PackMod) VersionDiff {
+	d := VersionDiff{MCVersion: mcVersion}
+	for id, mod := range newMods {
+		mod := mod
+		before, ok := oldMods[id]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, ModChange{ID: id, To: &mod})
+			d.ChangedSize += mod.Size
+		case before.SHA256 != mod.SHA256:
+			d.Updated = append(d.Updated, ModChange{ID: id, From: &before, To: &mod})
+			d.ChangedSize += mod.Size
+		default:
+			d.Unchanged++
+		}
+	}
+	for id, mod := range oldMods {
+		mod := mod
+		if _, ok := newMods[id]; !ok {
+			d.Removed = append(d.Removed, ModChange{ID: id, From: &mod})
+		}
+	}
+	for _, list := range [][]ModChange{d.Added, d.Removed, d.Updated} {
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	}
+	return d
+}
+
+// Print shows the difference for people.
+func (d *PackDiff) Print() {
+	fmt.Println(T("diff.header", d.From, d.To))
+	if d.FromVersion != d.ToVersion {
+		fmt.Println(T("diff.packversion", d.FromVersion, d.ToVersion))
+	}
+	if d.FromMinUpdater != d.ToMinUpdater {
+		fmt.Println(T("diff.minupdater", d.FromMinUpdater, d.ToMinUpdater))
+	}
+	if len(d.AddedMCVersions) > 0 {
+		fmt.Println(T("diff.mc.added", strings.Join(d.AddedMCVersions, ", ")))
+	}
+	if len(d.RemovedMCVersions) > 0 {
+		fmt.Println(T("diff.mc.removed", strings.Join(d.RemovedMCVersions, ", ")))
+	}
+	for _, v := range d.Versions {
+		fmt.Println()
+		if v.MCVersion == "" {
+			fmt.Println(T("diff.legacy"))
+		} else {
+			fmt.Println(T("diff.version", v.MCVersion))
+		}
+		if v.FromLoader != v.ToLoader {
+			fmt.Println(T("diff.loader", loaderOrAny(v.FromLoader), loaderOrAny(v.ToLoader)))
+		}
+		for _, c := range v.Added {
+			fmt.Printf("  + %s %s\n", c.ID, c.To.Version)
+		}
+		for _, c := range v.Removed {
+			fmt.Printf("  - %s %s\n", c.ID, c.From.Version)
+		}
+		for _, c := range v.Updated {
+			fmt.Printf("  ~ %s %s -> %s\n", c.ID, c.From.Version, c.To.Version)
+		}
+		fmt.Println(T("diff.summary", len(v.Added), len(v.Removed), len(v.Updated), v.Unchanged))
+		fmt.Println(T("diff.size", megabytes(v.ChangedSize), megabytes(d.ArchiveSize)))
+	}
+	if len(d.Conflicts) > 0 {
+		fmt.Println()
+		fmt.Println(T("diff.conflicts"))
+		for _, c := range d.Conflicts {
+			fmt.Println(T("diff.conflict", c.Ref, c.MCVersion, c.ID, strings.Join(c.Files, ", ")))
+		}
+	}
+}
+
+// loaderOrAny names a required loader release, any without one.
+func loaderOrAny(version string) string {
+	if version == "" {
+		return T("diff.loader.any")
+	}
+	return version
+}
+
+// megabytes formats a size for people.
+func megabytes(size int64) string {
+	return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+}
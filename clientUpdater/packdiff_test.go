@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The pack checkouts of testdata/diff: 1.5 updates sodium and the loader
+// on 1.20.1, adds a mod there, drops legacy-tweaks and 1.20.4, and starts
+// supporting 1.21 with two jars of lithium left in its folder.
+const (
+	diffFrom = "testdata/diff/v1.4"
+	diffTo   = "testdata/diff/v1.5"
+)
+
+func TestDiffPacksFixtures(t *testing.T) {
+	diff, err := DiffPacks(diffFrom, diffTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, filepath.Join("diff", "v1.4-v1.5.golden"), captureStdout(t, diff.Print))
+	out, err := json.MarshalIndent(diff, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, filepath.Join("diff", "v1.4-v1.5.json.golden"), string(out)+"\n")
+
+	// the same versions as archives differ the same way, with the size of
+	// the download
+	archived, err := DiffPacks(packArchive(t, diffFrom), packArchive(t, diffTo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(archived.Versions, diff.Versions) || archived.ArchiveSize == 0 {
+		t.Errorf("archives differ in %s, download %d", mustJSON(t, archived.Versions), archived.ArchiveSize)
+	}
+	for i, c := range archived.Conflicts {
+		if c.ID != diff.Conflicts[i].ID || !reflect.DeepEqual(c.Files, diff.Conflicts[i].Files) {
+			t.Errorf("archive conflict %+v", c)
+		}
+	}
+}
+
+// TestDiffPacksLoader changes only the loader a version requires, and
+// then only the series entry resolving to it.
+func TestDiffPacksLoader(t *testing.T) {
+	mods := PackMod{ID: "sodium", File: "sodium.jar", SHA256: "0123"}
+	snapshot := func(loaderVersions map[string]string) *PackSnapshot {
+		return &PackSnapshot{
+			Ref:      "pack",
+			Manifest: &PackManifest{LoaderVersions: loaderVersions},
+			Mods:     map[string]map[string]PackMod{"1.20.1": {"sodium": mods}, "1.21.1": {"sodium": mods}},
+		}
+	}
+	tests := []struct {
+		from, to map[string]string
+		changed  map[string][2]string
+	}{
+		{map[string]string{"1.20.1": "0.15.11"}, map[string]string{"1.20.1": "0.16.0"}, map[string][2]string{"1.20.1": {"0.15.11", "0.16.0"}}},
+		{map[string]string{"1.21.x": "0.16.5"}, map[string]string{"1.21.x": "0.16.5", "1.21.1": "0.16.9"}, map[string][2]string{"1.21.1": {"0.16.5", "0.16.9"}}},
+		{nil, map[string]string{"1.x": "0.16.0"}, map[string][2]string{"1.20.1": {"", "0.16.0"}, "1.21.1": {"", "0.16.0"}}},
+		{map[string]string{"1.20.1": "0.15.11"}, map[string]string{"1.20.x": "0.15.11"}, map[string][2]string{}},
+	}
+	for _, test := range tests {
+		diff := diffSnapshots(snapshot(test.from), snapshot(test.to))
+		changed := map[string][2]string{}
+		for _, v := range diff.Versions {
+			if v.FromLoader != v.ToLoader {
+				changed[v.MCVersion] = [2]string{v.FromLoader, v.ToLoader}
+			}
+		}
+		if !reflect.DeepEqual(changed, test.changed) {
+			t.Errorf("%v -> %v: changed %v", test.from, test.to, changed)
+		}
+	}
+	output := captureStdout(t, diffSnapshots(snapshot(nil), snapshot(map[string]string{"1.20.1": "0.16.0"})).Print)
+	if !strings.Contains(output, T("diff.loader", T("diff.loader.any"), "0.16.0")) {
+		t.Errorf("output:\n%s", output)
+	}
+}
+
+func TestDescribeMod(t *testing.T) {
+	file := func(name string, content []byte) snapshotFile {
+		return snapshotFile{name: name, open: func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(content)), nil }}
+	}
+	jar := []byte(modJar(t, "sodium", "0.5.11"))
+	mod, err := describeMod(file("pack/mods/sodium-0.5.11.jar", jar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PackMod{ID: "sodium", Version: "0.5.11", File: "sodium-0.5.11.jar", Size: int64(len(jar)), SHA256: sha256Hex(jar)}
+	if mod != want {
+		t.Errorf("described %+v, want %+v", mod, want)
+	}
+
+	// a jar too large for its metadata to be read is still hashed whole
+	saved := modMetadataLimit
+	modMetadataLimit = int64(len(jar)) - 1
+	defer func() { modMetadataLimit = saved }()
+	mod, err = describeMod(file("pack/mods/sodium-0.5.11.jar", jar))
+	want.ID, want.Version = "sodium-0.5.11.jar", ""
+	if err != nil || mod != want {
+		t.Errorf("large jar described %+v, %v", mod, err)
+	}
+
+	mod, err = describeMod(file("pack/mods/readme.jar", []byte("not a jar")))
+	if err != nil || mod.ID != "readme.jar" || mod.SHA256 != sha256Hex([]byte("not a jar")) {
+		t.Errorf("not a jar described %+v, %v", mod, err)
+	}
+}
+
+// TestSnapshotConflicts reads two jars of one mod: the first by file name
+// is the mod, both are a conflict.
+func TestSnapshotConflicts(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "pack")
+	writeFiles(t, dir, map[string]string{
+		"mods/sodium-b.jar":     modJar(t, "sodium", "0.5.11"),
+		"mods/sodium-a.jar":     modJar(t, "sodium", "0.5.8"),
+		"mods/sodium-extra.jar": modJar(t, "sodium", "0.6.0"),
+		"mods/iris.jar":         modJar(t, "iris", "1.7.0"),
+	})
+	snapshot, err := snapshotFromDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mod := snapshot.Mods[""]["sodium"]; mod.Version != "0.5.8" || len(snapshot.Mods[""]) != 2 {
+		t.Errorf("mods %s", mustJSON(t, snapshot.Mods))
+	}
+	want := []ModConflict{{Ref: dir, ID: "sodium", Files: []string{"sodium-a.jar", "sodium-b.jar", "sodium-extra.jar"}}}
+	if !reflect.DeepEqual(snapshot.Conflicts, want) {
+		t.Errorf("conflicts %+v", snapshot.Conflicts)
+	}
+}
+
+// TestDiffMode compares the fixtures through the diff mode, as JSON for
+// programs.
+func TestDiffMode(t *testing.T) {
+	machine := useMachineOut(t)
+	runMain(t, "--portable", t.TempDir(), "diff", "--from", diffFrom, "--to", diffTo, "--json")
+	var diff PackDiff
+	if err := json.Unmarshal(machine.Bytes(), &diff); err != nil {
+		t.Fatalf("%s in the output for programs:\n%s", err, machine)
+	}
+	checkGolden(t, filepath.Join("diff", "v1.4-v1.5.json.golden"), machine.String())
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// packTagsURL lists the tags of the pack repository, one for each released
+// version of the pack.
+const packTagsURL = "https://api.github.com/repos/rx13/rxmc-Mods/tags?per_page=100"
+
+// packTag is a tag as the repository lists it.
+type packTag struct {
+	Name   string `json:"name"`
+	Commit struct {
+		SHA string `json:"sha"`
+	} `json:"commit"`
+}
+
+// unknownPackVersionError means the pack has no release of the version
+// asked for, available lists those it has.
+type unknownPackVersionError struct {
+	version   string
+	available []string
+}
+
+func (e *unknownPackVersionError) Error() string {
+	if len(e.available) == 0 {
+		return fmt.Sprintf("the pack has no version %s, it has no released versions at all", e.version)
+	}
+	return fmt.Sprintf("the pack has no version %s, available are %s", e.version, strings.Join(e.available, ", "))
+}
+
+func (e *unknownPackVersionError) Category() string {
+	return categoryUsage
+}
+
+// samePackVersion reports whether two spellings name the same version,
+// "v1.4" being "1.4" as well.
+func samePackVersion(a string, b string) bool {
+	trim := func(s string) string {
+		return strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(s), "v"), "V")
+	}
+	return strings.EqualFold(trim(a), trim(b))
+}
+
+// ResolvePackVersion returns the commit of the pack release version, e.g.
+// v1.4, from the tags listed at tagsURL. A version the pack doesn't have
+// is an *unknownPackVersionError listing the ones it has.
+func ResolvePackVersion(version string, tagsURL string) (string, error) {
+	req, err := http.NewRequestWithContext(runCtx, "GET", tagsURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := withTimeout(probeTimeout).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &statusError{status: resp.StatusCode}
+	}
+	content, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	var tags []packTag
+	if err := json.Unmarshal(content, &tags); err != nil {
+		return "", fmt.Errorf("unexpected list of pack versions: %s", err)
+	}
+	var available []string
+	for _, tag := range tags {
+		if samePackVersion(tag.Name, version) && commitPattern.MatchString(tag.Commit.SHA) {
+			Logf("pack version %s is commit %s", tag.Name, tag.Commit.SHA)
+			return tag.Commit.SHA, nil
+		}
+		available = append(available, tag.Name)
+	}
+	sort.Strings(available)
+	return "", &unknownPackVersionError{version: version, available: available}
+}
+
+// VersionCheck compares the mods directory with one version of the pack.
+type VersionCheck struct {
+	Archive *PackArchive
+	ModPath string
+	// Differing are the files of the version missing from the mods
+	// directory or installed with other content. Extra are files the
+	// updater installed that the version doesn't have.
+	Differing []InstalledFile
+	Extra     []InstalledFile
+
+	// entries maps the names of the pack's files to their archive entry.
+	entries map[string]string
+}
+
+// Matches reports whether the mods directory is exactly the version.
+func (c *VersionCheck) Matches() bool {
+	return len(c.Differing) == 0 && len(c.Extra) == 0
+}
+
+// CheckPackVersion compares modPath with the version of the pack in
+// archive: its mods and external mods against what is installed, and the
+// files state records as the updater's against what the version has.
+// Protected files are the player's and never count. Nothing is changed.
+func CheckPackVersion(archive *PackArchive, state *InstalledState, modPath string) (*VersionCheck, error) {
+	c := &VersionCheck{Archive: archive, ModPath: modPath, entries: map[string]string{}}
+	protected, err := ScanProtected(modPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	paths := protectedPaths(modPath, protected)
+
+	var expected []InstalledFile
+	r, err := OpenPackArchive(archive.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || !archive.IsModEntry(f.Name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.New()
+		_, err = io.Copy(hash, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		name := archive.ModRelPath(f.Name)
+		c.entries[name] = f.Name
+		expected = append(expected, InstalledFile{Name: name, SHA256: hex.EncodeToString(hash.Sum(nil)), Size: int64(f.UncompressedSize64), PackPath: PackPath(f.Name)})
+	}
+	if archive.Manifest != nil {
+		for _, mod := range archive.Manifest.External {
+			file := InstalledFile{Name: mod.File, SHA256: strings.ToLower(mod.SHA256), External: mod.kind(), URL: mod.URL}
+			if mod.UserProvided() {
+				file.URL = mod.Page
+			}
+			expected = append(expected, file)
+		}
+	}
+
+	wanted := map[string]bool{}
+	for _, file := range expected {
+		wanted[file.Name] = true
+		p := filepath.Join(modPath, filepath.FromSlash(file.Name))
+		if paths.Has(p) {
+			continue
+		}
+		if !sameContent(p, file) {
+			c.Differing = append(c.Differing, file)
+		}
+	}
+	if state != nil {
+		for _, file := range state.Files {
+			p := filepath.Join(modPath, filepath.FromSlash(file.Name))
+			if wanted[file.Name] || file.PackPath == "" && file.External == "" || paths.Has(p) {
+				continue
+			}
+			if _, err := os.Stat(p); err == nil {
+				c.Extra = append(c.Extra, file)
+			}
+		}
+	}
+	return c, nil
+}
+
+// Print lists what differs from the version.
+func (c *VersionCheck) Print() {
+	for _, file := range c.Differing {
+		fmt.Println(T("verify.differs", file.Name))
+	}
+	for _, file := range c.Extra {
+		fmt.Println(T("verify.extra", file.Name))
+	}
+}
+
+// Repair makes the mods directory the version: differing files are put
+// back from the archive or downloaded from their authors, extra files
+// moved to backupDir, every change journaled. Mods the player provides can
+// only be reported. It returns the names of the repaired files and of
+// those that couldn't be repaired.
+func (c *VersionCheck) Repair(backupDir string, journal *Journal) (repaired []string, failed []string, err error) {
+	for _, file := range append(append([]InstalledFile{}, c.Extra...), c.Differing...) {
+		if file.External == externalUser {
+			continue
+		}
+		p := filepath.Join(c.ModPath, filepath.FromSlash(file.Name))
+		sum, err := fileSHA256(p)
+		if err != nil {
+			continue
+		}
+		backup := filepath.Join(backupDir, filepath.FromSlash(file.Name))
+		if err := moveFile(p, backup); err != nil {
+			return repaired, failed, err
+		}
+		if err := journal.Record(JournalEntry{Action: journalDelete, Path: p, SHA256: sum, Backup: backup}); err != nil {
+			return repaired, failed, err
+		}
+	}
+
+	r, err := OpenPackArchive(c.Archive.Path)
+	if err != nil {
+		return repaired, failed, err
+	}
+	defer r.Close()
+	files := map[string]*zip.File{}
+	for _, f := range r.File {
+		files[f.Name] = f
+	}
+	for _, file := range c.Differing {
+		if err := DiskFull(); err != nil {
+			return repaired, failed, err
+		}
+		if file.External == externalUser {
+			failed = append(failed, file.Name)
+			continue
+		}
+		name, err := sanitizeEntryPath(file.Name)
+		if err != nil {
+			return repaired, failed, err
+		}
+		p := filepath.Join(c.ModPath, name)
+		if err := os.MkdirAll(filepath.Dir(p), dirPerm); err != nil {
+			return repaired, failed, err
+		}
+		if file.External != "" {
+			_, err = downloadFile(p, file.URL, file.SHA256)
+		} else {
+			err = extractEntry(files[c.entries[file.Name]], p)
+		}
+		if err != nil {
+			Logf("repair: %s: %s", file.Name, err)
+			os.Remove(p)
+			failed = append(failed, file.Name)
+			continue
+		}
+		if err := journal.Record(JournalEntry{Action: journalAdd, Path: p, SHA256: file.SHA256}); err != nil {
+			return repaired, failed, err
+		}
+		repaired = append(repaired, file.Name)
+	}
+	return repaired, failed, nil
+}
+
+// PinInstalledState pins the installed state recorded at p to the pack
+// version, or clears the pin when version is empty.
+func PinInstalledState(p string, version string) error {
+	state, err := ReadInstalledState(p)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("no update has been recorded yet, run a normal update first")
+	}
+	state.PinnedVersion = version
+	state.UpdatedAt = clock.Now()
+	return WriteInstalledState(p, state)
+}
+
+// runPackVersion verifies the mods directory against the pack version, or
+// with mode "repair" makes it that version and pins it. The version is
+// fetched like the pack of the last update, by its commit, and checked
+// with everything the pack has.
+func runPackVersion(mode string, version string, config ConfFile, state *InstalledState, cache *Cache, fileURL string, fileOut string, installedPath string, journalPath string, backupsRoot string, runID string) error {
+	commit, err := ResolvePackVersion(version, packTagsURL)
+	if err != nil {
+		return err
+	}
+	fmt.Println(T("download.start"))
+	archive, err := FetchPack(fileOut, packURLs(commit, fileURL, config.Mirrors), "", state.MCVersion)
+	if err != nil {
+		return fetchFailed(err)
+	}
+	defer RemoveTemporary(archive.Path)
+	fmt.Println(T("verify.version", config.MCDirectory, version))
+	check, err := CheckPackVersion(archive, state, config.MCDirectory)
+	if err != nil {
+		return err
+	}
+	Logf("%s: %d files differ from %s, %d extra", mode, len(check.Differing), version, len(check.Extra))
+	if mode == "verify" {
+		check.Print()
+		if !check.Matches() {
+			return Told(Failure(categoryLocal, fmt.Errorf("%d files differ from pack version %s", len(check.Differing)+len(check.Extra), version)))
+		}
+		fmt.Println(T("verify.ok"))
+		return nil
+	}
+	loader, err := LoaderByName(state.Loader, cache)
+	if err != nil {
+		return err
+	}
+	if err := unlockForUpdate(config.MCDirectory); err != nil {
+		return err
+	}
+	repaired, failed, err := check.Repair(filepath.Join(backupsRoot, runID), &Journal{Path: journalPath, Run: runID, Pack: archive.Label()})
+	if err == nil {
+		err = RecordInstalledState(installedPath, archive, loader, config.MCDirectory, ResolveMinecraftRoot(config.MCDirectory, state), state.MCVersion, nil)
+	}
+	if err == nil {
+		err = PinInstalledState(installedPath, version)
+	}
+	relockAfterUpdate(config.MCDirectory, config.LockModsDir)
+	for _, name := range repaired {
+		fmt.Println(T("repair.fixed", name))
+	}
+	PrintRepairFailed(&InstalledState{Files: check.Differing}, failed)
+	Logf("repair: %d repaired, %d failed, error %v", len(repaired), len(failed), err)
+	if err != nil {
+		return err
+	}
+	fmt.Println(T("pin.set", version))
+	if len(failed) > 0 {
+		return Told(Failure(categoryLocal, fmt.Errorf("%d files couldn't be repaired", len(failed))))
+	}
+	SetOutcome(len(repaired) > 0 || len(check.Extra) > 0, false)
+	return nil
+}
+
+// What holds the normal update back, see UpdateHold.
+const (
+	holdNone   = ""
+	holdPinned = "pinned"
+	holdFrozen = "frozen"
+)
+
+// UpdateHold tells what keeps a normal run from updating the mods
+// directory to the latest pack, in which case it only verifies and repairs
+// the installed version. A pin, set by "repair --pack-version" and kept
+// until --unpin, comes first: it holds whether or not there is a freeze,
+// and outlasts a freeze ending. A freeze holds while it is active.
+func UpdateHold(freeze *Freeze, state *InstalledState, now time.Time) string {
+	switch {
+	case state != nil && state.PinnedVersion != "":
+		return holdPinned
+	case freeze.Active(now):
+		return holdFrozen
+	}
+	return holdNone
+}
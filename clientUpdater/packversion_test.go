@@ -0,0 +1,360 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// v14Commit is the commit the pack's tag v1.4 names.
+const v14Commit = "1414141414141414141414141414141414141414"
+
+// tagsServer lists the pack's tags and serves the archive of v1.4 by its
+// commit, everything else coming from next.
+type tagsServer struct {
+	next    http.Handler
+	archive string
+}
+
+func (s *tagsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Host == "api.github.com" && r.URL.Path == "/repos/rx13/rxmc-Mods/tags":
+		fmt.Fprintf(w, `[{"name": "v1.5", "commit": {"sha": "%s"}}, {"name": "v1.4", "commit": {"sha": "%s"}}, {"name": "v1.3", "commit": {"sha": "not a commit"}}]`, strings.Repeat("15", 20), v14Commit)
+	case r.Host == "github.com" && r.URL.Path == "/rx13/rxmc-Mods/archive/"+v14Commit+".zip":
+		http.ServeFile(w, r, s.archive)
+	default:
+		s.next.ServeHTTP(w, r)
+	}
+}
+
+// writeCommitZip writes the archive of a pack commit holding files, by entry
+// name, with the commit as its comment like GitHub's archives.
+func writeCommitZip(t *testing.T, p string, commit string, files map[string]string) {
+	t.Helper()
+	out, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	w := zip.NewWriter(out)
+	for name, content := range files {
+		f, err := w.Create("rxmc-Mods-" + commit + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.SetComment(commit); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSamePackVersion(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		same bool
+	}{
+		{"v1.4", "v1.4", true},
+		{"v1.4", "1.4", true},
+		{"V1.4", " v1.4 ", true},
+		{"v1.4-RC1", "1.4-rc1", true},
+		{"v1.4", "v1.40", false},
+		{"v1.4", "vv1.4", false},
+	} {
+		if same := samePackVersion(test.a, test.b); same != test.same {
+			t.Errorf("samePackVersion(%q, %q) = %v", test.a, test.b, same)
+		}
+	}
+}
+
+func TestResolvePackVersion(t *testing.T) {
+	useTestServer(t, &tagsServer{next: http.NotFoundHandler()})
+	tagsURL := "https://api.github.com/repos/rx13/rxmc-Mods/tags"
+	for _, version := range []string{"v1.4", "1.4"} {
+		commit, err := ResolvePackVersion(version, tagsURL)
+		if err != nil || commit != v14Commit {
+			t.Errorf("version %s is commit %q, %v", version, commit, err)
+		}
+	}
+
+	// a tag without a commit is listed, but can't be installed
+	_, err := ResolvePackVersion("v1.3", tagsURL)
+	unknown, ok := err.(*unknownPackVersionError)
+	if !ok {
+		t.Fatalf("v1.3: %v", err)
+	}
+	if got := unknown.Error(); got != "the pack has no version v1.3, available are v1.3, v1.4, v1.5" {
+		t.Errorf("v1.3: %s", got)
+	}
+	if ErrorCategory(err) != categoryUsage {
+		t.Errorf("an unknown version is a %s error", ErrorCategory(err))
+	}
+
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/empty":
+			w.Write([]byte("[]"))
+		case "/garbage":
+			w.Write([]byte("<html>"))
+		default:
+			http.Error(w, "rate limited", http.StatusForbidden)
+		}
+	}))
+	if _, err := ResolvePackVersion("v1.4", "https://api.github.com/empty"); err == nil || err.Error() != "the pack has no version v1.4, it has no released versions at all" {
+		t.Errorf("no tags: %v", err)
+	}
+	if _, err := ResolvePackVersion("v1.4", "https://api.github.com/garbage"); err == nil || !strings.HasPrefix(err.Error(), "unexpected list of pack versions") {
+		t.Errorf("a page for tags: %v", err)
+	}
+	if _, err := ResolvePackVersion("v1.4", "https://api.github.com/limited"); err == nil || ErrorCategory(err) == categoryUsage {
+		t.Errorf("a failing listing: %v", err)
+	}
+}
+
+// TestUpdateHold is the precedence of the pin and the freeze: a pin holds
+// whatever the freeze, a freeze only while it is active.
+func TestUpdateHold(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	pinned := &InstalledState{PinnedVersion: "v1.4"}
+	for _, test := range []struct {
+		name   string
+		freeze *Freeze
+		state  *InstalledState
+		hold   string
+	}{
+		{"nothing", nil, &InstalledState{}, holdNone},
+		{"no state", nil, nil, holdNone},
+		{"frozen", &Freeze{On: true}, &InstalledState{}, holdFrozen},
+		{"frozen before any update", &Freeze{On: true}, nil, holdFrozen},
+		{"frozen until tomorrow", &Freeze{On: true, Until: now.Add(24 * time.Hour)}, &InstalledState{}, holdFrozen},
+		{"freeze ended", &Freeze{On: true, Until: now}, &InstalledState{}, holdNone},
+		{"unfrozen", &Freeze{On: false}, &InstalledState{}, holdNone},
+		{"pinned", nil, pinned, holdPinned},
+		{"pinned and frozen", &Freeze{On: true}, pinned, holdPinned},
+		{"pinned, freeze ended", &Freeze{On: true, Until: now.Add(-time.Hour)}, pinned, holdPinned},
+		{"pinned, unfrozen", &Freeze{On: false}, pinned, holdPinned},
+	} {
+		if hold := UpdateHold(test.freeze, test.state, now); hold != test.hold {
+			t.Errorf("%s: held %q, want %q", test.name, hold, test.hold)
+		}
+	}
+}
+
+func TestPinInstalledState(t *testing.T) {
+	useFakeClock(t)
+	p := filepath.Join(t.TempDir(), "installed.json")
+	if err := PinInstalledState(p, "v1.4"); err == nil {
+		t.Error("pinned before any update")
+	}
+	if err := WriteInstalledState(p, &InstalledState{PackVersion: "1.5"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := PinInstalledState(p, "v1.4"); err != nil {
+		t.Fatal(err)
+	}
+	state, err := ReadInstalledState(p)
+	if err != nil || state.PinnedVersion != "v1.4" || state.PackVersion != "1.5" || !state.UpdatedAt.Equal(clock.Now()) {
+		t.Fatalf("pinned state %+v, %v", state, err)
+	}
+	if err := PinInstalledState(p, ""); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := ReadInstalledState(p); err != nil || state.PinnedVersion != "" {
+		t.Errorf("unpinned state %+v, %v", state, err)
+	}
+}
+
+// TestCheckPackVersion compares a mods directory with a version: the
+// player's files never count, the updater's files the version doesn't
+// have do.
+func TestCheckPackVersion(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "pack.zip")
+	writeZip(t, archivePath, map[string]string{
+		"rxmc-Mods-v1.4/mods/fabric-api.jar": "fabric api",
+		"rxmc-Mods-v1.4/mods/sodium.jar":     "sodium 1",
+		"rxmc-Mods-v1.4/mods/iris.jar":       "iris 1",
+		"rxmc-Mods-v1.4/mods/mine.jar":       "the pack's",
+		"rxmc-Mods-v1.4/README.md":           "not a mod",
+	})
+	archive, err := LocalPack(archivePath, "1.20.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mods := t.TempDir()
+	writeFiles(t, mods, map[string]string{
+		"fabric-api.jar":  "fabric api",
+		"sodium.jar":      "sodium 2",
+		"lithium.jar":     "lithium",
+		"mine.jar":        "the player's",
+		"mine.jar.keep":   "",
+		"local/extra.jar": "the player's",
+		"unknown.jar":     "not the updater's",
+	})
+	state := &InstalledState{Files: []InstalledFile{
+		{Name: "fabric-api.jar", PackPath: "mods/fabric-api.jar"},
+		{Name: "sodium.jar", PackPath: "mods/sodium.jar"},
+		{Name: "lithium.jar", PackPath: "mods/lithium.jar"},
+		{Name: "gone.jar", PackPath: "mods/gone.jar"},
+		{Name: "unknown.jar"},
+	}}
+	check, err := CheckPackVersion(archive, state, mods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var differing, extra []string
+	for _, file := range check.Differing {
+		differing = append(differing, file.Name)
+	}
+	for _, file := range check.Extra {
+		extra = append(extra, file.Name)
+	}
+	if got := strings.Join(differing, " "); got != "iris.jar sodium.jar" {
+		t.Errorf("differing %s", got)
+	}
+	if got := strings.Join(extra, " "); got != "lithium.jar" {
+		t.Errorf("extra %s", got)
+	}
+	if check.Matches() {
+		t.Error("matches")
+	}
+	if got := dirNames(t, mods); got != "fabric-api.jar lithium.jar local mine.jar mine.jar.keep sodium.jar unknown.jar" {
+		t.Errorf("checking changed the mods directory to %s", got)
+	}
+
+	// without a recorded update nothing is the updater's
+	check, err = CheckPackVersion(archive, nil, mods)
+	if err != nil || len(check.Extra) != 0 || len(check.Differing) != 2 {
+		t.Errorf("without state %+v, %v", check, err)
+	}
+}
+
+// TestRunPackVersion verifies the mods directory against pack versions
+// without going through main: a mismatch and a version that can't be
+// downloaded were told already, an unknown version wasn't.
+func TestRunPackVersion(t *testing.T) {
+	useRunState(t)
+	v14 := filepath.Join(t.TempDir(), "v1.4.zip")
+	writeCommitZip(t, v14, v14Commit, map[string]string{"mods/sodium.jar": "sodium 1"})
+	useTestServer(t, &tagsServer{archive: v14, next: http.NotFoundHandler()})
+	root := t.TempDir()
+	mods := filepath.Join(root, "mods")
+	writeFiles(t, mods, map[string]string{"sodium.jar": "sodium 1"})
+	config := ConfFile{MCDirectory: mods, Mirrors: []string{"https://mirror.example/pack.zip"}}
+	state := &InstalledState{MCVersion: "1.20.1", Files: []InstalledFile{{Name: "sodium.jar", PackPath: "mods/sodium.jar"}}}
+	verify := func(config ConfFile, version string) (err error) {
+		captureStdout(t, func() {
+			err = runPackVersion("verify", version, config, state, &Cache{Dir: t.TempDir()}, "https://github.com/rx13/rxmc-Mods/archive/master.zip", filepath.Join(root, "pack.zip"), filepath.Join(root, "installed.json"), filepath.Join(root, "journal.jsonl"), filepath.Join(root, "backups"), "run")
+		})
+		return err
+	}
+
+	if err := verify(config, "v1.4"); err != nil {
+		t.Errorf("v1.4: %v", err)
+	}
+	if err := verify(config, "v1.5"); !errors.As(err, new(*toldError)) {
+		t.Errorf("v1.5 not downloaded: %v", err)
+	}
+	if err := verify(config, "v1.2"); err == nil || errors.As(err, new(*toldError)) || ErrorCategory(err) != categoryUsage {
+		t.Errorf("v1.2: %v", err)
+	}
+
+	writeFiles(t, mods, map[string]string{"sodium.jar": "sodium 2"})
+	err := verify(config, "v1.4")
+	if !errors.As(err, new(*toldError)) || ErrorCategory(err) != categoryLocal || err.Error() != "1 files differ from pack version v1.4" {
+		t.Errorf("differing: %v", err)
+	}
+}
+
+// TestRepairPackVersion goes back to v1.4 after v1.5 is installed. The pin
+// holds through a freeze and its end, only --unpin lets the next run
+// update.
+func TestRepairPackVersion(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{
+		"pack.json":        `{"version": "1.5"}`,
+		"mods/sodium.jar":  "sodium 2",
+		"mods/lithium.jar": "lithium",
+	})
+	v14 := filepath.Join(t.TempDir(), "v1.4.zip")
+	writeCommitZip(t, v14, v14Commit, map[string]string{
+		"pack.json":       `{"version": "1.4"}`,
+		"mods/sodium.jar": "sodium 1",
+	})
+	useTestServer(t, &tagsServer{next: u.server, archive: v14})
+	useRunState(t)
+	installedPath := filepath.Join(u.state, "clientUpdate-installed.json")
+	logPath := filepath.Join(u.state, "clientUpdate.log")
+	u.run(t)
+
+	if code := exitsWith(func() { u.run(t, "verify", "--pack-version", "v1.4") }); code != exitLocal {
+		t.Errorf("verifying v1.5 against v1.4 exited %d", code)
+	}
+	if log := readFile(t, logPath); !strings.Contains(log, "verify: 1 files differ from v1.4, 1 extra") {
+		t.Errorf("log:\n%s", log)
+	}
+	if code := exitsWith(func() { u.run(t, "verify", "--pack-version", "v1.2") }); code != exitUsage {
+		t.Errorf("verifying an unknown version exited %d", code)
+	}
+	if log := readFile(t, logPath); !strings.Contains(log, "the pack has no version v1.2, available are v1.3, v1.4, v1.5") {
+		t.Errorf("log:\n%s", log)
+	}
+
+	output := readFile(t, u.run(t, "repair", "--pack-version", "v1.4"))
+	if got := dirNames(t, u.mods); got != "sodium.jar" || readFile(t, filepath.Join(u.mods, "sodium.jar")) != "sodium 1" {
+		t.Fatalf("repaired to v1.4, installed %s, output:\n%s", got, output)
+	}
+	if !strings.Contains(output, T("pin.set", "v1.4")) {
+		t.Errorf("output:\n%s", output)
+	}
+	backups, _ := filepath.Glob(filepath.Join(u.state, backupsDirName, "*", "lithium.jar"))
+	if len(backups) != 1 {
+		t.Errorf("lithium.jar backed up %d times", len(backups))
+	}
+	state, err := ReadInstalledState(installedPath)
+	if err != nil || state.PinnedVersion != "v1.4" || state.PackVersion != "1.4" || state.PackCommit != v14Commit {
+		t.Fatalf("installed state %+v, %v", state, err)
+	}
+	if code := exitsWith(func() { output = readFile(t, u.run(t, "verify", "--pack-version", "1.4")) }); code != -1 {
+		t.Errorf("verifying v1.4 exited %d", code)
+	}
+	if !strings.Contains(output, T("verify.ok")) {
+		t.Errorf("output:\n%s", output)
+	}
+
+	// a plain run keeps and repairs v1.4, frozen or not
+	writeFiles(t, u.mods, map[string]string{"sodium.jar": "damaged"})
+	u.run(t)
+	if got := dirNames(t, u.mods); got != "sodium.jar" || readFile(t, filepath.Join(u.mods, "sodium.jar")) != "sodium 1" {
+		t.Errorf("pinned, installed %s", got)
+	}
+	u.run(t, "--freeze")
+	u.run(t, "--unfreeze")
+	u.run(t)
+	if got := dirNames(t, u.mods); got != "sodium.jar" {
+		t.Errorf("pinned after a freeze, installed %s", got)
+	}
+	if log := readFile(t, logPath); !strings.Contains(log, "pinned to v1.4, verifying instead of updating") {
+		t.Errorf("log:\n%s", log)
+	}
+
+	if output := readFile(t, u.run(t, "--unpin")); !strings.Contains(output, T("pin.unset")) {
+		t.Errorf("output:\n%s", output)
+	}
+	u.run(t)
+	if got := dirNames(t, u.mods); got != "lithium.jar sodium.jar" || readFile(t, filepath.Join(u.mods, "sodium.jar")) != "sodium 2" {
+		t.Errorf("unpinned, installed %s", got)
+	}
+	if state, err := ReadInstalledState(installedPath); err != nil || state.PinnedVersion != "" || state.PackVersion != "1.5" {
+		t.Errorf("installed state %+v, %v", state, err)
+	}
+}
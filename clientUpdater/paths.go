@@ -0,0 +1,357 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ResolveModsDir accepts either a mods directory or the .minecraft directory
+// containing it, and returns the mods directory.
+func ResolveModsDir(dir string) (string, error) {
+	dir = NormalizeDir(dir)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+	if strings.HasSuffix(strings.ToLower(filepath.Base(dir)), "mods") {
+		return dir, nil
+	}
+	return filepath.Join(dir, "mods"), nil
+}
+
+// probeName is the file written to check a directory is writable.
+const probeName = ".clientUpdater-probe"
+
+// NormalizeDir cleans up a directory typed by the user or read from the
+// config: surrounding whitespace and quotes are dropped and separators are
+// converted to the ones of the platform.
+func NormalizeDir(dir string) string {
+	dir = strings.Trim(strings.TrimSpace(dir), "\"'")
+	if dir == "" {
+		return ""
+	}
+	return filepath.Clean(filepath.FromSlash(dir))
+}
+
+// ModsDirStatus is the result of checking a mods directory before use.
+type ModsDirStatus struct {
+	Path string
+	// Exists is false when the directory is still to be created inside an
+	// existing parent.
+	Exists   bool
+	Writable bool
+	// Locked is set for a mods directory left read-only by LockModsDir, it
+	// is unlocked before anything is changed.
+	Locked bool
+	Err    error
+}
+
+// String describes the status for the status output.
+func (s ModsDirStatus) String() string {
+	switch {
+	case s.Err != nil:
+		return T("modsdir.invalid", s.Err)
+	case !s.Exists:
+		return T("modsdir.create")
+	case s.Locked:
+		return T("modsdir.locked")
+	default:
+		return T("modsdir.ok")
+	}
+}
+
+// gameDirMarkers are files and folders of which at least one is found in a
+// minecraft directory, whichever launcher created it.
+var gameDirMarkers = []string{"versions", "options.txt", "launcher_profiles.json", "saves", "config", "logs"}
+
+// looksLikeGameDir reports whether dir is, or could become, a minecraft
+// directory: it is named like one or already contains game files.
+func looksLikeGameDir(dir string) bool {
+	switch strings.ToLower(filepath.Base(dir)) {
+	case ".minecraft", "minecraft":
+		return true
+	}
+	for _, marker := range gameDirMarkers {
+		if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// modsDirError is a mods directory an update can't use.
+type modsDirError struct {
+	dir string
+	err error
+}
+
+func (e *modsDirError) Error() string    { return e.err.Error() }
+func (e *modsDirError) Unwrap() error    { return e.err }
+func (e *modsDirError) Category() string { return categoryLocal }
+
+// gameRootMarkers are only ever found in a minecraft directory itself, never
+// in its mods folder.
+var gameRootMarkers = []string{"saves", "versions", "launcher_profiles.json", "screenshots"}
+
+// gameRootError is returned for a minecraft directory given as the mods
+// directory, whatever it is named.
+type gameRootError struct {
+	dir    string
+	marker string
+}
+
+func (e *gameRootError) Error() string {
+	return fmt.Sprintf("%s is a minecraft directory (it holds %s), not a mods directory; its mods directory is %s", e.dir, e.marker, e.ModsDir())
+}
+
+// ModsDir is the mods directory of the minecraft directory.
+func (e *gameRootError) ModsDir() string {
+	return filepath.Join(e.dir, "mods")
+}
+
+// checkSafeModsDir refuses directories whose contents must never be
+// replaced: filesystem roots, the home directory, minecraft directories
+// and anything whose parent isn't a minecraft directory. It applies to
+// every mods directory, however it is named.
+func checkSafeModsDir(dir string) error {
+	if filepath.Dir(dir) == dir {
+		return fmt.Errorf("%s is the root of a drive", dir)
+	}
+	if home := baseDirs.Home; home != "" && strings.EqualFold(filepath.Clean(home), dir) {
+		return fmt.Errorf("%s is your home directory", dir)
+	}
+	for _, marker := range gameRootMarkers {
+		if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+			return &gameRootError{dir: dir, marker: marker}
+		}
+	}
+	if !looksLikeGameDir(filepath.Dir(dir)) {
+		return fmt.Errorf("%s is not inside a minecraft directory", dir)
+	}
+	return nil
+}
+
+// IsStandardModsDir reports whether dir is named like a mods folder.
+func IsStandardModsDir(dir string) bool {
+	return strings.HasSuffix(strings.ToLower(dir), "mods")
+}
+
+// ConfirmNonStandardModsDir warns that dir doesn't look like a mods folder
+// and only returns true once the user typed the folder's name. This is
+// deliberately not answered by --yes: replacing the contents of the wrong
+// folder can't be undone by the user noticing afterwards. The default is
+// to stop.
+func ConfirmNonStandardModsDir(prompter *Prompter, dir string) bool {
+	name := filepath.Base(dir)
+	fmt.Println(T("path.notmods", dir))
+	return prompter.Text(T("path.notmods.confirm", name), "") == name
+}
+
+// CheckModsDir checks a normalized mods directory is absolute, safe to
+// replace, exists (or can be created in an existing parent) and is
+// writable.
+func CheckModsDir(dir string) ModsDirStatus {
+	status := ModsDirStatus{Path: dir}
+	if dir == "" {
+		status.Err = fmt.Errorf("no directory set")
+		return status
+	}
+	if !filepath.IsAbs(dir) {
+		status.Err = fmt.Errorf("%s is not an absolute path", dir)
+		return status
+	}
+	if err := checkSafeModsDir(dir); err != nil {
+		status.Err = err
+		return status
+	}
+
+	writeDir := dir
+	info, err := os.Stat(dir)
+	switch {
+	case err == nil && !info.IsDir():
+		status.Err = fmt.Errorf("%s is not a directory", dir)
+		return status
+	case err == nil:
+		status.Exists = true
+		// a locked directory is writable once unlocked, so its parent is
+		// probed instead
+		if ModsDirLocked(dir) {
+			status.Locked = true
+			writeDir = filepath.Dir(dir)
+		}
+	case os.IsNotExist(err):
+		writeDir = filepath.Dir(dir)
+		if info, err := os.Stat(writeDir); err != nil || !info.IsDir() {
+			status.Err = fmt.Errorf("neither %s nor its parent directory exist", dir)
+			return status
+		}
+	default:
+		status.Err = err
+		return status
+	}
+
+	probe := filepath.Join(writeDir, probeName)
+	file, err := os.OpenFile(probe, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, filePerm)
+	if err != nil {
+		status.Err = fmt.Errorf("%s is not writable: %w", writeDir, err)
+		return status
+	}
+	file.Close()
+	os.Remove(probe)
+	status.Writable = true
+	return status
+}
+
+// offerGameModsDir explains that a minecraft directory was given as the
+// mods directory and offers its mods directory instead. ok is false when
+// status has another problem or the offer was declined, by default it is
+// taken.
+func offerGameModsDir(prompter *Prompter, status ModsDirStatus) (string, bool) {
+	var root *gameRootError
+	if !errors.As(status.Err, &root) {
+		return "", false
+	}
+	fmt.Println(T("modsdir.gameroot", root.dir, root.marker))
+	modsDir := root.ModsDir()
+	if CheckModsDir(modsDir).Err != nil {
+		return "", false
+	}
+	return modsDir, prompter.Confirm(T("modsdir.gameroot.use", modsDir), true)
+}
+
+// pickAttempts is how often the user may enter an unusable directory.
+const pickAttempts = 3
+
+// PickModsDir asks the user for the mods directory until a usable one is
+// entered. There is no default.
+func PickModsDir(prompter *Prompter) (string, error) {
+	for attempt := 0; attempt < pickAttempts; attempt++ {
+		fmt.Println(T("prompt.path"))
+		input, err := prompter.Line("  > ")
+		if err != nil {
+			return "", err
+		}
+		dir := NormalizeDir(input)
+		status := CheckModsDir(dir)
+		if status.Err == nil {
+			return dir, nil
+		}
+		if modsDir, ok := offerGameModsDir(prompter, status); ok {
+			return modsDir, nil
+		}
+		fmt.Println(T("path.unusable", status.Err))
+	}
+	return "", fmt.Errorf("no usable directory entered")
+}
+
+// launcherDataDirs are the data directories of Prism Launcher and MultiMC,
+// below the user's config directory, holding an instances folder.
+var launcherDataDirs = []string{"PrismLauncher", "MultiMC"}
+
+// InstancesDirs returns the instances folders of the Prism Launcher and
+// MultiMC installs found on this machine, Prism Launcher's first.
+func InstancesDirs() []string {
+	roots := []string{baseDirs.Config}
+	if !isWindows() {
+		roots = append(roots, filepath.Join(baseDirs.Home, ".local", "share"))
+	}
+	var dirs []string
+	for _, launcher := range launcherDataDirs {
+		for _, root := range roots {
+			dir := filepath.Join(root, launcher, "instances")
+			if info, err := os.Stat(dir); err == nil && info.IsDir() {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs
+}
+
+// ModsDirCandidates returns the mods directories of the minecraft installs
+// found on this machine: the official launcher's and every Prism Launcher
+// or MultiMC instance. Only installs whose minecraft directory exists are
+// returned; their mods directory may still be missing.
+func ModsDirCandidates() []string {
+	var candidates []string
+	if info, err := os.Stat(DefaultMinecraftDir()); err == nil && info.IsDir() {
+		candidates = append(candidates, filepath.Join(DefaultMinecraftDir(), "mods"))
+	}
+
+	for _, instancesDir := range InstancesDirs() {
+		instances, err := os.ReadDir(instancesDir)
+		if err != nil {
+			continue
+		}
+		for _, instance := range instances {
+			for _, name := range []string{".minecraft", "minecraft"} {
+				dir := filepath.Join(instancesDir, instance.Name(), name)
+				if info, err := os.Stat(dir); err == nil && info.IsDir() {
+					candidates = append(candidates, filepath.Join(dir, "mods"))
+				}
+			}
+		}
+	}
+	return candidates
+}
+
+// ResolveMissingModsDir is used when the configured mods directory is gone,
+// usually because the instance was deleted. It lets the user pick one of
+// the installs found, enter another directory or, only when asked to,
+// create the configured one. Without a minecraft directory to put it in
+// the game was never set up here, which is explained first. There is no
+// default choice.
+func ResolveMissingModsDir(prompter *Prompter, status ModsDirStatus) (string, error) {
+	if modsDir, ok := offerGameModsDir(prompter, status); ok {
+		return modsDir, nil
+	}
+	canCreate := status.Err == nil && !status.Exists
+	if canCreate {
+		fmt.Println(T("modsdir.missing", status.Path))
+	} else if _, err := os.Stat(filepath.Dir(status.Path)); os.IsNotExist(err) {
+		fmt.Println(T("modsdir.fresh", filepath.Dir(status.Path)))
+	}
+
+	var candidates []string
+	for _, candidate := range ModsDirCandidates() {
+		if candidate != status.Path {
+			candidates = append(candidates, candidate)
+		}
+	}
+	for attempt := 0; attempt < pickAttempts; attempt++ {
+		fmt.Println(T("modsdir.choose"))
+		for i, candidate := range candidates {
+			fmt.Printf("  %d) %s\n", i+1, candidate)
+		}
+		if canCreate {
+			fmt.Println(T("modsdir.choose.create", status.Path))
+		}
+		fmt.Println(T("modsdir.choose.other"))
+		input, err := prompter.Line("  > ")
+		if err != nil {
+			return "", err
+		}
+		input = strings.ToLower(input)
+
+		if n, err := strconv.Atoi(input); err == nil && n >= 1 && n <= len(candidates) {
+			return candidates[n-1], nil
+		}
+		switch {
+		case input == "c" && canCreate:
+			if err := os.MkdirAll(status.Path, dirPerm); err != nil {
+				return "", err
+			}
+			Logf("created mods directory %s on request", status.Path)
+			return status.Path, nil
+		case input == "o":
+			return PickModsDir(prompter)
+		}
+	}
+	return "", fmt.Errorf("no usable directory chosen")
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCheckModsDirDeletedInstance removes an instance's mods directory and
+// then the instance itself while the config still points there.
+func TestCheckModsDirDeletedInstance(t *testing.T) {
+	instance := filepath.Join(t.TempDir(), "instances", "pack")
+	mods := filepath.Join(instance, ".minecraft", "mods")
+	if err := os.MkdirAll(mods, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if status := CheckModsDir(mods); !status.Exists || !status.Writable || status.Err != nil {
+		t.Errorf("existing: %+v", status)
+	}
+
+	os.RemoveAll(mods)
+	// can be created once asked to
+	if status := CheckModsDir(mods); status.Exists || !status.Writable || status.Err != nil {
+		t.Errorf("mods deleted: %+v", status)
+	}
+
+	os.RemoveAll(instance)
+	if status := CheckModsDir(mods); status.Exists || status.Err == nil || !strings.Contains(status.Err.Error(), "nor its parent directory exist") {
+		t.Errorf("instance deleted: %+v", status)
+	}
+	if _, err := os.Stat(instance); !os.IsNotExist(err) {
+		t.Errorf("checking created the instance: %v", err)
+	}
+}
+
+// TestResolveMissingModsDir answers the picker offered for a configured
+// mods directory that is gone. The machine has the official launcher's
+// install and two Prism Launcher instances, pack and other.
+func TestResolveMissingModsDir(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		// instanceDeleted removes pack's minecraft directory too, not only
+		// its mods.
+		instanceDeleted bool
+		// chosen is the mods directory returned, none for an error. Here
+		// and in input and output {{MINECRAFT}} stands for the official
+		// launcher's minecraft directory, {{INSTANCES}} for Prism
+		// Launcher's instances.
+		chosen string
+		// output is what the output has to contain, lacking is what it
+		// must not.
+		output  []string
+		lacking []string
+	}{
+		{
+			name:   "candidate",
+			input:  "2\n",
+			chosen: "{{INSTANCES}}/other/.minecraft/mods",
+			output: []string{
+				"The configured mods directory",
+				"  1) {{MINECRAFT}}/mods\n",
+				"  2) {{INSTANCES}}/other/.minecraft/mods\n",
+				"  c) create {{INSTANCES}}/pack/.minecraft/mods\n",
+			},
+			// the configured directory isn't offered as a candidate
+			lacking: []string{"  3) "},
+		},
+		{
+			name:   "create",
+			input:  "c\n",
+			chosen: "{{INSTANCES}}/pack/.minecraft/mods",
+		},
+		{
+			name:   "other directory",
+			input:  "o\n{{INSTANCES}}/other/.minecraft/mods\n",
+			chosen: "{{INSTANCES}}/other/.minecraft/mods",
+		},
+		{
+			// nowhere to create it, the instance has to be set up first
+			name:            "fresh install",
+			input:           "c\n1\n",
+			instanceDeleted: true,
+			chosen:          "{{MINECRAFT}}/mods",
+			output:          []string{"There is no minecraft directory at {{INSTANCES}}/pack/.minecraft yet."},
+			lacking:         []string{"c) create", "The configured mods directory"},
+		},
+		{
+			name:  "nothing chosen",
+			input: "9\nx\n\n",
+		},
+		{
+			name:  "input ended",
+			input: "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dirs := useTempBaseDirs(t)
+			instances := filepath.Join(dirs.Config, "PrismLauncher", "instances")
+			expand := strings.NewReplacer("{{MINECRAFT}}", DefaultMinecraftDir(), "{{INSTANCES}}", instances, "/", string(filepath.Separator)).Replace
+			for _, dir := range []string{
+				filepath.Join(DefaultMinecraftDir(), "mods"),
+				filepath.Join(instances, "pack", ".minecraft", "mods"),
+				filepath.Join(instances, "other", ".minecraft", "mods"),
+			} {
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			configured := filepath.Join(instances, "pack", ".minecraft", "mods")
+			os.RemoveAll(configured)
+			if test.instanceDeleted {
+				os.RemoveAll(filepath.Join(instances, "pack"))
+			}
+
+			input := expand(test.input)
+			var chosen string
+			var err error
+			output := captureStdout(t, func() {
+				chosen, err = ResolveMissingModsDir(NewPrompter(strings.NewReader(input), false), CheckModsDir(configured))
+			})
+			if test.chosen == "" {
+				if err == nil {
+					t.Errorf("chose %s", chosen)
+				}
+			} else if want := expand(test.chosen); err != nil || chosen != want {
+				t.Errorf("chose %s, %v, want %s\noutput:\n%s", chosen, err, want, output)
+			}
+			for _, want := range test.output {
+				if want = expand(want); !strings.Contains(output, want) {
+					t.Errorf("output lacks %q:\n%s", want, output)
+				}
+			}
+			for _, unwanted := range test.lacking {
+				if strings.Contains(output, unwanted) {
+					t.Errorf("output has %q:\n%s", unwanted, output)
+				}
+			}
+			// the configured directory is only there when asked for
+			if _, statErr := os.Stat(configured); (statErr == nil) != (test.name == "create") {
+				t.Errorf("configured directory: %v", statErr)
+			}
+		})
+	}
+}
+
+func TestModsDirCandidates(t *testing.T) {
+	dirs := useTempBaseDirs(t)
+	if got := ModsDirCandidates(); len(got) != 0 {
+		t.Errorf("candidates on a machine without minecraft: %v", got)
+	}
+	writeFiles(t, dirs.Config, map[string]string{
+		"MultiMC/instances/a/minecraft/options.txt":  "",
+		"MultiMC/instances/b/.minecraft/options.txt": "",
+		// not an instance, nothing set up yet
+		"MultiMC/instances/c/instance.cfg":  "",
+		"MultiMC/instances/instgroups.json": "",
+	})
+	want := []string{
+		filepath.Join(dirs.Config, "MultiMC", "instances", "a", "minecraft", "mods"),
+		filepath.Join(dirs.Config, "MultiMC", "instances", "b", ".minecraft", "mods"),
+	}
+	if got := ModsDirCandidates(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("candidates %v", got)
+	}
+}
+
+// TestCheckSafeModsDirGameRoot gives a minecraft directory renamed to end
+// in "mods" as the mods directory, holding each of the markers in turn: it
+// is refused whatever its name, and emptying it is refused too.
+func TestCheckSafeModsDirGameRoot(t *testing.T) {
+	for _, marker := range gameRootMarkers {
+		t.Run(marker, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "minecraft-mods")
+			writeFiles(t, dir, map[string]string{"options.txt": "fov:1.0", "sodium.jar": "sodium"})
+			if strings.HasSuffix(marker, ".json") {
+				writeFiles(t, dir, map[string]string{marker: "{}"})
+			} else {
+				writeFiles(t, dir, map[string]string{marker + "/World/level.dat": "world"})
+			}
+			err := checkSafeModsDir(dir)
+			var root *gameRootError
+			if !errors.As(err, &root) || root.marker != marker || root.ModsDir() != filepath.Join(dir, "mods") {
+				t.Fatalf("checked: %v", err)
+			}
+			if status := CheckModsDir(dir); !errors.As(status.Err, &root) {
+				t.Errorf("status: %v", status.Err)
+			}
+
+			before := dirNames(t, dir)
+			err = BackupAndRemove(dir, filepath.Join(t.TempDir(), "backup"), &Journal{Path: filepath.Join(t.TempDir(), "journal.jsonl")}, nil, nil, nil)
+			if !errors.As(err, &root) {
+				t.Errorf("emptied: %v", err)
+			}
+			if got := dirNames(t, dir); got != before {
+				t.Errorf("left %s of %s", got, before)
+			}
+		})
+	}
+	// a mods directory of its own is fine
+	minecraft := t.TempDir()
+	writeFiles(t, minecraft, map[string]string{"saves/World/level.dat": "world", "versions/1.20.1/1.20.1.json": "{}", "mods/sodium.jar": "sodium"})
+	if err := checkSafeModsDir(filepath.Join(minecraft, "mods")); err != nil {
+		t.Errorf("its mods directory: %v", err)
+	}
+}
+
+// TestPickModsDirGameRoot enters a minecraft directory at the prompt: its
+// mods directory is offered instead, and taken unless declined.
+func TestPickModsDirGameRoot(t *testing.T) {
+	useTempBaseDirs(t)
+	minecraft := filepath.Join(t.TempDir(), ".minecraft")
+	writeFiles(t, minecraft, map[string]string{"saves/World/level.dat": "world", "versions/1.20.1/1.20.1.json": "{}"})
+	mods := filepath.Join(minecraft, "mods")
+	tests := []struct {
+		input  string
+		chosen string
+	}{
+		{input: minecraft + "\n\n", chosen: mods},
+		{input: minecraft + "\ny\n", chosen: mods},
+		// declined, the player enters it themselves
+		{input: minecraft + "\nn\n" + mods + "\n", chosen: mods},
+		{input: minecraft + "\nn\n"},
+	}
+	for _, test := range tests {
+		var chosen string
+		var err error
+		output := captureStdout(t, func() {
+			chosen, err = PickModsDir(NewPrompter(strings.NewReader(test.input), false))
+		})
+		if chosen != test.chosen || (err == nil) != (test.chosen != "") {
+			t.Errorf("%q chose %q, %v", test.input, chosen, err)
+		}
+		if !strings.Contains(output, minecraft+" is not a mods directory but a whole Minecraft directory (it holds saves)") {
+			t.Errorf("%q output:\n%s", test.input, output)
+		}
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Phases of an update, each with a "phase.<name>" message telling what the
+// updater was doing when it was stopped.
+const (
+	phaseStartup    = "startup"
+	phaseDownload   = "download"
+	phaseConfirm    = "confirm"
+	phaseLoader     = "loader"
+	phaseBackup     = "backup"
+	phaseSwap       = "swap"
+	phaseTransforms = "transforms"
+	phaseTargets    = "targets"
+	phaseFinish     = "finish"
+)
+
+// defaultUnattendedMaxDuration is how long a run without anyone watching
+// may take unless the config says otherwise. Interactive runs have no
+// limit unless given --max-duration.
+const defaultUnattendedMaxDuration = 30 * time.Minute
+
+// Exit codes of a run that was stopped: the ones timeout(1) and shells use.
+const (
+	exitTimedOut    = 124
+	exitInterrupted = 130
+)
+
+// Why a run was stopped.
+var (
+	errRunTimedOut    = errors.New("the run took longer than allowed")
+	errRunInterrupted = errors.New("the run was interrupted")
+)
+
+// runCtx is done once the run is stopped, by --max-duration or an
+// interrupt. Downloads and the loader installer are canceled with it.
+var runCtx, stopRunCtx = context.WithCancel(context.Background())
+
+// osExit ends the process, replaced when the updater is driven by a test.
+var osExit = os.Exit
+
+// exitCode is what a test's osExit panics with, unwinding the run instead
+// of ending the process. ReportCrash lets it through.
+type exitCode int
+
+// runTracker knows the phase the run is in and how many destructive steps,
+// which change a mods directory, are underway. A run stopped in between
+// them exits right away, otherwise the destructive steps roll back what
+// they changed and the last one to finish exits.
+type runTracker struct {
+	mu          sync.Mutex
+	phase       string
+	since       time.Time
+	destructive int
+	limit       time.Duration
+	stopped     error
+	// spent is the time spent in each phase left so far.
+	spent map[string]time.Duration
+	// targets are the further targets updated in phaseTargets, in the
+	// order they started.
+	targets []*targetTracker
+
+	// progress is how far the phase got, statusPath where it is published
+	// for the daemon, if anywhere.
+	progress   RunProgress
+	statusPath string
+	published  time.Time
+	// watch is told about every change of phase and progress.
+	watch func(phase string, progress RunProgress)
+}
+
+// targetTracker knows the phase a further target is in, and the time it
+// spent in each phase left so far.
+type targetTracker struct {
+	target string
+	phase  string
+	since  time.Time
+	done   bool
+	spent  map[string]time.Duration
+}
+
+// statusInterval is how often progress is published at most.
+const statusInterval = time.Second
+
+// RunStatus is what a run publishes about itself.
+type RunStatus struct {
+	Phase      string       `json:"phase"`
+	PhaseSince time.Time    `json:"phaseSince"`
+	Progress   *RunProgress `json:"progress,omitempty"`
+	// Targets are the phases of the further targets, in phaseTargets.
+	Targets []TargetStatus `json:"targets,omitempty"`
+}
+
+// TargetStatus is the phase a further target is in.
+type TargetStatus struct {
+	Target     string    `json:"target"`
+	Phase      string    `json:"phase"`
+	PhaseSince time.Time `json:"phaseSince"`
+	Done       bool      `json:"done,omitempty"`
+}
+
+// RunProgress counts the work of a phase, bytes or files.
+type RunProgress struct {
+	Done    int64   `json:"done"`
+	Total   int64   `json:"total"`
+	Percent float64 `json:"percent"`
+	// Files and FilesTotal count the files of a phase counting bytes,
+	// BytesPerSecond is how fast it goes.
+	Files          int64 `json:"files,omitempty"`
+	FilesTotal     int64 `json:"filesTotal,omitempty"`
+	BytesPerSecond int64 `json:"bytesPerSecond,omitempty"`
+}
+
+var runState = &runTracker{phase: phaseStartup, since: clock.Now(), spent: map[string]time.Duration{}}
+
+// SetPhase records that the run moved on to phase. A run stopped while a
+// destructive step was finishing exits now.
+func SetPhase(phase string) {
+	runState.mu.Lock()
+	defer runState.mu.Unlock()
+	now := clock.Now()
+	Logf("phase: %s (%s in %s)", phase, now.Sub(runState.since).Round(time.Millisecond), runState.phase)
+	runState.spent[runState.phase] += now.Sub(runState.since)
+	runState.phase, runState.since = phase, now
+	runState.progress = RunProgress{}
+	runState.publish(true)
+	runState.notify()
+	if runState.stopped != nil && runState.destructive == 0 {
+		runState.exit()
+	}
+}
+
+// SetTargetPhase records that the further target moved on to phase. Like
+// SetPhase, a run stopped while a destructive step was finishing exits now.
+func SetTargetPhase(target string, phase string) {
+	runState.mu.Lock()
+	defer runState.mu.Unlock()
+	now := clock.Now()
+	t := runState.target(target)
+	if t == nil {
+		t = &targetTracker{target: target, spent: map[string]time.Duration{}}
+		runState.targets = append(runState.targets, t)
+	} else {
+		t.spent[t.phase] += now.Sub(t.since)
+	}
+	Logf("target %s: phase %s", target, phase)
+	t.phase, t.since = phase, now
+	runState.publish(true)
+	if runState.stopped != nil && runState.destructive == 0 {
+		runState.exit()
+	}
+}
+
+// FinishTarget records that the further target is done.
+func FinishTarget(target string) {
+	runState.mu.Lock()
+	defer runState.mu.Unlock()
+	t := runState.target(target)
+	if t == nil || t.done {
+		return
+	}
+	t.spent[t.phase] += clock.Now().Sub(t.since)
+	t.done = true
+	runState.publish(true)
+}
+
+func (r *runTracker) target(target string) *targetTracker {
+	for _, t := range r.targets {
+		if t.target == target {
+			return t
+		}
+	}
+	return nil
+}
+
+// SetProgress records how much of the phase's work is done.
+func SetProgress(done int64, total int64) {
+	SetRunProgress(RunProgress{Done: done, Total: total})
+}
+
+// SetRunProgress records how far the phase got, progress.Percent is worked
+// out from Done and Total.
+func SetRunProgress(progress RunProgress) {
+	runState.mu.Lock()
+	defer runState.mu.Unlock()
+	if progress.Total > 0 {
+		progress.Percent = float64(progress.Done*1000/progress.Total) / 10
+	}
+	runState.progress = progress
+	runState.publish(progress.Done == progress.Total)
+	runState.notify()
+}
+
+// copyProgress reports the progress of files being copied by several
+// workers at once to report: the bytes done, or the files for files all
+// empty, the files done and the rate.
+type copyProgress struct {
+	mu         sync.Mutex
+	report     func(RunProgress)
+	started    time.Time
+	files      int64
+	filesTotal int64
+	done       int64
+	total      int64
+}
+
+func newCopyProgress(files int64, bytes int64, report func(RunProgress)) *copyProgress {
+	p := &copyProgress{report: report, started: clock.Now(), filesTotal: files, total: bytes}
+	p.update()
+	return p
+}
+
+// Add counts a file of size done.
+func (p *copyProgress) Add(size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.files++
+	p.done += size
+	p.update()
+}
+
+func (p *copyProgress) update() {
+	progress := RunProgress{Done: p.done, Total: p.total, Files: p.files, FilesTotal: p.filesTotal}
+	if p.total == 0 {
+		progress.Done, progress.Total = p.files, p.filesTotal
+	}
+	if elapsed := clock.Now().Sub(p.started).Seconds(); elapsed > 0 {
+		progress.BytesPerSecond = int64(float64(p.done) / elapsed)
+	}
+	p.report(progress)
+}
+
+// progressWriter reports the bytes written through it, on top of done, as
+// progress towards total. A total of 0 means the size isn't known.
+type progressWriter struct {
+	done  int64
+	total int64
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.done += int64(len(p))
+	SetProgress(w.done, w.total)
+	return len(p), nil
+}
+
+// PublishStatus writes the phase and progress of the run to p whenever they
+// change, at most every statusInterval for progress.
+func PublishStatus(p string) {
+	runState.mu.Lock()
+	defer runState.mu.Unlock()
+	runState.statusPath = p
+	runState.publish(true)
+}
+
+// WatchProgress has watch called with every change of phase and progress.
+func WatchProgress(watch func(phase string, progress RunProgress)) {
+	runState.mu.Lock()
+	defer runState.mu.Unlock()
+	runState.watch = watch
+}
+
+func (r *runTracker) notify() {
+	if r.watch != nil {
+		r.watch(r.phase, r.progress)
+	}
+}
+
+func (r *runTracker) publish(force bool) {
+	if r.statusPath == "" || (!force && clock.Now().Sub(r.published) < statusInterval) {
+		return
+	}
+	r.published = clock.Now()
+	status := RunStatus{Phase: r.phase, PhaseSince: r.since}
+	for _, t := range r.targets {
+		status.Targets = append(status.Targets, TargetStatus{Target: t.target, Phase: t.phase, PhaseSince: t.since, Done: t.done})
+	}
+	if r.progress.Total > 0 {
+		progress := r.progress
+		status.Progress = &progress
+	}
+	content, err := json.Marshal(status)
+	if err == nil {
+		err = writeAtomic(r.statusPath, content)
+	}
+	if err != nil {
+		Logf("publishing the status to %s: %s", r.statusPath, err)
+		r.statusPath = ""
+	}
+}
+
+// PhaseDurations returns the time the run spent in each phase so far.
+func PhaseDurations() map[string]time.Duration {
+	runState.mu.Lock()
+	defer runState.mu.Unlock()
+	return runState.phaseDurations()
+}
+
+func (r *runTracker) phaseDurations() map[string]time.Duration {
+	spent := map[string]time.Duration{}
+	for phase, d := range r.spent {
+		spent[phase] = d
+	}
+	spent[r.phase] += clock.Now().Sub(r.since)
+	return spent
+}
+
+// TargetPhaseDurations returns the time each further target spent in each
+// phase so far, in the order the targets started.
+func TargetPhaseDurations() []TargetPhases {
+	runState.mu.Lock()
+	defer runState.mu.Unlock()
+	return runState.targetPhaseDurations()
+}
+
+func (r *runTracker) targetPhaseDurations() []TargetPhases {
+	var durations []TargetPhases
+	for _, t := range r.targets {
+		spent := map[string]time.Duration{}
+		for phase, d := range t.spent {
+			spent[phase] = d
+		}
+		if !t.done {
+			spent[t.phase] += clock.Now().Sub(t.since)
+		}
+		durations = append(durations, TargetPhases{Target: t.target, Phases: spent})
+	}
+	return durations
+}
+
+// logTargets logs the phase of the further targets not done yet.
+func (r *runTracker) logTargets() {
+	for _, t := range r.targets {
+		if !t.done {
+			Logf("target %s: stopped while %s (%s)", t.target, t.phase, clock.Now().Sub(t.since).Round(time.Second))
+		}
+	}
+}
+
+// phaseText tells what the run was doing, and in phaseTargets what each
+// further target not done yet was doing.
+func (r *runTracker) phaseText() string {
+	text := T("phase." + r.phase)
+	if r.phase != phaseTargets {
+		return text
+	}
+	var doing []string
+	for _, t := range r.targets {
+		if !t.done {
+			doing = append(doing, targetName(t.target)+": "+T("phase."+t.phase))
+		}
+	}
+	if len(doing) == 0 {
+		return text
+	}
+	return T("phase.targets.detail", strings.Join(doing, ", "))
+}
+
+// CurrentPhase returns the phase the run is in.
+func CurrentPhase() string {
+	runState.mu.Lock()
+	defer runState.mu.Unlock()
+	return runState.phase
+}
+
+// BeginDestructive marks the start of a step that changes a mods
+// directory, which must call the returned function once done. A run
+// already stopped exits instead of starting it.
+func BeginDestructive() (end func()) {
+	runState.mu.Lock()
+	defer runState.mu.Unlock()
+	if runState.stopped != nil {
+		runState.exit()
+	}
+	runState.destructive++
+	return func() {
+		runState.mu.Lock()
+		defer runState.mu.Unlock()
+		runState.destructive--
+		if runState.stopped != nil && runState.destructive == 0 {
+			runState.exit()
+		}
+	}
+}
+
+// StartRunLimits stops the run once it took longer than limit, 0 meaning
+// no limit, or when it is interrupted with Ctrl-C or by the system.
+func StartRunLimits(limit time.Duration) {
+	runState.mu.Lock()
+	runState.limit = limit
+	runState.mu.Unlock()
+	if limit > 0 {
+		Logf("the run may take %s", limit)
+		clock.AfterFunc(limit, func() { stopRun(errRunTimedOut) })
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		Logf("received %s", sig)
+		stopRun(errRunInterrupted)
+	}()
+}
+
+// stopRun cancels runCtx and exits, unless destructive steps are underway,
+// which then roll back and exit when done.
+func stopRun(reason error) {
+	runState.mu.Lock()
+	defer runState.mu.Unlock()
+	if runState.stopped != nil {
+		return
+	}
+	runState.stopped = reason
+	stopRunCtx()
+	if runState.destructive == 0 {
+		runState.exit()
+	} else {
+		Logf("%s while %s, rolling back", reason, runState.phase)
+		fmt.Println(T("run.rollback"))
+	}
+}
+
+// RunStopped returns why the run was stopped, nil while it wasn't.
+func RunStopped() error {
+	runState.mu.Lock()
+	defer runState.mu.Unlock()
+	return runState.stopped
+}
+
+// exit ends a stopped run, naming the phase it was in. The lock is held so
+// no destructive step starts meanwhile.
+func (r *runTracker) exit() {
+	phase := r.phaseText()
+	if r.stopped == errRunTimedOut {
+		Logf("fatal: timed out after %s while %s (%s)", r.limit, r.phase, clock.Now().Sub(r.since).Round(time.Second))
+		r.logTargets()
+		fmt.Println(T("run.timeout", r.limit, phase))
+		Notify(T("notify.failed", T("run.timeout", r.limit, phase)))
+		RecordFailure(categoryTimeout, r.phase, T("run.timeout", r.limit, phase))
+		writeRunMetrics(exitTimedOut, r.phaseDurations(), r.targetPhaseDurations())
+		writeEnvelope(exitTimedOut, categoryTimeout, T("run.timeout", r.limit, phase), r.phase)
+		osExit(exitTimedOut)
+	}
+	Logf("fatal: interrupted while %s", r.phase)
+	r.logTargets()
+	fmt.Println(T("run.interrupted", phase))
+	writeRunMetrics(exitInterrupted, r.phaseDurations(), r.targetPhaseDurations())
+	writeEnvelope(exitInterrupted, categoryInterrupted, T("run.interrupted", phase), r.phase)
+	osExit(exitInterrupted)
+}
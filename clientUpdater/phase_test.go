@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingNotifier keeps the notifications shown instead of showing them.
+type recordingNotifier struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (n *recordingNotifier) Notify(message string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.messages = append(n.messages, message)
+}
+
+func (n *recordingNotifier) Messages() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.messages...)
+}
+
+// useRecordingNotifier records the notifications of the test.
+func useRecordingNotifier(t *testing.T) *recordingNotifier {
+	t.Helper()
+	recording := &recordingNotifier{}
+	saved := notifier
+	notifier = recording
+	t.Cleanup(func() { notifier = saved })
+	return recording
+}
+
+// useRunState gives the test a run of its own, starting now on the clock.
+// Exiting panics with the exitCode, see exitsWith.
+func useRunState(t *testing.T) {
+	t.Helper()
+	savedState, savedCtx, savedStop, savedExit := runState, runCtx, stopRunCtx, osExit
+	runState = &runTracker{phase: phaseStartup, since: clock.Now(), spent: map[string]time.Duration{}}
+	runCtx, stopRunCtx = context.WithCancel(context.Background())
+	osExit = func(code int) { panic(exitCode(code)) }
+	t.Cleanup(func() {
+		stopRunCtx()
+		runState, runCtx, stopRunCtx, osExit = savedState, savedCtx, savedStop, savedExit
+	})
+}
+
+// exitsWith runs f, returning the code the updater exited with meanwhile,
+// -1 when it didn't.
+func exitsWith(f func()) (code int) {
+	code = -1
+	defer func() {
+		if r := recover(); r != nil {
+			exited, ok := r.(exitCode)
+			if !ok {
+				panic(r)
+			}
+			code = int(exited)
+		}
+	}()
+	f()
+	return code
+}
+
+// TestRunTimesOutInEachPhase times out a pipeline going through the phases
+// of a run in every one of them. The swap changes the mods directory, it
+// is rolled back before the run exits.
+func TestRunTimesOutInEachPhase(t *testing.T) {
+	const limit = time.Minute
+	for i, phase := range phaseOrder {
+		t.Run(phase, func(t *testing.T) {
+			fake := useFakeClock(t)
+			useRunState(t)
+			notifications := useRecordingNotifier(t)
+			StartRunLimits(limit)
+			for _, next := range phaseOrder[1 : i+1] {
+				if code := exitsWith(func() { SetPhase(next) }); code != -1 {
+					t.Fatalf("exited with %d moving on to %s", code, next)
+				}
+				fake.Advance(time.Second)
+			}
+
+			if phase == phaseSwap {
+				end := BeginDestructive()
+				if code := exitsWith(func() { fake.Advance(limit) }); code != -1 {
+					t.Fatalf("exited with %d in the middle of the swap", code)
+				}
+				if runCtx.Err() == nil || RunStopped() != errRunTimedOut {
+					t.Fatalf("the run wasn't stopped: %v", RunStopped())
+				}
+				if code := exitsWith(end); code != exitTimedOut {
+					t.Fatalf("exited with %d once the swap was rolled back, want %d", code, exitTimedOut)
+				}
+			} else if code := exitsWith(func() { fake.Advance(limit) }); code != exitTimedOut {
+				t.Fatalf("exited with %d, want %d", code, exitTimedOut)
+			}
+
+			if got := CurrentPhase(); got != phase {
+				t.Errorf("timed out in %s", got)
+			}
+			want := T("notify.failed", T("run.timeout", limit, T("phase."+phase)))
+			if messages := notifications.Messages(); len(messages) != 1 || messages[0] != want {
+				t.Errorf("notifications %q, want %q", messages, want)
+			}
+		})
+	}
+}
+
+func TestRunInterruptedDuringSwap(t *testing.T) {
+	useFakeClock(t)
+	useRunState(t)
+	SetPhase(phaseSwap)
+	end := BeginDestructive()
+	if code := exitsWith(func() { stopRun(errRunInterrupted) }); code != -1 {
+		t.Fatalf("exited with %d in the middle of the swap", code)
+	}
+	// a second destructive step can't start, the run exits instead
+	if code := exitsWith(func() { BeginDestructive() }); code != exitInterrupted {
+		t.Fatalf("exited with %d starting another destructive step, want %d", code, exitInterrupted)
+	}
+	if code := exitsWith(end); code != exitInterrupted {
+		t.Fatalf("exited with %d once the swap was rolled back, want %d", code, exitInterrupted)
+	}
+}
+
+func TestRunWithoutLimit(t *testing.T) {
+	fake := useFakeClock(t)
+	useRunState(t)
+	StartRunLimits(0)
+	if code := exitsWith(func() { fake.Advance(24 * time.Hour) }); code != -1 {
+		t.Fatalf("exited with %d", code)
+	}
+	if err := RunStopped(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPhaseDurations(t *testing.T) {
+	fake := useFakeClock(t)
+	useRunState(t)
+	fake.Advance(time.Second)
+	SetPhase(phaseDownload)
+	fake.Advance(3 * time.Second)
+	SetPhase(phaseConfirm)
+	fake.Advance(2 * time.Second)
+	spent := PhaseDurations()
+	for phase, want := range map[string]time.Duration{phaseStartup: time.Second, phaseDownload: 3 * time.Second, phaseConfirm: 2 * time.Second} {
+		if spent[phase] != want {
+			t.Errorf("%s took %s, want %s", phase, spent[phase], want)
+		}
+	}
+}
+
+// TestTargetPhases has two further targets go through their phases: the
+// status, a timeout and the metrics name the phase of each.
+func TestTargetPhases(t *testing.T) {
+	fake := useFakeClock(t)
+	useRunState(t)
+	notifications := useRecordingNotifier(t)
+	dir := t.TempDir()
+	statusPath := filepath.Join(dir, "status.json")
+	PublishStatus(statusPath)
+	EnableMetrics(filepath.Join(dir, "updater.prom"))
+	defer EnableMetrics("")
+	survival := filepath.Join(dir, "Survival", ".minecraft", "mods")
+	creative := filepath.Join(dir, "Creative", ".minecraft", "mods")
+
+	SetPhase(phaseTargets)
+	StartRunLimits(time.Minute)
+	SetTargetPhase(survival, phaseLoader)
+	SetTargetPhase(creative, phaseLoader)
+	fake.Advance(2 * time.Second)
+	SetTargetPhase(survival, phaseSwap)
+	fake.Advance(3 * time.Second)
+	SetTargetPhase(creative, phaseSwap)
+	fake.Advance(time.Second)
+	FinishTarget(survival)
+	SetTargetPhase(creative, phaseTransforms)
+
+	var status RunStatus
+	if err := json.Unmarshal([]byte(readFile(t, statusPath)), &status); err != nil {
+		t.Fatal(err)
+	}
+	want := []TargetStatus{
+		{Target: survival, Phase: phaseSwap, PhaseSince: fake.Now().Add(-4 * time.Second), Done: true},
+		{Target: creative, Phase: phaseTransforms, PhaseSince: fake.Now()},
+	}
+	if status.Phase != phaseTargets || len(status.Targets) != 2 {
+		t.Fatalf("status %+v", status)
+	}
+	for i := range want {
+		if got := status.Targets[i]; got.Target != want[i].Target || got.Phase != want[i].Phase || !got.PhaseSince.Equal(want[i].PhaseSince) || got.Done != want[i].Done {
+			t.Errorf("status of target %d is %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	if code := exitsWith(func() { fake.Advance(time.Minute) }); code != exitTimedOut {
+		t.Fatalf("exited with %d, want %d", code, exitTimedOut)
+	}
+	// the target done isn't named
+	timeout := T("run.timeout", time.Minute, T("phase.targets.detail", "Creative: "+T("phase.transforms")))
+	if messages := notifications.Messages(); len(messages) != 1 || messages[0] != T("notify.failed", timeout) {
+		t.Errorf("notifications %q, want one of %q", messages, timeout)
+	}
+	metrics := readFile(t, filepath.Join(dir, "updater.prom"))
+	for _, sample := range []string{
+		fmt.Sprintf(`%s{target="%s",phase="loader"} 2`, metricLastRunTargetPhaseDuration, escapeMetricText(survival, true)),
+		fmt.Sprintf(`%s{target="%s",phase="swap"} 4`, metricLastRunTargetPhaseDuration, escapeMetricText(survival, true)),
+		fmt.Sprintf(`%s{target="%s",phase="loader"} 5`, metricLastRunTargetPhaseDuration, escapeMetricText(creative, true)),
+		fmt.Sprintf(`%s{target="%s",phase="swap"} 1`, metricLastRunTargetPhaseDuration, escapeMetricText(creative, true)),
+		fmt.Sprintf(`%s{target="%s",phase="transforms"} 60`, metricLastRunTargetPhaseDuration, escapeMetricText(creative, true)),
+	} {
+		if !strings.Contains(metrics, sample+"\n") {
+			t.Errorf("no %s in the metrics:\n%s", sample, metrics)
+		}
+	}
+}
@@ -0,0 +1,804 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// zipMagic is the signature every zip archive starts with.
+var zipMagic = []byte("PK\x03\x04")
+
+// commitPattern matches a full git commit hash.
+var commitPattern = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// PackArchive is a downloaded pack archive that passed validation.
+type PackArchive struct {
+	Path     string
+	Download *Download
+	// ModFolder is the pack folder matching the Minecraft version, empty for
+	// the legacy layout. NewerVersion is set when the pack supports a newer
+	// Minecraft version than the one requested. MCVersion is the version
+	// the folder is for, the pack's newest when none was requested.
+	ModFolder    string
+	NewerVersion string
+	MCVersion    string
+	ModEntries   int
+	// Manifest is nil when the pack has none.
+	Manifest *PackManifest
+	// Commit is the pack repository commit the archive was built from,
+	// which GitHub stores as the zip comment. Empty for other archives.
+	Commit string
+	// Sources names the source each mod file came from when several were
+	// merged, nil for a single pack. SourceURLs are the urls the sources
+	// were downloaded from, by name.
+	Sources    map[string]string
+	SourceURLs map[string]string
+}
+
+// Label names the release of the pack in the archive, see packLabel.
+func (a *PackArchive) Label() string {
+	if a.Manifest != nil {
+		return packLabel(a.Manifest.Version, a.Commit)
+	}
+	return packLabel("", a.Commit)
+}
+
+// corruptArchiveError means the downloaded file is not a usable zip, as
+// opposed to a valid pack that doesn't fit our setup.
+type corruptArchiveError struct {
+	reason string
+}
+
+func (e *corruptArchiveError) Error() string {
+	return "the downloaded archive is damaged: " + e.reason
+}
+
+// ValidateArchive checks the archive at src is a readable zip containing mods
+// for mcVersion, or for the newest version the pack supports when
+// mcVersion is empty.
+func ValidateArchive(src string, mcVersion string) (*PackArchive, error) {
+	header := make([]byte, len(zipMagic))
+	file, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadFull(file, header)
+	file.Close()
+	if err != nil || !bytes.Equal(header, zipMagic) {
+		return nil, &corruptArchiveError{reason: "not a zip file"}
+	}
+
+	if err := checkEntryCount(src); err != nil {
+		return nil, err
+	}
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return nil, &corruptArchiveError{reason: err.Error()}
+	}
+	defer r.Close()
+	if err := NormalizeEntryNames(&r.Reader); err != nil {
+		return nil, err
+	}
+
+	manifest, err := ReadPackManifest(&r.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if manifest != nil {
+		if err := CheckUpdaterVersion(manifest.MinUpdaterVersion); err != nil {
+			return nil, err
+		}
+	}
+	versions := PackVersions(&r.Reader, manifest)
+	if mcVersion == "" {
+		mcVersion = newestMCVersion(versions)
+	}
+	folder, newer, err := SelectModFolder(versions, mcVersion)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStrayJars(&r.Reader, manifest, folder, versions); err != nil {
+		return nil, err
+	}
+	if err := checkJunk(&r.Reader); err != nil {
+		return nil, err
+	}
+	if manifest != nil {
+		for _, ignored := range manifest.Ignored {
+			Logf("pack: not installing %s, it is %s", ignored.Path, ignored.Reason)
+		}
+	}
+
+	archive := &PackArchive{Path: src, ModFolder: folder, NewerVersion: newer, MCVersion: mcVersion, Manifest: manifest}
+	if commitPattern.MatchString(r.Comment) {
+		archive.Commit = r.Comment
+	}
+	for _, f := range r.File {
+		if !f.FileInfo().IsDir() && isModEntry(f.Name, folder) {
+			archive.ModEntries++
+		}
+	}
+	if archive.ModEntries == 0 {
+		return nil, &corruptArchiveError{reason: "it does not contain any mods"}
+	}
+	return archive, nil
+}
+
+// FetchPack downloads and validates the pack archive. A damaged download is
+// thrown away and fetched once more before giving up.
+func FetchPack(dest string, urls []string, expectedSHA256 string, mcVersion string) (*PackArchive, error) {
+	var corrupt *corruptArchiveError
+	for attempt := 1; ; attempt++ {
+		download, err := DownloadFromSources(dest, urls, expectedSHA256)
+		if err != nil {
+			return nil, err
+		}
+		archive, err := ValidateArchive(dest, mcVersion)
+		if err == nil {
+			archive.Download = download
+			return archive, nil
+		}
+		os.Remove(dest)
+		if !errors.As(err, &corrupt) {
+			return nil, err
+		}
+		Logf("validate: %s (received %d bytes, server announced %d)", err, download.Size, download.ContentLength)
+		if attempt == 2 {
+			announced := "unknown"
+			if download.ContentLength >= 0 {
+				announced = fmt.Sprintf("%d", download.ContentLength)
+			}
+			return nil, fmt.Errorf("%w (received %d bytes, server announced %s)", err, download.Size, announced)
+		}
+		fmt.Println(T("download.retry"))
+	}
+}
+
+// LocalPack validates a pack archive the player already has, e.g. one
+// dropped onto the updater, so it is installed without downloading
+// anything. Its Download only records where it came from.
+func LocalPack(src string, mcVersion string) (*PackArchive, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := ValidateArchive(src, mcVersion)
+	if err != nil {
+		return nil, err
+	}
+	archive.Download = &Download{URL: src, Size: info.Size(), ContentLength: info.Size()}
+	return archive, nil
+}
+
+// UpdatePlan is everything an update is going to do. It is only built from
+// an archive that already passed validation, so nothing is removed from disk
+// before we know the new mods can be put in place.
+type UpdatePlan struct {
+	Archive       *PackArchive
+	MCVersion     string
+	ModPath       string
+	MinecraftPath string
+	// GameDir is the directory the game runs in, the parent of ModPath,
+	// which transforms install below. It is MinecraftPath unless the
+	// launcher keeps the game's versions further up, see
+	// ResolveMinecraftRoot.
+	GameDir       string
+	Loader        Loader
+	InstallLoader bool
+	// LoaderVersion pins the loader release to install, empty meaning the
+	// latest one.
+	LoaderVersion string
+	// Journal records every file the update removes or adds, removed files
+	// are kept in BackupDir. RecoveryDir holds the marker of a swap in
+	// progress.
+	Journal     *Journal
+	BackupDir   string
+	RecoveryDir string
+	// Prepared is the preparation done for ModPath, if any.
+	Prepared *Preparation
+	// TemplateValues fill in templates, Prompt asks for missing ones and is
+	// nil when nobody can answer.
+	TemplateValues map[string]string
+	Prompt         func(name string) (string, error)
+	// WarnModFiles and WarnModsMB are the limits above which extracting a
+	// pack that doesn't declare its contents warns, 0 for the defaults.
+	WarnModFiles int
+	WarnModsMB   int
+	// BootstrapVanilla downloads the vanilla version from Mojang before the
+	// loader is installed, for minecraft directories never launched.
+	BootstrapVanilla bool
+	// LowWrite leaves files already matching the pack in place instead of
+	// backing them up and writing them again.
+	LowWrite bool
+	// NoBackup deletes the mods replaced instead of backing them up, with
+	// --no-backup. The run can't be rolled back.
+	NoBackup bool
+	// ConfigOnly leaves the mods and the loader alone and only installs
+	// the transformed files, see DetectConfigOnly.
+	ConfigOnly bool
+	// SkipConfigs is the opposite: the mods are updated, the transformed
+	// files outside the mods directory left alone, see --skip-configs.
+	SkipConfigs bool
+	// PreviousLayout is the mods layout of the last update, the files of
+	// another layout than the pack's are moved over.
+	PreviousLayout string
+	// Cache serves the metadata installing the vanilla version looks up.
+	Cache *Cache
+	// Target identifies the plan among the targets updated concurrently,
+	// whose phase and progress go to Mux instead of being the run's.
+	Target string
+	Mux    *ProgressMux
+	// Out is where the plan prints, standard output when nil.
+	Out io.Writer
+	// ApplyRecommended overwrites the player's settings with the pack's
+	// recommended ones, see --apply-recommended-settings.
+	ApplyRecommended bool
+	// Guard holds the update lock of the minecraft directory, checked
+	// before each phase changing it, nil when nothing is watched.
+	Guard *SyncGuard
+
+	// CriticalChecks, Policies and Protected are filled in by Execute,
+	// and ExternalFailed, the external mods that couldn't be downloaded.
+	CriticalChecks []CriticalCheck
+	Policies       ModPolicies
+	Protected      []ProtectedFile
+	ExternalFailed []string
+}
+
+// setPhase records the phase the plan moved on to, the run's or, for a
+// target, its own.
+func (p *UpdatePlan) setPhase(phase string) {
+	if p.Mux != nil {
+		p.Mux.Phase(p.Target, phase)
+		SetTargetPhase(p.Target, phase)
+		return
+	}
+	SetPhase(phase)
+}
+
+// out returns where the plan prints.
+func (p *UpdatePlan) out() io.Writer {
+	if p.Out != nil {
+		return p.Out
+	}
+	return os.Stdout
+}
+
+// setProgress records how far the plan got in its phase.
+func (p *UpdatePlan) setProgress(progress RunProgress) {
+	if p.Mux != nil {
+		p.Mux.Progress(p.Target, progress)
+		return
+	}
+	SetRunProgress(progress)
+}
+
+// Execute carries out the plan. The archive is left in place, other
+// targets may still need it.
+func (p *UpdatePlan) Execute() error {
+	if p.ConfigOnly {
+		return p.executeConfigOnly()
+	}
+	p.setPhase(phaseLoader)
+	if p.BootstrapVanilla {
+		fmt.Fprintln(p.out(), T("vanilla.bootstrap", p.MCVersion))
+		if err := BootstrapVanilla(p.Cache, p.MinecraftPath, p.MCVersion, vanillaManifestURL); err != nil {
+			Logf("vanilla bootstrap failed: %s", err)
+			fmt.Fprintln(p.out(), T("vanilla.failed", err))
+		}
+	}
+	if p.InstallLoader {
+		p.installLoader()
+	} else {
+		fmt.Fprintln(p.out(), T("loader.present", p.Loader.Name()))
+	}
+
+	// the pack is extracted next to the mods directory first, the installed
+	// mods are only replaced once it looks right
+	staging := p.ModPath + stagingSuffix
+	archiveSum, err := fileSHA256(p.Archive.Path)
+	if err != nil {
+		return err
+	}
+	// a swap of the same archive that was interrupted is completed
+	// instead of extracting again
+	staged, swapped, resumed := resumeSwap(staging, p.ModPath, archiveSum)
+	if !resumed {
+		if err := removeRetrying(staging); err != nil {
+			return err
+		}
+	}
+	unchanged := map[string]bool{}
+	if p.LowWrite && p.Prepared != nil {
+		var err error
+		if _, unchanged, err = unchangedEntries(p.Archive, p.ModPath, p.Prepared); err != nil {
+			return err
+		}
+		Logf("low-write: %d files already match the pack", len(unchanged))
+	}
+	if !resumed {
+		if err := checkNameCollisions(p.Archive, p.ModPath); err != nil {
+			return err
+		}
+		if staged, err = p.stage(staging, unchanged, archiveSum); err != nil {
+			return err
+		}
+	}
+	// files left in place take the pack's name, also where it only
+	// changed case
+	var kept []string
+	for name := range unchanged {
+		kept = append(kept, name)
+	}
+	if err := fixNameCase(p.ModPath, kept, p.Journal); err != nil {
+		return err
+	}
+
+	// the player's own files stay as they are, even where the pack has a
+	// file of the same name
+	p.Protected, err = ScanProtected(p.ModPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// the mods the pack can't ship are kept where they are found, and
+	// downloaded where they can be
+	external := p.ExternalMods()
+	p.Protected = append(p.Protected, externalProtected(p.ModPath, external)...)
+	staged = append(staged, p.fetchExternal(staging, external)...)
+	if err := DiskFull(); err != nil {
+		RemoveTemporary(staging)
+		return err
+	}
+	protected := protectedPaths(p.ModPath, p.Protected)
+	for _, file := range p.Protected {
+		Logf("protected %s (%s)", file.Name, file.Reason)
+	}
+
+	keep := map[string]bool{}
+	skip := map[string]bool{}
+	for _, file := range p.Protected {
+		if file.Reason == protectedExternal {
+			keep[filepath.Join(p.ModPath, file.Name)] = true
+		}
+	}
+	for name := range unchanged {
+		keep[filepath.Join(p.ModPath, name)] = true
+	}
+	for _, name := range swapped {
+		keep[filepath.Join(p.ModPath, name)] = true
+	}
+	if p.Archive.Manifest != nil && len(p.Archive.Manifest.ModPolicies) > 0 {
+		if err := p.resolvePolicies(); err != nil {
+			return err
+		}
+		for _, mod := range p.Policies.Kept {
+			keep[filepath.Join(p.ModPath, mod.File)] = true
+			skip[mod.PackFile] = true
+		}
+	}
+
+	if err := p.Guard.Check(); err != nil {
+		return err
+	}
+	began := clock.Now()
+	p.setPhase(phaseSwap)
+	if err := p.swap(staging, archiveSum, staged, keep, skip, protected); err != nil {
+		return err
+	}
+	fmt.Fprintln(p.out(), T("mods.loaded"))
+	if p.layoutChanged() {
+		Logf("layout: changed from %s to %s", p.PreviousLayout, p.Archive.Manifest.layout())
+		if err := p.finishLayoutChange(); err != nil {
+			return err
+		}
+	}
+	if foreign, err := CheckModMetadata(p.Loader, p.ModPath); err == nil && len(foreign) > 0 {
+		for _, jar := range foreign {
+			Warn(warnMetadata, T("mods.foreign", jar, p.Loader.Name()))
+		}
+		Logf("mods without %s: %s", p.Loader.MetadataFile(), strings.Join(foreign, ", "))
+	}
+	p.CriticalChecks, err = p.checkCriticalMods()
+	if err != nil {
+		return err
+	}
+
+	if p.SkipConfigs {
+		p.skipConfigs()
+	} else if p.Archive.Manifest != nil && len(p.Archive.Manifest.Transforms) > 0 {
+		if err := p.Guard.Check(); err != nil {
+			return err
+		}
+		p.setPhase(phaseTransforms)
+		written, err := ApplyTransforms(p.Archive.Path, p.Archive.Manifest.Transforms, p.GameDir, p.BackupDir, p.Journal, p.TemplateValues, p.Prompt, p.ApplyRecommended)
+		if isNoSpace(err) {
+			// half the pack's configs don't go with its mods, the whole
+			// update is put back
+			p.rollBackDiskFull(p.GameDir, began)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(p.out(), T("transforms.done", len(written)))
+	}
+
+	fmt.Fprintln(p.out(), T("done"))
+	return nil
+}
+
+// swap replaces the installed mods with the staged ones, except those in
+// keep, which stay, and in skip or protected, which aren't moved in. A run
+// stopped meanwhile puts back what the swap already changed. Until the
+// swap is complete or rolled back, a recovery marker tells the next run
+// that the mods directory may be half updated.
+func (p *UpdatePlan) swap(staging string, archiveSum string, staged []string, keep map[string]bool, skip map[string]bool, protected pathSet) (err error) {
+	end := BeginDestructive()
+	defer end()
+	began := clock.Now()
+	marker, err := writeRecoveryMarker(p.RecoveryDir, recoveryMarker{
+		Run:       p.Journal.Run,
+		Phase:     phaseSwap,
+		Started:   began,
+		ModPath:   p.ModPath,
+		Archive:   archiveSum,
+		BackupDir: p.BackupDir,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			removeRecoveryMarker(marker)
+			return
+		}
+		if RunStopped() == nil && !isNoSpace(err) {
+			return
+		}
+		if isNoSpace(err) {
+			RemoveTemporary(staging)
+			if p.rollBackDiskFull(p.ModPath, began) {
+				discardIncompleteBackup(p.BackupDir)
+				removeRecoveryMarker(marker)
+			}
+			return
+		}
+		if err := RollbackRun(p.Journal, p.ModPath, began); err != nil {
+			Logf("rollback of %s failed: %s", p.ModPath, err)
+			return
+		}
+		discardIncompleteBackup(p.BackupDir)
+		RemoveTemporary(staging)
+		removeRecoveryMarker(marker)
+	}()
+
+	if _, err := os.Stat(p.ModPath); err == nil {
+		fmt.Fprintln(p.out(), T("mods.removing"))
+		var known map[string]string
+		if p.Prepared != nil {
+			known = p.Prepared.Known()
+		}
+		backupDir := p.BackupDir
+		if p.NoBackup {
+			backupDir = ""
+			if err := p.Journal.Record(JournalEntry{Action: journalNoBackup, Path: p.ModPath}); err != nil {
+				return err
+			}
+			Logf("backup: none for this run, --no-backup")
+		}
+		p.setPhase(phaseBackup)
+		if err := BackupAndRemove(p.ModPath, backupDir, p.Journal, known, keep, p.setProgress); err != nil {
+			return err
+		}
+		p.setPhase(phaseSwap)
+		fmt.Fprintln(p.out(), T("mods.removed"))
+		if !p.NoBackup {
+			fmt.Fprintln(p.out(), T("mods.backup", p.BackupDir))
+		}
+	}
+	if err := os.MkdirAll(p.ModPath, dirPerm); err != nil {
+		return err
+	}
+
+	// while the layout changes, a protected file keeps the pack's file of
+	// the same name out wherever the new layout puts it
+	protectedNames := map[string]bool{}
+	if p.layoutChanged() {
+		for _, file := range p.Protected {
+			protectedNames[filepath.Base(file.Name)] = true
+		}
+	}
+	for i, src := range staged {
+		if runCtx.Err() != nil {
+			return RunStopped()
+		}
+		p.setProgress(RunProgress{Done: int64(i), Total: int64(len(staged))})
+		rel, err := filepath.Rel(staging, src)
+		if err != nil {
+			return err
+		}
+		file := filepath.Join(p.ModPath, rel)
+		if skip[filepath.Base(src)] || protected.Has(file) || protectedNames[filepath.Base(src)] {
+			continue
+		}
+		if err := moveFile(src, file); err != nil {
+			return err
+		}
+		sum, err := fileSHA256(file)
+		if err != nil {
+			return err
+		}
+		if err := p.Journal.Record(JournalEntry{Action: journalAdd, Path: file, SHA256: sum}); err != nil {
+			return err
+		}
+	}
+	p.setProgress(RunProgress{Done: int64(len(staged)), Total: int64(len(staged))})
+	RemoveTemporary(staging)
+	return nil
+}
+
+// rollBackDiskFull puts back what the run changed below dir since began,
+// after a write ran out of space. The space the update took is given back
+// with it. It reports whether the rollback succeeded; when it didn't, the
+// recovery marker tells the next run.
+func (p *UpdatePlan) rollBackDiskFull(dir string, began time.Time) bool {
+	if err := RollbackRun(p.Journal, dir, began); err != nil {
+		Logf("rollback of %s failed: %s", dir, err)
+		fmt.Fprintln(p.out(), T("diskfull.rollback.failed", err))
+		return false
+	}
+	fmt.Fprintln(p.out(), T("diskfull.rolledback"))
+	return true
+}
+
+// stage extracts the pack's mods except the unchanged ones into staging,
+// checks the result and marks it as ready to be swapped in.
+func (p *UpdatePlan) stage(staging string, unchanged map[string]bool, archiveSum string) ([]string, error) {
+	fmt.Fprintln(p.out(), T("mods.loading"))
+	report, err := p.Archive.ExtractMods(staging, func(rel string) bool {
+		return !unchanged[rel]
+	}, true)
+	var failed *ExtractFailedError
+	if errors.As(err, &failed) {
+		if err := p.acceptFailures(failed.Failed); err != nil {
+			RemoveTemporary(staging)
+			return nil, err
+		}
+	} else if err != nil {
+		if isNoSpace(err) {
+			RemoveTemporary(staging)
+		}
+		return nil, err
+	}
+	// a file cut off without an error still never makes it into the mods
+	if err := VerifyExtractedSizes(report); err != nil {
+		RemoveTemporary(staging)
+		return nil, err
+	}
+	staged := report.Paths
+	extracted := staged
+	for name := range unchanged {
+		extracted = append(extracted, filepath.Join(p.ModPath, filepath.FromSlash(name)))
+	}
+	check, err := CheckExtraction(p.Archive, extracted)
+	if err != nil {
+		return nil, err
+	}
+	// the entries left out were declared, but are already warned about
+	if check.Expected != nil {
+		for _, failure := range report.Failed {
+			check.Expected.Files--
+			check.Expected.Size -= failure.Size
+		}
+	}
+	Logf("extracted %d files, %d bytes, to %s", check.Files, check.Size, staging)
+	if check.Mismatch() {
+		fmt.Fprintln(p.out(), T("extract.mismatch", check.Expected.Files, megabytes(check.Expected.Size), check.Files, megabytes(check.Size)))
+		check.PrintExtras(p.out())
+		fmt.Fprintln(p.out(), T("extract.kept", staging))
+		return nil, fmt.Errorf("extracted %d files (%d bytes), the pack declares %d (%d bytes)", check.Files, check.Size, check.Expected.Files, check.Expected.Size)
+	}
+	if check.Expected == nil && check.ExceedsLimits(p.WarnModFiles, p.WarnModsMB) {
+		Warn(warnExtract, T("extract.large", check.Files, megabytes(check.Size)))
+		check.PrintExtras(p.out())
+		Logf("extraction exceeds the limits: %d files, %d bytes", check.Files, check.Size)
+	}
+
+	marker := swapMarker{Run: p.Journal.Run, Archive: archiveSum, Files: map[string]string{}}
+	for _, src := range staged {
+		rel, err := filepath.Rel(staging, src)
+		if err != nil {
+			return nil, err
+		}
+		if marker.Files[filepath.ToSlash(rel)], err = fileSHA256(src); err != nil {
+			return nil, err
+		}
+	}
+	return staged, writeSwapMarker(staging, marker)
+}
+
+// acceptFailures decides whether an update goes ahead without the pack's
+// entries that couldn't be extracted: it does, with a warning for each,
+// when the manifest tells none of them is a critical mod. Without a
+// manifest nothing tells, so it doesn't either.
+func (p *UpdatePlan) acceptFailures(failed []EntryFailure) error {
+	ids, _ := p.criticalModIDs()
+	critical := CriticalFailures(failed, ids)
+	if p.Archive.Manifest == nil || len(critical) > 0 {
+		fmt.Fprintln(p.out(), T("extract.failed", len(failed)))
+		PrintFailures(p.out(), failed)
+		if len(critical) > 0 {
+			fmt.Fprintln(p.out(), T("extract.failed.critical", len(critical)))
+		} else {
+			fmt.Fprintln(p.out(), T("extract.failed.nomanifest"))
+		}
+		return &ExtractFailedError{Failed: failed}
+	}
+	for _, failure := range failed {
+		Warn(warnExtract, T("extract.failed.skipped", failure.Placed, failure.Err))
+	}
+	return nil
+}
+
+// criticalModIDs returns the mods the pack can't run without: the
+// manifest's critical mods, else the loader's. fromManifest tells which.
+func (p *UpdatePlan) criticalModIDs() (ids []string, fromManifest bool) {
+	if p.Archive.Manifest != nil && len(p.Archive.Manifest.CriticalMods) > 0 {
+		return p.Archive.Manifest.CriticalMods, true
+	}
+	return p.Loader.CriticalMods(), false
+}
+
+// resolvePolicies works out which mods the manifest's policies keep or
+// restore in this mods directory.
+func (p *UpdatePlan) resolvePolicies() error {
+	policies := p.Archive.Manifest.ModPolicies
+	shipped, err := ShippedMods(p.Archive, policyIDs(policies))
+	if err != nil {
+		return err
+	}
+	installed, err := ScanMods(p.ModPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	p.Policies = ResolveModPolicies(policies, installed, shipped)
+	// another platform's variant of a mod, e.g. copied over with an
+	// instance, is replaced however new it is
+	kept := p.Policies.Kept[:0]
+	for _, mod := range p.Policies.Kept {
+		if p.Archive.Manifest.forPlatform(mod.File) {
+			kept = append(kept, mod)
+		}
+	}
+	p.Policies.Kept = kept
+	for _, mod := range p.Policies.Kept {
+		Logf("floating mod %s: keeping %s %s over the pack's %s", mod.ID, mod.File, mod.Version, mod.PackVersion)
+	}
+	for _, mod := range p.Policies.Restored {
+		Logf("pinned mod %s: replacing %s with the pack's %s", mod.ID, mod.Version, mod.PackVersion)
+	}
+	return nil
+}
+
+// installLoader installs the loader for the plan.
+func (p *UpdatePlan) installLoader() {
+	InstallLoader(p.out(), p.Loader, p.MinecraftPath, p.MCVersion, p.LoaderVersion)
+}
+
+// InstallLoader installs the loader once the official launcher is done
+// writing to the same directories, and re-installs once if the result turns
+// out to be broken, telling w how it goes. A failed loader install doesn't
+// stop the mod update.
+func InstallLoader(w io.Writer, loader Loader, minecraftPath string, mcVersion string, loaderVersion string) {
+	if busy := WaitForLauncher(minecraftPath); len(busy) > 0 {
+		Warn(warnLauncher, T("launcher.gaveup", loader.Name()))
+		Logf("%s install skipped, launcher busy: %s", loader.Name(), strings.Join(busy, "; "))
+		return
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		fmt.Fprintln(w, T("loader.install", loader.Name()))
+		err := loader.Install(minecraftPath, mcVersion, loaderVersion)
+		if err == nil {
+			err = VerifyLoaderInstall(loader, minecraftPath, mcVersion)
+		}
+		if err == nil {
+			fmt.Fprintln(w, T("loader.done"))
+			return
+		}
+		fmt.Fprintln(w, T("loader.failed", loader.Name(), err))
+		Logf("%s install attempt %d failed: %s", loader.Name(), attempt, err)
+	}
+}
+
+// checkCriticalMods verifies the critical mods made it into the mods
+// directory exactly once in the pack's version, moving stray duplicates
+// and other versions aside and re-extracting the pack's jar where it's
+// missing before checking again. The manifest's list overrides the
+// loader's defaults; defaults the pack doesn't ship are left out, while a
+// manifest listing a mod it doesn't ship gets it reported as missing.
+func (p *UpdatePlan) checkCriticalMods() ([]CriticalCheck, error) {
+	ids, fromManifest := p.criticalModIDs()
+	// a floating mod the player upgraded is meant to differ from the pack
+	var checked []string
+	for _, id := range ids {
+		kept := false
+		for _, mod := range p.Policies.Kept {
+			kept = kept || mod.ID == id
+		}
+		if !kept {
+			checked = append(checked, id)
+		}
+	}
+	ids = checked
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	shipped, err := ShippedMods(p.Archive, ids)
+	if err != nil {
+		return nil, err
+	}
+	if !fromManifest {
+		var present []string
+		for _, id := range ids {
+			if _, ok := shipped[id]; ok {
+				present = append(present, id)
+			}
+		}
+		ids = present
+	}
+	checks, err := CheckCriticalMods(p.ModPath, ids, shipped)
+	if err != nil {
+		return nil, err
+	}
+	protected := protectedPaths(p.ModPath, p.Protected)
+
+	repaired := false
+	for _, check := range checks {
+		mod, ok := shipped[check.ID]
+		if !ok {
+			continue
+		}
+		if check.State == criticalOK {
+			continue
+		}
+		repaired = true
+		present := containsString(check.Files, mod.File)
+		if check.State == criticalDuplicate || check.State == criticalMismatch {
+			Logf("critical mod %s: %s %s", check.ID, check.State, strings.Join(check.Files, ", "))
+			if present, err = RemoveDuplicateMods(p.ModPath, check.ID, mod, p.BackupDir, p.Journal); err != nil {
+				return nil, err
+			}
+		}
+		if !present && !protected.Has(filepath.Join(p.ModPath, mod.File)) {
+			Logf("critical mod %s: extracting %s again", check.ID, mod.File)
+			_, err := p.Archive.ExtractMods(p.ModPath, func(rel string) bool {
+				return path.Base(rel) == mod.File
+			}, false)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if repaired {
+		checks, err = CheckCriticalMods(p.ModPath, ids, shipped)
+	}
+	return checks, err
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
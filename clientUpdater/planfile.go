@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// planFileVersion is the version of the plan file format written by "plan"
+// and the only one "apply" accepts.
+const planFileVersion = 1
+
+// File actions of a plan.
+const (
+	// planAdd puts a pack file into the mods directory, replacing the
+	// installed file of that name if there is one.
+	planAdd = "add"
+	// planKeep is an installed file already identical to the pack's.
+	planKeep = "keep"
+	// planRemove moves an installed file the pack doesn't have to the
+	// backup.
+	planRemove = "remove"
+	// planWrite installs a pack file below the minecraft directory through
+	// its transform.
+	planWrite = "write"
+)
+
+// PlanFile is an update worked out by "plan --out", so it can be reviewed
+// and carried out later by "apply" exactly as it was planned. It is JSON,
+// version 1 being:
+//
+//	version        1
+//	created        when the plan was made
+//	source         where the pack archive came from
+//	archiveSHA256  the hash of that archive
+//	packVersion    the pack's release name, packCommit its commit
+//	mcVersion      the minecraft version updated for
+//	loader         the loader, installLoader whether it is installed
+//	modPath        the mods directory updated
+//	configOnly     whether only the transformed files are installed
+//	files          the file actions, see PlannedFile
+//
+// Apply works the plan out again against the pack and the mods directory
+// as they are then, and refuses to carry it out when anything differs.
+type PlanFile struct {
+	Version       int           `json:"version"`
+	Created       time.Time     `json:"created"`
+	Source        string        `json:"source"`
+	ArchiveSHA256 string        `json:"archiveSHA256"`
+	PackVersion   string        `json:"packVersion,omitempty"`
+	PackCommit    string        `json:"packCommit,omitempty"`
+	MCVersion     string        `json:"mcVersion"`
+	Loader        string        `json:"loader"`
+	InstallLoader bool          `json:"installLoader"`
+	ModPath       string        `json:"modPath"`
+	ConfigOnly    bool          `json:"configOnly,omitempty"`
+	Files         []PlannedFile `json:"files"`
+}
+
+// PlannedFile is what the update does to one file. Path is relative to
+// the mods directory, for writes to the minecraft directory.
+type PlannedFile struct {
+	Action string `json:"action"`
+	Path   string `json:"path"`
+	// SHA256 is the content of the file in the pack, for removals the
+	// installed content.
+	SHA256 string `json:"sha256"`
+	// Installed is the content of the file the action replaces, empty
+	// when there is none.
+	Installed string `json:"installed,omitempty"`
+}
+
+func (f PlannedFile) String() string {
+	s := fmt.Sprintf("%s %s (%s)", f.Action, f.Path, shortHash(f.SHA256))
+	if f.Installed != "" && f.Action != planKeep {
+		s += fmt.Sprintf(" over %s", shortHash(f.Installed))
+	}
+	return s
+}
+
+// shortHash abbreviates a hash for people.
+func shortHash(sum string) string {
+	if len(sum) > 12 {
+		return sum[:12]
+	}
+	return sum
+}
+
+// PlanFile works out every file action of the plan from the archive and
+// the preparation, reading but not changing anything. Protected files are
+// the player's and left out.
+func (p *UpdatePlan) PlanFile(source string) (*PlanFile, error) {
+	archiveSum, err := fileSHA256(p.Archive.Path)
+	if err != nil {
+		return nil, err
+	}
+	plan := &PlanFile{
+		Version:       planFileVersion,
+		Created:       clock.Now(),
+		Source:        source,
+		ArchiveSHA256: archiveSum,
+		PackCommit:    p.Archive.Commit,
+		MCVersion:     p.MCVersion,
+		Loader:        p.Loader.Name(),
+		InstallLoader: p.InstallLoader,
+		ModPath:       p.ModPath,
+		ConfigOnly:    p.ConfigOnly,
+	}
+	if p.Archive.Manifest != nil {
+		plan.PackVersion = p.Archive.Manifest.Version
+	}
+
+	protected, err := ScanProtected(p.ModPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	protected = append(protected, externalProtected(p.ModPath, p.ExternalMods())...)
+	paths := protectedPaths(p.ModPath, protected)
+	// the installed files by name, hashed as the plan gets to them
+	installed := map[string]string{}
+	if p.Prepared != nil {
+		for file := range p.Prepared.Sizes {
+			if rel, err := filepath.Rel(p.ModPath, file); err == nil && !paths.Has(file) {
+				installed[filepath.ToSlash(rel)] = file
+			}
+		}
+	}
+
+	r, err := OpenPackArchive(p.Archive.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	// configs skipped for the run aren't part of its plan either
+	var transforms map[string]string
+	if p.Archive.Manifest != nil && !p.SkipConfigs {
+		transforms = p.Archive.Manifest.Transforms
+	}
+	shipped := map[string]bool{}
+	for _, entry := range r.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		packPath := PackPath(entry.Name)
+		_, transformed := transforms[packPath]
+		isMod := p.Archive.IsModEntry(entry.Name)
+		if !transformed && (!isMod || p.ConfigOnly) {
+			continue
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.New()
+		_, err = io.Copy(hash, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		sum := hex.EncodeToString(hash.Sum(nil))
+		if transformed {
+			file := PlannedFile{Action: planWrite, Path: packPath, SHA256: sum}
+			file.Installed, _ = fileSHA256(filepath.Join(p.GameDir, filepath.FromSlash(packPath)))
+			plan.Files = append(plan.Files, file)
+			continue
+		}
+		name := p.Archive.ModRelPath(entry.Name)
+		if paths.Has(filepath.Join(p.ModPath, filepath.FromSlash(name))) {
+			continue
+		}
+		shipped[name] = true
+		file := PlannedFile{Action: planAdd, Path: name, SHA256: sum}
+		if installedPath, ok := installed[name]; ok {
+			file.Installed, _ = p.Prepared.Hash(installedPath)
+		}
+		if file.Installed == sum {
+			file.Action = planKeep
+		}
+		plan.Files = append(plan.Files, file)
+	}
+	if !p.ConfigOnly {
+		for rel, installedPath := range installed {
+			if shipped[rel] {
+				continue
+			}
+			if sum, err := p.Prepared.Hash(installedPath); err == nil {
+				plan.Files = append(plan.Files, PlannedFile{Action: planRemove, Path: rel, SHA256: sum})
+			}
+		}
+	}
+	sort.Slice(plan.Files, func(i, j int) bool {
+		if plan.Files[i].Action != plan.Files[j].Action {
+			return plan.Files[i].Action < plan.Files[j].Action
+		}
+		return plan.Files[i].Path < plan.Files[j].Path
+	})
+	return plan, nil
+}
+
+// WritePlanFile saves plan at p.
+func WritePlanFile(p string, plan *PlanFile) error {
+	content, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeAtomic(p, append(content, '\n'))
+}
+
+// ReadPlanFile reads the plan saved at p, refusing plans of another
+// format version.
+func ReadPlanFile(p string) (*PlanFile, error) {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var plan PlanFile
+	if err := json.Unmarshal(content, &plan); err != nil {
+		return nil, fmt.Errorf("%s is not a plan: %s", p, err)
+	}
+	if plan.Version != planFileVersion {
+		return nil, fmt.Errorf("%s is a plan of format version %d, this updater only applies version %d, make a new plan", p, plan.Version, planFileVersion)
+	}
+	return &plan, nil
+}
+
+// Drift lists what differs between the plan and now, the plan worked out
+// again for the pack and the mods directory as they are now. An empty list
+// means the plan can be carried out as reviewed.
+func (plan *PlanFile) Drift(now *PlanFile) []string {
+	var drift []string
+	field := func(name string, planned interface{}, current interface{}) {
+		if planned != current {
+			drift = append(drift, T("apply.drift.field", name, planned, current))
+		}
+	}
+	field("mcVersion", plan.MCVersion, now.MCVersion)
+	field("loader", plan.Loader, now.Loader)
+	field("installLoader", plan.InstallLoader, now.InstallLoader)
+	field("modPath", plan.ModPath, now.ModPath)
+	field("configOnly", plan.ConfigOnly, now.ConfigOnly)
+
+	current := map[string]PlannedFile{}
+	for _, file := range now.Files {
+		current[file.Action+" "+file.Path] = file
+	}
+	planned := map[string]bool{}
+	for _, file := range plan.Files {
+		key := file.Action + " " + file.Path
+		planned[key] = true
+		found, ok := current[key]
+		switch {
+		case !ok:
+			drift = append(drift, T("apply.drift.gone", file))
+		case found != file:
+			drift = append(drift, T("apply.drift.changed", file, found))
+		}
+	}
+	for _, file := range now.Files {
+		if !planned[file.Action+" "+file.Path] {
+			drift = append(drift, T("apply.drift.new", file))
+		}
+	}
+	return drift
+}
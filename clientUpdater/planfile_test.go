@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// shortSum is the hash of content as plans show it.
+func shortSum(content string) string {
+	return shortHash(sha256Hex([]byte(content)))
+}
+
+func TestPlanFileRoundTrip(t *testing.T) {
+	plan := &PlanFile{
+		Version:       planFileVersion,
+		Created:       time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+		Source:        "https://example.com/pack.zip",
+		ArchiveSHA256: sha256Hex([]byte("archive")),
+		PackVersion:   "2024.06",
+		PackCommit:    "0123abc",
+		MCVersion:     "1.20.1",
+		Loader:        "fabric",
+		InstallLoader: true,
+		ModPath:       "/games/.minecraft/mods",
+		Files: []PlannedFile{
+			{Action: planAdd, Path: "sodium.jar", SHA256: sha256Hex([]byte("sodium 2")), Installed: sha256Hex([]byte("sodium 1"))},
+			{Action: planKeep, Path: "iris.jar", SHA256: sha256Hex([]byte("iris")), Installed: sha256Hex([]byte("iris"))},
+			{Action: planRemove, Path: "old.jar", SHA256: sha256Hex([]byte("old"))},
+			{Action: planWrite, Path: "options.txt", SHA256: sha256Hex([]byte("options"))},
+		},
+	}
+	p := filepath.Join(t.TempDir(), "plan.json")
+	if err := WritePlanFile(p, plan); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadPlanFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, plan) {
+		t.Errorf("read back\n%+v\nwritten\n%+v", read, plan)
+	}
+	if drift := plan.Drift(read); len(drift) > 0 {
+		t.Errorf("drifted from itself: %q", drift)
+	}
+}
+
+func TestReadPlanFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"next.json":    `{"version": 2, "files": []}`,
+		"old.json":     `{"files": []}`,
+		"invalid.json": `{"version": 1, "files": `,
+	})
+	for name, want := range map[string]string{
+		"next.json":    "is a plan of format version 2",
+		"old.json":     "is a plan of format version 0",
+		"invalid.json": "is not a plan",
+		"missing.json": "no such file",
+	} {
+		if _, err := ReadPlanFile(filepath.Join(dir, name)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestPlanFileDrift(t *testing.T) {
+	planned := func() *PlanFile {
+		return &PlanFile{
+			MCVersion: "1.20.1",
+			Loader:    "fabric",
+			ModPath:   "mods",
+			Files: []PlannedFile{
+				{Action: planAdd, Path: "sodium.jar", SHA256: "aaa", Installed: "bbb"},
+				{Action: planRemove, Path: "old.jar", SHA256: "ccc"},
+			},
+		}
+	}
+	tests := []struct {
+		name  string
+		edit  func(now *PlanFile)
+		drift []string
+	}{
+		{name: "unchanged", edit: func(now *PlanFile) { now.Created = clock.Now() }},
+		{
+			name:  "loader",
+			edit:  func(now *PlanFile) { now.Loader, now.InstallLoader = "quilt", true },
+			drift: []string{"loader was fabric, is now quilt", "installLoader was false, is now true"},
+		},
+		{
+			name:  "edited",
+			edit:  func(now *PlanFile) { now.Files[0].Installed = "ddd" },
+			drift: []string{"planned: add sodium.jar (aaa) over bbb, now: add sodium.jar (aaa) over ddd"},
+		},
+		{
+			name:  "removed by hand",
+			edit:  func(now *PlanFile) { now.Files = now.Files[:1] },
+			drift: []string{"planned: remove old.jar (ccc), no longer needed"},
+		},
+		{
+			name: "extra",
+			edit: func(now *PlanFile) {
+				now.Files = append(now.Files, PlannedFile{Action: planRemove, Path: "extra.jar", SHA256: "eee"})
+			},
+			drift: []string{"not planned: remove extra.jar (eee)"},
+		},
+	}
+	for _, test := range tests {
+		now := planned()
+		test.edit(now)
+		if drift := planned().Drift(now); !reflect.DeepEqual(drift, test.drift) {
+			t.Errorf("%s: drift %q, want %q", test.name, drift, test.drift)
+		}
+	}
+}
+
+func TestPlanAndApply(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 2", "mods/iris.jar": "iris"})
+	writeFiles(t, u.mods, map[string]string{"sodium.jar": "sodium 1", "iris.jar": "iris", "old.jar": "old"})
+	before := snapshotFiles(t, u.minecraft)
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+
+	output := readFile(t, u.run(t, "plan", "--out", planPath))
+	if !strings.Contains(output, "saved to "+planPath) {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := changedFiles(t, u.minecraft, before); got != "" {
+		t.Errorf("planning changed %s", got)
+	}
+	plan, err := ReadPlanFile(planPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, file := range plan.Files {
+		actions = append(actions, file.String())
+	}
+	want := []string{
+		"add sodium.jar (" + shortSum("sodium 2") + ") over " + shortSum("sodium 1"),
+		"keep iris.jar (" + shortSum("iris") + ")",
+		"remove old.jar (" + shortSum("old") + ")",
+	}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("planned %q, want %q", actions, want)
+	}
+	if plan.MCVersion != "1.20.1" || plan.Loader != "fabric" || plan.ModPath != u.mods || plan.ArchiveSHA256 == "" {
+		t.Errorf("plan %s", mustJSON(t, plan))
+	}
+
+	output = readFile(t, u.run(t, "apply", planPath))
+	if !strings.Contains(output, "Nothing changed since "+planPath+" was planned") {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := dirNames(t, u.mods); got != "iris.jar sodium.jar" {
+		t.Errorf("installed %s", got)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 2" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+}
+
+// TestApplyRefusesDrift applies a plan after a mod was edited and another
+// one added: nothing is changed and both are listed.
+func TestApplyRefusesDrift(t *testing.T) {
+	useRunState(t)
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 2"})
+	writeFiles(t, u.mods, map[string]string{"sodium.jar": "sodium 1"})
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	u.run(t, "plan", "--out", planPath)
+
+	writeFiles(t, u.mods, map[string]string{"sodium.jar": "sodium 1, edited", "extra.jar": "extra"})
+	before := snapshotFiles(t, u.minecraft)
+	if code := exitsWith(func() { u.run(t, "apply", planPath) }); code <= 0 {
+		t.Errorf("exited with %d", code)
+	}
+	if got := changedFiles(t, u.minecraft, before); got != "" {
+		t.Errorf("changed %s", got)
+	}
+	log := readFile(t, filepath.Join(u.state, "clientUpdate.log"))
+	for _, want := range []string{
+		"planned: add sodium.jar (" + shortSum("sodium 2") + ") over " + shortSum("sodium 1") + ", now: add sodium.jar (" + shortSum("sodium 2") + ") over " + shortSum("sodium 1, edited"),
+		"not planned: remove extra.jar (" + shortSum("extra") + ")",
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("no %q in the log:\n%s", want, log)
+		}
+	}
+}
+
+// TestApplyOtherVersion refuses to apply a plan of a later format before
+// looking at the pack.
+func TestApplyOtherVersion(t *testing.T) {
+	useRunState(t)
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium"})
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	content, err := json.Marshal(&PlanFile{Version: planFileVersion + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(planPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := exitsWith(func() { u.run(t, "apply", planPath) }); code <= 0 {
+		t.Errorf("exited with %d", code)
+	}
+	if got := dirNames(t, u.mods); got != "" {
+		t.Errorf("installed %s", got)
+	}
+	if got := atomic.LoadInt32(&u.server.downloads); got != 0 {
+		t.Errorf("downloaded the pack %d times", got)
+	}
+}
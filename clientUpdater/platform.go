@@ -0,0 +1,100 @@
+package main
+
+import (
+	"path"
+	"runtime"
+	"strings"
+)
+
+// PlatformConstraint limits a mod file of the pack to some platforms.
+// Values are GOOS and GOARCH names, e.g. "windows" or "arm64"; a leading
+// "!" excludes one instead. An empty list allows every platform.
+type PlatformConstraint struct {
+	OS   []string `json:"os,omitempty"`
+	Arch []string `json:"arch,omitempty"`
+}
+
+// matchValues reports whether value satisfies a list of allowed and
+// excluded ("!name") values: it must be one of the allowed ones, if any
+// are listed, and none of the excluded ones.
+func matchValues(values []string, value string) bool {
+	allowed := false
+	restricted := false
+	for _, v := range values {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if strings.HasPrefix(v, "!") {
+			if v[1:] == value {
+				return false
+			}
+			continue
+		}
+		restricted = true
+		allowed = allowed || v == value
+	}
+	return allowed || !restricted
+}
+
+// Matches reports whether the constraint allows goos and goarch.
+func (c PlatformConstraint) Matches(goos string, goarch string) bool {
+	return matchValues(c.OS, goos) && matchValues(c.Arch, goarch)
+}
+
+// String describes the constraint for people, e.g. "os windows, arch !arm64".
+func (c PlatformConstraint) String() string {
+	var parts []string
+	if len(c.OS) > 0 {
+		parts = append(parts, "os "+strings.Join(c.OS, " "))
+	}
+	if len(c.Arch) > 0 {
+		parts = append(parts, "arch "+strings.Join(c.Arch, " "))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// currentPlatform is what constraints are matched against, replaced when
+// the updater is driven by a test.
+var currentPlatform = struct{ OS, Arch string }{runtime.GOOS, runtime.GOARCH}
+
+// PlatformSkip is a mod file of the pack not meant for this platform.
+type PlatformSkip struct {
+	Name       string
+	Constraint PlatformConstraint
+}
+
+// forPlatform reports whether the mod file name of the pack is meant for
+// this platform.
+func (m *PackManifest) forPlatform(name string) bool {
+	if m == nil {
+		return true
+	}
+	c, ok := m.Platforms[path.Base(name)]
+	return !ok || c.Matches(currentPlatform.OS, currentPlatform.Arch)
+}
+
+// IsModEntry reports whether the archive entry is a mod file installed on
+// this platform.
+func (a *PackArchive) IsModEntry(name string) bool {
+	return isModEntry(name, a.ModFolder) && a.Manifest.forPlatform(name)
+}
+
+// PlatformSkips lists the mod files of the archive left out on this
+// platform, in archive order.
+func (a *PackArchive) PlatformSkips() ([]PlatformSkip, error) {
+	if a.Manifest == nil || len(a.Manifest.Platforms) == 0 {
+		return nil, nil
+	}
+	r, err := OpenPackArchive(a.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var skips []PlatformSkip
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || !isModEntry(f.Name, a.ModFolder) || a.Manifest.forPlatform(f.Name) {
+			continue
+		}
+		name := path.Base(f.Name)
+		skips = append(skips, PlatformSkip{Name: name, Constraint: a.Manifest.Platforms[name]})
+	}
+	return skips, nil
+}
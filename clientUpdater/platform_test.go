@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// usePlatform has the test's updater run on goos and goarch.
+func usePlatform(t *testing.T, goos string, goarch string) {
+	t.Helper()
+	saved := currentPlatform
+	currentPlatform.OS, currentPlatform.Arch = goos, goarch
+	t.Cleanup(func() { currentPlatform = saved })
+}
+
+func TestPlatformConstraintMatches(t *testing.T) {
+	tests := []struct {
+		constraint PlatformConstraint
+		goos       string
+		goarch     string
+		want       bool
+	}{
+		{PlatformConstraint{}, "linux", "amd64", true},
+		{PlatformConstraint{OS: []string{"windows"}}, "windows", "amd64", true},
+		{PlatformConstraint{OS: []string{"windows"}}, "linux", "amd64", false},
+		{PlatformConstraint{OS: []string{"linux", "darwin"}}, "darwin", "arm64", true},
+		{PlatformConstraint{OS: []string{"!windows"}}, "linux", "amd64", true},
+		{PlatformConstraint{OS: []string{"!windows"}}, "windows", "amd64", false},
+		// an exclusion wins over an allowed value
+		{PlatformConstraint{OS: []string{"windows", "!windows"}}, "windows", "amd64", false},
+		{PlatformConstraint{OS: []string{" Windows "}}, "windows", "amd64", true},
+		{PlatformConstraint{OS: []string{"darwin"}, Arch: []string{"arm64"}}, "darwin", "arm64", true},
+		{PlatformConstraint{OS: []string{"darwin"}, Arch: []string{"arm64"}}, "darwin", "amd64", false},
+		{PlatformConstraint{Arch: []string{"!arm64", "!386"}}, "linux", "amd64", true},
+		{PlatformConstraint{Arch: []string{"!arm64", "!386"}}, "windows", "386", false},
+	}
+	for _, test := range tests {
+		if got := test.constraint.Matches(test.goos, test.goarch); got != test.want {
+			t.Errorf("%s matches %s/%s: %t", test.constraint, test.goos, test.goarch, got)
+		}
+	}
+	if got := (PlatformConstraint{OS: []string{"windows"}, Arch: []string{"!arm64"}}).String(); got != "os windows, arch !arm64" {
+		t.Errorf("described as %q", got)
+	}
+}
+
+// nativesPack is a pack with a natives mod built for each platform.
+var nativesPack = map[string]string{
+	"mods/sodium.jar":                 "sodium",
+	"mods/natives-windows.jar":        "windows natives",
+	"mods/natives-linux.jar":          "linux natives",
+	"mods/natives-macos-arm64.jar":    "macOS natives",
+	"mods/natives-macos-x64.jar":      "macOS intel natives",
+	"mods/legacy-natives-not-arm.jar": "not arm",
+	"pack.json": `{"versions": {"1.20.1": "mods"}, "platforms": {
+		"natives-windows.jar": {"os": ["windows"]},
+		"natives-linux.jar": {"os": ["linux"]},
+		"natives-macos-arm64.jar": {"os": ["darwin"], "arch": ["arm64"]},
+		"natives-macos-x64.jar": {"os": ["darwin"], "arch": ["!arm64"]},
+		"legacy-natives-not-arm.jar": {"arch": ["!arm64"]}
+	}}`,
+}
+
+func TestPlatformSkips(t *testing.T) {
+	usePlatform(t, "darwin", "arm64")
+	u := newFakeUpdate(t, nativesPack)
+	archive, err := ValidateArchive(u.server.archive.Load().(string), "1.20.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	skips, err := archive.PlatformSkips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, skip := range skips {
+		got = append(got, skip.Name+" ("+skip.Constraint.String()+")")
+	}
+	want := "legacy-natives-not-arm.jar (arch !arm64)|natives-linux.jar (os linux)|natives-macos-x64.jar (os darwin, arch !arm64)|natives-windows.jar (os windows)"
+	if strings.Join(got, "|") != want {
+		t.Errorf("skipped %q", got)
+	}
+	if !archive.IsModEntry("rxmc-Mods-master/mods/natives-macos-arm64.jar") || archive.IsModEntry("rxmc-Mods-master/mods/natives-windows.jar") {
+		t.Error("the mod entries aren't those of darwin/arm64")
+	}
+}
+
+// TestUpdatePlatformMods updates an instance copied from a Linux computer
+// on Windows: the Linux natives are replaced by the Windows ones, and the
+// summary tells which files were left out.
+func TestUpdatePlatformMods(t *testing.T) {
+	usePlatform(t, "windows", "amd64")
+	u := newFakeUpdate(t, nativesPack)
+	writeFiles(t, u.mods, map[string]string{"sodium.jar": "sodium", "natives-linux.jar": "linux natives"})
+
+	output := readFile(t, u.run(t))
+	if got := dirNames(t, u.mods); got != "legacy-natives-not-arm.jar natives-windows.jar sodium.jar" {
+		t.Errorf("installed %s, output:\n%s", got, output)
+	}
+	for _, want := range []string{
+		"3 files left out, meant for other platforms than windows/amd64:",
+		"natives-linux.jar (os linux)",
+		"natives-macos-arm64.jar (os darwin, arch arm64)",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("no %q in the output:\n%s", want, output)
+		}
+	}
+	backups := filepath.Join(u.state, backupsDirName)
+	if _, err := os.Stat(filepath.Join(backups, "20240601-120100", "natives-linux.jar")); err != nil {
+		t.Errorf("the Linux natives weren't backed up: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import "sort"
+
+// Mod policies of the pack manifest. Mods without one are replaced by the
+// pack's version like any other file.
+const (
+	// modPinned mods are always put back to the pack's version, and the
+	// player is told when theirs was different.
+	modPinned = "pinned"
+	// modFloating mods the player upgraded themselves are left alone
+	// while their version is newer than the pack's.
+	modFloating = "floating"
+)
+
+// KeptMod is a floating mod whose installed version was kept over the
+// pack's.
+type KeptMod struct {
+	ID          string
+	File        string
+	Version     string
+	PackFile    string
+	PackVersion string
+}
+
+// PinnedMod is a pinned mod whose installed version was replaced by the
+// pack's.
+type PinnedMod struct {
+	ID          string
+	Version     string
+	PackVersion string
+}
+
+// ModPolicies is what the manifest's policies mean for one mods
+// directory.
+type ModPolicies struct {
+	Kept     []KeptMod
+	Restored []PinnedMod
+}
+
+// ResolveModPolicies compares the installed mods with the ones the pack
+// ships. A floating mod is kept when exactly one jar of it is installed
+// and its version is newer than the pack's; versions that aren't semantic
+// versions never count as newer. A pinned mod installed in another version
+// is reported as restored.
+func ResolveModPolicies(policies map[string]string, installed map[string][]ModInfo, shipped map[string]ModInfo) ModPolicies {
+	var resolved ModPolicies
+	for id, policy := range policies {
+		pack, ok := shipped[id]
+		if !ok {
+			continue
+		}
+		jars := installed[id]
+		switch policy {
+		case modFloating:
+			if len(jars) != 1 {
+				continue
+			}
+			if c, err := CompareSemver(jars[0].Version, pack.Version); err == nil && c > 0 {
+				resolved.Kept = append(resolved.Kept, KeptMod{ID: id, File: jars[0].File, Version: jars[0].Version, PackFile: pack.File, PackVersion: pack.Version})
+			}
+		case modPinned:
+			for _, jar := range jars {
+				if jar.Version != pack.Version {
+					resolved.Restored = append(resolved.Restored, PinnedMod{ID: id, Version: jar.Version, PackVersion: pack.Version})
+					break
+				}
+			}
+		}
+	}
+	sort.Slice(resolved.Kept, func(i, j int) bool { return resolved.Kept[i].ID < resolved.Kept[j].ID })
+	sort.Slice(resolved.Restored, func(i, j int) bool { return resolved.Restored[i].ID < resolved.Restored[j].ID })
+	return resolved
+}
+
+// policyIDs returns the ids of the mods with any policy.
+func policyIDs(policies map[string]string) []string {
+	ids := make([]string, 0, len(policies))
+	for id := range policies {
+		ids = append(ids, id)
+	}
+	return ids
+}
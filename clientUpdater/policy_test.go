@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// modJar returns the content of a Fabric mod's jar.
+func modJar(t *testing.T, id string, version string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), id+".jar")
+	writeZip(t, p, map[string]string{"fabric.mod.json": fmt.Sprintf(`{"id": %q, "version": %q}`, id, version)})
+	return readFile(t, p)
+}
+
+func TestResolveModPolicies(t *testing.T) {
+	policies := map[string]string{
+		"sodium":     modFloating,
+		"iris":       modFloating,
+		"lithium":    modFloating,
+		"modmenu":    modFloating,
+		"rxmc-sync":  modPinned,
+		"voicechat":  modPinned,
+		"journeymap": modFloating,
+		"absent":     modPinned,
+	}
+	shipped := map[string]ModInfo{
+		"sodium":     {ID: "sodium", Version: "0.5.9+mc1.20.1", File: "sodium-0.5.9.jar"},
+		"iris":       {ID: "iris", Version: "1.7.0+mc1.20.1", File: "iris-1.7.0.jar"},
+		"lithium":    {ID: "lithium", Version: "0.11.2", File: "lithium.jar"},
+		"modmenu":    {ID: "modmenu", Version: "7.2.2", File: "modmenu.jar"},
+		"rxmc-sync":  {ID: "rxmc-sync", Version: "1.2.0", File: "rxmc-sync.jar"},
+		"voicechat":  {ID: "voicechat", Version: "2.5.0", File: "voicechat.jar"},
+		"journeymap": {ID: "journeymap", Version: "5.10.0", File: "journeymap.jar"},
+	}
+	installed := map[string][]ModInfo{
+		// upgraded by the player
+		"sodium": {{ID: "sodium", Version: "0.6.0+mc1.20.1", File: "sodium-0.6.0.jar"}},
+		// older than the pack's
+		"iris": {{ID: "iris", Version: "1.6.4+mc1.20.1", File: "iris-1.6.4.jar"}},
+		// the same
+		"lithium": {{ID: "lithium", Version: "0.11.2", File: "lithium.jar"}},
+		// two jars of it, neither is kept
+		"modmenu": {{ID: "modmenu", Version: "7.3.0", File: "modmenu-7.3.0.jar"}, {ID: "modmenu", Version: "7.2.2", File: "modmenu.jar"}},
+		// not a version to compare
+		"journeymap": {{ID: "journeymap", Version: "${version}", File: "journeymap-dev.jar"}},
+		"rxmc-sync":  {{ID: "rxmc-sync", Version: "1.3.0-dev", File: "rxmc-sync-dev.jar"}},
+		"voicechat":  {{ID: "voicechat", Version: "2.5.0", File: "voicechat.jar"}},
+		"absent":     {{ID: "absent", Version: "1.0.0", File: "absent.jar"}},
+	}
+	resolved := ResolveModPolicies(policies, installed, shipped)
+	want := ModPolicies{
+		Kept:     []KeptMod{{ID: "sodium", File: "sodium-0.6.0.jar", Version: "0.6.0+mc1.20.1", PackFile: "sodium-0.5.9.jar", PackVersion: "0.5.9+mc1.20.1"}},
+		Restored: []PinnedMod{{ID: "rxmc-sync", Version: "1.3.0-dev", PackVersion: "1.2.0"}},
+	}
+	if mustJSON(t, resolved) != mustJSON(t, want) {
+		t.Errorf("resolved %s, want %s", mustJSON(t, resolved), mustJSON(t, want))
+	}
+	if resolved := ResolveModPolicies(policies, nil, shipped); len(resolved.Kept)+len(resolved.Restored) != 0 {
+		t.Errorf("resolved %+v without installed mods", resolved)
+	}
+}
+
+// TestUpdateWithModPolicies updates mods the player changed: the upgraded
+// floating mod stays, the changed pinned one is put back.
+func TestUpdateWithModPolicies(t *testing.T) {
+	pack := map[string]string{
+		"pack.json":                  `{"modPolicies": {"sodium": "floating", "iris": "floating", "rxmc-sync": "pinned"}}`,
+		"mods/sodium-0.5.9.jar":      modJar(t, "sodium", "0.5.9"),
+		"mods/iris-1.7.0.jar":        modJar(t, "iris", "1.7.0"),
+		"mods/rxmc-sync-1.2.0.jar":   modJar(t, "rxmc-sync", "1.2.0"),
+		"mods/fabric-api-0.92.2.jar": modJar(t, "fabric-api", "0.92.2"),
+	}
+	u := newFakeUpdate(t, pack)
+	writeFiles(t, u.mods, map[string]string{
+		"sodium-0.6.0.jar":     modJar(t, "sodium", "0.6.0"),
+		"iris-1.6.4.jar":       modJar(t, "iris", "1.6.4"),
+		"rxmc-sync-custom.jar": modJar(t, "rxmc-sync", "1.2.1"),
+	})
+
+	output := readFile(t, u.run(t))
+	if got := dirNames(t, u.mods); got != "fabric-api-0.92.2.jar iris-1.7.0.jar rxmc-sync-1.2.0.jar sodium-0.6.0.jar" {
+		t.Errorf("installed %s, output:\n%s", got, output)
+	}
+	for _, want := range []string{
+		"kept newer user version of sodium (0.6.0 > 0.5.9)",
+		"rxmc-sync must match the pack, restored 1.2.0 over your 1.2.1",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("no %q in the output:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "of iris") {
+		t.Errorf("kept the older iris:\n%s", output)
+	}
+}
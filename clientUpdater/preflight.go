@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Preflight summarizes an update before anything is changed, so it can be
+// confirmed once.
+type Preflight struct {
+	Source      string
+	PackVersion string
+	ModPath     string
+	MCVersion   string
+	// PreviousMCVersion is the version of the last update when this one
+	// moves to another.
+	PreviousMCVersion string
+	Loader            string
+	InstallLoader     bool
+	// BootstrapVanilla is set when the vanilla version is downloaded first.
+	BootstrapVanilla bool
+	// Add counts the pack files that are new or changed, Remove the
+	// installed files the pack doesn't have, Unchanged the files already
+	// matching the pack.
+	Add       int
+	Remove    int
+	Unchanged int
+	// BackupFiles are the installed files moved to BackupDir.
+	BackupFiles int
+	BackupDir   string
+	// Locked is set when the mods directory is locked between updates.
+	Locked bool
+	// ConfigOnly is set when only the transformed files are installed.
+	ConfigOnly bool
+	// Skipped are the pack's files meant for other platforms.
+	Skipped []PlatformSkip
+	// Protected are the player's files left alone, counted in neither Add
+	// nor Remove.
+	Protected []ProtectedFile
+	// External are the external mods missing or of another release, those
+	// downloaded counted in Add.
+	External []ExternalPlan
+	// Configs are the files outside the mods directory the update writes,
+	// listed in a section of their own and confirmed separately. With
+	// SkipConfigs they are left alone.
+	Configs     []ConfigChange
+	SkipConfigs bool
+	// IgnoredJunk counts the files of the manifest's transforms not
+	// installed, junk or not allowed in their folder.
+	IgnoredJunk int
+	// Requirements are the requirements of the pack this system doesn't
+	// meet.
+	Requirements []RequirementIssue
+	Warnings     []string
+}
+
+// Preflight works out what Execute is going to do from the archive and
+// the preparation, reading but not changing anything.
+func (p *UpdatePlan) Preflight(source string) (*Preflight, error) {
+	f := &Preflight{
+		Source:           source,
+		ModPath:          p.ModPath,
+		MCVersion:        p.MCVersion,
+		Loader:           p.Loader.Name(),
+		InstallLoader:    p.InstallLoader,
+		BootstrapVanilla: p.BootstrapVanilla,
+		BackupDir:        p.BackupDir,
+		Locked:           ModsDirLocked(p.ModPath),
+	}
+	if manifest := p.Archive.Manifest; manifest != nil {
+		f.PackVersion = manifest.Version
+		f.IgnoredJunk = len(manifest.Ignored)
+		if manifest.Requirements != nil {
+			f.Requirements = CheckRequirements(manifest.Requirements, ProbeSystem())
+		}
+	}
+
+	shipped, unchanged, err := unchangedEntries(p.Archive, p.ModPath, p.Prepared)
+	if err != nil {
+		return nil, err
+	}
+	if manifest := p.Archive.Manifest; manifest != nil && len(manifest.Transforms) > 0 {
+		f.Configs = PlanConfigChanges(manifest.Transforms, p.GameDir)
+		f.SkipConfigs = p.SkipConfigs
+	}
+	if f.Skipped, err = p.Archive.PlatformSkips(); err != nil {
+		return nil, err
+	}
+	if f.Protected, err = ScanProtected(p.ModPath); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	external := p.ExternalMods()
+	f.Protected = append(f.Protected, externalProtected(p.ModPath, external)...)
+	for _, plan := range external {
+		if plan.Status != externalPresent {
+			f.External = append(f.External, plan)
+		}
+	}
+	protected := protectedPaths(p.ModPath, f.Protected)
+	for name := range shipped {
+		if protected.Has(filepath.Join(p.ModPath, name)) {
+			delete(shipped, name)
+			delete(unchanged, name)
+		}
+	}
+	f.Unchanged = len(unchanged)
+	f.Add = len(shipped) - len(unchanged)
+	for _, plan := range f.External {
+		if !plan.Mod.UserProvided() {
+			f.Add++
+		}
+	}
+	// everything installed is backed up, except what low-write mode leaves
+	// in place
+	var installed map[string]int64
+	if p.Prepared != nil {
+		installed = p.Prepared.Sizes
+	}
+	for path := range installed {
+		if protected.Has(path) {
+			continue
+		}
+		f.BackupFiles++
+		if rel, err := filepath.Rel(p.ModPath, path); err == nil && !shipped[filepath.ToSlash(rel)] {
+			f.Remove++
+		}
+	}
+	if p.LowWrite {
+		f.BackupFiles -= f.Unchanged
+	}
+	return f, nil
+}
+
+// unchangedEntries returns the names of the mod files of the archive, and
+// of those whose copy in modPath, as prep listed it, is identical. Only
+// entries with an installed copy of the same size are read, and only those
+// copies hashed: a file of another size can't be the pack's. On a
+// case-insensitive filesystem the copy may be named in other case.
+func unchangedEntries(archive *PackArchive, modPath string, prep *Preparation) (shipped map[string]bool, unchanged map[string]bool, err error) {
+	r, err := OpenPackArchive(archive.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+	names := NamesIn(modPath)
+	installed := map[string]string{}
+	if prep != nil {
+		for p := range prep.Sizes {
+			installed[names.Key(p)] = p
+		}
+	}
+	shipped = map[string]bool{}
+	unchanged = map[string]bool{}
+	for _, entry := range r.File {
+		if entry.FileInfo().IsDir() || !archive.IsModEntry(entry.Name) {
+			continue
+		}
+		name := archive.ModRelPath(entry.Name)
+		shipped[name] = true
+		p, ok := installed[names.Key(filepath.Join(modPath, filepath.FromSlash(name)))]
+		if !ok || prep.Sizes[p] != int64(entry.UncompressedSize64) {
+			continue
+		}
+		sum, err := prep.Hash(p)
+		if err != nil {
+			continue
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		hash := sha256.New()
+		_, err = io.Copy(hash, rc)
+		rc.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if hex.EncodeToString(hash.Sum(nil)) == sum {
+			unchanged[name] = true
+		}
+	}
+	return shipped, unchanged, nil
+}
+
+// Render formats the summary for people.
+func (f *Preflight) Render() string {
+	var b strings.Builder
+	line := func(key string, args ...interface{}) {
+		b.WriteString(T(key, args...))
+		b.WriteString("\n")
+	}
+	line("preflight.header")
+	pack := f.Source
+	if f.PackVersion != "" {
+		pack = f.PackVersion + " (" + f.Source + ")"
+	}
+	line("preflight.pack", pack)
+	line("preflight.target", f.ModPath)
+	if f.Locked {
+		line("preflight.locked")
+	}
+	if f.InstallLoader {
+		line("preflight.minecraft.install", f.MCVersion, f.Loader)
+	} else {
+		line("preflight.minecraft", f.MCVersion, f.Loader)
+	}
+	if f.BootstrapVanilla {
+		line("preflight.vanilla")
+	}
+	if f.PreviousMCVersion != "" {
+		b.WriteString("  ")
+		line("mcversion.change", f.PreviousMCVersion, f.MCVersion)
+		line("mcversion.change.loader", f.Loader, f.MCVersion)
+		line("mcversion.change.keep", f.PreviousMCVersion)
+	}
+	if f.ConfigOnly {
+		line("preflight.configonly", f.Unchanged)
+	} else {
+		line("preflight.mods", f.Add, f.Remove, f.Unchanged)
+	}
+	if len(f.Skipped) > 0 {
+		line("preflight.platform", len(f.Skipped), currentPlatform.OS+"/"+currentPlatform.Arch)
+		for _, skip := range f.Skipped {
+			line("preflight.platform.file", skip.Name, skip.Constraint)
+		}
+	}
+	if len(f.Protected) > 0 {
+		line("preflight.protected", len(f.Protected))
+		for _, file := range f.Protected {
+			line("preflight.protected.file", file)
+		}
+	}
+	for _, plan := range f.External {
+		switch {
+		case !plan.Mod.UserProvided():
+			line("preflight.external.download", plan.Mod.name(), plan.Mod.URL)
+		case plan.Status == externalMismatch:
+			line("preflight.external.mismatch", plan.Mod.name(), plan.Mod.Page)
+		default:
+			line("preflight.external.missing", plan.Mod.name(), plan.Mod.Page)
+		}
+	}
+	if f.BackupFiles > 0 && !f.ConfigOnly {
+		line("preflight.backup", f.BackupFiles, f.BackupDir)
+	}
+	switch {
+	case len(f.Configs) > 0 && f.SkipConfigs:
+		line("preflight.configs.skipped", len(f.Configs))
+	case len(f.Configs) > 0:
+		line("preflight.configs", len(f.Configs))
+		for _, change := range f.Configs {
+			if change.Overwrite {
+				line("preflight.configs.overwrite", change.Path)
+			} else {
+				line("preflight.configs.add", change.Path)
+			}
+		}
+	}
+	if f.IgnoredJunk > 0 {
+		line("preflight.junk", f.IgnoredJunk)
+	}
+	if len(f.Requirements) > 0 {
+		line("preflight.requirements")
+		for _, issue := range f.Requirements {
+			line("preflight.requirements.issue", issue.Message)
+		}
+	}
+	for _, warning := range f.Warnings {
+		b.WriteString(fmt.Sprintf("  ! %s\n", warning))
+	}
+	return b.String()
+}
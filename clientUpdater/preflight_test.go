@@ -0,0 +1,119 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakePreflight is a summary of an update with something in every section.
+func fakePreflight() *Preflight {
+	return &Preflight{
+		Source:            "https://github.com/rx13/rxmc-Mods/archive/refs/heads/master.zip",
+		PackVersion:       "2024.06",
+		ModPath:           "/home/steve/.minecraft/mods",
+		MCVersion:         "1.21",
+		PreviousMCVersion: "1.20.1",
+		Loader:            "fabric",
+		InstallLoader:     true,
+		Add:               12,
+		Remove:            3,
+		Unchanged:         40,
+		BackupFiles:       43,
+		BackupDir:         "/home/steve/.rxmc/backups/20240601-120000",
+		Locked:            true,
+		Skipped:           []PlatformSkip{{Name: "macos-fix.jar", Constraint: PlatformConstraint{OS: []string{"darwin"}}}},
+		Protected:         []ProtectedFile{{Name: "local/minimap.jar", Reason: "local"}},
+		External: []ExternalPlan{
+			{Mod: ExternalMod{File: "voicechat.jar", URL: "https://cdn.example.com/voicechat.jar"}, Status: externalMissing},
+			{Mod: ExternalMod{File: "optifine.jar", Name: "OptiFine", Page: "https://optifine.net/downloads"}, Status: externalMismatch},
+		},
+		Configs: []ConfigChange{
+			{Path: "config/sodium-options.json", Transform: "template", Overwrite: true},
+			{Path: "servers.dat", Transform: "skip-if-exists"},
+		},
+		IgnoredJunk:  2,
+		Requirements: []RequirementIssue{{Minimum: true, Message: "4096 MB of memory needed, this computer has 2048 MB"}},
+		Warnings:     []string{"the pack was downloaded from a fork"},
+	}
+}
+
+func TestPreflightRender(t *testing.T) {
+	usePlatform(t, "linux", "amd64")
+
+	checkGolden(t, "preflight.golden", fakePreflight().Render())
+
+	configOnly := &Preflight{Source: "pack.zip", ModPath: "/mods", MCVersion: "1.20.1", Loader: "quilt", ConfigOnly: true, Unchanged: 5, BackupFiles: 5, BackupDir: "/backup", Configs: fakePreflight().Configs, SkipConfigs: true}
+	checkGolden(t, "preflight-configonly.golden", configOnly.Render())
+}
+
+// TestPlanPreflight works out the summary of an update replacing one of
+// three installed mods and adding another.
+func TestPlanPreflight(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "pack.zip")
+	writeZip(t, archivePath, map[string]string{
+		"rxmc-Mods-master/pack.json":              `{"version": "2024.06", "versions": {"1.20.1": "mods-1.20.1"}, "transforms": {"options.txt": "options-defaults"}}`,
+		"rxmc-Mods-master/mods-1.20.1/sodium.jar": "sodium 2",
+		"rxmc-Mods-master/mods-1.20.1/iris.jar":   "iris",
+		"rxmc-Mods-master/mods-1.20.1/new.jar":    "new",
+		"rxmc-Mods-master/options.txt":            "fov:90",
+	})
+	archive, err := ValidateArchive(archivePath, "1.20.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	minecraft := filepath.Join(root, ".minecraft")
+	mods := filepath.Join(minecraft, "mods")
+	writeFiles(t, mods, map[string]string{
+		"sodium.jar": "sodium 1",
+		"iris.jar":   "iris",
+		"old.jar":    "old",
+	})
+	writeFiles(t, minecraft, map[string]string{"options.txt": "fov:70"})
+	plan := UpdatePlan{
+		Archive:       archive,
+		MCVersion:     "1.20.1",
+		ModPath:       mods,
+		MinecraftPath: minecraft,
+		GameDir:       minecraft,
+		Loader:        fabricLoader{},
+		InstallLoader: true,
+		Prepared:      Prepare(mods, filepath.Join(minecraft, "versions"), fabricLoader{}, "1.20.1"),
+		BackupDir:     filepath.Join(root, "backup"),
+	}
+	preflight, err := plan.Preflight("pack.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preflight.Add != 2 || preflight.Remove != 1 || preflight.Unchanged != 1 || preflight.BackupFiles != 3 {
+		t.Errorf("add %d, remove %d, unchanged %d, back up %d", preflight.Add, preflight.Remove, preflight.Unchanged, preflight.BackupFiles)
+	}
+	if preflight.PackVersion != "2024.06" || !preflight.InstallLoader || len(preflight.Configs) != 1 || !preflight.Configs[0].Overwrite {
+		t.Errorf("preflight %+v", preflight)
+	}
+	// nothing was changed working it out
+	if got := dirNames(t, mods); got != "iris.jar old.jar sodium.jar" {
+		t.Errorf("mods %s", got)
+	}
+	if got := dirNames(t, root); got != ".minecraft pack.zip" {
+		t.Errorf("created %s", got)
+	}
+}
+
+// TestUpdateConfirmsPreflight checks an unattended update prints the
+// summary and its assumed confirmation before changing anything.
+func TestUpdateConfirmsPreflight(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium"})
+	writeFiles(t, u.mods, map[string]string{"old.jar": "old"})
+	output := readFile(t, u.run(t))
+	summary := strings.Index(output, "=== Ready to update ===")
+	confirmed := strings.Index(output, "< Go ahead? [Y = update / n = cancel / d = choose another mods directory]: y")
+	removing := strings.Index(output, T("mods.removing"))
+	if summary < 0 || confirmed < summary || removing < confirmed {
+		t.Fatalf("output:\n%s", output)
+	}
+	if !strings.Contains(output[summary:confirmed], "1 mods added or updated, 1 removed, 0 unchanged") {
+		t.Errorf("summary:\n%s", output[summary:confirmed])
+	}
+}
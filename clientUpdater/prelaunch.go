@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// prelaunchNetworkBudget is how long the prelaunch check waits for the
+// pack repository. The game is starting, so being offline or slow only
+// means the check is skipped.
+const prelaunchNetworkBudget = 2 * time.Second
+
+// packHeadURL returns the commit the pack's branch is at, as plain text
+// when asked for application/vnd.github.sha.
+const packHeadURL = "https://api.github.com/repos/rx13/rxmc-Mods/commits/master"
+
+// PrelaunchReport is what the check before a game start found.
+type PrelaunchReport struct {
+	// Damaged are the pack files missing or of another size, Restored
+	// those of them put back from the backups of earlier updates.
+	Damaged  []string
+	Restored []string
+	// Changed is set when the pack moved on since the last update,
+	// Offline when that couldn't be found out within the budget.
+	Changed bool
+	Offline bool
+}
+
+// Unrepaired reports whether pack files are still missing or damaged.
+func (r *PrelaunchReport) Unrepaired() bool {
+	return len(r.Restored) < len(r.Damaged)
+}
+
+// Prelaunch checks the mods directory against the last update right before
+// the game starts, e.g. as a launcher's pre-launch command. Files are only
+// compared by size, hashing every jar would delay the game; "repair" does
+// the full check. Damaged pack files are put back from the backups in the
+// journal when one with the recorded hash exists; what was there instead
+// is kept in backupDir. Meanwhile headURL is asked whether the pack changed
+// upstream, which is given up on when ctx is done. Nothing is downloaded
+// and nothing is asked.
+func Prelaunch(ctx context.Context, state *InstalledState, modPath string, backupDir string, journal *Journal, headURL string) *PrelaunchReport {
+	report := &PrelaunchReport{}
+	head := make(chan string, 1)
+	go func() {
+		commit, err := packHead(ctx, headURL)
+		if err != nil {
+			Logf("prelaunch: checking the pack: %s", err)
+		}
+		head <- commit
+	}()
+
+	var damaged []InstalledFile
+	for _, file := range state.Files {
+		info, err := os.Stat(filepath.Join(modPath, file.Name))
+		if file.PackPath != "" && (err != nil || info.Size() != file.Size) {
+			damaged = append(damaged, file)
+			report.Damaged = append(report.Damaged, file.Name)
+		}
+	}
+	if len(damaged) > 0 {
+		report.Restored = restoreFromBackups(damaged, modPath, backupDir, journal)
+	}
+
+	commit := <-head
+	switch {
+	case commit == "" || state.PackCommit == "":
+		report.Offline = commit == ""
+	case commit != state.PackCommit:
+		report.Changed = true
+	}
+	return report
+}
+
+// packHead returns the commit url names, giving up when ctx is done.
+func packHead(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github.sha")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &statusError{status: resp.StatusCode}
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, 256))
+	if err != nil {
+		return "", err
+	}
+	commit := strings.TrimSpace(string(body))
+	if !commitPattern.MatchString(commit) {
+		return "", fmt.Errorf("unexpected answer %q", commit)
+	}
+	return commit, nil
+}
+
+// restoreFromBackups copies the newest trusted backup with the recorded
+// hash of each damaged file back into modPath and returns the names of the
+// files restored. A damaged file still there is moved to backupDir first.
+func restoreFromBackups(damaged []InstalledFile, modPath string, backupDir string, journal *Journal) []string {
+	entries, err := ReadJournal(journal.Path)
+	if err != nil {
+		Logf("prelaunch: reading the journal: %s", err)
+		return nil
+	}
+	backups := map[string]JournalEntry{}
+	for _, entry := range entries {
+		if entry.Backup != "" {
+			backups[entry.SHA256] = entry
+		}
+	}
+
+	locked := ModsDirLocked(modPath)
+	if locked {
+		if err := UnlockModsDir(modPath); err != nil {
+			Logf("prelaunch: unlocking %s: %s", modPath, err)
+			return nil
+		}
+		defer relockAfterUpdate(modPath, true)
+	}
+	var restored []string
+	for _, file := range damaged {
+		entry, ok := backups[file.SHA256]
+		if !ok {
+			continue
+		}
+		backup, _, err := backupSource(entry)
+		if err != nil {
+			Logf("prelaunch: %s", err)
+			continue
+		}
+		p := filepath.Join(modPath, file.Name)
+		if sum, err := fileSHA256(p); err == nil {
+			kept := filepath.Join(backupDir, file.Name)
+			if err := moveFile(p, kept); err != nil {
+				Logf("prelaunch: keeping %s: %s", p, err)
+				continue
+			}
+			journal.Record(JournalEntry{Action: journalDelete, Path: p, SHA256: sum, Backup: kept})
+		}
+		if err := copyFile(backup, p); err != nil {
+			Logf("prelaunch: restoring %s from %s: %s", file.Name, backup, err)
+			continue
+		}
+		if sum, err := fileSHA256(p); err != nil || sum != file.SHA256 {
+			Logf("prelaunch: backup %s of %s doesn't match, removing it again", backup, file.Name)
+			os.Remove(p)
+			continue
+		}
+		journal.Record(JournalEntry{Action: journalAdd, Path: p, SHA256: file.SHA256})
+		restored = append(restored, file.Name)
+	}
+	return restored
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowHandler answers only once the request is given up on, like a
+// server that doesn't answer in time.
+var slowHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-r.Context().Done():
+	case <-time.After(10 * time.Second):
+	}
+})
+
+// TestPrelaunchPackHead asks a pack repository that answers in several
+// ways whether the pack changed, within a short budget.
+func TestPrelaunchPackHead(t *testing.T) {
+	const commit = "0123456789abcdef0123456789abcdef01234567"
+	tests := []struct {
+		name    string
+		handler http.Handler
+		changed bool
+		offline bool
+	}{
+		{name: "unchanged", handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { fmt.Fprintln(w, commit) })},
+		{name: "changed", handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, strings.Repeat("f", 40)) }), changed: true},
+		{name: "slow", handler: slowHandler, offline: true},
+		{name: "not found", handler: http.NotFoundHandler(), offline: true},
+		{name: "not a commit", handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "<html>") }), offline: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestServer(t, test.handler)
+			state := &InstalledState{PackCommit: commit}
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			report := Prelaunch(ctx, state, t.TempDir(), t.TempDir(), &Journal{Path: filepath.Join(t.TempDir(), "journal.jsonl")}, packHeadURL)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("took %s", elapsed)
+			}
+			if report.Changed != test.changed || report.Offline != test.offline || report.Unrepaired() {
+				t.Errorf("report %+v", report)
+			}
+		})
+	}
+}
+
+// TestPrelaunchEndToEnd runs the prelaunch mode after an update, with the
+// pack repository too slow to answer: the game starts within the network
+// budget, a deleted pack file is back from the update's backup, and
+// nothing is printed. A file without a backup stops the launch.
+func TestPrelaunchEndToEnd(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 2", "mods/iris.jar": "iris"})
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host == "api.github.com" {
+			slowHandler(w, r)
+			return
+		}
+		u.server.ServeHTTP(w, r)
+	}))
+	// the update backs up the sodium.jar already there, iris.jar is new
+	writeFiles(t, u.mods, map[string]string{"sodium.jar": "sodium 2"})
+	u.run(t)
+	if err := os.Remove(filepath.Join(u.mods, "sodium.jar")); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	output := readFile(t, u.run(t, "prelaunch"))
+	if elapsed := time.Since(start); elapsed > prelaunchNetworkBudget+time.Second {
+		t.Errorf("prelaunch took %s", elapsed)
+	}
+	if output != "" {
+		t.Errorf("printed:\n%s", output)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 2" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+	log := readFile(t, filepath.Join(u.state, "clientUpdate.log"))
+	for _, want := range []string{"prelaunch: 1 pack files damaged (sodium.jar), 1 restored", "prelaunch: the pack couldn't be checked, verified locally only"} {
+		if !strings.Contains(log, want) {
+			t.Errorf("no %q in the log:\n%s", want, log)
+		}
+	}
+
+	useRunState(t)
+	if err := os.Remove(filepath.Join(u.mods, "iris.jar")); err != nil {
+		t.Fatal(err)
+	}
+	if code := exitsWith(func() { u.run(t, "prelaunch") }); code != categoryExitCodes[categoryLocal] {
+		t.Errorf("exited with %d missing iris.jar", code)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Preparation is the read-only work on the target that doesn't depend on the
+// downloaded pack, so it can run while the download is still in flight.
+type Preparation struct {
+	ModPath   string
+	MCVersion string
+	// Sizes holds the size of every file currently in the mods directory,
+	// keyed by path, from a single listing of it. PrepareAsync hashes the
+	// files in the background while the pack downloads, those it didn't
+	// get to are hashed when asked for, see Hash: with thousands of files
+	// in the directory most never need to be.
+	Sizes           map[string]int64
+	LoaderInstalled bool
+	LoaderErr       error
+	Duration        time.Duration
+
+	// paths are the files listed, in the order listed.
+	paths  []string
+	mu     sync.Mutex
+	hashes map[string]string
+	// stop ends the background hashing.
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Hash returns the SHA-256 of the file at p, the one hashed in the
+// background if it got to the file already, otherwise hashing it now.
+func (prep *Preparation) Hash(p string) (string, error) {
+	prep.mu.Lock()
+	sum, ok := prep.hashes[p]
+	prep.mu.Unlock()
+	if ok {
+		return sum, nil
+	}
+	sum, err := fileSHA256(p)
+	if err != nil {
+		return "", err
+	}
+	prep.mu.Lock()
+	prep.hashes[p] = sum
+	prep.mu.Unlock()
+	return sum, nil
+}
+
+// hashInBackground hashes the files listed, one after the other, until
+// StopHashing is called. Files that can't be hashed now are left to Hash.
+func (prep *Preparation) hashInBackground() {
+	for _, p := range prep.paths {
+		select {
+		case <-prep.stop:
+			return
+		default:
+		}
+		prep.mu.Lock()
+		_, ok := prep.hashes[p]
+		prep.mu.Unlock()
+		if ok {
+			continue
+		}
+		if sum, err := fileSHA256(p); err == nil {
+			prep.mu.Lock()
+			prep.hashes[p] = sum
+			prep.mu.Unlock()
+		}
+	}
+}
+
+// StopHashing ends the background hashing once the download it overlaps
+// with is done, leaving the files it didn't get to for Hash.
+func (prep *Preparation) StopHashing() {
+	prep.stopOnce.Do(func() { close(prep.stop) })
+}
+
+// Known returns the hashes of the files hashed so far, keyed by path.
+func (prep *Preparation) Known() map[string]string {
+	prep.mu.Lock()
+	defer prep.mu.Unlock()
+	known := make(map[string]string, len(prep.hashes))
+	for p, sum := range prep.hashes {
+		known[p] = sum
+	}
+	return known
+}
+
+// Prepare lists the existing mods and checks for the loader concurrently.
+func Prepare(modPath string, versionsPath string, loader Loader, mcVersion string) *Preparation {
+	start := clock.Now()
+	prep := &Preparation{ModPath: modPath, MCVersion: mcVersion, Sizes: map[string]int64{}, hashes: map[string]string{}, stop: make(chan struct{})}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		prep.LoaderInstalled, prep.LoaderErr = LoaderInstalled(loader, versionsPath, mcVersion)
+	}()
+	go func() {
+		defer wg.Done()
+		filepath.WalkDir(modPath, func(p string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return nil
+			}
+			if info, err := entry.Info(); err == nil {
+				prep.Sizes[p] = info.Size()
+				prep.paths = append(prep.paths, p)
+			}
+			return nil
+		})
+	}()
+	wg.Wait()
+
+	prep.Duration = clock.Now().Sub(start)
+	return prep
+}
+
+// PrefetchMetadata looks up ahead, concurrently, the metadata installing
+// into the minecraft directory will need: the loader's when it isn't
+// installed yet, and the vanilla version manifest when vanilla is to be
+// bootstrapped too. The lookups land in cache, where the install finds
+// them; failures are left for the install to run into again.
+func PrefetchMetadata(cache *Cache, loader Loader, minecraftPath string, mcVersion string, vanilla bool) {
+	if mcVersion == "" {
+		return
+	}
+	if installed, err := LoaderInstalled(loader, filepath.Join(minecraftPath, "versions"), mcVersion); err == nil && installed {
+		return
+	}
+	var wg sync.WaitGroup
+	if prefetcher, ok := loader.(metadataPrefetcher); ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := prefetcher.prefetchMetadata(mcVersion); err != nil {
+				Logf("prefetch: %s metadata: %s", loader.Name(), err)
+			}
+		}()
+	}
+	if vanilla && NeverLaunched(minecraftPath) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.Metadata(vanillaManifestEntry, vanillaManifestURL, vanillaManifestTTL); err != nil {
+				Logf("prefetch: version manifest: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// preparing counts the preparations started by PrepareAsync still running,
+// their background hashing included.
+var preparing sync.WaitGroup
+
+// PrepareAsync runs Prepare in the background, the returned channel yields
+// its result once done. The files are hashed in the background from then
+// on, until the caller calls StopHashing.
+func PrepareAsync(modPath string, versionsPath string, loader Loader, mcVersion string) <-chan *Preparation {
+	done := make(chan *Preparation, 1)
+	preparing.Add(1)
+	go func() {
+		defer preparing.Done()
+		prep := Prepare(modPath, versionsPath, loader, mcVersion)
+		done <- prep
+		prep.hashInBackground()
+	}()
+	return done
+}
+
+// StopPreparing ends a preparation started by PrepareAsync for a run
+// exiting before it uses it: it waits for the result, stops the hashing
+// and waits for that to end, so nothing reads the disk while the run
+// exits.
+func StopPreparing(prepared <-chan *Preparation) {
+	if prepared == nil {
+		return
+	}
+	(<-prepared).StopHashing()
+	preparing.Wait()
+}
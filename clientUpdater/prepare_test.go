@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// installedMods writes n files named mod-<i>.jar to a mods directory,
+// returning it.
+func installedMods(t testing.TB, n int) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "mods")
+	files := map[string]string{}
+	for i := 0; i < n; i++ {
+		files[fmt.Sprintf("mod-%d.jar", i)] = fmt.Sprintf("mod %d", i)
+	}
+	writeFiles(t, dir, files)
+	return dir
+}
+
+func TestPrepareHashesInBackground(t *testing.T) {
+	dir := installedMods(t, 20)
+	prep := <-PrepareAsync(dir, filepath.Join(t.TempDir(), "versions"), fabricLoader{}, "1.20.1")
+	defer prep.StopHashing()
+	if len(prep.Sizes) != 20 {
+		t.Fatalf("listed %d files", len(prep.Sizes))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(prep.Known()) < 20 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of 20 files hashed in the background", len(prep.Known()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for p, sum := range prep.Known() {
+		if want, _ := fileSHA256(p); sum != want {
+			t.Errorf("%s hashed as %s, want %s", p, sum, want)
+		}
+	}
+}
+
+func TestPrepareHashesWhatIsLeftWhenAsked(t *testing.T) {
+	dir := installedMods(t, 20)
+	prep := <-PrepareAsync(dir, filepath.Join(t.TempDir(), "versions"), fabricLoader{}, "1.20.1")
+	prep.StopHashing()
+	for p := range prep.Sizes {
+		sum, err := prep.Hash(p)
+		if want, _ := fileSHA256(p); err != nil || sum != want {
+			t.Errorf("%s hashed as %s, %v, want %s", p, sum, err, want)
+		}
+	}
+	if known := len(prep.Known()); known != 20 {
+		t.Errorf("%d hashes known", known)
+	}
+}
+
+// TestUnchangedEntriesHashesOnlySameSize checks a copy whose size differs
+// from the pack's entry is never hashed.
+func TestUnchangedEntriesHashesOnlySameSize(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mods")
+	writeFiles(t, dir, map[string]string{
+		"same.jar":      "same",
+		"changed.jar":   "old!",
+		"resized.jar":   "a good deal longer",
+		"installed.jar": "not in the pack",
+	})
+	archivePath := filepath.Join(t.TempDir(), "pack.zip")
+	writeZip(t, archivePath, map[string]string{
+		"pack/mods/same.jar":    "same",
+		"pack/mods/changed.jar": "new!",
+		"pack/mods/resized.jar": "short",
+	})
+	prep := Prepare(dir, filepath.Join(t.TempDir(), "versions"), fabricLoader{}, "1.20.1")
+
+	shipped, unchanged, err := unchangedEntries(&PackArchive{Path: archivePath}, dir, prep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shipped) != 3 || len(unchanged) != 1 || !unchanged["same.jar"] {
+		t.Errorf("shipped %v, unchanged %v", shipped, unchanged)
+	}
+	var hashed []string
+	for p := range prep.Known() {
+		hashed = append(hashed, filepath.Base(p))
+	}
+	if len(hashed) != 2 || strings.Contains(strings.Join(hashed, " "), "resized.jar") {
+		t.Errorf("hashed %v, want same.jar and changed.jar", hashed)
+	}
+}
+
+// BenchmarkUnchangedEntries compares a pack of 200 mods with a directory of
+// 10k other files, none of which needs to be hashed.
+func BenchmarkUnchangedEntries(b *testing.B) {
+	dir := installedMods(b, 10000)
+	entries := map[string]string{}
+	for i := 0; i < 200; i++ {
+		entries[fmt.Sprintf("pack/mods/mod-%d.jar", i)] = fmt.Sprintf("mod %d, a newer release", i)
+	}
+	archivePath := filepath.Join(b.TempDir(), "pack.zip")
+	writeZip(b, archivePath, entries)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		prep := Prepare(dir, filepath.Join(dir, "versions"), fabricLoader{}, "1.20.1")
+		if _, _, err := unchangedEntries(&PackArchive{Path: archivePath}, dir, prep); err != nil {
+			b.Fatal(err)
+		}
+		if known := len(prep.Known()); known != 0 {
+			b.Fatalf("%d files hashed", known)
+		}
+	}
+}
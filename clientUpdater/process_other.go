@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// RunningProcesses returns the executable names of all running processes.
+func RunningProcesses() ([]string, error) {
+	output, err := exec.Command("ps", "-A", "-o", "comm=").Output()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			names = append(names, line)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/csv"
+	"os/exec"
+	"strings"
+)
+
+// RunningProcesses returns the executable names of all running processes.
+func RunningProcesses() ([]string, error) {
+	output, err := exec.Command("tasklist", "/FO", "CSV", "/NH").Output()
+	if err != nil {
+		return nil, err
+	}
+	records, err := csv.NewReader(strings.NewReader(string(output))).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, record := range records {
+		if len(record) > 0 {
+			names = append(names, record[0])
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How a ProgressMux shows the targets' progress.
+const (
+	// muxTerminal keeps a line per target on a terminal, redrawn in place.
+	muxTerminal = "terminal"
+	// muxLines prints a summary line per target every muxSummaryInterval,
+	// for logs and pipes.
+	muxLines = "lines"
+	// muxJSON writes every change as a TargetEvent, for programs.
+	muxJSON = "json"
+)
+
+// muxSummaryInterval is how often muxLines prints the targets' progress.
+const muxSummaryInterval = 15 * time.Second
+
+// Events of a target, see TargetEvent.
+const (
+	targetEventPhase    = "phase"
+	targetEventProgress = "progress"
+	targetEventDone     = "done"
+)
+
+// TargetEvent is a change of a target, a JSON line in muxJSON.
+type TargetEvent struct {
+	Event    string       `json:"event"`
+	Target   string       `json:"target"`
+	Phase    string       `json:"phase,omitempty"`
+	Progress *RunProgress `json:"progress,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// muxTarget is what a ProgressMux knows about a target.
+type muxTarget struct {
+	id       string
+	name     string
+	phase    string
+	progress RunProgress
+	started  bool
+	done     bool
+	err      error
+	// sent is when its progress was last written in muxJSON.
+	sent time.Time
+}
+
+// ProgressMux shows the progress of targets updated concurrently. Every
+// write is a whole frame or line made under its lock, so nothing the
+// targets report interleaves. With a single target it shows what the run
+// shows for itself: the progress line, or lines without the target's name.
+type ProgressMux struct {
+	mu      sync.Mutex
+	out     io.Writer
+	mode    string
+	targets []*muxTarget
+	// drawn is the number of lines of the frame on the terminal, drawnAt
+	// when it was drawn; summarized is when muxLines last printed.
+	drawn      int
+	drawnAt    time.Time
+	summarized time.Time
+	// pending is output of the targets captured up to its end of line.
+	pending []byte
+}
+
+// NewProgressMux returns a mux writing to out in mode.
+func NewProgressMux(out io.Writer, mode string) *ProgressMux {
+	return &ProgressMux{out: out, mode: mode, summarized: clock.Now()}
+}
+
+// Add registers the target id, shown as name, in the order shown.
+func (m *ProgressMux) Add(id string, name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.targets = append(m.targets, &muxTarget{id: id, name: name})
+}
+
+// Phase records that target id moved on to phase.
+func (m *ProgressMux) Phase(id string, phase string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.target(id)
+	t.phase, t.progress, t.started = phase, RunProgress{}, true
+	m.changed(t, targetEventPhase, true)
+}
+
+// Progress records how far target id got in its phase.
+func (m *ProgressMux) Progress(id string, progress RunProgress) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.target(id)
+	if progress.Total > 0 {
+		progress.Percent = float64(progress.Done*1000/progress.Total) / 10
+	}
+	t.progress, t.started = progress, true
+	m.changed(t, targetEventProgress, progress.Total > 0 && progress.Done >= progress.Total)
+}
+
+// Finish records that target id is done, failed when err is set.
+func (m *ProgressMux) Finish(id string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.target(id)
+	t.done, t.err = true, err
+	m.changed(t, targetEventDone, true)
+}
+
+// Close writes what the targets left unfinished and the last frame.
+func (m *ProgressMux) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.pending) > 0 {
+		m.print(string(m.pending) + "\n")
+		m.pending = nil
+	}
+	switch {
+	case m.mode == muxTerminal && len(m.targets) == 1:
+		m.write("\r\x1b[K")
+	case m.mode == muxTerminal:
+		m.draw()
+	}
+	m.drawn = 0
+}
+
+// target returns the target id, added on the fly for one never added.
+func (m *ProgressMux) target(id string) *muxTarget {
+	for _, t := range m.targets {
+		if t.id == id {
+			return t
+		}
+	}
+	t := &muxTarget{id: id, name: id}
+	m.targets = append(m.targets, t)
+	return t
+}
+
+// changed shows the change of t, an event of kind. Progress is shown at
+// most every progressLineInterval, or statusInterval for programs, unless
+// forced, as phases and finished targets are.
+func (m *ProgressMux) changed(t *muxTarget, kind string, force bool) {
+	now := clock.Now()
+	switch m.mode {
+	case muxJSON:
+		if !force && now.Sub(t.sent) < statusInterval {
+			return
+		}
+		t.sent = now
+		event := TargetEvent{Event: kind, Target: t.id, Phase: t.phase}
+		if kind == targetEventProgress {
+			progress := t.progress
+			event.Progress = &progress
+		}
+		if t.err != nil {
+			event.Error = t.err.Error()
+		}
+		line, _ := json.Marshal(event)
+		m.write(string(line) + "\n")
+	case muxTerminal:
+		if !force && now.Sub(m.drawnAt) < progressLineInterval {
+			return
+		}
+		m.draw()
+	default:
+		if kind == targetEventDone {
+			m.write(m.summary(t) + "\n")
+		} else if now.Sub(m.summarized) >= muxSummaryInterval {
+			m.summarize()
+		}
+	}
+}
+
+// draw redraws the frame on the terminal over the one drawn before: the
+// progress line for a single target, a line per target otherwise.
+func (m *ProgressMux) draw() {
+	m.drawnAt = clock.Now()
+	if len(m.targets) == 1 {
+		t := m.targets[0]
+		if t.done || t.progress.Total <= 0 || t.progress.Done >= t.progress.Total {
+			m.write("\r\x1b[K")
+			return
+		}
+		m.write("\r" + progressText(t.phase, t.progress) + "\x1b[K")
+		return
+	}
+	var frame strings.Builder
+	if m.drawn > 0 {
+		fmt.Fprintf(&frame, "\x1b[%dA", m.drawn)
+	}
+	width := 0
+	for _, t := range m.targets {
+		if len(t.name) > width {
+			width = len(t.name)
+		}
+	}
+	for _, t := range m.targets {
+		fmt.Fprintf(&frame, "\r\x1b[K  %-*s  %s\n", width, t.name, m.status(t))
+	}
+	m.drawn = len(m.targets)
+	m.write(frame.String())
+}
+
+// summarize prints a line per target still being updated.
+func (m *ProgressMux) summarize() {
+	m.summarized = clock.Now()
+	var lines strings.Builder
+	for _, t := range m.targets {
+		if t.started && !t.done {
+			lines.WriteString(m.summary(t) + "\n")
+		}
+	}
+	m.write(lines.String())
+}
+
+// summary is the line muxLines prints for t, without its name when it is
+// the only target.
+func (m *ProgressMux) summary(t *muxTarget) string {
+	if len(m.targets) == 1 {
+		return m.status(t)
+	}
+	return t.name + ": " + m.status(t)
+}
+
+// status tells how far t is.
+func (m *ProgressMux) status(t *muxTarget) string {
+	switch {
+	case t.done && t.err != nil:
+		return T("progress.failed", t.err)
+	case t.done:
+		return T("progress.done")
+	case !t.started:
+		return T("progress.waiting")
+	}
+	return progressText(t.phase, t.progress)
+}
+
+// print writes text the targets printed, above the frame on a terminal.
+func (m *ProgressMux) print(text string) {
+	if m.mode != muxTerminal {
+		m.write(text)
+		return
+	}
+	if len(m.targets) == 1 {
+		m.write("\r\x1b[K" + text)
+		m.draw()
+		return
+	}
+	// the frame moves down below the text
+	var frame strings.Builder
+	if m.drawn > 0 {
+		fmt.Fprintf(&frame, "\x1b[%dA\r\x1b[J", m.drawn)
+	}
+	frame.WriteString(text)
+	m.write(frame.String())
+	m.drawn = 0
+	m.draw()
+}
+
+func (m *ProgressMux) write(s string) {
+	if s != "" {
+		io.WriteString(m.out, s)
+	}
+}
+
+// Write takes what the targets print, shown line by line so it never
+// tears a frame.
+func (m *ProgressMux) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = append(m.pending, p...)
+	if i := strings.LastIndexByte(string(m.pending), '\n'); i >= 0 {
+		m.print(string(m.pending[:i+1]))
+		m.pending = append([]byte(nil), m.pending[i+1:]...)
+	}
+	return len(p), nil
+}
+
+// Output returns where the targets print: the mux, or for programs reading
+// JSON standard output, which then is standard error and gets none of the
+// events.
+func (m *ProgressMux) Output() io.Writer {
+	if m.mode == muxJSON {
+		return os.Stdout
+	}
+	return m
+}
+
+// targetName is how a target is shown: the name of its instance, the
+// folder its minecraft directory is in for the launchers' .minecraft.
+func targetName(modPath string) string {
+	dir := filepath.Dir(filepath.Clean(modPath))
+	for {
+		name := filepath.Base(dir)
+		if name != ".minecraft" && name != "minecraft" || filepath.Dir(dir) == dir {
+			return name
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// screen is what a terminal shows for the output written to it, for the
+// control sequences the mux uses: carriage return, line feed, erasing to
+// the end of the line or screen and moving the cursor up.
+type screen struct {
+	lines    [][]byte
+	row, col int
+}
+
+func (s *screen) Write(p []byte) (int, error) {
+	for text := string(p); text != ""; {
+		for len(s.lines) <= s.row {
+			s.lines = append(s.lines, nil)
+		}
+		line := s.lines[s.row]
+		switch {
+		case text[0] == '\r':
+			s.col, text = 0, text[1:]
+		case text[0] == '\n':
+			s.row, s.col, text = s.row+1, 0, text[1:]
+		case strings.HasPrefix(text, "\x1b["):
+			end := strings.IndexAny(text, "AJK")
+			n, _ := strconv.Atoi(text[2:end])
+			switch text[end] {
+			case 'A':
+				s.row -= n
+			case 'J':
+				s.lines = s.lines[:s.row+1]
+				fallthrough
+			case 'K':
+				if s.col < len(line) {
+					s.lines[s.row] = line[:s.col]
+				}
+			}
+			text = text[end+1:]
+		default:
+			for len(line) < s.col {
+				line = append(line, ' ')
+			}
+			if s.col < len(line) {
+				line[s.col] = text[0]
+			} else {
+				line = append(line, text[0])
+			}
+			s.lines[s.row] = line
+			s.col, text = s.col+1, text[1:]
+		}
+	}
+	return len(p), nil
+}
+
+// Lines returns the lines shown, up to the last one holding anything.
+func (s *screen) Lines() []string {
+	var lines []string
+	for _, line := range s.lines {
+		lines = append(lines, string(line))
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// newTestMux returns a mux in mode showing the targets a, b and c, named
+// Alpha, Beta and Gamma.
+func newTestMux(t *testing.T, mode string, out io.Writer) *ProgressMux {
+	t.Helper()
+	mux := NewProgressMux(out, mode)
+	mux.Add("a", "Alpha")
+	mux.Add("b", "Beta")
+	mux.Add("c", "Gamma")
+	return mux
+}
+
+func TestProgressMuxTerminal(t *testing.T) {
+	fake := useFakeClock(t)
+	term := &screen{}
+	mux := newTestMux(t, muxTerminal, term)
+	assertScreen := func(want ...string) {
+		t.Helper()
+		if got := term.Lines(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("the terminal shows\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	}
+
+	mux.Phase("a", phaseLoader)
+	mux.Phase("b", phaseSwap)
+	assertScreen(
+		"  Alpha  "+T("phase.loader"),
+		"  Beta   "+T("phase.swap"),
+		"  Gamma  "+T("progress.waiting"),
+	)
+
+	// progress is redrawn at most every progressLineInterval
+	fake.Advance(time.Second)
+	mux.Progress("b", RunProgress{Done: 25, Total: 100})
+	mux.Progress("b", RunProgress{Done: 50, Total: 100})
+	assertScreen(
+		"  Alpha  "+T("phase.loader"),
+		"  Beta   "+progressText(phaseSwap, RunProgress{Done: 25, Total: 100, Percent: 25}),
+		"  Gamma  "+T("progress.waiting"),
+	)
+
+	// what the targets print goes above the frame, whole lines only
+	fmt.Fprintln(mux, "Alpha says hello")
+	fmt.Fprint(mux, "Beta says ")
+	fmt.Fprint(mux, "hi\nand more")
+	mux.Finish("a", nil)
+	mux.Finish("c", errors.New("no space"))
+	mux.Finish("b", nil)
+	mux.Close()
+	assertScreen(
+		"Alpha says hello",
+		"Beta says hi",
+		"and more",
+		"  Alpha  "+T("progress.done"),
+		"  Beta   "+T("progress.done"),
+		"  Gamma  "+T("progress.failed", "no space"),
+	)
+}
+
+func TestProgressMuxTerminalSingleTarget(t *testing.T) {
+	fake := useFakeClock(t)
+	term := &screen{}
+	mux := NewProgressMux(term, muxTerminal)
+	mux.Add("a", "Alpha")
+	mux.Phase("a", phaseSwap)
+	fake.Advance(time.Second)
+	mux.Progress("a", RunProgress{Done: 10, Total: 40})
+	// the progress line of a run, without the name
+	progress := progressText(phaseSwap, RunProgress{Done: 10, Total: 40, Percent: 25})
+	if got := term.Lines(); len(got) != 1 || got[0] != progress {
+		t.Fatalf("the terminal shows %q, want %q", got, progress)
+	}
+	fmt.Fprintln(mux, "extracting")
+	if got := term.Lines(); len(got) != 2 || got[0] != "extracting" || got[1] != progress {
+		t.Fatalf("the terminal shows %q", got)
+	}
+	mux.Finish("a", nil)
+	mux.Close()
+	if got := term.Lines(); len(got) != 1 || got[0] != "extracting" {
+		t.Fatalf("the terminal shows %q once done", got)
+	}
+}
+
+func TestProgressMuxLines(t *testing.T) {
+	fake := useFakeClock(t)
+	var out strings.Builder
+	mux := newTestMux(t, muxLines, &out)
+	mux.Phase("a", phaseLoader)
+	mux.Phase("b", phaseSwap)
+	mux.Progress("b", RunProgress{Done: 1, Total: 4})
+	if out.Len() != 0 {
+		t.Fatalf("summarized early:\n%s", out.String())
+	}
+	fake.Advance(muxSummaryInterval)
+	mux.Progress("b", RunProgress{Done: 2, Total: 4})
+	mux.Finish("b", nil)
+	mux.Finish("a", errors.New("offline"))
+	fmt.Fprint(mux, "unfinished")
+	mux.Close()
+
+	want := []string{
+		// the summary leaves out the targets that didn't start
+		"Alpha: " + T("phase.loader"),
+		"Beta: " + progressText(phaseSwap, RunProgress{Done: 2, Total: 4, Percent: 50}),
+		"Beta: " + T("progress.done"),
+		"Alpha: " + T("progress.failed", "offline"),
+		"unfinished",
+	}
+	if got := strings.TrimSuffix(out.String(), "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("printed\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestProgressMuxJSON(t *testing.T) {
+	fake := useFakeClock(t)
+	var out strings.Builder
+	mux := newTestMux(t, muxJSON, &out)
+	mux.Phase("a", phaseLoader)
+	mux.Phase("c", phaseSwap)
+	mux.Progress("c", RunProgress{Done: 1, Total: 4})
+	// sent at most every statusInterval, unless complete
+	mux.Progress("c", RunProgress{Done: 2, Total: 4})
+	fake.Advance(statusInterval)
+	mux.Progress("c", RunProgress{Done: 3, Total: 4})
+	mux.Finish("a", errors.New("offline"))
+	mux.Close()
+
+	var events []TargetEvent
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var event TargetEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("%q: %s", line, err)
+		}
+		events = append(events, event)
+	}
+	want := []string{
+		"phase a loader",
+		"phase c swap",
+		"progress c swap 3/4",
+		"done a loader offline",
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events %+v", events)
+	}
+	for i, event := range events {
+		got := event.Event + " " + event.Target + " " + event.Phase
+		if event.Progress != nil {
+			got += fmt.Sprintf(" %d/%d", event.Progress.Done, event.Progress.Total)
+		}
+		if event.Error != "" {
+			got += " " + event.Error
+		}
+		if got != want[i] {
+			t.Errorf("event %d is %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+// TestProgressMuxSerializesWrites has three targets print and report
+// progress at the same time: every line comes out whole.
+func TestProgressMuxSerializesWrites(t *testing.T) {
+	useFakeClock(t)
+	var out strings.Builder
+	mux := newTestMux(t, muxLines, &out)
+	var wg sync.WaitGroup
+	for _, id := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				fmt.Fprintf(mux, "target %s line %d\n", id, i)
+				mux.Progress(id, RunProgress{Done: int64(i), Total: 200})
+			}
+			mux.Finish(id, nil)
+		}(id)
+	}
+	wg.Wait()
+	mux.Close()
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	printed := 0
+	for _, line := range lines {
+		var id string
+		var i int
+		switch {
+		case strings.HasSuffix(line, ": "+T("progress.done")):
+		case strings.HasPrefix(line, "target "):
+			if n, err := fmt.Sscanf(line, "target %s line %d", &id, &i); n != 2 || err != nil || fmt.Sprintf("target %s line %d", id, i) != line {
+				t.Fatalf("torn line %q", line)
+			}
+			printed++
+		default:
+			t.Fatalf("torn line %q", line)
+		}
+	}
+	if printed != 600 {
+		t.Errorf("%d lines printed, want 600", printed)
+	}
+}
+
+func TestTargetName(t *testing.T) {
+	tests := map[string]string{
+		"/home/ana/.minecraft/mods":                         "ana",
+		"/games/MultiMC/instances/Survival/.minecraft/mods": "Survival",
+		"/srv/packs/creative/mods":                          "creative",
+	}
+	for modPath, want := range tests {
+		if got := targetName(modPath); got != want {
+			t.Errorf("targetName(%q) = %q, want %q", modPath, got, want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// errNoAnswer is returned by prompts without a default when there is
+// nobody to answer them.
+var errNoAnswer = errors.New("no answer: the input ended or --defaults is set")
+
+// Prompter asks the player questions and reads the answers a line at a
+// time. Input from a console started through a shortcut or a wrapping
+// .bat file can end a line with a bare \r, or be closed or NUL from the
+// start: lines end at \r, \n or \r\n, and once the input ended every
+// prompt gets its default instead of waiting for an answer that never
+// comes. With --defaults every prompt gets its default without reading.
+type Prompter struct {
+	in *bufio.Reader
+	// defaults answers every prompt with its default.
+	defaults bool
+	// ended is set once the input is closed or failed.
+	ended bool
+	// skipLF drops the \n of a \r\n split between reads.
+	skipLF bool
+}
+
+// NewPrompter returns a prompter reading the answers from in, or none when
+// defaults is set.
+func NewPrompter(in io.Reader, defaults bool) *Prompter {
+	return &Prompter{in: bufio.NewReader(in), defaults: defaults}
+}
+
+// Asks reports whether prompts are answered by someone, not with their
+// defaults.
+func (p *Prompter) Asks() bool {
+	return !p.defaults && !p.ended
+}
+
+// ReadLine reads a line of runes without its end of line, invalid UTF-8
+// replaced. ok is false when there is nothing left to read.
+func (p *Prompter) ReadLine() (line string, ok bool) {
+	if p.ended {
+		return "", false
+	}
+	var b strings.Builder
+	for {
+		r, _, err := p.in.ReadRune()
+		if err != nil {
+			if err != io.EOF {
+				Logf("prompt: reading the input: %s", err)
+			}
+			Logf("prompt: the input ended, the prompts get their defaults")
+			p.ended = true
+			// a last line without its end of line still counts
+			return b.String(), b.Len() > 0
+		}
+		skipLF := p.skipLF
+		p.skipLF = false
+		switch {
+		case r == '\n' && skipLF:
+			continue
+		case r == '\n':
+			return b.String(), true
+		case r == '\r':
+			p.skipLF = true
+			return b.String(), true
+		case r == '\uFEFF' && b.Len() == 0:
+			// the byte order mark some shells put in front of piped input
+			continue
+		}
+		b.WriteRune(r)
+	}
+}
+
+// answer reads the answer to a prompt already printed, trimmed, empty when
+// there is none and ok false when nobody answered.
+func (p *Prompter) answer() (answer string, ok bool) {
+	if p.defaults {
+		return "", false
+	}
+	line, ok := p.ReadLine()
+	return strings.TrimSpace(line), ok
+}
+
+// Confirm asks question, answered yes or no, its hint showing def as the
+// answer of an empty line, of --defaults and once the input ended.
+func (p *Prompter) Confirm(question string, def bool) bool {
+	fmt.Print(question + " " + confirmHint(def) + ": ")
+	answer, ok := p.answer()
+	if !ok {
+		fmt.Println(answerWord(def))
+		return def
+	}
+	if answer == "" {
+		return def
+	}
+	return isYes(answer)
+}
+
+// Assume shows question, answered yes or no, with answer given for the
+// player, as --yes and unattended runs do.
+func (p *Prompter) Assume(question string, def bool, answer bool) bool {
+	fmt.Print(question + " " + confirmHint(def) + ": ")
+	fmt.Println(answerWord(answer))
+	return answer
+}
+
+// Text prints prompt and reads the answer, def when it is empty or
+// nobody answered. The prompt shows the default itself.
+func (p *Prompter) Text(prompt string, def string) string {
+	fmt.Print(prompt)
+	answer, ok := p.answer()
+	if !ok {
+		fmt.Println(def)
+		return def
+	}
+	if answer == "" {
+		return def
+	}
+	return answer
+}
+
+// Line prints prompt and reads the answer of a prompt without a default,
+// errNoAnswer when nobody answered.
+func (p *Prompter) Line(prompt string) (string, error) {
+	fmt.Print(prompt)
+	answer, ok := p.answer()
+	if !ok {
+		fmt.Println()
+		return "", errNoAnswer
+	}
+	return answer, nil
+}
+
+// confirmHint is the hint of a yes or no question, the default in capitals.
+func confirmHint(def bool) string {
+	if def {
+		return T("answer.hint.yes")
+	}
+	return T("answer.hint.no")
+}
+
+// answerWord is the answer shown for one given for the player.
+func answerWord(yes bool) string {
+	if yes {
+		return strings.Split(T("answer.yes"), ",")[0]
+	}
+	return T("answer.no")
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestReadLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		lines []string
+	}{
+		{"LF", "yes\nno\n", []string{"yes", "no"}},
+		{"CRLF", "yes\r\nno\r\n", []string{"yes", "no"}},
+		{"bare CR", "yes\rno\r", []string{"yes", "no"}},
+		{"mixed", "yes\r\nno\rmaybe\n", []string{"yes", "no", "maybe"}},
+		{"empty lines", "\r\n\r\n\n\r", []string{"", "", "", ""}},
+		{"empty line after CR", "yes\r\r\nno", []string{"yes", "", "no"}},
+		{"no end of line", "yes", []string{"yes"}},
+		{"EOF", "", nil},
+		{"byte order mark", "\uFEFFyes\r\n\uFEFFno\r\n", []string{"yes", "no"}},
+		{"byte order mark inside", "y\uFEFFes\n", []string{"y\uFEFFes"}},
+		{"multibyte", "sí\r\nJä\n日本語\r", []string{"sí", "Jä", "日本語"}},
+		{"invalid UTF-8", "s\xed\r\n", []string{"s\uFFFD"}},
+		{"spaces kept", "  yes \r\n", []string{"  yes "}},
+	}
+	for _, test := range tests {
+		// one byte at a time splits every \r\n and multibyte rune between
+		// reads, as a console may
+		for _, split := range []bool{false, true} {
+			in := strings.NewReader(test.input)
+			p := NewPrompter(in, false)
+			if split {
+				p = NewPrompter(iotest.OneByteReader(in), false)
+			}
+			var lines []string
+			for {
+				line, ok := p.ReadLine()
+				if !ok {
+					break
+				}
+				lines = append(lines, line)
+			}
+			if !reflect.DeepEqual(lines, test.lines) {
+				t.Errorf("%s (split %t): read %q, want %q", test.name, split, lines, test.lines)
+			}
+			if p.Asks() {
+				t.Errorf("%s (split %t): still asks after the input ended", test.name, split)
+			}
+		}
+	}
+}
+
+func TestReadLineFails(t *testing.T) {
+	log := captureRunLog(t)
+	p := NewPrompter(iotest.ErrReader(errors.New("the handle is invalid")), false)
+	if line, ok := p.ReadLine(); ok || line != "" {
+		t.Errorf("read %q", line)
+	}
+	if p.Asks() {
+		t.Error("still asks")
+	}
+	if !strings.Contains(log.String(), "prompt: reading the input: the handle is invalid") {
+		t.Errorf("log:\n%s", log)
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	tests := []struct {
+		input string
+		def   bool
+		want  bool
+	}{
+		{"y\r\n", false, true},
+		{"yes\r\n", false, true},
+		{"Y\r", false, true},
+		{"  yes  \n", false, true},
+		{"n\r\n", true, false},
+		{"no\r\n", true, false},
+		{"yes please\r\n", false, false},
+		{"\r\n", true, true},
+		{"\r\n", false, false},
+		{"\r", true, true},
+		{"", true, true},
+		{"", false, false},
+		{"\uFEFFy\r\n", false, true},
+	}
+	for _, test := range tests {
+		var got bool
+		output := captureStdout(t, func() {
+			got = NewPrompter(strings.NewReader(test.input), false).Confirm("Update?", test.def)
+		})
+		if got != test.want {
+			t.Errorf("%q with default %t: %t", test.input, test.def, got)
+		}
+		if !strings.HasPrefix(output, "Update? "+confirmHint(test.def)+": ") {
+			t.Errorf("%q: printed %q", test.input, output)
+		}
+	}
+
+	// nobody answering is told what was answered for them
+	output := captureStdout(t, func() { NewPrompter(strings.NewReader(""), false).Confirm("Update?", true) })
+	if output != "Update? [Y/n]: y\n" {
+		t.Errorf("printed %q", output)
+	}
+
+	useLanguage(t, "es")
+	for input, want := range map[string]bool{"sí\r\n": true, "si\r\n": true, "s\r\n": true, "y\r\n": true, "n\r\n": false, "\r\n": true} {
+		var got bool
+		output := captureStdout(t, func() { got = NewPrompter(strings.NewReader(input), false).Confirm("¿Actualizar?", true) })
+		if got != want || !strings.Contains(output, "[S/n]") {
+			t.Errorf("%q in Spanish: %t, printed %q", input, got, output)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	for input, want := range map[string]string{
+		"1.21.1\r\n":   "1.21.1",
+		" 1.21.1 \r":   "1.21.1",
+		"\r\n":         "1.20.1",
+		"":             "1.20.1",
+		"Jäger\r\n":    "Jäger",
+		"\uFEFF1.21\n": "1.21",
+	} {
+		var got string
+		captureStdout(t, func() {
+			got = NewPrompter(strings.NewReader(input), false).Text("Minecraft version [1.20.1]: ", "1.20.1")
+		})
+		if got != want {
+			t.Errorf("%q: %q, want %q", input, got, want)
+		}
+	}
+
+	p := NewPrompter(strings.NewReader("C:\\Games\\.minecraft\\mods\r\n\r\n"), false)
+	var answers []string
+	var errs []error
+	captureStdout(t, func() {
+		for i := 0; i < 3; i++ {
+			answer, err := p.Line("  > ")
+			answers, errs = append(answers, answer), append(errs, err)
+		}
+	})
+	if !reflect.DeepEqual(answers, []string{"C:\\Games\\.minecraft\\mods", "", ""}) || errs[0] != nil || errs[1] != nil || errs[2] != errNoAnswer {
+		t.Errorf("lines %q, errors %v", answers, errs)
+	}
+}
+
+// TestPrompterDefaults answers every prompt with its default without
+// reading the input.
+func TestPrompterDefaults(t *testing.T) {
+	p := NewPrompter(iotest.ErrReader(errors.New("read the input")), true)
+	if p.Asks() {
+		t.Error("asks with --defaults")
+	}
+	output := captureStdout(t, func() {
+		if !p.Confirm("Update?", true) || p.Confirm("Delete?", false) {
+			t.Error("confirmed something else than the default")
+		}
+		if got := p.Text("Minecraft version [1.20.1]: ", "1.20.1"); got != "1.20.1" {
+			t.Errorf("answered %q", got)
+		}
+		if _, err := p.Line("  > "); err != errNoAnswer {
+			t.Errorf("line: %v", err)
+		}
+	})
+	if output != "Update? [Y/n]: y\nDelete? [y/N]: n\nMinecraft version [1.20.1]: 1.20.1\n  > \n" {
+		t.Errorf("printed %q", output)
+	}
+
+	// --yes answers for the player whatever the default
+	output = captureStdout(t, func() {
+		if !p.Assume("Delete?", false, true) {
+			t.Error("not assumed")
+		}
+	})
+	if output != "Delete? [y/N]: y\n" {
+		t.Errorf("printed %q", output)
+	}
+}
+
+// TestUpdatePromptDefaults runs an interactive update declined in the
+// input, then with --defaults, which updates without reading the answer,
+// and with the input closed from the start, which answers the same.
+func TestUpdatePromptDefaults(t *testing.T) {
+	useRunState(t)
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 1"})
+	if err := os.MkdirAll(u.state, 0755); err != nil {
+		t.Fatal(err)
+	}
+	SaveConfig(ConfFile{MCDirectory: u.mods, MCVersion: "1.20.1"}, filepath.Join(u.state, "clientUpdate.json"))
+	useStdin(t, "n\r\n")
+	if code := exitsWith(func() { runMain(t, "--portable", u.state, "--no-telemetry", "--no-tui") }); code != 0 {
+		t.Errorf("declining exited %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(u.mods, "sodium.jar")); !os.IsNotExist(err) {
+		t.Fatalf("updated though declined: %v", err)
+	}
+
+	useStdin(t, "n\r\n")
+	u.clock.Advance(time.Minute)
+	output := readFile(t, runMain(t, "--portable", u.state, "--no-telemetry", "--no-tui", "--defaults"))
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 1" {
+		t.Fatalf("sodium.jar is %q, output:\n%s", got, output)
+	}
+	if !strings.Contains(output, T("preflight.confirm")) {
+		t.Errorf("the confirmation wasn't shown:\n%s", output)
+	}
+
+	u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 2"})
+	useStdin(t, "")
+	u.clock.Advance(time.Minute)
+	runMain(t, "--portable", u.state, "--no-telemetry", "--no-tui")
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 2" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+	if log := readFile(t, filepath.Join(u.state, "clientUpdate.log")); !strings.Contains(log, "prompt: the input ended, the prompts get their defaults") {
+		t.Errorf("log:\n%s", log)
+	}
+}
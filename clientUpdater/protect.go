@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// localModsDir is the folder in the mods directory for the player's own
+// mods. The updater never touches anything below it.
+const localModsDir = "local"
+
+// keepSuffix marks a single file as the player's: with mymod.jar.keep next
+// to it, mymod.jar is never removed or overwritten.
+const keepSuffix = ".keep"
+
+// Reasons a file is protected, each with a "protected.<reason>" message.
+const (
+	protectedMarker = "marker"
+	protectedLocal  = "local"
+	// protectedExternal is a mod the pack needs but can't ship, see
+	// ExternalMod.
+	protectedExternal = "external"
+)
+
+// ProtectedFile is a file in the mods directory the updater leaves alone.
+type ProtectedFile struct {
+	// Name is the path relative to the mods directory.
+	Name   string
+	Reason string
+}
+
+// ScanProtected returns the files in modPath that are protected, by name.
+// A marker protects its file even while that doesn't exist, so the pack
+// can't put another one there.
+func ScanProtected(modPath string) ([]ProtectedFile, error) {
+	local := filepath.Join(modPath, localModsDir)
+	var files []ProtectedFile
+	err := filepath.WalkDir(modPath, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if p == modPath {
+				return err
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(modPath, p)
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(p, local+string(filepath.Separator)):
+			files = append(files, ProtectedFile{Name: rel, Reason: protectedLocal})
+		case strings.HasSuffix(p, keepSuffix) && len(rel) > len(keepSuffix):
+			name := strings.TrimSuffix(rel, keepSuffix)
+			if _, err := os.Stat(filepath.Join(modPath, name)); os.IsNotExist(err) {
+				Logf("protect: %s marks %s, which doesn't exist", rel, name)
+			}
+			files = append(files, ProtectedFile{Name: name, Reason: protectedMarker})
+		}
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, err
+}
+
+// protectedPaths returns the paths of the protected files in modPath and of
+// their markers.
+func protectedPaths(modPath string, files []ProtectedFile) pathSet {
+	paths := newPathSet(modPath)
+	for _, file := range files {
+		p := filepath.Join(modPath, file.Name)
+		paths.Add(p)
+		if file.Reason == protectedMarker {
+			paths.Add(p + keepSuffix)
+		}
+	}
+	return paths
+}
+
+// String formats the file for the summary.
+func (f ProtectedFile) String() string {
+	return T("protected.file", f.Name, T("protected."+f.Reason))
+}
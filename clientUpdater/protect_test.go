@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// protectedMods are a mods directory holding files of the player's: one
+// marked, a marker for a file that doesn't exist, and the local folder.
+var protectedMods = map[string]string{
+	"mymod.jar":           "my own mod",
+	"mymod.jar.keep":      "",
+	"ghost.jar.keep":      "",
+	"local/tweaks.jar":    "tweaks",
+	"local/more/deep.jar": "deep",
+	"sodium.jar":          "sodium 1",
+	"old.jar":             "old",
+	".keep":               "not a marker of anything",
+}
+
+func TestScanProtected(t *testing.T) {
+	mods := t.TempDir()
+	writeFiles(t, mods, protectedMods)
+	log := captureRunLog(t)
+	files, err := ScanProtected(mods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, file := range files {
+		got = append(got, filepath.ToSlash(file.Name)+" "+file.Reason)
+	}
+	want := "ghost.jar marker|local/more/deep.jar local|local/tweaks.jar local|mymod.jar marker"
+	if strings.Join(got, "|") != want {
+		t.Errorf("protected %q", got)
+	}
+	if !strings.Contains(log.String(), "ghost.jar.keep marks ghost.jar, which doesn't exist") {
+		t.Errorf("log:\n%s", log)
+	}
+	if files, err := ScanProtected(filepath.Join(mods, "missing")); err == nil {
+		t.Errorf("scanned a missing directory: %v", files)
+	}
+}
+
+func TestBackupAndRemoveKeepsProtected(t *testing.T) {
+	minecraft := t.TempDir()
+	writeFiles(t, minecraft, map[string]string{"versions/1.20.1/1.20.1.json": "{}"})
+	mods := filepath.Join(minecraft, "mods")
+	writeFiles(t, mods, protectedMods)
+	backup := filepath.Join(t.TempDir(), "backup")
+	if err := BackupAndRemove(mods, backup, &Journal{Path: filepath.Join(t.TempDir(), "journal.jsonl")}, nil, nil, func(RunProgress) {}); err != nil {
+		t.Fatal(err)
+	}
+	if got := dirNames(t, mods); got != "ghost.jar.keep local mymod.jar mymod.jar.keep" {
+		t.Errorf("left %s", got)
+	}
+	if got := dirNames(t, filepath.Join(mods, localModsDir)); got != "more tweaks.jar" {
+		t.Errorf("left %s of the local folder", got)
+	}
+	if got := dirNames(t, backup); got != ".keep "+backupManifestName+" old.jar sodium.jar" {
+		t.Errorf("backed up %s", got)
+	}
+}
+
+func TestVerifyInstalledIgnoresProtected(t *testing.T) {
+	mods := t.TempDir()
+	writeFiles(t, mods, protectedMods)
+	files, err := ScanInstalledFiles(mods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &InstalledState{Files: append(files, InstalledFile{Name: "ghost.jar", Size: 5, SHA256: sha256Hex([]byte("ghost"))})}
+	// the player changed their own mod since
+	writeFiles(t, mods, map[string]string{"mymod.jar": "my own mod, version 2", "sodium.jar": "changed"})
+	var damaged []string
+	for _, file := range VerifyInstalled(state, mods) {
+		damaged = append(damaged, file.Name)
+	}
+	if got := strings.Join(damaged, " "); got != "sodium.jar" {
+		t.Errorf("damaged %s", got)
+	}
+}
+
+// TestUpdateLeavesProtected updates from a pack shipping files of the
+// names the player protected: their files stay, the pack's aren't
+// installed, and both summaries list them.
+func TestUpdateLeavesProtected(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{
+		"mods/sodium.jar": "sodium 2",
+		"mods/mymod.jar":  "the pack's mymod",
+		"mods/ghost.jar":  "the pack's ghost",
+	})
+	writeFiles(t, u.mods, protectedMods)
+
+	output := readFile(t, u.run(t))
+	if got := dirNames(t, u.mods); got != "ghost.jar.keep local mymod.jar mymod.jar.keep sodium.jar" {
+		t.Errorf("left %s, output:\n%s", got, output)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "mymod.jar")); got != "my own mod" {
+		t.Errorf("mymod.jar is %q", got)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "local", "more", "deep.jar")); got != "deep" {
+		t.Errorf("deep.jar is %q", got)
+	}
+	for _, want := range []string{"4 files of your own are left alone:", "mymod.jar: protected (marker)", filepath.Join("local", "tweaks.jar") + ": protected (mods/local)"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("no %q in the output:\n%s", want, output)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(u.mods, "ghost.jar")); !os.IsNotExist(err) {
+		t.Errorf("ghost.jar was installed: %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Provenance tells where an installed file came from, answering "where did
+// this exact jar come from" when something breaks.
+type Provenance struct {
+	// Source is the pack source the file came from, primarySource for the
+	// pack itself. Empty for external mods.
+	Source      string `json:"source,omitempty"`
+	PackVersion string `json:"packVersion,omitempty"`
+	PackCommit  string `json:"packCommit,omitempty"`
+	// URL is what the file was downloaded from: the pack archive, or the
+	// mod itself for external mods.
+	URL         string    `json:"url,omitempty"`
+	InstalledAt time.Time `json:"installedAt"`
+	// Unknown is set for files installed before provenance was recorded,
+	// see InstalledState.migrateFrom.
+	Unknown bool `json:"unknown,omitempty"`
+}
+
+// installedByUpdater reports whether the updater put file in place, as
+// opposed to the player.
+func installedByUpdater(file InstalledFile) bool {
+	return file.PackPath != "" || file.Source != "" || file.External == externalDownload
+}
+
+// setProvenance records where files came from in archive, installed at
+// now. Files previous recorded with the same content keep the provenance
+// known for them, the content is still what was installed then.
+func setProvenance(files []InstalledFile, archive *PackArchive, previous *InstalledState, now time.Time) {
+	known := map[string]*Provenance{}
+	if previous != nil {
+		for _, file := range previous.Files {
+			if file.Provenance != nil && !file.Provenance.Unknown {
+				known[file.Name+"\x00"+strings.ToLower(file.SHA256)] = file.Provenance
+			}
+		}
+	}
+	for i := range files {
+		file := &files[i]
+		if !installedByUpdater(*file) && file.External != externalUser {
+			continue
+		}
+		if provenance, ok := known[file.Name+"\x00"+strings.ToLower(file.SHA256)]; ok {
+			file.Provenance = provenance
+			continue
+		}
+		provenance := &Provenance{InstalledAt: now}
+		switch {
+		case file.External != "":
+			provenance.URL = file.URL
+		default:
+			provenance.Source = file.Source
+			if provenance.Source == "" {
+				provenance.Source = primarySource
+			}
+			if archive.Download != nil {
+				provenance.URL = archive.Download.URL
+			}
+			if url, ok := archive.SourceURLs[provenance.Source]; ok {
+				provenance.URL = url
+			}
+		}
+		if provenance.Source == primarySource || file.External != "" {
+			provenance.PackCommit = archive.Commit
+			if archive.Manifest != nil {
+				provenance.PackVersion = archive.Manifest.Version
+			}
+		}
+		file.Provenance = provenance
+	}
+}
+
+// migrateFrom upgrades a state read from a file of an older schema: the
+// files the updater installed before provenance was recorded get an
+// unknown one.
+func (s *InstalledState) migrateFrom(schema int) {
+	if schema >= 2 {
+		return
+	}
+	for i := range s.Files {
+		if s.Files[i].Provenance == nil && (installedByUpdater(s.Files[i]) || s.Files[i].External == externalUser) {
+			s.Files[i].Provenance = &Provenance{Unknown: true}
+		}
+	}
+}
+
+// ambiguousNameError is a name matching several owned files.
+type ambiguousNameError struct {
+	query   string
+	matches []string
+}
+
+func (e *ambiguousNameError) Error() string {
+	return fmt.Sprintf("%q matches %d files, name one of them: %s", e.query, len(e.matches), strings.Join(e.matches, ", "))
+}
+
+func (e *ambiguousNameError) Category() string { return categoryUsage }
+
+// unknownNameError is a name matching no owned file.
+type unknownNameError struct {
+	query string
+}
+
+func (e *unknownNameError) Error() string {
+	return fmt.Sprintf("no file the updater installed matches %q; the player's own files have no provenance", e.query)
+}
+
+func (e *unknownNameError) Category() string { return categoryUsage }
+
+// FindOwned returns the file of report named by query, ignoring case: the
+// one whose path below the minecraft directory or file name is query, else
+// the only one containing it. Several files containing it are an
+// *ambiguousNameError listing them.
+func FindOwned(report *OwnedReport, query string) (OwnedFile, error) {
+	q := strings.ToLower(filepath.ToSlash(strings.TrimSpace(query)))
+	var exact, partial []OwnedFile
+	for _, file := range report.Owned {
+		rel := strings.ToLower(ownedRelPath(report, file.Path))
+		switch {
+		case rel == q || path.Base(rel) == q:
+			exact = append(exact, file)
+		case q != "" && strings.Contains(rel, q):
+			partial = append(partial, file)
+		}
+	}
+	matches := exact
+	if len(matches) == 0 {
+		matches = partial
+	}
+	switch len(matches) {
+	case 0:
+		return OwnedFile{}, &unknownNameError{query: query}
+	case 1:
+		return matches[0], nil
+	}
+	names := make([]string, len(matches))
+	for i, file := range matches {
+		names[i] = ownedRelPath(report, file.Path)
+	}
+	sort.Strings(names)
+	return OwnedFile{}, &ambiguousNameError{query: query, matches: names}
+}
+
+// ownedRelPath returns p relative to the minecraft directory of report,
+// slash separated.
+func ownedRelPath(report *OwnedReport, p string) string {
+	if rel, err := filepath.Rel(report.MinecraftPath, p); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(p)
+}
+
+// PrintWhy prints where file came from and whether it changed since.
+func PrintWhy(report *OwnedReport, file OwnedFile) {
+	fmt.Println(T("why.file", ownedRelPath(report, file.Path)))
+	provenance := file.Provenance
+	switch {
+	case provenance == nil || provenance.Unknown:
+		fmt.Println(T("why.unknown"))
+	default:
+		if provenance.Source != "" {
+			fmt.Println(T("why.source", provenance.Source))
+		}
+		if label := packLabel(provenance.PackVersion, provenance.PackCommit); label != "" {
+			fmt.Println(T("why.pack", label))
+		}
+		if provenance.URL != "" {
+			fmt.Println(T("why.url", provenance.URL))
+		}
+		if !provenance.InstalledAt.IsZero() {
+			fmt.Println(T("why.installed", provenance.InstalledAt.Local().Format("2006-01-02 15:04")))
+		}
+	}
+	fmt.Println(T("why.sha256", file.SHA256))
+	fmt.Println(T("why.status." + file.Status))
+}
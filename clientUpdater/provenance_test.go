@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetProvenance(t *testing.T) {
+	earlier := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	now := earlier.AddDate(0, 1, 0)
+	sum := func(content string) string { return sha256Hex([]byte(content)) }
+	archive := &PackArchive{
+		Download:   &Download{URL: "https://github.com/rx13/rxmc-Mods/archive/master.zip"},
+		Commit:     "3f1c2a9e",
+		Manifest:   &PackManifest{Version: "2024.06"},
+		SourceURLs: map[string]string{"shaders": "https://example.com/shaders.zip"},
+	}
+	previous := &InstalledState{Files: []InstalledFile{
+		{Name: "iris.jar", SHA256: strings.ToUpper(sum("iris")), PackPath: "mods/iris.jar", Provenance: &Provenance{Source: primarySource, PackVersion: "2024.05", InstalledAt: earlier}},
+		{Name: "sodium.jar", SHA256: sum("sodium 1"), PackPath: "mods/sodium.jar", Provenance: &Provenance{Source: primarySource, PackVersion: "2024.05", InstalledAt: earlier}},
+		{Name: "lithium.jar", SHA256: sum("lithium"), PackPath: "mods/lithium.jar", Provenance: &Provenance{Unknown: true}},
+	}}
+	files := []InstalledFile{
+		{Name: "iris.jar", SHA256: sum("iris"), PackPath: "mods/iris.jar"},
+		{Name: "sodium.jar", SHA256: sum("sodium 2"), PackPath: "mods/sodium.jar"},
+		{Name: "lithium.jar", SHA256: sum("lithium"), PackPath: "mods/lithium.jar"},
+		{Name: "complementary.jar", SHA256: sum("shaders"), PackPath: "mods/complementary.jar", Source: "shaders"},
+		{Name: "voicechat.jar", SHA256: sum("voicechat"), External: externalDownload, URL: "https://cdn.modrinth.com/voicechat.jar"},
+		{Name: "optifine.jar", SHA256: sum("optifine"), External: externalUser, URL: "https://optifine.net/downloads"},
+		{Name: "mymod.jar", SHA256: sum("mine")},
+	}
+	setProvenance(files, archive, previous, now)
+
+	want := map[string]*Provenance{
+		// unchanged since, what was known then still holds
+		"iris.jar":   {Source: primarySource, PackVersion: "2024.05", InstalledAt: earlier},
+		"sodium.jar": {Source: primarySource, PackVersion: "2024.06", PackCommit: "3f1c2a9e", URL: "https://github.com/rx13/rxmc-Mods/archive/master.zip", InstalledAt: now},
+		// an unknown provenance isn't carried over
+		"lithium.jar":       {Source: primarySource, PackVersion: "2024.06", PackCommit: "3f1c2a9e", URL: "https://github.com/rx13/rxmc-Mods/archive/master.zip", InstalledAt: now},
+		"complementary.jar": {Source: "shaders", URL: "https://example.com/shaders.zip", InstalledAt: now},
+		"voicechat.jar":     {PackVersion: "2024.06", PackCommit: "3f1c2a9e", URL: "https://cdn.modrinth.com/voicechat.jar", InstalledAt: now},
+		"optifine.jar":      {PackVersion: "2024.06", PackCommit: "3f1c2a9e", URL: "https://optifine.net/downloads", InstalledAt: now},
+		"mymod.jar":         nil,
+	}
+	for _, file := range files {
+		if got, want := mustJSON(t, file.Provenance), mustJSON(t, want[file.Name]); got != want {
+			t.Errorf("%s came from %s, want %s", file.Name, got, want)
+		}
+	}
+
+	// a first update knows nothing earlier
+	files = []InstalledFile{{Name: "iris.jar", SHA256: sum("iris"), PackPath: "mods/iris.jar"}}
+	setProvenance(files, &PackArchive{}, nil, now)
+	if got := mustJSON(t, files[0].Provenance); got != mustJSON(t, &Provenance{Source: primarySource, InstalledAt: now}) {
+		t.Errorf("first update: %s", got)
+	}
+}
+
+// TestInstalledStateMigration reads installed states written before
+// provenance was recorded: the files the updater installed get an unknown
+// provenance, the player's none.
+func TestInstalledStateMigration(t *testing.T) {
+	sum := func(content string) string { return sha256Hex([]byte(content)) }
+	p := filepath.Join(t.TempDir(), "clientUpdate-installed.json")
+	old := &InstalledState{PackCommit: "3f1c2a9e", Files: []InstalledFile{
+		{Name: "mymod.jar", SHA256: sum("mine")},
+		{Name: "optifine.jar", SHA256: sum("optifine"), External: externalUser},
+		{Name: "sodium.jar", SHA256: sum("sodium"), PackPath: "mods/sodium.jar"},
+		{Name: "voicechat.jar", SHA256: sum("voicechat"), External: externalDownload},
+	}}
+	if err := WriteStateFile(p, 1, old); err != nil {
+		t.Fatal(err)
+	}
+	state, err := ReadInstalledState(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range state.Files {
+		unknown := file.Provenance != nil && file.Provenance.Unknown
+		if unknown != (file.Name != "mymod.jar") {
+			t.Errorf("%s came from %s", file.Name, mustJSON(t, file.Provenance))
+		}
+	}
+
+	// a state of the current schema is read as it is
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	state.Files[2].Provenance = &Provenance{Source: primarySource, InstalledAt: at}
+	state.Files[3].Provenance = nil
+	if err := WriteInstalledState(p, state); err != nil {
+		t.Fatal(err)
+	}
+	state, err = ReadInstalledState(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Files[2].Provenance.Unknown || !state.Files[2].Provenance.InstalledAt.Equal(at) || state.Files[3].Provenance != nil {
+		t.Errorf("read %s", mustJSON(t, state.Files))
+	}
+
+	// and so is one from before envelopes
+	var legacy InstalledState
+	if err := ReadStateFile(filepath.Join("testdata", "state", "installed-legacy.json"), installedSchema, &legacy); err != nil {
+		t.Fatal(err)
+	}
+	if provenance := legacy.Files[0].Provenance; provenance == nil || !provenance.Unknown {
+		t.Errorf("sodium.jar came from %s", mustJSON(t, provenance))
+	}
+}
+
+func TestFindOwned(t *testing.T) {
+	minecraft := filepath.Join(t.TempDir(), ".minecraft")
+	report := &OwnedReport{MinecraftPath: minecraft}
+	for _, rel := range []string{"mods/sodium.jar", "mods/sodium-extra.jar", "mods/Iris.jar", "config/sodium-options.json", "config/chat.json", "mods/sub/chat.json"} {
+		report.Owned = append(report.Owned, OwnedFile{Path: filepath.Join(minecraft, filepath.FromSlash(rel))})
+	}
+	tests := []struct {
+		query string
+		found string
+		err   string
+	}{
+		{query: "mods/sodium.jar", found: "mods/sodium.jar"},
+		{query: "sodium.jar", found: "mods/sodium.jar"},
+		{query: " SODIUM.JAR ", found: "mods/sodium.jar"},
+		{query: "iris.jar", found: "mods/Iris.jar"},
+		{query: "iris", found: "mods/Iris.jar"},
+		{query: "extra", found: "mods/sodium-extra.jar"},
+		{query: "options", found: "config/sodium-options.json"},
+		{query: filepath.Join("config", "chat.json"), found: "config/chat.json"},
+		{query: "sub/chat", found: "mods/sub/chat.json"},
+		{query: "sodium", err: `"sodium" matches 3 files, name one of them: config/sodium-options.json, mods/sodium-extra.jar, mods/sodium.jar`},
+		{query: "chat.json", err: `"chat.json" matches 2 files, name one of them: config/chat.json, mods/sub/chat.json`},
+		{query: "lithium", err: `no file the updater installed matches "lithium"; the player's own files have no provenance`},
+		{query: "", err: `no file the updater installed matches ""; the player's own files have no provenance`},
+	}
+	for _, test := range tests {
+		file, err := FindOwned(report, test.query)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err || ErrorCategory(err) != categoryUsage {
+				t.Errorf("%q: found %s, error %v", test.query, file.Path, err)
+			}
+			continue
+		}
+		if err != nil || ownedRelPath(report, file.Path) != test.found {
+			t.Errorf("%q: found %s, error %v", test.query, file.Path, err)
+		}
+	}
+}
+
+// TestUpdateWhy asks where files of an update came from: by exact, other
+// case and partial names, after one was edited, as JSON and after an
+// older updater recorded them.
+func TestUpdateWhy(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{
+		"pack.json":             `{"version": "2024.06"}`,
+		"mods/sodium.jar":       "sodium",
+		"mods/sodium-extra.jar": "sodium extra",
+		"mods/iris.jar":         "iris",
+	})
+	u.run(t)
+	installedAt := u.clock.Now()
+	writeFiles(t, u.mods, map[string]string{"iris.jar": "iris, edited", "mymod.jar": "mine"})
+
+	output := readFile(t, u.run(t, "why", "SODIUM.JAR"))
+	for _, want := range []string{
+		"mods/sodium.jar\n",
+		T("why.source", primarySource),
+		T("why.pack", "2024.06"),
+		T("why.url", "https://github.com/rx13/rxmc-Mods/archive/master.zip"),
+		T("why.installed", installedAt.Local().Format("2006-01-02 15:04")),
+		T("why.sha256", sha256Hex([]byte("sodium"))),
+		T("why.status.ok"),
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("no %q in the output:\n%s", want, output)
+		}
+	}
+	if output := readFile(t, u.run(t, "why", "extra")); !strings.Contains(output, "mods/sodium-extra.jar\n") {
+		t.Errorf("extra:\n%s", output)
+	}
+	if output := readFile(t, u.run(t, "why", "iris")); !strings.Contains(output, T("why.status.modified")) {
+		t.Errorf("iris:\n%s", output)
+	}
+
+	useRunState(t)
+	logPath := filepath.Join(u.state, "clientUpdate.log")
+	for query, want := range map[string]string{
+		"sodium": "matches 2 files, name one of them: mods/sodium-extra.jar, mods/sodium.jar",
+		"mymod":  `no file the updater installed matches "mymod"`,
+	} {
+		if code := exitsWith(func() { u.run(t, "why", query) }); code != exitUsage {
+			t.Errorf("%s: exited %d", query, code)
+		}
+		if log := readFile(t, logPath); !strings.Contains(log, want) {
+			t.Errorf("%s: log:\n%s", query, log)
+		}
+	}
+	if code := exitsWith(func() { u.run(t, "why") }); code != exitUsage {
+		t.Errorf("no name: exited %d", code)
+	}
+
+	machine := useMachineOut(t)
+	u.run(t, "why", "--json", "sodium.jar")
+	var file OwnedFile
+	if err := json.Unmarshal(machine.Bytes(), &file); err != nil {
+		t.Fatalf("%s in the output for programs:\n%s", err, machine)
+	}
+	if file.Provenance == nil || file.Provenance.Source != primarySource || file.Provenance.PackVersion != "2024.06" || !file.Provenance.InstalledAt.Equal(installedAt) {
+		t.Errorf("file %s", mustJSON(t, file))
+	}
+
+	// files an older updater installed came from somewhere unknown
+	installedPath := filepath.Join(u.state, "clientUpdate-installed.json")
+	state, err := ReadInstalledState(installedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range state.Files {
+		state.Files[i].Provenance = nil
+	}
+	if err := WriteStateFile(installedPath, 1, state); err != nil {
+		t.Fatal(err)
+	}
+	output = readFile(t, u.run(t, "why", "sodium.jar"))
+	if !strings.Contains(output, T("why.unknown")) || strings.Contains(output, T("why.source", primarySource)) {
+		t.Errorf("migrated:\n%s", output)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// recoveryMarker is written before a mods directory is changed and removed
+// once the change is complete or rolled back. Finding one means a run died
+// in between, leaving the directory in a state nobody knows.
+type recoveryMarker struct {
+	Run     string    `json:"run"`
+	Phase   string    `json:"phase"`
+	Started time.Time `json:"started"`
+	ModPath string    `json:"modPath"`
+	// Archive is the SHA-256 of the pack archive that was being installed.
+	Archive   string `json:"archive"`
+	BackupDir string `json:"backupDir"`
+
+	// path is where the marker was read from.
+	path string
+}
+
+// recoveryMarkerPath returns the marker of modPath in dir, one per mods
+// directory since targets are updated at the same time.
+func recoveryMarkerPath(dir string, modPath string) string {
+	sum := sha256.Sum256([]byte(modPath))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// writeRecoveryMarker records, synced to disk, that the mods directory of
+// marker is about to be changed.
+func writeRecoveryMarker(dir string, marker recoveryMarker) (string, error) {
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return "", err
+	}
+	content, err := json.Marshal(marker)
+	if err != nil {
+		return "", err
+	}
+	p := recoveryMarkerPath(dir, marker.ModPath)
+	return p, writeSynced(p, content)
+}
+
+// removeRecoveryMarker records that the change is complete.
+func removeRecoveryMarker(p string) {
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		Logf("removing recovery marker %s: %s", p, err)
+		return
+	}
+	syncDir(filepath.Dir(p))
+}
+
+// ReadRecoveryMarkers returns the markers runs that died left in dir.
+// Unreadable markers are logged and skipped.
+func ReadRecoveryMarkers(dir string) []recoveryMarker {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var markers []recoveryMarker
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		p := filepath.Join(dir, entry.Name())
+		content, err := ioutil.ReadFile(p)
+		var marker recoveryMarker
+		if err == nil {
+			err = json.Unmarshal(content, &marker)
+		}
+		if err != nil || marker.ModPath == "" {
+			Logf("recovery: unreadable marker %s: %v", p, err)
+			continue
+		}
+		marker.path = p
+		markers = append(markers, marker)
+	}
+	return markers
+}
+
+// Recover explains every interrupted change found in dir and fixes it: the
+// mods directory is restored to before the change, or the change is left
+// for this run's update to complete, and it can be compared with the last
+// completed update first. Unattended runs always restore, which never
+// loses anything: what the interrupted run put in place is in the journal.
+func Recover(dir string, prompter *Prompter, interactive bool, journalPath string, installedPath string) {
+	for _, marker := range ReadRecoveryMarkers(dir) {
+		Logf("recovery: run %s was interrupted while %s in %s at %s", marker.Run, marker.Phase, marker.ModPath, marker.Started.Format(time.RFC3339))
+		fmt.Println(T("recovery.found", marker.ModPath, T("phase."+marker.Phase), marker.Started.Local().Format("2006-01-02 15:04"), marker.Run))
+		choice := "r"
+		for interactive {
+			choice = strings.ToLower(prompter.Text(T("recovery.options")+": ", "r"))
+			if choice != "v" {
+				break
+			}
+			verifyAfterInterruption(marker.ModPath, installedPath)
+		}
+		if !interactive {
+			fmt.Println(T("recovery.unattended"))
+		}
+		switch choice {
+		case "c":
+			Logf("recovery: leaving %s for this update to complete", marker.ModPath)
+			fmt.Println(T("recovery.complete"))
+			removeRecoveryMarker(marker.path)
+		case "r":
+			if err := restoreInterrupted(marker, journalPath); err != nil {
+				Logf("recovery: restoring %s: %s", marker.ModPath, err)
+				fmt.Println(T("recovery.failed", err, marker.BackupDir))
+				continue
+			}
+			fmt.Println(T("recovery.restored", marker.ModPath))
+			removeRecoveryMarker(marker.path)
+		default:
+			fmt.Println(T("recovery.kept"))
+		}
+	}
+}
+
+// restoreInterrupted rolls back what the interrupted run changed in its
+// mods directory and discards what it staged.
+func restoreInterrupted(marker recoveryMarker, journalPath string) error {
+	locked := ModsDirLocked(marker.ModPath)
+	if err := unlockForUpdate(marker.ModPath); err != nil {
+		return err
+	}
+	defer relockAfterUpdate(marker.ModPath, locked)
+	if err := RollbackRun(&Journal{Path: journalPath, Run: marker.Run}, marker.ModPath, marker.Started); err != nil {
+		return err
+	}
+	return removeRetrying(marker.ModPath + stagingSuffix)
+}
+
+// verifyAfterInterruption compares the mods directory with the last
+// completed update.
+func verifyAfterInterruption(modPath string, installedPath string) {
+	state, err := ReadInstalledState(installedPath)
+	if err != nil || state == nil {
+		fmt.Println(T("recovery.verify.none"))
+		return
+	}
+	damaged := VerifyInstalled(state, modPath)
+	if len(damaged) == 0 {
+		fmt.Println(T("recovery.verify.ok"))
+		return
+	}
+	names := make([]string, len(damaged))
+	for i, file := range damaged {
+		names[i] = file.Name
+	}
+	fmt.Println(T("recovery.verify.damaged", len(damaged), strings.Join(names, ", ")))
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// treeSnapshot is the content of every file and directory below a root,
+// nil for directories.
+type treeSnapshot map[string]*string
+
+func snapshotTree(t *testing.T, root string) treeSnapshot {
+	t.Helper()
+	tree := treeSnapshot{}
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == root {
+			return err
+		}
+		if info.IsDir() {
+			tree[p] = nil
+			return nil
+		}
+		content := readFile(t, p)
+		tree[p] = &content
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// restore puts root back the way the snapshot found it.
+func (tree treeSnapshot) restore(t *testing.T, root string) {
+	t.Helper()
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(root, entry.Name())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for p, content := range tree {
+		if content == nil {
+			err = os.MkdirAll(p, 0755)
+		} else if err = os.MkdirAll(filepath.Dir(p), 0755); err == nil {
+			err = os.WriteFile(p, []byte(*content), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// crashUpdate runs an update of u that crashes the first time at reports
+// a phase and progress: everything below the update's root is left as it
+// was at that point, however much the run still did after it.
+func crashUpdate(t *testing.T, u *fakeUpdate, at func(phase string, progress RunProgress) bool) {
+	t.Helper()
+	useRunState(t)
+	root := filepath.Dir(u.minecraft)
+	var crashed treeSnapshot
+	WatchProgress(func(phase string, progress RunProgress) {
+		if crashed == nil && at(phase, progress) {
+			crashed = snapshotTree(t, root)
+		}
+	})
+	u.run(t)
+	WatchProgress(nil)
+	if crashed == nil {
+		t.Fatal("the update never got to the crash")
+	}
+	crashed.restore(t, root)
+}
+
+// crashPoints are the points of the destructive phases of an update a
+// crash can leave the mods directory at, for an update of two files.
+var crashPoints = []struct {
+	name string
+	at   func(phase string, progress RunProgress) bool
+}{
+	{"backup started", func(phase string, progress RunProgress) bool {
+		return phase == phaseBackup && progress.Total > 0 && progress.Files == 0
+	}},
+	{"backed up", func(phase string, progress RunProgress) bool {
+		return phase == phaseBackup && progress.FilesTotal > 0 && progress.Files == progress.FilesTotal
+	}},
+	{"swap started", func(phase string, progress RunProgress) bool {
+		return phase == phaseSwap && progress.Total > 0 && progress.Done == 0
+	}},
+	{"half swapped", func(phase string, progress RunProgress) bool {
+		return phase == phaseSwap && progress.Total > 0 && progress.Done == 1
+	}},
+}
+
+// TestRecoverAfterCrash crashes an update at every point of its
+// destructive phases; the recovery an unattended run does restores the
+// mods from before it and leaves no marker.
+func TestRecoverAfterCrash(t *testing.T) {
+	for _, point := range crashPoints {
+		t.Run(point.name, func(t *testing.T) {
+			u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 1", "mods/iris.jar": "iris 1"})
+			u.run(t)
+			u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 2", "mods/lithium.jar": "lithium"})
+			crashUpdate(t, u, point.at)
+			recoveryDir := filepath.Join(u.state, "clientUpdate-recovery")
+			markers := ReadRecoveryMarkers(recoveryDir)
+			if len(markers) != 1 || markers[0].ModPath != u.mods || markers[0].Phase != phaseSwap {
+				t.Fatalf("markers %+v", markers)
+			}
+
+			output := captureStdout(t, func() {
+				Recover(recoveryDir, NewPrompter(strings.NewReader(""), true), false, filepath.Join(u.state, "clientUpdate-journal.jsonl"), filepath.Join(u.state, "clientUpdate-installed.json"))
+			})
+			if !strings.Contains(output, "Restoring the mods from before that update") || !strings.Contains(output, "Restored "+u.mods) {
+				t.Errorf("output:\n%s", output)
+			}
+			if got := dirNames(t, u.mods); got != "iris.jar sodium.jar" || readFile(t, filepath.Join(u.mods, "sodium.jar")) != "sodium 1" {
+				t.Errorf("restored %s", got)
+			}
+			if _, err := os.Stat(u.mods + stagingSuffix); !os.IsNotExist(err) {
+				t.Errorf("staging left: %v", err)
+			}
+			if markers := ReadRecoveryMarkers(recoveryDir); len(markers) != 0 {
+				t.Errorf("markers left %+v", markers)
+			}
+		})
+	}
+}
+
+// TestUpdateAfterCrash updates again after an update crashed: the run
+// recovers first and then installs the pack.
+func TestUpdateAfterCrash(t *testing.T) {
+	for _, point := range crashPoints {
+		t.Run(point.name, func(t *testing.T) {
+			u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 1", "mods/iris.jar": "iris 1"})
+			u.run(t)
+			u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 2", "mods/lithium.jar": "lithium"})
+			crashUpdate(t, u, point.at)
+
+			output := readFile(t, u.run(t))
+			if !strings.Contains(output, "was interrupted while") || !strings.Contains(output, "Restored "+u.mods) {
+				t.Errorf("output:\n%s", output)
+			}
+			if got := dirNames(t, u.mods); got != "lithium.jar sodium.jar" || readFile(t, filepath.Join(u.mods, "sodium.jar")) != "sodium 2" {
+				t.Errorf("installed %s, output:\n%s", got, output)
+			}
+			if markers := ReadRecoveryMarkers(filepath.Join(u.state, "clientUpdate-recovery")); len(markers) != 0 {
+				t.Errorf("markers left %+v", markers)
+			}
+		})
+	}
+}
+
+// TestRecoverInteractive has the player compare the mods directory first
+// and then leave it to the update, or decide nothing.
+func TestRecoverInteractive(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 1", "mods/iris.jar": "iris 1"})
+	u.run(t)
+	u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 2", "mods/lithium.jar": "lithium"})
+	crashUpdate(t, u, crashPoints[3].at)
+	recoveryDir := filepath.Join(u.state, "clientUpdate-recovery")
+	recover := func(answers string) string {
+		return captureStdout(t, func() {
+			Recover(recoveryDir, NewPrompter(strings.NewReader(answers), false), true, filepath.Join(u.state, "clientUpdate-journal.jsonl"), filepath.Join(u.state, "clientUpdate-installed.json"))
+		})
+	}
+
+	before := snapshotTree(t, u.mods)
+	output := recover("x\n")
+	if !strings.Contains(output, "you'll be asked again next time") || len(ReadRecoveryMarkers(recoveryDir)) != 1 {
+		t.Errorf("output:\n%s", output)
+	}
+	output = recover("v\nc\n")
+	if !strings.Contains(output, "files differ from the last completed update: ") || !strings.Contains(output, "This update replaces the mods directory completely") {
+		t.Errorf("output:\n%s", output)
+	}
+	if len(ReadRecoveryMarkers(recoveryDir)) != 0 || mustJSON(t, snapshotTree(t, u.mods)) != mustJSON(t, before) {
+		t.Error("completing changed the mods or kept the marker")
+	}
+}
+
+func TestReadRecoveryMarkers(t *testing.T) {
+	dir := t.TempDir()
+	mods := filepath.Join(dir, "mods")
+	p, err := writeRecoveryMarker(dir, recoveryMarker{Run: "run", Phase: phaseSwap, ModPath: mods, Archive: "0123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != recoveryMarkerPath(dir, mods) {
+		t.Errorf("written to %s", p)
+	}
+	writeFiles(t, dir, map[string]string{"broken.json": "{", "empty.json": "{}", "notes.txt": "not a marker"})
+	log := captureRunLog(t)
+	markers := ReadRecoveryMarkers(dir)
+	if len(markers) != 1 || markers[0].Run != "run" || markers[0].path != p {
+		t.Errorf("markers %+v", markers)
+	}
+	if strings.Count(log.String(), "recovery: unreadable marker") != 2 {
+		t.Errorf("log:\n%s", log)
+	}
+	removeRecoveryMarker(p)
+	if got := dirNames(t, dir); got != "broken.json empty.json notes.txt" {
+		t.Errorf("left %s", got)
+	}
+	if markers := ReadRecoveryMarkers(filepath.Join(dir, "missing")); len(markers) != 0 {
+		t.Errorf("markers of a missing directory %+v", markers)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// packRawURL serves single files of the pack repository at a commit.
+const packRawURL = "https://raw.githubusercontent.com/rx13/rxmc-Mods/%s/%s"
+
+// PrintRepairFailed tells which files of state couldn't be repaired, and
+// where to get those the player provides.
+func PrintRepairFailed(state *InstalledState, failed []string) {
+	for _, name := range failed {
+		page := ""
+		for _, file := range state.Files {
+			if file.Name == name && file.External == externalUser {
+				page = file.URL
+			}
+		}
+		if page != "" {
+			fmt.Println(T("repair.external.user", name, page))
+		} else {
+			fmt.Println(T("repair.failed", name))
+		}
+	}
+}
+
+// Repair puts back the pack files of the last update that are missing or
+// damaged, without touching anything else. Each file is fetched on its own
+// when the pack commit is known; whatever is left is extracted from the
+// pack archive, downloaded to archivePath from urls. Damaged files are kept
+// in backupDir. External mods are downloaded from their authors again,
+// except those the player provides, which can only be reported. It returns
+// the names of the repaired files and of those that couldn't be repaired.
+func Repair(state *InstalledState, modPath string, urls []string, archivePath string, backupDir string, journal *Journal) (repaired []string, failed []string, err error) {
+	// files the player added themselves are theirs to look after
+	var damaged []InstalledFile
+	for _, file := range VerifyInstalled(state, modPath) {
+		switch {
+		case file.External == externalUser:
+			Logf("repair: %s, provided by the player, is missing or changed", file.Name)
+			failed = append(failed, file.Name)
+		case file.PackPath != "" || file.External == externalDownload:
+			damaged = append(damaged, file)
+		}
+	}
+	if len(damaged) == 0 {
+		return nil, failed, nil
+	}
+
+	// move damaged files out of the way first, nothing else is touched
+	for _, file := range damaged {
+		p := filepath.Join(modPath, file.Name)
+		sum, err := fileSHA256(p)
+		if err != nil {
+			continue
+		}
+		backup := filepath.Join(backupDir, file.Name)
+		if err := moveFile(p, backup); err != nil {
+			return nil, nil, err
+		}
+		if err := journal.Record(JournalEntry{Action: journalDelete, Path: p, SHA256: sum, Backup: backup}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var remaining []InstalledFile
+	for _, file := range damaged {
+		url := file.URL
+		if file.External == "" {
+			url = fmt.Sprintf(packRawURL, state.PackCommit, file.PackPath)
+		}
+		if file.External == "" && (state.PackCommit == "" || file.PackPath == "") {
+			remaining = append(remaining, file)
+			continue
+		}
+		p := filepath.Join(modPath, file.Name)
+		if err := os.MkdirAll(filepath.Dir(p), dirPerm); err != nil {
+			return repaired, failed, err
+		}
+		if _, err := downloadFile(p, url, file.SHA256); err != nil {
+			Logf("repair: fetching %s: %s", url, err)
+			// external mods are nowhere else
+			if file.External != "" {
+				failed = append(failed, file.Name)
+			} else {
+				remaining = append(remaining, file)
+			}
+			continue
+		}
+		if err := journal.Record(JournalEntry{Action: journalAdd, Path: p, SHA256: file.SHA256}); err != nil {
+			return repaired, failed, err
+		}
+		repaired = append(repaired, file.Name)
+	}
+	if len(remaining) == 0 {
+		return repaired, failed, nil
+	}
+
+	archive, err := FetchPack(archivePath, urls, "", state.MCVersion)
+	if err != nil {
+		return repaired, failed, err
+	}
+	defer RemoveTemporary(archive.Path)
+	names := make([]string, len(remaining))
+	for i, file := range remaining {
+		names[i] = file.Name
+	}
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+	if _, err := archive.ExtractMods(modPath, func(rel string) bool { return wanted[rel] }, false); err != nil {
+		return repaired, failed, err
+	}
+	for _, file := range remaining {
+		p := filepath.Join(modPath, file.Name)
+		sum, err := fileSHA256(p)
+		if err != nil || sum != file.SHA256 {
+			// the pack no longer has this version of the file
+			if err == nil {
+				os.Remove(p)
+			}
+			failed = append(failed, file.Name)
+			continue
+		}
+		if err := journal.Record(JournalEntry{Action: journalAdd, Path: p, SHA256: sum}); err != nil {
+			return repaired, failed, err
+		}
+		repaired = append(repaired, file.Name)
+	}
+	return repaired, failed, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// resumeState is stored next to a partial download and tells a later run
+// whether the partial file can be continued.
+type resumeState struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	// Offset is the size of the partial file, filled in when loading.
+	Offset int64 `json:"-"`
+}
+
+func resumeStatePath(partial string) string {
+	return partial + ".json"
+}
+
+// validator is the If-Range value, a strong ETag being preferred.
+func (r *resumeState) validator() string {
+	if r.ETag != "" {
+		return r.ETag
+	}
+	return r.LastModified
+}
+
+// loadResumeState returns the resume state of partial if it belongs to url
+// and has something to resume, nil otherwise.
+func loadResumeState(partial string, url string) *resumeState {
+	info, err := os.Stat(partial)
+	if err != nil || info.Size() == 0 {
+		return nil
+	}
+	content, err := ioutil.ReadFile(resumeStatePath(partial))
+	if err != nil {
+		return nil
+	}
+	var state resumeState
+	if json.Unmarshal(content, &state) != nil || state.URL != url || state.validator() == "" {
+		return nil
+	}
+	state.Offset = info.Size()
+	return &state
+}
+
+// saveResumeState records how to resume a download that is starting, as
+// long as the server supports ranges and gave us a validator to check the
+// file didn't change in between.
+func saveResumeState(partial string, url string, resp *http.Response) {
+	state := resumeState{URL: url, LastModified: resp.Header.Get("Last-Modified")}
+	// weak ETags can't be used with If-Range
+	if etag := resp.Header.Get("ETag"); !strings.HasPrefix(etag, "W/") {
+		state.ETag = etag
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" || state.validator() == "" {
+		return
+	}
+	content, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(resumeStatePath(partial), content, filePerm); err != nil {
+		Logf("download: unable to save resume state: %s", err)
+	}
+}
+
+func clearResumeState(partial string) {
+	os.Remove(resumeStatePath(partial))
+}
+
+// discardPartial removes a partial download that can't be resumed, so the
+// next request starts over without a range.
+func discardPartial(partial string) {
+	clearResumeState(partial)
+	os.Remove(partial)
+}
+
+// completedPartial renames a partial download holding all of the total
+// bytes, and matching expectedSHA256 when set, to dest. It returns nil when
+// the partial file isn't complete.
+func completedPartial(partial string, dest string, url string, resume *resumeState, total int64, expectedSHA256 string) *Download {
+	if total < 0 || resume.Offset != total {
+		return nil
+	}
+	sum, err := fileSHA256(partial)
+	if err != nil || expectedSHA256 != "" && !strings.EqualFold(sum, expectedSHA256) {
+		return nil
+	}
+	if err := renameSynced(partial, dest); err != nil {
+		Logf("download: %s", err)
+		return nil
+	}
+	clearResumeState(partial)
+	Logf("download: %s was downloaded completely before, %d bytes", url, total)
+	return &Download{
+		URL:           url,
+		Size:          total,
+		ContentLength: total,
+		SHA256:        sum,
+		ETag:          resume.ETag,
+		LastModified:  resume.LastModified,
+	}
+}
+
+// contentRangeTotal returns the size of the whole file a 206 or 416
+// response tells, or -1.
+func contentRangeTotal(resp *http.Response) int64 {
+	// Content-Range: bytes 1000-1999/2000 or bytes */2000
+	value := resp.Header.Get("Content-Range")
+	slash := strings.LastIndex(value, "/")
+	if slash < 0 {
+		return -1
+	}
+	total, err := strconv.ParseInt(value[slash+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return total
+}
+
+// contentRangeStart returns where the body of a 206 response starts, or -1.
+func contentRangeStart(resp *http.Response) int64 {
+	// Content-Range: bytes 1000-1999/2000
+	value := strings.TrimPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	dash := strings.Index(value, "-")
+	if dash < 0 {
+		return -1
+	}
+	start, err := strconv.ParseInt(value[:dash], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
+}
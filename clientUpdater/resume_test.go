@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rangeServer serves content with an ETag, honouring ranges, and records
+// the Range header of every request.
+type rangeServer struct {
+	mu      sync.Mutex
+	content []byte
+	etag    string
+	ranges  []string
+	// handle, when set, answers instead.
+	handle func(w http.ResponseWriter, r *http.Request)
+}
+
+func (s *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	s.mu.Unlock()
+	if s.handle != nil {
+		s.handle(w, r)
+		return
+	}
+	w.Header().Set("ETag", s.etag)
+	http.ServeContent(w, r, "pack.zip", time.Time{}, bytes.NewReader(s.content))
+}
+
+// interruptedDownload leaves the first n bytes of content in the partial
+// file of dest, as a download of url stopped there does.
+func interruptedDownload(t *testing.T, dest string, url string, etag string, content []byte) {
+	t.Helper()
+	partial := dest + partialSuffix
+	state, _ := json.Marshal(resumeState{URL: url, ETag: etag})
+	if err := os.WriteFile(resumeStatePath(partial), state, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(partial, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDownloadResume(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 1000))
+	const url = "https://example.com/pack.zip"
+	tests := []struct {
+		name    string
+		partial []byte
+		etag    string
+		// handle, when set, answers instead of the file server.
+		handle func(w http.ResponseWriter, r *http.Request)
+		// ranges are the Range headers sent.
+		ranges []string
+	}{
+		{
+			name:    "resumed",
+			partial: content[:4000],
+			etag:    `"v1"`,
+			ranges:  []string{"bytes=4000-"},
+		},
+		{
+			name:    "changed on the server",
+			partial: content[:4000],
+			etag:    `"v0"`,
+			ranges:  []string{"bytes=4000-"},
+		},
+		{
+			// stopped between the last byte and the rename
+			name:    "complete",
+			partial: content,
+			etag:    `"v1"`,
+			ranges:  []string{fmt.Sprintf("bytes=%d-", len(content))},
+		},
+		{
+			name:    "longer than the file",
+			partial: append(append([]byte{}, content...), "garbage"...),
+			etag:    `"v1"`,
+			ranges:  []string{fmt.Sprintf("bytes=%d-", len(content)+7), ""},
+		},
+		{
+			name:    "complete but damaged",
+			partial: append([]byte("X"), content[1:]...),
+			etag:    `"v1"`,
+			ranges:  []string{fmt.Sprintf("bytes=%d-", len(content)), ""},
+		},
+		{
+			name:    "range elsewhere",
+			partial: content[:4000],
+			etag:    `"v1"`,
+			handle: func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Range") == "" {
+					w.Write(content)
+					return
+				}
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(content)-1, len(content)))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(content)
+			},
+			ranges: []string{"bytes=4000-", ""},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &rangeServer{content: content, etag: `"v1"`, handle: test.handle}
+			useTestServer(t, server)
+			dest := filepath.Join(t.TempDir(), "pack.zip")
+			interruptedDownload(t, dest, url, test.etag, test.partial)
+
+			download, err := downloadFile(dest, url, sha256Hex(content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readFile(t, dest); got != string(content) {
+				t.Errorf("downloaded %d bytes, want the %d of the file", len(got), len(content))
+			}
+			if download.Size != int64(len(content)) || download.SHA256 != sha256Hex(content) {
+				t.Errorf("download of %d bytes with SHA-256 %s", download.Size, download.SHA256)
+			}
+			for _, leftover := range []string{dest + partialSuffix, resumeStatePath(dest + partialSuffix)} {
+				if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+					t.Errorf("%s is left: %v", filepath.Base(leftover), err)
+				}
+			}
+			if fmt.Sprint(server.ranges) != fmt.Sprint(test.ranges) {
+				t.Errorf("requested ranges %q, want %q", server.ranges, test.ranges)
+			}
+		})
+	}
+}
+
+func TestDownloadResumeRecoversFromUnsatisfiableRange(t *testing.T) {
+	content := []byte(strings.Repeat("x", 2000))
+	const url = "https://example.com/pack.zip"
+	// every ranged request fails: the first attempt must give up on the
+	// partial file so the next one isn't stuck with it
+	server := &rangeServer{handle: func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			w.Header().Set("Content-Range", "bytes */*")
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Write(content)
+	}}
+	useTestServer(t, server)
+	dest := filepath.Join(t.TempDir(), "pack.zip")
+	interruptedDownload(t, dest, url, `"v1"`, content[:500])
+
+	if _, err := downloadFile(dest, url, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, dest); got != string(content) {
+		t.Errorf("downloaded %d bytes, want %d", len(got), len(content))
+	}
+	if fmt.Sprint(server.ranges) != fmt.Sprint([]string{"bytes=500-", ""}) {
+		t.Errorf("requested ranges %q", server.ranges)
+	}
+}
+
+func TestContentRange(t *testing.T) {
+	tests := []struct {
+		header       string
+		start, total int64
+	}{
+		{"bytes 1000-1999/2000", 1000, 2000},
+		{"bytes */2000", -1, 2000},
+		{"bytes 0-99/*", 0, -1},
+		{"", -1, -1},
+	}
+	for _, test := range tests {
+		resp := &http.Response{Header: http.Header{"Content-Range": {test.header}}}
+		if start, total := contentRangeStart(resp), contentRangeTotal(resp); start != test.start || total != test.total {
+			t.Errorf("%q: start %d, total %d, want %d and %d", test.header, start, total, test.start, test.total)
+		}
+	}
+}
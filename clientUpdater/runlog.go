@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// runLog records what each run did so players can send it along with bug
+// reports. It discards everything until OpenRunLog is called.
+var runLog = log.New(ioutil.Discard, "", log.LstdFlags)
+
+// OpenRunLog appends the run log to the file at logPath.
+func OpenRunLog(logPath string) (*os.File, error) {
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, filePerm)
+	if err != nil {
+		return nil, err
+	}
+	runLog.SetOutput(logFile)
+	return logFile, nil
+}
+
+// logSecrets are values that must never show up in the log.
+var (
+	logSecretsMu sync.Mutex
+	logSecrets   []string
+)
+
+// AddLogSecret makes sure value is redacted from everything logged.
+func AddLogSecret(value string) {
+	if strings.TrimSpace(value) == "" {
+		return
+	}
+	logSecretsMu.Lock()
+	logSecrets = append(logSecrets, value)
+	logSecretsMu.Unlock()
+}
+
+// Redact replaces every secret in s.
+func Redact(s string) string {
+	logSecretsMu.Lock()
+	defer logSecretsMu.Unlock()
+	for _, secret := range logSecrets {
+		s = strings.Replace(s, secret, "[redacted]", -1)
+	}
+	return s
+}
+
+// Logf writes a line to the run log.
+func Logf(format string, v ...interface{}) {
+	runLog.Print(Redact(fmt.Sprintf(format, v...)))
+}
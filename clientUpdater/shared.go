@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// updateLockName is the file in the minecraft directory telling that an
+// update of it is underway, and on which machine. A minecraft directory
+// shared between machines by a sync tool carries it to the others, whose
+// updaters then hold off.
+const updateLockName = "rxmc-updater.lock"
+
+// updateLockStale is how old the lock of another machine may get before it
+// is taken for one left behind by a run that died there.
+const updateLockStale = 2 * time.Hour
+
+// UpdateLock is the content of the lock file.
+type UpdateLock struct {
+	Host    string    `json:"host"`
+	PID     int       `json:"pid"`
+	Run     string    `json:"run"`
+	Started time.Time `json:"started"`
+}
+
+// conflictPatterns match the copies sync tools make of a file changed on
+// two machines at once, the name of the original being the first group
+// followed by the second: Syncthing's "mod.sync-conflict-20240101-120000-
+// ABCDEFG.jar", and the "mod (conflicted copy 2024-01-01).jar" of Dropbox
+// and Nextcloud.
+var conflictPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^(.+)\.sync-conflict-\d{8}-\d{6}-[A-Z0-9]{7}(\.[^.]+)?$`),
+	regexp.MustCompile(`(?i)^(.+) \([^()]*conflicted copy[^()]*\)(\.[^.]+)?$`),
+}
+
+// ConflictBase returns the name of the file a sync tool made name a
+// conflict copy of, ok is false for names that aren't conflict copies.
+func ConflictBase(name string) (base string, ok bool) {
+	for _, pattern := range conflictPatterns {
+		if m := pattern.FindStringSubmatch(name); m != nil {
+			return m[1] + m[2], true
+		}
+	}
+	return "", false
+}
+
+// SyncConflict is a conflict copy found below a directory, both paths
+// relative to it.
+type SyncConflict struct {
+	Path string
+	Base string
+}
+
+// FindSyncConflicts lists the conflict copies below dir, sorted by path.
+func FindSyncConflicts(dir string) ([]SyncConflict, error) {
+	var conflicts []SyncConflict
+	err := filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		base, ok := ConflictBase(entry.Name())
+		if !ok {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		conflicts = append(conflicts, SyncConflict{Path: rel, Base: path.Join(path.Dir(rel), base)})
+		return nil
+	})
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Path < conflicts[j].Path })
+	return conflicts, err
+}
+
+// Contention is what tells that another machine is updating a minecraft
+// directory too: the lock of an updater running there, conflict copies in
+// the mods directory, or both.
+type Contention struct {
+	// Lock is the lock of the other machine, nil when there is none or
+	// it is stale.
+	Lock      *UpdateLock
+	Conflicts []SyncConflict
+}
+
+// Found reports whether there is any sign of another machine.
+func (c *Contention) Found() bool {
+	return c.Lock != nil || len(c.Conflicts) > 0
+}
+
+// Print lists the signs found.
+func (c *Contention) Print(modPath string) {
+	fmt.Println(T("shared.warning"))
+	if c.Lock != nil {
+		fmt.Println(T("shared.lock", c.Lock.Host, c.Lock.Started.Local().Format("2006-01-02 15:04")))
+	}
+	if len(c.Conflicts) > 0 {
+		fmt.Println(T("shared.conflicts", len(c.Conflicts), modPath))
+		for _, conflict := range c.Conflicts {
+			fmt.Println(T("shared.item", conflict.Path))
+		}
+	}
+}
+
+// DetectContention looks for signs of another machine updating the
+// minecraft directory minecraftPath with its mods in modPath. host is the
+// name of this machine, its own locks are left over from an earlier run
+// here.
+func DetectContention(minecraftPath string, modPath string, host string) *Contention {
+	c := &Contention{}
+	if lock, err := readUpdateLock(filepath.Join(minecraftPath, updateLockName)); err == nil {
+		age := clock.Now().Sub(lock.Started)
+		switch {
+		case lock.Host == host:
+		case age > updateLockStale:
+			Logf("shared: ignoring the lock of %s from %s, it is stale", lock.Host, lock.Started.Format(time.RFC3339))
+		default:
+			c.Lock = lock
+		}
+	} else if !os.IsNotExist(err) {
+		Logf("shared: reading the update lock: %s", err)
+	}
+	conflicts, err := FindSyncConflicts(modPath)
+	if err != nil && !os.IsNotExist(err) {
+		Logf("shared: looking for sync conflicts: %s", err)
+	}
+	c.Conflicts = conflicts
+	return c
+}
+
+func readUpdateLock(p string) (*UpdateLock, error) {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var lock UpdateLock
+	if err := json.Unmarshal(content, &lock); err != nil {
+		return nil, fmt.Errorf("%s is not an update lock: %s", p, err)
+	}
+	return &lock, nil
+}
+
+// contentionError is another machine changing the minecraft directory
+// while this run updates it.
+type contentionError struct {
+	dir   string
+	other *UpdateLock
+}
+
+func (e *contentionError) Error() string {
+	if e.other == nil {
+		return fmt.Sprintf("the update lock in %s was removed while updating, another machine sharing the directory may be updating it; stopped before changing more", e.dir)
+	}
+	return fmt.Sprintf("%s started updating %s at the same time; stopped before changing more, run the update again once it is done", e.other.Host, e.dir)
+}
+
+func (e *contentionError) Category() string { return categoryLocal }
+
+// SyncGuard holds the update lock of a minecraft directory for a run and
+// watches it, the sentinel of the directory: when it changes underneath
+// the run, another machine took the directory over.
+type SyncGuard struct {
+	dir     string
+	path    string
+	lock    UpdateLock
+	written os.FileInfo
+}
+
+// AcquireUpdateLock writes the lock of run to minecraftPath, replacing a
+// stale one or one the player chose to override.
+func AcquireUpdateLock(minecraftPath string, host string, run string) (*SyncGuard, error) {
+	g := &SyncGuard{
+		dir:  minecraftPath,
+		path: filepath.Join(minecraftPath, updateLockName),
+		lock: UpdateLock{Host: host, PID: os.Getpid(), Run: run, Started: clock.Now()},
+	}
+	content, err := json.Marshal(g.lock)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeAtomic(g.path, content); err != nil {
+		return nil, err
+	}
+	if g.written, err = os.Stat(g.path); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Check re-stats the lock and reports a *contentionError once it was
+// changed or removed by someone else. A nil guard checks nothing.
+func (g *SyncGuard) Check() error {
+	if g == nil {
+		return nil
+	}
+	info, err := os.Stat(g.path)
+	if err == nil && info.ModTime().Equal(g.written.ModTime()) && info.Size() == g.written.Size() {
+		return nil
+	}
+	if os.IsNotExist(err) {
+		Logf("shared: the update lock %s is gone", g.path)
+		return &contentionError{dir: g.dir}
+	}
+	lock, err := readUpdateLock(g.path)
+	if err != nil {
+		return err
+	}
+	if lock.Host == g.lock.Host && lock.Run == g.lock.Run {
+		g.written = info
+		return nil
+	}
+	Logf("shared: %s took the update lock, run %s", lock.Host, lock.Run)
+	return &contentionError{dir: g.dir, other: lock}
+}
+
+// Release removes the lock unless another machine took it over since.
+func (g *SyncGuard) Release() {
+	if g == nil {
+		return
+	}
+	if lock, err := readUpdateLock(g.path); err != nil || lock.Host != g.lock.Host || lock.Run != g.lock.Run {
+		return
+	}
+	if err := os.Remove(g.path); err != nil {
+		Logf("shared: removing the update lock: %s", err)
+	}
+}
+
+// ManagedConflicts returns the conflict copies in modPath of files the
+// updater installed, state listing them, whose original is still there:
+// the original is the updater's and the copy only a leftover of two
+// machines updating at once. Protected files are the player's and never
+// count.
+func ManagedConflicts(modPath string, state *InstalledState) ([]SyncConflict, error) {
+	if state == nil {
+		return nil, nil
+	}
+	conflicts, err := FindSyncConflicts(modPath)
+	if err != nil {
+		return nil, err
+	}
+	protected, err := ScanProtected(modPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	paths := protectedPaths(modPath, protected)
+	names := NamesIn(modPath)
+	managed := map[string]bool{}
+	for _, file := range state.Files {
+		managed[names.Key(file.Name)] = true
+	}
+	var found []SyncConflict
+	for _, conflict := range conflicts {
+		p := filepath.Join(modPath, filepath.FromSlash(conflict.Path))
+		if !managed[names.Key(conflict.Base)] || paths.Has(p) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(modPath, filepath.FromSlash(conflict.Base))); err != nil {
+			continue
+		}
+		found = append(found, conflict)
+	}
+	return found, nil
+}
+
+// CleanConflicts moves the conflict copies in modPath to backupDir,
+// journaling each one. It returns how many were moved.
+func CleanConflicts(modPath string, conflicts []SyncConflict, backupDir string, journal *Journal) (int, error) {
+	moved := 0
+	for _, conflict := range conflicts {
+		p := filepath.Join(modPath, filepath.FromSlash(conflict.Path))
+		sum, err := fileSHA256(p)
+		if err != nil {
+			return moved, err
+		}
+		backup := filepath.Join(backupDir, filepath.FromSlash(conflict.Path))
+		if err := moveFile(p, backup); err != nil {
+			return moved, err
+		}
+		if err := journal.Record(JournalEntry{Action: journalDelete, Path: p, SHA256: sum, Backup: backup}); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// CleanUpSyncConflicts reports the conflict copies of the updater's files
+// left in modPath after an update and, when the player agrees, moves them
+// to backupDir. Unattended runs only report them. It reports whether any
+// were moved, the installed state then has to be recorded again.
+func CleanUpSyncConflicts(installedPath string, modPath string, backupDir string, journal *Journal, prompter *Prompter, interactive bool) bool {
+	state, err := ReadInstalledState(installedPath)
+	if err != nil {
+		Logf("shared: reading the installed state: %s", err)
+		return false
+	}
+	conflicts, err := ManagedConflicts(modPath, state)
+	if err != nil {
+		Logf("shared: looking for sync conflicts: %s", err)
+		return false
+	}
+	if len(conflicts) == 0 {
+		return false
+	}
+	fmt.Println(T("shared.cleanup", len(conflicts), modPath))
+	for _, conflict := range conflicts {
+		fmt.Println(T("shared.cleanup.item", conflict.Path, conflict.Base))
+	}
+	if !interactive {
+		Warn(warnShared, T("shared.cleanup.warn", len(conflicts), modPath))
+		return false
+	}
+	if !prompter.Confirm(T("shared.cleanup.confirm"), false) {
+		return false
+	}
+	moved, err := CleanConflicts(modPath, conflicts, backupDir, journal)
+	if err != nil {
+		Logf("shared: moving the sync conflicts: %s", err)
+		Warn(warnShared, T("shared.cleanup.failed", err))
+	}
+	if moved > 0 {
+		Logf("shared: moved %d sync conflicts to %s", moved, backupDir)
+		fmt.Println(T("shared.cleaned", moved, backupDir))
+	}
+	return moved > 0
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConflictBase(t *testing.T) {
+	tests := map[string]string{
+		"sodium.sync-conflict-20240601-120000-ABCDEFG.jar":   "sodium.jar",
+		"options.sync-conflict-20240601-120000-7XK2QZ1":      "options",
+		"sodium (conflicted copy 2024-06-01).jar":            "sodium.jar",
+		"sodium (ana's conflicted copy 2024-06-01).jar":      "sodium.jar",
+		"fabric-api-0.92 (Conflicted Copy).jar":              "fabric-api-0.92.jar",
+		"sodium.sync-conflict-20240601-120000-abcdefg.jar":   "",
+		"sodium.sync-conflict-2024-120000-ABCDEFG.jar":       "",
+		"sodium (copy).jar":                                  "",
+		"sodium.jar":                                         "",
+		"sync-conflict-20240601-120000-ABCDEFG.jar":          "",
+		"sodium.jar.sync-conflict-20240601-120000-ABCDEFG.x": "sodium.jar.x",
+	}
+	for name, want := range tests {
+		base, ok := ConflictBase(name)
+		if ok != (want != "") || base != want {
+			t.Errorf("ConflictBase(%q) = %q, %t, want %q", name, base, ok, want)
+		}
+	}
+}
+
+func TestFindSyncConflicts(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"sodium.jar": "sodium",
+		"sodium.sync-conflict-20240601-120000-ABCDEFG.jar":                 "sodium",
+		"iris (conflicted copy 2024-06-01).jar":                            "iris",
+		"config/sodium-options.json":                                       "{}",
+		"config/sodium-options.sync-conflict-20240601-120000-ABCDEFG.json": "{}",
+	})
+	conflicts, err := FindSyncConflicts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SyncConflict{
+		{Path: "config/sodium-options.sync-conflict-20240601-120000-ABCDEFG.json", Base: "config/sodium-options.json"},
+		{Path: "iris (conflicted copy 2024-06-01).jar", Base: "iris.jar"},
+		{Path: "sodium.sync-conflict-20240601-120000-ABCDEFG.jar", Base: "sodium.jar"},
+	}
+	if mustJSON(t, conflicts) != mustJSON(t, want) {
+		t.Errorf("found %+v, want %+v", conflicts, want)
+	}
+}
+
+// writeUpdateLock writes the lock of an updater on host to the minecraft
+// directory dir.
+func writeUpdateLock(t *testing.T, dir string, host string, run string, started time.Time) {
+	t.Helper()
+	content, err := json.Marshal(UpdateLock{Host: host, PID: 4242, Run: run, Started: started})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, updateLockName), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDetectContention(t *testing.T) {
+	fake := useFakeClock(t)
+	tests := []struct {
+		name      string
+		lockHost  string
+		lockAge   time.Duration
+		conflicts bool
+		lock      bool
+	}{
+		{name: "nothing"},
+		{name: "other machine", lockHost: "attic-pc", lockAge: 10 * time.Minute, lock: true},
+		{name: "own lock", lockHost: "desk-pc", lockAge: 10 * time.Minute},
+		{name: "stale lock", lockHost: "attic-pc", lockAge: updateLockStale + time.Minute},
+		{name: "conflicts", conflicts: true},
+		{name: "both", lockHost: "attic-pc", lockAge: time.Minute, conflicts: true, lock: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			minecraft := t.TempDir()
+			mods := filepath.Join(minecraft, "mods")
+			files := map[string]string{"sodium.jar": "sodium"}
+			if test.conflicts {
+				files["sodium.sync-conflict-20240601-120000-ABCDEFG.jar"] = "sodium"
+			}
+			writeFiles(t, mods, files)
+			if test.lockHost != "" {
+				writeUpdateLock(t, minecraft, test.lockHost, "run", fake.Now().Add(-test.lockAge))
+			}
+
+			c := DetectContention(minecraft, mods, "desk-pc")
+			if (c.Lock != nil) != test.lock || (len(c.Conflicts) > 0) != test.conflicts {
+				t.Errorf("lock %+v, conflicts %+v", c.Lock, c.Conflicts)
+			}
+			if c.Found() != (test.lock || test.conflicts) {
+				t.Errorf("found: %t", c.Found())
+			}
+		})
+	}
+}
+
+func TestDetectContentionIgnoresCorruptLock(t *testing.T) {
+	useFakeClock(t)
+	minecraft := t.TempDir()
+	writeFiles(t, minecraft, map[string]string{updateLockName: "half a lo"})
+	if c := DetectContention(minecraft, filepath.Join(minecraft, "mods"), "desk-pc"); c.Found() {
+		t.Errorf("contention %+v", c)
+	}
+}
+
+func TestSyncGuard(t *testing.T) {
+	fake := useFakeClock(t)
+	minecraft := t.TempDir()
+	guard, err := AcquireUpdateLock(minecraft, "desk-pc", "run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := guard.Check(); err != nil {
+		t.Fatalf("the untouched lock: %s", err)
+	}
+
+	// the sync tool carrying the lock of another machine here
+	writeUpdateLock(t, minecraft, "attic-pc", "run-2", fake.Now())
+	err = guard.Check()
+	var contention *contentionError
+	if !errors.As(err, &contention) || contention.other == nil || contention.other.Host != "attic-pc" {
+		t.Fatalf("the lock taken over: %v", err)
+	}
+	if ErrorCategory(err) != categoryLocal || !strings.Contains(err.Error(), "attic-pc") {
+		t.Errorf("%s: %s", ErrorCategory(err), err)
+	}
+	guard.Release()
+	if lock, err := readUpdateLock(filepath.Join(minecraft, updateLockName)); err != nil || lock.Host != "attic-pc" {
+		t.Errorf("released the other machine's lock: %+v, %v", lock, err)
+	}
+
+	// the lock removed by the other machine finishing
+	os.Remove(filepath.Join(minecraft, updateLockName))
+	if err := guard.Check(); !errors.As(err, &contention) || contention.other != nil {
+		t.Errorf("the lock removed: %v", err)
+	}
+}
+
+func TestSyncGuardRewrittenBySelf(t *testing.T) {
+	fake := useFakeClock(t)
+	minecraft := t.TempDir()
+	guard, err := AcquireUpdateLock(minecraft, "desk-pc", "run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the sync tool writing the lock back, with a new mtime
+	writeUpdateLock(t, minecraft, "desk-pc", "run-1", fake.Now().Add(time.Second))
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(minecraft, updateLockName), later, later)
+	if err := guard.Check(); err != nil {
+		t.Errorf("the lock written back: %s", err)
+	}
+	guard.Release()
+	if _, err := os.Stat(filepath.Join(minecraft, updateLockName)); !os.IsNotExist(err) {
+		t.Errorf("the lock is left: %v", err)
+	}
+
+	var none *SyncGuard
+	if err := none.Check(); err != nil {
+		t.Errorf("a nil guard: %s", err)
+	}
+	none.Release()
+}
+
+// conflictedMods writes a mods directory holding conflict copies: of a
+// file the updater installed, of one it installed that is gone, of a
+// player's file and of a protected one. It returns the directory and the
+// path of its installed state.
+func conflictedMods(t *testing.T) (string, string) {
+	t.Helper()
+	root := t.TempDir()
+	mods := filepath.Join(root, "mods")
+	writeFiles(t, mods, map[string]string{
+		"sodium.jar": "sodium 2",
+		"sodium.sync-conflict-20240601-120000-ABCDEFG.jar":       "sodium 1",
+		"iris (conflicted copy 2024-06-01).jar":                  "iris",
+		"own.jar":                                                "own",
+		"own.sync-conflict-20240601-120000-ABCDEFG.jar":          "own",
+		"lithium.jar":                                            "lithium",
+		"lithium.sync-conflict-20240601-120000-ABCDEFG.jar":      "lithium",
+		"lithium.sync-conflict-20240601-120000-ABCDEFG.jar.keep": "",
+	})
+	installedPath := filepath.Join(root, "installed.json")
+	state := &InstalledState{Files: []InstalledFile{
+		{Name: "sodium.jar"}, {Name: "iris.jar"}, {Name: "lithium.jar"},
+	}}
+	if err := WriteInstalledState(installedPath, state); err != nil {
+		t.Fatal(err)
+	}
+	return mods, installedPath
+}
+
+func TestManagedConflicts(t *testing.T) {
+	mods, installedPath := conflictedMods(t)
+	state, err := ReadInstalledState(installedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflicts, err := ManagedConflicts(mods, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SyncConflict{{Path: "sodium.sync-conflict-20240601-120000-ABCDEFG.jar", Base: "sodium.jar"}}
+	if mustJSON(t, conflicts) != mustJSON(t, want) {
+		t.Errorf("managed conflicts %+v, want %+v", conflicts, want)
+	}
+	if conflicts, err := ManagedConflicts(mods, nil); err != nil || conflicts != nil {
+		t.Errorf("without a state: %+v, %v", conflicts, err)
+	}
+}
+
+func TestCleanUpSyncConflicts(t *testing.T) {
+	useFakeClock(t)
+	conflict := "sodium.sync-conflict-20240601-120000-ABCDEFG.jar"
+	tests := []struct {
+		name        string
+		interactive bool
+		answer      string
+		moved       bool
+	}{
+		{"confirmed", true, "y\n", true},
+		{"declined", true, "n\n", false},
+		{"unattended", false, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mods, installedPath := conflictedMods(t)
+			backup := filepath.Join(t.TempDir(), "backup")
+			journal := &Journal{Path: filepath.Join(t.TempDir(), "journal.jsonl"), Run: "run"}
+			prompter := NewPrompter(strings.NewReader(test.answer), false)
+
+			moved := CleanUpSyncConflicts(installedPath, mods, backup, journal, prompter, test.interactive)
+			if moved != test.moved {
+				t.Fatalf("moved: %t", moved)
+			}
+			_, err := os.Stat(filepath.Join(mods, conflict))
+			if os.IsNotExist(err) != test.moved {
+				t.Errorf("the conflict copy is left: %t", err == nil)
+			}
+			for _, name := range []string{"sodium.jar", "own.sync-conflict-20240601-120000-ABCDEFG.jar", "lithium.sync-conflict-20240601-120000-ABCDEFG.jar"} {
+				if _, err := os.Stat(filepath.Join(mods, name)); err != nil {
+					t.Errorf("%s: %s", name, err)
+				}
+			}
+			if !test.moved {
+				return
+			}
+			if got := readFile(t, filepath.Join(backup, conflict)); got != "sodium 1" {
+				t.Errorf("backed up %q", got)
+			}
+			entries, err := ReadJournal(journal.Path)
+			if err != nil || len(entries) != 1 || entries[0].Action != journalDelete || entries[0].Backup != filepath.Join(backup, conflict) {
+				t.Errorf("journaled %+v, %v", entries, err)
+			}
+		})
+	}
+}
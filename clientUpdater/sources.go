@@ -0,0 +1,273 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// primarySource names the pack the updater is built for in summaries.
+const primarySource = "base"
+
+// PackSource is another pack repository merged into the mods directory,
+// e.g. a small server-specific addon on top of the shared base pack.
+type PackSource struct {
+	// Name identifies the source in summaries, "source 1" and so on when
+	// empty.
+	Name    string   `json:"name,omitempty"`
+	URL     string   `json:"url"`
+	Mirrors []string `json:"mirrors,omitempty"`
+	SHA256  string   `json:"sha256,omitempty"`
+}
+
+// SourceArchive is a fetched and validated source.
+type SourceArchive struct {
+	Name    string
+	Archive *PackArchive
+}
+
+// FetchSources downloads and validates the extra sources in order, into
+// dir. A source being unavailable fails them all: merging without it would
+// remove its mods. The sources fetched until then are kept for the next run
+// to continue with.
+func FetchSources(fetcher *Fetcher, sources []PackSource, dir string, mcVersion string) ([]SourceArchive, error) {
+	var fetched []SourceArchive
+	for i, source := range sources {
+		name := source.Name
+		if name == "" {
+			name = fmt.Sprintf("source %d", i+1)
+		}
+		dest := filepath.Join(dir, fmt.Sprintf("clientUpdate-source-%d.zip", i+1))
+		fmt.Println(T("sources.fetch", name))
+		archive, err := fetcher.Fetch(dest, append([]string{source.URL}, source.Mirrors...), source.SHA256, mcVersion)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		fetched = append(fetched, SourceArchive{Name: name, Archive: archive})
+	}
+	return fetched, nil
+}
+
+// MergeOverride is a file of an earlier source replaced by the file of the
+// same name from a later one.
+type MergeOverride struct {
+	Name   string
+	Source string
+	By     string
+}
+
+// MergeConflict is a mod id shipped by more than one source under
+// different file names, which would install the mod twice.
+type MergeConflict struct {
+	ID    string
+	Files []string
+}
+
+func (c MergeConflict) String() string {
+	return fmt.Sprintf("%s: %s", c.ID, strings.Join(c.Files, ", "))
+}
+
+// mergeConflictError lists every conflict found.
+type mergeConflictError struct {
+	conflicts []MergeConflict
+}
+
+func (e *mergeConflictError) Error() string {
+	lines := make([]string, len(e.conflicts))
+	for i, c := range e.conflicts {
+		lines[i] = c.String()
+	}
+	return "mods shipped by more than one source: " + strings.Join(lines, "; ")
+}
+
+// mergedEntry is a mod file of the merged pack.
+type mergedEntry struct {
+	source string
+	file   *zip.File
+}
+
+// MergePacks writes one pack archive to dest holding everything of the
+// first source and the mod files of all of them, later sources replacing
+// files of the same name. Files are recorded in the archive's Sources.
+// Mods whose id comes from more than one source under different names are
+// reported as a mergeConflictError before anything is written. Manifest
+// transforms are only taken from the first source; mod policies of later
+// sources override those of earlier ones.
+func MergePacks(dest string, sources []SourceArchive) (*PackArchive, []MergeOverride, error) {
+	readers := make([]*zip.ReadCloser, len(sources))
+	defer func() {
+		for _, r := range readers {
+			if r != nil {
+				r.Close()
+			}
+		}
+	}()
+
+	mods := map[string]mergedEntry{}
+	var overrides []MergeOverride
+	for i, source := range sources {
+		r, err := OpenPackArchive(source.Archive.Path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", source.Name, err)
+		}
+		readers[i] = r
+		for _, f := range r.File {
+			if f.FileInfo().IsDir() || !isModEntry(f.Name, source.Archive.ModFolder) {
+				continue
+			}
+			name := path.Base(f.Name)
+			if previous, ok := mods[name]; ok && previous.source != source.Name {
+				overrides = append(overrides, MergeOverride{Name: name, Source: previous.source, By: source.Name})
+			}
+			mods[name] = mergedEntry{source: source.Name, file: f}
+		}
+	}
+	names := make([]string, 0, len(mods))
+	for name := range mods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if err := checkMergeConflicts(names, mods); err != nil {
+		return nil, nil, err
+	}
+
+	primary := sources[0].Archive
+	// every mod ends up next to the first mod of the first source
+	prefix := ""
+	for _, f := range readers[0].File {
+		if !f.FileInfo().IsDir() && isModEntry(f.Name, primary.ModFolder) {
+			prefix = path.Dir(f.Name) + "/"
+			break
+		}
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return nil, nil, err
+	}
+	w := zip.NewWriter(countingWriter{out})
+	copyEntry := func(f *zip.File, name string) error {
+		header := f.FileHeader
+		header.Name = name
+		// names were decoded when the archive was opened
+		header.Flags |= 0x800
+		raw, err := f.OpenRaw()
+		if err != nil {
+			return err
+		}
+		dst, err := w.CreateRaw(&header)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(dst, raw)
+		return err
+	}
+	for _, f := range readers[0].File {
+		if !f.FileInfo().IsDir() && isModEntry(f.Name, primary.ModFolder) {
+			continue
+		}
+		if err = copyEntry(f, f.Name); err != nil {
+			break
+		}
+	}
+	fileSources := map[string]string{}
+	for _, name := range names {
+		if err != nil {
+			break
+		}
+		err = copyEntry(mods[name].file, prefix+name)
+		fileSources[name] = mods[name].source
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return nil, nil, err
+	}
+
+	merged := *primary
+	merged.Path = dest
+	merged.ModEntries = len(names)
+	merged.Sources = fileSources
+	merged.SourceURLs = map[string]string{}
+	for _, source := range sources {
+		if source.Archive.Download != nil {
+			merged.SourceURLs[source.Name] = source.Archive.Download.URL
+		}
+	}
+	merged.Manifest = mergeManifests(sources)
+	return &merged, overrides, nil
+}
+
+// checkMergeConflicts reads the mod ids of the merged files and reports
+// ids coming from more than one source.
+func checkMergeConflicts(names []string, mods map[string]mergedEntry) error {
+	byID := map[string][]string{}
+	sourcesOf := map[string]map[string]bool{}
+	var ids []string
+	for _, name := range names {
+		entry := mods[name]
+		info, err := readArchivedModInfo(entry.file)
+		if err != nil || info.ID == "" {
+			continue
+		}
+		if _, ok := byID[info.ID]; !ok {
+			ids = append(ids, info.ID)
+			sourcesOf[info.ID] = map[string]bool{}
+		}
+		byID[info.ID] = append(byID[info.ID], entry.source+": "+name)
+		sourcesOf[info.ID][entry.source] = true
+	}
+	var conflicts []MergeConflict
+	for _, id := range ids {
+		if len(sourcesOf[id]) > 1 {
+			conflicts = append(conflicts, MergeConflict{ID: id, Files: byID[id]})
+		}
+	}
+	if len(conflicts) > 0 {
+		return &mergeConflictError{conflicts: conflicts}
+	}
+	return nil
+}
+
+// mergeManifests is the manifest of the first source with the mod
+// policies of all of them. Declared contents no longer apply to the
+// merged mods and are dropped.
+func mergeManifests(sources []SourceArchive) *PackManifest {
+	var merged PackManifest
+	found := false
+	for _, source := range sources {
+		found = found || source.Archive.Manifest != nil
+	}
+	if !found {
+		return nil
+	}
+	if m := sources[0].Archive.Manifest; m != nil {
+		merged = *m
+	}
+	merged.Contents = nil
+	policies := map[string]string{}
+	for _, source := range sources {
+		m := source.Archive.Manifest
+		if m == nil {
+			continue
+		}
+		for id, policy := range m.ModPolicies {
+			policies[id] = policy
+		}
+		if source.Archive != sources[0].Archive && len(m.Transforms) > 0 {
+			Logf("sources: transforms of %s are ignored, only the first source's apply", source.Name)
+		}
+	}
+	merged.ModPolicies = policies
+	return &merged
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// sourceArchive validates a pack of files, by entry name below the
+// archive's top folder, as the source named.
+func sourceArchive(t *testing.T, name string, files map[string]string) SourceArchive {
+	t.Helper()
+	entries := map[string]string{}
+	for file, content := range files {
+		entries["rxmc-"+name+"-master/"+file] = content
+	}
+	p := filepath.Join(t.TempDir(), name+".zip")
+	writeZip(t, p, entries)
+	archive, err := ValidateArchive(p, "1.20.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return SourceArchive{Name: name, Archive: archive}
+}
+
+// zipContents reads the archive at p, by entry name.
+func zipContents(t *testing.T, p string) map[string]string {
+	t.Helper()
+	r, err := zip.OpenReader(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	files := map[string]string{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		_, err = io.Copy(&b, rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = b.String()
+	}
+	return files
+}
+
+func TestMergePacks(t *testing.T) {
+	sources := []SourceArchive{
+		sourceArchive(t, "base", map[string]string{
+			"mods/sodium.jar":    "sodium",
+			"mods/voicechat.jar": "voicechat 1",
+			"config/sodium.json": "{}",
+			"pack.json":          `{"modPolicies": {"sodium": "floating"}}`,
+		}),
+		sourceArchive(t, "server", map[string]string{
+			"mods/voicechat.jar": "voicechat 2",
+			"mods/rxmc-sync.jar": "sync",
+			"config/ignored.txt": "only the first source's other files",
+			"pack.json":          `{"modPolicies": {"rxmc-sync": "pinned"}}`,
+		}),
+	}
+	dest := filepath.Join(t.TempDir(), "merged.zip")
+	merged, overrides, err := MergePacks(dest, sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"rxmc-base-master/config/sodium.json": "{}",
+		"rxmc-base-master/pack.json":          `{"modPolicies": {"sodium": "floating"}}`,
+		"rxmc-base-master/mods/rxmc-sync.jar": "sync",
+		"rxmc-base-master/mods/sodium.jar":    "sodium",
+		"rxmc-base-master/mods/voicechat.jar": "voicechat 2",
+	}
+	if got := zipContents(t, dest); mustJSON(t, got) != mustJSON(t, want) {
+		t.Errorf("merged %v", got)
+	}
+	if got := mustJSON(t, overrides); got != mustJSON(t, []MergeOverride{{Name: "voicechat.jar", Source: "base", By: "server"}}) {
+		t.Errorf("overrides %s", got)
+	}
+	wantSources := map[string]string{"rxmc-sync.jar": "server", "sodium.jar": "base", "voicechat.jar": "server"}
+	if merged.Path != dest || merged.ModEntries != 3 || mustJSON(t, merged.Sources) != mustJSON(t, wantSources) {
+		t.Errorf("merged %s with %d mods from %v", merged.Path, merged.ModEntries, merged.Sources)
+	}
+	if got := merged.Manifest.ModPolicies; len(got) != 2 || got["rxmc-sync"] != modPinned || got["sodium"] != modFloating {
+		t.Errorf("policies %v", got)
+	}
+}
+
+func TestMergePacksConflict(t *testing.T) {
+	sources := []SourceArchive{
+		sourceArchive(t, "base", map[string]string{"mods/sodium-0.5.jar": modJar(t, "sodium", "0.5"), "mods/iris.jar": modJar(t, "iris", "1.7")}),
+		sourceArchive(t, "server", map[string]string{"mods/sodium-fork.jar": modJar(t, "sodium", "0.6")}),
+		// the same id under the same name is an override, not a conflict
+		sourceArchive(t, "events", map[string]string{"mods/iris.jar": modJar(t, "iris", "1.8")}),
+	}
+	dest := filepath.Join(t.TempDir(), "merged.zip")
+	_, _, err := MergePacks(dest, sources)
+	var conflicts *mergeConflictError
+	if !errors.As(err, &conflicts) {
+		t.Fatalf("merged: %v", err)
+	}
+	if got := mustJSON(t, conflicts.conflicts); got != mustJSON(t, []MergeConflict{{ID: "sodium", Files: []string{"base: sodium-0.5.jar", "server: sodium-fork.jar"}}}) {
+		t.Errorf("conflicts %s", got)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("the merged archive was written: %v", err)
+	}
+}
+
+// TestUpdateWithSources updates from the pack and a server addon, served
+// from addon.example: the addon's files override the pack's, conflicting
+// mods and an unavailable addon stop the update before anything changes.
+func TestUpdateWithSources(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{
+		"mods/sodium.jar":    modJar(t, "sodium", "0.5"),
+		"mods/voicechat.jar": modJar(t, "voicechat", "1"),
+	})
+	addon := filepath.Join(t.TempDir(), "addon.zip")
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host == "addon.example" && r.URL.Path == "/addon.zip" {
+			http.ServeFile(w, r, addon)
+			return
+		}
+		u.server.ServeHTTP(w, r)
+	}))
+	if err := os.MkdirAll(u.state, 0755); err != nil {
+		t.Fatal(err)
+	}
+	SaveConfig(ConfFile{MCDirectory: u.mods, Sources: []PackSource{{Name: "server", URL: "https://addon.example/addon.zip"}}}, filepath.Join(u.state, "clientUpdate.json"))
+
+	writeZip(t, addon, map[string]string{
+		"rxmc-server-master/mods/voicechat.jar": modJar(t, "voicechat", "2"),
+		"rxmc-server-master/mods/rxmc-sync.jar": modJar(t, "rxmc-sync", "1"),
+	})
+	output := readFile(t, u.run(t))
+	if got := dirNames(t, u.mods); got != "rxmc-sync.jar sodium.jar voicechat.jar" || readFile(t, filepath.Join(u.mods, "voicechat.jar")) != modJar(t, "voicechat", "2") {
+		t.Fatalf("installed %s, output:\n%s", got, output)
+	}
+	for _, want := range []string{"Merged 2 pack sources, 3 mods in total.", "voicechat.jar of base is replaced by the one from server", "base: 1 mods", "server: 2 mods"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("no %q in the output:\n%s", want, output)
+		}
+	}
+	state, err := ReadInstalledState(filepath.Join(u.state, "clientUpdate-installed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sources []string
+	for _, f := range state.Files {
+		sources = append(sources, filepath.Base(f.Name)+"="+f.Source)
+	}
+	sort.Strings(sources)
+	if got := strings.Join(sources, " "); got != "rxmc-sync.jar=server sodium.jar=base voicechat.jar=server" {
+		t.Errorf("installed sources %s", got)
+	}
+
+	before := hashFiles(t, u.mods)
+	useRunState(t)
+	writeZip(t, addon, map[string]string{"rxmc-server-master/mods/sodium-fork.jar": modJar(t, "sodium", "0.6")})
+	if code := exitsWith(func() { u.run(t) }); code == -1 {
+		t.Error("updated with conflicting sources")
+	}
+	os.Remove(addon)
+	if code := exitsWith(func() { u.run(t) }); code == -1 {
+		t.Error("updated without the addon")
+	}
+	if got := hashFiles(t, u.mods); mustJSON(t, got) != mustJSON(t, before) {
+		t.Errorf("the mods changed to %v", got)
+	}
+}
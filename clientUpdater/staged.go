@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// stagedSchema is the schema of the staged update's marker.
+const stagedSchema = 1
+
+// StagedUpdate is a pack downloaded and verified by --stage-only, waiting
+// to be applied by the next run, the pre-launch hook or --apply-staged,
+// all without downloading anything.
+type StagedUpdate struct {
+	// Archive is the staged pack archive, SHA256 its hash when staged.
+	Archive string `json:"archive"`
+	SHA256  string `json:"sha256"`
+	// Commit is the pack commit staged, PackVersion its release.
+	Commit      string `json:"commit,omitempty"`
+	PackVersion string `json:"packVersion,omitempty"`
+	MCVersion   string `json:"mcVersion"`
+	// Source is where the archive was downloaded from.
+	Source   string    `json:"source"`
+	StagedAt time.Time `json:"stagedAt"`
+}
+
+// Label names the staged pack for people.
+func (s *StagedUpdate) Label() string {
+	return packLabel(s.PackVersion, s.Commit)
+}
+
+// stagedArchiveError is a staged archive that no longer is what was
+// staged, e.g. damaged on disk since.
+type stagedArchiveError struct {
+	path string
+	err  error
+}
+
+func (e *stagedArchiveError) Error() string {
+	return fmt.Sprintf("the staged update %s can't be used anymore: %s", e.path, e.err)
+}
+
+func (e *stagedArchiveError) Category() string { return categoryLocal }
+
+// StageUpdate moves the downloaded and validated archive to archivePath
+// and records it in the marker at p, replacing what was staged before.
+func StageUpdate(p string, archivePath string, archive *PackArchive) (*StagedUpdate, error) {
+	sum, err := fileSHA256(archive.Path)
+	if err != nil {
+		return nil, err
+	}
+	if archive.Path != archivePath {
+		if err := moveFile(archive.Path, archivePath); err != nil {
+			return nil, err
+		}
+	}
+	staged := &StagedUpdate{
+		Archive:   archivePath,
+		SHA256:    sum,
+		Commit:    archive.Commit,
+		MCVersion: archive.MCVersion,
+		Source:    archive.Download.URL,
+		StagedAt:  clock.Now(),
+	}
+	if archive.Manifest != nil {
+		staged.PackVersion = archive.Manifest.Version
+	}
+	if err := WriteStateFile(p, stagedSchema, staged); err != nil {
+		return nil, err
+	}
+	return staged, nil
+}
+
+// ReadStagedUpdate reads the marker at p, nil when nothing is staged.
+func ReadStagedUpdate(p string) (*StagedUpdate, error) {
+	var staged StagedUpdate
+	if err := ReadStateFile(p, stagedSchema, &staged); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &staged, nil
+}
+
+// Verify hashes the staged archive again, the disk may have changed since
+// it was staged, and validates it for mcVersion.
+func (s *StagedUpdate) Verify(mcVersion string) (*PackArchive, error) {
+	sum, err := fileSHA256(s.Archive)
+	if err == nil && sum != s.SHA256 {
+		err = fmt.Errorf("its SHA-256 is %s, %s was staged", sum, s.SHA256)
+	}
+	if err != nil {
+		return nil, &stagedArchiveError{path: s.Archive, err: err}
+	}
+	archive, err := LocalPack(s.Archive, mcVersion)
+	if err != nil {
+		return nil, &stagedArchiveError{path: s.Archive, err: err}
+	}
+	archive.Download.URL = s.Source
+	return archive, nil
+}
+
+// Superseded reports whether the pack moved on since it was staged, asking
+// headURL within ctx. When that can't be told, e.g. offline or for a pack
+// without a known commit, the staged update stands.
+func (s *StagedUpdate) Superseded(ctx context.Context, headURL string) (string, bool) {
+	if s.Commit == "" {
+		return "", false
+	}
+	head, err := packHead(ctx, headURL)
+	if err != nil {
+		Logf("staged: the pack couldn't be checked, applying the staged update: %s", err)
+		return "", false
+	}
+	return head, head != s.Commit
+}
+
+// DiscardStaged removes the staged archive and its marker at p.
+func DiscardStaged(p string) error {
+	staged, err := ReadStagedUpdate(p)
+	if staged != nil {
+		RemoveTemporary(staged.Archive)
+	}
+	if removeErr := RemoveStateFile(p); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// runStageOnly stages the downloaded archive for a later run to apply,
+// unless it is the pack installed already, see --stage-only.
+func runStageOnly(archive *PackArchive, previous *InstalledState, stagedPath string, stagedArchivePath string) error {
+	if previous != nil && previous.PackCommit != "" && previous.PackCommit == archive.Commit {
+		RemoveTemporary(archive.Path)
+		DiscardStaged(stagedPath)
+		fmt.Println(T("stage.uptodate", archive.Label()))
+		SetOutcome(false, false)
+		return nil
+	}
+	if before, _ := ReadStagedUpdate(stagedPath); before != nil && before.Commit != archive.Commit {
+		Logf("staged: replacing %s", before.Label())
+		fmt.Println(T("stage.replaced", before.Label()))
+	}
+	staged, err := StageUpdate(stagedPath, stagedArchivePath, archive)
+	if err != nil {
+		return err
+	}
+	Logf("staged: %s from %s, %s", staged.Label(), staged.Source, staged.SHA256)
+	fmt.Println(T("stage.done", staged.Label()))
+	return nil
+}
+
+// pendingStaged returns the update staged at stagedPath for this run to
+// apply, nil when there is none or the pack moved on since it was staged.
+// One applied on request, by --apply-staged or right before the game
+// starts, isn't checked against the pack.
+func pendingStaged(stagedPath string, requested bool) *StagedUpdate {
+	staged, err := ReadStagedUpdate(stagedPath)
+	if err != nil {
+		Logf("staged: %s", err)
+	}
+	if staged == nil || requested {
+		return staged
+	}
+	ctx, cancel := context.WithTimeout(runCtx, probeTimeout)
+	head, superseded := staged.Superseded(ctx, packHeadURL)
+	cancel()
+	if !superseded {
+		return staged
+	}
+	Logf("staged: %s was staged, the pack is at %s now", staged.Commit, head)
+	fmt.Println(T("stage.superseded", staged.Label()))
+	if err := DiscardStaged(stagedPath); err != nil {
+		Logf("staged: %s", err)
+	}
+	return nil
+}
+
+// runDiscardStaged throws away the update staged at stagedPath, see
+// --discard-staged.
+func runDiscardStaged(stagedPath string) error {
+	staged, err := ReadStagedUpdate(stagedPath)
+	if err == nil {
+		err = DiscardStaged(stagedPath)
+	}
+	if err != nil {
+		return err
+	}
+	if staged == nil {
+		fmt.Println(T("stage.none"))
+		return nil
+	}
+	Logf("staged: discarded %s", staged.Label())
+	fmt.Println(T("stage.discarded", staged.Label()))
+	return nil
+}
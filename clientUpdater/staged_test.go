@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Commits of the pack's releases in the staging tests.
+var (
+	stageCommitA = strings.Repeat("a", 40)
+	stageCommitB = strings.Repeat("b", 40)
+	stageCommitC = strings.Repeat("c", 40)
+	stageCommitD = strings.Repeat("d", 40)
+)
+
+// releaseServer serves the pack like fakePackServer, built from the
+// commit the pack's branch is at, and tells that commit.
+type releaseServer struct {
+	pack *fakePackServer
+	head atomic.Value
+}
+
+func (s *releaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Host == "api.github.com" && r.URL.Path == "/repos/rx13/rxmc-Mods/commits/master" {
+		w.Write([]byte(s.head.Load().(string)))
+		return
+	}
+	s.pack.ServeHTTP(w, r)
+}
+
+// useReleases serves the releases of u's pack with their commits, see
+// release.
+func useReleases(t *testing.T, u *fakeUpdate) *releaseServer {
+	t.Helper()
+	s := &releaseServer{pack: u.server}
+	s.head.Store("")
+	useTestServer(t, s)
+	return s
+}
+
+// release moves the pack's branch to commit, holding files.
+func (s *releaseServer) release(t *testing.T, commit string, files map[string]string) {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "pack.zip")
+	writeCommitZip(t, p, commit, files)
+	s.pack.archive.Store(p)
+	s.head.Store(commit)
+}
+
+func (s *releaseServer) downloads() int32 {
+	return atomic.LoadInt32(&s.pack.downloads)
+}
+
+func TestStageUpdate(t *testing.T) {
+	useFakeClock(t)
+	dir := t.TempDir()
+	downloaded := filepath.Join(dir, "serverMods-master.zip")
+	writeCommitZip(t, downloaded, stageCommitA, map[string]string{"pack.json": `{"version": "2024.06"}`, "mods/sodium.jar": "sodium 1"})
+	archive, err := LocalPack(downloaded, "1.20.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive.Download.URL = "https://github.com/rx13/rxmc-Mods/archive/master.zip"
+	marker := filepath.Join(dir, "clientUpdate-staged.json")
+	if staged, err := ReadStagedUpdate(marker); staged != nil || err != nil {
+		t.Fatalf("staged before staging: %+v, %v", staged, err)
+	}
+
+	stagedPath := filepath.Join(dir, "clientUpdate-staged.zip")
+	staged, err := StageUpdate(marker, stagedPath, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(downloaded); !os.IsNotExist(err) {
+		t.Errorf("the download is still there: %v", err)
+	}
+	read, err := ReadStagedUpdate(marker)
+	if err != nil || *read != *staged {
+		t.Fatalf("read %+v, %v, staged %+v", read, err, staged)
+	}
+	if read.Archive != stagedPath || read.Commit != stageCommitA || read.PackVersion != "2024.06" || read.MCVersion != "1.20.1" || read.Source != archive.Download.URL || !read.StagedAt.Equal(clock.Now()) {
+		t.Errorf("staged %+v", read)
+	}
+	if read.Label() != packLabel("2024.06", stageCommitA) {
+		t.Errorf("labelled %s", read.Label())
+	}
+	verified, err := read.Verify("1.20.1")
+	if err != nil || verified.Commit != stageCommitA || verified.Download.URL != archive.Download.URL {
+		t.Errorf("verified %+v, %v", verified, err)
+	}
+
+	// the disk changed since
+	damageZipEntry(t, stagedPath, "rxmc-Mods-"+stageCommitA+"/mods/sodium.jar")
+	if _, err := read.Verify("1.20.1"); err == nil || ErrorCategory(err) != categoryLocal || !strings.Contains(err.Error(), "was staged") {
+		t.Errorf("verified a damaged staging: %v", err)
+	}
+	os.Remove(stagedPath)
+	if _, err := read.Verify("1.20.1"); err == nil {
+		t.Error("verified a missing staging")
+	}
+
+	if err := DiscardStaged(marker); err != nil {
+		t.Fatal(err)
+	}
+	if got := dirNames(t, dir); got != "" {
+		t.Errorf("discarding left %s", got)
+	}
+	if err := DiscardStaged(marker); err != nil {
+		t.Errorf("discarding nothing: %v", err)
+	}
+}
+
+func TestStagedSuperseded(t *testing.T) {
+	var head atomic.Value
+	head.Store(stageCommitA)
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(head.Load().(string)))
+	}))
+	staged := &StagedUpdate{Commit: stageCommitA}
+	if _, superseded := staged.Superseded(context.Background(), packHeadURL); superseded {
+		t.Error("superseded by itself")
+	}
+	head.Store(stageCommitB)
+	if got, superseded := staged.Superseded(context.Background(), packHeadURL); !superseded || got != stageCommitB {
+		t.Errorf("head %s, superseded %v", got, superseded)
+	}
+	// what can't be told stands
+	if _, superseded := staged.Superseded(context.Background(), "https://api.github.com/down"); superseded {
+		t.Error("superseded while the pack can't be checked")
+	}
+	if _, superseded := (&StagedUpdate{}).Superseded(context.Background(), packHeadURL); superseded {
+		t.Error("superseded without a commit")
+	}
+}
+
+// TestStagedModes stages, checks and discards updates without going
+// through main.
+func TestStagedModes(t *testing.T) {
+	useFakeClock(t)
+	var head atomic.Value
+	head.Store(stageCommitA)
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(head.Load().(string)))
+	}))
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "clientUpdate-staged.json")
+	stagedZip := filepath.Join(dir, "clientUpdate-staged.zip")
+	download := func(commit string) *PackArchive {
+		p := filepath.Join(dir, "serverMods-master.zip")
+		writeCommitZip(t, p, commit, map[string]string{"mods/sodium.jar": "sodium " + commit[:1]})
+		archive, err := LocalPack(p, "1.20.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return archive
+	}
+	stage := func(archive *PackArchive, previous *InstalledState) string {
+		t.Helper()
+		var err error
+		output := captureStdout(t, func() { err = runStageOnly(archive, previous, marker, stagedZip) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		return output
+	}
+
+	a := download(stageCommitA)
+	if output := stage(a, &InstalledState{PackCommit: stageCommitC}); output != T("stage.done", a.Label())+"\n" {
+		t.Errorf("staging printed %q", output)
+	}
+	var staged *StagedUpdate
+	captureStdout(t, func() { staged = pendingStaged(marker, false) })
+	if staged == nil || staged.Commit != stageCommitA {
+		t.Fatalf("pending %+v", staged)
+	}
+
+	// staging again replaces it
+	b := download(stageCommitB)
+	if output := stage(b, nil); !strings.Contains(output, T("stage.replaced", a.Label())) {
+		t.Errorf("restaging printed %q", output)
+	}
+	// the pack moved on, unless applied on request
+	head.Store(stageCommitD)
+	captureStdout(t, func() { staged = pendingStaged(marker, true) })
+	if staged == nil || staged.Commit != stageCommitB {
+		t.Errorf("requested %+v", staged)
+	}
+	output := captureStdout(t, func() { staged = pendingStaged(marker, false) })
+	if staged != nil || output != T("stage.superseded", b.Label())+"\n" {
+		t.Errorf("superseded %+v, printed %q", staged, output)
+	}
+	if got := dirNames(t, dir); got != "" {
+		t.Errorf("superseded left %s", got)
+	}
+
+	// the pack installed already isn't staged, and drops what was
+	stage(download(stageCommitB), nil)
+	d := download(stageCommitD)
+	if output := stage(d, &InstalledState{PackCommit: stageCommitD}); output != T("stage.uptodate", d.Label())+"\n" {
+		t.Errorf("staging the installed pack printed %q", output)
+	}
+	if got := dirNames(t, dir); got != "" {
+		t.Errorf("staging the installed pack left %s", got)
+	}
+
+	stage(download(stageCommitA), nil)
+	for _, want := range []string{T("stage.discarded", a.Label()), T("stage.none")} {
+		var err error
+		output := captureStdout(t, func() { err = runDiscardStaged(marker) })
+		if err != nil || output != want+"\n" {
+			t.Errorf("discarding printed %q, %v; want %q", output, err, want)
+		}
+	}
+	if got := dirNames(t, dir); got != "" {
+		t.Errorf("discarding left %s", got)
+	}
+}
+
+// TestStageThenApply stages an update and applies it, from the next run,
+// the pre-launch hook and --apply-staged, without downloading again.
+func TestStageThenApply(t *testing.T) {
+	for _, apply := range [][]string{nil, {"prelaunch"}, {"--apply-staged"}} {
+		u := newFakeUpdate(t, nil)
+		s := useReleases(t, u)
+		s.release(t, stageCommitA, map[string]string{"mods/sodium.jar": "sodium 1"})
+		u.run(t)
+		s.release(t, stageCommitB, map[string]string{"mods/sodium.jar": "sodium 2"})
+
+		output := readFile(t, u.run(t, "--stage-only"))
+		if !strings.Contains(output, T("stage.done", packLabel("", stageCommitB))) {
+			t.Errorf("output:\n%s", output)
+		}
+		if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 1" {
+			t.Errorf("staging installed sodium.jar %q", got)
+		}
+		if got := dirNames(t, u.state); !strings.Contains(got, "clientUpdate-staged.json clientUpdate-staged.zip") {
+			t.Errorf("state %s", got)
+		}
+
+		u.run(t, apply...)
+		if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 2" {
+			t.Errorf("%q: sodium.jar is %q", apply, got)
+		}
+		if got := s.downloads(); got != 2 {
+			t.Errorf("%q: downloaded %d times", apply, got)
+		}
+		if got := dirNames(t, u.state); strings.Contains(got, "staged") {
+			t.Errorf("%q: state %s after applying", apply, got)
+		}
+		if log := readFile(t, filepath.Join(u.state, "clientUpdate.log")); apply != nil && apply[0] == "prelaunch" && !strings.Contains(log, "prelaunch: applying the staged update") {
+			t.Errorf("log:\n%s", log)
+		}
+	}
+}
+
+// TestStageThenNewerRelease stages a release the pack moves on from: the
+// next run downloads the newer one, staging again replaces the staged one.
+func TestStageThenNewerRelease(t *testing.T) {
+	u := newFakeUpdate(t, nil)
+	s := useReleases(t, u)
+	s.release(t, stageCommitA, map[string]string{"mods/sodium.jar": "sodium 1"})
+	u.run(t, "--stage-only")
+	s.release(t, stageCommitB, map[string]string{"mods/sodium.jar": "sodium 2"})
+
+	output := readFile(t, u.run(t))
+	if !strings.Contains(output, T("stage.superseded", packLabel("", stageCommitA))) {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 2" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+	if got := dirNames(t, u.state); strings.Contains(got, "staged") {
+		t.Errorf("state %s", got)
+	}
+
+	// staged twice, the newer staging replaces the older one
+	s.release(t, stageCommitC, map[string]string{"mods/sodium.jar": "sodium 3"})
+	u.run(t, "--stage-only")
+	s.release(t, stageCommitD, map[string]string{"mods/sodium.jar": "sodium 4"})
+	output = readFile(t, u.run(t, "--stage-only"))
+	if !strings.Contains(output, T("stage.replaced", packLabel("", stageCommitC))) {
+		t.Errorf("output:\n%s", output)
+	}
+	staged, err := ReadStagedUpdate(filepath.Join(u.state, "clientUpdate-staged.json"))
+	if err != nil || staged == nil || staged.Commit != stageCommitD {
+		t.Fatalf("staged %+v, %v", staged, err)
+	}
+	downloads := s.downloads()
+	u.run(t)
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 4" || s.downloads() != downloads {
+		t.Errorf("sodium.jar is %q, downloaded %d more times", got, s.downloads()-downloads)
+	}
+
+	// the installed release isn't staged
+	output = readFile(t, u.run(t, "--stage-only"))
+	if !strings.Contains(output, T("stage.uptodate", packLabel("", stageCommitD))) {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := dirNames(t, u.state); strings.Contains(got, "staged") {
+		t.Errorf("state %s", got)
+	}
+}
+
+func TestStageThenDiscard(t *testing.T) {
+	u := newFakeUpdate(t, nil)
+	s := useReleases(t, u)
+	useRunState(t)
+	s.release(t, stageCommitA, map[string]string{"mods/sodium.jar": "sodium 1"})
+	u.run(t, "--stage-only")
+
+	output := readFile(t, u.run(t, "--discard-staged"))
+	if !strings.Contains(output, T("stage.discarded", packLabel("", stageCommitA))) {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := dirNames(t, u.state); strings.Contains(got, "staged") {
+		t.Errorf("state %s", got)
+	}
+	if output := readFile(t, u.run(t, "--discard-staged")); !strings.Contains(output, T("stage.none")) {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := dirNames(t, u.mods); got != "" {
+		t.Errorf("installed %s", got)
+	}
+	if code := exitsWith(func() { u.run(t, "--apply-staged") }); code != exitLocal {
+		t.Errorf("applying nothing exited %d", code)
+	}
+}
+
+// TestStageDamaged damages the staged archive: the next run downloads
+// the pack instead, --apply-staged fails.
+func TestStageDamaged(t *testing.T) {
+	u := newFakeUpdate(t, nil)
+	s := useReleases(t, u)
+	useRunState(t)
+	// the runs exiting early leave no preparation running past the test
+	t.Cleanup(preparing.Wait)
+	s.release(t, stageCommitA, map[string]string{"mods/sodium.jar": "sodium 1"})
+	stagedArchive := filepath.Join(u.state, "clientUpdate-staged.zip")
+
+	u.run(t, "--stage-only")
+	damageZipEntry(t, stagedArchive, "rxmc-Mods-"+stageCommitA+"/mods/sodium.jar")
+	output := readFile(t, u.run(t))
+	if !strings.Contains(output, "> The staged update can't be used, downloading the pack instead") {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 1" || s.downloads() != 2 {
+		t.Errorf("sodium.jar is %q, downloaded %d times", got, s.downloads())
+	}
+
+	s.release(t, stageCommitB, map[string]string{"mods/sodium.jar": "sodium 2"})
+	u.run(t, "--stage-only")
+	damageZipEntry(t, stagedArchive, "rxmc-Mods-"+stageCommitB+"/mods/sodium.jar")
+	if code := exitsWith(func() { u.run(t, "--apply-staged") }); code != exitLocal {
+		t.Errorf("applying a damaged staging exited %d", code)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 1" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+	if got := dirNames(t, u.state); strings.Contains(got, "staged") {
+		t.Errorf("state %s", got)
+	}
+}
+
+func TestStageOnlyDownloads(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 1"})
+	useRunState(t)
+	local := filepath.Join(t.TempDir(), "pack.zip")
+	writeZip(t, local, map[string]string{"pack/mods/sodium.jar": "sodium 1"})
+	if code := exitsWith(func() { u.run(t, "--stage-only", "--source", local) }); code != exitUsage {
+		t.Errorf("staging a local archive exited %d", code)
+	}
+}
+
+func TestDaemonStatusStaged(t *testing.T) {
+	useFakeClock(t)
+	d := newTestDaemon(t, nil)
+	if status := d.Status(); status.Staged != nil {
+		t.Errorf("staged %+v", status.Staged)
+	}
+	staged := StagedUpdate{Archive: "clientUpdate-staged.zip", Commit: stageCommitA, PackVersion: "2024.06", MCVersion: "1.20.1", StagedAt: clock.Now().Add(-time.Hour)}
+	if err := WriteStateFile(d.StagedPath, stagedSchema, staged); err != nil {
+		t.Fatal(err)
+	}
+	if status := d.Status(); status.Staged == nil || *status.Staged != staged {
+		t.Errorf("staged %+v", status.Staged)
+	}
+}
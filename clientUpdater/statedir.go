@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// portableMarker is the file next to the executable switching to portable
+// mode. It holds the directory the state is kept in, relative to the
+// executable's; empty is the executable's directory itself, e.g. for an
+// updater carried around on a USB stick.
+const portableMarker = "clientUpdate.portable"
+
+// legacyStateFiles are the files of the state that earlier versions kept
+// in the working directory and are taken over from there once.
+var legacyStateFiles = []string{"clientUpdate.json", "clientUpdate-installed.json", "clientUpdate-journal.jsonl"}
+
+// Where the state is kept, see ResolveStateDir.
+const (
+	stateStandard = "standard"
+	statePortable = "portable"
+)
+
+// StateDirInputs is everything deciding where the state is kept.
+type StateDirInputs struct {
+	// Executable is the updater's executable, WorkingDir the directory it
+	// was started in.
+	Executable string
+	WorkingDir string
+	// Portable is the directory given with --portable.
+	Portable string
+	Base     BaseDirs
+	// ReadFile reads the portable marker, Writable creates a directory if
+	// needed and tells whether files can be written to it. Both are
+	// replaced by tests simulating other systems.
+	ReadFile func(p string) ([]byte, error)
+	Writable func(dir string) error
+}
+
+// StateLocation is where the updater keeps its config, logs, journal and
+// downloads.
+type StateLocation struct {
+	Dir  string
+	Mode string
+	// Reason says what chose Dir, for the log.
+	Reason string
+	// Legacy is the working directory when it holds the state of an
+	// earlier version to take over, empty otherwise.
+	Legacy string
+}
+
+// stateDirError is a state directory that can't be used.
+type stateDirError struct {
+	location StateLocation
+	err      error
+}
+
+func (e *stateDirError) Error() string {
+	if e.location.Dir == "" {
+		return fmt.Sprintf("the updater has nowhere to keep its files, %s; run it with --portable <directory> to keep them in a writable directory", e.err)
+	}
+	return fmt.Sprintf("the updater keeps its files in %s (%s), which is not writable: %s; run it with --portable <directory> to keep them in a writable directory", e.location.Dir, e.location.Reason, e.err)
+}
+
+func (e *stateDirError) Category() string { return categoryLocal }
+
+// ResolveStateDir decides where the state lives. Nothing is ever written
+// next to the executable unless asked to, it may be on a read-only network
+// share:
+//
+//   - --portable <dir> keeps it in dir, relative to the working directory
+//   - otherwise a portableMarker next to the executable keeps it where the
+//     marker says
+//   - otherwise it is the user's config directory, rxmc-Updater below it
+//
+// The directory is created when missing and must be writable.
+func ResolveStateDir(in StateDirInputs) (StateLocation, error) {
+	var location StateLocation
+	exeDir := filepath.Dir(in.Executable)
+	marker := filepath.Join(exeDir, portableMarker)
+	content, markerErr := in.ReadFile(marker)
+	if in.Executable == "" {
+		markerErr = os.ErrNotExist
+	}
+	if in.Portable != "" {
+		location = StateLocation{Dir: in.Portable, Mode: statePortable, Reason: "--portable"}
+		if !filepath.IsAbs(location.Dir) {
+			location.Dir = filepath.Join(in.WorkingDir, location.Dir)
+		}
+	} else if markerErr == nil {
+		location = StateLocation{Dir: exeDir, Mode: statePortable, Reason: marker}
+		if dir := NormalizeDir(string(content)); dir != "" {
+			location.Dir = dir
+			if !filepath.IsAbs(dir) {
+				location.Dir = filepath.Join(exeDir, dir)
+			}
+		}
+	} else {
+		location = StateLocation{Mode: stateStandard, Reason: "the user's config directory"}
+		switch {
+		case in.Base.Config != "":
+			location.Dir = filepath.Join(in.Base.Config, cacheDirName)
+		case in.Base.Home != "":
+			location.Dir = filepath.Join(in.Base.Home, "."+cacheDirName)
+		default:
+			return location, &stateDirError{location: location, err: fmt.Errorf("the system doesn't tell the user's config directory")}
+		}
+	}
+	location.Dir = filepath.Clean(location.Dir)
+	if err := in.Writable(location.Dir); err != nil {
+		return location, &stateDirError{location: location, err: err}
+	}
+
+	// the state of earlier versions, kept in the working directory, is
+	// taken over unless there is state already
+	if location.Mode == stateStandard && in.WorkingDir != "" && !sameDir(in.WorkingDir, location.Dir) {
+		_, err := in.ReadFile(filepath.Join(location.Dir, legacyStateFiles[0]))
+		_, legacyErr := in.ReadFile(filepath.Join(in.WorkingDir, legacyStateFiles[0]))
+		if os.IsNotExist(err) && legacyErr == nil {
+			location.Legacy = in.WorkingDir
+		}
+	}
+	return location, nil
+}
+
+// sameDir reports whether a and b are spelled the same, ignoring case on
+// Windows.
+func sameDir(a string, b string) bool {
+	a, b = filepath.Clean(a), filepath.Clean(b)
+	if isWindows() {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// SystemStateDirInputs are the inputs of this process.
+func SystemStateDirInputs(portable string) StateDirInputs {
+	executable, _ := os.Executable()
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+	workingDir, _ := os.Getwd()
+	return StateDirInputs{
+		Executable: executable,
+		WorkingDir: workingDir,
+		Portable:   portable,
+		Base:       baseDirs,
+		ReadFile:   ioutil.ReadFile,
+		Writable:   writableDir,
+	}
+}
+
+// writableDir creates dir if needed and writes a probe file to it.
+func writableDir(dir string) error {
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return err
+	}
+	probe := filepath.Join(dir, probeName)
+	file, err := os.OpenFile(probe, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, filePerm)
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(probe)
+}
+
+// TakeOverLegacyState copies the state files of an earlier version from
+// the working directory into the state directory. They are only read,
+// the working directory may be read-only.
+func TakeOverLegacyState(location StateLocation) {
+	for _, name := range legacyStateFiles {
+		src := filepath.Join(location.Legacy, name)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		if err := copyFile(src, filepath.Join(location.Dir, name)); err != nil {
+			Logf("state: taking over %s: %s", src, err)
+			continue
+		}
+		Logf("state: took over %s", src)
+	}
+}
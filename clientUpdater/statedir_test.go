@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeStateFS stands in for the file system ResolveStateDir sees: the
+// files it holds, by path, and the directories below which nothing can
+// be written, like a read-only network share.
+type fakeStateFS struct {
+	files    map[string]string
+	readOnly []string
+	checked  []string
+}
+
+func (fs *fakeStateFS) ReadFile(p string) ([]byte, error) {
+	content, ok := fs.files[p]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+	}
+	return []byte(content), nil
+}
+
+func (fs *fakeStateFS) Writable(dir string) error {
+	fs.checked = append(fs.checked, dir)
+	for _, readOnly := range fs.readOnly {
+		if dir == readOnly || strings.HasPrefix(dir, readOnly+string(filepath.Separator)) {
+			return &os.PathError{Op: "open", Path: filepath.Join(dir, probeName), Err: os.ErrPermission}
+		}
+	}
+	return nil
+}
+
+// statePaths are the directories of the scenarios in the system's path
+// convention: the executable on a read-only network share, the user's
+// directories and a working directory.
+type statePaths struct {
+	share, exe, home, config, work string
+}
+
+func systemStatePaths() statePaths {
+	if isWindows() {
+		return statePaths{
+			share:  `\\lab-server\apps`,
+			exe:    `\\lab-server\apps\rxmc\RXclientUpdater.exe`,
+			home:   `C:\Users\player`,
+			config: `C:\Users\player\AppData\Roaming`,
+			work:   `\\lab-server\apps\rxmc`,
+		}
+	}
+	return statePaths{
+		share:  "/mnt/lab-server/apps",
+		exe:    "/mnt/lab-server/apps/rxmc/RXclientUpdater.bin",
+		home:   "/home/player",
+		config: "/home/player/.config",
+		work:   "/mnt/lab-server/apps/rxmc",
+	}
+}
+
+func TestResolveStateDir(t *testing.T) {
+	paths := systemStatePaths()
+	exeDir := filepath.Dir(paths.exe)
+	marker := filepath.Join(exeDir, portableMarker)
+	usb := filepath.Join(paths.home, "usb")
+	for _, test := range []struct {
+		name     string
+		portable string
+		files    map[string]string
+		readOnly []string
+		noConfig bool
+		noExe    bool
+		dir      string
+		mode     string
+		reason   string
+	}{
+		{name: "standard", dir: filepath.Join(paths.config, cacheDirName), mode: stateStandard, reason: "the user's config directory"},
+		{name: "read-only executable directory", readOnly: []string{paths.share}, dir: filepath.Join(paths.config, cacheDirName), mode: stateStandard},
+		{name: "no config directory", noConfig: true, dir: filepath.Join(paths.home, "."+cacheDirName), mode: stateStandard},
+		{name: "portable", portable: usb, dir: usb, mode: statePortable, reason: "--portable"},
+		{name: "portable relative", portable: filepath.Join("..", "state"), dir: filepath.Join(paths.share, "state"), mode: statePortable},
+		{name: "portable before marker", portable: usb, files: map[string]string{marker: ""}, dir: usb, mode: statePortable, reason: "--portable"},
+		{name: "empty marker", files: map[string]string{marker: ""}, dir: exeDir, mode: statePortable, reason: marker},
+		{name: "marker relative", files: map[string]string{marker: " \"state/player\"\r\n"}, dir: filepath.Join(exeDir, "state", "player"), mode: statePortable, reason: marker},
+		{name: "marker absolute", files: map[string]string{marker: usb + "\n"}, dir: usb, mode: statePortable},
+		{name: "marker of an unknown executable", noExe: true, files: map[string]string{marker: ""}, dir: filepath.Join(paths.config, cacheDirName), mode: stateStandard},
+	} {
+		fs := &fakeStateFS{files: test.files, readOnly: test.readOnly}
+		in := StateDirInputs{
+			Executable: paths.exe,
+			WorkingDir: paths.work,
+			Portable:   test.portable,
+			Base:       BaseDirs{Home: paths.home, Config: paths.config},
+			ReadFile:   fs.ReadFile,
+			Writable:   fs.Writable,
+		}
+		if test.noConfig {
+			in.Base.Config = ""
+		}
+		if test.noExe {
+			in.Executable = ""
+		}
+		location, err := ResolveStateDir(in)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if location.Dir != test.dir || location.Mode != test.mode || test.reason != "" && location.Reason != test.reason {
+			t.Errorf("%s: state in %s, %s (%s), want %s, %s", test.name, location.Dir, location.Mode, location.Reason, test.dir, test.mode)
+		}
+		// only the state directory is ever checked for writes
+		if len(fs.checked) != 1 || fs.checked[0] != location.Dir {
+			t.Errorf("%s: checked %q for writes", test.name, fs.checked)
+		}
+	}
+}
+
+func TestResolveStateDirNotWritable(t *testing.T) {
+	paths := systemStatePaths()
+	exeDir := filepath.Dir(paths.exe)
+	fs := &fakeStateFS{files: map[string]string{filepath.Join(exeDir, portableMarker): ""}, readOnly: []string{paths.share}}
+	in := StateDirInputs{Executable: paths.exe, WorkingDir: paths.work, Base: BaseDirs{Home: paths.home, Config: paths.config}, ReadFile: fs.ReadFile, Writable: fs.Writable}
+
+	// the marker asks for the read-only share
+	location, err := ResolveStateDir(in)
+	if _, ok := err.(*stateDirError); !ok || location.Dir != exeDir {
+		t.Fatalf("state in %s, error %v", location.Dir, err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, exeDir) || !strings.Contains(msg, "--portable <directory>") || !strings.Contains(msg, "permission denied") {
+		t.Errorf("error: %s", msg)
+	}
+	if ErrorCategory(err) != categoryLocal {
+		t.Errorf("a %s error", ErrorCategory(err))
+	}
+
+	// so does --portable
+	in.Portable = filepath.Join(paths.share, "state")
+	if _, err := ResolveStateDir(in); err == nil {
+		t.Error("kept the state on the read-only share")
+	}
+
+	// and without any user directory there is nowhere to go
+	fs.files = nil
+	in.Portable, in.Base = "", BaseDirs{}
+	location, err = ResolveStateDir(in)
+	if err == nil || location.Dir != "" || !strings.Contains(err.Error(), "nowhere to keep its files") {
+		t.Errorf("state in %q, error %v", location.Dir, err)
+	}
+	if len(fs.checked) != 2 {
+		t.Errorf("checked %q for writes", fs.checked)
+	}
+}
+
+func TestResolveStateDirLegacy(t *testing.T) {
+	paths := systemStatePaths()
+	stateDir := filepath.Join(paths.config, cacheDirName)
+	legacy := filepath.Join(paths.work, "clientUpdate.json")
+	for _, test := range []struct {
+		name     string
+		portable string
+		work     string
+		files    []string
+		legacy   string
+	}{
+		{name: "earlier version's state", files: []string{legacy}, legacy: paths.work},
+		{name: "nothing to take over"},
+		{name: "taken over already", files: []string{legacy, filepath.Join(stateDir, "clientUpdate.json")}},
+		{name: "portable", portable: paths.home, files: []string{legacy}},
+		{name: "working in the state directory", work: stateDir, files: []string{filepath.Join(stateDir, "clientUpdate.json")}},
+	} {
+		fs := &fakeStateFS{files: map[string]string{}}
+		for _, p := range test.files {
+			fs.files[p] = "{}"
+		}
+		work := paths.work
+		if test.work != "" {
+			work = test.work
+		}
+		location, err := ResolveStateDir(StateDirInputs{Executable: paths.exe, WorkingDir: work, Portable: test.portable, Base: BaseDirs{Home: paths.home, Config: paths.config}, ReadFile: fs.ReadFile, Writable: fs.Writable})
+		if err != nil || location.Legacy != test.legacy {
+			t.Errorf("%s: taking over %q, want %q, error %v", test.name, location.Legacy, test.legacy, err)
+		}
+	}
+}
+
+// TestResolveStateDirWindowsCase works in the state directory spelled in
+// other case, which is the same directory on Windows only.
+func TestResolveStateDirWindowsCase(t *testing.T) {
+	paths := systemStatePaths()
+	stateDir := filepath.Join(paths.config, cacheDirName)
+	work := strings.ToUpper(stateDir)
+	fs := &fakeStateFS{files: map[string]string{filepath.Join(work, "clientUpdate.json"): "{}"}}
+	location, err := ResolveStateDir(StateDirInputs{Executable: paths.exe, WorkingDir: work, Base: BaseDirs{Home: paths.home, Config: paths.config}, ReadFile: fs.ReadFile, Writable: fs.Writable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := !isWindows(); (location.Legacy != "") != want {
+		t.Errorf("working in %s, taking over %q", work, location.Legacy)
+	}
+}
+
+func TestWritableDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state", "rxmc-Updater")
+	if err := writableDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := dirNames(t, dir); got != "" {
+		t.Errorf("left %s behind", got)
+	}
+	file := filepath.Join(t.TempDir(), "file")
+	writeFiles(t, filepath.Dir(file), map[string]string{"file": ""})
+	if err := writableDir(filepath.Join(file, "state")); err == nil {
+		t.Error("a directory below a file is writable")
+	}
+}
+
+func TestTakeOverLegacyState(t *testing.T) {
+	work, state := t.TempDir(), t.TempDir()
+	writeFiles(t, work, map[string]string{
+		"clientUpdate.json":          `{"mcVersion": "1.20.1"}`,
+		"clientUpdate-journal.jsonl": "journal",
+		"clientUpdate.log":           "not taken over",
+	})
+	log := captureRunLog(t)
+	TakeOverLegacyState(StateLocation{Dir: state, Legacy: work})
+	if strings.Count(log.String(), "state: took over") != 2 {
+		t.Errorf("log:\n%s", log)
+	}
+	if got := dirNames(t, state); got != "clientUpdate-journal.jsonl clientUpdate.json" {
+		t.Errorf("took over %s", got)
+	}
+	if got := readFile(t, filepath.Join(state, "clientUpdate.json")); got != `{"mcVersion": "1.20.1"}` {
+		t.Errorf("config %q", got)
+	}
+	if got := dirNames(t, work); got != "clientUpdate-journal.jsonl clientUpdate.json clientUpdate.log" {
+		t.Errorf("left %s", got)
+	}
+}
+
+// TestUpdateTakesOverLegacyState runs in a directory an earlier version
+// kept its config in. The state moves to the user's config directory and
+// nothing is written to the working directory.
+func TestUpdateTakesOverLegacyState(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 1"})
+	work := t.TempDir()
+	config, _ := json.Marshal(ConfFile{MCVersion: "1.20.1", MCDirectory: u.mods})
+	writeFiles(t, work, map[string]string{"clientUpdate.json": string(config)})
+	t.Chdir(work)
+
+	output := readFile(t, runMain(t, "--yes", "--no-telemetry", "--no-tui"))
+	stateDir := filepath.Join(baseDirs.Config, cacheDirName)
+	if !strings.Contains(output, T("statedir.legacy", work, stateDir)) {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 1" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(stateDir, "clientUpdate-installed.json")); err != nil {
+		t.Error(err)
+	}
+	if got := dirNames(t, work); got != "clientUpdate.json" {
+		t.Errorf("wrote %s to the working directory", got)
+	}
+}
+
+func TestUpdateStateDirNotWritable(t *testing.T) {
+	useRunState(t)
+	useTempBaseDirs(t)
+	file := filepath.Join(t.TempDir(), "file")
+	writeFiles(t, filepath.Dir(file), map[string]string{"file": ""})
+	if code := exitsWith(func() { runMain(t, "--portable", filepath.Join(file, "state"), "--yes", "--no-tui") }); code != exitLocal {
+		t.Errorf("exited %d", code)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+)
+
+// stateGenerations is how many earlier versions of a state file are kept,
+// as <file>.1 and <file>.2, the newest first.
+const stateGenerations = 2
+
+// Schema versions of the state files, raised whenever a payload changes
+// in a way older updaters would misread.
+const (
+	installedSchema = 2
+	fetchSchema     = 1
+	cleanupSchema   = 1
+)
+
+// stateEnvelope wraps the payload of every state file the updater writes
+// for itself: the schema of the payload and the SHA-256 of its compact
+// JSON, so a damaged or half edited file is told apart from a good one.
+// Files from before envelopes are the bare payload and are still read.
+type stateEnvelope struct {
+	Schema  int             `json:"schema"`
+	Payload json.RawMessage `json:"payload"`
+	SHA256  string          `json:"sha256"`
+}
+
+// stateMigrator is a payload upgrading itself when read from a file of an
+// older schema, 0 for a file from before envelopes.
+type stateMigrator interface {
+	migrateFrom(schema int)
+}
+
+// StateFileError is a state file none of whose generations can be used,
+// with what is wrong with the file itself.
+type StateFileError struct {
+	Path    string
+	Problem string
+}
+
+func (e *StateFileError) Error() string {
+	return fmt.Sprintf("%s is damaged: %s", e.Path, e.Problem)
+}
+
+// generationPath returns where generation gen of the state file p is kept,
+// p itself for 0.
+func generationPath(p string, gen int) string {
+	if gen == 0 {
+		return p
+	}
+	return p + "." + strconv.Itoa(gen)
+}
+
+// payloadSum returns the SHA-256 of the compact form of payload, which
+// stays the same however the file is indented.
+func payloadSum(payload []byte) (string, error) {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, payload); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(compact.Bytes())
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// WriteStateFile writes payload to p in the envelope of schema. The file
+// it replaces is kept as the newest earlier generation.
+func WriteStateFile(p string, schema int, payload interface{}) error {
+	content, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	sum, err := payloadSum(content)
+	if err != nil {
+		return err
+	}
+	envelope, err := json.MarshalIndent(stateEnvelope{Schema: schema, Payload: content, SHA256: sum}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := rotateGenerations(p); err != nil {
+		Logf("state: keeping the previous %s: %s", p, err)
+	}
+	return writeAtomic(p, append(envelope, '\n'))
+}
+
+// rotateGenerations shifts the earlier generations of p down by one,
+// dropping the oldest, and copies p itself to the newest. p stays in place
+// until it is replaced, a crash never leaves it missing.
+func rotateGenerations(p string) error {
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		return nil
+	}
+	for gen := stateGenerations; gen > 1; gen-- {
+		if err := os.Rename(generationPath(p, gen-1), generationPath(p, gen)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return copyFile(p, generationPath(p, 1))
+}
+
+// RemoveStateFile removes p and its earlier generations.
+func RemoveStateFile(p string) error {
+	for gen := stateGenerations; gen >= 0; gen-- {
+		if err := os.Remove(generationPath(p, gen)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeState checks the content of a state file and decodes its payload
+// of schema into payload, returning what is wrong with it otherwise.
+func decodeState(content []byte, schema int, payload interface{}) error {
+	var envelope stateEnvelope
+	if err := json.Unmarshal(content, &envelope); err != nil {
+		return fmt.Errorf("not valid JSON: %s", err)
+	}
+	if envelope.Schema == 0 && envelope.Payload == nil {
+		// written before envelopes
+		if err := json.Unmarshal(content, payload); err != nil {
+			return fmt.Errorf("not valid JSON: %s", err)
+		}
+		if migrator, ok := payload.(stateMigrator); ok {
+			migrator.migrateFrom(0)
+		}
+		return nil
+	}
+	switch {
+	case envelope.Schema > schema:
+		return fmt.Errorf("written by a newer updater (schema %d, this one reads %d)", envelope.Schema, schema)
+	case envelope.Schema < 1 || envelope.Payload == nil:
+		return fmt.Errorf("schema or payload missing")
+	}
+	sum, err := payloadSum(envelope.Payload)
+	if err != nil {
+		return fmt.Errorf("payload is not valid JSON: %s", err)
+	}
+	if sum != envelope.SHA256 {
+		return fmt.Errorf("checksum mismatch, changed by hand or by a disk error")
+	}
+	if err := json.Unmarshal(envelope.Payload, payload); err != nil {
+		return fmt.Errorf("payload doesn't fit schema %d: %s", schema, err)
+	}
+	if migrator, ok := payload.(stateMigrator); ok {
+		migrator.migrateFrom(envelope.Schema)
+	}
+	return nil
+}
+
+// ReadStateFile decodes the state file p of schema into payload. A damaged
+// file is reported, naming the file and what is wrong with it, and
+// replaced by its newest earlier generation that is intact. When none is,
+// the error is a *StateFileError; a missing p is an os.IsNotExist error.
+func ReadStateFile(p string, schema int, payload interface{}) error {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	problem := decodeState(content, schema, payload)
+	if problem == nil {
+		return nil
+	}
+	Warn(warnState, T("state.damaged", p, problem))
+	for gen := 1; gen <= stateGenerations; gen++ {
+		earlier := generationPath(p, gen)
+		content, err := ioutil.ReadFile(earlier)
+		if err != nil {
+			continue
+		}
+		reflect.ValueOf(payload).Elem().Set(reflect.Zero(reflect.TypeOf(payload).Elem()))
+		if err := decodeState(content, schema, payload); err != nil {
+			Logf("state: %s can't be used either: %s", earlier, err)
+			continue
+		}
+		if err := writeAtomic(p, content); err != nil {
+			Logf("state: putting %s back: %s", earlier, err)
+		}
+		Warn(warnState, T("state.restored", p, earlier))
+		return nil
+	}
+	reflect.ValueOf(payload).Elem().Set(reflect.Zero(reflect.TypeOf(payload).Elem()))
+	return &StateFileError{Path: p, Problem: problem.Error()}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stateFixture is a payload of the tests' state files.
+type stateFixture struct {
+	Generation int    `json:"generation"`
+	Note       string `json:"note,omitempty"`
+}
+
+// writeGenerations writes n generations of the state file p, the last one
+// being generation n.
+func writeGenerations(t *testing.T, p string, n int) {
+	t.Helper()
+	for gen := 1; gen <= n; gen++ {
+		if err := WriteStateFile(p, 1, stateFixture{Generation: gen}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readGeneration returns which generation of stateFixture the file p
+// holds, as written by writeGenerations.
+func readGeneration(t *testing.T, p string) int {
+	t.Helper()
+	var envelope stateEnvelope
+	if err := json.Unmarshal([]byte(readFile(t, p)), &envelope); err != nil {
+		t.Fatalf("%s: %v", p, err)
+	}
+	var payload stateFixture
+	if err := json.Unmarshal(envelope.Payload, &payload); err != nil {
+		t.Fatalf("%s: %v", p, err)
+	}
+	return payload.Generation
+}
+
+func TestWriteStateFileRotates(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "state.json")
+	writeGenerations(t, p, 1)
+	if got := dirNames(t, dir); got != "state.json" {
+		t.Errorf("wrote %s", got)
+	}
+	writeGenerations(t, p, 4)
+	if got := dirNames(t, dir); got != "state.json state.json.1 state.json.2" {
+		t.Errorf("wrote %s", got)
+	}
+	for gen, want := range []int{4, 3, 2} {
+		if got := readGeneration(t, generationPath(p, gen)); got != want {
+			t.Errorf("generation %d holds %d, want %d", gen, got, want)
+		}
+	}
+
+	if err := RemoveStateFile(p); err != nil {
+		t.Fatal(err)
+	}
+	if got := dirNames(t, dir); got != "" {
+		t.Errorf("left %s", got)
+	}
+	if err := RemoveStateFile(p); err != nil {
+		t.Errorf("removing again: %v", err)
+	}
+}
+
+// stateDamages damage the content of a state file, each with the problem
+// it is reported with.
+var stateDamages = []struct {
+	name    string
+	damage  func(content string) string
+	problem string
+}{
+	{name: "cut off", damage: func(content string) string { return content[:len(content)/2] }, problem: "not valid JSON: unexpected end of JSON input"},
+	{name: "edited", damage: func(content string) string { return strings.Replace(content, `"generation": `, `"generation": ,`, 1) }, problem: "not valid JSON"},
+	{name: "changed", damage: func(content string) string { return strings.Replace(content, `"generation": `, `"generation": 9`, 1) }, problem: "checksum mismatch, changed by hand or by a disk error"},
+	{name: "newer", damage: func(content string) string { return strings.Replace(content, `"schema": 1`, `"schema": 7`, 1) }, problem: "written by a newer updater (schema 7, this one reads 1)"},
+	{name: "no payload", damage: func(content string) string { return `{"schema": 1, "sha256": "00"}` }, problem: "schema or payload missing"},
+	{name: "not a payload", damage: func(content string) string {
+		return `{"schema": 1, "payload": "text", "sha256": "` + sha256Hex([]byte(`"text"`)) + `"}`
+	}, problem: "payload doesn't fit schema 1"},
+	{name: "empty", damage: func(content string) string { return "" }, problem: "not valid JSON"},
+}
+
+// damageFile applies damage to the content of the file at p.
+func damageFile(t *testing.T, p string, damage func(content string) string) {
+	t.Helper()
+	if err := os.WriteFile(p, []byte(damage(readFile(t, p))), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadStateFile(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "state.json")
+	writeGenerations(t, p, 3)
+	var payload stateFixture
+	if err := ReadStateFile(p, 1, &payload); err != nil || payload.Generation != 3 {
+		t.Fatalf("read %+v, %v", payload, err)
+	}
+	// indenting the file differently keeps it intact
+	var indented map[string]interface{}
+	json.Unmarshal([]byte(readFile(t, p)), &indented)
+	content, _ := json.MarshalIndent(indented, "", "\t")
+	writeFiles(t, filepath.Dir(p), map[string]string{"state.json": string(content)})
+	if err := ReadStateFile(p, 1, &payload); err != nil || payload.Generation != 3 {
+		t.Fatalf("read reindented %+v, %v", payload, err)
+	}
+	if err := ReadStateFile(p+".missing", 1, &payload); !os.IsNotExist(err) {
+		t.Errorf("read a missing file: %v", err)
+	}
+}
+
+// TestReadStateFileDamaged damages a state file in several ways: it is
+// reported and replaced by its newest intact earlier generation, and
+// without one it can't be read at all.
+func TestReadStateFileDamaged(t *testing.T) {
+	for _, damage := range stateDamages {
+		t.Run(damage.name, func(t *testing.T) {
+			useWarnings(t)
+			defer PrintWarningsTo(ioutil.Discard)()
+			p := filepath.Join(t.TempDir(), "state.json")
+			writeGenerations(t, p, 3)
+			damageFile(t, p, damage.damage)
+			damageFile(t, p+".1", damage.damage)
+
+			payload := stateFixture{Note: "left from before"}
+			if err := ReadStateFile(p, 1, &payload); err != nil || payload != (stateFixture{Generation: 1}) {
+				t.Fatalf("read %+v, %v", payload, err)
+			}
+			warned := warningMessages(warnState)
+			if len(warned) != 2 || !strings.HasPrefix(warned[0], p+" is damaged: "+damage.problem) || warned[1] != p+" was restored from "+p+".2; changes made after that copy was written may be missing." {
+				t.Errorf("warned %q", warned)
+			}
+			// the restored generation is put back in place
+			if got := readGeneration(t, p); got != 1 {
+				t.Errorf("put back generation %d", got)
+			}
+
+			useWarnings(t)
+			defer PrintWarningsTo(ioutil.Discard)()
+			damageFile(t, p, damage.damage)
+			damageFile(t, p+".2", damage.damage)
+			payload = stateFixture{Note: "left from before"}
+			err := ReadStateFile(p, 1, &payload)
+			var stateErr *StateFileError
+			if !errors.As(err, &stateErr) || stateErr.Path != p || !strings.HasPrefix(stateErr.Problem, damage.problem) {
+				t.Fatalf("failed with %v", err)
+			}
+			if payload != (stateFixture{}) {
+				t.Errorf("left %+v", payload)
+			}
+		})
+	}
+}
+
+func TestReadStateFileLegacy(t *testing.T) {
+	var state InstalledState
+	if err := ReadStateFile(filepath.Join("testdata", "state", "installed-legacy.json"), installedSchema, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.PackCommit != "3f1c2a9" || len(state.Files) != 1 || state.Files[0].Name != "sodium.jar" {
+		t.Errorf("read %+v", state)
+	}
+}
+
+// TestRebuildInstalledState rebuilds the installed state from the mods
+// directory only when no generation of it can be read.
+func TestRebuildInstalledState(t *testing.T) {
+	clock := useFakeClock(t)
+	useWarnings(t)
+	defer PrintWarningsTo(ioutil.Discard)()
+	mods := filepath.Join(t.TempDir(), "mods")
+	writeFiles(t, mods, map[string]string{"sodium.jar": "sodium", "mymod.jar": "the player's"})
+	p := filepath.Join(t.TempDir(), "clientUpdate-installed.json")
+
+	if rebuilt, err := RebuildInstalledState(p, mods, "1.20.1", "fabric"); rebuilt || err != nil {
+		t.Errorf("rebuilt a missing state: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := WriteInstalledState(p, &InstalledState{MCVersion: "1.20.1", Loader: "fabric", PackCommit: "3f1c2a9"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rebuilt, err := RebuildInstalledState(p, mods, "1.20.1", "fabric"); rebuilt || err != nil {
+		t.Errorf("rebuilt an intact state: %v", err)
+	}
+	damageFile(t, p, stateDamages[0].damage)
+	if rebuilt, err := RebuildInstalledState(p, mods, "1.20.1", "fabric"); rebuilt || err != nil {
+		t.Errorf("rebuilt a state with intact generations: %v", err)
+	}
+
+	for gen := 0; gen <= stateGenerations; gen++ {
+		damageFile(t, generationPath(p, gen), stateDamages[0].damage)
+	}
+	if rebuilt, err := RebuildInstalledState(p, mods, "1.20.1", "fabric"); !rebuilt || err != nil {
+		t.Fatalf("rebuilt %t, %v", rebuilt, err)
+	}
+	state, err := ReadInstalledState(p)
+	if err != nil || state == nil {
+		t.Fatalf("read %+v, %v", state, err)
+	}
+	// nothing tells the pack's files from the player's, and which pack
+	// release is installed is unknown
+	if state.MCVersion != "1.20.1" || state.Loader != "fabric" || state.PackCommit != "" || !state.UpdatedAt.Equal(clock.Now()) {
+		t.Errorf("rebuilt %+v", state)
+	}
+	var files []string
+	for _, file := range state.Files {
+		if !file.Assumed || file.SHA256 != sha256Hex([]byte(readFile(t, filepath.Join(mods, file.Name)))) {
+			t.Errorf("rebuilt %+v", file)
+		}
+		files = append(files, file.Name)
+	}
+	if got := strings.Join(files, " "); got != "mymod.jar sodium.jar" {
+		t.Errorf("rebuilt with %s", got)
+	}
+}
+
+// TestReadJournalDamaged reads a journal with damaged lines and records an
+// entry after its last line was cut off by a crash.
+func TestReadJournalDamaged(t *testing.T) {
+	useFakeClock(t)
+	useWarnings(t)
+	defer PrintWarningsTo(ioutil.Discard)()
+	p := filepath.Join(t.TempDir(), "clientUpdate-journal.jsonl")
+	fixture := readFile(t, filepath.Join("testdata", "state", "journal-damaged.jsonl"))
+	writeFiles(t, filepath.Dir(p), map[string]string{filepath.Base(p): strings.TrimSuffix(fixture, "\n")})
+
+	journal := &Journal{Path: p, Run: "20240601-120000"}
+	if err := journal.Record(JournalEntry{Action: journalAdd, Path: "/games/.minecraft/mods/lithium.jar", SHA256: "cc"}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ReadJournal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, entry := range entries {
+		if entry.Sum != "" {
+			t.Errorf("read the sum of %+v", entry)
+		}
+		paths = append(paths, filepath.Base(entry.Path))
+	}
+	// the line from before sums is read, the new entry is a line of its own
+	if got := strings.Join(paths, " "); got != "iris.jar lithium.jar" {
+		t.Errorf("read %s", got)
+	}
+	warned := warningMessages(warnState)
+	if len(warned) != 2 || warned[0] != "Line 2 of "+p+" is damaged and was skipped: checksum mismatch." || !strings.HasPrefix(warned[1], "Line 3 of "+p+" is damaged and was skipped: unexpected end of JSON input") {
+		t.Errorf("warned %q", warned)
+	}
+}
+
+// TestJournalLineIntact checks the sums of the lines Record writes.
+func TestJournalLineIntact(t *testing.T) {
+	useFakeClock(t)
+	p := filepath.Join(t.TempDir(), "clientUpdate-journal.jsonl")
+	journal := &Journal{Path: p, Run: "20240601-120000", Pack: "2024.06"}
+	if err := journal.Record(JournalEntry{Action: journalAdd, Path: "/games/.minecraft/mods/sodium.jar", SHA256: "aa"}); err != nil {
+		t.Fatal(err)
+	}
+	line := []byte(strings.TrimSuffix(readFile(t, p), "\n"))
+	var entry JournalEntry
+	if err := json.Unmarshal(line, &entry); err != nil || len(entry.Sum) != journalSumLength {
+		t.Fatalf("recorded %s, %v", line, err)
+	}
+	if !journalLineIntact(line, entry) {
+		t.Errorf("the recorded line isn't intact: %s", line)
+	}
+	changed := []byte(strings.Replace(string(line), "sodium.jar", "sodiun.jar", 1))
+	if journalLineIntact(changed, entry) {
+		t.Errorf("a changed line is intact: %s", changed)
+	}
+	if !journalLineIntact([]byte(`{"action":"add"}`), JournalEntry{Action: journalAdd}) {
+		t.Error("a line from before sums isn't intact")
+	}
+}
+
+// TestUpdateRestoresInstalledState damages the installed state between
+// updates: the next update restores it from the generation before and
+// goes ahead with it.
+func TestUpdateRestoresInstalledState(t *testing.T) {
+	useWarnings(t)
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 1"})
+	u.run(t)
+	u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 2"})
+	u.run(t)
+	installed := filepath.Join(u.state, "clientUpdate-installed.json")
+	damageFile(t, installed, func(content string) string {
+		return strings.Replace(content, `"mcVersion":`, `"mcVersion":"1.19.2","x":`, 1)
+	})
+
+	u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 3"})
+	output := readFile(t, u.run(t))
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 3" {
+		t.Fatalf("sodium.jar is %q, output:\n%s", got, output)
+	}
+	want := installed + " was restored from " + installed + ".1"
+	found := false
+	for _, message := range warningMessages(warnState) {
+		found = found || strings.HasPrefix(message, want)
+	}
+	if !found {
+		t.Errorf("no %q among %q", want, warningMessages(warnState))
+	}
+	state, err := ReadInstalledState(installed)
+	if err != nil || state == nil || len(state.Files) != 1 || state.Files[0].SHA256 != sha256Hex([]byte("sodium 3")) {
+		t.Errorf("installed state %+v, %v", state, err)
+	}
+}
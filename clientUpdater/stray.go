@@ -0,0 +1,88 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"strings"
+)
+
+// Classes of the entries of a pack archive, see ClassifyEntry.
+const (
+	// entryEligible is a mod file of the mods folder installed from.
+	entryEligible = "eligible"
+	// entryIgnored is anything else the pack is expected to have: its
+	// manifest, the mods of other Minecraft versions, configs.
+	entryIgnored = "ignored"
+	// entrySuspicious is a jar outside every folder jars belong in,
+	// usually committed to the pack by accident. It is never installed.
+	entrySuspicious = "suspicious"
+)
+
+// packAreas are the folders of a pack besides its mods folders that may
+// hold jars, mirroring the minecraft directory.
+var packAreas = ownedAreas
+
+// strictPack makes jars outside the pack's folders fail the run instead of
+// being warned about, for the pack repository's CI. Set by --strict-pack.
+var strictPack bool
+
+// ClassifyEntry classifies the archive entry name of a pack whose mods are
+// installed from folder, empty for the legacy layout. folders are the mods
+// folders of every Minecraft version the pack supports and transforms the
+// manifest's, whose files are recognized wherever they are.
+func ClassifyEntry(name string, folder string, folders []string, transforms map[string]string) string {
+	p := PackPath(name)
+	switch {
+	case p == "" || strings.HasSuffix(p, "/"):
+		return entryIgnored
+	case isModEntry(name, folder):
+		return entryEligible
+	case !strings.HasSuffix(strings.ToLower(p), ".jar"):
+		return entryIgnored
+	}
+	if _, ok := transforms[p]; ok {
+		return entryIgnored
+	}
+	for _, dir := range append(append([]string{}, folders...), packAreas...) {
+		if dir != "" && strings.HasPrefix(p, dir+"/") {
+			return entryIgnored
+		}
+	}
+	if folder == "" && legacyModPattern.MatchString(p) {
+		return entryIgnored
+	}
+	return entrySuspicious
+}
+
+// strayJarsError is a pack with suspicious entries in strict mode.
+type strayJarsError struct {
+	paths []string
+}
+
+func (e *strayJarsError) Error() string {
+	return fmt.Sprintf("the pack has jars outside its mods folders: %s", strings.Join(e.paths, ", "))
+}
+
+// checkStrayJars warns about the suspicious entries of the archive r, or
+// fails on them in strict mode.
+func checkStrayJars(r *zip.Reader, manifest *PackManifest, folder string, versions map[string]string) error {
+	var folders []string
+	for _, dir := range versions {
+		folders = append(folders, dir)
+	}
+	var transforms map[string]string
+	if manifest != nil {
+		transforms = manifest.Transforms
+	}
+	var stray []string
+	for _, f := range r.File {
+		if ClassifyEntry(f.Name, folder, folders, transforms) == entrySuspicious {
+			stray = append(stray, PackPath(f.Name))
+			Warn(warnPack, T("pack.stray", PackPath(f.Name)))
+		}
+	}
+	if len(stray) > 0 && strictPack {
+		return &strayJarsError{paths: stray}
+	}
+	return nil
+}
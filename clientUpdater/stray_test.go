@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClassifyEntry(t *testing.T) {
+	folders := []string{"mods-1.20.1", "mods-1.21"}
+	transforms := map[string]string{"tools/options-patch.jar": "options.txt"}
+	tests := []struct {
+		name   string
+		folder string
+		want   string
+	}{
+		{"rxmc-Mods-master/", "mods-1.20.1", entryIgnored},
+		{"rxmc-Mods-master/mods-1.20.1/", "mods-1.20.1", entryIgnored},
+		{"rxmc-Mods-master/pack.json", "mods-1.20.1", entryIgnored},
+		{"rxmc-Mods-master/README.md", "mods-1.20.1", entryIgnored},
+		{"rxmc-Mods-master/mods-1.20.1/sodium.jar", "mods-1.20.1", entryEligible},
+		{"rxmc-Mods-master/mods-1.20.1/perf/lithium.jar", "mods-1.20.1", entryEligible},
+		{"rxmc-Mods-master/mods-1.20.1/notes.txt", "mods-1.20.1", entryIgnored},
+		// the mods of the other versions are the pack's as well
+		{"rxmc-Mods-master/mods-1.21/sodium.jar", "mods-1.20.1", entryIgnored},
+		{"rxmc-Mods-master/config/bundled.jar", "mods-1.20.1", entryIgnored},
+		{"rxmc-Mods-master/resourcepacks/Faithful.jar", "mods-1.20.1", entryIgnored},
+		{"rxmc-Mods-master/shaderpacks/BSL.jar", "mods-1.20.1", entryIgnored},
+		{"rxmc-Mods-master/tools/options-patch.jar", "mods-1.20.1", entryIgnored},
+		// jars anywhere else are committed by accident
+		{"rxmc-Mods-master/test.jar", "mods-1.20.1", entrySuspicious},
+		{"rxmc-Mods-master/Sodium-Debug.JAR", "mods-1.20.1", entrySuspicious},
+		{"rxmc-Mods-master/tools/other.jar", "mods-1.20.1", entrySuspicious},
+		{"rxmc-Mods-master/mods-1.20/sodium.jar", "mods-1.20.1", entrySuspicious},
+		{"rxmc-Mods-master/configs/bundled.jar", "mods-1.20.1", entrySuspicious},
+		// the legacy layout has a single mods folder
+		{"rxmc-Mods-master/mods/sodium.jar", "", entryEligible},
+		{"rxmc-Mods-master/1.20.1-mods/sodium.jar", "", entryEligible},
+		{"rxmc-Mods-master/test.jar", "", entrySuspicious},
+		{"rxmc-Mods-master/libs/gson.jar", "", entrySuspicious},
+		{"rxmc-Mods-master/config/bundled.jar", "", entryIgnored},
+	}
+	for _, test := range tests {
+		if got := ClassifyEntry(test.name, test.folder, folders, transforms); got != test.want {
+			t.Errorf("%s installed from %q is %s, want %s", test.name, test.folder, got, test.want)
+		}
+	}
+}
+
+// useStrictPack sets --strict-pack for the test.
+func useStrictPack(t *testing.T, strict bool) {
+	t.Helper()
+	saved := strictPack
+	strictPack = strict
+	t.Cleanup(func() { strictPack = saved })
+}
+
+// TestValidateArchiveStrayJars validates a pack with jars committed to the
+// wrong places: they are warned about, and fail it in strict mode.
+func TestValidateArchiveStrayJars(t *testing.T) {
+	archive := packZip(t, map[string]string{
+		"pack.json":              `{"versions": {"1.20.1": "mods-1.20.1"}}`,
+		"mods-1.20.1/sodium.jar": "sodium",
+		"test.jar":               "test",
+		"tools/debug.jar":        "debug",
+	})
+	for _, strict := range []bool{false, true} {
+		useWarnings(t)
+		defer PrintWarningsTo(&strings.Builder{})()
+		useStrictPack(t, strict)
+		_, err := ValidateArchive(archive, "1.20.1")
+		warned := warningMessages(warnPack)
+		if len(warned) != 2 || !strings.HasPrefix(warned[0], "test.jar is a jar outside the pack's mods folders") || !strings.HasPrefix(warned[1], "tools/debug.jar is a jar") {
+			t.Errorf("strict %t: warned %q", strict, warned)
+		}
+		var stray *strayJarsError
+		switch {
+		case !strict && err != nil:
+			t.Errorf("failed with %v", err)
+		case strict && (!errors.As(err, &stray) || err.Error() != "the pack has jars outside its mods folders: test.jar, tools/debug.jar"):
+			t.Errorf("strict: failed with %v", err)
+		}
+	}
+}
+
+// TestUpdateWithStrayJars updates from a pack with a jar at its root: it
+// isn't installed, and with --strict-pack the run fails before anything
+// changes.
+func TestUpdateWithStrayJars(t *testing.T) {
+	useWarnings(t)
+	defer PrintWarningsTo(&strings.Builder{})()
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium", "test.jar": "test"})
+	useRunState(t)
+	if code := exitsWith(func() { u.run(t, "--strict-pack") }); code != exitLocal {
+		t.Errorf("exited with %d", code)
+	}
+	if got := dirNames(t, u.mods); got != "" {
+		t.Errorf("installed %s", got)
+	}
+	log := readFile(t, filepath.Join(u.state, "clientUpdate.log"))
+	if !strings.Contains(log, "the pack has jars outside its mods folders: test.jar") {
+		t.Errorf("not in the log:\n%s", log)
+	}
+
+	output := readFile(t, u.run(t))
+	if got := dirNames(t, u.mods); got != "sodium.jar" {
+		t.Errorf("installed %s, output:\n%s", got, output)
+	}
+	if _, err := os.Stat(filepath.Join(u.minecraft, "test.jar")); !os.IsNotExist(err) {
+		t.Errorf("test.jar was installed: %v", err)
+	}
+}
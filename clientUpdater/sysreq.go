@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// SystemRequirements are what the pack needs to run well. They are soft:
+// a system below them is warned about before the update, not refused.
+type SystemRequirements struct {
+	// MinRAMMB is the memory, in MB, below which the game is unplayable,
+	// RecommendedRAMMB what it needs to run smoothly.
+	MinRAMMB         int `json:"minRAMMB,omitempty"`
+	RecommendedRAMMB int `json:"recommendedRAMMB,omitempty"`
+	// MinJava is the oldest major version of java the pack runs on.
+	MinJava int `json:"minJava,omitempty"`
+	// ConfirmBelowMinimum has players confirm an update on a system below
+	// the minimums separately.
+	ConfirmBelowMinimum bool `json:"confirmBelowMinimum,omitempty"`
+}
+
+// validate reports requirements, at the place at of the manifest, that
+// can't be meant.
+func (r *SystemRequirements) validate(at string, problems *manifestProblems) {
+	for _, field := range []struct {
+		name  string
+		value int
+	}{{"minRAMMB", r.MinRAMMB}, {"recommendedRAMMB", r.RecommendedRAMMB}, {"minJava", r.MinJava}} {
+		if field.value < 0 {
+			problems.add(fieldPath(at, field.name), "must not be negative")
+		}
+	}
+	if r.RecommendedRAMMB > 0 && r.RecommendedRAMMB < r.MinRAMMB {
+		problems.add(fieldPath(at, "recommendedRAMMB"), "%d is below minRAMMB %d", r.RecommendedRAMMB, r.MinRAMMB)
+	}
+}
+
+// SystemFacts is what was found out about the system the pack runs on.
+type SystemFacts struct {
+	// TotalRAMMB is the physical memory in MB, 0 when it couldn't be told.
+	TotalRAMMB int `json:"totalRAMMB"`
+	// Java is the major version of the java on the PATH, 0 when there is
+	// none. JavaVersion is the full version it reported.
+	Java        int    `json:"java"`
+	JavaVersion string `json:"javaVersion,omitempty"`
+}
+
+// SystemProbe finds out about the system, replaced by tests simulating
+// another one.
+type SystemProbe interface {
+	// TotalRAM returns the physical memory in bytes.
+	TotalRAM() (uint64, error)
+	// JavaVersion returns what java -version printed.
+	JavaVersion() (string, error)
+}
+
+type hostProbe struct{}
+
+func (hostProbe) TotalRAM() (uint64, error) { return totalMemory() }
+
+func (hostProbe) JavaVersion() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), diagnoseJavaWait)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, javaCommand, "-version").CombinedOutput()
+	return string(output), err
+}
+
+// systemProbe is the SystemProbe in use.
+var systemProbe SystemProbe = hostProbe{}
+
+// javaVersionPattern finds the version in java -version, e.g.
+// openjdk version "17.0.2" or java version "1.8.0_292".
+var javaVersionPattern = regexp.MustCompile(`version "([^"]+)"`)
+
+// javaMajorPattern splits the major version, and the minor one for 1.x,
+// off a java version.
+var javaMajorPattern = regexp.MustCompile(`^(\d+)(?:\.(\d+))?`)
+
+// javaMajor returns the major version of a java version, 8 for the 1.8.0
+// style of java 8 and before, 0 when it isn't one.
+func javaMajor(version string) int {
+	m := javaMajorPattern.FindStringSubmatch(version)
+	if m == nil {
+		return 0
+	}
+	major, _ := strconv.Atoi(m[1])
+	if major == 1 && m[2] != "" {
+		major, _ = strconv.Atoi(m[2])
+	}
+	return major
+}
+
+// probedSystem caches ProbeSystem, the system doesn't change during a run.
+var probedSystem struct {
+	once  sync.Once
+	facts SystemFacts
+}
+
+// ProbeSystem finds out about the system once per run. What can't be
+// found out is logged and left 0.
+func ProbeSystem() SystemFacts {
+	probedSystem.once.Do(func() {
+		probedSystem.facts = probeSystem(systemProbe)
+	})
+	return probedSystem.facts
+}
+
+func probeSystem(probe SystemProbe) SystemFacts {
+	var facts SystemFacts
+	if total, err := probe.TotalRAM(); err != nil {
+		Logf("system: total memory unknown: %s", err)
+	} else {
+		facts.TotalRAMMB = int(total >> 20)
+	}
+	output, err := probe.JavaVersion()
+	if m := javaVersionPattern.FindStringSubmatch(output); m != nil {
+		facts.JavaVersion = m[1]
+		facts.Java = javaMajor(m[1])
+	} else {
+		Logf("system: no java version found: %v", err)
+	}
+	Logf("system: %d MB memory, java %q", facts.TotalRAMMB, facts.JavaVersion)
+	return facts
+}
+
+// RequirementIssue is a requirement of the pack the system doesn't meet.
+type RequirementIssue struct {
+	// Minimum is set when the system is below a minimum, not only below
+	// what is recommended.
+	Minimum bool
+	Message string
+}
+
+// CheckRequirements compares the system with the requirements. What
+// couldn't be found out about the system is assumed to meet them: a
+// missing java on the PATH usually means the launcher brings its own.
+func CheckRequirements(req *SystemRequirements, facts SystemFacts) []RequirementIssue {
+	if req == nil {
+		return nil
+	}
+	var issues []RequirementIssue
+	// systems report a little less memory than is installed, what the
+	// firmware and graphics keep for themselves, so an 8 GB laptop meets
+	// 8 GB
+	ram := facts.TotalRAMMB
+	installed := ram + ram/16
+	switch {
+	case ram == 0:
+	case installed < req.MinRAMMB:
+		issues = append(issues, RequirementIssue{Minimum: true, Message: T("requirements.ram.min", formatRAM(ram), formatRAM(req.MinRAMMB))})
+	case installed < req.RecommendedRAMMB:
+		issues = append(issues, RequirementIssue{Message: T("requirements.ram.recommended", formatRAM(ram), formatRAM(req.RecommendedRAMMB))})
+	}
+	if facts.Java > 0 && facts.Java < req.MinJava {
+		issues = append(issues, RequirementIssue{Minimum: true, Message: T("requirements.java", facts.JavaVersion, req.MinJava)})
+	}
+	return issues
+}
+
+// BelowMinimum reports whether one of the issues is below a minimum.
+func BelowMinimum(issues []RequirementIssue) bool {
+	for _, issue := range issues {
+		if issue.Minimum {
+			return true
+		}
+	}
+	return false
+}
+
+// formatRAM formats MB of memory as GB, the way computers are sold.
+func formatRAM(mb int) string {
+	return fmt.Sprintf("%.1f GB", float64(mb)/1024)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeProbe is a system with ram bytes of memory and a java printing java
+// for its version, failing with the errors set.
+type fakeProbe struct {
+	ram     uint64
+	ramErr  error
+	java    string
+	javaErr error
+}
+
+func (p fakeProbe) TotalRAM() (uint64, error) { return p.ram, p.ramErr }
+
+func (p fakeProbe) JavaVersion() (string, error) { return p.java, p.javaErr }
+
+// useSystemProbe has the run find probe's system.
+func useSystemProbe(t *testing.T, probe SystemProbe) {
+	t.Helper()
+	saved := systemProbe
+	systemProbe = probe
+	reset := func() {
+		probedSystem.once = sync.Once{}
+		probedSystem.facts = SystemFacts{}
+	}
+	reset()
+	t.Cleanup(func() {
+		systemProbe = saved
+		reset()
+	})
+}
+
+func TestJavaMajor(t *testing.T) {
+	for version, want := range map[string]int{"17.0.2": 17, "21": 21, "1.8.0_292": 8, "1.7.0": 7, "9-ea": 9, "": 0, "openjdk": 0} {
+		if got := javaMajor(version); got != want {
+			t.Errorf("javaMajor(%q) = %d, want %d", version, got, want)
+		}
+	}
+}
+
+func TestProbeSystem(t *testing.T) {
+	tests := []struct {
+		probe fakeProbe
+		want  SystemFacts
+	}{
+		{fakeProbe{ram: 8 << 30, java: "openjdk version \"17.0.2\" 2022-01-18\nOpenJDK Runtime Environment"}, SystemFacts{TotalRAMMB: 8192, Java: 17, JavaVersion: "17.0.2"}},
+		{fakeProbe{ram: 4 << 30, java: `java version "1.8.0_292"`}, SystemFacts{TotalRAMMB: 4096, Java: 8, JavaVersion: "1.8.0_292"}},
+		// what can't be found out is left 0
+		{fakeProbe{ramErr: errors.New("no sysctl"), javaErr: errors.New(`exec: "java": executable file not found in $PATH`)}, SystemFacts{}},
+	}
+	for _, test := range tests {
+		if got := probeSystem(test.probe); got != test.want {
+			t.Errorf("probed %+v, want %+v", got, test.want)
+		}
+	}
+
+	useSystemProbe(t, fakeProbe{ram: 16 << 30})
+	if facts := ProbeSystem(); facts.TotalRAMMB != 16384 {
+		t.Errorf("probed %+v", facts)
+	}
+	// the system is probed once per run
+	systemProbe = fakeProbe{ram: 2 << 30}
+	if facts := ProbeSystem(); facts.TotalRAMMB != 16384 {
+		t.Errorf("probed again: %+v", facts)
+	}
+}
+
+func TestTotalMemory(t *testing.T) {
+	// every system the tests run on has a gigabyte or so
+	if total, err := totalMemory(); err != nil || total < 256<<20 {
+		t.Errorf("total memory %d, %v", total, err)
+	}
+}
+
+func TestCheckRequirements(t *testing.T) {
+	req := &SystemRequirements{MinRAMMB: 4096, RecommendedRAMMB: 8192, MinJava: 17}
+	tests := []struct {
+		name  string
+		facts SystemFacts
+		// issues are the messages, prefixed "min: " for minimums.
+		issues string
+	}{
+		{name: "meets", facts: SystemFacts{TotalRAMMB: 16384, Java: 21, JavaVersion: "21.0.1"}},
+		// an 8 GB system reports a little less
+		{name: "8 GB reported as less", facts: SystemFacts{TotalRAMMB: 7800, Java: 17, JavaVersion: "17.0.2"}},
+		{name: "below recommended", facts: SystemFacts{TotalRAMMB: 6000, Java: 17}, issues: "5.9 GB of memory, the pack recommends 8.0 GB; lower the render distance if the game stutters"},
+		{name: "below minimum", facts: SystemFacts{TotalRAMMB: 2048, Java: 8, JavaVersion: "1.8.0_292"}, issues: "min: 2.0 GB of memory, the pack needs at least 4.0 GB; expect very low frame rates or crashes|min: java 1.8.0_292 found, the pack needs java 17 or newer; make sure the launcher uses a newer one"},
+		// unknowns are assumed to meet the requirements
+		{name: "unknown", facts: SystemFacts{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			issues := CheckRequirements(req, test.facts)
+			var got []string
+			for _, issue := range issues {
+				if issue.Minimum {
+					got = append(got, "min: "+issue.Message)
+				} else {
+					got = append(got, issue.Message)
+				}
+			}
+			if strings.Join(got, "|") != test.issues {
+				t.Errorf("issues %q, want %q", got, test.issues)
+			}
+			if BelowMinimum(issues) != strings.HasPrefix(test.issues, "min: ") {
+				t.Errorf("below minimum: %t", BelowMinimum(issues))
+			}
+		})
+	}
+	if issues := CheckRequirements(nil, SystemFacts{TotalRAMMB: 1024}); issues != nil {
+		t.Errorf("no requirements: %+v", issues)
+	}
+}
+
+func TestParseRequirements(t *testing.T) {
+	manifest, err := parsePackManifest([]byte(`{"requirements": {"minRAMMB": 4096, "recommendedRAMMB": 8192, "minJava": 17, "confirmBelowMinimum": true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (SystemRequirements{MinRAMMB: 4096, RecommendedRAMMB: 8192, MinJava: 17, ConfirmBelowMinimum: true}); manifest.Requirements == nil || *manifest.Requirements != want {
+		t.Errorf("requirements %+v", manifest.Requirements)
+	}
+	for _, broken := range []string{
+		`{"requirements": {"minRAMMB": -1}}`,
+		`{"requirements": {"minRAMMB": 8192, "recommendedRAMMB": 4096}}`,
+		`{"requirements": {"minRAMMB": "4G"}}`,
+	} {
+		if _, err := DecodePackManifest([]byte(broken)); err == nil {
+			t.Errorf("accepted %s", broken)
+		}
+	}
+}
+
+// TestUpdateBelowRequirements updates on a system below the pack's
+// minimums: the preflight warns, and an unattended run confirms itself.
+func TestUpdateBelowRequirements(t *testing.T) {
+	useSystemProbe(t, fakeProbe{ram: 3 << 30, java: `openjdk version "17.0.2"`})
+	u := newFakeUpdate(t, map[string]string{
+		"pack.json":       `{"requirements": {"minRAMMB": 4096, "recommendedRAMMB": 8192, "minJava": 17, "confirmBelowMinimum": true}}`,
+		"mods/sodium.jar": "sodium",
+	})
+	output := readFile(t, u.run(t))
+	for _, want := range []string{
+		"! 3.0 GB of memory, the pack needs at least 4.0 GB",
+		"below the minimum requirements of the pack. Update anyway? [Y/n]: y\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("no %q in the output:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "java 17.0.2 found") {
+		t.Errorf("warned about java:\n%s", output)
+	}
+	if got := dirNames(t, u.mods); got != "sodium.jar" {
+		t.Errorf("installed %s", got)
+	}
+}
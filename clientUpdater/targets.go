@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TargetGroup is the mods directories sharing one minecraft directory. They
+// share versions/ and libraries/, so they are updated one after another and
+// the loader is installed at most once for all of them; different groups
+// are updated concurrently.
+type TargetGroup struct {
+	MinecraftPath string
+	ModPaths      []string
+}
+
+// GroupTargets groups mods directories by their minecraft directory. Groups
+// and the targets within them keep the order they were given in, and
+// duplicates are dropped.
+func GroupTargets(modPaths []string) []TargetGroup {
+	var groups []TargetGroup
+	index := map[string]int{}
+	seen := map[string]bool{}
+	for _, modPath := range modPaths {
+		key := filepath.Clean(modPath)
+		if isWindows() {
+			key = strings.ToLower(key)
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		root, _ := FindMinecraftRoot(modPath)
+		rootKey := root
+		if isWindows() {
+			rootKey = strings.ToLower(root)
+		}
+		i, ok := index[rootKey]
+		if !ok {
+			i = len(groups)
+			index[rootKey] = i
+			groups = append(groups, TargetGroup{MinecraftPath: root})
+		}
+		groups[i].ModPaths = append(groups[i].ModPaths, modPath)
+	}
+	return groups
+}
+
+// TargetResult is the outcome of updating one target.
+type TargetResult struct {
+	ModPath string
+	Group   int
+	// Waited is how long the target waited for the targets before it in
+	// its group.
+	Waited   time.Duration
+	Duration time.Duration
+	Err      error
+}
+
+// RunTargetGroups updates every target, the groups concurrently and the
+// targets of a group in order. first is set for the first target of each
+// group, the one to install the loader. Results are returned in group
+// order.
+func RunTargetGroups(groups []TargetGroup, update func(group TargetGroup, modPath string, first bool) error) []TargetResult {
+	results := make([][]TargetResult, len(groups))
+	start := clock.Now()
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		wg.Add(1)
+		go func(i int, group TargetGroup) {
+			defer wg.Done()
+			for j, modPath := range group.ModPaths {
+				began := clock.Now()
+				err := update(group, modPath, j == 0)
+				results[i] = append(results[i], TargetResult{
+					ModPath:  modPath,
+					Group:    i,
+					Waited:   began.Sub(start),
+					Duration: clock.Now().Sub(began),
+					Err:      err,
+				})
+			}
+		}(i, group)
+	}
+	wg.Wait()
+
+	var all []TargetResult
+	for _, r := range results {
+		all = append(all, r...)
+	}
+	return all
+}
+
+// PrintTargetOrder explains in which order the targets are going to be
+// updated.
+func PrintTargetOrder(groups []TargetGroup) {
+	fmt.Println(T("targets.order", len(groups)))
+	for i, group := range groups {
+		fmt.Println(T("targets.group", i+1, group.MinecraftPath))
+		for j, modPath := range group.ModPaths {
+			fmt.Printf("    %d. %s\n", j+1, modPath)
+		}
+	}
+}
+
+// PrintTargetResults summarizes the update of every target.
+func PrintTargetResults(results []TargetResult) {
+	for _, r := range results {
+		status := T("targets.ok")
+		if r.Err != nil {
+			status = T("targets.failed", r.Err)
+		}
+		fmt.Println(T("targets.result", r.Group+1, r.ModPath, status, r.Waited.Round(time.Second), r.Duration.Round(time.Second)))
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupTargets(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"a/.minecraft/launcher_profiles.json":      "{}",
+		"b/.minecraft/versions/1.20.1/1.20.1.json": "{}",
+		"b/.minecraft/profiles/test/mods/.keep":    "",
+		"c/instances/modded/.minecraft/mods/.keep": "",
+		"a/.minecraft/mods-test/.keep":             "",
+		"a/.minecraft/mods/.keep":                  "",
+		"b/.minecraft/mods/.keep":                  "",
+	})
+	dir := func(p string) string { return filepath.Join(root, filepath.FromSlash(p)) }
+	groups := GroupTargets([]string{
+		dir("a/.minecraft/mods"),
+		dir("b/.minecraft/mods"),
+		dir("a/.minecraft/mods-test"),
+		dir("a/.minecraft/mods/"),
+		// no marker above it, its parent is taken
+		dir("c/instances/modded/.minecraft/mods"),
+		dir("b/.minecraft/profiles/test/mods"),
+	})
+	want := []TargetGroup{
+		{MinecraftPath: dir("a/.minecraft"), ModPaths: []string{dir("a/.minecraft/mods"), dir("a/.minecraft/mods-test")}},
+		{MinecraftPath: dir("b/.minecraft"), ModPaths: []string{dir("b/.minecraft/mods"), dir("b/.minecraft/profiles/test/mods")}},
+		{MinecraftPath: dir("c/instances/modded/.minecraft"), ModPaths: []string{dir("c/instances/modded/.minecraft/mods")}},
+	}
+	if mustJSON(t, groups) != mustJSON(t, want) {
+		t.Errorf("groups %+v", groups)
+	}
+	if groups := GroupTargets(nil); len(groups) != 0 {
+		t.Errorf("groups of nothing: %+v", groups)
+	}
+}
+
+// TestRunTargetGroups checks groups run at the same time and the targets
+// of a group one after another, the first installing the loader.
+func TestRunTargetGroups(t *testing.T) {
+	groups := []TargetGroup{
+		{MinecraftPath: "a", ModPaths: []string{"a/mods", "a/mods-test", "a/mods-old"}},
+		{MinecraftPath: "b", ModPaths: []string{"b/mods"}},
+	}
+	var mu sync.Mutex
+	running := map[string]int{}
+	var order []string
+	// a's first target only finishes once b's started
+	bStarted := make(chan struct{})
+	failed := errors.New("disk full")
+	results := RunTargetGroups(groups, func(group TargetGroup, modPath string, first bool) error {
+		mu.Lock()
+		running[group.MinecraftPath]++
+		if running[group.MinecraftPath] > 1 {
+			t.Errorf("%s updated along with another target of %s", modPath, group.MinecraftPath)
+		}
+		order = append(order, fmt.Sprintf("%s(%t)", modPath, first))
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running[group.MinecraftPath]--
+			mu.Unlock()
+		}()
+		switch modPath {
+		case "a/mods":
+			select {
+			case <-bStarted:
+			case <-time.After(5 * time.Second):
+				t.Error("the groups ran one after another")
+			}
+		case "b/mods":
+			close(bStarted)
+		case "a/mods-test":
+			return failed
+		}
+		return nil
+	})
+
+	var got []string
+	for _, r := range results {
+		got = append(got, fmt.Sprintf("%d %s %v", r.Group, r.ModPath, r.Err))
+	}
+	// a failed target doesn't stop the ones after it
+	want := "0 a/mods <nil>, 0 a/mods-test disk full, 0 a/mods-old <nil>, 1 b/mods <nil>"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("results %s", strings.Join(got, ", "))
+	}
+	var groupA []string
+	for _, target := range order {
+		if strings.HasPrefix(target, "a/") {
+			groupA = append(groupA, target)
+		}
+	}
+	if strings.Join(groupA, " ") != "a/mods(true) a/mods-test(false) a/mods-old(false)" {
+		t.Errorf("updated %v", order)
+	}
+}
+
+func TestRunTargetGroupsWaits(t *testing.T) {
+	fake := useFakeClock(t)
+	groups := []TargetGroup{{MinecraftPath: "a", ModPaths: []string{"a/mods", "a/mods-test"}}}
+	results := RunTargetGroups(groups, func(group TargetGroup, modPath string, first bool) error {
+		fake.Sleep(10 * time.Second)
+		return nil
+	})
+	if len(results) != 2 || results[0].Waited != 0 || results[1].Waited != 10*time.Second || results[1].Duration != 10*time.Second {
+		t.Errorf("results %+v", results)
+	}
+}
+
+func TestPrintTargets(t *testing.T) {
+	groups := []TargetGroup{
+		{MinecraftPath: "/a", ModPaths: []string{"/a/mods", "/a/mods-test"}},
+		{MinecraftPath: "/b", ModPaths: []string{"/b/mods"}},
+	}
+	got := captureStdout(t, func() { PrintTargetOrder(groups) })
+	want := "Updating 2 more group(s) of targets. Groups run at the same time, targets sharing a minecraft directory wait for each other:\n" +
+		"  Group 1 (/a):\n" +
+		"    1. /a/mods\n" +
+		"    2. /a/mods-test\n" +
+		"  Group 2 (/b):\n" +
+		"    1. /b/mods\n"
+	if got != want {
+		t.Errorf("order:\n%s", got)
+	}
+
+	got = captureStdout(t, func() {
+		PrintTargetResults([]TargetResult{
+			{ModPath: "/a/mods", Duration: 2 * time.Second},
+			{ModPath: "/a/mods-test", Waited: 2 * time.Second, Duration: time.Second, Err: os.ErrPermission},
+		})
+	})
+	want = "  [1] /a/mods: OK (started after 0s, took 2s)\n" +
+		"  [1] /a/mods-test: FAILED: permission denied (started after 2s, took 1s)\n"
+	if got != want {
+		t.Errorf("results:\n%s", got)
+	}
+}
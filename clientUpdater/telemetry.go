@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// statsTimeout bounds sending the statistics, a slow endpoint must never
+// hold up the player.
+const statsTimeout = 3 * time.Second
+
+// UpdateStats is everything sent after an update when the player opted in.
+// It deliberately holds no paths, names or anything else identifying the
+// player; InstallID is random and only tells installs apart.
+type UpdateStats struct {
+	InstallID      string `json:"installId"`
+	PackVersion    string `json:"packVersion"`
+	MCVersion      string `json:"mcVersion"`
+	OS             string `json:"os"`
+	UpdaterVersion string `json:"updaterVersion"`
+	// Frozen is set when the player is frozen on PackVersion, see Freeze.
+	Frozen bool `json:"frozen,omitempty"`
+}
+
+// BuildUpdateStats assembles the statistics payload.
+func BuildUpdateStats(installID string, packVersion string, mcVersion string, goos string, updaterVersion string) UpdateStats {
+	return UpdateStats{
+		InstallID:      installID,
+		PackVersion:    packVersion,
+		MCVersion:      mcVersion,
+		OS:             goos,
+		UpdaterVersion: updaterVersion,
+	}
+}
+
+// NewInstallID returns a random version 4 UUID.
+func NewInstallID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return ""
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	s := hex.EncodeToString(id[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// SendUpdateStats posts the statistics to url. Failures are only logged.
+func SendUpdateStats(url string, stats UpdateStats) {
+	body, err := json.Marshal(stats)
+	if err != nil {
+		return
+	}
+	resp, err := withTimeout(statsTimeout).Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		Logf("stats: %s", err)
+		return
+	}
+	resp.Body.Close()
+	Logf("stats: sent to %s, %s", url, resp.Status)
+}
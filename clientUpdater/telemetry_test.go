@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// payloadFields lists the fields of a JSON object, sorted.
+func payloadFields(t *testing.T, payload []byte) string {
+	t.Helper()
+	var fields map[string]interface{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+// TestBuildUpdateStats checks the payload holds exactly the fields players
+// were told about, and nothing else.
+func TestBuildUpdateStats(t *testing.T) {
+	stats := BuildUpdateStats("0b0e8a2c-5d1f-4c3e-9a7b-1d2e3f4a5b6c", "2024.06", "1.20.1", "windows", "1.4.0")
+	payload := []byte(mustJSON(t, stats))
+	if got := payloadFields(t, payload); got != "installId mcVersion os packVersion updaterVersion" {
+		t.Errorf("fields %s", got)
+	}
+	want := `{"installId":"0b0e8a2c-5d1f-4c3e-9a7b-1d2e3f4a5b6c","packVersion":"2024.06","mcVersion":"1.20.1","os":"windows","updaterVersion":"1.4.0"}`
+	if string(payload) != want {
+		t.Errorf("payload %s", payload)
+	}
+	stats.Frozen = true
+	if got := payloadFields(t, []byte(mustJSON(t, stats))); got != "frozen installId mcVersion os packVersion updaterVersion" {
+		t.Errorf("fields of a frozen install %s", got)
+	}
+}
+
+func TestNewInstallID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	a, b := NewInstallID(), NewInstallID()
+	if !uuid.MatchString(a) || !uuid.MatchString(b) || a == b {
+		t.Errorf("ids %s, %s", a, b)
+	}
+}
+
+// statsServer records the statistics posted to stats.example.com and
+// passes everything else on.
+type statsServer struct {
+	next  http.Handler
+	mu    sync.Mutex
+	posts []string
+}
+
+func (s *statsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Host != "stats.example.com" {
+		s.next.ServeHTTP(w, r)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	s.posts = append(s.posts, r.Method+" "+r.URL.Path+" "+r.Header.Get("Content-Type")+" "+string(body))
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *statsServer) Posts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.posts...)
+}
+
+func TestSendUpdateStats(t *testing.T) {
+	stats := &statsServer{next: http.NotFoundHandler()}
+	useTestServer(t, stats)
+	SendUpdateStats("https://stats.example.com/report", UpdateStats{InstallID: "id", PackVersion: "2024.06"})
+	posts := stats.Posts()
+	if len(posts) != 1 || posts[0] != `POST /report application/json {"installId":"id","packVersion":"2024.06","mcVersion":"","os":"","updaterVersion":""}` {
+		t.Errorf("posted %q", posts)
+	}
+	// failures are silent
+	SendUpdateStats("https://nowhere.example.com/report", UpdateStats{})
+	SendUpdateStats("::", UpdateStats{})
+}
+
+func TestSendUpdateStatsTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer close(release)
+	start := time.Now()
+	SendUpdateStats("https://stats.example.com/report", UpdateStats{})
+	if took := time.Since(start); took < statsTimeout || took > statsTimeout+5*time.Second {
+		t.Errorf("gave up after %s", took)
+	}
+}
+
+// TestUpdateStats runs updates of a pack asking for statistics: only
+// players who agreed send them, and --no-telemetry or a pack without a
+// statsURL keeps every run offline.
+func TestUpdateStats(t *testing.T) {
+	agreed, declined := true, false
+	tests := []struct {
+		name      string
+		statsURL  string
+		telemetry *bool
+		args      []string
+		sent      bool
+	}{
+		{name: "agreed", statsURL: "https://stats.example.com/report", telemetry: &agreed, sent: true},
+		{name: "declined", statsURL: "https://stats.example.com/report", telemetry: &declined},
+		// unattended runs don't ask
+		{name: "not asked", statsURL: "https://stats.example.com/report"},
+		{name: "--no-telemetry", statsURL: "https://stats.example.com/report", telemetry: &agreed, args: []string{"--no-telemetry"}},
+		{name: "no statsURL", telemetry: &agreed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manifest := `{"version": "2024.06"}`
+			if test.statsURL != "" {
+				manifest = `{"version": "2024.06", "statsURL": "` + test.statsURL + `"}`
+			}
+			u := newFakeUpdate(t, map[string]string{"pack.json": manifest, "mods/sodium.jar": "sodium"})
+			stats := &statsServer{next: u.server}
+			useTestServer(t, stats)
+			configPath := filepath.Join(u.state, "clientUpdate.json")
+			if err := os.MkdirAll(u.state, 0755); err != nil {
+				t.Fatal(err)
+			}
+			SaveConfig(ConfFile{MCDirectory: u.mods, Telemetry: test.telemetry}, configPath)
+
+			u.clock.Advance(time.Minute)
+			output := runMain(t, append([]string{"--portable", u.state, "--dir", u.mods, "--yes", "--no-tui", "--mc-version", "1.20.1"}, test.args...)...)
+			if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium" {
+				t.Fatalf("not updated, output:\n%s", readFile(t, output))
+			}
+			var saved ConfFile
+			if err := json.Unmarshal([]byte(readFile(t, configPath)), &saved); err != nil {
+				t.Fatal(err)
+			}
+			posts := stats.Posts()
+			if !test.sent {
+				if len(posts) != 0 || saved.InstallID != "" {
+					t.Errorf("sent %q as %s", posts, saved.InstallID)
+				}
+				if (saved.Telemetry == nil) != (test.telemetry == nil) {
+					t.Errorf("the answer is now %v", saved.Telemetry)
+				}
+				return
+			}
+			want := `POST /report application/json {"installId":"` + saved.InstallID + `","packVersion":"2024.06","mcVersion":"1.20.1"`
+			if saved.InstallID == "" || len(posts) != 1 || !strings.HasPrefix(posts[0], want) {
+				t.Errorf("sent %q as %q", posts, saved.InstallID)
+			}
+		})
+	}
+}
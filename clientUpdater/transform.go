@@ -0,0 +1,260 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// TransformContext is what a transform gets to produce its file.
+type TransformContext struct {
+	// Dest is the absolute path the file is written to.
+	Dest string
+	// Values are the template values stored in the config. Transforms may
+	// add to them; they are saved after the update.
+	Values map[string]string
+	// Prompt asks the user for a value, it is nil in non-interactive runs.
+	Prompt func(name string) (string, error)
+	// ApplyRecommended has transforms of default settings overwrite the
+	// player's own, see --apply-recommended-settings.
+	ApplyRecommended bool
+}
+
+// A Transform writes the content of an archive entry to ctx.Dest. It returns
+// false when it decided not to write anything.
+type Transform func(ctx *TransformContext, src io.Reader) (bool, error)
+
+// Transforms holds every transform the pack manifest can refer to by name.
+// Programs embedding the updater can add their own with RegisterTransform.
+var Transforms = map[string]Transform{
+	"template":         templateTransform,
+	"skip-if-exists":   skipIfExistsTransform,
+	"options-defaults": optionsDefaultsTransform,
+}
+
+// RegisterTransform makes a transform available to pack manifests.
+func RegisterTransform(name string, transform Transform) {
+	Transforms[name] = transform
+}
+
+// templateVar matches {{NAME}} placeholders.
+var templateVar = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+// templateTransform substitutes {{NAME}} placeholders with values from the
+// config, asking the user once for any value that isn't known yet.
+func templateTransform(ctx *TransformContext, src io.Reader) (bool, error) {
+	content, err := ioutil.ReadAll(src)
+	if err != nil {
+		return false, err
+	}
+	for _, match := range templateVar.FindAllSubmatch(content, -1) {
+		name := string(match[1])
+		if _, ok := ctx.Values[name]; ok {
+			continue
+		}
+		if ctx.Prompt == nil {
+			return false, fmt.Errorf("a value for %s is needed, run the updater interactively once", name)
+		}
+		value, err := ctx.Prompt(name)
+		if err != nil {
+			return false, err
+		}
+		ctx.Values[name] = value
+		AddLogSecret(value)
+	}
+	content = templateVar.ReplaceAllFunc(content, func(placeholder []byte) []byte {
+		return []byte(ctx.Values[string(templateVar.FindSubmatch(placeholder)[1])])
+	})
+	return true, writeFile(ctx.Dest, content)
+}
+
+// MissingTemplateValues lists the placeholders of the templates among
+// transforms that values has no value for, so they can be asked for before
+// the update starts.
+func MissingTemplateValues(src string, transforms map[string]string, values map[string]string) ([]string, error) {
+	r, err := OpenPackArchive(src)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var missing []string
+	seen := map[string]bool{}
+	for _, f := range r.File {
+		if transforms[PackPath(f.Name)] != "template" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range templateVar.FindAllSubmatch(content, -1) {
+			name := string(match[1])
+			if _, ok := values[name]; !ok && !seen[name] {
+				seen[name] = true
+				missing = append(missing, name)
+			}
+		}
+	}
+	return missing, nil
+}
+
+// skipIfExistsTransform installs a file only when the player doesn't have
+// one yet, so defaults never overwrite their own changes.
+func skipIfExistsTransform(ctx *TransformContext, src io.Reader) (bool, error) {
+	if _, err := os.Stat(ctx.Dest); err == nil {
+		return false, nil
+	}
+	content, err := ioutil.ReadAll(src)
+	if err != nil {
+		return false, err
+	}
+	return true, writeFile(ctx.Dest, content)
+}
+
+func writeFile(dest string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(dest), dirPerm); err != nil {
+		return err
+	}
+	return writeReplacing(dest, content)
+}
+
+// transformDest resolves a manifest destination relative to the minecraft
+// directory, refusing anything that would end up outside of it.
+func transformDest(minecraftPath string, dest string) (string, error) {
+	clean, err := sanitizeEntryPath(dest)
+	if err != nil {
+		return "", fmt.Errorf("%s: illegal destination: %s", dest, err)
+	}
+	return filepath.Join(minecraftPath, clean), nil
+}
+
+// ConfigChange is a file outside the mods directory an update writes: a
+// config, resource pack, shader pack or setting the manifest lists under
+// transforms.
+type ConfigChange struct {
+	// Path is the file's slash separated path below the minecraft
+	// directory.
+	Path      string
+	Transform string
+	// Overwrite is set when the player has the file already, it is then
+	// kept in the backup.
+	Overwrite bool
+}
+
+// PlanConfigChanges lists the files of transforms that writing them below
+// minecraftPath would add or overwrite, sorted by path. Files only
+// installed when missing and already there are left out, nothing changes
+// for them.
+func PlanConfigChanges(transforms map[string]string, minecraftPath string) []ConfigChange {
+	var changes []ConfigChange
+	for dest, name := range transforms {
+		change := ConfigChange{Path: dest, Transform: name}
+		if target, err := transformDest(minecraftPath, dest); err == nil {
+			_, err := os.Stat(target)
+			change.Overwrite = err == nil
+		}
+		if change.Overwrite && name == "skip-if-exists" {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// ApplyTransforms installs the files the manifest lists under transforms.
+// Each is read from the same path in the pack and written below the
+// minecraft directory by its transform. It returns the files written.
+// applyRecommended is passed on to the transforms, see TransformContext.
+func ApplyTransforms(src string, transforms map[string]string, minecraftPath string, backupDir string, journal *Journal, values map[string]string, prompt func(string) (string, error), applyRecommended bool) ([]string, error) {
+	if len(transforms) == 0 {
+		return nil, nil
+	}
+	r, err := OpenPackArchive(src)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	entries := map[string]*zip.File{}
+	for _, f := range r.File {
+		entries[PackPath(f.Name)] = f
+	}
+	dests := make([]string, 0, len(transforms))
+	for dest := range transforms {
+		dests = append(dests, dest)
+	}
+	sort.Strings(dests)
+
+	var written []string
+	for _, dest := range dests {
+		transform, ok := Transforms[transforms[dest]]
+		if !ok {
+			return written, fmt.Errorf("%s: unknown transform %q", dest, transforms[dest])
+		}
+		f, ok := entries[dest]
+		if !ok {
+			return written, fmt.Errorf("%s: not found in the pack", dest)
+		}
+		target, err := transformDest(minecraftPath, dest)
+		if err != nil {
+			return written, err
+		}
+
+		// keep whatever the transform is about to replace
+		var backup, oldSum string
+		if _, err := os.Stat(target); err == nil && transforms[dest] != "skip-if-exists" {
+			if oldSum, err = fileSHA256(target); err != nil {
+				return written, err
+			}
+			backup = filepath.Join(backupDir, "transforms", filepath.FromSlash(dest))
+			if err := os.MkdirAll(filepath.Dir(backup), dirPerm); err != nil {
+				return written, err
+			}
+			if err := copyFile(target, backup); err != nil {
+				return written, err
+			}
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return written, err
+		}
+		ctx := &TransformContext{Dest: target, Values: values, Prompt: prompt, ApplyRecommended: applyRecommended}
+		wrote, err := transform(ctx, rc)
+		rc.Close()
+		if err != nil {
+			return written, fmt.Errorf("%s: %s", dest, err)
+		}
+		if !wrote {
+			Logf("transform %s: %s left as is", transforms[dest], dest)
+			continue
+		}
+		Logf("transform %s: wrote %s", transforms[dest], dest)
+		if backup != "" {
+			if err := journal.Record(JournalEntry{Action: journalOverwrite, Path: target, SHA256: oldSum, Backup: backup}); err != nil {
+				return written, err
+			}
+		}
+		sum, err := fileSHA256(target)
+		if err != nil {
+			return written, err
+		}
+		if err := journal.Record(JournalEntry{Action: journalAdd, Path: target, SHA256: sum}); err != nil {
+			return written, err
+		}
+		written = append(written, target)
+	}
+	return written, nil
+}
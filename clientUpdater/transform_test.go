@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// useLogSecrets starts the test without secrets to redact, restoring them
+// after it.
+func useLogSecrets(t *testing.T) {
+	t.Helper()
+	logSecretsMu.Lock()
+	saved := logSecrets
+	logSecrets = nil
+	logSecretsMu.Unlock()
+	t.Cleanup(func() {
+		logSecretsMu.Lock()
+		logSecrets = saved
+		logSecretsMu.Unlock()
+	})
+}
+
+// captureRunLog has the run log written to the buffer returned.
+func captureRunLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var b bytes.Buffer
+	runLog.SetOutput(&b)
+	t.Cleanup(func() { runLog.SetOutput(ioutil.Discard) })
+	return &b
+}
+
+func TestTemplateTransform(t *testing.T) {
+	useLogSecrets(t)
+	dest := filepath.Join(t.TempDir(), "config", "chat.json")
+	var asked []string
+	ctx := &TransformContext{
+		Dest:   dest,
+		Values: map[string]string{"SERVER": "mc.example.com"},
+		Prompt: func(name string) (string, error) {
+			asked = append(asked, name)
+			return "s3cret-" + name, nil
+		},
+	}
+	wrote, err := templateTransform(ctx, strings.NewReader(`{"server": "{{SERVER}}", "token": "{{ TOKEN }}", "again": "{{TOKEN}}"}`))
+	if err != nil || !wrote {
+		t.Fatal(wrote, err)
+	}
+	if got := readFile(t, dest); got != `{"server": "mc.example.com", "token": "s3cret-TOKEN", "again": "s3cret-TOKEN"}` {
+		t.Errorf("wrote %s", got)
+	}
+	// each value is asked for once, kept to be saved and never logged
+	if strings.Join(asked, " ") != "TOKEN" || ctx.Values["TOKEN"] != "s3cret-TOKEN" {
+		t.Errorf("asked for %v, values %v", asked, ctx.Values)
+	}
+	if got := Redact("token s3cret-TOKEN sent"); got != "token [redacted] sent" {
+		t.Errorf("logged %q", got)
+	}
+}
+
+func TestTemplateTransformWithoutPrompt(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "chat.json")
+	ctx := &TransformContext{Dest: dest, Values: map[string]string{}}
+	if wrote, err := templateTransform(ctx, strings.NewReader("{{TOKEN}}")); wrote || err == nil || !strings.Contains(err.Error(), "TOKEN") {
+		t.Errorf("unattended: %t, %v", wrote, err)
+	}
+	prompted := errors.New("no input")
+	ctx.Prompt = func(string) (string, error) { return "", prompted }
+	if _, err := templateTransform(ctx, strings.NewReader("{{TOKEN}}")); err != prompted {
+		t.Errorf("failed prompt: %v", err)
+	}
+	if _, err := os.Stat(dest); err == nil {
+		t.Error("wrote the template without its values")
+	}
+}
+
+func TestSkipIfExistsTransform(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "options.txt")
+	ctx := &TransformContext{Dest: dest}
+	if wrote, err := skipIfExistsTransform(ctx, strings.NewReader("defaults")); !wrote || err != nil {
+		t.Fatal(wrote, err)
+	}
+	if wrote, err := skipIfExistsTransform(ctx, strings.NewReader("new defaults")); wrote || err != nil {
+		t.Fatal(wrote, err)
+	}
+	if got := readFile(t, dest); got != "defaults" {
+		t.Errorf("the player's file is now %q", got)
+	}
+}
+
+func TestTransformDest(t *testing.T) {
+	minecraft := t.TempDir()
+	if got, err := transformDest(minecraft, "config/./chat.json"); err != nil || got != filepath.Join(minecraft, "config", "chat.json") {
+		t.Errorf("config/./chat.json: %s, %v", got, err)
+	}
+	for _, dest := range []string{"../options.txt", "config/../../x", "/etc/passwd", "C:/x"} {
+		if got, err := transformDest(minecraft, dest); err == nil {
+			t.Errorf("%s allowed as %s", dest, got)
+		}
+	}
+}
+
+// transformPack writes an archive of files, by path in the pack, returning
+// its path.
+func transformPack(t *testing.T, files map[string]string) string {
+	t.Helper()
+	entries := map[string]string{}
+	for name, content := range files {
+		entries["rxmc-Mods-master/"+name] = content
+	}
+	p := filepath.Join(t.TempDir(), "pack.zip")
+	writeZip(t, p, entries)
+	return p
+}
+
+func TestApplyTransforms(t *testing.T) {
+	useLogSecrets(t)
+	logged := captureRunLog(t)
+	pack := transformPack(t, map[string]string{
+		"config/chat.json":     "token={{TOKEN}}",
+		"options.txt":          "pack defaults",
+		"config/new.txt":       "new",
+		"mods-1.20.1/a.jar":    "not transformed",
+		"config/untouched.txt": "not listed",
+	})
+	minecraft := t.TempDir()
+	writeFiles(t, minecraft, map[string]string{
+		"options.txt":      "player's options",
+		"config/chat.json": "token=old",
+	})
+	backup := t.TempDir()
+	journal := &Journal{Path: filepath.Join(t.TempDir(), "journal.jsonl"), Run: "run"}
+	values := map[string]string{}
+	prompt := func(name string) (string, error) { return "s3cret", nil }
+	transforms := map[string]string{
+		"config/chat.json": "template",
+		"options.txt":      "skip-if-exists",
+		"config/new.txt":   "skip-if-exists",
+	}
+
+	written, err := ApplyTransforms(pack, transforms, minecraft, backup, journal, values, prompt, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(minecraft, "config", "chat.json"), filepath.Join(minecraft, "config", "new.txt")}
+	if strings.Join(written, "\n") != strings.Join(want, "\n") {
+		t.Errorf("wrote %v", written)
+	}
+	got := map[string]string{}
+	for _, name := range []string{"options.txt", "config/chat.json", "config/new.txt", "config/untouched.txt"} {
+		if _, err := os.Stat(filepath.Join(minecraft, name)); err == nil {
+			got[name] = readFile(t, filepath.Join(minecraft, name))
+		}
+	}
+	wantFiles := map[string]string{"options.txt": "player's options", "config/chat.json": "token=s3cret", "config/new.txt": "new"}
+	if mustJSON(t, got) != mustJSON(t, wantFiles) {
+		t.Errorf("files %v", got)
+	}
+	if values["TOKEN"] != "s3cret" {
+		t.Errorf("values %v", values)
+	}
+	if got := readFile(t, filepath.Join(backup, "transforms", "config", "chat.json")); got != "token=old" {
+		t.Errorf("backed up %q", got)
+	}
+
+	entries, err := ReadJournal(journal.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, entry := range entries {
+		rel, _ := filepath.Rel(minecraft, entry.Path)
+		actions = append(actions, entry.Action+" "+filepath.ToSlash(rel))
+	}
+	if got := strings.Join(actions, ", "); got != "overwrite config/chat.json, add config/chat.json, add config/new.txt" {
+		t.Errorf("journaled %s", got)
+	}
+	if strings.Contains(logged.String(), "s3cret") || !strings.Contains(logged.String(), "options.txt left as is") {
+		t.Errorf("logged:\n%s", logged)
+	}
+}
+
+func TestApplyTransformsErrors(t *testing.T) {
+	pack := transformPack(t, map[string]string{"options.txt": "defaults"})
+	tests := map[string]map[string]string{
+		`options.txt: unknown transform "merge"`: {"options.txt": "merge"},
+		"servers.dat: not found in the pack":     {"servers.dat": "skip-if-exists"},
+	}
+	for want, transforms := range tests {
+		journal := &Journal{Path: filepath.Join(t.TempDir(), "journal.jsonl")}
+		_, err := ApplyTransforms(pack, transforms, t.TempDir(), t.TempDir(), journal, map[string]string{}, nil, false)
+		if err == nil || err.Error() != want {
+			t.Errorf("error %v, want %s", err, want)
+		}
+	}
+	if written, err := ApplyTransforms("", nil, "", "", nil, nil, nil, false); written != nil || err != nil {
+		t.Errorf("without transforms: %v, %v", written, err)
+	}
+}
+
+func TestRegisterTransform(t *testing.T) {
+	defer delete(Transforms, "upper")
+	RegisterTransform("upper", func(ctx *TransformContext, src io.Reader) (bool, error) {
+		content, err := ioutil.ReadAll(src)
+		if err != nil {
+			return false, err
+		}
+		return true, writeFile(ctx.Dest, bytes.ToUpper(content))
+	})
+	pack := transformPack(t, map[string]string{"config/motd.txt": "welcome"})
+	minecraft := t.TempDir()
+	journal := &Journal{Path: filepath.Join(t.TempDir(), "journal.jsonl")}
+	if _, err := ApplyTransforms(pack, map[string]string{"config/motd.txt": "upper"}, minecraft, t.TempDir(), journal, map[string]string{}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(minecraft, "config", "motd.txt")); got != "WELCOME" {
+		t.Errorf("wrote %q", got)
+	}
+}
+
+func TestMissingTemplateValues(t *testing.T) {
+	pack := transformPack(t, map[string]string{
+		"config/chat.json": "{{TOKEN}} {{ SERVER }} {{TOKEN}}",
+		"config/b.json":    "{{NAME}}",
+		"options.txt":      "{{NOT_A_TEMPLATE}}",
+	})
+	transforms := map[string]string{"config/chat.json": "template", "config/b.json": "template", "options.txt": "skip-if-exists"}
+	missing, err := MissingTemplateValues(pack, transforms, map[string]string{"SERVER": "mc.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each once, in the order of the archive's entries
+	sort.Strings(missing)
+	if got := strings.Join(missing, " "); got != "NAME TOKEN" {
+		t.Errorf("missing %s", got)
+	}
+	if _, err := MissingTemplateValues(filepath.Join(t.TempDir(), "missing.zip"), transforms, nil); err == nil {
+		t.Error("read a missing pack")
+	}
+}
+
+func TestPlanConfigChanges(t *testing.T) {
+	minecraft := t.TempDir()
+	writeFiles(t, minecraft, map[string]string{"options.txt": "", "config/chat.json": ""})
+	changes := PlanConfigChanges(map[string]string{
+		"options.txt":      "skip-if-exists",
+		"servers.dat":      "skip-if-exists",
+		"config/chat.json": "template",
+		"config/new.json":  "template",
+	}, minecraft)
+	want := []ConfigChange{
+		{Path: "config/chat.json", Transform: "template", Overwrite: true},
+		{Path: "config/new.json", Transform: "template"},
+		{Path: "servers.dat", Transform: "skip-if-exists"},
+	}
+	if mustJSON(t, changes) != mustJSON(t, want) {
+		t.Errorf("changes %+v", changes)
+	}
+}
+
+// TestUpdateWithTemplates runs an update of a pack with a template the
+// config has the value for: it is filled in without asking and kept out of
+// the run log.
+func TestUpdateWithTemplates(t *testing.T) {
+	useLogSecrets(t)
+	u := newFakeUpdate(t, map[string]string{
+		"pack.json":        `{"transforms": {"config/chat.json": "template"}}`,
+		"config/chat.json": "token={{TOKEN}}",
+		"mods/sodium.jar":  "sodium",
+	})
+	configPath := filepath.Join(u.state, "clientUpdate.json")
+	if err := os.MkdirAll(u.state, 0755); err != nil {
+		t.Fatal(err)
+	}
+	SaveConfig(ConfFile{MCDirectory: u.mods, TemplateValues: map[string]string{"TOKEN": "s3cret"}}, configPath)
+	output := u.run(t)
+	if got := readFile(t, filepath.Join(u.minecraft, "config", "chat.json")); got != "token=s3cret" {
+		t.Errorf("chat.json %q, output:\n%s", got, readFile(t, output))
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium" {
+		t.Errorf("sodium.jar %q", got)
+	}
+	var saved ConfFile
+	if err := json.Unmarshal([]byte(readFile(t, configPath)), &saved); err != nil || saved.TemplateValues["TOKEN"] != "s3cret" {
+		t.Errorf("saved %+v, %v", saved, err)
+	}
+	if got := readFile(t, filepath.Join(u.state, "clientUpdate.log")); strings.Contains(got, "s3cret") || !strings.Contains(got, "wrote config/chat.json") {
+		t.Errorf("log:\n%s", got)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxRequestsPerHost is how many requests go to one host at the same time,
+// however many downloads run in parallel. Idle connections are kept for
+// as many, so the next request to the host reuses one instead of another
+// TLS handshake.
+const maxRequestsPerHost = 4
+
+// newTransport returns the transport of httpClient: keep-alives and
+// HTTP/2 like the default one, with enough idle connections per host for
+// maxRequestsPerHost, behind a hostLimiter.
+func newTransport() http.RoundTripper {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          32,
+		MaxIdleConnsPerHost:   maxRequestsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	return &hostLimiter{base: transport, limit: maxRequestsPerHost}
+}
+
+// hostLimiter lets at most limit requests per host through base at a time.
+// A request counts until its response body is closed, since that is when
+// its connection becomes free again.
+type hostLimiter struct {
+	base  http.RoundTripper
+	limit int
+
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+func (l *hostLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	slot := l.slot(req.URL.Host)
+	select {
+	case slot <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	resp, err := l.base.RoundTrip(req)
+	if err != nil {
+		<-slot
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { <-slot }}
+	return resp, nil
+}
+
+// slot returns the semaphore of host.
+func (l *hostLimiter) slot(host string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.slots == nil {
+		l.slots = map[string]chan struct{}{}
+	}
+	slot, ok := l.slots[host]
+	if !ok {
+		slot = make(chan struct{}, l.limit)
+		l.slots[host] = slot
+	}
+	return slot
+}
+
+// releasingBody releases the slot of its request once, when closed.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestTransportReusesConnections downloads many small files from 16
+// goroutines: no more than maxRequestsPerHost connections are opened, and
+// no more requests than that run at the same time.
+func TestTransportReusesConnections(t *testing.T) {
+	var connections, running, most int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			seen := atomic.LoadInt32(&most)
+			if n <= seen || atomic.CompareAndSwapInt32(&most, seen, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	client := &http.Client{Transport: newTransport()}
+	var wg sync.WaitGroup
+	errs := make(chan error, 160)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				path := fmt.Sprintf("/mods/mod-%d-%d.jar", g, i)
+				resp, err := client.Get(server.URL + path)
+				if err != nil {
+					errs <- err
+					return
+				}
+				body, err := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil || string(body) != path {
+					errs <- fmt.Errorf("%s: %q, %v", path, body, err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if n := atomic.LoadInt32(&connections); n > maxRequestsPerHost {
+		t.Errorf("%d connections for 160 downloads", n)
+	}
+	if n := atomic.LoadInt32(&most); n > maxRequestsPerHost {
+		t.Errorf("%d requests at the same time", n)
+	}
+}
+
+// heldTransport answers requests to github.com once they are let
+// through, those to other hosts right away.
+type heldTransport struct {
+	started chan string
+	release chan struct{}
+}
+
+func (h *heldTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h.started <- req.URL.Host
+	if req.URL.Host == "github.com" {
+		<-h.release
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("ok")), Request: req}, nil
+}
+
+func TestHostLimiter(t *testing.T) {
+	base := &heldTransport{started: make(chan string, 10), release: make(chan struct{})}
+	limiter := &hostLimiter{base: base, limit: 2}
+	responses := make(chan *http.Response, 10)
+	get := func(ctx context.Context, rawURL string) error {
+		req, _ := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+		resp, err := limiter.RoundTrip(req)
+		if err == nil {
+			responses <- resp
+		}
+		return err
+	}
+	for i := 0; i < 3; i++ {
+		go get(context.Background(), "https://github.com/pack.zip")
+	}
+	go get(context.Background(), "https://cdn.modrinth.com/sodium.jar")
+	started := map[string]int{}
+	for i := 0; i < 3; i++ {
+		started[<-base.started]++
+	}
+	// the third request to github.com waits for a slot, another host doesn't
+	select {
+	case host := <-base.started:
+		t.Fatalf("another request to %s started", host)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if started["github.com"] != 2 || started["cdn.modrinth.com"] != 1 {
+		t.Fatalf("started %v", started)
+	}
+	other := <-responses
+
+	// waiting for a slot ends with the request's context
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() { canceled <- get(ctx, "https://github.com/other.zip") }()
+	cancel()
+	if err := <-canceled; err != context.Canceled {
+		t.Errorf("canceled while waiting: %v", err)
+	}
+
+	// a slot is free once a response body is closed, not before
+	base.release <- struct{}{}
+	resp := <-responses
+	select {
+	case host := <-base.started:
+		t.Fatalf("a request to %s started before the body was closed", host)
+	case <-time.After(20 * time.Millisecond):
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	resp.Body.Close()
+	if host := <-base.started; host != "github.com" {
+		t.Errorf("started %s", host)
+	}
+	close(base.release)
+	other.Body.Close()
+}
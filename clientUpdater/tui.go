@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Actions of the menu shown when the updater is started from a terminal
+// without arguments, e.g. by double-clicking it.
+const (
+	menuUpdate   = "update"
+	menuVerify   = "verify"
+	menuRollback = "rollback"
+	menuSettings = "settings"
+	menuLog      = "log"
+	menuQuit     = "quit"
+)
+
+// menuActions are the actions of the menu in the order shown.
+var menuActions = []string{menuUpdate, menuVerify, menuRollback, menuSettings, menuLog, menuQuit}
+
+// menuModes are the modes the actions run, exactly as if given on the
+// command line. The update is the run without a mode.
+var menuModes = map[string]string{
+	menuVerify:   "repair",
+	menuRollback: "rollback",
+	menuSettings: "settings",
+}
+
+// Key is a key pressed in the menu.
+type Key int
+
+// Keys of the menu. The digits 1 to 9 follow keyDigit1.
+const (
+	keyNone Key = iota
+	keyUp
+	keyDown
+	keyEnter
+	keyQuit
+	keyDigit1
+)
+
+// Menu is what the menu shows and which action was chosen.
+type Menu struct {
+	Actions  []string
+	Selected int
+	Chosen   bool
+}
+
+// Handle moves the selection or chooses an action for key: arrows move, a
+// digit chooses its action right away, Enter the one selected and q or
+// Ctrl+C quit. It reports whether an action was chosen.
+func (m *Menu) Handle(key Key) bool {
+	switch {
+	case key == keyUp:
+		m.Selected = (m.Selected + len(m.Actions) - 1) % len(m.Actions)
+	case key == keyDown:
+		m.Selected = (m.Selected + 1) % len(m.Actions)
+	case key == keyEnter:
+		m.Chosen = true
+	case key == keyQuit:
+		for i, action := range m.Actions {
+			if action == menuQuit {
+				m.Selected, m.Chosen = i, true
+			}
+		}
+	case key >= keyDigit1 && int(key-keyDigit1) < len(m.Actions):
+		m.Selected, m.Chosen = int(key-keyDigit1), true
+	}
+	return m.Chosen
+}
+
+// Choice returns the action chosen, empty while there is none.
+func (m *Menu) Choice() string {
+	if !m.Chosen {
+		return ""
+	}
+	return m.Actions[m.Selected]
+}
+
+// Render writes the menu to w, over the menu written before when redraw
+// is set. Lines end in \r\n, the terminal is raw.
+func (m *Menu) Render(w io.Writer, redraw bool) {
+	if redraw {
+		fmt.Fprintf(w, "\x1b[%dA", len(m.Actions)+1)
+	}
+	fmt.Fprint(w, "\r\x1b[K"+T("menu.title")+"\r\n")
+	for i, action := range m.Actions {
+		marker := " "
+		if i == m.Selected {
+			marker = ">"
+		}
+		fmt.Fprintf(w, "\r\x1b[K %s %d. %s\r\n", marker, i+1, T("menu."+action))
+	}
+}
+
+// ReadKey reads the next key from a raw terminal. Arrows arrive as escape
+// sequences, ESC [ A or ESC O A for up; keys the menu doesn't know are
+// keyNone.
+func ReadKey(r *bufio.Reader) (Key, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return keyNone, err
+	}
+	switch {
+	case b == '\r' || b == '\n':
+		return keyEnter, nil
+	case b == 'q' || b == 'Q' || b == 3 || b == 4:
+		return keyQuit, nil
+	case b == 'k':
+		return keyUp, nil
+	case b == 'j':
+		return keyDown, nil
+	case b >= '1' && b <= '9':
+		return keyDigit1 + Key(b-'1'), nil
+	case b != 0x1b:
+		return keyNone, nil
+	}
+	if b, err = r.ReadByte(); err != nil || b != '[' && b != 'O' {
+		return keyNone, err
+	}
+	if b, err = r.ReadByte(); err != nil {
+		return keyNone, err
+	}
+	switch b {
+	case 'A':
+		return keyUp, nil
+	case 'B':
+		return keyDown, nil
+	}
+	return keyNone, nil
+}
+
+// RunMenu shows the menu on the terminal of in until an action is chosen.
+// ok is false when the terminal can't be switched to raw mode, the
+// updater then runs the update as without the menu.
+func RunMenu(in *os.File, out io.Writer) (action string, ok bool) {
+	restore, err := rawTerminal(in)
+	if err != nil {
+		Logf("menu: no raw terminal, updating without the menu: %s", err)
+		return "", false
+	}
+	defer restore()
+	menu := &Menu{Actions: menuActions}
+	menu.Render(out, false)
+	reader := bufio.NewReader(in)
+	for {
+		key, err := ReadKey(reader)
+		if err != nil {
+			return menuQuit, true
+		}
+		done := menu.Handle(key)
+		menu.Render(out, true)
+		if done {
+			return menu.Choice(), true
+		}
+	}
+}
+
+// runMenu has the player choose actions with choose until one runs a mode,
+// showing the last run's log in between. It returns the mode to run as if
+// given on the command line, empty for the update, and whether the player
+// quit instead.
+func runMenu(choose func() (string, bool), logPath string) (mode string, quit bool) {
+	for {
+		action, ok := choose()
+		switch {
+		case !ok || action == menuUpdate:
+			return "", false
+		case action == menuQuit:
+			return "", true
+		case action == menuLog:
+			if err := PrintLastRunLog(logPath); err != nil {
+				fmt.Println(T("fatal", err))
+			}
+			fmt.Println()
+		default:
+			return menuModes[action], false
+		}
+	}
+}
+
+// logTailLines is how much of the last run's log is shown at most.
+const logTailLines = 40
+
+// PrintLastRunLog prints the log of the last run, its last logTailLines
+// lines for a long one.
+func PrintLastRunLog(logPath string) error {
+	content, err := ioutil.ReadFile(logPath)
+	if os.IsNotExist(err) {
+		fmt.Println(T("menu.log.none"))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	for i := len(lines) - 1; i > 0; i-- {
+		if strings.Contains(lines[i], "starting update, run ") {
+			lines = lines[i:]
+			break
+		}
+	}
+	if len(lines) > logTailLines {
+		fmt.Println(T("menu.log.more", len(lines)-logTailLines, logPath))
+		lines = lines[len(lines)-logTailLines:]
+	}
+	for _, line := range lines {
+		fmt.Println(strings.TrimRight(line, "\r"))
+	}
+	return nil
+}
+
+// progressLineInterval is how often the progress line is redrawn at most.
+const progressLineInterval = 100 * time.Millisecond
+
+// progressBarWidth is the number of characters of the progress bar.
+const progressBarWidth = 30
+
+// ShowProgressLine keeps a line on out showing the progress of the phase
+// the run is in, erased once the phase is done so it never gets in the
+// way of what is printed. While the targets are updated their ProgressMux
+// shows theirs instead.
+func ShowProgressLine(out io.Writer) {
+	var shown time.Time
+	showing := false
+	WatchProgress(func(phase string, progress RunProgress) {
+		if phase == phaseTargets || progress.Total <= 0 || progress.Done >= progress.Total {
+			if showing {
+				fmt.Fprint(out, "\r\x1b[K")
+				showing = false
+			}
+			return
+		}
+		if showing && clock.Now().Sub(shown) < progressLineInterval {
+			return
+		}
+		shown, showing = clock.Now(), true
+		fmt.Fprint(out, "\r"+progressText(phase, progress)+"\x1b[K")
+	})
+}
+
+// progressText shows progress in phase as a bar with the percentage and,
+// for phases counting files, the files and the rate. Without a total it
+// is just the phase.
+func progressText(phase string, progress RunProgress) string {
+	if progress.Total <= 0 {
+		return T("phase." + phase)
+	}
+	filled := int(progress.Done * progressBarWidth / progress.Total)
+	if filled > progressBarWidth {
+		filled = progressBarWidth
+	}
+	bar := strings.Repeat("#", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	detail := ""
+	if progress.FilesTotal > 0 {
+		detail = " " + T("progress.files", progress.Files, progress.FilesTotal, megabytes(progress.BytesPerSecond))
+	}
+	return fmt.Sprintf("[%s] %5.1f%% %s%s", bar, progress.Percent, T("phase."+phase), detail)
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// keys decodes what a terminal sends for the keys pressed.
+func keys(t *testing.T, input string) []Key {
+	t.Helper()
+	r := bufio.NewReader(strings.NewReader(input))
+	var pressed []Key
+	for {
+		key, err := ReadKey(r)
+		if err != nil {
+			return pressed
+		}
+		pressed = append(pressed, key)
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	tests := map[string][]Key{
+		"\x1b[A\x1b[B":   {keyUp, keyDown},
+		"\x1bOA\x1bOB":   {keyUp, keyDown},
+		"kj":             {keyUp, keyDown},
+		"\r\n":           {keyEnter, keyEnter},
+		"qQ\x03\x04":     {keyQuit, keyQuit, keyQuit, keyQuit},
+		"19":             {keyDigit1, keyDigit1 + 8},
+		"x0\x1b[C\x1b[D": {keyNone, keyNone, keyNone, keyNone},
+		// a lone escape followed by a key the menu doesn't know
+		"\x1bx": {keyNone},
+	}
+	for input, want := range tests {
+		if got := keys(t, input); len(got) != len(want) || !bytes.Equal(keyBytes(got), keyBytes(want)) {
+			t.Errorf("%q: keys %v, want %v", input, got, want)
+		}
+	}
+}
+
+// keyBytes makes keys comparable with bytes.Equal.
+func keyBytes(keys []Key) []byte {
+	b := make([]byte, len(keys))
+	for i, key := range keys {
+		b[i] = byte(key)
+	}
+	return b
+}
+
+func TestMenu(t *testing.T) {
+	tests := []struct {
+		input  string
+		choice string
+	}{
+		{input: "\r", choice: menuUpdate},
+		{input: "\x1b[B\x1b[B\r", choice: menuRollback},
+		{input: "jjjk\n", choice: menuRollback},
+		// up from the first action wraps around to the last
+		{input: "\x1b[A\r", choice: menuQuit},
+		{input: "\x1b[A\x1b[B\x1b[B\r", choice: menuVerify},
+		{input: "4", choice: menuSettings},
+		{input: "\x1b[B5", choice: menuLog},
+		{input: "q", choice: menuQuit},
+		{input: "\x03", choice: menuQuit},
+		// digits without an action and unknown keys do nothing
+		{input: "7x\x1b[C2", choice: menuVerify},
+		{input: "jj", choice: ""},
+	}
+	for _, test := range tests {
+		menu := &Menu{Actions: menuActions}
+		for _, key := range keys(t, test.input) {
+			if menu.Handle(key) {
+				break
+			}
+		}
+		if got := menu.Choice(); got != test.choice {
+			t.Errorf("%q chose %q, want %q", test.input, got, test.choice)
+		}
+	}
+	if len(menuActions) > 9 {
+		t.Errorf("%d actions, not every one has a digit", len(menuActions))
+	}
+	for _, action := range menuActions {
+		if _, ok := english["menu."+action]; !ok {
+			t.Errorf("no label for %s", action)
+		}
+	}
+}
+
+func TestMenuRender(t *testing.T) {
+	menu := &Menu{Actions: []string{menuUpdate, menuQuit}, Selected: 1}
+	var out bytes.Buffer
+	menu.Render(&out, false)
+	want := "\r\x1b[K" + english["menu.title"] + "\r\n\r\x1b[K   1. Update now\r\n\r\x1b[K > 2. Quit\r\n"
+	if got := out.String(); got != want {
+		t.Errorf("rendered\n%q\nwant\n%q", got, want)
+	}
+	out.Reset()
+	menu.Handle(keyDown)
+	menu.Render(&out, true)
+	if got := out.String(); !strings.HasPrefix(got, "\x1b[3A") || !strings.Contains(got, " > 1. Update now") {
+		t.Errorf("redrawn\n%q", got)
+	}
+}
+
+func TestRunMenuWithoutTerminal(t *testing.T) {
+	in, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	var out bytes.Buffer
+	if action, ok := RunMenu(in, &out); ok || action != "" || out.Len() > 0 {
+		t.Errorf("ran the menu without a terminal: %q, %t, %q", action, ok, out.String())
+	}
+}
+
+// TestRunMenuActions chooses actions in turn: the log is shown in place,
+// the others end the menu with the mode they run.
+func TestRunMenuActions(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "clientUpdate.log")
+	tests := []struct {
+		chosen []string
+		ok     bool
+		mode   string
+		quit   bool
+	}{
+		{chosen: []string{menuUpdate}, ok: true},
+		{chosen: []string{menuLog, menuLog, menuVerify}, ok: true, mode: "repair"},
+		{chosen: []string{menuRollback}, ok: true, mode: "rollback"},
+		{chosen: []string{menuLog, menuSettings}, ok: true, mode: "settings"},
+		{chosen: []string{menuLog, menuQuit}, ok: true, quit: true},
+		// without a terminal the update runs
+		{chosen: []string{""}},
+	}
+	for _, test := range tests {
+		asked := 0
+		choose := func() (string, bool) {
+			action := test.chosen[asked]
+			asked++
+			return action, test.ok
+		}
+		var mode string
+		var quit bool
+		output := captureStdout(t, func() { mode, quit = runMenu(choose, logPath) })
+		if mode != test.mode || quit != test.quit || asked != len(test.chosen) {
+			t.Errorf("%q: mode %q, quit %t after %d", test.chosen, mode, quit, asked)
+		}
+		logs := 0
+		for _, action := range test.chosen {
+			if action == menuLog {
+				logs++
+			}
+		}
+		if got := strings.Count(output, T("menu.log.none")); got != logs {
+			t.Errorf("%q: printed\n%s", test.chosen, output)
+		}
+	}
+}
+
+// TestSettingsMode edits the settings without going through main, saving
+// them only when they changed.
+func TestSettingsMode(t *testing.T) {
+	jsonConfPath := filepath.Join(t.TempDir(), "clientUpdate.json")
+	config := ConfFile{MCDirectory: "/games/mods", MCVersion: "1.20.1"}
+	output := captureStdout(t, func() {
+		if err := runSettings(config, NewPrompter(strings.NewReader("\n\n\n"), false), jsonConfPath); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.HasSuffix(output, T("settings.unchanged")+"\n") {
+		t.Errorf("output:\n%s", output)
+	}
+	if _, err := os.Stat(jsonConfPath); !os.IsNotExist(err) {
+		t.Errorf("saved unchanged settings: %v", err)
+	}
+
+	output = captureStdout(t, func() {
+		if err := runSettings(config, NewPrompter(strings.NewReader("\n1.21\nes\n"), false), jsonConfPath); err != nil {
+			t.Fatal(err)
+		}
+	})
+	saved, _, err := ReadConfig(jsonConfPath)
+	if err != nil || saved.MCDirectory != "/games/mods" || saved.MCVersion != "1.21" || saved.Language != "es" {
+		t.Errorf("saved %+v, %v", saved, err)
+	}
+	if !strings.HasSuffix(output, T("settings.saved", jsonConfPath)+"\n") {
+		t.Errorf("output:\n%s", output)
+	}
+
+	// a config that can't be written is returned
+	captureStdout(t, func() {
+		if err := runSettings(config, NewPrompter(strings.NewReader("\n1.21.1\n\n"), false), filepath.Join(jsonConfPath, "clientUpdate.json")); err == nil {
+			t.Error("saved below a file")
+		}
+	})
+}
+
+func TestPrintLastRunLog(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "clientUpdate.log")
+	if got := captureStdout(t, func() { PrintLastRunLog(p) }); got != english["menu.log.none"]+"\n" {
+		t.Errorf("without a log printed %q", got)
+	}
+
+	var log strings.Builder
+	log.WriteString("10:00 starting update, run 1\r\n10:01 updated\r\n")
+	log.WriteString("11:00 starting update, run 2\r\n")
+	for i := 0; i < logTailLines+5; i++ {
+		log.WriteString("11:01 line\r\n")
+	}
+	writeFiles(t, filepath.Dir(p), map[string]string{"clientUpdate.log": log.String()})
+	got := captureStdout(t, func() { PrintLastRunLog(p) })
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != logTailLines+1 || lines[0] != "(6 earlier lines not shown, see "+p+")" || lines[1] != "11:01 line" {
+		t.Errorf("printed %d lines:\n%q", len(lines), got)
+	}
+
+	writeFiles(t, filepath.Dir(p), map[string]string{"clientUpdate.log": "10:00 starting update, run 1\n10:01 updated\n11:00 starting update, run 2\n11:01 failed\n"})
+	if got := captureStdout(t, func() { PrintLastRunLog(p) }); got != "11:00 starting update, run 2\n11:01 failed\n" {
+		t.Errorf("printed %q", got)
+	}
+}
+
+func TestProgressText(t *testing.T) {
+	tests := []struct {
+		progress RunProgress
+		want     string
+	}{
+		{progress: RunProgress{}, want: "downloading the pack"},
+		{progress: RunProgress{Done: 1, Total: 4, Percent: 25}, want: "[#######                       ]  25.0% downloading the pack"},
+		{progress: RunProgress{Done: 5, Total: 4, Percent: 125}, want: "[##############################] 125.0% downloading the pack"},
+		{progress: RunProgress{Done: 1, Total: 2, Percent: 50, Files: 3, FilesTotal: 6, BytesPerSecond: 2 << 20}, want: "[###############               ]  50.0% downloading the pack 3/6 files, " + megabytes(2<<20) + "/s"},
+	}
+	for _, test := range tests {
+		if got := progressText(phaseDownload, test.progress); got != test.want {
+			t.Errorf("%+v: %q, want %q", test.progress, got, test.want)
+		}
+	}
+}
+
+func TestEditSettings(t *testing.T) {
+	mods := filepath.Join(t.TempDir(), ".minecraft", "mods")
+	if err := os.MkdirAll(mods, 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := &ConfFile{MCDirectory: "/old/mods", MCVersion: "1.20.1"}
+	input := "relative/mods\n" + mods + "\n1.20.x\n1.21\nklingon\nde\n"
+	var changed bool
+	output := captureStdout(t, func() { changed = EditSettings(NewPrompter(strings.NewReader(input), false), config) })
+	if !changed {
+		t.Fatalf("nothing changed, output:\n%s", output)
+	}
+	if config.MCDirectory != mods || config.MCVersion != "1.21" || config.Language != "de" {
+		t.Errorf("settings %+v", config)
+	}
+	if got := strings.Count(output, "try again or press Enter to keep it"); got != 3 {
+		t.Errorf("output:\n%s", output)
+	}
+
+	// empty answers keep everything
+	captureStdout(t, func() { changed = EditSettings(NewPrompter(strings.NewReader("\n\n\n"), false), config) })
+	if changed || config.MCDirectory != mods || config.MCVersion != "1.21" || config.Language != "de" {
+		t.Errorf("changed %t: %+v", changed, config)
+	}
+}
+
+// TestRollbackMode rolls back without going through main: nothing before
+// an update, the update after it.
+func TestRollbackMode(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 1"})
+	installedPath := filepath.Join(u.state, "clientUpdate-installed.json")
+	journalPath := filepath.Join(u.state, "clientUpdate-journal.jsonl")
+	config := ConfFile{MCDirectory: u.mods}
+	writeFiles(t, u.mods, map[string]string{"sodium.jar": "the player's"})
+	output := captureStdout(t, func() {
+		if err := runRollback(config, installedPath, journalPath, "rollback"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if output != T("rollback.none")+"\n" {
+		t.Errorf("without an update printed %q", output)
+	}
+
+	u.run(t)
+	output = captureStdout(t, func() {
+		if err := runRollback(config, installedPath, journalPath, "rollback"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if output != T("rollback.done", "20240601-120100")+"\n"+T("rollback.freeze")+"\n" {
+		t.Errorf("printed %q", output)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "the player's" {
+		t.Errorf("sodium.jar is %q", got)
+	}
+}
+
+// TestRollbackSteps rolls back twice after three updates: each rollback
+// goes one update further back instead of undoing the one before it.
+func TestRollbackSteps(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 1"})
+	u.run(t)
+	u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 2", "mods/iris.jar": "iris"})
+	u.run(t)
+	u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 3", "mods/iris.jar": "iris"})
+	u.run(t)
+
+	output := readFile(t, u.run(t, "rollback"))
+	if !strings.Contains(output, "Rolled back the update of run 20240601-120300") {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 2" {
+		t.Errorf("after the first rollback sodium.jar is %q", got)
+	}
+	state, err := ReadInstalledState(filepath.Join(u.state, "clientUpdate-installed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.PackCommit != "" || len(state.Files) != 1 || state.Files[0].Name != "iris.jar" {
+		t.Errorf("state after the rollback: %s", mustJSON(t, state))
+	}
+
+	output = readFile(t, u.run(t, "rollback"))
+	if !strings.Contains(output, "Rolled back the update of run 20240601-120200") {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := dirNames(t, u.mods); got != "sodium.jar" {
+		t.Errorf("after the second rollback installed %s", got)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium 1" {
+		t.Errorf("after the second rollback sodium.jar is %q", got)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Console modes switched for the menu.
+const (
+	enableEchoInput                 = 0x0004
+	enableLineInput                 = 0x0002
+	enableVirtualTerminalInput      = 0x0200
+	enableVirtualTerminalProcessing = 0x0004
+)
+
+// rawTerminal switches the console of in to raw mode, keys arriving as
+// they are pressed, without echo and arrows as escape sequences, and
+// returns how to switch it back. The console keeps understanding escape
+// sequences written to it, for the progress line of the update. Consoles
+// older than Windows 10 can't and fail.
+func rawTerminal(in *os.File) (restore func(), err error) {
+	kernel32 := syscall.NewLazyDLL("kernel32.dll")
+	getMode, setMode := kernel32.NewProc("GetConsoleMode"), kernel32.NewProc("SetConsoleMode")
+	if err := getMode.Find(); err != nil {
+		return nil, err
+	}
+	var inMode, outMode uint32
+	if ok, _, err := getMode.Call(in.Fd(), uintptr(unsafe.Pointer(&inMode))); ok == 0 {
+		return nil, err
+	}
+	if ok, _, err := getMode.Call(os.Stdout.Fd(), uintptr(unsafe.Pointer(&outMode))); ok == 0 {
+		return nil, err
+	}
+	if ok, _, err := setMode.Call(os.Stdout.Fd(), uintptr(outMode|enableVirtualTerminalProcessing)); ok == 0 {
+		return nil, err
+	}
+	raw := inMode&^(enableEchoInput|enableLineInput) | enableVirtualTerminalInput
+	if ok, _, err := setMode.Call(in.Fd(), uintptr(raw)); ok == 0 {
+		return nil, err
+	}
+	return func() { setMode.Call(in.Fd(), uintptr(inMode)) }, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// vanillaManifestURL lists every Minecraft version Mojang publishes.
+const vanillaManifestURL = "https://piston-meta.mojang.com/mc/game/version_manifest_v2.json"
+
+// vanillaManifestTTL is how long the version manifest is reused. A version
+// JSON is cached under its hash, so vanillaVersionTTL only bounds how long
+// an unused one stays around.
+const (
+	vanillaManifestTTL = time.Hour
+	vanillaVersionTTL  = 30 * 24 * time.Hour
+)
+
+// vanillaManifestEntry is the cache entry of the version manifest.
+const vanillaManifestEntry = "minecraft-version-manifest.json"
+
+// emptyLauncherProfiles is written for installs the official launcher never
+// ran in; the Fabric installer refuses to add its profile without the file.
+const emptyLauncherProfiles = "{\n  \"profiles\": {}\n}\n"
+
+// NeverLaunched reports whether the minecraft directory looks like the
+// official launcher never started the game in it: it has neither
+// launcher_profiles.json nor any version with its JSON.
+func NeverLaunched(minecraftPath string) bool {
+	if _, err := os.Stat(filepath.Join(minecraftPath, "launcher_profiles.json")); err == nil {
+		return false
+	}
+	versionsPath := filepath.Join(minecraftPath, "versions")
+	versions, err := ScanVersions(versionsPath)
+	if err != nil {
+		return true
+	}
+	for _, v := range versions {
+		if v.HasJSON {
+			return false
+		}
+		// the scan only looks for the JSON of loader folders
+		if v.Loader == "" {
+			if _, err := os.Stat(filepath.Join(versionsPath, v.Name, v.Name+".json")); err == nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// vanillaManifest is the part of Mojang's version manifest needed to find
+// a version's JSON.
+type vanillaManifest struct {
+	Versions []struct {
+		ID   string `json:"id"`
+		URL  string `json:"url"`
+		SHA1 string `json:"sha1"`
+	} `json:"versions"`
+}
+
+// vanillaVersion is the part of a version JSON needed to find its client.
+type vanillaVersion struct {
+	ID        string `json:"id"`
+	Downloads struct {
+		Client struct {
+			URL  string `json:"url"`
+			SHA1 string `json:"sha1"`
+		} `json:"client"`
+	} `json:"downloads"`
+}
+
+// BootstrapVanilla puts the vanilla version JSON and client jar of
+// mcVersion into the minecraft directory the way the official launcher
+// does on first launch, so a loader can be installed without it. Both are
+// verified against the SHA-1 hashes Mojang publishes. manifestURL is
+// normally vanillaManifestURL. Both JSONs are looked up through cache.
+func BootstrapVanilla(cache *Cache, minecraftPath string, mcVersion string, manifestURL string) error {
+	content, err := cache.Metadata(vanillaManifestEntry, manifestURL, vanillaManifestTTL)
+	if err != nil {
+		return fmt.Errorf("fetching the version manifest: %s", err)
+	}
+	var manifest vanillaManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return fmt.Errorf("reading the version manifest: %s", err)
+	}
+	versionURL, versionSHA1 := "", ""
+	for _, v := range manifest.Versions {
+		if v.ID == mcVersion {
+			versionURL, versionSHA1 = v.URL, v.SHA1
+			break
+		}
+	}
+	if versionURL == "" {
+		return fmt.Errorf("Minecraft %s is not in the version manifest", mcVersion)
+	}
+
+	versionEntry := "minecraft-" + mcVersion + "-" + versionSHA1 + ".json"
+	content, err = cache.Metadata(versionEntry, versionURL, vanillaVersionTTL)
+	if err != nil {
+		return fmt.Errorf("fetching %s.json: %s", mcVersion, err)
+	}
+	if sum := sha1Hex(content); sum != versionSHA1 {
+		cache.DropMetadata(versionEntry)
+		return fmt.Errorf("%s.json: expected SHA-1 %s, got %s", mcVersion, versionSHA1, sum)
+	}
+	var version vanillaVersion
+	if err := json.Unmarshal(content, &version); err != nil {
+		return fmt.Errorf("reading %s.json: %s", mcVersion, err)
+	}
+	if version.ID != mcVersion || version.Downloads.Client.URL == "" {
+		return fmt.Errorf("%s.json doesn't describe the Minecraft %s client", mcVersion, mcVersion)
+	}
+
+	dir := filepath.Join(minecraftPath, "versions", mcVersion)
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return err
+	}
+	// the jar first, a version JSON without its jar would count as
+	// installed
+	jar := filepath.Join(dir, mcVersion+".jar")
+	if _, err := downloadFile(jar+".part", version.Downloads.Client.URL, ""); err != nil {
+		os.Remove(jar + ".part")
+		return fmt.Errorf("downloading the Minecraft %s client: %s", mcVersion, err)
+	}
+	if sum, err := fileSHA1(jar + ".part"); err != nil || sum != version.Downloads.Client.SHA1 {
+		os.Remove(jar + ".part")
+		if err == nil {
+			err = fmt.Errorf("expected SHA-1 %s, got %s", version.Downloads.Client.SHA1, sum)
+		}
+		return fmt.Errorf("%s.jar: %s", mcVersion, err)
+	}
+	if err := os.Rename(jar+".part", jar); err != nil {
+		return err
+	}
+	if err := writeAtomic(filepath.Join(dir, mcVersion+".json"), content); err != nil {
+		return err
+	}
+	ForgetVersions(filepath.Join(minecraftPath, "versions"))
+
+	profiles := filepath.Join(minecraftPath, "launcher_profiles.json")
+	if _, err := os.Stat(profiles); os.IsNotExist(err) {
+		if err := writeAtomic(profiles, []byte(emptyLauncherProfiles)); err != nil {
+			return err
+		}
+		// written by us, it mustn't look like the launcher is at work
+		settled := clock.Now().Add(-launcherChurnWindow)
+		if err := os.Chtimes(profiles, settled, settled); err != nil {
+			return err
+		}
+	}
+	Logf("vanilla %s bootstrapped in %s", mcVersion, minecraftPath)
+	return nil
+}
+
+func sha1Hex(content []byte) string {
+	sum := sha1.Sum(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func fileSHA1(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha1.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
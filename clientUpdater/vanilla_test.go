@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fixtureVanilla holds Mojang's version manifest as recorded, cut down to
+// a few versions, with the version JSONs and client jars it refers to.
+var fixtureVanilla = filepath.Join("testdata", "vanilla")
+
+// mojangServer serves the recorded files of fixtureVanilla at Mojang's
+// URLs, the jars and JSONs below their hashes. Files listed in replaced
+// are served with other content.
+type mojangServer struct {
+	replaced map[string]string
+}
+
+func (s mojangServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := ""
+	switch {
+	case r.Host == "piston-meta.mojang.com" && r.URL.Path == "/mc/game/version_manifest_v2.json":
+		name = "version_manifest_v2.json"
+	case r.Host == "piston-meta.mojang.com" && strings.HasPrefix(r.URL.Path, "/v1/packages/"):
+		name = path.Base(r.URL.Path)
+	case r.Host == "piston-data.mojang.com" && path.Base(r.URL.Path) == "client.jar":
+		sum := path.Base(path.Dir(r.URL.Path))
+		jars, _ := filepath.Glob(filepath.Join(fixtureVanilla, "client-*.jar"))
+		for _, jar := range jars {
+			if got, _ := fileSHA1(jar); got == sum {
+				name = filepath.Base(jar)
+			}
+		}
+	}
+	if content, ok := s.replaced[name]; ok {
+		w.Write([]byte(content))
+		return
+	}
+	if name == "" {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(fixtureVanilla, name))
+}
+
+func TestNeverLaunched(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		never bool
+	}{
+		{"empty", nil, true},
+		{"only options", map[string]string{"options.txt": ""}, true},
+		{"version without its JSON", map[string]string{"versions/1.21/1.21.jar": ""}, true},
+		{"launched", map[string]string{"versions/1.21/1.21.json": "{}", "versions/1.21/1.21.jar": ""}, false},
+		{"profiles", map[string]string{"launcher_profiles.json": "{}"}, false},
+	}
+	for _, test := range tests {
+		minecraft := filepath.Join(t.TempDir(), ".minecraft")
+		if err := os.MkdirAll(minecraft, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFiles(t, minecraft, test.files)
+		if got := NeverLaunched(minecraft); got != test.never {
+			t.Errorf("%s: never launched %t", test.name, got)
+		}
+	}
+}
+
+func TestBootstrapVanilla(t *testing.T) {
+	useFakeClock(t)
+	useTestServer(t, mojangServer{})
+	cache := &Cache{Dir: t.TempDir()}
+	minecraft := filepath.Join(t.TempDir(), ".minecraft")
+
+	if err := BootstrapVanilla(cache, minecraft, "1.21", vanillaManifestURL); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(minecraft, "versions", "1.21")
+	if got := dirNames(t, dir); got != "1.21.jar 1.21.json" {
+		t.Fatalf("installed %s", got)
+	}
+	if readFile(t, filepath.Join(dir, "1.21.jar")) != readFile(t, filepath.Join(fixtureVanilla, "client-1.21.jar")) ||
+		readFile(t, filepath.Join(dir, "1.21.json")) != readFile(t, filepath.Join(fixtureVanilla, "1.21.json")) {
+		t.Error("installed other files than Mojang's")
+	}
+	if got := readFile(t, filepath.Join(minecraft, "launcher_profiles.json")); got != emptyLauncherProfiles {
+		t.Errorf("launcher_profiles.json %q", got)
+	}
+	if NeverLaunched(minecraft) {
+		t.Error("still looks never launched")
+	}
+
+	// a second version leaves the profiles alone
+	writeFiles(t, minecraft, map[string]string{"launcher_profiles.json": `{"profiles": {"fabric": {}}}`})
+	if err := BootstrapVanilla(cache, minecraft, "1.20.1", vanillaManifestURL); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(minecraft, "launcher_profiles.json")); got != `{"profiles": {"fabric": {}}}` {
+		t.Errorf("launcher_profiles.json %q", got)
+	}
+	if got := dirNames(t, filepath.Join(minecraft, "versions")); got != "1.20.1 1.21" {
+		t.Errorf("versions %s", got)
+	}
+}
+
+// TestBootstrapVanillaVerifies checks nothing is installed from files not
+// matching the hashes Mojang publishes.
+func TestBootstrapVanillaVerifies(t *testing.T) {
+	tests := []struct {
+		name      string
+		mcVersion string
+		replaced  map[string]string
+		err       string
+	}{
+		{name: "unknown version", mcVersion: "1.19.2", err: "Minecraft 1.19.2 is not in the version manifest"},
+		{name: "tampered JSON", mcVersion: "1.21", replaced: map[string]string{"1.21.json": `{"id": "1.21"}`}, err: "1.21.json: expected SHA-1"},
+		{name: "tampered jar", mcVersion: "1.21", replaced: map[string]string{"client-1.21.jar": "not the client"}, err: "1.21.jar: expected SHA-1"},
+		{name: "broken manifest", mcVersion: "1.21", replaced: map[string]string{"version_manifest_v2.json": "<html>"}, err: "reading the version manifest"},
+		{name: "snapshot not served", mcVersion: "24w14a", err: "fetching 24w14a.json"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useFakeClock(t)
+			useTestServer(t, mojangServer{replaced: test.replaced})
+			cache := &Cache{Dir: t.TempDir()}
+			minecraft := filepath.Join(t.TempDir(), ".minecraft")
+			err := BootstrapVanilla(cache, minecraft, test.mcVersion, vanillaManifestURL)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("error %v, want %s", err, test.err)
+			}
+			if _, err := os.Stat(filepath.Join(minecraft, "versions", test.mcVersion, test.mcVersion+".json")); !os.IsNotExist(err) {
+				t.Errorf("installed the version JSON: %v", err)
+			}
+			if !NeverLaunched(minecraft) {
+				t.Error("looks launched")
+			}
+		})
+	}
+}
+
+// TestUpdateBootstrapsVanilla updates a minecraft directory the launcher
+// never ran in with --bootstrap-vanilla: the vanilla files are put in
+// place before Fabric is installed.
+func TestUpdateBootstrapsVanilla(t *testing.T) {
+	record := useFakeJava(t)
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium"})
+	for _, name := range []string{"versions", "launcher_profiles.json"} {
+		if err := os.RemoveAll(filepath.Join(u.minecraft, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ForgetVersions(filepath.Join(u.minecraft, "versions"))
+	mojang := mojangServer{}
+	useTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.Host, "mojang.com") {
+			mojang.ServeHTTP(w, r)
+			return
+		}
+		u.server.ServeHTTP(w, r)
+	}))
+
+	output := readFile(t, u.run(t, "--bootstrap-vanilla"))
+	if got := dirNames(t, filepath.Join(u.minecraft, "versions")); got != "1.20.1 fabric-loader-0.16.5-1.20.1" {
+		t.Fatalf("versions %s, output:\n%s", got, output)
+	}
+	if got := dirNames(t, filepath.Join(u.minecraft, "versions", "1.20.1")); got != "1.20.1.jar 1.20.1.json" {
+		t.Errorf("vanilla %s", got)
+	}
+	if _, err := os.Stat(record); err != nil {
+		t.Errorf("the Fabric installer never ran: %v", err)
+	}
+	if got := readFile(t, filepath.Join(u.mods, "sodium.jar")); got != "sodium" {
+		t.Errorf("sodium.jar %q", got)
+	}
+}
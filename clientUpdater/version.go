@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Build information, set when building a release:
+//
+//	go build -ldflags "-X main.Version=1.4.0 -X main.BuildDate=2024-06-01 -X main.Commit=abc1234"
+var (
+	Version   = "dev"
+	BuildDate = "unknown"
+	Commit    = "unknown"
+)
+
+// releasesURL is where players get a new version of the updater.
+const releasesURL = "https://github.com/rx13/rxmc-Updater/releases/latest"
+
+// VersionString describes the running binary for --version and the log.
+func VersionString() string {
+	return fmt.Sprintf("%s (built %s, commit %s)", Version, BuildDate, Commit)
+}
+
+// semver is a parsed semantic version. Build metadata is dropped, it
+// doesn't take part in comparisons.
+type semver struct {
+	core       [3]int
+	prerelease []string
+}
+
+// parseSemver parses versions like 1.4.0, v1.4.0-rc.1 or 1.4.0+build.5. A
+// missing minor or patch number counts as 0.
+func parseSemver(v string) (semver, error) {
+	var parsed semver
+	s := strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.Index(s, "-"); i >= 0 {
+		parsed.prerelease = strings.Split(s[i+1:], ".")
+		s = s[:i]
+		for _, id := range parsed.prerelease {
+			if id == "" {
+				return parsed, fmt.Errorf("invalid version %q", v)
+			}
+		}
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return parsed, fmt.Errorf("invalid version %q", v)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return parsed, fmt.Errorf("invalid version %q", v)
+		}
+		parsed.core[i] = n
+	}
+	return parsed, nil
+}
+
+// CompareSemver compares two semantic versions following the precedence
+// rules of semver.org, returning -1, 0 or 1.
+func CompareSemver(a, b string) (int, error) {
+	x, err := parseSemver(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := parseSemver(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := range x.core {
+		if x.core[i] != y.core[i] {
+			return compareInts(x.core[i], y.core[i]), nil
+		}
+	}
+
+	// a release is newer than any of its pre-releases
+	switch {
+	case len(x.prerelease) == 0 && len(y.prerelease) == 0:
+		return 0, nil
+	case len(x.prerelease) == 0:
+		return 1, nil
+	case len(y.prerelease) == 0:
+		return -1, nil
+	}
+	for i := 0; i < len(x.prerelease) && i < len(y.prerelease); i++ {
+		if c := comparePrerelease(x.prerelease[i], y.prerelease[i]); c != 0 {
+			return c, nil
+		}
+	}
+	return compareInts(len(x.prerelease), len(y.prerelease)), nil
+}
+
+// comparePrerelease compares single pre-release identifiers: numeric ones
+// numerically and below alphanumeric ones, which compare as strings.
+func comparePrerelease(a, b string) int {
+	an, aerr := strconv.Atoi(a)
+	bn, berr := strconv.Atoi(b)
+	switch {
+	case aerr == nil && berr == nil:
+		return compareInts(an, bn)
+	case aerr == nil:
+		return -1
+	case berr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// updaterTooOldError means the pack needs features of a newer updater.
+type updaterTooOldError struct {
+	required string
+}
+
+func (e *updaterTooOldError) Error() string {
+	return fmt.Sprintf("the mod pack requires updater %s or newer, this is %s", e.required, Version)
+}
+
+// CheckUpdaterVersion fails when the running updater is older than
+// required. Development builds carry no usable version and are let
+// through, with a warning.
+func CheckUpdaterVersion(required string) error {
+	if required == "" {
+		return nil
+	}
+	c, err := CompareSemver(Version, required)
+	if err != nil {
+		Logf("updater version check skipped: %s", err)
+		Warn(warnPack, T("updater.unchecked", Version, required, releasesURL))
+		return nil
+	}
+	if c < 0 {
+		return &updaterTooOldError{required: required}
+	}
+	return nil
+}
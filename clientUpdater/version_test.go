@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCompareSemver(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.4.0", "1.4.0", 0},
+		{"v1.4.0", "1.4.0", 0},
+		{"1.4", "1.4.0", 0},
+		{"1", "1.0.0", 0},
+		{"1.4.0+build.5", "1.4.0+build.6", 0},
+		{"1.4.0", "1.5.0", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.4.1", "1.4.0", 1},
+		// the precedence example of semver.org, in order
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta", "1.0.0-beta.2", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc.1", 1},
+		{"1.0.0-rc.1", "1.0.0-rc.1", 0},
+	}
+	for _, test := range tests {
+		got, err := CompareSemver(test.a, test.b)
+		if err != nil || got != test.want {
+			t.Errorf("CompareSemver(%q, %q) = %d, %v, want %d", test.a, test.b, got, err, test.want)
+		}
+	}
+}
+
+func TestCompareSemverInvalid(t *testing.T) {
+	for _, v := range []string{"dev", "", "1.2.3.4", "1.x", "1.-2.0", "1.0.0-", "1.0.0-rc..1", "latest"} {
+		if _, err := CompareSemver(v, "1.0.0"); err == nil {
+			t.Errorf("%q compared as a version", v)
+		}
+		if _, err := CompareSemver("1.0.0", v); err == nil {
+			t.Errorf("%q compared as a version", v)
+		}
+	}
+}
+
+func TestCheckUpdaterVersion(t *testing.T) {
+	saved := Version
+	defer func() { Version = saved }()
+	tests := []struct {
+		version, required string
+		tooOld            bool
+	}{
+		{"1.4.0", "", false},
+		{"1.4.0", "1.4.0", false},
+		{"1.4.0", "1.3.9", false},
+		{"1.4.0", "1.4.1", true},
+		{"1.4.0-rc.1", "1.4.0", true},
+		{"dev", "9.0.0", false},
+	}
+	for _, test := range tests {
+		Version = test.version
+		err := CheckUpdaterVersion(test.required)
+		var tooOld *updaterTooOldError
+		if errors.As(err, &tooOld) != test.tooOld || (err != nil && !test.tooOld) {
+			t.Errorf("updater %s with %q required: %v", test.version, test.required, err)
+		}
+	}
+}
+
+func TestCheckUpdaterVersionWarnsWhenUnchecked(t *testing.T) {
+	saved := Version
+	defer func() { Version = saved }()
+	Version = "dev"
+	if err := CheckUpdaterVersion("1.4.0"); err != nil {
+		t.Fatal(err)
+	}
+	want := T("updater.unchecked", "dev", "1.4.0", releasesURL)
+	for _, message := range warningMessages(warnPack) {
+		if message == want {
+			return
+		}
+	}
+	t.Errorf("no warning %q among %q", want, warningMessages(warnPack))
+}
+
+// warningMessages returns the warnings of the category so far.
+func warningMessages(category string) []string {
+	for _, group := range WarningGroups() {
+		if group.Category == category {
+			return group.Messages
+		}
+	}
+	return nil
+}
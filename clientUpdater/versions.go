@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// VersionDir is a folder of versions/ with what its name says it holds.
+type VersionDir struct {
+	Name string
+	// Loader is fabric, quilt or neoforge, empty for vanilla and anything
+	// not recognized.
+	Loader        string
+	LoaderVersion string
+	MCVersion     string
+	// HasJSON is set when the folder contains <Name>.json. Only loader
+	// folders are checked, to keep scanning a large directory cheap.
+	HasJSON bool
+}
+
+// ParseVersionDir parses a versions/ folder name:
+//
+//	fabric-loader-<loader version>-<minecraft version>
+//	quilt-loader-<loader version>-<minecraft version>
+//	neoforge-<neoforge version>
+//	<minecraft version>
+//
+// Loader versions may contain dashes themselves (0.26.0-beta.1), the
+// Minecraft version starts at the first dash followed by a digit.
+func ParseVersionDir(name string) VersionDir {
+	v := VersionDir{Name: name}
+	for _, loader := range []string{"fabric", "quilt"} {
+		prefix := loader + "-loader-"
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rest := name[len(prefix):]
+		for i := 0; i < len(rest)-1; i++ {
+			if rest[i] == '-' && rest[i+1] >= '0' && rest[i+1] <= '9' {
+				v.Loader, v.LoaderVersion, v.MCVersion = loader, rest[:i], rest[i+1:]
+				return v
+			}
+		}
+		return v
+	}
+	if strings.HasPrefix(name, "neoforge-") {
+		v.Loader = "neoforge"
+		v.LoaderVersion = strings.TrimPrefix(name, "neoforge-")
+		v.MCVersion = neoForgeMCVersion(v.LoaderVersion)
+		return v
+	}
+	v.MCVersion = name
+	return v
+}
+
+// neoForgeMCVersion is the inverse of neoForgePrefix: 21.1.57 is built for
+// Minecraft 1.21.1, 21.0.10 for 1.21.
+func neoForgeMCVersion(neoForgeVersion string) string {
+	parts := strings.SplitN(neoForgeVersion, ".", 3)
+	if len(parts) < 2 {
+		return ""
+	}
+	if parts[1] == "0" {
+		return "1." + parts[0]
+	}
+	return "1." + parts[0] + "." + parts[1]
+}
+
+// versionScans caches ScanVersions within a run, several steps need the
+// same listing.
+var versionScans = struct {
+	sync.Mutex
+	dirs map[string][]VersionDir
+}{dirs: map[string][]VersionDir{}}
+
+// ScanVersions lists the folders of a versions directory. Files are
+// ignored. The result is cached until ForgetVersions is called.
+func ScanVersions(versionsPath string) ([]VersionDir, error) {
+	versionScans.Lock()
+	defer versionScans.Unlock()
+	if dirs, ok := versionScans.dirs[versionsPath]; ok {
+		return dirs, nil
+	}
+
+	entries, err := os.ReadDir(versionsPath)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make([]VersionDir, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		v := ParseVersionDir(entry.Name())
+		if v.Loader != "" {
+			_, err := os.Stat(filepath.Join(versionsPath, v.Name, v.Name+".json"))
+			v.HasJSON = err == nil
+		}
+		dirs = append(dirs, v)
+	}
+	versionScans.dirs[versionsPath] = dirs
+	return dirs, nil
+}
+
+// ForgetVersions drops the cached scan of versionsPath, after something
+// was installed into it.
+func ForgetVersions(versionsPath string) {
+	versionScans.Lock()
+	delete(versionScans.dirs, versionsPath)
+	versionScans.Unlock()
+}
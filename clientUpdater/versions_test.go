@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseVersionDir(t *testing.T) {
+	tests := []VersionDir{
+		{Name: "1.20.1", MCVersion: "1.20.1"},
+		{Name: "24w14a", MCVersion: "24w14a"},
+		{Name: "fabric-loader-0.15.11-1.20.1", Loader: "fabric", LoaderVersion: "0.15.11", MCVersion: "1.20.1"},
+		{Name: "fabric-loader-0.16.0-1.21-pre2", Loader: "fabric", LoaderVersion: "0.16.0", MCVersion: "1.21-pre2"},
+		{Name: "quilt-loader-0.26.0-beta.1-1.20.1", Loader: "quilt", LoaderVersion: "0.26.0-beta.1", MCVersion: "1.20.1"},
+		{Name: "neoforge-21.1.77", Loader: "neoforge", LoaderVersion: "21.1.77", MCVersion: "1.21.1"},
+		{Name: "neoforge-21.0.10-beta", Loader: "neoforge", LoaderVersion: "21.0.10-beta", MCVersion: "1.21"},
+		// nothing to tell the Minecraft version by
+		{Name: "fabric-loader-0.15.11"},
+		{Name: "fabric-loader-"},
+		{Name: "neoforge-snapshot", Loader: "neoforge", LoaderVersion: "snapshot"},
+		{Name: "OptiFine 1.20.1_HD_U_I6", MCVersion: "OptiFine 1.20.1_HD_U_I6"},
+	}
+	for _, want := range tests {
+		if got := ParseVersionDir(want.Name); got != want {
+			t.Errorf("ParseVersionDir(%q) = %+v, want %+v", want.Name, got, want)
+		}
+	}
+}
+
+// TestScanVersions scans the fixture's versions directory: files are left
+// out, loader folders without their JSON are listed but not counted as
+// installed.
+func TestScanVersions(t *testing.T) {
+	ForgetVersions(fixtureVersions)
+	defer ForgetVersions(fixtureVersions)
+	dirs, err := ScanVersions(fixtureVersions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, dir := range dirs {
+		if dir.Loader != "" && !dir.HasJSON {
+			names = append(names, dir.Name+"(no JSON)")
+		} else {
+			names = append(names, dir.Name)
+		}
+	}
+	want := "1.20.1 1.21 fabric-loader-0.15.11-1.20.1 fabric-loader-0.16.5-1.20.1 fabric-loader-0.16.9-1.21(no JSON) neoforge-20.4.237 neoforge-21.0.10 neoforge-21.1.77 quilt-loader-0.26.0-beta.1-1.20.1"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("scanned %s", got)
+	}
+}
+
+// TestScanVersionsLeftovers checks what bad unzips and aborted installs
+// leave behind: a file named like a loader folder and an empty one.
+func TestScanVersionsLeftovers(t *testing.T) {
+	versions := filepath.Join(t.TempDir(), "versions")
+	writeFiles(t, versions, map[string]string{"fabric-loader-0.15.11-1.20.1": "from a bad unzip"})
+	if err := os.Mkdir(filepath.Join(versions, "fabric-loader-0.16.5-1.20.1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer ForgetVersions(versions)
+	installed, err := LoaderInstalled(fabricLoader{}, versions, "1.20.1")
+	if err != nil || installed {
+		t.Errorf("installed: %t, %v", installed, err)
+	}
+}
+
+func TestScanVersionsCached(t *testing.T) {
+	versions := filepath.Join(t.TempDir(), "versions")
+	writeFiles(t, versions, map[string]string{"1.20.1/1.20.1.json": "{}"})
+	defer ForgetVersions(versions)
+	if dirs, err := ScanVersions(versions); err != nil || len(dirs) != 1 {
+		t.Fatalf("scanned %v, %v", dirs, err)
+	}
+	writeFiles(t, versions, map[string]string{"1.21/1.21.json": "{}"})
+	if dirs, _ := ScanVersions(versions); len(dirs) != 1 {
+		t.Errorf("scanned again: %v", dirs)
+	}
+	ForgetVersions(versions)
+	if dirs, _ := ScanVersions(versions); len(dirs) != 2 {
+		t.Errorf("scanned %v after forgetting", dirs)
+	}
+	if _, err := ScanVersions(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("scanned a missing directory")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// SameVolume reports whether a and b, or the closest existing directories
+// above them, are on the same volume, so files can be renamed from one to
+// the other. It is true when that can't be told.
+func SameVolume(a string, b string) bool {
+	same, err := sameDevice(existingAncestor(a), existingAncestor(b))
+	if err != nil {
+		Logf("volume: comparing %s and %s: %s", a, b, err)
+		return true
+	}
+	return same
+}
+
+// existingAncestor returns p, or the closest directory above it that
+// exists, as an absolute path.
+func existingAncestor(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		p = abs
+	}
+	for {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return p
+		}
+		p = parent
+	}
+}
+
+// BackupsRoot returns where the backups of modPath are kept: backupsPath,
+// unless that is on another volume, e.g. the updater on C: and the
+// instance on D:. Backups then go next to the minecraft directory, so
+// they are made by renaming instead of copying every file.
+func BackupsRoot(backupsPath string, modPath string) string {
+	if SameVolume(backupsPath, modPath) {
+		return backupsPath
+	}
+	root := filepath.Join(filepath.Dir(modPath), filepath.Base(backupsPath))
+	Logf("volume: %s is on another volume than %s, keeping backups in %s", backupsPath, modPath, root)
+	return root
+}
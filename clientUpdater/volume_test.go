@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// useVolumeBoundary has renames fail as between filesystems when they
+// move a file into or out of dir, as when dir is another volume.
+func useVolumeBoundary(t *testing.T, dir string) {
+	t.Helper()
+	saved := rename
+	t.Cleanup(func() {
+		rename = saved
+		copyFallback = sync.Once{}
+	})
+	copyFallback = sync.Once{}
+	inside := func(p string) bool {
+		rel, err := filepath.Rel(dir, p)
+		return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	}
+	rename = func(src string, dst string) error {
+		if inside(src) != inside(dst) {
+			return &os.LinkError{Op: "rename", Old: src, New: dst, Err: syscall.EXDEV}
+		}
+		return saved(src, dst)
+	}
+}
+
+// hashFiles returns the SHA-256 of every file below dir, by slash
+// separated path relative to it.
+func hashFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	hashes := map[string]string{}
+	for p := range snapshotFiles(t, dir) {
+		sum, err := fileSHA256(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel, _ := filepath.Rel(dir, p)
+		hashes[filepath.ToSlash(rel)] = sum
+	}
+	return hashes
+}
+
+func TestSameVolume(t *testing.T) {
+	dir := t.TempDir()
+	// paths not created yet are on the volume of the directory above them
+	if !SameVolume(dir, filepath.Join(dir, "missing", "mods")) {
+		t.Error("a missing directory is on another volume")
+	}
+	if got := existingAncestor(filepath.Join(dir, "a", "b")); got != dir {
+		t.Errorf("existing ancestor %s", got)
+	}
+	if runtime.GOOS == "linux" && SameVolume(dir, "/proc") {
+		t.Error("/proc is on the volume of the temporary directory")
+	}
+	if got := BackupsRoot(filepath.Join(dir, "clientUpdate-backups"), filepath.Join(dir, ".minecraft", "mods")); got != filepath.Join(dir, "clientUpdate-backups") {
+		t.Errorf("backups on the same volume in %s", got)
+	}
+	if runtime.GOOS == "linux" {
+		// /proc/self/mods doesn't exist, it is on /proc's volume
+		if got := BackupsRoot(filepath.Join(dir, "clientUpdate-backups"), "/proc/self/mods"); got != "/proc/self/clientUpdate-backups" {
+			t.Errorf("backups of another volume in %s", got)
+		}
+	}
+}
+
+func TestMoveFileAcrossVolumes(t *testing.T) {
+	dir := t.TempDir()
+	useVolumeBoundary(t, filepath.Join(dir, "d"))
+	writeFiles(t, dir, map[string]string{"c/sodium.jar": "sodium"})
+	var err error
+	output := captureStdout(t, func() {
+		err = moveFile(filepath.Join(dir, "c", "sodium.jar"), filepath.Join(dir, "d", "mods", "sodium.jar"))
+		if err == nil {
+			err = moveFile(filepath.Join(dir, "d", "mods", "sodium.jar"), filepath.Join(dir, "c", "sodium.jar"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(dir, "c", "sodium.jar")); got != "sodium" {
+		t.Errorf("moved back %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "d", "mods", "sodium.jar")); !os.IsNotExist(err) {
+		t.Errorf("the copy is left: %v", err)
+	}
+	// told once per run
+	if strings.Count(output, "is on another drive") != 1 {
+		t.Errorf("output %q", output)
+	}
+}
+
+// TestUpdateAcrossVolumes updates a mods directory every rename into or
+// out of fails: the moves fall back to copying, the files installed and
+// the ones backed up are intact, and rolling back brings the old ones back.
+func TestUpdateAcrossVolumes(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 2", "mods/iris.jar": "iris"})
+	writeFiles(t, u.mods, map[string]string{"sodium.jar": "sodium 1", "old.jar": "old", "config/old.txt": "kept"})
+	before := hashFiles(t, u.mods)
+	useVolumeBoundary(t, u.mods)
+
+	output := readFile(t, u.run(t))
+	if !strings.Contains(output, "is on another drive") {
+		t.Errorf("no copying told, output:\n%s", output)
+	}
+	want := map[string]string{"iris.jar": sha256Hex([]byte("iris")), "sodium.jar": sha256Hex([]byte("sodium 2"))}
+	if got := hashFiles(t, u.mods); mustJSON(t, got) != mustJSON(t, want) {
+		t.Errorf("installed %v, output:\n%s", got, output)
+	}
+	backups := filepath.Join(u.state, backupsDirName)
+	if got := dirNames(t, backups); got != "20240601-120100" {
+		t.Fatalf("backups %s", got)
+	}
+	got := hashFiles(t, filepath.Join(backups, "20240601-120100"))
+	delete(got, backupManifestName)
+	if mustJSON(t, got) != mustJSON(t, before) {
+		t.Errorf("backed up %v, want %v", got, before)
+	}
+	if _, err := os.Stat(u.mods + stagingSuffix); !os.IsNotExist(err) {
+		t.Errorf("the staging directory is left: %v", err)
+	}
+
+	output = readFile(t, u.run(t, "rollback"))
+	if got := hashFiles(t, u.mods); mustJSON(t, got) != mustJSON(t, before) {
+		t.Errorf("rolled back to %v, want %v, output:\n%s", got, before, output)
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// sameDevice compares the volumes of two existing absolute paths, drive
+// letters or UNC shares. Folders mounted onto another volume aren't
+// noticed, renames there still fall back to copying.
+func sameDevice(a string, b string) (bool, error) {
+	return strings.EqualFold(filepath.VolumeName(a), filepath.VolumeName(b)), nil
+}
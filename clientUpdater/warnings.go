@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// maxShownWarnings is how many warnings of one category are printed as
+// they happen. The rest only go to the log, so a problem repeated for
+// every jar of a pack doesn't bury everything else.
+const maxShownWarnings = 3
+
+// Warning categories, each with a "warning.<category>" message naming it
+// in the summary.
+const (
+	warnMetadata = "metadata"
+	warnExtract  = "extract"
+	warnLauncher = "launcher"
+	warnLock     = "lock"
+	warnExternal = "external"
+	warnLoader   = "loader"
+	warnState    = "state"
+	warnPack     = "pack"
+	warnShared   = "shared"
+)
+
+// WarningGroup is every warning of one category from this run, in the
+// order they happened. Identical warnings are kept once and counted in
+// Repeats.
+type WarningGroup struct {
+	Category string   `json:"category"`
+	Messages []string `json:"messages"`
+	Repeats  int      `json:"repeats,omitempty"`
+	// Shown counts the messages printed, the others were only logged.
+	Shown int `json:"shown"`
+}
+
+// warningCollector groups the warnings of a run. Targets update at the
+// same time, so it is locked.
+type warningCollector struct {
+	mu      sync.Mutex
+	groups  []*WarningGroup
+	seen    map[string]bool
+	verbose bool
+	// out is where warnings are printed, standard output when nil.
+	out io.Writer
+}
+
+var warnings = &warningCollector{seen: map[string]bool{}}
+
+// SetVerboseWarnings prints every warning instead of the first few of
+// each category.
+func SetVerboseWarnings(verbose bool) {
+	warnings.mu.Lock()
+	defer warnings.mu.Unlock()
+	warnings.verbose = verbose
+}
+
+// PrintWarningsTo prints the warnings to w instead of standard output until
+// the returned function is called.
+func PrintWarningsTo(w io.Writer) (restore func()) {
+	warnings.mu.Lock()
+	defer warnings.mu.Unlock()
+	saved := warnings.out
+	warnings.out = w
+	return func() {
+		warnings.mu.Lock()
+		defer warnings.mu.Unlock()
+		warnings.out = saved
+	}
+}
+
+// Warn logs a warning of the category and prints it, unless it was
+// printed before or maxShownWarnings of the category already were. The
+// first warning held back says so.
+func Warn(category string, message string) {
+	warnings.mu.Lock()
+	defer warnings.mu.Unlock()
+	Logf("warning %s: %s", category, message)
+
+	var group *WarningGroup
+	for _, g := range warnings.groups {
+		if g.Category == category {
+			group = g
+			break
+		}
+	}
+	if group == nil {
+		group = &WarningGroup{Category: category}
+		warnings.groups = append(warnings.groups, group)
+	}
+	key := category + "\x00" + message
+	if warnings.seen[key] {
+		group.Repeats++
+		return
+	}
+	warnings.seen[key] = true
+	group.Messages = append(group.Messages, message)
+	out := warnings.out
+	if out == nil {
+		out = os.Stdout
+	}
+	switch {
+	case warnings.verbose || group.Shown < maxShownWarnings:
+		group.Shown++
+		fmt.Fprintln(out, message)
+	case len(group.Messages) == maxShownWarnings+1:
+		fmt.Fprintln(out, T("warnings.held", T("warning."+category)))
+	}
+}
+
+// WarningGroups returns copies of the warnings of this run by category,
+// in the order the categories first came up.
+func WarningGroups() []WarningGroup {
+	warnings.mu.Lock()
+	defer warnings.mu.Unlock()
+	groups := make([]WarningGroup, len(warnings.groups))
+	for i, g := range warnings.groups {
+		groups[i] = *g
+		groups[i].Messages = append([]string(nil), g.Messages...)
+	}
+	return groups
+}
+
+// PrintWarningSummary counts the warnings of each category, pointing to
+// logPath for those that weren't printed.
+func PrintWarningSummary(logPath string) {
+	for _, group := range WarningGroups() {
+		line := T("warnings.summary", T("warning."+group.Category), len(group.Messages)+group.Repeats)
+		if hidden := len(group.Messages) - group.Shown; hidden > 0 {
+			line += " " + T("warnings.more", hidden, logPath)
+		}
+		fmt.Println("  " + line)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useWarnings gives the test a warning collector of its own.
+func useWarnings(t *testing.T) {
+	t.Helper()
+	saved := warnings
+	warnings = &warningCollector{seen: map[string]bool{}}
+	t.Cleanup(func() { warnings = saved })
+}
+
+func TestWarnGroups(t *testing.T) {
+	useWarnings(t)
+	log := captureRunLog(t)
+	var printed bytes.Buffer
+	defer PrintWarningsTo(&printed)()
+
+	for i := 1; i <= 5; i++ {
+		Warn(warnMetadata, fmt.Sprintf("mod-%d.jar doesn't look like a fabric mod", i))
+		// the same warning again and again is printed once
+		Warn(warnLauncher, "the launcher is running")
+	}
+	Warn(warnLock, "the mods directory couldn't be locked")
+	want := strings.Join([]string{
+		"mod-1.jar doesn't look like a fabric mod",
+		"the launcher is running",
+		"mod-2.jar doesn't look like a fabric mod",
+		"mod-3.jar doesn't look like a fabric mod",
+		"  ... further Mod metadata warnings only go to the log",
+		"the mods directory couldn't be locked",
+		"",
+	}, "\n")
+	if printed.String() != want {
+		t.Errorf("printed:\n%s", printed.String())
+	}
+	groups := mustJSON(t, WarningGroups())
+	wantGroups := mustJSON(t, []WarningGroup{
+		{Category: warnMetadata, Messages: []string{
+			"mod-1.jar doesn't look like a fabric mod",
+			"mod-2.jar doesn't look like a fabric mod",
+			"mod-3.jar doesn't look like a fabric mod",
+			"mod-4.jar doesn't look like a fabric mod",
+			"mod-5.jar doesn't look like a fabric mod",
+		}, Shown: 3},
+		{Category: warnLauncher, Messages: []string{"the launcher is running"}, Repeats: 4, Shown: 1},
+		{Category: warnLock, Messages: []string{"the mods directory couldn't be locked"}, Shown: 1},
+	})
+	if groups != wantGroups {
+		t.Errorf("groups %s", groups)
+	}
+	// every warning is logged, repeats included
+	if got := strings.Count(log.String(), "warning "); got != 11 {
+		t.Errorf("%d warnings logged:\n%s", got, log)
+	}
+
+	summary := captureStdout(t, func() { PrintWarningSummary(filepath.Join("state", "clientUpdate.log")) })
+	wantSummary := "  Mod metadata: 5 warnings (2 not shown, see " + filepath.Join("state", "clientUpdate.log") + ")\n" +
+		"  Launcher: 5 warnings\n" +
+		"  Mods directory lock: 1 warnings\n"
+	if summary != wantSummary {
+		t.Errorf("summary:\n%s", summary)
+	}
+}
+
+func TestWarnVerbose(t *testing.T) {
+	useWarnings(t)
+	SetVerboseWarnings(true)
+	defer SetVerboseWarnings(false)
+	var printed bytes.Buffer
+	defer PrintWarningsTo(&printed)()
+	for i := 1; i <= 5; i++ {
+		Warn(warnMetadata, fmt.Sprintf("mod-%d.jar doesn't look like a fabric mod", i))
+		Warn(warnMetadata, "mod-1.jar doesn't look like a fabric mod")
+	}
+	if got := strings.Count(printed.String(), "\n"); got != 5 || strings.Contains(printed.String(), "only go to the log") {
+		t.Errorf("printed:\n%s", printed.String())
+	}
+	summary := captureStdout(t, func() { PrintWarningSummary("clientUpdate.log") })
+	if summary != "  Mod metadata: 10 warnings\n" {
+		t.Errorf("summary %q", summary)
+	}
+}
+
+// TestUpdateGroupsWarnings updates from a pack whose jars all lack the
+// loader's metadata: the warnings are held back after the first few,
+// unless --verbose asks for all of them.
+func TestUpdateGroupsWarnings(t *testing.T) {
+	pack := map[string]string{}
+	for i := 1; i <= 10; i++ {
+		pack[fmt.Sprintf("mods/mod-%02d.jar", i)] = "not a mod"
+	}
+	for _, verbose := range []bool{false, true} {
+		t.Run(fmt.Sprintf("verbose=%t", verbose), func(t *testing.T) {
+			useWarnings(t)
+			u := newFakeUpdate(t, pack)
+			var args []string
+			if verbose {
+				args = append(args, "--verbose")
+				defer SetVerboseWarnings(false)
+			}
+			output := readFile(t, u.run(t, args...))
+			shown, held := strings.Count(output, "doesn't look like a fabric mod"), strings.Contains(output, "further Mod metadata warnings only go to the log")
+			if verbose && (shown != 10 || held) || !verbose && (shown != 3 || !held) {
+				t.Errorf("%d warnings shown, output:\n%s", shown, output)
+			}
+			summary := "Mod metadata: 10 warnings"
+			if !verbose {
+				summary += " (7 not shown, see "
+			}
+			if !strings.Contains(output, summary) {
+				t.Errorf("no %q in the output:\n%s", summary, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// lowWriteBackups names the only backup directory kept in low-write mode,
+// replaced by every update. The one it replaces is kept until the new one
+// is verified.
+const lowWriteBackups = "low-write"
+
+// bytesWritten counts the bytes the updater wrote to disk during this
+// run, apart from its log.
+var bytesWritten int64
+
+// diskWrite writes p to w, a file on disk, replaced by tests simulating
+// a disk filling up.
+var diskWrite = func(w io.Writer, p []byte) (int, error) { return w.Write(p) }
+
+// countingWriter counts everything written through it in bytesWritten.
+type countingWriter struct {
+	w io.Writer
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := diskWrite(c.w, p)
+	atomic.AddInt64(&bytesWritten, int64(n))
+	return n, err
+}
+
+// BytesWritten returns how much the updater wrote to disk so far.
+func BytesWritten() int64 {
+	return atomic.LoadInt64(&bytesWritten)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// snapshotFiles maps every file below dir to its modification time.
+func snapshotFiles(t *testing.T, dir string) map[string]time.Time {
+	t.Helper()
+	files := map[string]time.Time{}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		files[p] = info.ModTime()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// changedFiles lists the files below dir created, changed or removed since
+// the snapshot, relative to dir.
+func changedFiles(t *testing.T, dir string, before map[string]time.Time) string {
+	t.Helper()
+	after := snapshotFiles(t, dir)
+	var changed []string
+	for p, modTime := range after {
+		if was, ok := before[p]; !ok || !was.Equal(modTime) {
+			rel, _ := filepath.Rel(dir, p)
+			changed = append(changed, filepath.ToSlash(rel))
+		}
+	}
+	for p := range before {
+		if _, ok := after[p]; !ok {
+			rel, _ := filepath.Rel(dir, p)
+			changed = append(changed, "-"+filepath.ToSlash(rel))
+		}
+	}
+	sort.Strings(changed)
+	return strings.Join(changed, " ")
+}
+
+func TestCountingWriter(t *testing.T) {
+	before := BytesWritten()
+	f, err := os.Create(filepath.Join(t.TempDir(), "counted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := countingWriter{f}
+	w.Write([]byte("sodium"))
+	w.Write([]byte("iris"))
+	if got := BytesWritten() - before; got != 10 {
+		t.Errorf("counted %d bytes", got)
+	}
+}
+
+// TestLowWriteUpToDate runs an update in low-write mode when nothing
+// changed: it leaves nothing written but its log. The pack downloaded to
+// compare, the fetch state and the update lock are removed again.
+func TestLowWriteUpToDate(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{
+		"mods/sodium.jar": "sodium",
+		"mods/iris.jar":   "iris",
+	})
+	u.run(t, "--low-write")
+	// the files written are all in the previous second now
+	time.Sleep(10 * time.Millisecond)
+	root := filepath.Dir(u.minecraft)
+	before := snapshotFiles(t, root)
+
+	output := readFile(t, u.run(t, "--low-write"))
+	if !strings.Contains(output, "Config-only update applied, 0 files changed") {
+		t.Errorf("output:\n%s", output)
+	}
+	if got := changedFiles(t, root, before); got != "state/clientUpdate.log" {
+		t.Errorf("changed %s", got)
+	}
+}
+
+// TestLowWriteKeepsOneBackup checks updates in low-write mode replace the
+// one backup, moving the files replaced instead of copying them.
+func TestLowWriteKeepsOneBackup(t *testing.T) {
+	u := newFakeUpdate(t, map[string]string{"mods/sodium.jar": "sodium 1", "mods/iris.jar": "iris"})
+	u.run(t, "--low-write")
+	u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 2", "mods/iris.jar": "iris"})
+	written := BytesWritten()
+	u.run(t, "--low-write")
+	// the pack, the new sodium.jar and the state files, not the unchanged
+	// iris.jar nor a copy of the old sodium.jar
+	if got := BytesWritten() - written; got < int64(len("sodium 2")) || got > 4096 {
+		t.Errorf("wrote %d bytes", got)
+	}
+	u.setPack(t, map[string]string{"mods/sodium.jar": "sodium 3", "mods/iris.jar": "iris"})
+	u.run(t, "--low-write")
+
+	backups := BackupsRoot(filepath.Join(u.state, backupsDirName), u.mods)
+	if got := dirNames(t, backups); got != lowWriteBackups {
+		t.Fatalf("backups %s", got)
+	}
+	if got := readFile(t, filepath.Join(backups, lowWriteBackups, "sodium.jar")); got != "sodium 2" {
+		t.Errorf("backed up sodium.jar %q", got)
+	}
+}
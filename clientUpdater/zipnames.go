@@ -0,0 +1,159 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// codePages maps the legacy encodings zip entry names may use to the
+// characters of their bytes 0x80-0xFF. Bytes below 0x80 are ASCII in all of
+// them.
+var codePages = map[string][]rune{
+	"cp437":        []rune("ÇüéâäàåçêëèïîìÄÅÉæÆôöòûùÿÖÜ¢£¥₧ƒáíóúñÑªº¿⌐¬½¼¡«»░▒▓│┤╡╢╖╕╣║╗╝╜╛┐└┴┬├─┼╞╟╚╔╩╦╠═╬╧╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀αßΓπΣσµτΦΘΩδ∞φε∩≡±≥≤⌠⌡÷≈°∙·√ⁿ²■" + "\u00a0"),
+	"windows-1252": windows1252(),
+}
+
+// windows1252 builds the Windows-1252 table: 0x80-0x9F hold punctuation and
+// a few letters (the five unassigned bytes stay C1 controls), the rest is
+// Latin-1.
+func windows1252() []rune {
+	table := make([]rune, 128)
+	high := []rune("€‚ƒ„…†‡ˆ‰Š‹ŒŽ‘’“”•–—˜™š›œžŸ")
+	for i := range table {
+		b := rune(0x80 + i)
+		switch {
+		case b >= 0xA0:
+			table[i] = b
+		case b == 0x81 || b == 0x8D || b == 0x8F || b == 0x90 || b == 0x9D:
+			table[i] = b
+		default:
+			table[i], high = high[0], high[1:]
+		}
+	}
+	return table
+}
+
+// zipNameEncoding is the encoding assumed for entry names of archives that
+// don't flag them as UTF-8.
+var zipNameEncoding = "cp437"
+
+// SetZipNameEncoding changes the encoding assumed for entry names not
+// flagged as UTF-8. An empty name keeps the default, CP437, which is what
+// zip tools on Windows use unless told otherwise.
+func SetZipNameEncoding(name string) error {
+	if name == "" {
+		return nil
+	}
+	name = strings.ToLower(name)
+	if _, ok := codePages[name]; !ok {
+		return fmt.Errorf("unsupported zip name encoding %q (supported: cp437, windows-1252)", name)
+	}
+	zipNameEncoding = name
+	return nil
+}
+
+// decodeName transcodes a legacy encoded name to UTF-8.
+func decodeName(name string, table []rune) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c < 0x80 {
+			b.WriteByte(c)
+		} else {
+			b.WriteRune(table[c-0x80])
+		}
+	}
+	return b.String()
+}
+
+// NormalizeEntryNames rewrites the names of every entry of an archive so
+// the rest of the updater only ever sees UTF-8 names with forward slashes:
+// names not flagged as UTF-8 are transcoded from zipNameEncoding, and
+// backslash separators written by some Windows tools are replaced. Names
+// containing control characters are refused.
+func NormalizeEntryNames(r *zip.Reader) error {
+	table := codePages[zipNameEncoding]
+	for _, f := range r.File {
+		name := f.Name
+		if f.NonUTF8 || !utf8.ValidString(name) {
+			name = decodeName(name, table)
+		}
+		name = strings.Replace(name, "\\", "/", -1)
+		for _, c := range name {
+			if unicode.IsControl(c) {
+				return fmt.Errorf("archive entry %q contains control characters", name)
+			}
+		}
+		f.Name = name
+	}
+	return nil
+}
+
+// OpenPackArchive opens a pack archive with normalized entry names.
+func OpenPackArchive(src string) (*zip.ReadCloser, error) {
+	if err := checkEntryCount(src); err != nil {
+		return nil, err
+	}
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := NormalizeEntryNames(&r.Reader); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// windowsReservedNames are device names Windows opens instead of a file,
+// whatever extension follows them.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeEntryPath turns an archive entry name into a path relative to
+// the directory it is extracted to. Absolute paths, drive letters, UNC
+// prefixes and ".." are refused everywhere; on Windows also colons,
+// reserved device names and components ending in a dot or space, which
+// Windows silently changes. Every extraction destination goes through it.
+func sanitizeEntryPath(name string) (string, error) {
+	slashed := strings.Replace(name, "\\", "/", -1)
+	switch {
+	case strings.HasPrefix(slashed, "/"):
+		// also catches UNC prefixes, //server/share
+		return "", fmt.Errorf("archive entry %q is an absolute path", name)
+	case len(slashed) >= 2 && slashed[1] == ':' && unicode.IsLetter(rune(slashed[0])):
+		return "", fmt.Errorf("archive entry %q starts with a drive letter", name)
+	}
+
+	var parts []string
+	for _, part := range strings.Split(slashed, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			return "", fmt.Errorf("archive entry %q leaves the extraction directory", name)
+		}
+		if isWindows() {
+			base := strings.ToUpper(strings.SplitN(part, ".", 2)[0])
+			switch {
+			case strings.Contains(part, ":"):
+				return "", fmt.Errorf("archive entry %q contains a colon", name)
+			case windowsReservedNames[strings.TrimRight(base, " ")]:
+				return "", fmt.Errorf("archive entry %q uses the reserved name %s", name, base)
+			case strings.HasSuffix(part, ".") || strings.HasSuffix(part, " "):
+				return "", fmt.Errorf("archive entry %q ends in a dot or space", name)
+			}
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return "", fmt.Errorf("archive entry %q is empty", name)
+	}
+	return filepath.Join(parts...), nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// zipFixture is an archive of testdata/zips, built by third-party tools:
+//
+//	backslashes.zip     names separated by backslashes
+//	backslash-slip.zip  a backslash separated name leaving the directory
+//	cp437.zip           Tüxedo.jar and Ñandú.jar in CP437, without the
+//	                    UTF-8 flag, and 日本語.jar flagged as UTF-8
+//	control.zip         a name containing a BEL character
+func zipFixture(name string) string {
+	return filepath.Join("testdata", "zips", name)
+}
+
+// useZipNameEncoding sets the encoding of names not flagged as UTF-8 for
+// the test.
+func useZipNameEncoding(t *testing.T, name string) {
+	t.Helper()
+	saved := zipNameEncoding
+	t.Cleanup(func() { zipNameEncoding = saved })
+	if err := SetZipNameEncoding(name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenPackArchiveNormalizesNames(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		encoding string
+		names    string
+		err      string
+	}{
+		{
+			fixture: "backslashes.zip",
+			names:   "rxmc-Mods-master/mods/sodium.jar rxmc-Mods-master/mods/performance/lithium.jar rxmc-Mods-master/README.md",
+		},
+		{
+			fixture: "cp437.zip",
+			names:   "rxmc-Mods-master/mods/Tüxedo.jar rxmc-Mods-master/mods/Ñandú.jar rxmc-Mods-master/mods/日本語.jar",
+		},
+		{
+			// 0x81 is unassigned in Windows-1252, a C1 control
+			fixture:  "cp437.zip",
+			encoding: "Windows-1252",
+			err:      "contains control characters",
+		},
+		{
+			fixture: "control.zip",
+			err:     `archive entry "rxmc-Mods-master/mods/bell\a.jar" contains control characters`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.fixture+" "+test.encoding, func(t *testing.T) {
+			useZipNameEncoding(t, test.encoding)
+			r, err := OpenPackArchive(zipFixture(test.fixture))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("error %v, want %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			var names []string
+			for _, f := range r.File {
+				names = append(names, f.Name)
+			}
+			if got := strings.Join(names, " "); got != test.names {
+				t.Errorf("names %s, want %s", got, test.names)
+			}
+		})
+	}
+}
+
+func TestDecodeName(t *testing.T) {
+	if got := decodeName("caf\x82 \xe1", codePages["cp437"]); got != "café ß" {
+		t.Errorf("CP437: %s", got)
+	}
+	if got := decodeName("caf\xe9 \x80 \x9c", codePages["windows-1252"]); got != "café € œ" {
+		t.Errorf("Windows-1252: %s", got)
+	}
+	for name, table := range codePages {
+		if len(table) != 128 {
+			t.Errorf("%s maps %d bytes", name, len(table))
+		}
+	}
+}
+
+func TestSetZipNameEncoding(t *testing.T) {
+	useZipNameEncoding(t, "")
+	if zipNameEncoding != "cp437" {
+		t.Errorf("default %s", zipNameEncoding)
+	}
+	if err := SetZipNameEncoding("GBK"); err == nil || zipNameEncoding != "cp437" {
+		t.Errorf("GBK: %v, now %s", err, zipNameEncoding)
+	}
+	if err := SetZipNameEncoding("WINDOWS-1252"); err != nil || zipNameEncoding != "windows-1252" {
+		t.Errorf("WINDOWS-1252: %v, now %s", err, zipNameEncoding)
+	}
+}
+
+// TestUnzipNormalizedNames extracts the fixtures' mods: they land under
+// their decoded names, names leaving the mods directory are refused.
+func TestUnzipNormalizedNames(t *testing.T) {
+	tests := map[string]string{
+		"backslashes.zip": "lithium.jar sodium.jar",
+		"cp437.zip":       "Tüxedo.jar Ñandú.jar 日本語.jar",
+	}
+	for fixture, want := range tests {
+		mods := t.TempDir()
+		if _, err := Unzip(zipFixture(fixture), mods, "mods"); err != nil {
+			t.Fatalf("%s: %v", fixture, err)
+		}
+		if got := dirNames(t, mods); got != want {
+			t.Errorf("%s: extracted %s, want %s", fixture, got, want)
+		}
+	}
+
+	dir := t.TempDir()
+	mods := filepath.Join(dir, "a", "b", "mods")
+	if _, err := Unzip(zipFixture("backslash-slip.zip"), mods, "mods"); err == nil || !strings.Contains(err.Error(), "leaves the extraction directory") {
+		t.Errorf("extracted an entry leaving the mods directory: %v", err)
+	}
+	if got := dirNames(t, dir); got != "" {
+		t.Errorf("extracted %s", got)
+	}
+}
+
+func TestSanitizeEntryPath(t *testing.T) {
+	tests := []struct {
+		name string
+		// clean is the path returned, with slashes, none when the name is
+		// refused everywhere.
+		clean string
+		// windowsRefuses is set for names only Windows can't take.
+		windowsRefuses bool
+	}{
+		{name: "mods/sodium.jar", clean: "mods/sodium.jar"},
+		{name: "sodium.jar", clean: "sodium.jar"},
+		{name: `config\sodium\options.json`, clean: "config/sodium/options.json"},
+		{name: "./config//sodium/./options.json", clean: "config/sodium/options.json"},
+		{name: "config/", clean: "config"},
+		{name: "..mods/x.jar", clean: "..mods/x.jar"},
+		{name: "mods/x..jar", clean: "mods/x..jar"},
+		{name: "CONFIG/console.txt", clean: "CONFIG/console.txt"},
+		{name: "mods/COM10.jar", clean: "mods/COM10.jar"},
+		{name: "resourcepacks/faithful 32x.zip", clean: "resourcepacks/faithful 32x.zip"},
+
+		// leaving the extraction directory
+		{name: "../evil"},
+		{name: "mods/../../evil"},
+		{name: `mods\..\..\evil`},
+		{name: "mods/.."},
+		// absolute paths
+		{name: "/etc/cron.d/x"},
+		{name: `\Windows\System32\x.dll`},
+		{name: `\\server\share\x`},
+		{name: "//server/share/x"},
+		{name: `\\?\C:\evil`},
+		// drive letters
+		{name: `C:\evil`},
+		{name: "c:/evil"},
+		{name: "C:evil"},
+		{name: "z:"},
+		// nothing left
+		{name: ""},
+		{name: "."},
+		{name: "./"},
+		{name: "/"},
+
+		// what Windows opens as a device or silently renames
+		{name: "mods/CON", clean: "mods/CON", windowsRefuses: true},
+		{name: "nul.txt", clean: "nul.txt", windowsRefuses: true},
+		{name: "config/com1.json", clean: "config/com1.json", windowsRefuses: true},
+		{name: "LPT9", clean: "LPT9", windowsRefuses: true},
+		{name: "aux .txt", clean: "aux .txt", windowsRefuses: true},
+		{name: "Prn.tar.gz", clean: "Prn.tar.gz", windowsRefuses: true},
+		{name: "mods/sodium.jar.", clean: "mods/sodium.jar.", windowsRefuses: true},
+		{name: "mods /sodium.jar", clean: "mods /sodium.jar", windowsRefuses: true},
+		{name: "config/x.json ", clean: "config/x.json ", windowsRefuses: true},
+		{name: "mods/sodium.jar:Zone.Identifier", clean: "mods/sodium.jar:Zone.Identifier", windowsRefuses: true},
+	}
+	for _, test := range tests {
+		got, err := sanitizeEntryPath(test.name)
+		refused := test.clean == "" || test.windowsRefuses && isWindows()
+		switch {
+		case refused && err == nil:
+			t.Errorf("%q accepted as %q", test.name, got)
+		case !refused && err != nil:
+			t.Errorf("%q: %v", test.name, err)
+		case !refused && got != filepath.FromSlash(test.clean):
+			t.Errorf("%q cleaned to %q, want %q", test.name, got, test.clean)
+		case refused && (got != "" || !strings.Contains(err.Error(), strconv.Quote(test.name))):
+			t.Errorf("%q: %q, %v", test.name, got, err)
+		}
+	}
+}